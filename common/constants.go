@@ -73,6 +73,9 @@ var MemoryCacheEnabled bool
 
 var LogConsumeEnabled = true
 
+// LogSqlRetentionDays SQL 日志表保留天数，0 表示不清理
+var LogSqlRetentionDays = 0
+
 var SMTPServer = ""
 var SMTPPort = 587
 var SMTPSSLEnabled = false
@@ -173,6 +176,12 @@ var (
 	DownloadRateLimitDuration int64 = 60
 )
 
+// /metrics 访问控制，均未配置时不开放
+var (
+	MetricsToken      string
+	MetricsAllowedIps []string
+)
+
 var RateLimitKeyExpirationDuration = 20 * time.Minute
 
 const (
@@ -200,6 +209,11 @@ const (
 	ChannelStatusAutoDisabled     = 3
 )
 
+const (
+	OrganizationStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	OrganizationStatusDisabled = 2 // also don't use 0
+)
+
 const (
 	TopUpStatusPending = "pending"
 	TopUpStatusSuccess = "success"
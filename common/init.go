@@ -14,17 +14,18 @@ import (
 )
 
 var (
-	Port         = flag.Int("port", 3000, "the listening port")
-	PrintVersion = flag.Bool("version", false, "print version and exit")
-	PrintHelp    = flag.Bool("help", false, "print help and exit")
-	LogDir       = flag.String("log-dir", "./logs", "specify the log directory")
+	Port           = flag.Int("port", 3000, "the listening port")
+	PrintVersion   = flag.Bool("version", false, "print version and exit")
+	PrintHelp      = flag.Bool("help", false, "print help and exit")
+	LogDir         = flag.String("log-dir", "./logs", "specify the log directory")
+	MigrateSecrets = flag.Bool("migrate-secrets", false, "encrypt stored secrets with the current SECRET_ENCRYPTION_KEY and exit")
 )
 
 func printHelp() {
 	fmt.Println("NewAPI(Based OneAPI) " + Version + " - The next-generation LLM gateway and AI asset management system supports multiple languages.")
 	fmt.Println("Original Project: OneAPI by JustSong - https://github.com/songquanpeng/knight-omega")
 	fmt.Println("Maintainer: QuantumNous - https://github.com/QuantumNous/new-api")
-	fmt.Println("Usage: newapi [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: newapi [--port <port>] [--log-dir <log directory>] [--migrate-secrets] [--version] [--help]")
 }
 
 func InitEnv() {
@@ -60,6 +61,9 @@ func InitEnv() {
 	} else {
 		CryptoSecret = SessionSecret
 	}
+	if err := InitSecretEncryption(); err != nil {
+		log.Fatal(err)
+	}
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -92,6 +96,7 @@ func InitEnv() {
 	RelayTimeout = GetEnvOrDefault("RELAY_TIMEOUT", 0)
 	RelayMaxIdleConns = GetEnvOrDefault("RELAY_MAX_IDLE_CONNS", 500)
 	RelayMaxIdleConnsPerHost = GetEnvOrDefault("RELAY_MAX_IDLE_CONNS_PER_HOST", 100)
+	LogSqlRetentionDays = GetEnvOrDefault("LOG_SQL_RETENTION_DAYS", 0)
 
 	// Initialize string variables with GetEnvOrDefaultString
 	GeminiSafetySetting = GetEnvOrDefaultString("GEMINI_SAFETY_SETTING", "BLOCK_NONE")
@@ -109,6 +114,14 @@ func InitEnv() {
 	CriticalRateLimitEnable = GetEnvOrDefaultBool("CRITICAL_RATE_LIMIT_ENABLE", true)
 	CriticalRateLimitNum = GetEnvOrDefault("CRITICAL_RATE_LIMIT", 20)
 	CriticalRateLimitDuration = int64(GetEnvOrDefault("CRITICAL_RATE_LIMIT_DURATION", 20*60))
+
+	// Initialize metrics access control
+	MetricsToken = GetEnvOrDefaultString("METRICS_TOKEN", "")
+	for _, ip := range strings.Split(GetEnvOrDefaultString("METRICS_ALLOWED_IPS", ""), ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			MetricsAllowedIps = append(MetricsAllowedIps, ip)
+		}
+	}
 	initConstantEnv()
 }
 
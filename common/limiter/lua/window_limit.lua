@@ -0,0 +1,21 @@
+-- 固定窗口计数器
+-- KEYS[1]: 计数器唯一标识（包含窗口序号）
+-- ARGV[1]: 窗口内允许的最大数量
+-- ARGV[2]: 本次消耗数量
+-- ARGV[3]: 过期时间（秒）
+
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local amount = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+
+local current = tonumber(redis.call('GET', key) or '0')
+if current + amount > limit then
+    return {0, current}
+end
+
+current = redis.call('INCRBY', key, amount)
+if redis.call('TTL', key) < 0 then
+    redis.call('EXPIRE', key, ttl)
+end
+return {1, current}
@@ -0,0 +1,186 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/go-redis/redis/v8"
+)
+
+//go:embed lua/window_limit.lua
+var windowLimitScript string
+
+//go:embed lua/concurrency_limit.lua
+var concurrencyLimitScript string
+
+var (
+	windowScript      = redis.NewScript(windowLimitScript)
+	concurrencyScript = redis.NewScript(concurrencyLimitScript)
+)
+
+// WindowResult 固定窗口计数结果
+type WindowResult struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	ResetAfter time.Duration
+}
+
+type memoryCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// memoryCounters Redis 未启用时的内存计数器
+type memoryCounters struct {
+	mutex    sync.Mutex
+	counters map[string]*memoryCounter
+	once     sync.Once
+}
+
+var localCounters = &memoryCounters{counters: make(map[string]*memoryCounter)}
+
+func (m *memoryCounters) startCleaner() {
+	m.once.Do(func() {
+		go func() {
+			for {
+				time.Sleep(time.Minute)
+				now := time.Now()
+				m.mutex.Lock()
+				for key, counter := range m.counters {
+					if now.After(counter.expiresAt) {
+						delete(m.counters, key)
+					}
+				}
+				m.mutex.Unlock()
+			}
+		}()
+	})
+}
+
+// get 获取未过期的计数器，调用方需持有锁
+func (m *memoryCounters) get(key string, ttl time.Duration) *memoryCounter {
+	counter, ok := m.counters[key]
+	if !ok || time.Now().After(counter.expiresAt) {
+		counter = &memoryCounter{expiresAt: time.Now().Add(ttl)}
+		m.counters[key] = counter
+	}
+	return counter
+}
+
+func windowKey(key string, window time.Duration) (string, time.Duration) {
+	seconds := int64(window.Seconds())
+	if seconds <= 0 {
+		seconds = 60
+	}
+	now := time.Now().Unix()
+	index := now / seconds
+	resetAfter := time.Duration((index+1)*seconds-now) * time.Second
+	return fmt.Sprintf("%s:%d", key, index), resetAfter
+}
+
+// ConsumeWindow 在固定窗口内消耗 amount，超出 limit 时拒绝且不计数
+func ConsumeWindow(ctx context.Context, key string, limit int64, amount int64, window time.Duration) (*WindowResult, error) {
+	fullKey, resetAfter := windowKey(key, window)
+	result := &WindowResult{Limit: limit, ResetAfter: resetAfter}
+	var current int64
+	if common.RedisEnabled {
+		values, err := windowScript.Run(ctx, common.RDB, []string{fullKey}, limit, amount, int64(window.Seconds())+1).Int64Slice()
+		if err != nil {
+			return nil, fmt.Errorf("window limit failed: %w", err)
+		}
+		result.Allowed = values[0] == 1
+		current = values[1]
+	} else {
+		localCounters.startCleaner()
+		localCounters.mutex.Lock()
+		counter := localCounters.get(fullKey, resetAfter)
+		if counter.value+amount <= limit {
+			counter.value += amount
+			result.Allowed = true
+		}
+		current = counter.value
+		localCounters.mutex.Unlock()
+	}
+	result.Remaining = limit - current
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	return result, nil
+}
+
+// AdjustWindow 修正当前窗口的计数，用于按实际用量校正预估值，计数不会小于 0
+func AdjustWindow(ctx context.Context, key string, delta int64, window time.Duration) error {
+	if delta == 0 {
+		return nil
+	}
+	fullKey, resetAfter := windowKey(key, window)
+	if common.RedisEnabled {
+		value, err := common.RDB.IncrBy(ctx, fullKey, delta).Result()
+		if err != nil {
+			return err
+		}
+		if value < 0 {
+			common.RDB.Set(ctx, fullKey, 0, resetAfter+time.Second)
+		} else {
+			common.RDB.Expire(ctx, fullKey, resetAfter+time.Second)
+		}
+		return nil
+	}
+	localCounters.mutex.Lock()
+	defer localCounters.mutex.Unlock()
+	counter := localCounters.get(fullKey, resetAfter)
+	counter.value += delta
+	if counter.value < 0 {
+		counter.value = 0
+	}
+	return nil
+}
+
+// AcquireConcurrency 占用一个并发名额，返回是否成功及占用后的并发数
+func AcquireConcurrency(ctx context.Context, key string, limit int64, ttl time.Duration) (bool, int64, error) {
+	if common.RedisEnabled {
+		values, err := concurrencyScript.Run(ctx, common.RDB, []string{key}, limit, int64(ttl.Seconds())).Int64Slice()
+		if err != nil {
+			return false, 0, fmt.Errorf("concurrency limit failed: %w", err)
+		}
+		return values[0] == 1, values[1], nil
+	}
+	localCounters.startCleaner()
+	localCounters.mutex.Lock()
+	defer localCounters.mutex.Unlock()
+	counter := localCounters.get(key, ttl)
+	if counter.value >= limit {
+		return false, counter.value, nil
+	}
+	counter.value++
+	counter.expiresAt = time.Now().Add(ttl)
+	return true, counter.value, nil
+}
+
+// ReleaseConcurrency 释放一个并发名额
+func ReleaseConcurrency(ctx context.Context, key string) error {
+	if common.RedisEnabled {
+		value, err := common.RDB.Decr(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if value <= 0 {
+			common.RDB.Del(ctx, key)
+		}
+		return nil
+	}
+	localCounters.mutex.Lock()
+	defer localCounters.mutex.Unlock()
+	if counter, ok := localCounters.counters[key]; ok {
+		counter.value--
+		if counter.value <= 0 {
+			delete(localCounters.counters, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package limiter
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/stretchr/testify/require"
+)
+
+// 使用较长的窗口，避免测试跨越窗口边界
+const testWindow = time.Hour
+
+func TestMain(m *testing.M) {
+	// 使用内存计数器
+	common.RedisEnabled = false
+	os.Exit(m.Run())
+}
+
+func TestConsumeWindow(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int64
+		amounts       []int64
+		wantAllowed   []bool
+		wantRemaining int64
+	}{
+		{
+			name:          "within limit",
+			limit:         10,
+			amounts:       []int64{3, 4},
+			wantAllowed:   []bool{true, true},
+			wantRemaining: 3,
+		},
+		{
+			name:          "exactly at limit",
+			limit:         5,
+			amounts:       []int64{5},
+			wantAllowed:   []bool{true},
+			wantRemaining: 0,
+		},
+		{
+			name:          "rejected request is not counted",
+			limit:         10,
+			amounts:       []int64{8, 5, 2},
+			wantAllowed:   []bool{true, false, true},
+			wantRemaining: 0,
+		},
+		{
+			name:          "single request over limit",
+			limit:         3,
+			amounts:       []int64{4, 1},
+			wantAllowed:   []bool{false, true},
+			wantRemaining: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "test:consume:" + tt.name
+			var result *WindowResult
+			for i, amount := range tt.amounts {
+				var err error
+				result, err = ConsumeWindow(context.Background(), key, tt.limit, amount, testWindow)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantAllowed[i], result.Allowed, "request %d", i)
+				require.Equal(t, tt.limit, result.Limit)
+			}
+			require.Equal(t, tt.wantRemaining, result.Remaining)
+		})
+	}
+}
+
+func TestAdjustWindow(t *testing.T) {
+	tests := []struct {
+		name          string
+		consumed      int64
+		delta         int64
+		wantRemaining int64
+	}{
+		{name: "actual usage above estimate", consumed: 10, delta: 5, wantRemaining: 85},
+		{name: "actual usage below estimate", consumed: 10, delta: -4, wantRemaining: 94},
+		{name: "refund never goes below zero", consumed: 10, delta: -50, wantRemaining: 100},
+		{name: "zero delta", consumed: 10, delta: 0, wantRemaining: 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "test:adjust:" + tt.name
+			_, err := ConsumeWindow(context.Background(), key, 100, tt.consumed, testWindow)
+			require.NoError(t, err)
+			require.NoError(t, AdjustWindow(context.Background(), key, tt.delta, testWindow))
+			result, err := ConsumeWindow(context.Background(), key, 100, 0, testWindow)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantRemaining, result.Remaining)
+		})
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	ctx := context.Background()
+	key := "test:concurrency"
+	steps := []struct {
+		name        string
+		acquire     bool
+		wantOk      bool
+		wantCurrent int64
+	}{
+		{name: "first", acquire: true, wantOk: true, wantCurrent: 1},
+		{name: "second", acquire: true, wantOk: true, wantCurrent: 2},
+		{name: "over limit", acquire: true, wantOk: false, wantCurrent: 2},
+		{name: "release one", acquire: false},
+		{name: "acquire after release", acquire: true, wantOk: true, wantCurrent: 2},
+	}
+	for _, step := range steps {
+		if !step.acquire {
+			require.NoError(t, ReleaseConcurrency(ctx, key), step.name)
+			continue
+		}
+		ok, current, err := AcquireConcurrency(ctx, key, 2, time.Minute)
+		require.NoError(t, err, step.name)
+		require.Equal(t, step.wantOk, ok, step.name)
+		require.Equal(t, step.wantCurrent, current, step.name)
+	}
+}
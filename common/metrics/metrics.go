@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "new_api"
+
+// Registry 独立的指标注册表，避免与第三方库的默认注册表冲突
+var Registry = prometheus.NewRegistry()
+
+var (
+	relayRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_requests_total",
+		Help:      "Total number of relay requests.",
+	}, []string{"format", "model", "group", "channel", "status"})
+
+	relayRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_request_duration_seconds",
+		Help:      "Relay request latency in seconds.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"format", "model", "group", "channel"})
+
+	relayFirstTokenDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_first_token_seconds",
+		Help:      "Time to first response chunk of streaming relay requests in seconds.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20, 30, 60},
+	}, []string{"format", "model", "group", "channel"})
+
+	relayRetries = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_retries",
+		Help:      "Number of retries per relay request.",
+		Buckets:   []float64{0, 1, 2, 3, 5, 10},
+	}, []string{"format", "model"})
+
+	upstreamErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Total number of upstream errors by channel, status code and error code.",
+	}, []string{"channel", "status", "code"})
+
+	channelAutoDisabledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channel_auto_disabled_total",
+		Help:      "Total number of channels disabled automatically.",
+	}, []string{"channel"})
+
+	quotaConsumedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_consumed_total",
+		Help:      "Total quota consumed by model and group.",
+	}, []string{"model", "group", "channel"})
+
+	tokensConsumedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_consumed_total",
+		Help:      "Total tokens consumed by model, group and type.",
+	}, []string{"model", "group", "type"})
+
+	streamScannerTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_scanner_timeouts_total",
+		Help:      "Total number of streaming responses aborted by the scanner timeout.",
+	}, []string{"channel"})
+
+	logSinkDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "log_sink_dropped_total",
+		Help:      "Total number of log entries dropped by external log sinks.",
+	}, []string{"sink"})
+
+	activeConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_active_connections",
+		Help:      "Number of in-flight HTTP requests.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		relayRequestsTotal,
+		relayRequestDuration,
+		relayFirstTokenDuration,
+		relayRetries,
+		upstreamErrorsTotal,
+		channelAutoDisabledTotal,
+		quotaConsumedTotal,
+		tokensConsumedTotal,
+		streamScannerTimeoutsTotal,
+		logSinkDroppedTotal,
+		activeConnections,
+	)
+}
+
+// RelayLabels relay 请求的公共标签
+type RelayLabels struct {
+	Format    string
+	Model     string
+	Group     string
+	ChannelId int
+}
+
+func channelLabel(channelId int) string {
+	if channelId == 0 {
+		return ""
+	}
+	return strconv.Itoa(channelId)
+}
+
+// RecordRelayRequest 记录一次 relay 请求的结果
+func RecordRelayRequest(labels RelayLabels, statusCode int, duration time.Duration, firstToken time.Duration, retries int) {
+	channel := channelLabel(labels.ChannelId)
+	relayRequestsTotal.WithLabelValues(labels.Format, labels.Model, labels.Group, channel, strconv.Itoa(statusCode)).Inc()
+	relayRequestDuration.WithLabelValues(labels.Format, labels.Model, labels.Group, channel).Observe(duration.Seconds())
+	if firstToken > 0 {
+		relayFirstTokenDuration.WithLabelValues(labels.Format, labels.Model, labels.Group, channel).Observe(firstToken.Seconds())
+	}
+	relayRetries.WithLabelValues(labels.Format, labels.Model).Observe(float64(retries))
+}
+
+func RecordUpstreamError(channelId int, statusCode int, errorCode string) {
+	upstreamErrorsTotal.WithLabelValues(channelLabel(channelId), strconv.Itoa(statusCode), errorCode).Inc()
+}
+
+func RecordChannelAutoDisabled(channelId int) {
+	channelAutoDisabledTotal.WithLabelValues(channelLabel(channelId)).Inc()
+}
+
+func RecordConsume(model string, group string, channelId int, quota int, promptTokens int, completionTokens int) {
+	// counter 不允许减少
+	if quota > 0 {
+		quotaConsumedTotal.WithLabelValues(model, group, channelLabel(channelId)).Add(float64(quota))
+	}
+	if promptTokens > 0 {
+		tokensConsumedTotal.WithLabelValues(model, group, "prompt").Add(float64(promptTokens))
+	}
+	if completionTokens > 0 {
+		tokensConsumedTotal.WithLabelValues(model, group, "completion").Add(float64(completionTokens))
+	}
+}
+
+func RecordStreamScannerTimeout(channelId int) {
+	streamScannerTimeoutsTotal.WithLabelValues(channelLabel(channelId)).Inc()
+}
+
+func RecordLogSinkDropped(sink string, count int) {
+	logSinkDroppedTotal.WithLabelValues(sink).Add(float64(count))
+}
+
+func IncActiveConnections() {
+	activeConnections.Inc()
+}
+
+func DecActiveConnections() {
+	activeConnections.Dec()
+}
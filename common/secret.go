@@ -0,0 +1,223 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 加密后的密文格式：enc:v1:<主密钥ID>:<被主密钥加密的数据密钥>:<被数据密钥加密的明文>
+const secretCipherPrefix = "enc:v1:"
+
+type secretMasterKey struct {
+	id  string
+	key []byte
+}
+
+var (
+	secretCurrentKey *secretMasterKey
+	secretKeyring    = map[string]*secretMasterKey{}
+)
+
+var ErrSecretKeyNotFound = errors.New("secret encryption key not found, please check SECRET_ENCRYPTION_KEY and SECRET_ENCRYPTION_OLD_KEYS")
+
+// newSecretMasterKey 主密钥支持 base64 / hex 编码的 32 字节密钥，其他任意字符串通过 SHA-256 派生
+func newSecretMasterKey(raw string) *secretMasterKey {
+	raw = strings.TrimSpace(raw)
+	var key []byte
+	if decoded, err := base64.StdEncoding.DecodeString(raw); err == nil && len(decoded) == 32 {
+		key = decoded
+	} else if decoded, err := hex.DecodeString(raw); err == nil && len(decoded) == 32 {
+		key = decoded
+	} else {
+		key = Sha256Raw([]byte(raw))
+	}
+	sum := sha256.Sum256(key)
+	return &secretMasterKey{id: hex.EncodeToString(sum[:4]), key: key}
+}
+
+// InitSecretEncryption 从环境变量或密钥文件加载主密钥，未配置时不加密
+func InitSecretEncryption() error {
+	current := os.Getenv("SECRET_ENCRYPTION_KEY")
+	if keyFile := os.Getenv("SECRET_ENCRYPTION_KEY_FILE"); current == "" && keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read SECRET_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		current = string(content)
+	}
+	secretCurrentKey = nil
+	secretKeyring = map[string]*secretMasterKey{}
+	if strings.TrimSpace(current) == "" {
+		return nil
+	}
+	secretCurrentKey = newSecretMasterKey(current)
+	secretKeyring[secretCurrentKey.id] = secretCurrentKey
+	// 轮换主密钥时，旧密钥仅用于解密
+	for _, old := range strings.Split(os.Getenv("SECRET_ENCRYPTION_OLD_KEYS"), ",") {
+		if strings.TrimSpace(old) == "" {
+			continue
+		}
+		oldKey := newSecretMasterKey(old)
+		if _, ok := secretKeyring[oldKey.id]; !ok {
+			secretKeyring[oldKey.id] = oldKey
+		}
+	}
+	return nil
+}
+
+func SecretEncryptionEnabled() bool {
+	return secretCurrentKey != nil
+}
+
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretCipherPrefix)
+}
+
+func aesGCMSeal(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func aesGCMOpen(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("invalid secret ciphertext")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func formatSecretCipher(keyId string, wrappedKey []byte, ciphertext []byte) string {
+	return secretCipherPrefix + keyId + ":" + base64.StdEncoding.EncodeToString(wrappedKey) + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+func parseSecretCipher(value string) (keyId string, wrappedKey []byte, ciphertext []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, secretCipherPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("invalid secret ciphertext")
+	}
+	if wrappedKey, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, err
+	}
+	if ciphertext, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, err
+	}
+	return parts[0], wrappedKey, ciphertext, nil
+}
+
+// unwrapSecretDataKey 使用对应的主密钥解出数据密钥
+func unwrapSecretDataKey(keyId string, wrappedKey []byte) ([]byte, error) {
+	masterKey, ok := secretKeyring[keyId]
+	if !ok {
+		return nil, ErrSecretKeyNotFound
+	}
+	return aesGCMOpen(masterKey.key, wrappedKey)
+}
+
+// EncryptSecret 使用随机数据密钥加密明文，数据密钥再由主密钥加密；未启用或已加密时原样返回
+func EncryptSecret(plaintext string) (string, error) {
+	if plaintext == "" || secretCurrentKey == nil || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := aesGCMSeal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := aesGCMSeal(secretCurrentKey.key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return formatSecretCipher(secretCurrentKey.id, wrappedKey, ciphertext), nil
+}
+
+// SecretLookupHash 计算明文的确定性摘要，密文随机化后仍可按原值精确查找
+func SecretLookupHash(plaintext string) string {
+	if plaintext == "" {
+		return ""
+	}
+	return hex.EncodeToString(Sha256Raw([]byte(plaintext)))
+}
+
+// DecryptSecret 解密密文，未加密的旧数据原样返回
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	keyId, wrappedKey, ciphertext, err := parseSecretCipher(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := unwrapSecretDataKey(keyId, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aesGCMOpen(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// SecretNeedsMigration 判断值是否需要加密或使用当前主密钥重新封装
+func SecretNeedsMigration(value string) bool {
+	if value == "" || secretCurrentKey == nil {
+		return false
+	}
+	if !IsEncryptedSecret(value) {
+		return true
+	}
+	keyId, _, _, err := parseSecretCipher(value)
+	return err == nil && keyId != secretCurrentKey.id
+}
+
+// RewrapSecret 将明文加密，或将旧主密钥加密的数据密钥用当前主密钥重新封装，密文主体不变
+func RewrapSecret(value string) (string, error) {
+	if !SecretNeedsMigration(value) {
+		return value, nil
+	}
+	if !IsEncryptedSecret(value) {
+		return EncryptSecret(value)
+	}
+	keyId, wrappedKey, ciphertext, err := parseSecretCipher(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := unwrapSecretDataKey(keyId, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	rewrapped, err := aesGCMSeal(secretCurrentKey.key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return formatSecretCipher(secretCurrentKey.id, rewrapped, ciphertext), nil
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setSecretKeys(t *testing.T, current string, old string) {
+	t.Setenv("SECRET_ENCRYPTION_KEY", current)
+	t.Setenv("SECRET_ENCRYPTION_KEY_FILE", "")
+	t.Setenv("SECRET_ENCRYPTION_OLD_KEYS", old)
+	require.NoError(t, InitSecretEncryption())
+	t.Cleanup(func() {
+		secretCurrentKey = nil
+		secretKeyring = map[string]*secretMasterKey{}
+	})
+}
+
+func TestEncryptDecryptSecret(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           string
+		plaintext     string
+		wantEncrypted bool
+	}{
+		{name: "disabled keeps plaintext", key: "", plaintext: "sk-test", wantEncrypted: false},
+		{name: "empty value stays empty", key: "master", plaintext: "", wantEncrypted: false},
+		{name: "passphrase key", key: "master", plaintext: "sk-test", wantEncrypted: true},
+		{name: "base64 key", key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", plaintext: "sk-test", wantEncrypted: true},
+		{name: "multi line value", key: "master", plaintext: "sk-a\nsk-b", wantEncrypted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSecretKeys(t, tt.key, "")
+			encrypted, err := EncryptSecret(tt.plaintext)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantEncrypted, IsEncryptedSecret(encrypted))
+			if tt.wantEncrypted {
+				require.NotContains(t, encrypted, tt.plaintext)
+				// 已加密的值不会被重复加密
+				again, err := EncryptSecret(encrypted)
+				require.NoError(t, err)
+				require.Equal(t, encrypted, again)
+			}
+			decrypted, err := DecryptSecret(encrypted)
+			require.NoError(t, err)
+			require.Equal(t, tt.plaintext, decrypted)
+		})
+	}
+}
+
+func TestSecretLegacyKeys(t *testing.T) {
+	setSecretKeys(t, "old-master", "")
+	legacy, err := EncryptSecret("sk-legacy")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		current       string
+		old           string
+		value         string
+		wantMigration bool
+		wantErr       error
+	}{
+		{name: "plaintext legacy value", current: "new-master", value: "sk-plain", wantMigration: true},
+		{name: "encrypted with old key", current: "new-master", old: "old-master", value: legacy, wantMigration: true},
+		{name: "encrypted with current key", current: "old-master", value: legacy, wantMigration: false},
+		{name: "old key missing", current: "new-master", value: legacy, wantMigration: true, wantErr: ErrSecretKeyNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSecretKeys(t, tt.current, tt.old)
+			require.Equal(t, tt.wantMigration, SecretNeedsMigration(tt.value))
+			rewrapped, err := RewrapSecret(tt.value)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				_, err = DecryptSecret(tt.value)
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.False(t, SecretNeedsMigration(rewrapped))
+			original, err := DecryptSecret(tt.value)
+			require.NoError(t, err)
+			decrypted, err := DecryptSecret(rewrapped)
+			require.NoError(t, err)
+			require.Equal(t, original, decrypted)
+			if IsEncryptedSecret(tt.value) {
+				// 轮换只重新封装数据密钥，密文主体不变
+				parts := strings.Split(tt.value, ":")
+				require.True(t, strings.HasSuffix(rewrapped, parts[len(parts)-1]))
+			}
+		})
+	}
+}
+
+func TestSecretLookupHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "empty", value: "", want: ""},
+		{name: "sha256 hex", value: "abc", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, SecretLookupHash(tt.value))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var TracingEnabled = false
+
+var tracer = otel.Tracer("github.com/QuantumNous/new-api")
+
+// StartTracing 配置了 OTLP 地址时启用链路追踪，导出地址、请求头、采样率等沿用 OTEL_* 标准环境变量
+func StartTracing() error {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return err
+	}
+	// OTEL_SERVICE_NAME、OTEL_RESOURCE_ATTRIBUTES 优先于默认服务名
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("new-api"), semconv.ServiceVersion(Version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return err
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	TracingEnabled = true
+	SysLog("opentelemetry tracing enabled")
+	return nil
+}
+
+// StartSpan 以当前请求上下文中的 span 为父节点创建子 span
+func StartSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(c.Request.Context(), name, trace.WithAttributes(attrs...))
+}
+
+// StartNestedSpan 创建子 span 并替换请求上下文，之后创建的 span 都挂在其下，调用返回的函数结束 span 并恢复上下文
+func StartNestedSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) (trace.Span, func()) {
+	parent := c.Request.Context()
+	ctx, span := tracer.Start(parent, name, trace.WithAttributes(attrs...))
+	c.Request = c.Request.WithContext(ctx)
+	return span, func() {
+		span.End()
+		c.Request = c.Request.WithContext(parent)
+	}
+}
+
+// StartServerSpan 读取请求头中的 traceparent 作为父节点
+func StartServerSpan(r *http.Request, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// InjectTraceContext 将 ctx 中的 span 写入 traceparent 请求头
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+func SetSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
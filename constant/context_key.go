@@ -19,6 +19,12 @@ const (
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
 	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenUsageLimit        ContextKey = "token_usage_limit"
+	ContextKeyTokenResponseCache     ContextKey = "token_response_cache"
+	ContextKeyTokenProjectId         ContextKey = "token_project_id"
+	ContextKeyTokenOrgId             ContextKey = "token_org_id"
+	ContextKeyTokenBudget            ContextKey = "token_budget"
+	ContextKeyTokenCallbackUrl       ContextKey = "token_callback_url"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -43,16 +49,45 @@ const (
 	ContextKeyAutoGroupRetryIndex ContextKey = "auto_group_retry_index"
 
 	/* user related keys */
-	ContextKeyUserId      ContextKey = "id"
-	ContextKeyUserSetting ContextKey = "user_setting"
-	ContextKeyUserQuota   ContextKey = "user_quota"
-	ContextKeyUserStatus  ContextKey = "user_status"
-	ContextKeyUserEmail   ContextKey = "user_email"
-	ContextKeyUserGroup   ContextKey = "user_group"
-	ContextKeyUsingGroup  ContextKey = "group"
-	ContextKeyUserName    ContextKey = "username"
+	ContextKeyUserId         ContextKey = "id"
+	ContextKeyUserSetting    ContextKey = "user_setting"
+	ContextKeyUserQuota      ContextKey = "user_quota"
+	ContextKeyUserStatus     ContextKey = "user_status"
+	ContextKeyUserEmail      ContextKey = "user_email"
+	ContextKeyUserGroup      ContextKey = "user_group"
+	ContextKeyUsingGroup     ContextKey = "group"
+	ContextKeyUserName       ContextKey = "username"
+	ContextKeyUserUsageLimit ContextKey = "user_usage_limit"
+	ContextKeyUserBudget     ContextKey = "user_budget"
 
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	/* usage limit related keys */
+	ContextKeyUsageLimitScopes ContextKey = "usage_limit_scopes"
+	ContextKeyConsumedTokens   ContextKey = "consumed_tokens"
+
+	/* response cache related keys */
+	ContextKeyRelayUsage ContextKey = "relay_usage"
+
+	/* batch related keys */
+	ContextKeyBatchId ContextKey = "batch_id"
+
+	/* payload capture related keys */
+	ContextKeyPayloadCapture         ContextKey = "payload_capture"
+	ContextKeyPayloadUpstreamRequest ContextKey = "payload_upstream_request"
+
+	/* guardrail related keys */
+	ContextKeyGuardrailHits ContextKey = "guardrail_hits"
+
+	/* pii redaction related keys */
+	ContextKeyPIIRedactor ContextKey = "pii_redactor"
+
+	/* virtual model related keys */
+	ContextKeyVirtualModel              ContextKey = "virtual_model"
+	ContextKeyVirtualModelParamOverride ContextKey = "virtual_model_param_override"
+
+	/* routing rule related keys */
+	ContextKeyRoutingRule ContextKey = "routing_rule"
 )
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const artifactDownloadTimeout = 10 * time.Minute
+
+// archiveTaskVideo 视频任务成功后异步转存生成结果，上游地址过期后仍可通过签名地址访问
+func archiveTaskVideo(task *model.Task) {
+	if !operation_setting.GetArtifactSetting().Enabled {
+		return
+	}
+	taskCopy := *task
+	gopool.Go(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), artifactDownloadTimeout)
+		defer cancel()
+		client, req, proxyErr := newTaskVideoRequest(ctx, &taskCopy)
+		if proxyErr != nil {
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to download artifact of task %s: %s", taskCopy.TaskID, err.Error()))
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			logger.LogError(ctx, fmt.Sprintf("failed to download artifact of task %s: status %d", taskCopy.TaskID, resp.StatusCode))
+			return
+		}
+		if _, err = service.SaveArtifact(taskCopy.UserId, model.ArtifactTaskTypeTask, taskCopy.TaskID, resp); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to save artifact of task %s: %s", taskCopy.TaskID, err.Error()))
+		}
+	})
+}
+
+// archiveMidjourneyTask Midjourney 任务成功后异步转存视频或图片
+func archiveMidjourneyTask(task *model.Midjourney) {
+	if !operation_setting.GetArtifactSetting().Enabled {
+		return
+	}
+	sourceUrl := task.VideoUrl
+	if sourceUrl == "" {
+		sourceUrl = task.ImageUrl
+	}
+	if sourceUrl == "" {
+		return
+	}
+	userId, mjId, channelId := task.UserId, task.MjId, task.ChannelId
+	gopool.Go(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), artifactDownloadTimeout)
+		defer cancel()
+		proxy := ""
+		if channel, err := model.CacheGetChannel(channelId); err == nil {
+			proxy = channel.GetSetting().Proxy
+		}
+		client, err := service.GetHttpClientWithProxy(proxy)
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to create proxy client for midjourney task %s: %s", mjId, err.Error()))
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceUrl, nil)
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to create artifact request of midjourney task %s: %s", mjId, err.Error()))
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to download artifact of midjourney task %s: %s", mjId, err.Error()))
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			logger.LogError(ctx, fmt.Sprintf("failed to download artifact of midjourney task %s: status %d", mjId, resp.StatusCode))
+			return
+		}
+		if _, err = service.SaveArtifact(userId, model.ArtifactTaskTypeMidjourney, mjId, resp); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("failed to save artifact of midjourney task %s: %s", mjId, err.Error()))
+		}
+	})
+}
+
+// GetUserArtifacts 分页列出当前用户的转存结果，附带签名下载地址
+func GetUserArtifacts(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	artifacts, total, err := model.GetUserArtifacts(c.GetInt("id"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	for _, artifact := range artifacts {
+		artifact.Url = service.SignArtifactUrl(artifact)
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(artifacts)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetArtifactContent 通过签名地址下载转存结果，无需登录
+func GetArtifactContent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid artifact id"})
+		return
+	}
+	if err = service.VerifyArtifactSignature(id, c.Query("expires"), c.Query("signature")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	artifact, err := model.GetArtifactById(id)
+	if err != nil || artifact.IsExpired() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "artifact not found"})
+		return
+	}
+	reader, err := service.GetArtifactStore().Open(artifact.StorageKey)
+	if err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("failed to open artifact %d: %s", artifact.Id, err.Error()))
+		c.JSON(http.StatusNotFound, gin.H{"error": "artifact not found"})
+		return
+	}
+	defer reader.Close()
+	// 产物内容来自上游，一律作为附件下载，禁止浏览器按内容嗅探类型
+	filename := fmt.Sprintf("artifact-%d", artifact.Id)
+	if extensions, _ := mime.ExtensionsByType(artifact.ContentType); len(extensions) > 0 {
+		filename += extensions[0]
+	}
+	c.DataFromReader(http.StatusOK, artifact.Size, artifact.ContentType, reader, map[string]string{
+		"Cache-Control":          "private, max-age=3600",
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": filename}),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getAuditLogFilter(c *gin.Context) model.AuditLogFilter {
+	actorId, _ := strconv.Atoi(c.Query("actor_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return model.AuditLogFilter{
+		ActorId:        actorId,
+		ActorName:      c.Query("actor_name"),
+		Action:         c.Query("action"),
+		TargetType:     c.Query("target_type"),
+		TargetId:       c.Query("target_id"),
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+	}
+}
+
+func GetAuditLogs(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	logs, total, err := model.GetAuditLogs(getAuditLogFilter(c), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(logs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// ExportAuditLogs 按筛选条件导出 CSV
+func ExportAuditLogs(c *gin.Context) {
+	filter := getAuditLogFilter(c)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=audit-logs-%s.csv", time.Now().Format("20060102150405")))
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write([]string{"id", "created_at", "actor_id", "actor_name", "actor_role", "ip", "action", "target_type", "target_id", "diff"})
+	lastId := 0
+	for {
+		logs, err := model.GetAuditLogsAfterId(filter, lastId, 1000)
+		if err != nil {
+			common.SysError("failed to export audit logs: " + err.Error())
+			break
+		}
+		for _, log := range logs {
+			_ = writer.Write([]string{
+				strconv.Itoa(log.Id),
+				time.Unix(log.CreatedAt, 0).Format(time.RFC3339),
+				strconv.Itoa(log.ActorId),
+				log.ActorName,
+				strconv.Itoa(log.ActorRole),
+				log.Ip,
+				log.Action,
+				log.TargetType,
+				log.TargetId,
+				log.Diff,
+			})
+			lastId = log.Id
+		}
+		writer.Flush()
+		if len(logs) < 1000 {
+			break
+		}
+	}
+}
+
+func getOptionValue(key string) string {
+	common.OptionMapRWMutex.RLock()
+	defer common.OptionMapRWMutex.RUnlock()
+	return common.OptionMap[key]
+}
+
+// recordOptionAudit 以配置项名为根记录变更，JSON 类配置按子项比较
+func recordOptionAudit(c *gin.Context, key string, before string, after string) {
+	service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetOption, key,
+		map[string]any{key: before}, map[string]any{key: after})
+}
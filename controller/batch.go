@@ -0,0 +1,571 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const batchCompletionWindow = "24h"
+
+var batchEndpointFormats = map[string]types.RelayFormat{
+	"/v1/chat/completions": types.RelayFormatOpenAI,
+	"/v1/completions":      types.RelayFormatOpenAI,
+	"/v1/embeddings":       types.RelayFormatEmbedding,
+	"/v1/responses":        types.RelayFormatOpenAIResponses,
+}
+
+type batchContextKey struct{}
+
+type batchLineContext struct {
+	batchId string
+	tokenId int
+}
+
+func batchToOpenAIBatch(batch *model.Batch) dto.OpenAIBatch {
+	optionalTime := func(t int64) *int64 {
+		if t == 0 {
+			return nil
+		}
+		return &t
+	}
+	optionalString := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+	result := dto.OpenAIBatch{
+		ID:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileID:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileID:     optionalString(batch.OutputFileId),
+		ErrorFileID:      optionalString(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     optionalTime(batch.InProgressAt),
+		ExpiresAt:        optionalTime(batch.ExpiresAt),
+		FinalizingAt:     optionalTime(batch.FinalizingAt),
+		CompletedAt:      optionalTime(batch.CompletedAt),
+		FailedAt:         optionalTime(batch.FailedAt),
+		ExpiredAt:        optionalTime(batch.ExpiredAt),
+		CancellingAt:     optionalTime(batch.CancellingAt),
+		CancelledAt:      optionalTime(batch.CancelledAt),
+		RequestCounts: dto.BatchRequestCounts{
+			Total:     batch.TotalCount,
+			Completed: batch.CompletedCount,
+			Failed:    batch.FailedCount,
+		},
+	}
+	if batch.Errors != "" {
+		var batchErrors dto.BatchErrors
+		if err := common.UnmarshalJsonStr(batch.Errors, &batchErrors); err == nil {
+			result.Errors = &batchErrors
+		}
+	}
+	if batch.Metadata != "" {
+		_ = common.UnmarshalJsonStr(batch.Metadata, &result.Metadata)
+	}
+	return result
+}
+
+// CreateBatch POST /v1/batches
+func CreateBatch(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	var req dto.BatchCreateRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid request body: "+err.Error(), "invalid_request_error")
+		return
+	}
+	if _, ok := batchEndpointFormats[req.Endpoint]; !ok {
+		openAIErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("unsupported endpoint: %s", req.Endpoint), "invalid_request_error")
+		return
+	}
+	if req.CompletionWindow != batchCompletionWindow {
+		openAIErrorResponse(c, http.StatusBadRequest, "completion_window must be 24h", "invalid_request_error")
+		return
+	}
+	if len(req.Metadata) > 16 {
+		openAIErrorResponse(c, http.StatusBadRequest, "metadata can have at most 16 pairs", "invalid_request_error")
+		return
+	}
+	userId := c.GetInt("id")
+	inputFile, err := model.GetUserFile(userId, req.InputFileID)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("No such File object: %s", req.InputFileID), "invalid_request_error")
+		return
+	}
+	if inputFile.Purpose != model.FilePurposeBatch {
+		openAIErrorResponse(c, http.StatusBadRequest, "input file must be uploaded with purpose batch", "invalid_request_error")
+		return
+	}
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          "batch_" + common.GetUUID(),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Endpoint:         req.Endpoint,
+		InputFileId:      inputFile.FileId,
+		CompletionWindow: req.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		CreatedAt:        now,
+		ExpiresAt:        now + int64(24*time.Hour/time.Second),
+	}
+	if len(req.Metadata) > 0 {
+		metadata, _ := common.Marshal(req.Metadata)
+		batch.Metadata = string(metadata)
+	}
+	if err = batch.Insert(); err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error")
+		return
+	}
+	c.JSON(http.StatusOK, batchToOpenAIBatch(batch))
+}
+
+func getUserBatchOrAbort(c *gin.Context) *model.Batch {
+	batchId := c.Param("batch_id")
+	batch, err := model.GetUserBatch(c.GetInt("id"), batchId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			openAIErrorResponse(c, http.StatusNotFound, fmt.Sprintf("No such Batch object: %s", batchId), "invalid_request_error")
+		} else {
+			openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error")
+		}
+		return nil
+	}
+	return batch
+}
+
+// RetrieveBatch GET /v1/batches/:batch_id
+func RetrieveBatch(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	batch := getUserBatchOrAbort(c)
+	if batch == nil {
+		return
+	}
+	c.JSON(http.StatusOK, batchToOpenAIBatch(batch))
+}
+
+// ListBatches GET /v1/batches
+func ListBatches(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	limit := getListLimit(c)
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error")
+		return
+	}
+	list := dto.OpenAIList[dto.OpenAIBatch]{
+		Object: "list",
+		Data:   make([]dto.OpenAIBatch, 0, len(batches)),
+	}
+	if len(batches) > limit {
+		batches = batches[:limit]
+		list.HasMore = true
+	}
+	for _, batch := range batches {
+		list.Data = append(list.Data, batchToOpenAIBatch(batch))
+	}
+	if len(list.Data) > 0 {
+		list.FirstID = list.Data[0].ID
+		list.LastID = list.Data[len(list.Data)-1].ID
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// CancelBatch POST /v1/batches/:batch_id/cancel
+func CancelBatch(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	batch := getUserBatchOrAbort(c)
+	if batch == nil {
+		return
+	}
+	if err := model.CancelUserBatch(batch); err != nil {
+		openAIErrorResponse(c, http.StatusConflict, err.Error(), "invalid_request_error")
+		return
+	}
+	c.JSON(http.StatusOK, batchToOpenAIBatch(batch))
+}
+
+func batchErrorsJson(code string, message string, line *int) string {
+	data, _ := common.Marshal(dto.BatchErrors{
+		Object: "list",
+		Data:   []dto.BatchError{{Code: code, Message: message, Line: line}},
+	})
+	return string(data)
+}
+
+var (
+	batchEngine     *gin.Engine
+	batchEngineOnce sync.Once
+)
+
+// batchLineAuth 为批处理中的单行请求恢复创建批处理时所用令牌的上下文，校验逻辑与 TokenAuth 保持一致
+func batchLineAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lineCtx, ok := c.Request.Context().Value(batchContextKey{}).(*batchLineContext)
+		if !ok {
+			openAIErrorResponse(c, http.StatusInternalServerError, "missing batch context", "new_api_error")
+			c.Abort()
+			return
+		}
+		token, err := model.GetTokenById(lineCtx.tokenId)
+		if err == nil {
+			token, err = model.ValidateUserToken(token.Key)
+		}
+		if err != nil {
+			openAIErrorResponse(c, http.StatusUnauthorized, err.Error(), "new_api_error")
+			c.Abort()
+			return
+		}
+		userCache, err := model.GetUserCache(token.UserId)
+		if err != nil {
+			openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "new_api_error")
+			c.Abort()
+			return
+		}
+		if userCache.Status != common.UserStatusEnabled {
+			openAIErrorResponse(c, http.StatusForbidden, "用户已被封禁", "new_api_error")
+			c.Abort()
+			return
+		}
+		userCache.WriteContext(c)
+		usingGroup := userCache.Group
+		if token.Group != "" {
+			if _, ok := service.GetUserUsableGroups(usingGroup)[token.Group]; !ok {
+				openAIErrorResponse(c, http.StatusForbidden, fmt.Sprintf("无权访问 %s 分组", token.Group), "new_api_error")
+				c.Abort()
+				return
+			}
+			usingGroup = token.Group
+		}
+		common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
+		if err = middleware.SetupContextForToken(c, token); err != nil {
+			// 项目校验失败时已写入错误响应
+			if !c.Writer.Written() {
+				openAIErrorResponse(c, http.StatusForbidden, err.Error(), "new_api_error")
+			}
+			c.Abort()
+			return
+		}
+		common.SetContextKey(c, constant.ContextKeyBatchId, lineCtx.batchId)
+		c.Next()
+	}
+}
+
+// getBatchEngine 内部路由，批处理的每一行都像普通请求一样经过 Distribute 与 Relay
+func getBatchEngine() *gin.Engine {
+	batchEngineOnce.Do(func() {
+		engine := gin.New()
+		engine.Use(gin.Recovery(), middleware.RequestId(), batchLineAuth(), middleware.Distribute())
+		for endpoint, format := range batchEndpointFormats {
+			relayFormat := format
+			engine.POST(endpoint, func(c *gin.Context) {
+				Relay(c, relayFormat)
+			})
+		}
+		batchEngine = engine
+	})
+	return batchEngine
+}
+
+func executeBatchLine(batch *model.Batch, line *dto.BatchRequestLine) *dto.BatchResponseLine {
+	result := &dto.BatchResponseLine{
+		ID:       "batch_req_" + common.GetUUID(),
+		CustomID: line.CustomID,
+	}
+	ctx := context.WithValue(context.Background(), batchContextKey{}, &batchLineContext{
+		batchId: batch.BatchId,
+		tokenId: batch.TokenId,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, line.URL, bytes.NewReader(line.Body))
+	if err != nil {
+		result.Error = &dto.BatchError{Code: "invalid_request", Message: err.Error()}
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	getBatchEngine().ServeHTTP(recorder, req)
+
+	body := recorder.Body.Bytes()
+	if !json.Valid(body) {
+		body, _ = common.Marshal(string(body))
+	}
+	result.Response = &dto.BatchResponseBody{
+		StatusCode: recorder.Code,
+		RequestID:  recorder.Header().Get(common.RequestIdKey),
+		Body:       body,
+	}
+	return result
+}
+
+// parseBatchInput 读取并校验批处理输入文件，任何一行不合法都会使整个批处理失败
+func parseBatchInput(batch *model.Batch) ([]*dto.BatchRequestLine, *dto.BatchError) {
+	reader, err := service.GetFileStore().Open(batch.InputFileId)
+	if err != nil {
+		return nil, &dto.BatchError{Code: "invalid_file", Message: "input file not found"}
+	}
+	defer reader.Close()
+
+	maxLines := operation_setting.GetBatchSetting().MaxLinesPerBatch
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), constant.MaxRequestBodyMB<<20)
+	lines := make([]*dto.BatchRequestLine, 0)
+	customIds := make(map[string]struct{})
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		n := lineNo
+		if maxLines > 0 && len(lines) >= maxLines {
+			return nil, &dto.BatchError{Code: "too_many_requests", Message: fmt.Sprintf("batch contains more than %d requests", maxLines), Line: &n}
+		}
+		var line dto.BatchRequestLine
+		if err := common.Unmarshal(raw, &line); err != nil {
+			return nil, &dto.BatchError{Code: "invalid_json_line", Message: err.Error(), Line: &n}
+		}
+		if line.CustomID == "" {
+			return nil, &dto.BatchError{Code: "missing_required_parameter", Message: "custom_id is required", Line: &n}
+		}
+		if _, ok := customIds[line.CustomID]; ok {
+			return nil, &dto.BatchError{Code: "duplicate_custom_id", Message: fmt.Sprintf("duplicate custom_id: %s", line.CustomID), Line: &n}
+		}
+		customIds[line.CustomID] = struct{}{}
+		if line.Method != http.MethodPost {
+			return nil, &dto.BatchError{Code: "invalid_method", Message: "method must be POST", Line: &n}
+		}
+		if line.URL != batch.Endpoint {
+			return nil, &dto.BatchError{Code: "mismatched_endpoint", Message: fmt.Sprintf("url %s does not match batch endpoint %s", line.URL, batch.Endpoint), Line: &n}
+		}
+		var streamRequest struct {
+			Stream bool `json:"stream"`
+		}
+		if err := common.Unmarshal(line.Body, &streamRequest); err != nil {
+			return nil, &dto.BatchError{Code: "invalid_request", Message: "body must be a JSON object", Line: &n}
+		}
+		if streamRequest.Stream {
+			return nil, &dto.BatchError{Code: "invalid_request", Message: "stream is not supported in batch requests", Line: &n}
+		}
+		lines = append(lines, &line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &dto.BatchError{Code: "invalid_file", Message: err.Error()}
+	}
+	if len(lines) == 0 {
+		return nil, &dto.BatchError{Code: "empty_file", Message: "input file contains no requests"}
+	}
+	return lines, nil
+}
+
+func writeBatchResultFile(batch *model.Batch, suffix string, results []*dto.BatchResponseLine) (string, error) {
+	var buf bytes.Buffer
+	for _, result := range results {
+		data, err := common.Marshal(result)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	file := &model.File{
+		FileId:    "file-" + common.GetUUID(),
+		UserId:    batch.UserId,
+		Filename:  fmt.Sprintf("%s_%s.jsonl", batch.BatchId, suffix),
+		Purpose:   model.FilePurposeBatchOutput,
+		Status:    model.FileStatusProcessed,
+		ExpiresAt: fileExpiresAt(),
+	}
+	n, err := service.GetFileStore().Put(file.FileId, &buf)
+	if err != nil {
+		return "", err
+	}
+	file.Bytes = n
+	if err = file.Insert(); err != nil {
+		_ = service.GetFileStore().Delete(file.FileId)
+		return "", err
+	}
+	return file.FileId, nil
+}
+
+func processBatch(batch *model.Batch) {
+	lines, lineErr := parseBatchInput(batch)
+	if lineErr != nil {
+		data, _ := common.Marshal(dto.BatchErrors{Object: "list", Data: []dto.BatchError{*lineErr}})
+		batch.Status = model.BatchStatusFailed
+		batch.Errors = string(data)
+		batch.FailedAt = common.GetTimestamp()
+		if err := batch.Update(); err != nil {
+			common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		}
+		return
+	}
+	batch.TotalCount = len(lines)
+	if err := batch.UpdateTotalCount(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+
+	// 定期检查批处理是否被取消
+	var stopped atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if status, err := model.GetBatchStatus(batch.Id); err == nil && status == model.BatchStatusCancelling {
+					stopped.Store(true)
+					return
+				}
+			}
+		}
+	}()
+
+	results := make([]*dto.BatchResponseLine, len(lines))
+	var expired atomic.Bool
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	concurrency := operation_setting.GetBatchSetting().Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = executeBatchLine(batch, lines[idx])
+			}
+		}()
+	}
+	for idx := range lines {
+		if stopped.Load() {
+			break
+		}
+		if common.GetTimestamp() > batch.ExpiresAt {
+			expired.Store(true)
+			break
+		}
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+	close(done)
+	// 最后一次轮询之后才被取消时，同样以取消结束，避免把取消中的状态覆盖为已完成
+	if status, err := model.GetBatchStatus(batch.Id); err == nil && status == model.BatchStatusCancelling {
+		stopped.Store(true)
+	}
+
+	batch.FinalizingAt = common.GetTimestamp()
+	var outputs, failures []*dto.BatchResponseLine
+	for idx, result := range results {
+		if result == nil {
+			// 取消或过期时未执行的行
+			code, message := "batch_cancelled", "This request was not executed because the batch was cancelled."
+			if expired.Load() {
+				code, message = "batch_expired", "This request could not be executed before the completion window expired."
+			}
+			result = &dto.BatchResponseLine{
+				ID:       "batch_req_" + common.GetUUID(),
+				CustomID: lines[idx].CustomID,
+				Error:    &dto.BatchError{Code: code, Message: message},
+			}
+		}
+		if result.Error == nil && result.Response != nil && result.Response.StatusCode == http.StatusOK {
+			outputs = append(outputs, result)
+		} else {
+			failures = append(failures, result)
+		}
+	}
+	batch.CompletedCount = len(outputs)
+	batch.FailedCount = len(failures)
+
+	var err error
+	if len(outputs) > 0 {
+		if batch.OutputFileId, err = writeBatchResultFile(batch, "output", outputs); err != nil {
+			common.SysError(fmt.Sprintf("failed to write output file for batch %s: %s", batch.BatchId, err.Error()))
+		}
+	}
+	if len(failures) > 0 {
+		if batch.ErrorFileId, err = writeBatchResultFile(batch, "error", failures); err != nil {
+			common.SysError(fmt.Sprintf("failed to write error file for batch %s: %s", batch.BatchId, err.Error()))
+		}
+	}
+
+	now := common.GetTimestamp()
+	switch {
+	case stopped.Load():
+		batch.Status = model.BatchStatusCancelled
+		batch.CancelledAt = now
+	case expired.Load():
+		batch.Status = model.BatchStatusExpired
+		batch.ExpiredAt = now
+	default:
+		batch.Status = model.BatchStatusCompleted
+		batch.CompletedAt = now
+	}
+	if err = batch.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	common.SysLog(fmt.Sprintf("batch %s %s: %d completed, %d failed", batch.BatchId, batch.Status, batch.CompletedCount, batch.FailedCount))
+}
+
+// RunBatchTasks 批处理执行器，仅在主节点运行
+func RunBatchTasks() {
+	err := model.FailInterruptedBatches(batchErrorsJson("interrupted", "The batch was interrupted by a server restart.", nil))
+	if err != nil {
+		common.SysError("failed to reset interrupted batches: " + err.Error())
+	}
+	for {
+		if !operation_setting.GetBatchSetting().Enabled {
+			time.Sleep(time.Minute)
+			continue
+		}
+		batch, err := model.ClaimPendingBatch()
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				common.SysError("failed to claim batch: " + err.Error())
+			}
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		common.SysLog(fmt.Sprintf("batch %s started", batch.BatchId))
+		processBatch(batch)
+	}
+}
@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryFileStore 测试用的内存文件存储
+type memoryFileStore struct {
+	mutex sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memoryFileStore) Put(name string, reader io.Reader) (int64, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.files[name] = data
+	return int64(len(data)), nil
+}
+
+func (s *memoryFileStore) Open(name string) (io.ReadCloser, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.files[name]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memoryFileStore) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.files, name)
+	return nil
+}
+
+func setMemoryFileStoreForTest(t *testing.T) *memoryFileStore {
+	store := &memoryFileStore{files: map[string][]byte{}}
+	service.SetFileStore(store)
+	t.Cleanup(func() { service.SetFileStore(nil) })
+	return store
+}
+
+// readBatchResultLines 读取批处理结果文件中的每一行
+func readBatchResultLines(t *testing.T, store *memoryFileStore, fileId string) []dto.BatchResponseLine {
+	var results []dto.BatchResponseLine
+	for _, raw := range strings.Split(strings.TrimSpace(string(store.files[fileId])), "\n") {
+		var line dto.BatchResponseLine
+		require.NoError(t, common.UnmarshalJsonStr(raw, &line))
+		results = append(results, line)
+	}
+	return results
+}
+
+func TestParseBatchInput(t *testing.T) {
+	const endpoint = "/v1/chat/completions"
+	line := func(customId string) string {
+		return `{"custom_id":"` + customId + `","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}`
+	}
+	tests := []struct {
+		name      string
+		input     string
+		wantCount int
+		wantCode  string
+		wantLine  int
+	}{
+		{name: "valid lines", input: line("a") + "\n\n" + line("b") + "\n", wantCount: 2},
+		{name: "empty file", input: "\n\n", wantCode: "empty_file"},
+		{name: "invalid json", input: line("a") + "\n{not json}", wantCode: "invalid_json_line", wantLine: 2},
+		{name: "missing custom id", input: line(""), wantCode: "missing_required_parameter", wantLine: 1},
+		{name: "duplicate custom id", input: line("a") + "\n" + line("a"), wantCode: "duplicate_custom_id", wantLine: 2},
+		{
+			name:     "wrong method",
+			input:    `{"custom_id":"a","method":"GET","url":"/v1/chat/completions","body":{}}`,
+			wantCode: "invalid_method",
+			wantLine: 1,
+		},
+		{
+			name:     "mismatched endpoint",
+			input:    `{"custom_id":"a","method":"POST","url":"/v1/embeddings","body":{}}`,
+			wantCode: "mismatched_endpoint",
+			wantLine: 1,
+		},
+		{
+			name:     "body is not an object",
+			input:    `{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":"text"}`,
+			wantCode: "invalid_request",
+			wantLine: 1,
+		},
+		{
+			name:     "stream rejected",
+			input:    `{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"stream":true}}`,
+			wantCode: "invalid_request",
+			wantLine: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setMemoryFileStoreForTest(t)
+			store.files["file-input"] = []byte(tt.input)
+
+			lines, lineErr := parseBatchInput(&model.Batch{InputFileId: "file-input", Endpoint: endpoint})
+			if tt.wantCode == "" {
+				require.Nil(t, lineErr)
+				require.Len(t, lines, tt.wantCount)
+				return
+			}
+			require.NotNil(t, lineErr)
+			require.Equal(t, tt.wantCode, lineErr.Code)
+			if tt.wantLine > 0 {
+				require.NotNil(t, lineErr.Line)
+				require.Equal(t, tt.wantLine, *lineErr.Line)
+			}
+		})
+	}
+
+	t.Run("missing input file", func(t *testing.T) {
+		setMemoryFileStoreForTest(t)
+		_, lineErr := parseBatchInput(&model.Batch{InputFileId: "file-missing", Endpoint: endpoint})
+		require.NotNil(t, lineErr)
+		require.Equal(t, "invalid_file", lineErr.Code)
+	})
+}
+
+func TestWriteBatchResultFile(t *testing.T) {
+	setupTestDB(t, &model.File{})
+	store := setMemoryFileStoreForTest(t)
+	batch := &model.Batch{BatchId: "batch_1", UserId: 7}
+	results := []*dto.BatchResponseLine{
+		{ID: "batch_req_1", CustomID: "a", Response: &dto.BatchResponseBody{StatusCode: 200, Body: []byte(`{"id":"x"}`)}},
+		{ID: "batch_req_2", CustomID: "b", Error: &dto.BatchError{Code: "batch_cancelled", Message: "cancelled"}},
+	}
+
+	fileId, err := writeBatchResultFile(batch, "output", results)
+	require.NoError(t, err)
+	file, err := model.GetUserFile(7, fileId)
+	require.NoError(t, err)
+	require.Equal(t, "batch_1_output.jsonl", file.Filename)
+	require.Equal(t, model.FilePurposeBatchOutput, file.Purpose)
+	require.EqualValues(t, len(store.files[fileId]), file.Bytes)
+
+	lines := readBatchResultLines(t, store, fileId)
+	require.Len(t, lines, 2)
+	require.Equal(t, "a", lines[0].CustomID)
+	require.JSONEq(t, `{"id":"x"}`, string(lines[0].Response.Body))
+	require.Nil(t, lines[0].Error)
+	require.Equal(t, "b", lines[1].CustomID)
+	require.Equal(t, "batch_cancelled", lines[1].Error.Code)
+}
+
+func TestProcessBatch(t *testing.T) {
+	const input = `{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+{"custom_id":"b","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}`
+	tests := []struct {
+		name       string
+		input      string
+		cancel     bool
+		wantStatus string
+		wantTotal  int
+		wantFailed int
+		wantError  string
+	}{
+		// 批处理令牌不存在时每一行都返回 401，写入错误文件
+		{name: "line errors go to the error file", input: input, wantStatus: model.BatchStatusCompleted, wantTotal: 2, wantFailed: 2},
+		{name: "cancelled while running", input: input, cancel: true, wantStatus: model.BatchStatusCancelled, wantTotal: 2, wantFailed: 2},
+		{name: "invalid input fails the batch", input: "{not json}", wantStatus: model.BatchStatusFailed, wantError: "invalid_json_line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, &model.Batch{}, &model.File{}, &model.Token{})
+			store := setMemoryFileStoreForTest(t)
+			store.files["file-input"] = []byte(tt.input)
+			require.NoError(t, (&model.Batch{
+				BatchId:     "batch_1",
+				UserId:      1,
+				TokenId:     1,
+				Endpoint:    "/v1/chat/completions",
+				InputFileId: "file-input",
+				Status:      model.BatchStatusValidating,
+				ExpiresAt:   common.GetTimestamp() + 3600,
+			}).Insert())
+			batch, err := model.ClaimPendingBatch()
+			require.NoError(t, err)
+			if tt.cancel {
+				// 认领之后、写入总数之前被取消
+				require.NoError(t, model.CancelUserBatch(&model.Batch{Id: batch.Id, Status: model.BatchStatusInProgress}))
+			}
+
+			processBatch(batch)
+
+			var saved model.Batch
+			require.NoError(t, db.First(&saved, batch.Id).Error)
+			require.Equal(t, tt.wantStatus, saved.Status)
+			require.Equal(t, tt.wantTotal, saved.TotalCount)
+			require.Equal(t, tt.wantFailed, saved.FailedCount)
+			require.Empty(t, saved.OutputFileId)
+			require.Equal(t, tt.cancel, saved.CancellingAt != 0)
+			if tt.wantError != "" {
+				require.Contains(t, saved.Errors, tt.wantError)
+				require.Empty(t, saved.ErrorFileId)
+				return
+			}
+			lines := readBatchResultLines(t, store, saved.ErrorFileId)
+			require.Len(t, lines, tt.wantFailed)
+			customIds := make([]string, 0, len(lines))
+			for _, line := range lines {
+				customIds = append(customIds, line.CustomID)
+				require.NotNil(t, line.Response)
+				require.Equal(t, 401, line.Response.StatusCode)
+			}
+			require.ElementsMatch(t, []string{"a", "b"}, customIds)
+		})
+	}
+}
@@ -495,6 +495,15 @@ func SearchChannels(c *gin.Context) {
 	return
 }
 
+// GetChannelHealth 返回各渠道的熔断状态与延迟统计
+func GetChannelHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelHealthSnapshots(),
+	})
+}
+
 func GetChannel(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -791,6 +800,9 @@ func AddChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	for i := range channels {
+		service.RecordAudit(c, model.AuditActionCreate, model.AuditTargetChannel, channels[i].Id, nil, &channels[i])
+	}
 	service.ResetProxyClientCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -801,12 +813,14 @@ func AddChannel(c *gin.Context) {
 
 func DeleteChannel(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	origin, _ := model.GetChannelById(id, true)
 	channel := model.Channel{Id: id}
 	err := channel.Delete()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionDelete, model.AuditTargetChannel, id, origin, nil)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -821,6 +835,7 @@ func DeleteDisabledChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionDelete, model.AuditTargetChannel, "disabled", nil, gin.H{"deleted_count": rows})
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -857,6 +872,7 @@ func DisableTagChannels(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetChannelTag, channelTag.Tag, nil, gin.H{"status": common.ChannelStatusManuallyDisabled})
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -880,6 +896,7 @@ func EnableTagChannels(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetChannelTag, channelTag.Tag, nil, gin.H{"status": common.ChannelStatusEnabled})
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -932,6 +949,7 @@ func EditTagChannels(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetChannelTag, channelTag.Tag, nil, channelTag)
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -955,11 +973,20 @@ func DeleteChannelBatch(c *gin.Context) {
 		})
 		return
 	}
+	origins := make(map[int]*model.Channel, len(channelBatch.Ids))
+	for _, id := range channelBatch.Ids {
+		if origin, err := model.GetChannelById(id, true); err == nil {
+			origins[id] = origin
+		}
+	}
 	err = model.BatchDeleteChannels(channelBatch.Ids)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	for _, id := range channelBatch.Ids {
+		service.RecordAudit(c, model.AuditActionDelete, model.AuditTargetChannel, id, origins[id], nil)
+	}
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1094,6 +1121,13 @@ func UpdateChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	// 覆盖密钥后原有下标不再对应同一个 Key
+	if channel.Key != "" && (channel.KeyMode == nil || *channel.KeyMode != "append") {
+		model.RemoveChannelHealth(channel.Id, true)
+	}
+	if updated, err := model.GetChannelById(channel.Id, true); err == nil {
+		service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetChannel, channel.Id, originChannel, updated)
+	}
 	model.InitChannelCache()
 	service.ResetProxyClientCache()
 	channel.Key = ""
@@ -1241,6 +1275,9 @@ func BatchSetChannelTag(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	for _, id := range channelBatch.Ids {
+		service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetChannel, id, nil, gin.H{"tag": channelBatch.Tag})
+	}
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1332,10 +1369,13 @@ func CopyChannel(c *gin.Context) {
 	}
 
 	// insert
-	if err := model.BatchInsertChannels([]model.Channel{clone}); err != nil {
+	clones := []model.Channel{clone}
+	if err := model.BatchInsertChannels(clones); err != nil {
 		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
 		return
 	}
+	clone = clones[0]
+	service.RecordAudit(c, model.AuditActionCreate, model.AuditTargetChannel, clone.Id, nil, &clone)
 	model.InitChannelCache()
 	// success
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": gin.H{"id": clone.Id}})
@@ -1745,6 +1785,7 @@ func ManageMultiKeys(c *gin.Context) {
 			common.ApiError(c, err)
 			return
 		}
+		model.RemoveChannelHealth(channel.Id, true)
 
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
@@ -1813,6 +1854,7 @@ func ManageMultiKeys(c *gin.Context) {
 			common.ApiError(c, err)
 			return
 		}
+		model.RemoveChannelHealth(channel.Id, true)
 
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay"
+	relaychannel "github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelRequestConverter 将请求转换为渠道的上游格式
+type channelRequestConverter func(c *gin.Context, info *relaycommon.RelayInfo, adaptor relaychannel.Adaptor) (any, error)
+
+// doInternalChannelRequest 与渠道测试一样使用独立的上下文经渠道适配器发起内部请求，返回适配器写出的响应体，不写入客户端响应也不计费
+func doInternalChannelRequest(c *gin.Context, channelId int, path string, relayFormat types.RelayFormat, request dto.Request, modelName string, convert channelRequestConverter) ([]byte, error) {
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	w := httptest.NewRecorder()
+	innerCtx, _ := gin.CreateTestContext(w)
+	innerCtx.Request = (&http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: path},
+		Header: make(http.Header),
+	}).WithContext(ctx)
+	innerCtx.Request.Header.Set("Content-Type", "application/json")
+	innerCtx.Set(common.RequestIdKey, c.GetString(common.RequestIdKey))
+	if newAPIError := middleware.SetupContextForSelectedChannel(innerCtx, channel, modelName); newAPIError != nil {
+		return nil, newAPIError
+	}
+
+	info, err := relaycommon.GenRelayInfo(innerCtx, relayFormat, request, nil)
+	if err != nil {
+		return nil, err
+	}
+	info.InitChannelMeta(innerCtx)
+	if err := helper.ModelMappedHelper(innerCtx, info, request); err != nil {
+		return nil, err
+	}
+	adaptor := relay.GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return nil, fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+	convertedRequest, err := convert(innerCtx, info, adaptor)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := adaptor.DoRequest(innerCtx, info, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return nil, fmt.Errorf("%s request returned no response", path)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, service.RelayErrorHandler(ctx, httpResp, false)
+	}
+	if _, newAPIError := adaptor.DoResponse(innerCtx, httpResp, info); newAPIError != nil {
+		return nil, newAPIError
+	}
+	if w.Body.Len() == 0 {
+		return nil, errors.New("upstream response is empty")
+	}
+	return w.Body.Bytes(), nil
+}
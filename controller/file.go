@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func openAIErrorResponse(c *gin.Context, statusCode int, message string, errType string) {
+	c.JSON(statusCode, gin.H{
+		"error": types.OpenAIError{
+			Message: common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
+			Type:    errType,
+		},
+	})
+}
+
+func checkBatchEnabled(c *gin.Context) bool {
+	if !operation_setting.GetBatchSetting().Enabled {
+		openAIErrorResponse(c, http.StatusNotImplemented, "Files and Batch API are disabled", "new_api_error")
+		return false
+	}
+	return true
+}
+
+func getListLimit(c *gin.Context) int {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	return limit
+}
+
+func fileToOpenAIFile(file *model.File) dto.OpenAIFile {
+	return dto.OpenAIFile{
+		ID:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		ExpiresAt: file.ExpiresAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+}
+
+func fileExpiresAt() int64 {
+	days := operation_setting.GetBatchSetting().FileRetentionDays
+	if days <= 0 {
+		return 0
+	}
+	return time.Now().Add(time.Duration(days) * 24 * time.Hour).Unix()
+}
+
+// UploadFile POST /v1/files
+func UploadFile(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if purpose != model.FilePurposeBatch && purpose != model.FilePurposeUserData {
+		openAIErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("unsupported purpose: %s", purpose), "invalid_request_error")
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "file is required", "invalid_request_error")
+		return
+	}
+	maxBytes := int64(operation_setting.GetBatchSetting().MaxFileSizeMB) << 20
+	if maxBytes > 0 && header.Size > maxBytes {
+		openAIErrorResponse(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds %d MB", operation_setting.GetBatchSetting().MaxFileSizeMB), "invalid_request_error")
+		return
+	}
+	reader, err := header.Open()
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+	defer reader.Close()
+
+	file := &model.File{
+		FileId:    "file-" + common.GetUUID(),
+		UserId:    c.GetInt("id"),
+		Filename:  header.Filename,
+		Purpose:   purpose,
+		Status:    model.FileStatusProcessed,
+		ExpiresAt: fileExpiresAt(),
+	}
+	file.Bytes, err = service.GetFileStore().Put(file.FileId, reader)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to store file %s: %s", file.FileId, err.Error()))
+		openAIErrorResponse(c, http.StatusInternalServerError, "failed to store file", "server_error")
+		return
+	}
+	if err = file.Insert(); err != nil {
+		_ = service.GetFileStore().Delete(file.FileId)
+		openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error")
+		return
+	}
+	c.JSON(http.StatusOK, fileToOpenAIFile(file))
+}
+
+// ListFiles GET /v1/files
+func ListFiles(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	limit := getListLimit(c)
+	files, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"), c.Query("after"), limit+1)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error")
+		return
+	}
+	list := dto.OpenAIList[dto.OpenAIFile]{
+		Object: "list",
+		Data:   make([]dto.OpenAIFile, 0, len(files)),
+	}
+	if len(files) > limit {
+		files = files[:limit]
+		list.HasMore = true
+	}
+	for _, file := range files {
+		list.Data = append(list.Data, fileToOpenAIFile(file))
+	}
+	if len(list.Data) > 0 {
+		list.FirstID = list.Data[0].ID
+		list.LastID = list.Data[len(list.Data)-1].ID
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func getUserFileOrAbort(c *gin.Context) *model.File {
+	file, err := model.GetUserFile(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			openAIErrorResponse(c, http.StatusNotFound, fmt.Sprintf("No such File object: %s", c.Param("id")), "invalid_request_error")
+		} else {
+			openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error")
+		}
+		return nil
+	}
+	return file
+}
+
+// RetrieveFile GET /v1/files/:id
+func RetrieveFile(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	c.JSON(http.StatusOK, fileToOpenAIFile(file))
+}
+
+// DeleteFile DELETE /v1/files/:id
+func DeleteFile(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	if err := service.GetFileStore().Delete(file.FileId); err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error")
+		return
+	}
+	if err := model.DeleteUserFile(file.UserId, file.FileId); err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, err.Error(), "server_error")
+		return
+	}
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleted{
+		ID:      file.FileId,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+// GetFileContent GET /v1/files/:id/content
+func GetFileContent(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	reader, err := service.GetFileStore().Open(file.FileId)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusNotFound, "file content not found", "invalid_request_error")
+		return
+	}
+	defer reader.Close()
+	c.DataFromReader(http.StatusOK, file.Bytes, "application/octet-stream", reader, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}),
+	})
+}
+
+// CleanExpiredFiles 定期清理过期文件
+func CleanExpiredFiles() {
+	for {
+		time.Sleep(time.Hour)
+		if !operation_setting.GetBatchSetting().Enabled {
+			continue
+		}
+		files, err := model.GetExpiredFiles(common.GetTimestamp(), 1000)
+		if err != nil {
+			common.SysError("failed to query expired files: " + err.Error())
+			continue
+		}
+		for _, file := range files {
+			if err := service.GetFileStore().Delete(file.FileId); err != nil {
+				common.SysError(fmt.Sprintf("failed to delete expired file %s: %s", file.FileId, err.Error()))
+				continue
+			}
+			_ = model.DeleteFileById(file.Id)
+		}
+		if len(files) > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired files", len(files)))
+		}
+	}
+}
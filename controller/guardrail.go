@@ -0,0 +1,26 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaychannel "github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GuardrailModerate 经审核渠道的适配器调用 moderations 接口，返回上游的审核结果，不写入客户端响应也不计费
+func GuardrailModerate(c *gin.Context, text string) ([]byte, error) {
+	setting := operation_setting.GetGuardrailSetting()
+	if setting.ModerationChannelId == 0 {
+		return nil, errors.New("moderation channel is not configured")
+	}
+	request := &dto.GeneralOpenAIRequest{Model: setting.ModerationModel, Input: text}
+	return doInternalChannelRequest(c, setting.ModerationChannelId, "/v1/moderations", types.RelayFormatOpenAI, request, setting.ModerationModel,
+		func(c *gin.Context, info *relaycommon.RelayInfo, adaptor relaychannel.Adaptor) (any, error) {
+			return adaptor.ConvertOpenAIRequest(c, info, request)
+		})
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreditNoteRequest struct {
+	Amount      float64 `json:"amount"`
+	Reason      string  `json:"reason"`
+	DeductQuota int     `json:"deduct_quota"` // 同时扣回的额度，0 表示只开票不扣额度
+}
+
+func getInvoices(c *gin.Context, filter model.InvoiceFilter) {
+	pageInfo := common.GetPageQuery(c)
+	filter.Type = c.Query("type")
+	filter.SourceType = c.Query("source_type")
+	filter.Number = c.Query("number")
+	invoices, total, err := model.GetInvoices(filter, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(invoices)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// renderInvoice 按 format 参数输出 PDF 或 HTML，默认 PDF
+func renderInvoice(c *gin.Context, invoice *model.Invoice) {
+	if c.Query("format") == "html" {
+		content, err := service.RenderInvoiceHTML(invoice)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(content))
+		return
+	}
+	content, err := service.RenderInvoicePDF(invoice)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.Number))
+	c.Data(http.StatusOK, "application/pdf", content)
+}
+
+func GetSelfInvoices(c *gin.Context) {
+	getInvoices(c, model.InvoiceFilter{UserId: c.GetInt("id")})
+}
+
+func DownloadSelfInvoice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	invoice, err := model.GetInvoiceById(id)
+	if err != nil || invoice.UserId != c.GetInt("id") {
+		common.ApiErrorMsg(c, "发票不存在")
+		return
+	}
+	renderInvoice(c, invoice)
+}
+
+func GetAllInvoices(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	getInvoices(c, model.InvoiceFilter{UserId: userId})
+}
+
+func DownloadInvoice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	invoice, err := model.GetInvoiceById(id)
+	if err != nil {
+		common.ApiErrorMsg(c, "发票不存在")
+		return
+	}
+	renderInvoice(c, invoice)
+}
+
+// SendInvoice 重新发送发票邮件
+func SendInvoice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	invoice, err := model.GetInvoiceById(id)
+	if err != nil {
+		common.ApiErrorMsg(c, "发票不存在")
+		return
+	}
+	if invoice.BuyerEmail == "" {
+		common.ApiErrorMsg(c, "用户未绑定邮箱")
+		return
+	}
+	service.SendInvoiceEmail(invoice)
+	common.ApiSuccess(c, nil)
+}
+
+// CreateCreditNote 为退款开具红字发票，可同时扣回已发放的额度
+func CreateCreditNote(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req CreditNoteRequest
+	if err = c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	creditNote, err := model.IssueCreditNote(id, req.Amount, req.Reason, req.DeductQuota)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, model.AuditActionCreate, model.AuditTargetInvoice, creditNote.Id, nil, creditNote)
+	service.DeliverCreditNote(creditNote)
+	common.ApiSuccess(c, creditNote)
+}
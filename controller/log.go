@@ -167,3 +167,13 @@ func DeleteHistoryLogs(c *gin.Context) {
 	})
 	return
 }
+
+// GetCapturedPayloads 查看日志对应请求采集的内容
+func GetCapturedPayloads(c *gin.Context) {
+	payloads, err := model.GetCapturedPayloadsByRequestId(c.Param("request_id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, payloads)
+}
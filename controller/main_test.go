@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"os"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// 测试不连接 Redis，异步通知或缓存更新可能在单个测试恢复全局设置后才执行
+	common.RedisEnabled = false
+	os.Exit(m.Run())
+}
+
+// setupTestDB 使用内存 SQLite 替换 model 的全局 DB，测试结束后恢复；Redis 由 TestMain 统一关闭，
+// 不在此恢复，避免与请求结束后仍在执行的异步缓存更新竞争
+func setupTestDB(t *testing.T, models ...any) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(models...))
+
+	origDB, origLogDB := model.DB, model.LOG_DB
+	origSQLite := common.UsingSQLite
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB = origDB, origLogDB
+		common.UsingSQLite = origSQLite
+	})
+	model.DB, model.LOG_DB = db, db
+	common.UsingSQLite = true
+	return db
+}
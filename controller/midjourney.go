@@ -13,6 +13,7 @@ import (
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
@@ -138,6 +139,7 @@ func UpdateMidjourneyTaskBulk() {
 				task.StartTime = responseItem.StartTime
 				task.FinishTime = responseItem.FinishTime
 				task.ImageUrl = responseItem.ImageUrl
+				preStatus := task.Status
 				task.Status = responseItem.Status
 				task.FailReason = responseItem.FailReason
 				if responseItem.Properties != nil {
@@ -177,13 +179,19 @@ func UpdateMidjourneyTaskBulk() {
 					logger.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
 				} else {
 					if shouldReturnQuota {
-						err = model.IncreaseUserQuota(task.UserId, task.Quota, false)
+						err = model.IncreaseBillingQuota(task.UserId, task.ProjectId, task.BillingCharge(), model.QuotaLedgerRef{Type: model.QuotaLedgerTypeRefund, ReferenceId: task.MjId})
 						if err != nil {
 							logger.LogError(ctx, "fail to increase user quota: "+err.Error())
 						}
 						logContent := fmt.Sprintf("构图失败 %s，补偿 %s", task.MjId, logger.LogQuota(task.Quota))
 						model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
 					}
+					if preStatus != task.Status && (task.Status == "SUCCESS" || task.Status == "FAILURE") {
+						if task.Status == "SUCCESS" {
+							archiveMidjourneyTask(task)
+						}
+						service.NotifyMidjourneyFinished(task, relay.MidjourneyModel2Dto(task))
+					}
 				}
 			}
 		}
@@ -133,7 +133,7 @@ func ListModels(c *gin.Context, modelType int) {
 			tokenModelLimit = map[string]bool{}
 		}
 		for allowModel, _ := range tokenModelLimit {
-			if !acceptUnsetRatioModel {
+			if !acceptUnsetRatioModel && operation_setting.GetVirtualModel(allowModel) == nil {
 				_, _, exist := ratio_setting.GetModelRatioOrPrice(allowModel)
 				if !exist {
 					continue
@@ -141,6 +141,7 @@ func ListModels(c *gin.Context, modelType int) {
 			}
 			if oaiModel, ok := openAIModelsMap[allowModel]; ok {
 				oaiModel.SupportedEndpointTypes = model.GetModelSupportEndpointTypes(allowModel)
+				oaiModel.ModelCapabilities = model.GetModelCapabilities(allowModel)
 				userOpenAiModels = append(userOpenAiModels, oaiModel)
 			} else {
 				userOpenAiModels = append(userOpenAiModels, dto.OpenAIModels{
@@ -149,6 +150,7 @@ func ListModels(c *gin.Context, modelType int) {
 					Created:                1626777600,
 					OwnedBy:                "custom",
 					SupportedEndpointTypes: model.GetModelSupportEndpointTypes(allowModel),
+					ModelCapabilities:      model.GetModelCapabilities(allowModel),
 				})
 			}
 		}
@@ -189,6 +191,7 @@ func ListModels(c *gin.Context, modelType int) {
 			}
 			if oaiModel, ok := openAIModelsMap[modelName]; ok {
 				oaiModel.SupportedEndpointTypes = model.GetModelSupportEndpointTypes(modelName)
+				oaiModel.ModelCapabilities = model.GetModelCapabilities(modelName)
 				userOpenAiModels = append(userOpenAiModels, oaiModel)
 			} else {
 				userOpenAiModels = append(userOpenAiModels, dto.OpenAIModels{
@@ -197,9 +200,28 @@ func ListModels(c *gin.Context, modelType int) {
 					Created:                1626777600,
 					OwnedBy:                "custom",
 					SupportedEndpointTypes: model.GetModelSupportEndpointTypes(modelName),
+					ModelCapabilities:      model.GetModelCapabilities(modelName),
 				})
 			}
 		}
+		// 回退链中任一模型可用时展示虚拟模型
+		virtualModelSetting := operation_setting.GetVirtualModelSetting()
+		for _, vm := range virtualModelSetting.Models {
+			if !virtualModelSetting.Enabled {
+				break
+			}
+			for _, step := range vm.Steps {
+				if common.StringsContains(models, step.Model) {
+					userOpenAiModels = append(userOpenAiModels, dto.OpenAIModels{
+						Id:      vm.Name,
+						Object:  "model",
+						Created: 1626777600,
+						OwnedBy: "custom",
+					})
+					break
+				}
+			}
+		}
 	}
 
 	switch modelType {
@@ -226,6 +248,12 @@ func ListModels(c *gin.Context, modelType int) {
 				Name:        model.Id,
 				DisplayName: model.Id,
 			}
+			if model.ContextWindow > 0 {
+				userGeminiModels[i].InputTokenLimit = model.ContextWindow
+			}
+			if model.MaxOutputTokens > 0 {
+				userGeminiModels[i].OutputTokenLimit = model.MaxOutputTokens
+			}
 		}
 		c.JSON(200, gin.H{
 			"models":        userGeminiModels,
@@ -273,6 +301,7 @@ func RetrieveModel(c *gin.Context, modelType int) {
 				Type:        "model",
 			})
 		default:
+			aiModel.ModelCapabilities = model.GetModelCapabilities(modelId)
 			c.JSON(200, aiModel)
 		}
 	} else {
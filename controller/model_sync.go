@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
 
 	"github.com/gin-gonic/gin"
@@ -64,6 +65,8 @@ type upstreamModel struct {
 	Status      int             `json:"status"`
 	Tags        string          `json:"tags"`
 	VendorName  string          `json:"vendor_name"`
+
+	dto.ModelCapabilities
 }
 
 type upstreamVendor struct {
@@ -366,6 +369,8 @@ func SyncUpstreamModels(c *gin.Context) {
 			VendorID:    vendorID,
 			Status:      chooseStatus(up.Status, 1),
 			NameRule:    up.NameRule,
+
+			ModelCapabilities: up.ModelCapabilities,
 		}
 		if err := mi.Insert(); err == nil {
 			createdModels++
@@ -423,6 +428,10 @@ func SyncUpstreamModels(c *gin.Context) {
 					local.Status = chooseStatus(up.Status, local.Status)
 					needUpdate = true
 				}
+				if containsField(ow.Fields, "capabilities") {
+					local.ModelCapabilities = up.ModelCapabilities
+					needUpdate = true
+				}
 				if !needUpdate {
 					return nil
 				}
@@ -454,6 +463,12 @@ func SyncUpstreamModels(c *gin.Context) {
 	})
 }
 
+func capabilitiesEqual(a, b dto.ModelCapabilities) bool {
+	aJson, _ := common.Marshal(a)
+	bJson, _ := common.Marshal(b)
+	return string(aJson) == string(bJson)
+}
+
 func containsField(fields []string, key string) bool {
 	key = strings.ToLower(strings.TrimSpace(key))
 	for _, f := range fields {
@@ -600,6 +615,10 @@ func SyncUpstreamPreview(c *gin.Context) {
 		if local.Status != chooseStatus(up.Status, local.Status) {
 			fields = append(fields, conflictField{Field: "status", Local: local.Status, Upstream: up.Status})
 		}
+		// 上游未提供能力信息时不视为冲突，避免覆盖本地配置
+		if up.ModelCapabilities != (dto.ModelCapabilities{}) && !capabilitiesEqual(local.ModelCapabilities, up.ModelCapabilities) {
+			fields = append(fields, conflictField{Field: "capabilities", Local: local.ModelCapabilities, Upstream: up.ModelCapabilities})
+		}
 		if len(fields) > 0 {
 			conflicts = append(conflicts, conflictItem{ModelName: local.ModelName, Fields: fields})
 		}
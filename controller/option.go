@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
@@ -21,11 +20,7 @@ func GetOptions(c *gin.Context) {
 	var options []*model.Option
 	common.OptionMapRWMutex.Lock()
 	for k, v := range common.OptionMap {
-		if strings.HasSuffix(k, "Token") ||
-			strings.HasSuffix(k, "Secret") ||
-			strings.HasSuffix(k, "Key") ||
-			strings.HasSuffix(k, "secret") ||
-			strings.HasSuffix(k, "api_key") {
+		if model.IsSecretOption(k) {
 			continue
 		}
 		options = append(options, &model.Option{
@@ -142,6 +137,15 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "TieredRatio":
+		err = ratio_setting.UpdateTieredRatioByJSONString(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "分级倍率设置失败: " + err.Error(),
+			})
+			return
+		}
 	case "ImageRatio":
 		err = ratio_setting.UpdateImageRatioByJSONString(option.Value.(string))
 		if err != nil {
@@ -214,6 +218,33 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "guardrail_setting.rules":
+		err = operation_setting.ValidateGuardrailRules(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "routing_setting.rules":
+		err = operation_setting.ValidateRoutingRules(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "virtual_model_setting.models":
+		err = operation_setting.ValidateVirtualModels(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	case "console_setting.uptime_kuma_groups":
 		err = console_setting.ValidateConsoleSettings(option.Value.(string), "UptimeKumaGroups")
 		if err != nil {
@@ -224,11 +255,13 @@ func UpdateOption(c *gin.Context) {
 			return
 		}
 	}
+	before := getOptionValue(option.Key)
 	err = model.UpdateOption(option.Key, option.Value.(string))
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordOptionAudit(c, option.Key, before, option.Value.(string))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
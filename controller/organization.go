@@ -0,0 +1,468 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOrgMember 获取当前用户在组织中的成员信息，并校验角色不低于 required
+func getOrgMember(c *gin.Context, required string) (*model.OrganizationMember, bool) {
+	orgId, _ := strconv.Atoi(c.Param("id"))
+	member, err := model.GetOrganizationMember(orgId, c.GetInt("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "组织不存在或您不是该组织的成员")
+		return nil, false
+	}
+	if !member.HasRole(required) {
+		common.ApiErrorMsg(c, "无权进行此操作")
+		return nil, false
+	}
+	return member, true
+}
+
+// canManageOrgMember 拥有者可管理所有成员，管理员只能管理角色低于自己的成员
+func canManageOrgMember(operator *model.OrganizationMember, target *model.OrganizationMember) bool {
+	if operator.Role == model.OrgRoleOwner {
+		return true
+	}
+	return !target.HasRole(operator.Role)
+}
+
+func GetSelfOrganizations(c *gin.Context) {
+	orgs, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, orgs)
+}
+
+func CreateOrganization(c *gin.Context) {
+	var req model.Organization
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Name == "" || len(req.Name) > 64 {
+		common.ApiErrorMsg(c, "组织名称不能为空且长度不能超过 64")
+		return
+	}
+	org := model.Organization{
+		Name:        req.Name,
+		Description: req.Description,
+		Status:      common.OrganizationStatusEnabled,
+	}
+	if err := model.CreateOrganization(&org, c.GetInt("id")); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, &org)
+}
+
+func GetOrganization(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	org, err := model.GetOrganizationById(member.OrgId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, model.UserOrganization{Organization: *org, Role: member.Role})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req model.Organization
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Name == "" || len(req.Name) > 64 {
+		common.ApiErrorMsg(c, "组织名称不能为空且长度不能超过 64")
+		return
+	}
+	org, err := model.GetOrganizationById(member.OrgId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Status != 0 && req.Status != org.Status {
+		if member.Role != model.OrgRoleOwner {
+			common.ApiErrorMsg(c, "只有组织拥有者可以启用或禁用组织")
+			return
+		}
+		org.Status = req.Status
+	}
+	org.Name = req.Name
+	org.Description = req.Description
+	if err := org.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
+
+func DeleteOrganization(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	if err := model.DeleteOrganizationById(member.OrgId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	orgs, total, err := model.GetAllOrganizations(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(orgs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+type UpdateOrganizationQuotaRequest struct {
+	Quota int `json:"quota"`
+}
+
+// UpdateOrganizationQuota 管理员设置组织的剩余额度
+func UpdateOrganizationQuota(c *gin.Context) {
+	orgId, _ := strconv.Atoi(c.Param("id"))
+	var req UpdateOrganizationQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.UpdateOrganizationQuota(org.Id, req.Quota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("管理员将组织 %s 的额度从 %s修改为 %s", org.Name, logger.LogQuota(org.Quota), logger.LogQuota(req.Quota)))
+	service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetOrg, org.Id, gin.H{"quota": org.Quota}, gin.H{"quota": req.Quota})
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	members, err := model.GetOrganizationMembers(member.OrgId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, members)
+}
+
+type OrganizationMemberRequest struct {
+	UserId     int    `json:"user_id"`
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	QuotaLimit int    `json:"quota_limit"`
+}
+
+func AddOrganizationMember(c *gin.Context) {
+	operator, ok := getOrgMember(c, model.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req OrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !model.IsValidOrgRole(req.Role) || !model.OrgRoleAtLeast(operator.Role, req.Role) {
+		common.ApiErrorMsg(c, "无效的成员角色")
+		return
+	}
+	if req.QuotaLimit < 0 {
+		common.ApiErrorMsg(c, "额度上限不能为负数")
+		return
+	}
+	userId, err := model.GetUserIdByUsername(req.Username)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetOrganizationMember(operator.OrgId, userId); err == nil {
+		common.ApiErrorMsg(c, "该用户已是组织成员")
+		return
+	}
+	member := model.OrganizationMember{
+		OrgId:      operator.OrgId,
+		UserId:     userId,
+		Role:       req.Role,
+		QuotaLimit: req.QuotaLimit,
+	}
+	if err := member.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, &member)
+}
+
+func UpdateOrganizationMember(c *gin.Context) {
+	operator, ok := getOrgMember(c, model.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req OrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !model.IsValidOrgRole(req.Role) || !model.OrgRoleAtLeast(operator.Role, req.Role) {
+		common.ApiErrorMsg(c, "无效的成员角色")
+		return
+	}
+	if req.QuotaLimit < 0 {
+		common.ApiErrorMsg(c, "额度上限不能为负数")
+		return
+	}
+	member, err := model.GetOrganizationMember(operator.OrgId, req.UserId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !canManageOrgMember(operator, member) {
+		common.ApiErrorMsg(c, "无权修改该成员")
+		return
+	}
+	if member.Role == model.OrgRoleOwner && req.Role != model.OrgRoleOwner {
+		if count, err := model.CountOrganizationOwners(operator.OrgId); err != nil || count <= 1 {
+			common.ApiErrorMsg(c, "组织至少需要保留一名拥有者")
+			return
+		}
+	}
+	member.Role = req.Role
+	member.QuotaLimit = req.QuotaLimit
+	if err := member.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, member)
+}
+
+// DeleteOrganizationMember 移除成员，成员也可以主动退出组织
+func DeleteOrganizationMember(c *gin.Context) {
+	operator, ok := getOrgMember(c, model.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	member, err := model.GetOrganizationMember(operator.OrgId, userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if member.UserId != operator.UserId && (!operator.HasRole(model.OrgRoleAdmin) || !canManageOrgMember(operator, member)) {
+		common.ApiErrorMsg(c, "无权移除该成员")
+		return
+	}
+	if member.Role == model.OrgRoleOwner {
+		if count, err := model.CountOrganizationOwners(operator.OrgId); err != nil || count <= 1 {
+			common.ApiErrorMsg(c, "组织至少需要保留一名拥有者")
+			return
+		}
+	}
+	if err := member.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationProjects(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	projects, err := model.GetOrganizationProjects(member.OrgId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, projects)
+}
+
+func validateProject(c *gin.Context, project *model.Project) bool {
+	if project.Name == "" || len(project.Name) > 64 {
+		common.ApiErrorMsg(c, "项目名称不能为空且长度不能超过 64")
+		return false
+	}
+	if project.QuotaMode == "" {
+		project.QuotaMode = model.ProjectQuotaModeOrg
+	}
+	if project.QuotaMode != model.ProjectQuotaModeOrg && project.QuotaMode != model.ProjectQuotaModeProject {
+		common.ApiErrorMsg(c, "无效的额度模式")
+		return false
+	}
+	return true
+}
+
+// getOrgProject 获取组织下的项目，防止跨组织访问
+func getOrgProject(c *gin.Context, orgId int, projectId int) (*model.Project, bool) {
+	project, err := model.GetProjectById(projectId)
+	if err != nil || project.OrgId != orgId {
+		common.ApiErrorMsg(c, "项目不存在")
+		return nil, false
+	}
+	return project, true
+}
+
+func CreateProject(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req model.Project
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !validateProject(c, &req) {
+		return
+	}
+	project := model.Project{
+		OrgId:       member.OrgId,
+		Name:        req.Name,
+		Description: req.Description,
+		QuotaMode:   req.QuotaMode,
+		Status:      common.OrganizationStatusEnabled,
+	}
+	if err := project.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, &project)
+}
+
+func UpdateProject(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req model.Project
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !validateProject(c, &req) {
+		return
+	}
+	project, ok := getOrgProject(c, member.OrgId, req.Id)
+	if !ok {
+		return
+	}
+	project.Name = req.Name
+	project.Description = req.Description
+	project.QuotaMode = req.QuotaMode
+	if req.Status != 0 {
+		project.Status = req.Status
+	}
+	if err := project.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, project)
+}
+
+// DeleteProject 删除项目，项目剩余的独立额度退回组织
+func DeleteProject(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	projectId, _ := strconv.Atoi(c.Param("project_id"))
+	project, ok := getOrgProject(c, member.OrgId, projectId)
+	if !ok {
+		return
+	}
+	if project.Quota > 0 {
+		if err := model.TransferProjectQuota(project, -project.Quota); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if err := project.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+type TransferProjectQuotaRequest struct {
+	Quota int `json:"quota"` // 为正时从组织划入项目，为负时退回组织
+}
+
+func TransferProjectQuota(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req TransferProjectQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	projectId, _ := strconv.Atoi(c.Param("project_id"))
+	project, ok := getOrgProject(c, member.OrgId, projectId)
+	if !ok {
+		return
+	}
+	if project.UseOrgQuota() {
+		common.ApiErrorMsg(c, "项目使用组织共享额度，无需划拨")
+		return
+	}
+	if err := model.TransferProjectQuota(project, req.Quota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationLogs(c *gin.Context) {
+	member, ok := getOrgMember(c, model.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	username := c.Query("username")
+	tokenName := c.Query("token_name")
+	modelName := c.Query("model_name")
+	projectId, _ := strconv.Atoi(c.Query("project_id"))
+	logs, total, err := model.GetOrgLogs(member.OrgId, logType, startTimestamp, endTimestamp, modelName, username, tokenName, projectId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(logs)
+	common.ApiSuccess(c, pageInfo)
+}
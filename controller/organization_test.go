@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// setupOrganizationControllerTest 创建组织 1 及各角色成员：用户 1 拥有者、2 管理员、3 成员、4 观察者，用户 5 不在组织中
+func setupOrganizationControllerTest(t *testing.T) {
+	db := setupTestDB(t, &model.User{}, &model.Token{}, &model.QuotaLedger{},
+		&model.Organization{}, &model.OrganizationMember{}, &model.Project{})
+	require.NoError(t, db.Create(&model.Organization{Id: 1, Name: "org", Quota: 500, Status: common.OrganizationStatusEnabled}).Error)
+	roles := []string{model.OrgRoleOwner, model.OrgRoleAdmin, model.OrgRoleMember, model.OrgRoleViewer}
+	for i, role := range roles {
+		userId := i + 1
+		require.NoError(t, db.Create(&model.User{Id: userId, Username: "u" + strconv.Itoa(userId), AffCode: "aff" + strconv.Itoa(userId)}).Error)
+		require.NoError(t, db.Create(&model.OrganizationMember{OrgId: 1, UserId: userId, Role: role}).Error)
+	}
+	require.NoError(t, db.Create(&model.User{Id: 5, Username: "u5", AffCode: "aff5"}).Error)
+	require.NoError(t, db.Create(&model.Project{Id: 1, OrgId: 1, Name: "p1", QuotaMode: model.ProjectQuotaModeProject, Status: common.OrganizationStatusEnabled}).Error)
+}
+
+// callOrganizationHandler 以指定用户调用组织接口，返回响应中的 success 与 message
+func callOrganizationHandler(t *testing.T, handler gin.HandlerFunc, userId int, params gin.Params, body string) (bool, string) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/organization", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = append(gin.Params{{Key: "id", Value: "1"}}, params...)
+	c.Set("id", userId)
+	handler(c)
+	var resp struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	require.NoError(t, common.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Success, resp.Message
+}
+
+func TestOrganizationRoleChecks(t *testing.T) {
+	memberParam := func(userId int) gin.Params {
+		return gin.Params{{Key: "user_id", Value: strconv.Itoa(userId)}}
+	}
+	projectParam := gin.Params{{Key: "project_id", Value: "1"}}
+	tests := []struct {
+		name        string
+		handler     gin.HandlerFunc
+		userId      int
+		params      gin.Params
+		body        string
+		wantSuccess bool
+		wantMessage string
+	}{
+		{name: "viewer reads organization", handler: GetOrganization, userId: 4, wantSuccess: true},
+		{name: "outsider cannot read organization", handler: GetOrganization, userId: 5, wantMessage: "组织不存在或您不是该组织的成员"},
+		{name: "member cannot update organization", handler: UpdateOrganization, userId: 3, body: `{"name":"new"}`, wantMessage: "无权进行此操作"},
+		{name: "admin updates organization", handler: UpdateOrganization, userId: 2, body: `{"name":"new"}`, wantSuccess: true},
+		{name: "admin cannot disable organization", handler: UpdateOrganization, userId: 2, body: `{"name":"new","status":2}`, wantMessage: "只有组织拥有者可以启用或禁用组织"},
+		{name: "admin cannot delete organization", handler: DeleteOrganization, userId: 2, wantMessage: "无权进行此操作"},
+		{name: "admin cannot add an owner", handler: AddOrganizationMember, userId: 2, body: `{"username":"u5","role":"owner"}`, wantMessage: "无效的成员角色"},
+		{name: "admin adds a member", handler: AddOrganizationMember, userId: 2, body: `{"username":"u5","role":"member"}`, wantSuccess: true},
+		{name: "member cannot add members", handler: AddOrganizationMember, userId: 3, body: `{"username":"u5","role":"viewer"}`, wantMessage: "无权进行此操作"},
+		{name: "admin cannot change another admin", handler: UpdateOrganizationMember, userId: 2, body: `{"user_id":2,"role":"member"}`, wantMessage: "无权修改该成员"},
+		{name: "admin changes a member", handler: UpdateOrganizationMember, userId: 2, body: `{"user_id":3,"role":"viewer"}`, wantSuccess: true},
+		{name: "last owner cannot be demoted", handler: UpdateOrganizationMember, userId: 1, body: `{"user_id":1,"role":"admin"}`, wantMessage: "组织至少需要保留一名拥有者"},
+		{name: "viewer leaves organization", handler: DeleteOrganizationMember, userId: 4, params: memberParam(4), wantSuccess: true},
+		{name: "viewer cannot remove others", handler: DeleteOrganizationMember, userId: 4, params: memberParam(3), wantMessage: "无权移除该成员"},
+		{name: "admin cannot remove the owner", handler: DeleteOrganizationMember, userId: 2, params: memberParam(1), wantMessage: "无权移除该成员"},
+		{name: "last owner cannot leave", handler: DeleteOrganizationMember, userId: 1, params: memberParam(1), wantMessage: "组织至少需要保留一名拥有者"},
+		{name: "member cannot create projects", handler: CreateProject, userId: 3, body: `{"name":"p2"}`, wantMessage: "无权进行此操作"},
+		{name: "member cannot transfer quota", handler: TransferProjectQuota, userId: 3, params: projectParam, body: `{"quota":100}`, wantMessage: "无权进行此操作"},
+		{name: "admin transfers quota", handler: TransferProjectQuota, userId: 2, params: projectParam, body: `{"quota":100}`, wantSuccess: true},
+		{name: "viewer cannot delete projects", handler: DeleteProject, userId: 4, params: projectParam, wantMessage: "无权进行此操作"},
+		{name: "admin deletes project", handler: DeleteProject, userId: 2, params: projectParam, wantSuccess: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupOrganizationControllerTest(t)
+			success, message := callOrganizationHandler(t, tt.handler, tt.userId, tt.params, tt.body)
+			require.Equal(t, tt.wantSuccess, success, message)
+			if tt.wantMessage != "" {
+				require.Equal(t, tt.wantMessage, message)
+			}
+		})
+	}
+}
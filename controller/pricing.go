@@ -51,6 +51,7 @@ func GetPricing(c *gin.Context) {
 
 func ResetModelRatio(c *gin.Context) {
 	defaultStr := ratio_setting.DefaultModelRatio2JSONString()
+	before := getOptionValue("ModelRatio")
 	err := model.UpdateOption("ModelRatio", defaultStr)
 	if err != nil {
 		c.JSON(200, gin.H{
@@ -67,6 +68,7 @@ func ResetModelRatio(c *gin.Context) {
 		})
 		return
 	}
+	recordOptionAudit(c, "ModelRatio", before, defaultStr)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "重置模型倍率成功",
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getQuotaLedgerFilter(c *gin.Context) model.QuotaLedgerFilter {
+	subjectId, _ := strconv.Atoi(c.Query("subject_id"))
+	startTime, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTime, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return model.QuotaLedgerFilter{
+		Subject:     c.Query("subject"),
+		SubjectId:   subjectId,
+		EntryType:   c.Query("entry_type"),
+		ReferenceId: c.Query("reference_id"),
+		StartTime:   startTime,
+		EndTime:     endTime,
+	}
+}
+
+func getQuotaLedgers(c *gin.Context, filter model.QuotaLedgerFilter) {
+	pageInfo := common.GetPageQuery(c)
+	ledgers, total, err := model.GetQuotaLedgers(filter, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(ledgers)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetSelfQuotaLedgers 分页查询当前用户及其令牌的额度流水
+func GetSelfQuotaLedgers(c *gin.Context) {
+	filter := getQuotaLedgerFilter(c)
+	filter.UserId = c.GetInt("id")
+	getQuotaLedgers(c, filter)
+}
+
+// GetAllQuotaLedgers 管理员分页查询额度流水
+func GetAllQuotaLedgers(c *gin.Context) {
+	filter := getQuotaLedgerFilter(c)
+	filter.UserId, _ = strconv.Atoi(c.Query("user_id"))
+	getQuotaLedgers(c, filter)
+}
+
+// GetQuotaReconcileReport 获取最近一次对账结果
+func GetQuotaReconcileReport(c *gin.Context) {
+	common.ApiSuccess(c, service.GetLastQuotaReconcileReport())
+}
+
+// RunQuotaReconcile 立即对账，since 为空时检查最近 24 小时有变动的用户和令牌
+func RunQuotaReconcile(c *gin.Context) {
+	since, _ := strconv.ParseInt(c.Query("since"), 10, 64)
+	if since <= 0 {
+		since = common.GetTimestamp() - 24*60*60
+	}
+	report, err := service.ReconcileQuota(since)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, report)
+}
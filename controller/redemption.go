@@ -8,6 +8,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -110,6 +111,7 @@ func AddRedemption(c *gin.Context) {
 			})
 			return
 		}
+		service.RecordAudit(c, model.AuditActionCreate, model.AuditTargetRedemption, cleanRedemption.Id, nil, &cleanRedemption)
 		keys = append(keys, key)
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -122,11 +124,13 @@ func AddRedemption(c *gin.Context) {
 
 func DeleteRedemption(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	origin, _ := model.GetRedemptionById(id)
 	err := model.DeleteRedemptionById(id)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionDelete, model.AuditTargetRedemption, id, origin, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -147,6 +151,7 @@ func UpdateRedemption(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	origin := *cleanRedemption
 	if statusOnly == "" {
 		if err := validateExpiredTime(redemption.ExpiredTime); err != nil {
 			c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
@@ -165,6 +170,7 @@ func UpdateRedemption(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetRedemption, cleanRedemption.Id, &origin, cleanRedemption)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -179,6 +185,7 @@ func DeleteInvalidRedemption(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionDelete, model.AuditTargetRedemption, "invalid", nil, gin.H{"deleted_count": rows})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
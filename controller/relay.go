@@ -8,8 +8,10 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
@@ -27,6 +29,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func relayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
@@ -114,12 +117,35 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		return
 	}
 
+	if relayFormat == types.RelayFormatGemini && strings.Contains(c.Request.URL.Path, ":countTokens") {
+		newAPIError = relay.GeminiCountTokensHelper(c, request)
+		return
+	}
+
+	redacted, guardrailErr := service.CheckPromptGuardrail(c, request)
+	if guardrailErr != nil {
+		newAPIError = guardrailErr
+		return
+	}
+	if redacted {
+		// 护栏改写了请求体，重新解析
+		request, err = helper.GetAndValidateRequest(c, relayFormat)
+		if err != nil {
+			newAPIError = types.NewError(err, types.ErrorCodeInvalidRequest)
+			return
+		}
+	}
+
 	relayInfo, err := relaycommon.GenRelayInfo(c, relayFormat, request, ws)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
 		return
 	}
 
+	defer func() {
+		recordRelayMetrics(c, relayInfo, newAPIError)
+	}()
+
 	needSensitiveCheck := setting.ShouldCheckPromptSensitive()
 	needCountToken := constant.CountToken
 	// Avoid building huge CombineText (strings.Join) when token counting and sensitive check are both disabled.
@@ -139,7 +165,10 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}
 
+	_, estimateSpan := common.StartSpan(c, "relay.estimate_tokens")
 	tokens, err := service.EstimateRequestToken(c, meta, relayInfo)
+	estimateSpan.SetAttributes(attribute.Int("prompt_tokens", tokens))
+	estimateSpan.End()
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeCountTokenFailed)
 		return
@@ -147,21 +176,21 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	relayInfo.SetEstimatePromptTokens(tokens)
 
-	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
-	if err != nil {
-		newAPIError = types.NewError(err, types.ErrorCodeModelPriceError)
+	// 按模型能力拒绝超长提示词、截断 max_tokens 并移除不支持的参数，需在预扣费前完成
+	newAPIError = service.ApplyModelCapabilities(c, relayInfo, request, meta)
+	if newAPIError != nil {
 		return
 	}
 
-	// common.SetContextKey(c, constant.ContextKeyTokenCountMeta, meta)
+	newAPIError = service.CheckUsageTokenLimit(c, tokens)
+	if newAPIError != nil {
+		return
+	}
+	defer service.SettleUsageTokenLimit(c, tokens)
 
-	if priceData.FreeModel {
-		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
-	} else {
-		newAPIError = service.PreConsumeQuota(c, priceData.QuotaToPreConsume, relayInfo)
-		if newAPIError != nil {
-			return
-		}
+	newAPIError = preConsumeModelQuota(c, relayInfo, tokens, meta)
+	if newAPIError != nil {
+		return
 	}
 
 	defer func() {
@@ -171,52 +200,107 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
-	retryParam := &service.RetryParam{
-		Ctx:        c,
-		TokenGroup: relayInfo.TokenGroup,
-		ModelName:  relayInfo.OriginModelName,
-		Retry:      common.GetPointer(0),
+	// 响应缓存命中时直接返回，不请求上游
+	responseCache := service.NewResponseCache(c, relayInfo, request, responseCacheEmbedding)
+	if responseCache != nil {
+		if entry := responseCache.Lookup(c); entry != nil && entry.Replayable(relayInfo.IsStream, relayInfo.RelayMode) {
+			newAPIError = relay.ResponseCacheHelper(c, relayInfo, entry)
+			return
+		}
 	}
 
-	for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
-		channel, channelErr := getChannel(c, relayInfo, retryParam)
-		if channelErr != nil {
-			logger.LogError(c, channelErr.Error())
-			newAPIError = channelErr
-			break
+	payloadCapture := service.NewPayloadCapture()
+	if payloadCapture != nil {
+		defer func() {
+			payloadCapture.Save(c, relayInfo, newAPIError)
+		}()
+	}
+
+	for {
+		retryParam := &service.RetryParam{
+			Ctx:        c,
+			TokenGroup: relayInfo.TokenGroup,
+			ModelName:  relayInfo.OriginModelName,
+			Retry:      common.GetPointer(0),
 		}
+		service.ApplyRoutingRetryParam(c, retryParam)
+
+		for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
+			channel, channelErr := getChannel(c, relayInfo, retryParam)
+			if channelErr != nil {
+				logger.LogError(c, channelErr.Error())
+				newAPIError = channelErr
+				break
+			}
 
-		addUsedChannel(c, channel.Id)
-		requestBody, bodyErr := common.GetRequestBody(c)
-		if bodyErr != nil {
-			// Ensure consistent 413 for oversized bodies even when error occurs later (e.g., retry path)
-			if common.IsRequestBodyTooLargeError(bodyErr) || errors.Is(bodyErr, common.ErrRequestBodyTooLarge) {
-				newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry())
-			} else {
-				newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+			addUsedChannel(c, channel.Id)
+			requestBody, bodyErr := common.GetRequestBody(c)
+			if bodyErr != nil {
+				// Ensure consistent 413 for oversized bodies even when error occurs later (e.g., retry path)
+				if common.IsRequestBodyTooLargeError(bodyErr) || errors.Is(bodyErr, common.ErrRequestBodyTooLarge) {
+					newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry())
+				} else {
+					newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+				}
+				break
 			}
-			break
-		}
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
-		}
+			if responseCache != nil {
+				responseCache.Capture(c)
+			}
+			if payloadCapture != nil {
+				payloadCapture.Begin(c, relayInfo)
+			}
+			attemptSpan, endAttemptSpan := common.StartNestedSpan(c, "relay.attempt",
+				attribute.Int("retry", retryParam.GetRetry()),
+				attribute.Int("channel.id", channel.Id),
+				attribute.Int("channel.type", channel.Type),
+				attribute.String("model", relayInfo.OriginModelName),
+			)
+			attemptStart := time.Now()
+			switch relayFormat {
+			case types.RelayFormatOpenAIRealtime:
+				newAPIError = relay.WssHelper(c, relayInfo)
+			case types.RelayFormatClaude:
+				newAPIError = relay.ClaudeHelper(c, relayInfo)
+			case types.RelayFormatGemini:
+				newAPIError = geminiRelayHandler(c, relayInfo)
+			default:
+				newAPIError = relayHandler(c, relayInfo)
+			}
+			recordChannelHealth(c, relayInfo, channel.Id, attemptStart, newAPIError)
+			if relayInfo.ChannelMeta != nil {
+				attemptSpan.SetAttributes(attribute.String("upstream_model", relayInfo.UpstreamModelName))
+			}
+			if newAPIError != nil {
+				attemptSpan.SetAttributes(attribute.Int("http.response.status_code", newAPIError.StatusCode))
+				common.SetSpanError(attemptSpan, newAPIError)
+			}
+			endAttemptSpan()
 
-		if newAPIError == nil {
-			return
-		}
+			if newAPIError == nil {
+				if responseCache != nil {
+					responseCache.Save(c, relayInfo)
+				}
+				return
+			}
 
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+			processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
 
-		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+			if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+				break
+			}
+		}
+
+		// 虚拟模型：当前模型失败后回退到下一个模型，按实际服务的模型重新计价
+		nextModel, ok := service.NextVirtualModelStep(c, newAPIError, retryParam)
+		if !ok {
+			break
+		}
+		logger.LogWarn(c, fmt.Sprintf("虚拟模型回退：%s -> %s, 原因: %s", relayInfo.OriginModelName, nextModel, newAPIError.Error()))
+		request, newAPIError = switchVirtualModelStep(c, relayFormat, relayInfo, tokens, meta, nextModel)
+		if newAPIError != nil {
 			break
 		}
 	}
@@ -228,6 +312,51 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	}
 }
 
+// preConsumeModelQuota 按当前模型计算价格并预扣费
+func preConsumeModelQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, tokens int, meta *types.TokenCountMeta) *types.NewAPIError {
+	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeModelPriceError)
+	}
+
+	// common.SetContextKey(c, constant.ContextKeyTokenCountMeta, meta)
+
+	if priceData.FreeModel {
+		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
+		return nil
+	}
+	_, preConsumeSpan := common.StartSpan(c, "relay.pre_consume", attribute.Int("quota", priceData.QuotaToPreConsume))
+	defer preConsumeSpan.End()
+	return service.PreConsumeQuota(c, priceData.QuotaToPreConsume, relayInfo)
+}
+
+// switchVirtualModelStep 切换到虚拟模型的下一个真实模型：退还当前预扣费，按新模型重新应用能力限制并预扣费
+func switchVirtualModelStep(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, tokens int, meta *types.TokenCountMeta, modelName string) (dto.Request, *types.NewAPIError) {
+	if relayInfo.FinalPreConsumedQuota != 0 {
+		service.ReturnPreConsumedQuota(c, relayInfo)
+		relayInfo.FinalPreConsumedQuota = 0
+		relayInfo.BillingCharge = types.BillingCharge{}
+	}
+	// 重新解析请求，避免上一个模型的能力限制残留
+	request, err := helper.GetAndValidateRequest(c, relayFormat)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	request.SetModelName(modelName)
+	relayInfo.Request = request
+	relayInfo.OriginModelName = modelName
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, modelName)
+	if relayInfo.ChannelMeta == nil {
+		// 确保下一轮重新选择渠道，而不是复用分发时的渠道
+		relayInfo.ChannelMeta = &relaycommon.ChannelMeta{}
+	}
+
+	if newAPIError := service.ApplyModelCapabilities(c, relayInfo, request, meta); newAPIError != nil {
+		return request, newAPIError
+	}
+	return request, preConsumeModelQuota(c, relayInfo, tokens, meta)
+}
+
 var upgrader = websocket.Upgrader{
 	Subprotocols: []string{"realtime"}, // WS 握手支持的协议，如果有使用 Sec-WebSocket-Protocol，则必须在此声明对应的 Protocol TODO add other protocol
 	CheckOrigin: func(r *http.Request) bool {
@@ -235,6 +364,48 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// recordChannelHealth 记录本次尝试的结果，用于渠道熔断与健康度选择，客户端错误不计入
+func recordChannelHealth(c *gin.Context, relayInfo *relaycommon.RelayInfo, channelId int, attemptStart time.Time, newAPIError *types.NewAPIError) {
+	keyIndex := -1
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		keyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	if newAPIError == nil {
+		var firstByte time.Duration
+		if relayInfo.IsStream && relayInfo.FirstResponseTime.After(attemptStart) {
+			firstByte = relayInfo.FirstResponseTime.Sub(attemptStart)
+		}
+		model.RecordChannelResult(channelId, keyIndex, true, time.Since(attemptStart), firstByte)
+		return
+	}
+	statusCode := newAPIError.StatusCode
+	if types.IsChannelError(newAPIError) || statusCode == 0 || statusCode >= 500 ||
+		statusCode == http.StatusTooManyRequests || statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		model.RecordChannelResult(channelId, keyIndex, false, time.Since(attemptStart), 0)
+	}
+}
+
+func recordRelayMetrics(c *gin.Context, relayInfo *relaycommon.RelayInfo, newAPIError *types.NewAPIError) {
+	statusCode := c.Writer.Status()
+	if newAPIError != nil {
+		statusCode = newAPIError.StatusCode
+	}
+	var firstToken time.Duration
+	if relayInfo.IsStream && relayInfo.HasSendResponse() {
+		firstToken = relayInfo.FirstResponseTime.Sub(relayInfo.StartTime)
+	}
+	retries := len(c.GetStringSlice("use_channel")) - 1
+	if retries < 0 {
+		retries = 0
+	}
+	metrics.RecordRelayRequest(metrics.RelayLabels{
+		Format:    string(relayInfo.RelayFormat),
+		Model:     relayInfo.OriginModelName,
+		Group:     relayInfo.UsingGroup,
+		ChannelId: common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+	}, statusCode, time.Since(relayInfo.StartTime), firstToken, retries)
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
@@ -344,6 +515,7 @@ func shouldRetry(c *gin.Context, openaiErr *types.NewAPIError, retryTimes int) b
 
 func processChannelError(c *gin.Context, channelError types.ChannelError, err *types.NewAPIError) {
 	logger.LogError(c, fmt.Sprintf("channel error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
+	metrics.RecordUpstreamError(channelError.ChannelId, err.StatusCode, string(err.GetErrorCode()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
 	if service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan {
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracingTestExporter = tracetest.NewInMemoryExporter()
+	tracingTestOnce     sync.Once
+)
+
+// setupTracingTest 安装内存导出器；全局 TracerProvider 只能委托一次，因此只在首次调用时设置
+func setupTracingTest(t *testing.T) *tracetest.InMemoryExporter {
+	tracingTestOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracingTestExporter)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	origEnabled := common.TracingEnabled
+	t.Cleanup(func() {
+		common.TracingEnabled = origEnabled
+		tracingTestExporter.Reset()
+	})
+	common.TracingEnabled = true
+	tracingTestExporter.Reset()
+	return tracingTestExporter
+}
+
+// findSpan 按名称查找导出的 span
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	require.Failf(t, "span not found", "%s", name)
+	return tracetest.SpanStub{}
+}
+
+func TestRelayTraceContext(t *testing.T) {
+	const (
+		incomingTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+		incomingSpanId  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name            string
+		traceparent     string
+		propagate       bool
+		wantUpstreamHdr bool
+	}{
+		{name: "incoming trace is continued and propagated", traceparent: "00-" + incomingTraceId + "-" + incomingSpanId + "-01", propagate: true, wantUpstreamHdr: true},
+		{name: "new trace without traceparent", propagate: true, wantUpstreamHdr: true},
+		{name: "propagation disabled for the channel", traceparent: "00-" + incomingTraceId + "-" + incomingSpanId + "-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := setupTracingTest(t)
+			ratio_setting.InitRatioSettings()
+			service.InitHttpClient()
+			db := setupTestDB(t, &model.User{}, &model.Channel{}, &model.Ability{}, &model.Log{}, &model.Model{}, &model.Vendor{},
+				&model.QuotaLedger{}, &model.Subscription{}, &model.SubscriptionPlan{})
+
+			var upstreamTraceparent string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamTraceparent = r.Header.Get("traceparent")
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`))
+			}))
+			t.Cleanup(upstream.Close)
+
+			require.NoError(t, db.Create(&model.User{Id: 1, Username: "u1", AffCode: "aff1", Group: "default", Quota: 1000000, Status: common.UserStatusEnabled}).Error)
+			channel := &model.Channel{Id: 1, Type: constant.ChannelTypeOpenAI, Key: "sk-upstream", Status: common.ChannelStatusEnabled,
+				Name: "upstream", Models: "gpt-4o", Group: "default"}
+			channel.BaseURL = &upstream.URL
+			channel.SetSetting(dto.ChannelSettings{PropagateTraceContext: tt.propagate})
+			require.NoError(t, db.Create(channel).Error)
+
+			// playground 路由不扣令牌额度，用户认证由测试代替，并指定渠道以跳过渠道选择
+			router := gin.New()
+			router.Use(middleware.RequestId(), middleware.Tracing())
+			router.POST("/pg/chat/completions", func(c *gin.Context) {
+				c.Set("id", 1)
+				common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+				c.Set("specific_channel_id", "1")
+				c.Next()
+			}, middleware.Distribute(), Playground)
+
+			req := httptest.NewRequest(http.MethodPost, "/pg/chat/completions",
+				strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			spans := exporter.GetSpans()
+			server := findSpan(t, spans, "POST /pg/chat/completions")
+			distribute := findSpan(t, spans, "distribute")
+			attempt := findSpan(t, spans, "relay.attempt")
+			upstreamSpan := findSpan(t, spans, "relay.upstream")
+			traceId := server.SpanContext.TraceID()
+			if tt.traceparent != "" {
+				require.Equal(t, incomingTraceId, traceId.String())
+				require.True(t, server.Parent.IsRemote())
+				require.Equal(t, incomingSpanId, server.Parent.SpanID().String())
+			} else {
+				require.False(t, server.Parent.IsValid())
+			}
+			require.Equal(t, trace.SpanKindServer, server.SpanKind)
+			for _, span := range []tracetest.SpanStub{distribute, attempt, upstreamSpan} {
+				require.Equal(t, traceId, span.SpanContext.TraceID(), span.Name)
+			}
+			require.Equal(t, server.SpanContext.SpanID(), distribute.Parent.SpanID())
+			require.Equal(t, server.SpanContext.SpanID(), attempt.Parent.SpanID())
+			require.Equal(t, attempt.SpanContext.SpanID(), upstreamSpan.Parent.SpanID())
+
+			if !tt.wantUpstreamHdr {
+				require.Empty(t, upstreamTraceparent)
+				return
+			}
+			require.Equal(t, "00-"+traceId.String()+"-"+upstreamSpan.SpanContext.SpanID().String()+"-01", upstreamTraceparent)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaychannel "github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 计算向量时最多使用的字符数
+const responseCacheEmbeddingMaxRunes = 8000
+
+// truncateResponseCacheText 按字符截断，避免截断多字节字符产生无效的 UTF-8
+func truncateResponseCacheText(text string) string {
+	if utf8.RuneCountInString(text) <= responseCacheEmbeddingMaxRunes {
+		return text
+	}
+	return string([]rune(text)[:responseCacheEmbeddingMaxRunes])
+}
+
+// responseCacheEmbedding 经语义匹配渠道的适配器计算向量，不写入客户端响应也不计费
+func responseCacheEmbedding(c *gin.Context, text string) ([]float64, error) {
+	setting := operation_setting.GetResponseCacheSetting()
+	if setting.SemanticChannelId == 0 {
+		return nil, errors.New("semantic channel is not configured")
+	}
+	request := &dto.EmbeddingRequest{Model: setting.SemanticModel, Input: truncateResponseCacheText(text)}
+	body, err := doInternalChannelRequest(c, setting.SemanticChannelId, "/v1/embeddings", types.RelayFormatEmbedding, request, setting.SemanticModel,
+		func(c *gin.Context, info *relaycommon.RelayInfo, adaptor relaychannel.Adaptor) (any, error) {
+			return adaptor.ConvertEmbeddingRequest(c, info, *request)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var embeddingResponse dto.OpenAIEmbeddingResponse
+	if err := common.Unmarshal(body, &embeddingResponse); err != nil {
+		return nil, err
+	}
+	if len(embeddingResponse.Data) == 0 || len(embeddingResponse.Data[0].Embedding) == 0 {
+		return nil, errors.New("embedding response is empty")
+	}
+	return embeddingResponse.Data[0].Embedding, nil
+}
@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateResponseCacheText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		wantRunes int
+	}{
+		{name: "short text is kept", text: "hello", wantRunes: 5},
+		{name: "ascii is truncated", text: strings.Repeat("a", responseCacheEmbeddingMaxRunes+10), wantRunes: responseCacheEmbeddingMaxRunes},
+		{name: "multi-byte text is truncated by runes", text: strings.Repeat("你好", responseCacheEmbeddingMaxRunes), wantRunes: responseCacheEmbeddingMaxRunes},
+		{name: "multi-byte text within the limit", text: strings.Repeat("你", responseCacheEmbeddingMaxRunes), wantRunes: responseCacheEmbeddingMaxRunes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateResponseCacheText(tt.text)
+			require.True(t, utf8.ValidString(got))
+			require.Equal(t, tt.wantRunes, utf8.RuneCountInString(got))
+			require.True(t, strings.HasPrefix(tt.text, got))
+		})
+	}
+}
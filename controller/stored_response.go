@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func storedResponseNotFound(c *gin.Context, id string) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error": types.OpenAIError{
+			Message: fmt.Sprintf("Response with id '%s' not found.", id),
+			Type:    "invalid_request_error",
+			Param:   "response_id",
+			Code:    "",
+		},
+	})
+}
+
+// GetStoredResponse GET /v1/responses/:id，仅返回网关保存的响应（经 chat/completions 转发的渠道）
+func GetStoredResponse(c *gin.Context) {
+	id := c.Param("id")
+	stored, err := model.GetStoredResponse(id, c.GetInt("id"), common.GetTimestamp())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			storedResponseNotFound(c, id)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": types.NewError(err, types.ErrorCodeQueryDataError).ToOpenAIError(),
+		})
+		return
+	}
+	c.Data(http.StatusOK, "application/json", []byte(stored.Response))
+}
+
+// DeleteStoredResponse DELETE /v1/responses/:id
+func DeleteStoredResponse(c *gin.Context) {
+	id := c.Param("id")
+	count, err := model.DeleteStoredResponse(id, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": types.NewError(err, types.ErrorCodeUpdateDataError).ToOpenAIError(),
+		})
+		return
+	}
+	if count == 0 {
+		storedResponseNotFound(c, id)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "response",
+		"deleted": true,
+	})
+}
@@ -0,0 +1,478 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/subscription"
+	"github.com/thanhpk/randstr"
+	"gorm.io/gorm"
+)
+
+type SubscribePlanRequest struct {
+	PlanId int `json:"plan_id"`
+}
+
+type GrantSubscriptionRequest struct {
+	UserId int `json:"user_id"`
+	PlanId int `json:"plan_id"`
+	Months int `json:"months"`
+}
+
+func initStripeKey() error {
+	if !strings.HasPrefix(setting.StripeApiSecret, "sk_") && !strings.HasPrefix(setting.StripeApiSecret, "rk_") {
+		return fmt.Errorf("无效的Stripe API密钥")
+	}
+	stripe.Key = setting.StripeApiSecret
+	return nil
+}
+
+// GetSubscriptionPlans 列出可订阅的套餐
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+// GetSelfSubscription 获取当前用户生效中的订阅，没有订阅时返回 null
+func GetSelfSubscription(c *gin.Context) {
+	sub, err := model.GetActiveSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sub)
+}
+
+// SubscribePlan 创建 Stripe 订阅支付链接，支付完成后由 Webhook 生效
+func SubscribePlan(c *gin.Context) {
+	var req SubscribePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || !plan.Enabled {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	if plan.StripePriceId == "" {
+		common.ApiErrorMsg(c, "该套餐不支持在线订阅")
+		return
+	}
+	if err = initStripeKey(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	user, err := model.GetUserById(c.GetInt("id"), false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	reference := fmt.Sprintf("new-api-sub-%d-%d-%s", user.Id, time.Now().UnixMilli(), randstr.String(4))
+	referenceId := "sub_" + common.Sha1([]byte(reference))
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String(referenceId),
+		SuccessURL:        stripe.String(system_setting.ServerAddress + "/console/topup"),
+		CancelURL:         stripe.String(system_setting.ServerAddress + "/console/topup"),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(plan.StripePriceId),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Mode:                stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		AllowPromotionCodes: stripe.Bool(setting.StripePromotionCodesEnabled),
+	}
+	if user.StripeCustomer != "" {
+		params.Customer = stripe.String(user.StripeCustomer)
+	} else if user.Email != "" {
+		params.CustomerEmail = stripe.String(user.Email)
+	}
+	result, err := session.New(params)
+	if err != nil {
+		log.Println("获取Stripe订阅支付链接失败", err)
+		common.ApiErrorMsg(c, "拉起支付失败")
+		return
+	}
+
+	sub := &model.Subscription{
+		UserId:    user.Id,
+		PlanId:    plan.Id,
+		Status:    model.SubscriptionStatusPending,
+		Reference: referenceId,
+	}
+	if err = sub.Insert(); err != nil {
+		common.ApiErrorMsg(c, "创建订单失败")
+		return
+	}
+	common.ApiSuccess(c, gin.H{"pay_link": result.URL})
+}
+
+// CancelSelfSubscription 取消自动续费，当前周期结束后失效
+func CancelSelfSubscription(c *gin.Context) {
+	sub, err := model.GetActiveSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if sub == nil {
+		common.ApiErrorMsg(c, "没有生效中的订阅")
+		return
+	}
+	if sub.StripeSubscriptionId != "" {
+		if err = initStripeKey(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if _, err = subscription.Update(sub.StripeSubscriptionId, &stripe.SubscriptionParams{CancelAtPeriodEnd: stripe.Bool(true)}); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	sub.CancelAtPeriodEnd = true
+	if err = sub.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sub)
+}
+
+// stripeSubscriptionSessionCompleted 订阅支付完成，按 Stripe 当前计费周期生效
+func stripeSubscriptionSessionCompleted(event stripe.Event) {
+	referenceId := event.GetObjectValue("client_reference_id")
+	stripeSubscriptionId := event.GetObjectValue("subscription")
+	sub, err := model.GetSubscriptionByReference(referenceId)
+	if err != nil {
+		log.Println("订阅订单不存在", referenceId)
+		return
+	}
+	if sub.Status != model.SubscriptionStatusPending {
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(sub.PlanId)
+	if err != nil {
+		log.Println("订阅套餐不存在", referenceId, sub.PlanId)
+		return
+	}
+	if err = initStripeKey(); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	stripeSub, err := subscription.Get(stripeSubscriptionId, nil)
+	if err != nil {
+		log.Println("获取Stripe订阅失败", stripeSubscriptionId, err)
+		return
+	}
+	sub.StripeSubscriptionId = stripeSubscriptionId
+	payment := stripeSubscriptionPayment(event, "amount_total")
+	if err = model.ActivateSubscription(sub, plan, stripeSub.CurrentPeriodStart, stripeSub.CurrentPeriodEnd, payment); err != nil {
+		log.Println("订阅生效失败", referenceId, err)
+		return
+	}
+	service.DeliverInvoice(model.InvoiceSourceSubscription, model.SubscriptionInvoiceReference(sub))
+	if err = model.UpdateUserStripeCustomer(sub.UserId, event.GetObjectValue("customer")); err != nil {
+		log.Println("更新Stripe客户失败", referenceId, err)
+	}
+	log.Printf("订阅已生效：%s, 用户 %d, 套餐 %s", referenceId, sub.UserId, plan.Name)
+}
+
+// stripeSubscriptionSessionExpired 订阅支付超时未完成
+func stripeSubscriptionSessionExpired(event stripe.Event) {
+	referenceId := event.GetObjectValue("client_reference_id")
+	sub, err := model.GetSubscriptionByReference(referenceId)
+	if err != nil || sub.Status != model.SubscriptionStatusPending {
+		return
+	}
+	sub.Status = model.SubscriptionStatusExpired
+	if err = sub.Update(); err != nil {
+		log.Println("过期订阅订单失败", referenceId, ", err:", err.Error())
+	}
+}
+
+// stripeInvoicePaid 续费成功后进入新周期并重新发放额度，已失效的订阅补缴后恢复
+func stripeInvoicePaid(event stripe.Event) {
+	stripeSubscriptionId := event.GetObjectValue("subscription")
+	if stripeSubscriptionId == "" {
+		return
+	}
+	sub, err := model.GetSubscriptionByStripeId(stripeSubscriptionId)
+	if err != nil {
+		// 首期账单可能早于 checkout.session.completed 到达，由后者负责生效
+		return
+	}
+	if err = initStripeKey(); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	stripeSub, err := subscription.Get(stripeSubscriptionId, nil)
+	if err != nil {
+		log.Println("获取Stripe订阅失败", stripeSubscriptionId, err)
+		return
+	}
+	payment := stripeSubscriptionPayment(event, "amount_paid")
+	applied, err := applyStripeInvoicePaid(sub, stripeSub.CurrentPeriodStart, stripeSub.CurrentPeriodEnd, payment)
+	if err != nil {
+		log.Println("订阅续期失败", stripeSubscriptionId, err)
+		return
+	}
+	if applied {
+		service.DeliverInvoice(model.InvoiceSourceSubscription, model.SubscriptionInvoiceReference(sub))
+	}
+}
+
+// applyStripeInvoicePaid 按账单所属周期恢复或续期订阅，同一周期的重复通知不做处理并返回 false
+func applyStripeInvoicePaid(sub *model.Subscription, periodStart int64, periodEnd int64, payment *model.SubscriptionPayment) (bool, error) {
+	switch {
+	case sub.Status == model.SubscriptionStatusExpired:
+		plan, err := model.GetSubscriptionPlanById(sub.PlanId)
+		if err != nil {
+			return false, fmt.Errorf("subscription plan #%d not found: %w", sub.PlanId, err)
+		}
+		return true, model.ActivateSubscription(sub, plan, periodStart, periodEnd, payment)
+	case sub.Status == model.SubscriptionStatusActive && periodStart > sub.CurrentPeriodStart:
+		err := model.RenewSubscriptionPeriod(sub, periodStart, periodEnd, payment)
+		if errors.Is(err, model.ErrSubscriptionPeriodNotAdvanced) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return false, nil
+	}
+}
+
+// Stripe 金额以最小货币单位表示，零小数位币种无需换算
+var stripeZeroDecimalCurrencies = []string{"BIF", "CLP", "DJF", "GNF", "JPY", "KMF", "KRW", "MGA", "PYG", "RWF", "UGX", "VND", "VUV", "XAF", "XOF", "XPF"}
+
+// stripeSubscriptionPayment 从 Checkout 会话（amount_total）或账单（amount_paid）读取实际付款，用于开具发票
+func stripeSubscriptionPayment(event stripe.Event, amountField string) *model.SubscriptionPayment {
+	amount, err := strconv.ParseInt(event.GetObjectValue(amountField), 10, 64)
+	if err != nil {
+		return nil
+	}
+	currency := strings.ToUpper(event.GetObjectValue("currency"))
+	money := decimal.NewFromInt(amount)
+	if !common.StringsContains(stripeZeroDecimalCurrencies, currency) {
+		money = money.Div(decimal.NewFromInt(100))
+	}
+	return &model.SubscriptionPayment{Amount: money.InexactFloat64(), Currency: currency}
+}
+
+// stripeSubscriptionChanged 同步取消续费标记，订阅被取消或欠费终止时失效
+func stripeSubscriptionChanged(event stripe.Event) {
+	var stripeSub stripe.Subscription
+	if err := common.Unmarshal(event.Data.Raw, &stripeSub); err != nil {
+		log.Println("解析Stripe订阅失败", err)
+		return
+	}
+	sub, err := model.GetSubscriptionByStripeId(stripeSub.ID)
+	if err != nil || sub.Status != model.SubscriptionStatusActive {
+		return
+	}
+	switch {
+	case event.Type == stripe.EventTypeCustomerSubscriptionDeleted,
+		stripeSub.Status == stripe.SubscriptionStatusCanceled,
+		stripeSub.Status == stripe.SubscriptionStatusUnpaid,
+		stripeSub.Status == stripe.SubscriptionStatusIncompleteExpired:
+		err = model.ExpireSubscription(sub)
+	case sub.CancelAtPeriodEnd != stripeSub.CancelAtPeriodEnd:
+		sub.CancelAtPeriodEnd = stripeSub.CancelAtPeriodEnd
+		err = sub.Update()
+	}
+	if err != nil {
+		log.Println("同步Stripe订阅失败", stripeSub.ID, err)
+	}
+}
+
+// GetAllSubscriptions 管理员分页查询订阅
+func GetAllSubscriptions(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	planId, _ := strconv.Atoi(c.Query("plan_id"))
+	subs, total, err := model.GetSubscriptions(model.SubscriptionFilter{
+		UserId: userId,
+		PlanId: planId,
+		Status: c.Query("status"),
+	}, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(subs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GrantSubscription 管理员直接为用户开通套餐，按月发放额度直至到期
+func GrantSubscription(c *gin.Context) {
+	var req GrantSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	if req.Months <= 0 {
+		common.ApiErrorMsg(c, "开通月数必须大于0")
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	if _, err = model.GetUserById(req.UserId, false); err != nil {
+		common.ApiErrorMsg(c, "用户不存在")
+		return
+	}
+	now := time.Now()
+	sub := &model.Subscription{
+		UserId:    req.UserId,
+		ExpiresAt: now.AddDate(0, req.Months, 0).Unix(),
+	}
+	if err = model.ActivateSubscription(sub, plan, now.Unix(), now.AddDate(0, 1, 0).Unix(), nil); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, model.AuditActionCreate, model.AuditTargetSub, sub.Id, nil, sub)
+	common.ApiSuccess(c, sub)
+}
+
+// AdminCancelSubscription 立即终止订阅，Stripe 订阅同时取消
+func AdminCancelSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sub, err := model.GetSubscriptionById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.ApiErrorMsg(c, "订阅不存在")
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	if sub.StripeSubscriptionId != "" && sub.Status == model.SubscriptionStatusActive {
+		if err = initStripeKey(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if _, err = subscription.Cancel(sub.StripeSubscriptionId, nil); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	origin := *sub
+	if err = model.ExpireSubscription(sub); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetSub, sub.Id, &origin, sub)
+	common.ApiSuccess(c, sub)
+}
+
+// GetAllSubscriptionPlans 管理员查看全部套餐，包括已停用的
+func GetAllSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+func validateSubscriptionPlan(plan *model.SubscriptionPlan) error {
+	if strings.TrimSpace(plan.Name) == "" {
+		return errors.New("套餐名称不能为空")
+	}
+	if plan.Quota < 0 {
+		return errors.New("套餐额度不能为负数")
+	}
+	if plan.OverageRatio < 0 {
+		return errors.New("超额倍率不能为负数")
+	}
+	if plan.OverageRatio == 0 {
+		plan.OverageRatio = 1
+	}
+	return nil
+}
+
+func CreateSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan.Id = 0
+	if err := plan.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, model.AuditActionCreate, model.AuditTargetPlan, plan.Id, nil, &plan)
+	common.ApiSuccess(c, &plan)
+}
+
+func UpdateSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	origin, err := model.GetSubscriptionPlanById(plan.Id)
+	if err != nil {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	if err = validateSubscriptionPlan(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan.CreatedAt = origin.CreatedAt
+	if err = plan.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetPlan, plan.Id, origin, &plan)
+	common.ApiSuccess(c, &plan)
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	origin, err := model.GetSubscriptionPlanById(id)
+	if err != nil {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	if err = model.DeleteSubscriptionPlanById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, model.AuditActionDelete, model.AuditTargetPlan, id, origin, nil)
+	common.ApiSuccess(c, nil)
+}
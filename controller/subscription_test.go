@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyStripeInvoicePaid(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		periodStart int64
+		staleStart  int64 // 非 0 时模拟读取订阅后其他请求已续期
+		wantApplied bool
+		wantStart   int64
+		wantStatus  string
+	}{
+		{name: "new period renews", status: model.SubscriptionStatusActive, periodStart: 200, wantApplied: true, wantStart: 200, wantStatus: model.SubscriptionStatusActive},
+		{name: "same period is ignored", status: model.SubscriptionStatusActive, periodStart: 100, wantStart: 100, wantStatus: model.SubscriptionStatusActive},
+		{name: "concurrent duplicate is ignored", status: model.SubscriptionStatusActive, periodStart: 200, staleStart: 200, wantStart: 200, wantStatus: model.SubscriptionStatusActive},
+		{name: "expired subscription is reactivated", status: model.SubscriptionStatusExpired, periodStart: 200, wantApplied: true, wantStart: 200, wantStatus: model.SubscriptionStatusActive},
+		{name: "pending subscription is left to checkout", status: model.SubscriptionStatusPending, periodStart: 200, wantStart: 100, wantStatus: model.SubscriptionStatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, &model.User{}, &model.Subscription{}, &model.SubscriptionPlan{}, &model.QuotaLedger{}, &model.Log{},
+				&model.Invoice{}, &model.InvoiceSequence{}, &model.PendingInvoice{})
+			require.NoError(t, db.Create(&model.User{Id: 1, Username: "u1"}).Error)
+			require.NoError(t, db.Create(&model.SubscriptionPlan{Id: 1, Name: "pro", Quota: 100, Enabled: true}).Error)
+			require.NoError(t, db.Create(&model.Subscription{
+				Id:                   1,
+				UserId:               1,
+				PlanId:               1,
+				Status:               tt.status,
+				StripeSubscriptionId: "sub_1",
+				Quota:                100,
+				UsedQuota:            40,
+				CurrentPeriodStart:   100,
+				CurrentPeriodEnd:     200,
+			}).Error)
+			sub, err := model.GetSubscriptionByStripeId("sub_1")
+			require.NoError(t, err)
+			if tt.staleStart != 0 {
+				require.NoError(t, db.Model(&model.Subscription{}).Where("id = ?", 1).Update("current_period_start", tt.staleStart).Error)
+			}
+
+			applied, err := applyStripeInvoicePaid(sub, tt.periodStart, tt.periodStart+100, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantApplied, applied)
+			saved, err := model.GetSubscriptionById(1)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStatus, saved.Status)
+			require.Equal(t, tt.wantStart, saved.CurrentPeriodStart)
+			if tt.wantApplied {
+				require.Zero(t, saved.UsedQuota)
+			} else {
+				require.Equal(t, 40, saved.UsedQuota)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
@@ -151,6 +152,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			continue
 		}
 
+		preStatus := task.Status
 		task.Status = lo.If(model.TaskStatus(responseItem.Status) != "", model.TaskStatus(responseItem.Status)).Else(task.Status)
 		task.FailReason = lo.If(responseItem.FailReason != "", responseItem.FailReason).Else(task.FailReason)
 		task.SubmitTime = lo.If(responseItem.SubmitTime != 0, responseItem.SubmitTime).Else(task.SubmitTime)
@@ -165,7 +167,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			} else {
 				quota := task.Quota
 				if quota != 0 {
-					err = model.IncreaseUserQuota(task.UserId, quota, false)
+					err = model.IncreaseBillingQuota(task.UserId, task.ProjectId, task.BillingCharge().Refund(quota), model.QuotaLedgerRef{Type: model.QuotaLedgerTypeRefund, ReferenceId: task.TaskID})
 					if err != nil {
 						logger.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
@@ -182,6 +184,8 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		err = task.Update()
 		if err != nil {
 			common.SysLog("UpdateMidjourneyTask task error: " + err.Error())
+		} else if preStatus != task.Status && task.IsFinished() {
+			service.NotifyTaskFinished(task, relay.TaskModel2Dto(task))
 		}
 	}
 	return nil
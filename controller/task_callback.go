@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetTaskCallbacks 分页查询任务回调投递记录
+func GetTaskCallbacks(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	callbacks, total, err := model.GetTaskCallbacks(model.TaskCallbackFilter{
+		UserId:   userId,
+		TaskId:   c.Query("task_id"),
+		TaskType: c.Query("task_type"),
+		Status:   c.Query("status"),
+	}, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(callbacks)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// RedeliverTaskCallback 重置投递状态，由后台任务立即重新投递
+func RedeliverTaskCallback(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := service.RedeliverTaskCallback(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.ApiErrorMsg(c, "回调记录不存在")
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/relay"
 	"github.com/QuantumNous/new-api/relay/channel"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 )
 
@@ -194,9 +195,10 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 									logger.LogQuota(preConsumedQuota),
 									taskResult.TotalTokens,
 								))
-								if err := model.DecreaseUserQuota(task.UserId, quotaDelta); err != nil {
+								if charge, err := model.DecreaseBillingQuota(task.UserId, task.ProjectId, quotaDelta, model.QuotaLedgerRef{Type: model.QuotaLedgerTypeConsume, ReferenceId: task.TaskID}); err != nil {
 									logger.LogError(ctx, fmt.Sprintf("补扣费失败: %s", err.Error()))
 								} else {
+									task.SubscriptionQuota += charge.Subscription
 									model.UpdateUserUsedQuotaAndRequestCount(task.UserId, quotaDelta)
 									model.UpdateChannelUsedQuota(task.ChannelId, quotaDelta)
 									task.Quota = actualQuota // 更新任务记录的实际扣费额度
@@ -217,9 +219,11 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 									logger.LogQuota(preConsumedQuota),
 									taskResult.TotalTokens,
 								))
-								if err := model.IncreaseUserQuota(task.UserId, refundQuota, false); err != nil {
+								refund := task.BillingCharge().Refund(refundQuota)
+								if err := model.IncreaseBillingQuota(task.UserId, task.ProjectId, refund, model.QuotaLedgerRef{Type: model.QuotaLedgerTypeRefund, ReferenceId: task.TaskID}); err != nil {
 									logger.LogError(ctx, fmt.Sprintf("退还预扣费失败: %s", err.Error()))
 								} else {
+									task.SubscriptionQuota -= refund.Subscription
 									task.Quota = actualQuota // 更新任务记录的实际扣费额度
 
 									// 记录退款日志
@@ -264,11 +268,16 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 	if err := task.Update(); err != nil {
 		common.SysLog("UpdateVideoTask task error: " + err.Error())
 		shouldRefund = false
+	} else if preStatus != task.Status && task.IsFinished() {
+		if task.Status == model.TaskStatusSuccess {
+			archiveTaskVideo(task)
+		}
+		service.NotifyTaskFinished(task, relay.TaskModel2Dto(task))
 	}
 
 	if shouldRefund {
 		// 任务失败且之前状态不是失败才退还额度，防止重复退还
-		if err := model.IncreaseUserQuota(task.UserId, quota, false); err != nil {
+		if err := model.IncreaseBillingQuota(task.UserId, task.ProjectId, task.BillingCharge().Refund(quota), model.QuotaLedgerRef{Type: model.QuotaLedgerTypeRefund, ReferenceId: task.TaskID}); err != nil {
 			logger.LogWarn(ctx, "Failed to increase user quota: "+err.Error())
 		}
 		logContent := fmt.Sprintf("Video async task failed %s, refund %s", task.TaskID, logger.LogQuota(quota))
@@ -8,6 +8,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -136,13 +137,21 @@ func GetTokenUsage(c *gin.Context) {
 	})
 }
 
+// tokenRequest 新增与编辑令牌的请求，回调密钥只写不读，编辑时留空保留原有密钥
+type tokenRequest struct {
+	model.Token
+	CallbackSecret      string `json:"callback_secret"`
+	OriginalRemainQuota *int   `json:"original_remain_quota"` // 打开编辑时的剩余额度，用于按差值调整额度
+}
+
 func AddToken(c *gin.Context) {
-	token := model.Token{}
-	err := c.ShouldBindJSON(&token)
+	req := tokenRequest{}
+	err := c.ShouldBindJSON(&req)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	token := req.Token
 	if len(token.Name) > 50 {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -168,6 +177,20 @@ func AddToken(c *gin.Context) {
 			return
 		}
 	}
+	if err := service.ValidateBudget(token.GetBudget()); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := service.ValidateTaskCallbackUrl(token.CallbackUrl); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if token.ProjectId > 0 {
+		if _, err := model.GetProjectAccess(token.ProjectId, c.GetInt("id")); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -191,6 +214,20 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
+		RpmLimit:           token.RpmLimit,
+		TpmLimit:           token.TpmLimit,
+		ConcurrencyLimit:   token.ConcurrencyLimit,
+		ResponseCache:      token.ResponseCache,
+		ProjectId:          token.ProjectId,
+		BudgetPeriod:       token.BudgetPeriod,
+		BudgetQuota:        token.BudgetQuota,
+		BudgetMonthlyCap:   token.BudgetMonthlyCap,
+		BudgetTimezone:     token.BudgetTimezone,
+		CallbackUrl:        token.CallbackUrl,
+	}
+	if err = cleanToken.SetCallbackSecret(req.CallbackSecret); err != nil {
+		common.ApiError(c, err)
+		return
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -222,12 +259,13 @@ func DeleteToken(c *gin.Context) {
 func UpdateToken(c *gin.Context) {
 	userId := c.GetInt("id")
 	statusOnly := c.Query("status_only")
-	token := model.Token{}
-	err := c.ShouldBindJSON(&token)
+	req := tokenRequest{}
+	err := c.ShouldBindJSON(&req)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	token := req.Token
 	if len(token.Name) > 50 {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -252,6 +290,14 @@ func UpdateToken(c *gin.Context) {
 			return
 		}
 	}
+	if err := service.ValidateBudget(token.GetBudget()); err != nil && statusOnly == "" {
+		common.ApiError(c, err)
+		return
+	}
+	if err := service.ValidateTaskCallbackUrl(token.CallbackUrl); err != nil && statusOnly == "" {
+		common.ApiError(c, err)
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		common.ApiError(c, err)
@@ -276,7 +322,7 @@ func UpdateToken(c *gin.Context) {
 	if statusOnly != "" {
 		cleanToken.Status = token.Status
 	} else {
-		// If you add more fields, please also update token.Update()
+		// If you add more fields, please also update token.updateFields()
 		cleanToken.Name = token.Name
 		cleanToken.ExpiredTime = token.ExpiredTime
 		cleanToken.RemainQuota = token.RemainQuota
@@ -286,8 +332,34 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		cleanToken.RpmLimit = token.RpmLimit
+		cleanToken.TpmLimit = token.TpmLimit
+		cleanToken.ConcurrencyLimit = token.ConcurrencyLimit
+		cleanToken.ResponseCache = token.ResponseCache
+		if token.ProjectId != cleanToken.ProjectId && token.ProjectId > 0 {
+			if _, err := model.GetProjectAccess(token.ProjectId, userId); err != nil {
+				common.ApiError(c, err)
+				return
+			}
+		}
+		cleanToken.ProjectId = token.ProjectId
+		cleanToken.BudgetPeriod = token.BudgetPeriod
+		cleanToken.BudgetQuota = token.BudgetQuota
+		cleanToken.BudgetMonthlyCap = token.BudgetMonthlyCap
+		cleanToken.BudgetTimezone = token.BudgetTimezone
+		cleanToken.CallbackUrl = token.CallbackUrl
+		if req.CallbackSecret != "" {
+			if err = cleanToken.SetCallbackSecret(req.CallbackSecret); err != nil {
+				common.ApiError(c, err)
+				return
+			}
+		}
+	}
+	if statusOnly != "" {
+		err = cleanToken.Update()
+	} else {
+		err = cleanToken.Edit(req.OriginalRemainQuota)
 	}
-	err = cleanToken.Update()
 	if err != nil {
 		common.ApiError(c, err)
 		return
@@ -191,6 +191,7 @@ func RequestEpay(c *gin.Context) {
 		Money:         payMoney,
 		TradeNo:       tradeNo,
 		PaymentMethod: req.PaymentMethod,
+		Currency:      "CNY", // 易支付按人民币收款
 		CreateTime:    time.Now().Unix(),
 		Status:        "pending",
 	}
@@ -279,12 +280,17 @@ func EpayNotify(c *gin.Context) {
 			dAmount := decimal.NewFromInt(int64(topUp.Amount))
 			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
 			quotaToAdd := int(dAmount.Mul(dQuotaPerUnit).IntPart())
-			err = model.IncreaseUserQuota(topUp.UserId, quotaToAdd, true)
+			err = model.IncreaseUserQuota(topUp.UserId, quotaToAdd, true, model.QuotaLedgerRef{Type: model.QuotaLedgerTypeTopup, ReferenceId: topUp.TradeNo})
 			if err != nil {
 				log.Printf("易支付回调更新用户失败: %v", topUp)
 				return
 			}
 			log.Printf("易支付回调更新用户成功 %v", topUp)
+			if err = model.IssueTopUpInvoice(topUp, quotaToAdd); err != nil {
+				log.Printf("易支付回调开具发票失败: %v, %v", topUp, err)
+			} else {
+				service.DeliverInvoice(model.InvoiceSourceTopUp, topUp.TradeNo)
+			}
 			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", logger.LogQuota(quotaToAdd), topUp.Money))
 		}
 	} else {
@@ -388,5 +394,6 @@ func AdminCompleteTopUp(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.DeliverInvoice(model.InvoiceSourceTopUp, req.TradeNo)
 	common.ApiSuccess(c, nil)
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
@@ -89,6 +90,7 @@ func (*StripeAdaptor) RequestPay(c *gin.Context, req *StripePayRequest) {
 		Money:         chargedMoney,
 		TradeNo:       referenceId,
 		PaymentMethod: PaymentMethodStripe,
+		Currency:      "USD", // 支付完成时以 Stripe 回调的币种为准
 		CreateTime:    time.Now().Unix(),
 		Status:        common.TopUpStatusPending,
 	}
@@ -150,6 +152,10 @@ func StripeWebhook(c *gin.Context) {
 		sessionCompleted(event)
 	case stripe.EventTypeCheckoutSessionExpired:
 		sessionExpired(event)
+	case stripe.EventTypeInvoicePaid:
+		stripeInvoicePaid(event)
+	case stripe.EventTypeCustomerSubscriptionUpdated, stripe.EventTypeCustomerSubscriptionDeleted:
+		stripeSubscriptionChanged(event)
 	default:
 		log.Printf("不支持的Stripe Webhook事件类型: %s\n", event.Type)
 	}
@@ -166,14 +172,20 @@ func sessionCompleted(event stripe.Event) {
 		return
 	}
 
-	err := model.Recharge(referenceId, customerId)
+	if event.GetObjectValue("mode") == string(stripe.CheckoutSessionModeSubscription) {
+		stripeSubscriptionSessionCompleted(event)
+		return
+	}
+
+	currency := strings.ToUpper(event.GetObjectValue("currency"))
+	err := model.Recharge(referenceId, customerId, currency)
 	if err != nil {
 		log.Println(err.Error(), referenceId)
 		return
 	}
+	service.DeliverInvoice(model.InvoiceSourceTopUp, referenceId)
 
 	total, _ := strconv.ParseFloat(event.GetObjectValue("amount_total"), 64)
-	currency := strings.ToUpper(event.GetObjectValue("currency"))
 	log.Printf("收到款项：%s, %.2f(%s)", referenceId, total/100, currency)
 }
 
@@ -190,6 +202,11 @@ func sessionExpired(event stripe.Event) {
 		return
 	}
 
+	if event.GetObjectValue("mode") == string(stripe.CheckoutSessionModeSubscription) {
+		stripeSubscriptionSessionExpired(event)
+		return
+	}
+
 	topUp := model.GetTopUpByTradeNo(referenceId)
 	if topUp == nil {
 		log.Println("充值订单不存在", referenceId)
@@ -442,6 +442,13 @@ func GetSelf(c *gin.Context) {
 
 	// 获取用户设置并提取sidebar_modules
 	userSetting := user.GetSetting()
+	// 返回解密后的设置，webhook 密钥在数据库中加密存储
+	settingJson := user.Setting
+	if settingJson != "" {
+		if settingBytes, err := common.Marshal(userSetting); err == nil {
+			settingJson = string(settingBytes)
+		}
+	}
 
 	// 构建响应数据，包含用户信息和权限
 	responseData := map[string]interface{}{
@@ -466,7 +473,7 @@ func GetSelf(c *gin.Context) {
 		"aff_history_quota": user.AffHistoryQuota,
 		"inviter_id":        user.InviterId,
 		"linux_do_id":       user.LinuxDOId,
-		"setting":           user.Setting,
+		"setting":           settingJson,
 		"stripe_customer":   user.StripeCustomer,
 		"sidebar_modules":   userSetting.SidebarModules, // 正确提取sidebar_modules字段
 		"permissions":       permissions,                // 新增权限字段
@@ -597,9 +604,16 @@ func GetUserModels(c *gin.Context) {
 	return
 }
 
+// updateUserRequest 管理员编辑用户的请求，original_quota 为打开编辑时的额度，用于按差值调整额度
+type updateUserRequest struct {
+	model.User
+	OriginalQuota *int `json:"original_quota"`
+}
+
 func UpdateUser(c *gin.Context) {
-	var updatedUser model.User
-	err := json.NewDecoder(c.Request.Body).Decode(&updatedUser)
+	var req updateUserRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	updatedUser := req.User
 	if err != nil || updatedUser.Id == 0 {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -617,6 +631,10 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if err := service.ValidateBudget(updatedUser.GetBudget()); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	originUser, err := model.GetUserById(updatedUser.Id, false)
 	if err != nil {
 		common.ApiError(c, err)
@@ -641,10 +659,17 @@ func UpdateUser(c *gin.Context) {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
-	if err := updatedUser.Edit(updatePassword); err != nil {
+	if err := updatedUser.Edit(updatePassword, req.OriginalQuota); err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	if user, err := model.GetUserById(updatedUser.Id, false); err == nil {
+		if updatePassword {
+			// 仅用于标记密码已修改，审计记录中会脱敏
+			user.Password = updatedUser.Password
+		}
+		service.RecordAudit(c, model.AuditActionUpdate, model.AuditTargetUser, user.Id, originUser, user)
+	}
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", logger.LogQuota(originUser.Quota), logger.LogQuota(updatedUser.Quota)))
 	}
@@ -797,6 +822,9 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 	err = model.HardDeleteUserById(id)
+	if err == nil {
+		service.RecordAudit(c, model.AuditActionDelete, model.AuditTargetUser, id, originUser, nil)
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -870,6 +898,7 @@ func CreateUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordAudit(c, model.AuditActionCreate, model.AuditTargetUser, cleanUser.Id, nil, &cleanUser)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -915,6 +944,7 @@ func ManageUser(c *gin.Context) {
 		})
 		return
 	}
+	originUser := user
 	switch req.Action {
 	case "disable":
 		user.Status = common.UserStatusDisabled
@@ -980,6 +1010,11 @@ func ManageUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	auditAction := model.AuditActionUpdate
+	if req.Action == "delete" {
+		auditAction = model.AuditActionDelete
+	}
+	service.RecordAudit(c, auditAction, model.AuditTargetUser, user.Id, &originUser, &user)
 	clearUser := model.User{
 		Role:   user.Role,
 		Status: user.Status,
@@ -1112,6 +1147,8 @@ type UpdateUserSettingRequest struct {
 	GotifyPriority             int     `json:"gotify_priority,omitempty"`
 	AcceptUnsetModelRatioModel bool    `json:"accept_unset_model_ratio_model"`
 	RecordIpLog                bool    `json:"record_ip_log"`
+	TaskCallbackUrl            string  `json:"task_callback_url,omitempty"`
+	TaskCallbackSecret         string  `json:"task_callback_secret,omitempty"`
 }
 
 func UpdateUserSetting(c *gin.Context) {
@@ -1234,6 +1271,15 @@ func UpdateUserSetting(c *gin.Context) {
 		}
 	}
 
+	// 验证异步任务回调地址
+	if err := service.ValidateTaskCallbackUrl(req.TaskCallbackUrl); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的任务回调地址",
+		})
+		return
+	}
+
 	userId := c.GetInt("id")
 	user, err := model.GetUserById(userId, true)
 	if err != nil {
@@ -1247,6 +1293,14 @@ func UpdateUserSetting(c *gin.Context) {
 		QuotaWarningThreshold: req.QuotaWarningThreshold,
 		AcceptUnsetRatioModel: req.AcceptUnsetModelRatioModel,
 		RecordIpLog:           req.RecordIpLog,
+		TaskCallbackUrl:       req.TaskCallbackUrl,
+	}
+
+	// 回调密钥留空时保留原有密钥
+	if req.TaskCallbackSecret != "" {
+		settings.TaskCallbackSecret = req.TaskCallbackSecret
+	} else if req.TaskCallbackUrl != "" {
+		settings.TaskCallbackSecret = user.GetSetting().TaskCallbackSecret
 	}
 
 	// 如果是webhook类型,添加webhook相关设置
@@ -60,95 +60,24 @@ func VideoProxy(c *gin.Context) {
 		return
 	}
 
-	channel, err := model.CacheGetChannel(task.ChannelId)
-	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to get task %s: not found", taskID))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Failed to retrieve channel information",
-				"type":    "server_error",
-			},
-		})
-		return
-	}
-	baseURL := channel.GetBaseURL()
-	if baseURL == "" {
-		baseURL = "https://api.openai.com"
-	}
-
-	var videoURL string
-	proxy := channel.GetSetting().Proxy
-	client, err := service.GetHttpClientWithProxy(proxy)
-	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to create proxy client for task %s: %s", taskID, err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Failed to create proxy client",
-				"type":    "server_error",
-			},
-		})
+	if artifact, err := model.GetArtifactByTask(model.ArtifactTaskTypeTask, task.TaskID); err == nil && !artifact.IsExpired() {
+		c.Redirect(http.StatusFound, service.SignArtifactUrl(artifact))
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
 	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "", nil)
-	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to create request: %s", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{
+	client, req, proxyErr := newTaskVideoRequest(ctx, task)
+	if proxyErr != nil {
+		c.JSON(proxyErr.status, gin.H{
 			"error": gin.H{
-				"message": "Failed to create proxy request",
-				"type":    "server_error",
-			},
-		})
-		return
-	}
-
-	switch channel.Type {
-	case constant.ChannelTypeGemini:
-		apiKey := task.PrivateData.Key
-		if apiKey == "" {
-			logger.LogError(c.Request.Context(), fmt.Sprintf("Missing stored API key for Gemini task %s", taskID))
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": gin.H{
-					"message": "API key not stored for task",
-					"type":    "server_error",
-				},
-			})
-			return
-		}
-
-		videoURL, err = getGeminiVideoURL(channel, task, apiKey)
-		if err != nil {
-			logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to resolve Gemini video URL for task %s: %s", taskID, err.Error()))
-			c.JSON(http.StatusBadGateway, gin.H{
-				"error": gin.H{
-					"message": "Failed to resolve Gemini video URL",
-					"type":    "server_error",
-				},
-			})
-			return
-		}
-		req.Header.Set("x-goog-api-key", apiKey)
-	case constant.ChannelTypeOpenAI, constant.ChannelTypeSora:
-		videoURL = fmt.Sprintf("%s/v1/videos/%s/content", baseURL, task.TaskID)
-		req.Header.Set("Authorization", "Bearer "+channel.Key)
-	default:
-		// Video URL is directly in task.FailReason
-		videoURL = task.FailReason
-	}
-
-	req.URL, err = url.Parse(videoURL)
-	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to parse URL %s: %s", videoURL, err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Failed to create proxy request",
+				"message": proxyErr.message,
 				"type":    "server_error",
 			},
 		})
 		return
 	}
+	videoURL := req.URL.String()
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -187,3 +116,65 @@ func VideoProxy(c *gin.Context) {
 		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to stream video content: %s", err.Error()))
 	}
 }
+
+// videoProxyError 构建上游请求失败时返回给客户端的状态码与提示
+type videoProxyError struct {
+	status  int
+	message string
+}
+
+// newTaskVideoRequest 按渠道类型构建获取视频内容的上游请求，转存生成结果时复用
+func newTaskVideoRequest(ctx context.Context, task *model.Task) (*http.Client, *http.Request, *videoProxyError) {
+	channel, err := model.CacheGetChannel(task.ChannelId)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Failed to get task %s: not found", task.TaskID))
+		return nil, nil, &videoProxyError{status: http.StatusInternalServerError, message: "Failed to retrieve channel information"}
+	}
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = "https://api.openai.com"
+	}
+
+	var videoURL string
+	proxy := channel.GetSetting().Proxy
+	client, err := service.GetHttpClientWithProxy(proxy)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Failed to create proxy client for task %s: %s", task.TaskID, err.Error()))
+		return nil, nil, &videoProxyError{status: http.StatusInternalServerError, message: "Failed to create proxy client"}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "", nil)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Failed to create request: %s", err.Error()))
+		return nil, nil, &videoProxyError{status: http.StatusInternalServerError, message: "Failed to create proxy request"}
+	}
+
+	switch channel.Type {
+	case constant.ChannelTypeGemini:
+		apiKey := task.PrivateData.Key
+		if apiKey == "" {
+			logger.LogError(ctx, fmt.Sprintf("Missing stored API key for Gemini task %s", task.TaskID))
+			return nil, nil, &videoProxyError{status: http.StatusInternalServerError, message: "API key not stored for task"}
+		}
+
+		videoURL, err = getGeminiVideoURL(channel, task, apiKey)
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("Failed to resolve Gemini video URL for task %s: %s", task.TaskID, err.Error()))
+			return nil, nil, &videoProxyError{status: http.StatusBadGateway, message: "Failed to resolve Gemini video URL"}
+		}
+		req.Header.Set("x-goog-api-key", apiKey)
+	case constant.ChannelTypeOpenAI, constant.ChannelTypeSora:
+		videoURL = fmt.Sprintf("%s/v1/videos/%s/content", baseURL, task.TaskID)
+		req.Header.Set("Authorization", "Bearer "+channel.Key)
+	default:
+		// Video URL is directly in task.FailReason
+		videoURL = task.FailReason
+	}
+
+	req.URL, err = url.Parse(videoURL)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Failed to parse URL %s: %s", videoURL, err.Error()))
+		return nil, nil, &videoProxyError{status: http.StatusInternalServerError, message: "Failed to create proxy request"}
+	}
+	return client, req, nil
+}
@@ -7,6 +7,9 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	PropagateTraceContext  bool   `json:"propagate_trace_context,omitempty"` // 向上游透传 traceparent
+	PIIRedaction           bool   `json:"pii_redaction,omitempty"`           // 转发上游前将 PII 替换为占位符
+	PIIRestore             bool   `json:"pii_restore,omitempty"`             // 在响应中将占位符还原为原文
 }
 
 type VertexKeyType string
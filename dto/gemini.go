@@ -43,6 +43,16 @@ func (r *GeminiChatRequest) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// GeminiCountTokensRequest countTokens 请求，contents 与 generateContentRequest 二选一
+type GeminiCountTokensRequest struct {
+	Contents               []GeminiChatContent `json:"contents,omitempty"`
+	GenerateContentRequest *GeminiChatRequest  `json:"generateContentRequest,omitempty"`
+}
+
+type GeminiCountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
 type ToolConfig struct {
 	FunctionCallingConfig *FunctionCallingConfig `json:"functionCallingConfig,omitempty"`
 	RetrievalConfig       *RetrievalConfig       `json:"retrievalConfig,omitempty"`
@@ -330,7 +330,8 @@ func (o *OpenAIResponsesResponse) GetSize() string {
 }
 
 type IncompleteDetails struct {
-	Reasoning string `json:"reasoning"`
+	Reasoning string `json:"reasoning,omitempty"`
+	Reason    string `json:"reason,omitempty"` // max_output_tokens / content_filter
 }
 
 type ResponsesOutput struct {
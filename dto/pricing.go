@@ -9,6 +9,19 @@ type OpenAIModels struct {
 	Created                int                     `json:"created"`
 	OwnedBy                string                  `json:"owned_by"`
 	SupportedEndpointTypes []constant.EndpointType `json:"supported_endpoint_types"`
+
+	ModelCapabilities
+}
+
+// ModelCapabilities 模型能力，零值或 nil 表示未知，relay 不做相应限制
+type ModelCapabilities struct {
+	ContextWindow     int   `json:"context_window,omitempty" gorm:"default:0"`
+	MaxOutputTokens   int   `json:"max_output_tokens,omitempty" gorm:"default:0"`
+	SupportsVision    *bool `json:"supports_vision,omitempty"`
+	SupportsTools     *bool `json:"supports_tools,omitempty"`
+	SupportsJsonMode  *bool `json:"supports_json_mode,omitempty"`
+	SupportsAudio     *bool `json:"supports_audio,omitempty"`
+	SupportsReasoning *bool `json:"supports_reasoning,omitempty"`
 }
 
 type AnthropicModel struct {
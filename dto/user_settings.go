@@ -13,6 +13,8 @@ type UserSetting struct {
 	AcceptUnsetRatioModel bool    `json:"accept_unset_model_ratio_model,omitempty"` // AcceptUnsetRatioModel 是否接受未设置价格的模型
 	RecordIpLog           bool    `json:"record_ip_log,omitempty"`                  // 是否记录请求和错误日志IP
 	SidebarModules        string  `json:"sidebar_modules,omitempty"`                // SidebarModules 左侧边栏模块配置
+	TaskCallbackUrl       string  `json:"task_callback_url,omitempty"`              // TaskCallbackUrl 异步任务完成回调地址
+	TaskCallbackSecret    string  `json:"task_callback_secret,omitempty"`           // TaskCallbackSecret 回调签名密钥
 }
 
 var (
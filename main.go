@@ -2,13 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
@@ -60,6 +63,14 @@ func main() {
 		}
 	}()
 
+	if *common.MigrateSecrets {
+		if err := model.MigrateSecrets(); err != nil {
+			common.FatalLog("failed to migrate secrets: " + err.Error())
+		}
+		common.SysLog("secrets migrated")
+		return
+	}
+
 	if common.RedisEnabled {
 		// for compatibility with old versions
 		common.MemoryCacheEnabled = true
@@ -113,6 +124,47 @@ func main() {
 			controller.UpdateTaskBulk()
 		})
 	}
+	if common.IsMasterNode {
+		gopool.Go(func() {
+			controller.RunBatchTasks()
+		})
+		gopool.Go(func() {
+			controller.CleanExpiredFiles()
+		})
+		gopool.Go(func() {
+			service.CleanExpiredResponseCaches()
+		})
+		gopool.Go(func() {
+			service.CleanExpiredBudgetUsages()
+		})
+		gopool.Go(func() {
+			service.CleanExpiredAuditLogs()
+		})
+		gopool.Go(func() {
+			service.CleanExpiredCapturedPayloads()
+		})
+		gopool.Go(func() {
+			service.CleanExpiredStoredResponses()
+		})
+		gopool.Go(func() {
+			service.CleanOldSqlLogs()
+		})
+		gopool.Go(func() {
+			service.RunTaskCallbackDeliveries()
+		})
+		gopool.Go(func() {
+			service.CleanExpiredArtifacts()
+		})
+		gopool.Go(func() {
+			service.RunSubscriptionMaintenance()
+		})
+		gopool.Go(func() {
+			service.RunQuotaReconciliation()
+		})
+		gopool.Go(func() {
+			service.RunPendingInvoiceRetry()
+		})
+	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
 		common.SysLog("batch update enabled with interval " + strconv.Itoa(common.BatchUpdateInterval) + "s")
@@ -132,6 +184,11 @@ func main() {
 		common.SysError(fmt.Sprintf("start pyroscope error : %v", err))
 	}
 
+	err = common.StartTracing()
+	if err != nil {
+		common.SysError(fmt.Sprintf("start tracing error : %v", err))
+	}
+
 	// Initialize HTTP server
 	server := gin.New()
 	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
@@ -146,6 +203,7 @@ func main() {
 	// This will cause SSE not to work!!!
 	//server.Use(gzip.Gzip(gzip.DefaultCompression))
 	server.Use(middleware.RequestId())
+	server.Use(middleware.Tracing())
 	middleware.SetUpLogger(server)
 	// Initialize session store
 	store := cookie.NewStore([]byte(common.SessionSecret))
@@ -171,10 +229,24 @@ func main() {
 	// Log startup success message
 	common.LogStartupSuccess(startTime, port)
 
-	err = server.Run(":" + port)
-	if err != nil {
-		common.FatalLog("failed to start HTTP server: " + err.Error())
+	httpServer := &http.Server{Addr: ":" + port, Handler: server}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			common.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+
+	// 收到退出信号后停止接收请求，并写出外部日志存储队列中的日志
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	common.SysLog("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		common.SysError("failed to shut down HTTP server: " + err.Error())
 	}
+	model.CloseLogSinks(30 * time.Second)
 }
 
 func InjectUmamiAnalytics() {
@@ -260,6 +332,17 @@ func InitResources() error {
 	if err != nil {
 		return err
 	}
+	err = model.InitLogSinks()
+	if err != nil {
+		return err
+	}
+
+	service.SetGuardrailModerator(controller.GuardrailModerate)
+
+	err = service.InitArtifactStore()
+	if err != nil {
+		return err
+	}
 
 	// Initialize Redis
 	err = common.InitRedisClient()
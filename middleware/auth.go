@@ -12,10 +12,12 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func validUserInfo(username string, role int) bool {
@@ -180,6 +182,8 @@ func WssAuth(c *gin.Context) {
 
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		_, span := common.StartSpan(c, "auth.token")
+		defer span.End()
 		// 先检测是否为ws
 		if c.Request.Header.Get("Sec-WebSocket-Protocol") != "" {
 			// Sec-WebSocket-Protocol: realtime, openai-insecure-api-key.sk-xxx, openai-beta.realtime-v1
@@ -298,6 +302,8 @@ func TokenAuth() func(c *gin.Context) {
 		if err != nil {
 			return
 		}
+		span.SetAttributes(attribute.Int("user.id", token.UserId), attribute.Int("token.id", token.Id))
+		span.End()
 		c.Next()
 	}
 }
@@ -322,6 +328,23 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	common.SetContextKey(c, constant.ContextKeyTokenUsageLimit, operation_setting.UsageLimit{
+		RPM:         token.RpmLimit,
+		TPM:         token.TpmLimit,
+		Concurrency: token.ConcurrencyLimit,
+	})
+	common.SetContextKey(c, constant.ContextKeyTokenResponseCache, token.ResponseCache)
+	common.SetContextKey(c, constant.ContextKeyTokenBudget, token.GetBudget())
+	common.SetContextKey(c, constant.ContextKeyTokenCallbackUrl, token.CallbackUrl)
+	if token.ProjectId > 0 {
+		project, err := model.GetProjectAccess(token.ProjectId, token.UserId)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusForbidden, err.Error())
+			return err
+		}
+		common.SetContextKey(c, constant.ContextKeyTokenProjectId, project.Id)
+		common.SetContextKey(c, constant.ContextKeyTokenOrgId, project.OrgId)
+	}
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
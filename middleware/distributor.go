@@ -15,10 +15,12 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ModelRequest struct {
@@ -28,6 +30,8 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		_, span := common.StartSpan(c, "distribute")
+		defer span.End()
 		var channel *model.Channel
 		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
@@ -97,12 +101,33 @@ func Distribute() func(c *gin.Context) {
 						common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
 					}
 				}
-				channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
+				// 按请求特征匹配路由规则，可改写分组、渠道标签与模型
+				if rule := service.MatchRoutingRule(c, modelRequest.Model, usingGroup); rule != nil {
+					if rule.Model != "" {
+						modelRequest.Model = rule.Model
+					}
+					if rule.Group != "" {
+						usingGroup = rule.Group
+						common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
+					}
+				}
+				retryParam := &service.RetryParam{
 					Ctx:        c,
 					ModelName:  modelRequest.Model,
 					TokenGroup: usingGroup,
 					Retry:      common.GetPointer(0),
-				})
+				}
+				service.ApplyRoutingRetryParam(c, retryParam)
+				if vm := operation_setting.GetVirtualModel(modelRequest.Model); vm != nil {
+					// 虚拟模型：选择回退链中第一个有可用渠道的真实模型，后续按真实模型转发与计费
+					var realModel string
+					channel, realModel, selectGroup, err = service.SelectVirtualModelChannel(c, vm, retryParam)
+					if err == nil && channel != nil {
+						modelRequest.Model = realModel
+					}
+				} else {
+					channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(retryParam)
+				}
 				if err != nil {
 					showGroup := usingGroup
 					if usingGroup == "auto" {
@@ -125,6 +150,18 @@ func Distribute() func(c *gin.Context) {
 		}
 		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
 		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		span.SetAttributes(attribute.String("model", modelRequest.Model), attribute.String("group", common.GetContextKeyString(c, constant.ContextKeyUsingGroup)))
+		if channel != nil {
+			span.SetAttributes(attribute.Int("channel.id", channel.Id), attribute.Int("channel.type", channel.Type))
+		}
+		span.End()
+		// 分组在选择渠道后才确定（auto 分组、路由规则改写），此时再检查分组维度的限流
+		releaseGroupLimit, apiErr := service.ApplyGroupUsageLimit(c)
+		if apiErr != nil {
+			abortWithOpenAiMessage(c, apiErr.StatusCode, apiErr.Error(), string(types.ErrorCodeRateLimitExceeded))
+			return
+		}
+		defer releaseGroupLimit()
 		c.Next()
 	}
 }
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsAuth 校验 /metrics 的访问权限，支持 Bearer Token 与 IP 白名单，两者满足其一即可
+func MetricsAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if common.MetricsToken == "" && len(common.MetricsAllowedIps) == 0 {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if common.MetricsToken != "" {
+			token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+			if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(common.MetricsToken)) == 1 {
+				c.Next()
+				return
+			}
+		}
+		if len(common.MetricsAllowedIps) > 0 {
+			ip := net.ParseIP(c.ClientIP())
+			if ip != nil && common.IsIpInCIDRList(ip, common.MetricsAllowedIps) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
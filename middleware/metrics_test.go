@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		token      string
+		allowedIps []string
+		auth       string
+		remoteAddr string
+		wantStatus int
+	}{
+		{name: "not configured", auth: "Bearer secret", wantStatus: http.StatusNotFound},
+		{name: "valid token", token: "secret", auth: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "invalid token", token: "secret", auth: "Bearer wrong", wantStatus: http.StatusUnauthorized},
+		{name: "missing token", token: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "allowed ip", allowedIps: []string{"10.0.0.1"}, remoteAddr: "10.0.0.1:1234", wantStatus: http.StatusOK},
+		{name: "allowed cidr", allowedIps: []string{"10.0.0.0/24"}, remoteAddr: "10.0.0.8:1234", wantStatus: http.StatusOK},
+		{name: "ip not allowed", allowedIps: []string{"10.0.0.0/24"}, remoteAddr: "10.0.1.8:1234", wantStatus: http.StatusUnauthorized},
+		{name: "token or ip, ip matches", token: "secret", allowedIps: []string{"10.0.0.1"}, auth: "Bearer wrong", remoteAddr: "10.0.0.1:1234", wantStatus: http.StatusOK},
+		{name: "token or ip, token matches", token: "secret", allowedIps: []string{"10.0.0.1"}, auth: "Bearer secret", remoteAddr: "10.0.0.2:1234", wantStatus: http.StatusOK},
+		{name: "token or ip, neither matches", token: "secret", allowedIps: []string{"10.0.0.1"}, remoteAddr: "10.0.0.2:1234", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origToken, origIps := common.MetricsToken, common.MetricsAllowedIps
+			t.Cleanup(func() { common.MetricsToken, common.MetricsAllowedIps = origToken, origIps })
+			common.MetricsToken, common.MetricsAllowedIps = tt.token, tt.allowedIps
+
+			router := gin.New()
+			router.GET("/metrics", MetricsAuth(), gin.WrapH(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})))
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				require.Contains(t, w.Body.String(), "http_active_connections")
+			} else {
+				require.NotContains(t, w.Body.String(), "http_active_connections")
+			}
+		})
+	}
+}
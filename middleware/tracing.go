@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Tracing 为每个请求创建根 span，客户端传入的 traceparent 作为父节点
+func Tracing() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !common.TracingEnabled {
+			c.Next()
+			return
+		}
+		ctx, span := common.StartServerSpan(c.Request, c.Request.Method+" "+c.Request.URL.Path,
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("request.id", c.GetString(common.RequestIdKey)),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		if route := c.FullPath(); route != "" {
+			span.SetName(c.Request.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("status %d", status))
+		}
+	}
+}
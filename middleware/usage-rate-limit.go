@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UsageRateLimit 按令牌、用户限制 RPM 与并发数，分组维度在 Distribute 选出渠道后检查，TPM 在 Relay 中按预估 token 数检查
+func UsageRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !operation_setting.GetUsageLimitSetting().Enabled {
+			c.Next()
+			return
+		}
+		scopes := service.ResolveUsageLimitScopes(c)
+		if len(scopes) == 0 {
+			c.Next()
+			return
+		}
+
+		release, apiErr := service.AcquireUsageConcurrency(c, scopes)
+		if apiErr != nil {
+			abortWithOpenAiMessage(c, apiErr.StatusCode, apiErr.Error(), string(types.ErrorCodeRateLimitExceeded))
+			return
+		}
+		defer release()
+
+		if apiErr = service.CheckUsageRequestLimit(c, scopes); apiErr != nil {
+			abortWithOpenAiMessage(c, apiErr.StatusCode, apiErr.Error(), string(types.ErrorCodeRateLimitExceeded))
+			return
+		}
+		c.Next()
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/samber/lo"
 	"gorm.io/gorm"
@@ -58,12 +59,20 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
+// abilityQuery 查询分组与模型下已启用的能力，tag 非空时只匹配该标签的渠道
+func abilityQuery(group string, model string, tag string) *gorm.DB {
+	query := DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
+	if tag != "" {
+		query = query.Where("tag = ?", tag)
+	}
+	return query
+}
+
+func getPriority(group string, model string, tag string, retry int) (int, error) {
 
 	var priorities []int
-	err := DB.Model(&Ability{}).
+	err := abilityQuery(group, model, tag).
 		Select("DISTINCT(priority)").
-		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
 		Order("priority DESC").              // 按优先级降序排序
 		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
 
@@ -88,26 +97,26 @@ func getPriority(group string, model string, retry int) (int, error) {
 	return priorityToUse, nil
 }
 
-func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
-	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, true, maxPrioritySubQuery)
+func getChannelQuery(group string, model string, tag string, retry int) (*gorm.DB, error) {
+	maxPrioritySubQuery := abilityQuery(group, model, tag).Select("MAX(priority)")
+	channelQuery := abilityQuery(group, model, tag).Where("priority = (?)", maxPrioritySubQuery)
 	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
+		priority, err := getPriority(group, model, tag, retry)
 		if err != nil {
 			return nil, err
 		} else {
-			channelQuery = DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = ?", group, model, true, priority)
+			channelQuery = abilityQuery(group, model, tag).Where("priority = ?", priority)
 		}
 	}
 
 	return channelQuery, nil
 }
 
-func GetChannel(group string, model string, retry int) (*Channel, error) {
+func GetChannel(group string, model string, tag string, retry int) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
-	channelQuery, err := getChannelQuery(group, model, retry)
+	channelQuery, err := getChannelQuery(group, model, tag, retry)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +129,15 @@ func GetChannel(group string, model string, retry int) (*Channel, error) {
 		return nil, err
 	}
 	channel := Channel{}
-	if len(abilities) > 0 {
+	if len(abilities) > 0 && operation_setting.GetChannelHealthSetting().Enabled {
+		channelIds := make([]int, len(abilities))
+		weights := make([]int, len(abilities))
+		for i, ability_ := range abilities {
+			channelIds[i] = ability_.ChannelId
+			weights[i] = int(ability_.Weight)
+		}
+		channel.Id = channelIds[pickChannelByHealth(channelIds, weights)]
+	} else if len(abilities) > 0 {
 		// Randomly choose one
 		weightSum := uint(0)
 		for _, ability_ := range abilities {
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+)
+
+const (
+	ArtifactTaskTypeTask       = "task"
+	ArtifactTaskTypeMidjourney = "midjourney"
+)
+
+// Artifact 转存到本地或 S3 的任务生成结果，StorageKey 为存储后端中的文件名
+type Artifact struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TaskType    string `json:"task_type" gorm:"type:varchar(20);index:idx_artifact_task,priority:1"`
+	TaskId      string `json:"task_id" gorm:"type:varchar(191);index:idx_artifact_task,priority:2"`
+	StorageKey  string `json:"-" gorm:"type:varchar(255)"`
+	ContentType string `json:"content_type" gorm:"type:varchar(128)"`
+	Size        int64  `json:"size" gorm:"bigint"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"bigint;index"`
+	Url         string `json:"url,omitempty" gorm:"-"`
+}
+
+func (artifact *Artifact) Insert() error {
+	if artifact.CreatedAt == 0 {
+		artifact.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(artifact).Error
+}
+
+func (artifact *Artifact) IsExpired() bool {
+	return artifact.ExpiresAt > 0 && artifact.ExpiresAt < common.GetTimestamp()
+}
+
+func GetArtifactById(id int) (*Artifact, error) {
+	artifact := &Artifact{}
+	err := DB.First(artifact, "id = ?", id).Error
+	return artifact, err
+}
+
+// GetArtifactByTask 获取任务最近一次转存的结果
+func GetArtifactByTask(taskType string, taskId string) (*Artifact, error) {
+	artifact := &Artifact{}
+	err := DB.Where("task_type = ? AND task_id = ?", taskType, taskId).Order("id desc").First(artifact).Error
+	return artifact, err
+}
+
+func GetUserArtifacts(userId int, startIdx int, num int) (artifacts []*Artifact, total int64, err error) {
+	tx := DB.Model(&Artifact{}).Where("user_id = ?", userId)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&artifacts).Error
+	return artifacts, total, err
+}
+
+// GetUserArtifactSize 统计用户已占用的存储空间
+func GetUserArtifactSize(userId int) (int64, error) {
+	var size int64
+	err := DB.Model(&Artifact{}).Where("user_id = ?", userId).Select("COALESCE(SUM(size), 0)").Scan(&size).Error
+	return size, err
+}
+
+// GetExpiredArtifacts 获取已过期的转存结果，用于清理
+func GetExpiredArtifacts(now int64, limit int) (artifacts []*Artifact, err error) {
+	err = DB.Where("expires_at > 0 AND expires_at < ?", now).Limit(limit).Find(&artifacts).Error
+	return artifacts, err
+}
+
+func DeleteArtifactById(id int) error {
+	return DB.Delete(&Artifact{}, id).Error
+}
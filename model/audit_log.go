@@ -0,0 +1,114 @@
+package model
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// 审计目标类型
+const (
+	AuditTargetChannel    = "channel"
+	AuditTargetChannelTag = "channel_tag"
+	AuditTargetOption     = "option"
+	AuditTargetUser       = "user"
+	AuditTargetRedemption = "redemption"
+	AuditTargetOrg        = "organization"
+	AuditTargetPlan       = "subscription_plan"
+	AuditTargetSub        = "subscription"
+	AuditTargetInvoice    = "invoice"
+)
+
+// 审计动作
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// AuditLog 管理操作与配置变更的审计记录，Diff 为字段级变更 JSON，敏感字段已脱敏
+type AuditLog struct {
+	Id         int    `json:"id"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint;index"`
+	ActorId    int    `json:"actor_id" gorm:"index"`
+	ActorName  string `json:"actor_name" gorm:"index;default:''"`
+	ActorRole  int    `json:"actor_role"`
+	Ip         string `json:"ip" gorm:"default:''"`
+	Action     string `json:"action" gorm:"type:varchar(64);index"`
+	TargetType string `json:"target_type" gorm:"type:varchar(32);index:idx_audit_target,priority:1"`
+	TargetId   string `json:"target_id" gorm:"type:varchar(128);index:idx_audit_target,priority:2"`
+	Diff       string `json:"diff" gorm:"type:text"`
+}
+
+type AuditLogFilter struct {
+	ActorId        int
+	ActorName      string
+	Action         string
+	TargetType     string
+	TargetId       string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+func (log *AuditLog) Insert() error {
+	return LOG_DB.Create(log).Error
+}
+
+func auditLogQuery(filter AuditLogFilter) *gorm.DB {
+	tx := LOG_DB.Model(&AuditLog{})
+	if filter.ActorId != 0 {
+		tx = tx.Where("actor_id = ?", filter.ActorId)
+	}
+	if filter.ActorName != "" {
+		tx = tx.Where("actor_name = ?", filter.ActorName)
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		tx = tx.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetId != "" {
+		tx = tx.Where("target_id = ?", filter.TargetId)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	return tx
+}
+
+func GetAuditLogs(filter AuditLogFilter, startIdx int, num int) (logs []*AuditLog, total int64, err error) {
+	tx := auditLogQuery(filter)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	return logs, total, err
+}
+
+// GetAuditLogsAfterId 按 id 升序分批读取，用于导出
+func GetAuditLogsAfterId(filter AuditLogFilter, afterId int, num int) (logs []*AuditLog, err error) {
+	err = auditLogQuery(filter).Where("id > ?", afterId).Order("id asc").Limit(num).Find(&logs).Error
+	return logs, err
+}
+
+func DeleteOldAuditLogs(ctx context.Context, targetTimestamp int64, limit int) (int64, error) {
+	var total int64 = 0
+	for {
+		if nil != ctx.Err() {
+			return total, ctx.Err()
+		}
+		result := LOG_DB.Where("created_at < ?", targetTimestamp).Limit(limit).Delete(&AuditLog{})
+		if nil != result.Error {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < int64(limit) {
+			break
+		}
+	}
+	return total, nil
+}
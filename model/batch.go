@@ -0,0 +1,164 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch OpenAI Batch API 任务，由网关逐行通过 relay 流水线执行
+type Batch struct {
+	Id               int    `json:"-"`
+	BatchId          string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int    `json:"-" gorm:"index"`
+	TokenId          int    `json:"-" gorm:"index"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(20);index"`
+	Errors           string `json:"errors"`
+	Metadata         string `json:"metadata"`
+	TotalCount       int    `json:"total_count"`
+	CompletedCount   int    `json:"completed_count"`
+	FailedCount      int    `json:"failed_count"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+}
+
+func (b *Batch) Insert() error {
+	if b.CreatedAt == 0 {
+		b.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(b).Error
+}
+
+func (b *Batch) IsFinished() bool {
+	switch b.Status {
+	case BatchStatusCompleted, BatchStatusFailed, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// Update 执行器写入批处理结果，仅在批处理仍在执行中或取消中时写入，不会覆盖已结束的状态；
+// cancelling_at 只由 CancelUserBatch 写入
+func (b *Batch) Update() error {
+	return DB.Model(b).Where("status IN ?", []string{BatchStatusInProgress, BatchStatusCancelling}).
+		Select("status", "output_file_id", "error_file_id", "errors", "total_count", "completed_count",
+			"failed_count", "in_progress_at", "finalizing_at", "completed_at", "failed_at", "expired_at",
+			"cancelled_at").Updates(b).Error
+}
+
+// UpdateTotalCount 只写入请求总数，避免覆盖执行期间由 CancelUserBatch 写入的取消状态
+func (b *Batch) UpdateTotalCount() error {
+	return DB.Model(b).Update("total_count", b.TotalCount).Error
+}
+
+func GetUserBatch(userId int, batchId string) (*Batch, error) {
+	if batchId == "" {
+		return nil, errors.New("batch id is empty")
+	}
+	var batch Batch
+	err := DB.Where("user_id = ? AND batch_id = ?", userId, batchId).First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func GetBatchStatus(id int) (string, error) {
+	var batch Batch
+	err := DB.Select("status").Where("id = ?", id).First(&batch).Error
+	return batch.Status, err
+}
+
+// GetUserBatches 按创建时间倒序列出用户批处理，after 为上一页最后一个批处理 id
+func GetUserBatches(userId int, after string, limit int) (batches []*Batch, err error) {
+	tx := DB.Where("user_id = ?", userId)
+	if after != "" {
+		var cursor Batch
+		if err = DB.Where("user_id = ? AND batch_id = ?", userId, after).First(&cursor).Error; err == nil {
+			tx = tx.Where("id < ?", cursor.Id)
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	err = tx.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// ClaimPendingBatch 原子地将一个待执行的批处理置为执行中，多实例下保证只有一个节点执行
+func ClaimPendingBatch() (*Batch, error) {
+	var batch Batch
+	err := DB.Where("status = ?", BatchStatusValidating).Order("id asc").First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	now := common.GetTimestamp()
+	result := DB.Model(&Batch{}).Where("id = ? AND status = ?", batch.Id, BatchStatusValidating).
+		Updates(map[string]any{"status": BatchStatusInProgress, "in_progress_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	batch.Status = BatchStatusInProgress
+	batch.InProgressAt = now
+	return &batch, nil
+}
+
+// CancelUserBatch 将批处理标记为取消中，执行器会在处理下一行之前停止
+func CancelUserBatch(batch *Batch) error {
+	now := common.GetTimestamp()
+	if batch.Status == BatchStatusValidating {
+		batch.Status = BatchStatusCancelled
+		batch.CancelledAt = now
+	} else {
+		batch.Status = BatchStatusCancelling
+	}
+	batch.CancellingAt = now
+	result := DB.Model(&Batch{}).Where("id = ? AND status IN ?", batch.Id, []string{BatchStatusValidating, BatchStatusInProgress}).
+		Updates(map[string]any{"status": batch.Status, "cancelling_at": now, "cancelled_at": batch.CancelledAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("batch cannot be cancelled in its current status")
+	}
+	return nil
+}
+
+// FailInterruptedBatches 将因进程重启而中断的批处理标记为失败，已执行的行已经计费，不能重新执行
+func FailInterruptedBatches(errorsJson string) error {
+	now := common.GetTimestamp()
+	err := DB.Model(&Batch{}).Where("status IN ?", []string{BatchStatusInProgress, BatchStatusFinalizing}).
+		Updates(map[string]any{"status": BatchStatusFailed, "failed_at": now, "errors": errorsJson}).Error
+	if err != nil {
+		return err
+	}
+	return DB.Model(&Batch{}).Where("status = ?", BatchStatusCancelling).
+		Updates(map[string]any{"status": BatchStatusCancelled, "cancelled_at": now}).Error
+}
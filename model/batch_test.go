@@ -0,0 +1,130 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelUserBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		wantStatus string
+		wantErr    bool
+	}{
+		{name: "validating is cancelled at once", status: BatchStatusValidating, wantStatus: BatchStatusCancelled},
+		{name: "in progress becomes cancelling", status: BatchStatusInProgress, wantStatus: BatchStatusCancelling},
+		{name: "completed cannot be cancelled", status: BatchStatusCompleted, wantStatus: BatchStatusCompleted, wantErr: true},
+		{name: "cancelling cannot be cancelled again", status: BatchStatusCancelling, wantStatus: BatchStatusCancelling, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t, &Batch{})
+			batch := &Batch{BatchId: "batch_1", UserId: 1, Status: tt.status}
+			require.NoError(t, batch.Insert())
+
+			err := CancelUserBatch(batch)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.NotZero(t, batch.CancellingAt)
+			}
+			status, err := GetBatchStatus(batch.Id)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStatus, status)
+		})
+	}
+}
+
+func TestBatchProgressKeepsCancelling(t *testing.T) {
+	tests := []struct {
+		name       string
+		write      func(b *Batch) error
+		dbStatus   string
+		wantStatus string
+		wantTotal  int
+	}{
+		{
+			name:       "total count does not overwrite cancelling",
+			write:      func(b *Batch) error { return b.UpdateTotalCount() },
+			dbStatus:   BatchStatusCancelling,
+			wantStatus: BatchStatusCancelling,
+			wantTotal:  3,
+		},
+		{
+			name: "final update applies while cancelling",
+			write: func(b *Batch) error {
+				b.Status = BatchStatusCancelled
+				return b.Update()
+			},
+			dbStatus:   BatchStatusCancelling,
+			wantStatus: BatchStatusCancelled,
+			wantTotal:  3,
+		},
+		{
+			name: "final update does not reopen a finished batch",
+			write: func(b *Batch) error {
+				b.Status = BatchStatusCompleted
+				return b.Update()
+			},
+			dbStatus:   BatchStatusFailed,
+			wantStatus: BatchStatusFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, &Batch{})
+			require.NoError(t, (&Batch{BatchId: "batch_1", UserId: 1, Status: BatchStatusValidating}).Insert())
+			batch, err := ClaimPendingBatch()
+			require.NoError(t, err)
+			require.Equal(t, BatchStatusInProgress, batch.Status)
+			// 认领之后由取消或其他流程并发写入状态
+			require.NoError(t, db.Model(&Batch{}).Where("id = ?", batch.Id).Update("status", tt.dbStatus).Error)
+
+			batch.TotalCount = 3
+			require.NoError(t, tt.write(batch))
+
+			var saved Batch
+			require.NoError(t, db.First(&saved, batch.Id).Error)
+			require.Equal(t, tt.wantStatus, saved.Status)
+			require.Equal(t, tt.wantTotal, saved.TotalCount)
+		})
+	}
+}
+
+func TestClaimPendingBatch(t *testing.T) {
+	setupTestDB(t, &Batch{})
+	_, err := ClaimPendingBatch()
+	require.Error(t, err)
+
+	require.NoError(t, (&Batch{BatchId: "batch_1", UserId: 1, Status: BatchStatusValidating}).Insert())
+	batch, err := ClaimPendingBatch()
+	require.NoError(t, err)
+	require.Equal(t, "batch_1", batch.BatchId)
+	require.NotZero(t, batch.InProgressAt)
+
+	_, err = ClaimPendingBatch()
+	require.Error(t, err, "a claimed batch must not be claimed twice")
+}
+
+func TestFailInterruptedBatches(t *testing.T) {
+	db := setupTestDB(t, &Batch{})
+	statuses := map[string]string{
+		BatchStatusValidating: BatchStatusValidating,
+		BatchStatusInProgress: BatchStatusFailed,
+		BatchStatusFinalizing: BatchStatusFailed,
+		BatchStatusCancelling: BatchStatusCancelled,
+		BatchStatusCompleted:  BatchStatusCompleted,
+	}
+	for status := range statuses {
+		require.NoError(t, (&Batch{BatchId: "batch_" + status, Status: status}).Insert())
+	}
+	require.NoError(t, FailInterruptedBatches(`{"object":"list"}`))
+	for status, want := range statuses {
+		var saved Batch
+		require.NoError(t, db.Where("batch_id = ?", "batch_"+status).First(&saved).Error)
+		require.Equal(t, want, saved.Status, status)
+	}
+}
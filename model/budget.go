@@ -0,0 +1,100 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BudgetUsage 周期预算的已用额度，未启用 Redis 时使用
+type BudgetUsage struct {
+	Id        int    `json:"id"`
+	UsageKey  string `json:"usage_key" gorm:"type:varchar(128);uniqueIndex"`
+	Used      int    `json:"used" gorm:"default:0"`
+	ExpiresAt int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func GetBudgetUsage(key string) (int, error) {
+	if common.RedisEnabled {
+		used, err := common.RDB.Get(context.Background(), key).Int()
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return used, err
+	}
+	var usage BudgetUsage
+	err := DB.Where("usage_key = ?", key).Limit(1).Find(&usage).Error
+	return usage.Used, err
+}
+
+// IncreaseBudgetUsage 累加周期用量（delta 可为负），返回累加后的用量
+func IncreaseBudgetUsage(key string, delta int, expiresAt time.Time) (int, error) {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		pipe := common.RDB.TxPipeline()
+		incr := pipe.IncrBy(ctx, key, int64(delta))
+		pipe.ExpireAt(ctx, key, expiresAt)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return 0, err
+		}
+		return int(incr.Val()), nil
+	}
+	err := DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "usage_key"}},
+		// 带表名引用原值，避免 PostgreSQL 报列名不明确
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"used": gorm.Expr("budget_usages.used + ?", delta),
+		}),
+	}).Create(&BudgetUsage{UsageKey: key, Used: delta, ExpiresAt: expiresAt.Unix()}).Error
+	if err != nil {
+		return 0, err
+	}
+	return GetBudgetUsage(key)
+}
+
+// reserveBudgetScript 用量加上 delta 不超过 limit 时才累加，返回 {是否成功, 用量}
+var reserveBudgetScript = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+local delta = tonumber(ARGV[1])
+if used >= tonumber(ARGV[2]) or used + delta > tonumber(ARGV[2]) then
+	return {0, used}
+end
+used = redis.call('INCRBY', KEYS[1], delta)
+redis.call('EXPIREAT', KEYS[1], ARGV[3])
+return {1, used}
+`)
+
+// ReserveBudgetUsage 在同一次原子操作中检查并累加周期用量，已用完或累加后超过 limit 时不计入并返回 false
+func ReserveBudgetUsage(key string, delta int, limit int, expiresAt time.Time) (bool, int, error) {
+	if common.RedisEnabled {
+		values, err := reserveBudgetScript.Run(context.Background(), common.RDB, []string{key}, delta, limit, expiresAt.Unix()).Int64Slice()
+		if err != nil {
+			return false, 0, err
+		}
+		return values[0] == 1, int(values[1]), nil
+	}
+	err := DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&BudgetUsage{UsageKey: key, ExpiresAt: expiresAt.Unix()}).Error
+	if err != nil {
+		return false, 0, err
+	}
+	result := DB.Model(&BudgetUsage{}).
+		Where("usage_key = ? AND used < ? AND used + ? <= ?", key, limit, delta, limit).
+		Update("used", gorm.Expr("used + ?", delta))
+	if result.Error != nil {
+		return false, 0, result.Error
+	}
+	used, err := GetBudgetUsage(key)
+	return result.RowsAffected > 0, used, err
+}
+
+func DeleteExpiredBudgetUsages(now int64) (int64, error) {
+	result := DB.Where("expires_at < ?", now).Delete(&BudgetUsage{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,33 @@
+package model
+
+// CapturedPayload 采集的请求/响应内容，内容在保存前已脱敏
+type CapturedPayload struct {
+	Id              int    `json:"id"`
+	RequestId       string `json:"request_id" gorm:"type:varchar(64);index"`
+	UserId          int    `json:"user_id" gorm:"index"`
+	TokenId         int    `json:"token_id"`
+	ChannelId       int    `json:"channel_id"`
+	ModelName       string `json:"model_name" gorm:"default:''"`
+	StatusCode      int    `json:"status_code"`
+	ErrorMessage    string `json:"error_message" gorm:"type:text"`
+	Request         string `json:"request" gorm:"type:text"`          // 客户端请求体
+	UpstreamRequest string `json:"upstream_request" gorm:"type:text"` // 参数覆盖后发往上游的请求体
+	Response        string `json:"response" gorm:"type:text"`         // 返回给客户端的响应，流式为拼接后的全部事件
+	Truncated       bool   `json:"truncated"`
+	CreatedAt       int64  `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt       int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func (payload *CapturedPayload) Insert() error {
+	return LOG_DB.Create(payload).Error
+}
+
+func GetCapturedPayloadsByRequestId(requestId string) (payloads []*CapturedPayload, err error) {
+	err = LOG_DB.Where("request_id = ?", requestId).Order("id asc").Find(&payloads).Error
+	return payloads, err
+}
+
+func DeleteExpiredCapturedPayloads(now int64) (int64, error) {
+	result := LOG_DB.Where("expires_at < ?", now).Delete(&CapturedPayload{})
+	return result.RowsAffected, result.Error
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"strings"
 	"sync"
 
@@ -22,6 +23,7 @@ type Channel struct {
 	Id                 int     `json:"id"`
 	Type               int     `json:"type" gorm:"default:0"`
 	Key                string  `json:"key" gorm:"not null"`
+	KeyHash            string  `json:"-" gorm:"type:varchar(64);index"` // 密钥摘要，密钥加密存储时用于按密钥搜索
 	OpenAIOrganization *string `json:"openai_organization"`
 	TestModel          *string `json:"test_model"`
 	Status             int     `json:"status" gorm:"default:1"`
@@ -78,6 +80,60 @@ func (c *ChannelInfo) Scan(value interface{}) error {
 	return common.Unmarshal(bytesValue, c)
 }
 
+// BeforeSave 写入数据库前加密渠道密钥，兼容 Update("key", ...) 等按列更新的写法
+func (channel *Channel) BeforeSave(tx *gorm.DB) error {
+	if values, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		if key, ok := values["key"].(string); ok {
+			if !common.IsEncryptedSecret(key) {
+				values["key_hash"] = common.SecretLookupHash(key)
+			}
+			encrypted, err := common.EncryptSecret(key)
+			if err != nil {
+				return err
+			}
+			values["key"] = encrypted
+		}
+		return nil
+	}
+	target := channel
+	if dest, ok := tx.Statement.Dest.(*Channel); ok {
+		target = dest
+	}
+	if target.Key != "" && !common.IsEncryptedSecret(target.Key) {
+		target.KeyHash = common.SecretLookupHash(target.Key)
+	}
+	encrypted, err := common.EncryptSecret(target.Key)
+	if err != nil {
+		return err
+	}
+	target.Key = encrypted
+	return nil
+}
+
+// AfterSave 保存后恢复明文，避免调用方及缓存拿到密文
+func (channel *Channel) AfterSave(tx *gorm.DB) error {
+	if dest, ok := tx.Statement.Dest.(*Channel); ok && dest != channel {
+		if err := dest.decryptKey(); err != nil {
+			return err
+		}
+	}
+	return channel.decryptKey()
+}
+
+// AfterFind 读取后解密渠道密钥
+func (channel *Channel) AfterFind(tx *gorm.DB) error {
+	return channel.decryptKey()
+}
+
+func (channel *Channel) decryptKey() error {
+	key, err := common.DecryptSecret(channel.Key)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt key of channel #%d: %w", channel.Id, err)
+	}
+	channel.Key = key
+	return nil
+}
+
 func (channel *Channel) GetKeys() []string {
 	if channel.Key == "" {
 		return []string{}
@@ -144,12 +200,18 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 	if len(enabledIdx) == 0 {
 		return "", 0, types.NewError(errors.New("no enabled keys"), types.ErrorCodeChannelNoAvailableKey)
 	}
+	// 跳过已熔断的 Key，选中半开状态的 Key 时占用探测名额；
+	// 全部熔断时 filterAvailableKeyIndexes 退化为全部 Key，此时占用失败也照常使用
+	enabledIdx = filterAvailableKeyIndexes(channel.Id, enabledIdx)
+	selectKey := func(idx int) (string, int, *types.NewAPIError) {
+		tryClaimChannel(channel.Id, idx)
+		return keys[idx], idx, nil
+	}
 
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
 		// Randomly pick one enabled key
-		selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
-		return keys[selectedIdx], selectedIdx, nil
+		return selectKey(enabledIdx[rand.Intn(len(enabledIdx))])
 	case constant.MultiKeyModePolling:
 		// Use channel-specific lock to ensure thread-safe polling
 
@@ -175,17 +237,17 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		for i := 0; i < len(keys); i++ {
 			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
+			if getStatus(idx) == common.ChannelStatusEnabled && slices.Contains(enabledIdx, idx) {
 				// update polling index for next call (point to the next position)
 				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
-				return keys[idx], idx, nil
+				return selectKey(idx)
 			}
 		}
 		// Fallback – should not happen, but return first enabled key
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		return selectKey(enabledIdx[0])
 	default:
 		// Unknown mode, default to first enabled key (or original key string)
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		return selectKey(enabledIdx[0])
 	}
 }
 
@@ -323,11 +385,11 @@ func SearchChannels(keyword string, group string, model string, idSort bool) ([]
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR key_hash = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", common.SecretLookupHash(keyword), "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR key_hash = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", common.SecretLookupHash(keyword), "%"+keyword+"%", "%"+model+"%")
 	}
 
 	// 执行查询
@@ -403,7 +465,13 @@ func BatchDeleteChannels(ids []int) error {
 			return err
 		}
 	}
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		RemoveChannelHealth(id, false)
+	}
+	return nil
 }
 
 func (channel *Channel) GetPriority() int64 {
@@ -530,6 +598,7 @@ func (channel *Channel) Delete() error {
 	if err != nil {
 		return err
 	}
+	RemoveChannelHealth(channel.Id, false)
 	err = channel.DeleteAbilities()
 	return err
 }
@@ -769,12 +838,26 @@ func updateChannelUsedQuota(id int, quota int) {
 }
 
 func DeleteChannelByStatus(status int64) (int64, error) {
+	var ids []int
+	DB.Model(&Channel{}).Where("status = ?", status).Pluck("id", &ids)
 	result := DB.Where("status = ?", status).Delete(&Channel{})
+	if result.Error == nil {
+		for _, id := range ids {
+			RemoveChannelHealth(id, false)
+		}
+	}
 	return result.RowsAffected, result.Error
 }
 
 func DeleteDisabledChannel() (int64, error) {
+	var ids []int
+	DB.Model(&Channel{}).Where("status = ? or status = ?", common.ChannelStatusAutoDisabled, common.ChannelStatusManuallyDisabled).Pluck("id", &ids)
 	result := DB.Where("status = ? or status = ?", common.ChannelStatusAutoDisabled, common.ChannelStatusManuallyDisabled).Delete(&Channel{})
+	if result.Error == nil {
+		for _, id := range ids {
+			RemoveChannelHealth(id, false)
+		}
+	}
 	return result.RowsAffected, result.Error
 }
 
@@ -818,11 +901,11 @@ func SearchTags(keyword string, group string, model string, idSort bool) ([]*str
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR key_hash = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", common.SecretLookupHash(keyword), "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR key_hash = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", common.SecretLookupHash(keyword), "%"+keyword+"%", "%"+model+"%")
 	}
 
 	subQuery := baseQuery.Where(whereClause, args...).
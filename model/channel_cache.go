@@ -11,6 +11,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 )
 
@@ -93,10 +94,11 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+// GetRandomSatisfiedChannel 按优先级与权重选择渠道，tag 非空时只在该标签的渠道中选择
+func GetRandomSatisfiedChannel(group string, model string, tag string, retry int) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetChannel(group, model, retry)
+		return GetChannel(group, model, tag, retry)
 	}
 
 	channelSyncLock.RLock()
@@ -111,6 +113,16 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		channels = group2model2channels[group][normalizedModel]
 	}
 
+	if tag != "" {
+		tagged := make([]int, 0, len(channels))
+		for _, channelId := range channels {
+			if channel, ok := channelsIDM[channelId]; ok && channel.GetTag() == tag {
+				tagged = append(tagged, channelId)
+			}
+		}
+		channels = tagged
+	}
+
 	if len(channels) == 0 {
 		return nil, nil
 	}
@@ -159,6 +171,17 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
 	}
 
+	// 启用熔断时按实时健康度选择
+	if operation_setting.GetChannelHealthSetting().Enabled {
+		channelIds := make([]int, len(targetChannels))
+		weights := make([]int, len(targetChannels))
+		for i, channel := range targetChannels {
+			channelIds[i] = channel.Id
+			weights[i] = channel.GetWeight()
+		}
+		return targetChannels[pickChannelByHealth(channelIds, weights)], nil
+	}
+
 	// smoothing factor and adjustment
 	smoothingFactor := 1
 	smoothingAdjustment := 0
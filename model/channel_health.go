@@ -0,0 +1,500 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+const (
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half_open"
+)
+
+const (
+	channelHealthBuckets   = 10
+	channelBreakerRedisKey = "channel_breaker"
+	channelBreakerSyncTick = 2 * time.Second
+	// 渠道维度的健康度使用 -1 作为 key index
+	channelHealthWholeKey = -1
+)
+
+type healthBucket struct {
+	start   int64
+	success int
+	failure int
+}
+
+// channelHealth 单个渠道（或多 Key 渠道中的单个 Key）的健康状态
+type channelHealth struct {
+	mutex        sync.Mutex
+	buckets      [channelHealthBuckets]healthBucket
+	latencyEWMA  float64 // 毫秒
+	ttftEWMA     float64 // 毫秒
+	state        string
+	openUntil    int64 // 毫秒时间戳
+	probing      int
+	probeStarted int64
+}
+
+// ChannelHealthSnapshot 渠道健康状态快照
+type ChannelHealthSnapshot struct {
+	ChannelId   int     `json:"channel_id"`
+	KeyIndex    int     `json:"key_index"`
+	State       string  `json:"state"`
+	Success     int     `json:"success"`
+	Failure     int     `json:"failure"`
+	ErrorRate   float64 `json:"error_rate"`
+	LatencyMs   float64 `json:"latency_ms"`
+	FirstByteMs float64 `json:"first_byte_ms"`
+	OpenUntil   int64   `json:"open_until"`
+}
+
+var (
+	channelHealthMap      sync.Map // "channelId:keyIndex" -> *channelHealth
+	channelBreakerSyncOne sync.Once
+)
+
+func channelHealthKey(channelId int, keyIndex int) string {
+	return fmt.Sprintf("%d:%d", channelId, keyIndex)
+}
+
+func getChannelHealth(channelId int, keyIndex int, create bool) *channelHealth {
+	key := channelHealthKey(channelId, keyIndex)
+	if value, ok := channelHealthMap.Load(key); ok {
+		return value.(*channelHealth)
+	}
+	if !create {
+		return nil
+	}
+	value, _ := channelHealthMap.LoadOrStore(key, &channelHealth{state: BreakerStateClosed})
+	return value.(*channelHealth)
+}
+
+func healthBucketSeconds() int64 {
+	seconds := int64(operation_setting.GetChannelHealthSetting().WindowSeconds) / channelHealthBuckets
+	if seconds <= 0 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// counts 统计滑动窗口内的成功与失败次数，调用方需持有锁
+func (h *channelHealth) counts(now int64) (success int, failure int) {
+	bucketSeconds := healthBucketSeconds()
+	minStart := now - bucketSeconds*channelHealthBuckets
+	for _, bucket := range h.buckets {
+		if bucket.start > minStart {
+			success += bucket.success
+			failure += bucket.failure
+		}
+	}
+	return
+}
+
+// refreshState 熔断到期后进入半开状态，调用方需持有锁
+func (h *channelHealth) refreshState(nowMs int64) {
+	if h.state == BreakerStateOpen && nowMs >= h.openUntil {
+		h.state = BreakerStateHalfOpen
+		h.probing = 0
+	}
+	// 探测请求长时间未返回结果时释放名额
+	openMs := int64(operation_setting.GetChannelHealthSetting().OpenSeconds) * 1000
+	if h.state == BreakerStateHalfOpen && h.probing > 0 && nowMs-h.probeStarted > openMs {
+		h.probing = 0
+	}
+}
+
+// allowed 判断当前是否可以向该渠道发送请求，调用方需持有锁
+func (h *channelHealth) allowed(nowMs int64) bool {
+	h.refreshState(nowMs)
+	switch h.state {
+	case BreakerStateOpen:
+		return false
+	case BreakerStateHalfOpen:
+		return h.probing < max(operation_setting.GetChannelHealthSetting().HalfOpenRequests, 1)
+	}
+	return true
+}
+
+func (h *channelHealth) open(nowMs int64) int64 {
+	h.state = BreakerStateOpen
+	h.openUntil = nowMs + int64(operation_setting.GetChannelHealthSetting().OpenSeconds)*1000
+	h.probing = 0
+	return h.openUntil
+}
+
+func (h *channelHealth) record(success bool, latency time.Duration, firstByte time.Duration) (openUntil int64) {
+	setting := operation_setting.GetChannelHealthSetting()
+	now := time.Now()
+	nowMs := now.UnixMilli()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	bucketSeconds := healthBucketSeconds()
+	index := now.Unix() / bucketSeconds
+	bucket := &h.buckets[index%channelHealthBuckets]
+	if start := index * bucketSeconds; bucket.start != start {
+		*bucket = healthBucket{start: start}
+	}
+	if success {
+		bucket.success++
+		alpha := setting.EWMAAlpha
+		if alpha <= 0 || alpha > 1 {
+			alpha = 0.3
+		}
+		if latency > 0 {
+			h.latencyEWMA = ewma(h.latencyEWMA, float64(latency.Milliseconds()), alpha)
+		}
+		if firstByte > 0 {
+			h.ttftEWMA = ewma(h.ttftEWMA, float64(firstByte.Milliseconds()), alpha)
+		}
+	} else {
+		bucket.failure++
+	}
+
+	h.refreshState(nowMs)
+	switch h.state {
+	case BreakerStateHalfOpen:
+		if h.probing > 0 {
+			h.probing--
+		}
+		if success {
+			h.state = BreakerStateClosed
+			h.buckets = [channelHealthBuckets]healthBucket{}
+		} else {
+			return h.open(nowMs)
+		}
+	case BreakerStateClosed:
+		if success {
+			return 0
+		}
+		successCount, failureCount := h.counts(now.Unix())
+		total := successCount + failureCount
+		if total >= max(setting.MinRequests, 1) && float64(failureCount)/float64(total) >= setting.ErrorRateThreshold {
+			return h.open(nowMs)
+		}
+	}
+	return 0
+}
+
+func ewma(current float64, value float64, alpha float64) float64 {
+	if current == 0 {
+		return value
+	}
+	return alpha*value + (1-alpha)*current
+}
+
+// RecordChannelResult 记录一次上游请求的结果，用于熔断与健康度加权选择
+func RecordChannelResult(channelId int, keyIndex int, success bool, latency time.Duration, firstByte time.Duration) {
+	if !operation_setting.GetChannelHealthSetting().Enabled || channelId == 0 {
+		return
+	}
+	startChannelBreakerSync()
+	indexes := []int{channelHealthWholeKey}
+	if keyIndex >= 0 {
+		indexes = append(indexes, keyIndex)
+	}
+	for _, index := range indexes {
+		openUntil := getChannelHealth(channelId, index, true).record(success, latency, firstByte)
+		if openUntil > 0 {
+			common.SysLog(fmt.Sprintf("channel #%d key #%d circuit breaker opened until %s", channelId, index,
+				time.UnixMilli(openUntil).Format(time.RFC3339)))
+			publishChannelBreaker(channelId, index, openUntil)
+		}
+	}
+}
+
+// IsChannelAvailable 判断渠道（或多 Key 渠道中的某个 Key）当前是否未熔断
+func IsChannelAvailable(channelId int, keyIndex int) bool {
+	if !operation_setting.GetChannelHealthSetting().Enabled {
+		return true
+	}
+	h := getChannelHealth(channelId, keyIndex, false)
+	if h == nil {
+		return true
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.allowed(time.Now().UnixMilli())
+}
+
+// tryClaimChannel 在同一次加锁中判断渠道（或 Key）是否可用并在半开状态下占用探测名额，
+// 避免并发请求同时通过检查后超出探测数量限制
+func tryClaimChannel(channelId int, keyIndex int) bool {
+	if !operation_setting.GetChannelHealthSetting().Enabled {
+		return true
+	}
+	h := getChannelHealth(channelId, keyIndex, false)
+	if h == nil {
+		return true
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	nowMs := time.Now().UnixMilli()
+	if !h.allowed(nowMs) {
+		return false
+	}
+	if h.state == BreakerStateHalfOpen {
+		h.probing++
+		h.probeStarted = nowMs
+	}
+	return true
+}
+
+// channelHealthScore 返回渠道的成功率与延迟（毫秒，0 表示暂无数据）
+func channelHealthScore(channelId int) (successRate float64, latency float64) {
+	h := getChannelHealth(channelId, channelHealthWholeKey, false)
+	if h == nil {
+		return 1, 0
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	success, failure := h.counts(time.Now().Unix())
+	// 加入先验，避免少量样本导致权重剧烈波动
+	successRate = float64(success+1) / float64(success+failure+1)
+	latency = h.ttftEWMA
+	if latency == 0 {
+		latency = h.latencyEWMA
+	}
+	return successRate, latency
+}
+
+// pickChannelByHealth 在同一优先级内按实时健康度选择渠道，返回选中的下标
+func pickChannelByHealth(channelIds []int, weights []int) int {
+	candidates := make([]int, 0, len(channelIds))
+	for i, channelId := range channelIds {
+		if IsChannelAvailable(channelId, channelHealthWholeKey) {
+			candidates = append(candidates, i)
+		}
+	}
+	// 选中后再占用名额，占用失败（探测名额已被并发请求占满）时从其余渠道中重新选择
+	for len(candidates) > 0 {
+		selected := pickHealthCandidate(channelIds, weights, candidates)
+		if tryClaimChannel(channelIds[selected], channelHealthWholeKey) {
+			return selected
+		}
+		candidates = slices.DeleteFunc(candidates, func(i int) bool { return i == selected })
+	}
+	// 全部熔断时退化为不考虑熔断状态，避免直接返回无可用渠道
+	all := make([]int, len(channelIds))
+	for i := range channelIds {
+		all[i] = i
+	}
+	return pickHealthCandidate(channelIds, weights, all)
+}
+
+// pickHealthCandidate 按选择模式在候选渠道中选出一个，返回选中的下标
+func pickHealthCandidate(channelIds []int, weights []int, candidates []int) int {
+	setting := operation_setting.GetChannelHealthSetting()
+	successRates := make([]float64, len(channelIds))
+	latencies := make([]float64, len(channelIds))
+	minLatency := math.MaxFloat64
+	for _, i := range candidates {
+		successRates[i], latencies[i] = channelHealthScore(channelIds[i])
+		if latencies[i] > 0 && latencies[i] < minLatency {
+			minLatency = latencies[i]
+		}
+	}
+
+	selected := -1
+	if setting.SelectMode == operation_setting.ChannelSelectModeLowestLatency {
+		// 优先探测暂无延迟数据的渠道
+		var untested []int
+		for _, i := range candidates {
+			if latencies[i] == 0 {
+				untested = append(untested, i)
+			} else if selected == -1 || latencies[i]/successRates[i] < latencies[selected]/successRates[selected] {
+				selected = i
+			}
+		}
+		if len(untested) > 0 {
+			selected = untested[rand.Intn(len(untested))]
+		}
+		return selected
+	}
+
+	sumWeight := 0
+	for _, i := range candidates {
+		sumWeight += weights[i]
+	}
+	scores := make([]float64, len(channelIds))
+	totalScore := 0.0
+	for _, i := range candidates {
+		base := float64(weights[i])
+		if sumWeight == 0 {
+			base = 1
+		}
+		factor := successRates[i]
+		if latencies[i] > 0 && minLatency < math.MaxFloat64 {
+			factor *= math.Max(minLatency/latencies[i], 0.2)
+		}
+		scores[i] = base * factor
+		totalScore += scores[i]
+	}
+	if totalScore <= 0 {
+		return candidates[rand.Intn(len(candidates))]
+	}
+	random := rand.Float64() * totalScore
+	for _, i := range candidates {
+		random -= scores[i]
+		if random < 0 {
+			return i
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// filterAvailableKeyIndexes 过滤掉已熔断的 Key，全部熔断时返回原列表
+func filterAvailableKeyIndexes(channelId int, indexes []int) []int {
+	if !operation_setting.GetChannelHealthSetting().Enabled {
+		return indexes
+	}
+	available := make([]int, 0, len(indexes))
+	for _, index := range indexes {
+		if IsChannelAvailable(channelId, index) {
+			available = append(available, index)
+		}
+	}
+	if len(available) == 0 {
+		return indexes
+	}
+	return available
+}
+
+// RemoveChannelHealth 清理已删除渠道的健康状态；keysOnly 为 true 时仅清理各 Key 的状态（删除 Key 后下标会重排）
+func RemoveChannelHealth(channelId int, keysOnly bool) {
+	prefix := fmt.Sprintf("%d:", channelId)
+	wholeKey := channelHealthKey(channelId, channelHealthWholeKey)
+	matched := func(key string) bool {
+		return strings.HasPrefix(key, prefix) && !(keysOnly && key == wholeKey)
+	}
+	channelHealthMap.Range(func(key, value any) bool {
+		if matched(key.(string)) {
+			channelHealthMap.Delete(key)
+		}
+		return true
+	})
+	if !common.RedisEnabled {
+		return
+	}
+	ctx := context.Background()
+	fields, err := common.RDB.HKeys(ctx, channelBreakerRedisKey).Result()
+	if err != nil {
+		common.SysError("failed to remove channel breaker state: " + err.Error())
+		return
+	}
+	var stale []string
+	for _, field := range fields {
+		if matched(field) {
+			stale = append(stale, field)
+		}
+	}
+	if len(stale) > 0 {
+		common.RDB.HDel(ctx, channelBreakerRedisKey, stale...)
+	}
+}
+
+// GetChannelHealthSnapshots 获取所有渠道的健康状态
+func GetChannelHealthSnapshots() []ChannelHealthSnapshot {
+	snapshots := make([]ChannelHealthSnapshot, 0)
+	now := time.Now()
+	channelHealthMap.Range(func(key, value any) bool {
+		parts := strings.SplitN(key.(string), ":", 2)
+		channelId, _ := strconv.Atoi(parts[0])
+		keyIndex, _ := strconv.Atoi(parts[1])
+		h := value.(*channelHealth)
+		h.mutex.Lock()
+		h.refreshState(now.UnixMilli())
+		success, failure := h.counts(now.Unix())
+		snapshot := ChannelHealthSnapshot{
+			ChannelId:   channelId,
+			KeyIndex:    keyIndex,
+			State:       h.state,
+			Success:     success,
+			Failure:     failure,
+			LatencyMs:   h.latencyEWMA,
+			FirstByteMs: h.ttftEWMA,
+		}
+		if h.state == BreakerStateOpen {
+			snapshot.OpenUntil = h.openUntil
+		}
+		h.mutex.Unlock()
+		if total := success + failure; total > 0 {
+			snapshot.ErrorRate = float64(failure) / float64(total)
+		}
+		snapshots = append(snapshots, snapshot)
+		return true
+	})
+	return snapshots
+}
+
+func publishChannelBreaker(channelId int, keyIndex int, openUntil int64) {
+	if !common.RedisEnabled {
+		return
+	}
+	err := common.RDB.HSet(context.Background(), channelBreakerRedisKey, channelHealthKey(channelId, keyIndex), openUntil).Err()
+	if err != nil {
+		common.SysError("failed to publish channel breaker state: " + err.Error())
+	}
+}
+
+// startChannelBreakerSync 启用 Redis 时定期同步其他节点的熔断状态
+func startChannelBreakerSync() {
+	if !common.RedisEnabled {
+		return
+	}
+	channelBreakerSyncOne.Do(func() {
+		go func() {
+			for {
+				time.Sleep(channelBreakerSyncTick)
+				if !operation_setting.GetChannelHealthSetting().Enabled {
+					continue
+				}
+				syncChannelBreakers()
+			}
+		}()
+	})
+}
+
+func syncChannelBreakers() {
+	ctx := context.Background()
+	values, err := common.RDB.HGetAll(ctx, channelBreakerRedisKey).Result()
+	if err != nil {
+		common.SysError("failed to sync channel breaker state: " + err.Error())
+		return
+	}
+	nowMs := time.Now().UnixMilli()
+	for field, value := range values {
+		openUntil, _ := strconv.ParseInt(value, 10, 64)
+		if openUntil <= nowMs {
+			common.RDB.HDel(ctx, channelBreakerRedisKey, field)
+			continue
+		}
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		channelId, _ := strconv.Atoi(parts[0])
+		keyIndex, _ := strconv.Atoi(parts[1])
+		h := getChannelHealth(channelId, keyIndex, true)
+		h.mutex.Lock()
+		if h.state != BreakerStateOpen || h.openUntil < openUntil {
+			h.state = BreakerStateOpen
+			h.openUntil = openUntil
+			h.probing = 0
+		}
+		h.mutex.Unlock()
+	}
+}
@@ -0,0 +1,230 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setupChannelHealthTest(t *testing.T) {
+	setting := operation_setting.GetChannelHealthSetting()
+	orig := *setting
+	redisEnabled := common.RedisEnabled
+	t.Cleanup(func() {
+		*setting = orig
+		common.RedisEnabled = redisEnabled
+		channelHealthMap.Range(func(key, value any) bool {
+			channelHealthMap.Delete(key)
+			return true
+		})
+	})
+	common.RedisEnabled = false
+	setting.Enabled = true
+	setting.WindowSeconds = 60
+	setting.MinRequests = 4
+	setting.ErrorRateThreshold = 0.5
+	setting.OpenSeconds = 30
+	setting.HalfOpenRequests = 1
+}
+
+func TestChannelBreakerStateMachine(t *testing.T) {
+	type step struct {
+		record    *bool // 记录一次结果；nil 表示其他操作
+		expire    bool  // 熔断到期
+		claim     bool  // 占用探测名额
+		allowed   bool
+		wantState string
+	}
+	ok, fail := true, false
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "failures below min requests stay closed",
+			steps: []step{
+				{record: &fail, allowed: true, wantState: BreakerStateClosed},
+				{record: &fail, allowed: true, wantState: BreakerStateClosed},
+				{record: &fail, allowed: true, wantState: BreakerStateClosed},
+			},
+		},
+		{
+			name: "error rate below threshold stays closed",
+			steps: []step{
+				{record: &ok, allowed: true, wantState: BreakerStateClosed},
+				{record: &ok, allowed: true, wantState: BreakerStateClosed},
+				{record: &ok, allowed: true, wantState: BreakerStateClosed},
+				{record: &fail, allowed: true, wantState: BreakerStateClosed},
+			},
+		},
+		{
+			name: "error rate at threshold opens",
+			steps: []step{
+				{record: &ok, allowed: true, wantState: BreakerStateClosed},
+				{record: &ok, allowed: true, wantState: BreakerStateClosed},
+				{record: &fail, allowed: true, wantState: BreakerStateClosed},
+				{record: &fail, allowed: false, wantState: BreakerStateOpen},
+			},
+		},
+		{
+			name: "half open probe success closes",
+			steps: []step{
+				{record: &fail}, {record: &fail}, {record: &fail},
+				{record: &fail, allowed: false, wantState: BreakerStateOpen},
+				{expire: true, allowed: true, wantState: BreakerStateHalfOpen},
+				{claim: true, allowed: false, wantState: BreakerStateHalfOpen},
+				{record: &ok, allowed: true, wantState: BreakerStateClosed},
+			},
+		},
+		{
+			name: "half open probe failure reopens",
+			steps: []step{
+				{record: &fail}, {record: &fail}, {record: &fail},
+				{record: &fail, allowed: false, wantState: BreakerStateOpen},
+				{expire: true, allowed: true, wantState: BreakerStateHalfOpen},
+				{claim: true, allowed: false, wantState: BreakerStateHalfOpen},
+				{record: &fail, allowed: false, wantState: BreakerStateOpen},
+			},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupChannelHealthTest(t)
+			channelId := 100 + i
+			for j, s := range tt.steps {
+				switch {
+				case s.record != nil:
+					RecordChannelResult(channelId, -1, *s.record, time.Millisecond, 0)
+				case s.expire:
+					h := getChannelHealth(channelId, channelHealthWholeKey, false)
+					h.mutex.Lock()
+					h.openUntil = time.Now().UnixMilli() - 1
+					h.mutex.Unlock()
+				case s.claim:
+					require.True(t, tryClaimChannel(channelId, channelHealthWholeKey))
+				}
+				if s.wantState == "" {
+					continue
+				}
+				require.Equal(t, s.allowed, IsChannelAvailable(channelId, channelHealthWholeKey), "step %d", j)
+				h := getChannelHealth(channelId, channelHealthWholeKey, false)
+				require.Equal(t, s.wantState, h.state, "step %d", j)
+			}
+		})
+	}
+}
+
+// setHalfOpenForTest 将渠道置为熔断到期、等待探测的半开状态
+func setHalfOpenForTest(channelId int) {
+	nowMs := time.Now().UnixMilli()
+	h := getChannelHealth(channelId, channelHealthWholeKey, true)
+	h.open(nowMs)
+	h.openUntil = nowMs - 1
+}
+
+func TestTryClaimChannelConcurrent(t *testing.T) {
+	setupChannelHealthTest(t)
+	setHalfOpenForTest(300)
+
+	var claimed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tryClaimChannel(300, channelHealthWholeKey) {
+				claimed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, claimed.Load())
+	require.Equal(t, 1, getChannelHealth(300, channelHealthWholeKey, false).probing)
+}
+
+func TestPickChannelByHealthConcurrentProbe(t *testing.T) {
+	setupChannelHealthTest(t)
+	setHalfOpenForTest(301)
+
+	channelIds := []int{301, 302}
+	var picked [2]atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			picked[pickChannelByHealth(channelIds, []int{1000, 1})].Add(1)
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, picked[0].Load())
+	require.EqualValues(t, 49, picked[1].Load())
+	require.Equal(t, 1, getChannelHealth(301, channelHealthWholeKey, false).probing)
+}
+
+func TestFilterAvailableKeyIndexes(t *testing.T) {
+	tests := []struct {
+		name     string
+		openKeys []int
+		halfOpen []int
+		claimed  []int
+		want     []int
+	}{
+		{name: "no breaker state", want: []int{0, 1, 2}},
+		{name: "skip open keys", openKeys: []int{1}, want: []int{0, 2}},
+		{name: "all open falls back to all keys", openKeys: []int{0, 1, 2}, want: []int{0, 1, 2}},
+		{name: "half open key is available until claimed", halfOpen: []int{0}, want: []int{0, 1, 2}},
+		{name: "claimed half open key is skipped", halfOpen: []int{0}, claimed: []int{0}, want: []int{1, 2}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupChannelHealthTest(t)
+			channelId := 200 + i
+			nowMs := time.Now().UnixMilli()
+			for _, index := range tt.openKeys {
+				getChannelHealth(channelId, index, true).open(nowMs)
+			}
+			for _, index := range tt.halfOpen {
+				h := getChannelHealth(channelId, index, true)
+				h.open(nowMs)
+				h.openUntil = nowMs - 1
+			}
+			for _, index := range tt.claimed {
+				require.True(t, tryClaimChannel(channelId, index))
+			}
+			require.Equal(t, tt.want, filterAvailableKeyIndexes(channelId, []int{0, 1, 2}))
+		})
+	}
+}
+
+func TestRemoveChannelHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		keysOnly bool
+		want     []string
+	}{
+		{name: "remove channel", keysOnly: false, want: []string{"2:-1"}},
+		{name: "remove keys only", keysOnly: true, want: []string{"1:-1", "2:-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupChannelHealthTest(t)
+			RecordChannelResult(1, 0, true, time.Millisecond, 0)
+			RecordChannelResult(1, 1, true, time.Millisecond, 0)
+			RecordChannelResult(2, -1, true, time.Millisecond, 0)
+			RemoveChannelHealth(1, tt.keysOnly)
+			var keys []string
+			channelHealthMap.Range(func(key, value any) bool {
+				keys = append(keys, key.(string))
+				return true
+			})
+			require.ElementsMatch(t, tt.want, keys)
+		})
+	}
+}
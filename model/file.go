@@ -0,0 +1,82 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+	FilePurposeUserData    = "user_data"
+
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+)
+
+// File 用户通过 /v1/files 上传或由批处理生成的文件，内容保存在 FileStore 中
+type File struct {
+	Id        int    `json:"-"`
+	FileId    string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId    int    `json:"-" gorm:"index"`
+	Bytes     int64  `json:"bytes"`
+	Filename  string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose   string `json:"purpose" gorm:"type:varchar(32);index"`
+	Status    string `json:"status" gorm:"type:varchar(32)"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt int64  `json:"expires_at,omitempty" gorm:"bigint;index"`
+}
+
+func (f *File) Insert() error {
+	if f.CreatedAt == 0 {
+		f.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(f).Error
+}
+
+func GetUserFile(userId int, fileId string) (*File, error) {
+	if fileId == "" {
+		return nil, errors.New("file id is empty")
+	}
+	var file File
+	err := DB.Where("user_id = ? AND file_id = ?", userId, fileId).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetUserFiles 按创建时间倒序列出用户文件，after 为上一页最后一个文件 id
+func GetUserFiles(userId int, purpose string, after string, limit int) (files []*File, err error) {
+	tx := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		tx = tx.Where("purpose = ?", purpose)
+	}
+	if after != "" {
+		var cursor File
+		if err = DB.Where("user_id = ? AND file_id = ?", userId, after).First(&cursor).Error; err == nil {
+			tx = tx.Where("id < ?", cursor.Id)
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	err = tx.Order("id desc").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+func DeleteUserFile(userId int, fileId string) error {
+	return DB.Where("user_id = ? AND file_id = ?", userId, fileId).Delete(&File{}).Error
+}
+
+// GetExpiredFiles 获取已过期的文件，用于清理
+func GetExpiredFiles(now int64, limit int) (files []*File, err error) {
+	err = DB.Where("expires_at > 0 AND expires_at < ?", now).Limit(limit).Find(&files).Error
+	return files, err
+}
+
+func DeleteFileById(id int) error {
+	return DB.Delete(&File{}, id).Error
+}
@@ -0,0 +1,364 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	InvoiceTypeInvoice    = "invoice"
+	InvoiceTypeCreditNote = "credit_note"
+)
+
+const (
+	InvoiceSourceTopUp        = "topup"
+	InvoiceSourceSubscription = "subscription"
+	InvoiceSourceRefund       = "refund"
+)
+
+// Invoice 发票与红字发票，开具时快照销售方信息、币种和税率，开具后不再修改
+type Invoice struct {
+	Id               int     `json:"id"`
+	Number           string  `json:"number" gorm:"type:varchar(64);uniqueIndex"`
+	Type             string  `json:"type" gorm:"type:varchar(16);index"`
+	UserId           int     `json:"user_id" gorm:"index"`
+	SourceType       string  `json:"source_type" gorm:"type:varchar(32);index:idx_invoice_source,priority:1"`
+	SourceReference  string  `json:"source_reference" gorm:"type:varchar(191);index:idx_invoice_source,priority:2"`
+	RelatedInvoiceId int     `json:"related_invoice_id" gorm:"index"` // 红字发票对应的原发票
+	Description      string  `json:"description" gorm:"type:varchar(255)"`
+	PaymentMethod    string  `json:"payment_method" gorm:"type:varchar(50)"`
+	Quota            int     `json:"quota"`
+	Currency         string  `json:"currency" gorm:"type:varchar(8)"`
+	Subtotal         float64 `json:"subtotal"`
+	TaxRate          float64 `json:"tax_rate"`
+	TaxAmount        float64 `json:"tax_amount"`
+	Total            float64 `json:"total"`
+	BuyerName        string  `json:"buyer_name" gorm:"type:varchar(255)"`
+	BuyerEmail       string  `json:"buyer_email" gorm:"type:varchar(255)"`
+	SellerName       string  `json:"seller_name" gorm:"type:varchar(255)"`
+	SellerAddress    string  `json:"seller_address" gorm:"type:text"`
+	SellerTaxId      string  `json:"seller_tax_id" gorm:"type:varchar(64)"`
+	SellerEmail      string  `json:"seller_email" gorm:"type:varchar(255)"`
+	Footer           string  `json:"footer" gorm:"type:text"`
+	Note             string  `json:"note" gorm:"type:text"`
+	IssuedAt         int64   `json:"issued_at" gorm:"bigint;index"`
+}
+
+// InvoiceSequence 发票编号计数器，按发票类型分别递增，保证编号连续
+type InvoiceSequence struct {
+	Name  string `gorm:"primaryKey;type:varchar(32)"`
+	Value int64
+}
+
+type InvoiceFilter struct {
+	UserId     int
+	Type       string
+	SourceType string
+	Number     string
+}
+
+func nextInvoiceNumber(tx *gorm.DB, invoiceType string, prefix string) (string, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&InvoiceSequence{Name: invoiceType}).Error; err != nil {
+		return "", err
+	}
+	if err := tx.Model(&InvoiceSequence{}).Where("name = ?", invoiceType).Update("value", gorm.Expr("value + 1")).Error; err != nil {
+		return "", err
+	}
+	var value int64
+	if err := tx.Model(&InvoiceSequence{}).Where("name = ?", invoiceType).Select("value").Find(&value).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%06d", prefix, value), nil
+}
+
+func roundMoney(d decimal.Decimal) float64 {
+	return d.Round(2).InexactFloat64()
+}
+
+// issueInvoice 在事务内分配编号并保存发票，total 为含税金额
+func issueInvoice(tx *gorm.DB, invoice *Invoice, total float64) error {
+	setting := operation_setting.GetInvoiceSetting()
+	prefix := setting.NumberPrefix
+	if invoice.Type == InvoiceTypeCreditNote {
+		prefix = setting.CreditNotePrefix
+	}
+	number, err := nextInvoiceNumber(tx, invoice.Type, prefix)
+	if err != nil {
+		return err
+	}
+	invoice.Number = number
+	if invoice.Currency == "" {
+		invoice.Currency = setting.Currency
+	}
+	dTotal := decimal.NewFromFloat(total)
+	dSubtotal := dTotal
+	if invoice.TaxRate > 0 {
+		dSubtotal = dTotal.Div(decimal.NewFromFloat(1 + invoice.TaxRate/100))
+	}
+	invoice.Total = roundMoney(dTotal)
+	invoice.Subtotal = roundMoney(dSubtotal)
+	invoice.TaxAmount = roundMoney(dTotal.Sub(decimal.NewFromFloat(invoice.Subtotal)))
+	invoice.SellerName = setting.CompanyName
+	invoice.SellerAddress = setting.CompanyAddress
+	invoice.SellerTaxId = setting.CompanyTaxId
+	invoice.SellerEmail = setting.CompanyEmail
+	invoice.Footer = setting.Footer
+	if invoice.IssuedAt == 0 {
+		invoice.IssuedAt = common.GetTimestamp()
+	}
+	return tx.Create(invoice).Error
+}
+
+func fillInvoiceBuyer(tx *gorm.DB, invoice *Invoice) error {
+	var user User
+	if err := tx.Select("id", "username", "display_name", "email").Where("id = ?", invoice.UserId).First(&user).Error; err != nil {
+		return err
+	}
+	invoice.BuyerName = user.DisplayName
+	if invoice.BuyerName == "" {
+		invoice.BuyerName = user.Username
+	}
+	invoice.BuyerEmail = user.Email
+	return nil
+}
+
+// PendingInvoice 待开具的发票，在付款事务内写入，事务提交后开具；开具失败时由后台任务重试，同一来源只会开具一张发票
+type PendingInvoice struct {
+	Id              int     `json:"id"`
+	SourceType      string  `json:"source_type" gorm:"type:varchar(32);uniqueIndex:idx_pending_invoice_source,priority:1"`
+	SourceReference string  `json:"source_reference" gorm:"type:varchar(191);uniqueIndex:idx_pending_invoice_source,priority:2"`
+	UserId          int     `json:"user_id"`
+	Description     string  `json:"description" gorm:"type:varchar(255)"`
+	PaymentMethod   string  `json:"payment_method" gorm:"type:varchar(50)"`
+	Quota           int     `json:"quota"`
+	Currency        string  `json:"currency" gorm:"type:varchar(8)"`
+	Amount          float64 `json:"amount"`
+	Attempts        int     `json:"attempts"`
+	LastError       string  `json:"last_error" gorm:"type:text"`
+	CreatedAt       int64   `json:"created_at" gorm:"bigint;index"`
+}
+
+// PendingInvoiceMaxAttempts 超过重试次数的待开具发票保留 LastError 供管理员排查
+const PendingInvoiceMaxAttempts = 20
+
+// enqueueInvoice 在付款事务内登记待开具的发票，未启用发票或金额为 0 时跳过，同一来源重复登记时忽略
+func enqueueInvoice(tx *gorm.DB, pending *PendingInvoice) error {
+	if !operation_setting.GetInvoiceSetting().Enabled || pending.Amount <= 0 {
+		return nil
+	}
+	pending.CreatedAt = common.GetTimestamp()
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(pending).Error
+}
+
+func enqueueTopUpInvoice(tx *gorm.DB, topUp *TopUp, quota int) error {
+	return enqueueInvoice(tx, &PendingInvoice{
+		SourceType:      InvoiceSourceTopUp,
+		SourceReference: topUp.TradeNo,
+		UserId:          topUp.UserId,
+		Description:     fmt.Sprintf("Account top-up: %s", logger.FormatQuota(quota)),
+		PaymentMethod:   topUp.PaymentMethod,
+		Quota:           quota,
+		Currency:        topUp.Currency,
+		Amount:          topUp.Money,
+	})
+}
+
+// SubscriptionInvoiceReference 订阅每个计费周期对应一张发票
+func SubscriptionInvoiceReference(sub *Subscription) string {
+	return fmt.Sprintf("%d-%d", sub.Id, sub.CurrentPeriodStart)
+}
+
+// enqueueSubscriptionInvoice 订阅每进入一个已付款的周期开具一张发票，金额按实际付款；管理员发放的订阅没有付款，不开票
+func enqueueSubscriptionInvoice(tx *gorm.DB, sub *Subscription, planName string, payment *SubscriptionPayment) error {
+	if payment == nil {
+		return nil
+	}
+	return enqueueInvoice(tx, &PendingInvoice{
+		SourceType:      InvoiceSourceSubscription,
+		SourceReference: SubscriptionInvoiceReference(sub),
+		UserId:          sub.UserId,
+		Description: fmt.Sprintf("Subscription %s: %s - %s", planName,
+			time.Unix(sub.CurrentPeriodStart, 0).Format("2006-01-02"), time.Unix(sub.CurrentPeriodEnd, 0).Format("2006-01-02")),
+		PaymentMethod: "stripe",
+		Quota:         sub.Quota,
+		Currency:      payment.Currency,
+		Amount:        payment.Amount,
+	})
+}
+
+// IssuePendingInvoice 开具来源对应的待开具发票，已开具过或没有待开具记录时直接返回；失败时记录错误等待重试
+func IssuePendingInvoice(sourceType string, reference string) error {
+	var pendingId int
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		pending := &PendingInvoice{}
+		result := tx.Set("gorm:query_option", "FOR UPDATE").
+			Where("source_type = ? AND source_reference = ?", sourceType, reference).Limit(1).Find(pending)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		pendingId = pending.Id
+		var count int64
+		if err := tx.Model(&Invoice{}).Where("source_type = ? AND source_reference = ? AND type = ?", sourceType, reference, InvoiceTypeInvoice).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			invoice := &Invoice{
+				Type:            InvoiceTypeInvoice,
+				UserId:          pending.UserId,
+				SourceType:      pending.SourceType,
+				SourceReference: pending.SourceReference,
+				Description:     pending.Description,
+				PaymentMethod:   pending.PaymentMethod,
+				Quota:           pending.Quota,
+				Currency:        pending.Currency,
+				TaxRate:         operation_setting.GetInvoiceSetting().TaxRate,
+			}
+			if err := fillInvoiceBuyer(tx, invoice); err != nil {
+				return err
+			}
+			if err := issueInvoice(tx, invoice, pending.Amount); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(pending).Error
+	})
+	if err != nil && pendingId != 0 {
+		if updateErr := DB.Model(&PendingInvoice{}).Where("id = ?", pendingId).Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": err.Error(),
+		}).Error; updateErr != nil {
+			common.SysError("failed to record pending invoice error: " + updateErr.Error())
+		}
+	}
+	return err
+}
+
+// issuePendingInvoiceAfterCommit 付款事务提交后立即开具发票，失败不影响付款结果，由后台任务重试
+func issuePendingInvoiceAfterCommit(sourceType string, reference string) {
+	if err := IssuePendingInvoice(sourceType, reference); err != nil {
+		common.SysError(fmt.Sprintf("failed to issue invoice for %s %s, will retry: %s", sourceType, reference, err.Error()))
+	}
+}
+
+// GetRetryablePendingInvoices 获取仍可重试的待开具发票
+func GetRetryablePendingInvoices(limit int) (pendings []*PendingInvoice, err error) {
+	err = DB.Where("attempts < ?", PendingInvoiceMaxAttempts).Order("id asc").Limit(limit).Find(&pendings).Error
+	return pendings, err
+}
+
+// IssueCreditNote 为退款开具红字发票，累计退款金额不能超过原发票金额；deductQuota 大于 0 时同时扣回已发放的额度
+func IssueCreditNote(invoiceId int, amount float64, reason string, deductQuota int) (*Invoice, error) {
+	if amount <= 0 {
+		return nil, errors.New("退款金额必须大于0")
+	}
+	if deductQuota < 0 {
+		return nil, errors.New("扣回额度不能为负数")
+	}
+	creditNote := &Invoice{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		original := &Invoice{}
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", invoiceId).First(original).Error; err != nil {
+			return errors.New("发票不存在")
+		}
+		if original.Type != InvoiceTypeInvoice {
+			return errors.New("只能为发票开具红字发票")
+		}
+		var refunded float64
+		if err := tx.Model(&Invoice{}).Where("related_invoice_id = ? AND type = ?", original.Id, InvoiceTypeCreditNote).
+			Select("COALESCE(SUM(total), 0)").Scan(&refunded).Error; err != nil {
+			return err
+		}
+		remaining := decimal.NewFromFloat(original.Total).Sub(decimal.NewFromFloat(refunded))
+		if decimal.NewFromFloat(amount).GreaterThan(remaining) {
+			return fmt.Errorf("退款金额超过发票剩余可退金额 %s", remaining.StringFixed(2))
+		}
+		*creditNote = Invoice{
+			Type:             InvoiceTypeCreditNote,
+			UserId:           original.UserId,
+			SourceType:       InvoiceSourceRefund,
+			SourceReference:  original.Number,
+			RelatedInvoiceId: original.Id,
+			Description:      "Refund of " + original.Number,
+			PaymentMethod:    original.PaymentMethod,
+			Quota:            deductQuota,
+			Currency:         original.Currency,
+			TaxRate:          original.TaxRate,
+			BuyerName:        original.BuyerName,
+			BuyerEmail:       original.BuyerEmail,
+			Note:             reason,
+		}
+		if err := issueInvoice(tx, creditNote, amount); err != nil {
+			return err
+		}
+		return changeUserQuota(tx, original.UserId, -deductQuota, QuotaLedgerRef{Type: QuotaLedgerTypeTopupRefund, ReferenceId: creditNote.Number})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if deductQuota > 0 {
+		gopool.Go(func() {
+			if err := cacheDecrUserQuota(creditNote.UserId, int64(deductQuota)); err != nil {
+				common.SysLog("failed to decrease user quota cache: " + err.Error())
+			}
+		})
+		RecordLog(creditNote.UserId, LogTypeManage, fmt.Sprintf("退款 %s，扣回额度 %s", creditNote.Number, logger.LogQuota(deductQuota)))
+	}
+	return creditNote, nil
+}
+
+func GetInvoiceById(id int) (*Invoice, error) {
+	invoice := &Invoice{}
+	err := DB.First(invoice, "id = ?", id).Error
+	return invoice, err
+}
+
+// GetInvoiceBySource 获取来源对应的发票，未开具时返回 nil
+func GetInvoiceBySource(sourceType string, reference string) (*Invoice, error) {
+	var invoices []*Invoice
+	err := DB.Where("source_type = ? AND source_reference = ? AND type = ?", sourceType, reference, InvoiceTypeInvoice).
+		Limit(1).Find(&invoices).Error
+	if err != nil || len(invoices) == 0 {
+		return nil, err
+	}
+	return invoices[0], nil
+}
+
+func GetInvoices(filter InvoiceFilter, startIdx int, num int) (invoices []*Invoice, total int64, err error) {
+	tx := DB.Model(&Invoice{})
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Type != "" {
+		tx = tx.Where("type = ?", filter.Type)
+	}
+	if filter.SourceType != "" {
+		tx = tx.Where("source_type = ?", filter.SourceType)
+	}
+	if filter.Number != "" {
+		tx = tx.Where("number = ?", filter.Number)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&invoices).Error
+	return invoices, total, err
+}
+
+// IssueTopUpInvoice 为不在事务内完成的充值补开发票，已开具过则直接返回
+func IssueTopUpInvoice(topUp *TopUp, quota int) error {
+	if err := enqueueTopUpInvoice(DB, topUp, quota); err != nil {
+		return err
+	}
+	return IssuePendingInvoice(InvoiceSourceTopUp, topUp.TradeNo)
+}
@@ -0,0 +1,200 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupInvoiceTest(t *testing.T, setting operation_setting.InvoiceSetting) *gorm.DB {
+	db := setupTestDB(t, &User{}, &Invoice{}, &InvoiceSequence{}, &PendingInvoice{}, &QuotaLedger{})
+	require.NoError(t, db.Create(&User{Id: 1, Username: "u1", DisplayName: "Alice", Email: "a@example.com", Quota: 1000}).Error)
+	current := operation_setting.GetInvoiceSetting()
+	original := *current
+	t.Cleanup(func() {
+		*current = original
+	})
+	*current = setting
+	return db
+}
+
+func TestNextInvoiceNumber(t *testing.T) {
+	db := setupInvoiceTest(t, operation_setting.InvoiceSetting{})
+	tests := []struct {
+		invoiceType string
+		prefix      string
+		want        string
+	}{
+		{invoiceType: InvoiceTypeInvoice, prefix: "INV-", want: "INV-000001"},
+		{invoiceType: InvoiceTypeInvoice, prefix: "INV-", want: "INV-000002"},
+		// 红字发票使用独立的计数器
+		{invoiceType: InvoiceTypeCreditNote, prefix: "CN-", want: "CN-000001"},
+		{invoiceType: InvoiceTypeInvoice, prefix: "INV-", want: "INV-000003"},
+		// 修改前缀不重置编号
+		{invoiceType: InvoiceTypeInvoice, prefix: "2026-", want: "2026-000004"},
+		{invoiceType: InvoiceTypeCreditNote, prefix: "CN-", want: "CN-000002"},
+	}
+	for _, tt := range tests {
+		var number string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			number, err = nextInvoiceNumber(tx, tt.invoiceType, tt.prefix)
+			return err
+		})
+		require.NoError(t, err)
+		require.Equal(t, tt.want, number)
+	}
+}
+
+func TestNextInvoiceNumberRollback(t *testing.T) {
+	db := setupInvoiceTest(t, operation_setting.InvoiceSetting{})
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := nextInvoiceNumber(tx, InvoiceTypeInvoice, "INV-"); err != nil {
+			return err
+		}
+		return gorm.ErrInvalidTransaction
+	})
+	require.ErrorIs(t, err, gorm.ErrInvalidTransaction)
+	// 事务回滚后编号不被占用，保证编号连续
+	var number string
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		number, err = nextInvoiceNumber(tx, InvoiceTypeInvoice, "INV-")
+		return err
+	}))
+	require.Equal(t, "INV-000001", number)
+}
+
+func TestIssueInvoiceAmounts(t *testing.T) {
+	tests := []struct {
+		name         string
+		total        float64
+		taxRate      float64
+		currency     string
+		wantSubtotal float64
+		wantTax      float64
+		wantCurrency string
+	}{
+		{name: "no tax", total: 100, wantSubtotal: 100, wantTax: 0, wantCurrency: "USD"},
+		{name: "tax included in total", total: 100, taxRate: 20, wantSubtotal: 83.33, wantTax: 16.67, wantCurrency: "USD"},
+		{name: "exact tax", total: 10.6, taxRate: 6, wantSubtotal: 10, wantTax: 0.6, wantCurrency: "USD"},
+		{name: "payment currency wins", total: 50, currency: "EUR", wantSubtotal: 50, wantCurrency: "EUR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupInvoiceTest(t, operation_setting.InvoiceSetting{
+				NumberPrefix: "INV-",
+				Currency:     "USD",
+				CompanyName:  "Example Ltd",
+			})
+			invoice := &Invoice{Type: InvoiceTypeInvoice, UserId: 1, TaxRate: tt.taxRate, Currency: tt.currency}
+			require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+				return issueInvoice(tx, invoice, tt.total)
+			}))
+			require.Equal(t, "INV-000001", invoice.Number)
+			require.Equal(t, tt.total, invoice.Total)
+			require.Equal(t, tt.wantSubtotal, invoice.Subtotal)
+			require.Equal(t, tt.wantTax, invoice.TaxAmount)
+			require.Equal(t, tt.wantCurrency, invoice.Currency)
+			require.Equal(t, "Example Ltd", invoice.SellerName)
+		})
+	}
+}
+
+func TestIssuePendingInvoice(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		amount      float64
+		issueTimes  int
+		wantNumbers []string
+	}{
+		{name: "issued once per source", enabled: true, amount: 20, issueTimes: 2, wantNumbers: []string{"INV-000001"}},
+		{name: "disabled skips queue", enabled: false, amount: 20, issueTimes: 1},
+		{name: "zero amount skips queue", enabled: true, amount: 0, issueTimes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupInvoiceTest(t, operation_setting.InvoiceSetting{Enabled: tt.enabled, NumberPrefix: "INV-", Currency: "USD"})
+			topUp := &TopUp{UserId: 1, TradeNo: "trade-1", Money: tt.amount, PaymentMethod: "stripe", Currency: "USD"}
+			for i := 0; i < 2; i++ {
+				require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+					return enqueueTopUpInvoice(tx, topUp, 500)
+				}))
+			}
+			for i := 0; i < tt.issueTimes; i++ {
+				require.NoError(t, IssuePendingInvoice(InvoiceSourceTopUp, "trade-1"))
+			}
+
+			var numbers []string
+			require.NoError(t, db.Model(&Invoice{}).Order("id").Pluck("number", &numbers).Error)
+			require.Equal(t, len(tt.wantNumbers), len(numbers))
+			for i, want := range tt.wantNumbers {
+				require.Equal(t, want, numbers[i])
+			}
+			var pending int64
+			require.NoError(t, db.Model(&PendingInvoice{}).Count(&pending).Error)
+			require.Zero(t, pending)
+			if len(tt.wantNumbers) > 0 {
+				invoice, err := GetInvoiceBySource(InvoiceSourceTopUp, "trade-1")
+				require.NoError(t, err)
+				require.Equal(t, "Alice", invoice.BuyerName)
+				require.Equal(t, "a@example.com", invoice.BuyerEmail)
+				require.Equal(t, 500, invoice.Quota)
+			}
+		})
+	}
+}
+
+func TestIssueCreditNote(t *testing.T) {
+	tests := []struct {
+		name        string
+		refunds     []float64
+		wantErr     []bool
+		wantNumbers []string
+	}{
+		{name: "partial refunds up to total", refunds: []float64{30, 70}, wantErr: []bool{false, false}, wantNumbers: []string{"CN-000001", "CN-000002"}},
+		{name: "refund over remaining amount", refunds: []float64{60, 50}, wantErr: []bool{false, true}, wantNumbers: []string{"CN-000001"}},
+		{name: "non-positive amount", refunds: []float64{0}, wantErr: []bool{true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupInvoiceTest(t, operation_setting.InvoiceSetting{NumberPrefix: "INV-", CreditNotePrefix: "CN-", Currency: "USD", TaxRate: 10})
+			original := &Invoice{Type: InvoiceTypeInvoice, UserId: 1, Currency: "EUR", TaxRate: 10}
+			require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+				return issueInvoice(tx, original, 100)
+			}))
+
+			var numbers []string
+			for i, amount := range tt.refunds {
+				creditNote, err := IssueCreditNote(original.Id, amount, "refund", 0)
+				require.Equal(t, tt.wantErr[i], err != nil, "refund %d err: %v", i, err)
+				if err != nil {
+					continue
+				}
+				numbers = append(numbers, creditNote.Number)
+				require.Equal(t, original.Id, creditNote.RelatedInvoiceId)
+				require.Equal(t, original.Number, creditNote.SourceReference)
+				require.Equal(t, "EUR", creditNote.Currency)
+				require.Equal(t, amount, creditNote.Total)
+			}
+			if len(tt.wantNumbers) == 0 {
+				require.Empty(t, numbers)
+			} else {
+				require.Equal(t, tt.wantNumbers, numbers)
+			}
+		})
+	}
+
+	t.Run("credit note cannot be refunded", func(t *testing.T) {
+		db := setupInvoiceTest(t, operation_setting.InvoiceSetting{NumberPrefix: "INV-", CreditNotePrefix: "CN-"})
+		creditNote := &Invoice{Type: InvoiceTypeCreditNote, UserId: 1}
+		require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+			return issueInvoice(tx, creditNote, 10)
+		}))
+		_, err := IssueCreditNote(creditNote.Id, 1, "", 0)
+		require.Error(t, err)
+	})
+}
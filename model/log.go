@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/types"
 
@@ -37,6 +40,8 @@ type Log struct {
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	Other            string `json:"other"`
+	OrgId            int    `json:"org_id" gorm:"default:0;index"`
+	ProjectId        int    `json:"project_id" gorm:"default:0;index"`
 }
 
 // don't use iota, avoid change log type value
@@ -65,12 +70,16 @@ func formatUserLogs(logs []*Log) {
 }
 
 func GetLogByKey(key string) (logs []*Log, err error) {
-	if os.Getenv("LOG_SQL_DSN") != "" {
+	if logReader != nil || os.Getenv("LOG_SQL_DSN") != "" {
 		var tk Token
 		if err = DB.Model(&Token{}).Where(logKeyCol+"=?", strings.TrimPrefix(key, "sk-")).First(&tk).Error; err != nil {
 			return nil, err
 		}
-		err = LOG_DB.Model(&Log{}).Where("token_id=?", tk.Id).Find(&logs).Error
+		if logReader != nil {
+			logs, _, err = logReader.QueryLogs(LogQuery{TokenId: tk.Id}, 0, common.MaxRecentItems)
+		} else {
+			err = LOG_DB.Model(&Log{}).Where("token_id=?", tk.Id).Find(&logs).Error
+		}
 	} else {
 		err = LOG_DB.Joins("left join tokens on tokens.id = logs.token_id").Where("tokens.key = ?", strings.TrimPrefix(key, "sk-")).Find(&logs).Error
 	}
@@ -90,7 +99,7 @@ func RecordLog(userId int, logType int, content string) {
 		Type:      logType,
 		Content:   content,
 	}
-	err := LOG_DB.Create(log).Error
+	err := saveLog(log)
 	if err != nil {
 		common.SysLog("failed to record log: " + err.Error())
 	}
@@ -130,9 +139,11 @@ func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string,
 			}
 			return ""
 		}(),
-		Other: otherStr,
+		Other:     otherStr,
+		OrgId:     common.GetContextKeyInt(c, constant.ContextKeyTokenOrgId),
+		ProjectId: common.GetContextKeyInt(c, constant.ContextKeyTokenProjectId),
 	}
-	err := LOG_DB.Create(log).Error
+	err := saveLog(log)
 	if err != nil {
 		logger.LogError(c, "failed to record log: "+err.Error())
 	}
@@ -154,6 +165,7 @@ type RecordConsumeLogParams struct {
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	metrics.RecordConsume(params.ModelName, params.Group, params.ChannelId, params.Quota, params.PromptTokens, params.CompletionTokens)
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -189,20 +201,39 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			}
 			return ""
 		}(),
-		Other: otherStr,
+		Other:     otherStr,
+		OrgId:     common.GetContextKeyInt(c, constant.ContextKeyTokenOrgId),
+		ProjectId: common.GetContextKeyInt(c, constant.ContextKeyTokenProjectId),
 	}
-	err := LOG_DB.Create(log).Error
+	err := saveLog(log)
 	if err != nil {
 		logger.LogError(c, "failed to record log: "+err.Error())
 	}
 	if common.DataExportEnabled {
 		gopool.Go(func() {
-			LogQuotaData(userId, username, params.ModelName, params.Quota, common.GetTimestamp(), params.PromptTokens+params.CompletionTokens)
+			LogQuotaData(userId, username, log.OrgId, params.ModelName, params.Quota, common.GetTimestamp(), params.PromptTokens+params.CompletionTokens)
 		})
 	}
 }
 
 func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int, group string) (logs []*Log, total int64, err error) {
+	if logReader != nil {
+		logs, total, err = logReader.QueryLogs(LogQuery{
+			Type:           logType,
+			StartTimestamp: startTimestamp,
+			EndTimestamp:   endTimestamp,
+			ModelName:      modelName,
+			Username:       username,
+			TokenName:      tokenName,
+			Channel:        channel,
+			Group:          group,
+		}, startIdx, num)
+		if err != nil {
+			return nil, 0, err
+		}
+		return logs, total, fillLogChannelNames(logs)
+	}
+
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
 		tx = LOG_DB
@@ -239,7 +270,10 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 	if err != nil {
 		return nil, 0, err
 	}
+	return logs, total, fillLogChannelNames(logs)
+}
 
+func fillLogChannelNames(logs []*Log) error {
 	channelIds := types.NewSet[int]()
 	for _, log := range logs {
 		if log.ChannelId != 0 {
@@ -252,8 +286,8 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 			Id   int    `gorm:"column:id"`
 			Name string `gorm:"column:name"`
 		}
-		if err = DB.Table("channels").Select("id, name").Where("id IN ?", channelIds.Items()).Find(&channels).Error; err != nil {
-			return logs, total, err
+		if err := DB.Table("channels").Select("id, name").Where("id IN ?", channelIds.Items()).Find(&channels).Error; err != nil {
+			return err
 		}
 		channelMap := make(map[int]string, len(channels))
 		for _, channel := range channels {
@@ -263,11 +297,27 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 			logs[i].ChannelName = channelMap[logs[i].ChannelId]
 		}
 	}
-
-	return logs, total, err
+	return nil
 }
 
 func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int, group string) (logs []*Log, total int64, err error) {
+	if logReader != nil {
+		logs, total, err = logReader.QueryLogs(LogQuery{
+			UserId:         userId,
+			Type:           logType,
+			StartTimestamp: startTimestamp,
+			EndTimestamp:   endTimestamp,
+			ModelName:      modelName,
+			TokenName:      tokenName,
+			Group:          group,
+		}, startIdx, num)
+		if err != nil {
+			return nil, 0, err
+		}
+		formatUserLogs(logs)
+		return logs, total, nil
+	}
+
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
 		tx = LOG_DB.Where("logs.user_id = ?", userId)
@@ -303,12 +353,81 @@ func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int
 	return logs, total, err
 }
 
+// GetOrgLogs 获取组织内所有成员通过项目令牌产生的日志
+func GetOrgLogs(orgId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, projectId int, startIdx int, num int) (logs []*Log, total int64, err error) {
+	if logReader != nil {
+		logs, total, err = logReader.QueryLogs(LogQuery{
+			OrgId:          orgId,
+			ProjectId:      projectId,
+			Type:           logType,
+			StartTimestamp: startTimestamp,
+			EndTimestamp:   endTimestamp,
+			ModelName:      modelName,
+			Username:       username,
+			TokenName:      tokenName,
+		}, startIdx, num)
+		if err != nil {
+			return nil, 0, err
+		}
+		formatUserLogs(logs)
+		return logs, total, nil
+	}
+
+	tx := LOG_DB.Where("logs.org_id = ?", orgId)
+	if logType != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", logType)
+	}
+	if modelName != "" {
+		tx = tx.Where("logs.model_name like ?", modelName)
+	}
+	if username != "" {
+		tx = tx.Where("logs.username = ?", username)
+	}
+	if tokenName != "" {
+		tx = tx.Where("logs.token_name = ?", tokenName)
+	}
+	if projectId != 0 {
+		tx = tx.Where("logs.project_id = ?", projectId)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", endTimestamp)
+	}
+	err = tx.Model(&Log{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("logs.id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	formatUserLogs(logs)
+	return logs, total, err
+}
+
 func SearchAllLogs(keyword string) (logs []*Log, err error) {
+	if logReader != nil {
+		logs, _, err = logReader.QueryLogs(LogQuery{Keyword: keyword}, 0, common.MaxRecentItems)
+		return logs, err
+	}
 	err = LOG_DB.Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(common.MaxRecentItems).Find(&logs).Error
 	return logs, err
 }
 
 func SearchUserLogs(userId int, keyword string) (logs []*Log, err error) {
+	if logReader != nil {
+		// 与 SQL 查询一致，keyword 只匹配日志类型
+		logType, convErr := strconv.Atoi(keyword)
+		if convErr != nil || logType == LogTypeUnknown {
+			return []*Log{}, nil
+		}
+		logs, _, err = logReader.QueryLogs(LogQuery{UserId: userId, Type: logType}, 0, common.MaxRecentItems)
+		formatUserLogs(logs)
+		return logs, err
+	}
 	err = LOG_DB.Where("user_id = ? and type = ?", userId, keyword).Order("id desc").Limit(common.MaxRecentItems).Find(&logs).Error
 	formatUserLogs(logs)
 	return logs, err
@@ -321,6 +440,23 @@ type Stat struct {
 }
 
 func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int, group string) (stat Stat) {
+	if logReader != nil {
+		stat, err := logReader.SumUsedQuota(LogQuery{
+			Type:           logType,
+			StartTimestamp: startTimestamp,
+			EndTimestamp:   endTimestamp,
+			ModelName:      modelName,
+			Username:       username,
+			TokenName:      tokenName,
+			Channel:        channel,
+			Group:          group,
+		})
+		if err != nil {
+			common.SysError("failed to sum used quota from log sink: " + err.Error())
+		}
+		return stat
+	}
+
 	tx := LOG_DB.Table("logs").Select("sum(quota) quota")
 
 	// 为rpm和tpm创建单独的查询
@@ -0,0 +1,227 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+)
+
+// LogSink 外部日志存储，Write 以批为单位调用，返回错误时整批重试
+type LogSink interface {
+	Name() string
+	Write(logs []*Log) error
+}
+
+// LogQuery 日志查询条件，零值表示不筛选
+type LogQuery struct {
+	Type           int
+	StartTimestamp int64
+	EndTimestamp   int64
+	ModelName      string
+	Username       string
+	TokenName      string
+	Channel        int
+	Group          string
+	UserId         int
+	TokenId        int
+	OrgId          int
+	ProjectId      int
+	// Keyword 与 SearchAllLogs 一致，匹配日志类型或内容前缀
+	Keyword string
+}
+
+// LogReader 支持查询的外部存储实现此接口后，所有日志列表与统计改为从该存储读取
+type LogReader interface {
+	QueryLogs(query LogQuery, startIdx int, num int) (logs []*Log, total int64, err error)
+	SumUsedQuota(query LogQuery) (Stat, error)
+}
+
+const logSinkMaxRetries = 3
+
+var (
+	logSinkWorkers []*logSinkWorker
+	logReader      LogReader
+	// 关闭 SQL 日志表时仍需为日志分配 Id，供外部存储排序和去重
+	logSqlEnabled = true
+	logIdSequence atomic.Int64
+)
+
+type logSinkWorker struct {
+	sink          LogSink
+	queue         chan *Log
+	batchSize     int
+	flushInterval time.Duration
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// InitLogSinks 按 LOG_SINKS 初始化外部日志存储，sql 表示写入日志数据库
+func InitLogSinks() error {
+	sinks := common.GetEnvOrDefaultString("LOG_SINKS", "sql")
+	batchSize := common.GetEnvOrDefault("LOG_SINK_BATCH_SIZE", 500)
+	flushInterval := time.Duration(common.GetEnvOrDefault("LOG_SINK_FLUSH_INTERVAL", 5)) * time.Second
+	queueSize := common.GetEnvOrDefault("LOG_SINK_QUEUE_SIZE", 10000)
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+	if queueSize < batchSize {
+		queueSize = batchSize
+	}
+
+	logSqlEnabled = false
+	logSinkWorkers = nil
+	logReader = nil
+	for _, name := range strings.Split(sinks, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		var sink LogSink
+		var err error
+		switch name {
+		case "":
+			continue
+		case "sql":
+			logSqlEnabled = true
+			continue
+		case "jsonl":
+			sink, err = newFileLogSink()
+		case "http":
+			sink, err = newHTTPLogSink()
+		case "clickhouse":
+			sink, err = newClickHouseLogSink()
+		default:
+			return fmt.Errorf("unknown log sink: %s", name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to init log sink %s: %w", name, err)
+		}
+		if reader, ok := sink.(LogReader); ok && logReader == nil {
+			logReader = reader
+		}
+		worker := &logSinkWorker{
+			sink:          sink,
+			queue:         make(chan *Log, queueSize),
+			batchSize:     batchSize,
+			flushInterval: flushInterval,
+			stop:          make(chan struct{}),
+			done:          make(chan struct{}),
+		}
+		logSinkWorkers = append(logSinkWorkers, worker)
+		go worker.run()
+		common.SysLog("log sink enabled: " + sink.Name())
+	}
+	if !logSqlEnabled && logReader == nil {
+		return fmt.Errorf("LOG_SINKS without sql requires a sink that supports queries, such as clickhouse")
+	}
+	if !logSqlEnabled {
+		logIdSequence.Store(time.Now().UnixMilli() << 12)
+		common.SysLog("sql log table disabled, logs are only written to external sinks")
+	}
+	return nil
+}
+
+// saveLog 写入 SQL 日志表（若启用）并投递到外部存储
+func saveLog(log *Log) error {
+	var err error
+	if logSqlEnabled {
+		err = LOG_DB.Create(log).Error
+	} else {
+		log.Id = int(logIdSequence.Add(1))
+	}
+	for _, worker := range logSinkWorkers {
+		worker.enqueue(log)
+	}
+	return err
+}
+
+// enqueue 队列满时直接丢弃，不阻塞请求
+func (w *logSinkWorker) enqueue(log *Log) {
+	select {
+	case w.queue <- log:
+	default:
+		metrics.RecordLogSinkDropped(w.sink.Name(), 1)
+	}
+}
+
+func (w *logSinkWorker) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Log, 0, w.batchSize)
+	for {
+		select {
+		case log := <-w.queue:
+			batch = append(batch, log)
+			if len(batch) < w.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-w.stop:
+			w.drain(batch)
+			return
+		}
+		w.flush(batch)
+		batch = make([]*Log, 0, w.batchSize)
+	}
+}
+
+// drain 写出队列中剩余的日志
+func (w *logSinkWorker) drain(batch []*Log) {
+	for {
+		select {
+		case log := <-w.queue:
+			batch = append(batch, log)
+			if len(batch) < w.batchSize {
+				continue
+			}
+			w.flush(batch)
+			batch = make([]*Log, 0, w.batchSize)
+		default:
+			if len(batch) > 0 {
+				w.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// CloseLogSinks 停止外部存储并写出队列中的日志，超过 timeout 仍未完成时放弃等待
+func CloseLogSinks(timeout time.Duration) {
+	for _, worker := range logSinkWorkers {
+		close(worker.stop)
+	}
+	deadline := time.After(timeout)
+	for _, worker := range logSinkWorkers {
+		select {
+		case <-worker.done:
+		case <-deadline:
+			common.SysError("timed out waiting for log sinks to flush")
+			return
+		}
+	}
+}
+
+func (w *logSinkWorker) flush(batch []*Log) {
+	var err error
+	for i := 0; i < logSinkMaxRetries; i++ {
+		if err = w.sink.Write(batch); err == nil {
+			return
+		}
+		time.Sleep(time.Duration(i+1) * time.Second)
+	}
+	metrics.RecordLogSinkDropped(w.sink.Name(), len(batch))
+	common.SysError(fmt.Sprintf("log sink %s dropped %d logs: %s", w.sink.Name(), len(batch), err.Error()))
+}
+
+// IsSqlLogEnabled 是否写入 SQL 日志表
+func IsSqlLogEnabled() bool {
+	return logSqlEnabled
+}
@@ -0,0 +1,256 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// clickHouseLogSink 通过 ClickHouse HTTP 接口写入与查询日志，列名与 Log 的 JSON 字段一致
+type clickHouseLogSink struct {
+	url      string
+	database string
+	table    string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func newClickHouseLogSink() (LogSink, error) {
+	sink := &clickHouseLogSink{
+		url:      strings.TrimRight(common.GetEnvOrDefaultString("LOG_SINK_CLICKHOUSE_URL", ""), "/"),
+		database: common.GetEnvOrDefaultString("LOG_SINK_CLICKHOUSE_DATABASE", "default"),
+		table:    common.GetEnvOrDefaultString("LOG_SINK_CLICKHOUSE_TABLE", "logs"),
+		user:     common.GetEnvOrDefaultString("LOG_SINK_CLICKHOUSE_USER", "default"),
+		password: common.GetEnvOrDefaultString("LOG_SINK_CLICKHOUSE_PASSWORD", ""),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	if sink.url == "" {
+		return nil, fmt.Errorf("LOG_SINK_CLICKHOUSE_URL is required")
+	}
+	if _, err := sink.exec(sink.createTableSQL(), nil, nil); err != nil {
+		return nil, err
+	}
+	if !common.GetEnvOrDefaultBool("LOG_SINK_CLICKHOUSE_READ", true) {
+		return writeOnlyLogSink{sink}, nil
+	}
+	return sink, nil
+}
+
+// writeOnlyLogSink 隐藏 LogReader 实现，管理员查询继续走 SQL 日志表
+type writeOnlyLogSink struct {
+	sink LogSink
+}
+
+func (s writeOnlyLogSink) Name() string {
+	return s.sink.Name()
+}
+
+func (s writeOnlyLogSink) Write(logs []*Log) error {
+	return s.sink.Write(logs)
+}
+
+func (s *clickHouseLogSink) Name() string {
+	return "clickhouse"
+}
+
+func (s *clickHouseLogSink) tableName() string {
+	return fmt.Sprintf("`%s`.`%s`", s.database, s.table)
+}
+
+func (s *clickHouseLogSink) createTableSQL() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id Int64,
+	user_id Int64,
+	created_at Int64,
+	type Int32,
+	content String,
+	username String,
+	token_name String,
+	model_name String,
+	quota Int64,
+	prompt_tokens Int64,
+	completion_tokens Int64,
+	use_time Int64,
+	is_stream Bool,
+	channel Int64,
+	token_id Int64,
+	`+"`group`"+` String,
+	ip String,
+	other String,
+	org_id Int64,
+	project_id Int64
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(toDateTime(created_at))
+ORDER BY (created_at, id)`, s.tableName())
+}
+
+// exec 执行 SQL，params 为 {name:Type} 形式的查询参数，body 不为空时附加在 SQL 之后
+func (s *clickHouseLogSink) exec(query string, params map[string]string, body []byte) ([]byte, error) {
+	args := url.Values{}
+	args.Set("database", s.database)
+	args.Set("output_format_json_quote_64bit_integers", "0")
+	for name, value := range params {
+		args.Set("param_"+name, value)
+	}
+	var reqBody io.Reader = strings.NewReader(query)
+	if body != nil {
+		args.Set("query", query)
+		args.Set("input_format_skip_unknown_fields", "1")
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url+"/?"+args.Encode(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-ClickHouse-User", s.user)
+	if s.password != "" {
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("clickhouse status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
+
+func (s *clickHouseLogSink) Write(logs []*Log) error {
+	body, err := buildNDJSONBody(logs)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.tableName()), nil, body)
+	return err
+}
+
+// buildWhere 与 log.go 中各 SQL 查询的筛选条件保持一致
+func (s *clickHouseLogSink) buildWhere(query LogQuery, withTime bool) (string, map[string]string) {
+	conditions := []string{"1 = 1"}
+	params := make(map[string]string)
+	if query.Type != LogTypeUnknown {
+		conditions = append(conditions, "type = {type:Int32}")
+		params["type"] = strconv.Itoa(query.Type)
+	}
+	if query.ModelName != "" {
+		conditions = append(conditions, "model_name LIKE {model_name:String}")
+		params["model_name"] = query.ModelName
+	}
+	if query.Username != "" {
+		conditions = append(conditions, "username = {username:String}")
+		params["username"] = query.Username
+	}
+	if query.TokenName != "" {
+		conditions = append(conditions, "token_name = {token_name:String}")
+		params["token_name"] = query.TokenName
+	}
+	if withTime && query.StartTimestamp != 0 {
+		conditions = append(conditions, "created_at >= {start_timestamp:Int64}")
+		params["start_timestamp"] = strconv.FormatInt(query.StartTimestamp, 10)
+	}
+	if withTime && query.EndTimestamp != 0 {
+		conditions = append(conditions, "created_at <= {end_timestamp:Int64}")
+		params["end_timestamp"] = strconv.FormatInt(query.EndTimestamp, 10)
+	}
+	if query.Channel != 0 {
+		conditions = append(conditions, "channel = {channel:Int64}")
+		params["channel"] = strconv.Itoa(query.Channel)
+	}
+	if query.Group != "" {
+		conditions = append(conditions, "`group` = {group:String}")
+		params["group"] = query.Group
+	}
+	if query.UserId != 0 {
+		conditions = append(conditions, "user_id = {user_id:Int64}")
+		params["user_id"] = strconv.Itoa(query.UserId)
+	}
+	if query.TokenId != 0 {
+		conditions = append(conditions, "token_id = {token_id:Int64}")
+		params["token_id"] = strconv.Itoa(query.TokenId)
+	}
+	if query.OrgId != 0 {
+		conditions = append(conditions, "org_id = {org_id:Int64}")
+		params["org_id"] = strconv.Itoa(query.OrgId)
+	}
+	if query.ProjectId != 0 {
+		conditions = append(conditions, "project_id = {project_id:Int64}")
+		params["project_id"] = strconv.Itoa(query.ProjectId)
+	}
+	if query.Keyword != "" {
+		conditions = append(conditions, "(toString(type) = {keyword:String} OR startsWith(content, {keyword:String}))")
+		params["keyword"] = query.Keyword
+	}
+	return strings.Join(conditions, " AND "), params
+}
+
+func (s *clickHouseLogSink) QueryLogs(query LogQuery, startIdx int, num int) (logs []*Log, total int64, err error) {
+	where, params := s.buildWhere(query, true)
+	countBody, err := s.exec(fmt.Sprintf("SELECT count() AS total FROM %s WHERE %s FORMAT JSONEachRow", s.tableName(), where), params, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	var count struct {
+		Total int64 `json:"total"`
+	}
+	if err = common.Unmarshal(bytes.TrimSpace(countBody), &count); err != nil {
+		return nil, 0, err
+	}
+
+	params["limit"] = strconv.Itoa(num)
+	params["offset"] = strconv.Itoa(startIdx)
+	body, err := s.exec(fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY id DESC LIMIT {limit:UInt64} OFFSET {offset:UInt64} FORMAT JSONEachRow", s.tableName(), where), params, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		log := &Log{}
+		if err = common.Unmarshal(line, log); err != nil {
+			return nil, 0, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, count.Total, scanner.Err()
+}
+
+// SumUsedQuota 与 SQL 实现一致，只统计消费日志，rpm/tpm 取最近 60 秒
+func (s *clickHouseLogSink) SumUsedQuota(query LogQuery) (stat Stat, err error) {
+	query.Type = LogTypeConsume
+	where, params := s.buildWhere(query, true)
+	body, err := s.exec(fmt.Sprintf("SELECT sum(quota) AS quota FROM %s WHERE %s FORMAT JSONEachRow", s.tableName(), where), params, nil)
+	if err != nil {
+		return stat, err
+	}
+	if err = common.Unmarshal(bytes.TrimSpace(body), &stat); err != nil {
+		return stat, err
+	}
+
+	where, params = s.buildWhere(query, false)
+	params["since"] = strconv.FormatInt(time.Now().Add(-60*time.Second).Unix(), 10)
+	body, err = s.exec(fmt.Sprintf("SELECT count() AS rpm, sum(prompt_tokens) + sum(completion_tokens) AS tpm FROM %s WHERE %s AND created_at >= {since:Int64} FORMAT JSONEachRow", s.tableName(), where), params, nil)
+	if err != nil {
+		return stat, err
+	}
+	err = common.Unmarshal(bytes.TrimSpace(body), &stat)
+	return stat, err
+}
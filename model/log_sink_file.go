@@ -0,0 +1,100 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+const fileLogSinkPrefix = "logs-"
+
+// fileLogSink 按 JSON Lines 写入本地文件，超过大小后轮转并只保留最近若干个文件
+type fileLogSink struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newFileLogSink() (LogSink, error) {
+	sink := &fileLogSink{
+		dir:      common.GetEnvOrDefaultString("LOG_SINK_JSONL_DIR", "./logs/sink"),
+		maxSize:  int64(common.GetEnvOrDefault("LOG_SINK_JSONL_MAX_SIZE_MB", 100)) << 20,
+		maxFiles: common.GetEnvOrDefault("LOG_SINK_JSONL_MAX_FILES", 10),
+	}
+	if err := os.MkdirAll(sink.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := sink.rotate(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *fileLogSink) Name() string {
+	return "jsonl"
+}
+
+func (s *fileLogSink) Write(logs []*Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size >= s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(s.file)
+	for _, log := range logs {
+		data, err := common.Marshal(log)
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+		s.size += int64(len(data))
+	}
+	return w.Flush()
+}
+
+// rotate 打开新文件并删除超出数量的旧文件，需持有锁调用
+func (s *fileLogSink) rotate() error {
+	name := filepath.Join(s.dir, fmt.Sprintf("%s%s.jsonl", fileLogSinkPrefix, time.Now().Format("20060102-150405.000")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	s.file = file
+	s.size = 0
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil || s.maxFiles <= 0 {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), fileLogSinkPrefix) && strings.HasSuffix(entry.Name(), ".jsonl") {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	for len(files) > s.maxFiles {
+		_ = os.Remove(filepath.Join(s.dir, files[0]))
+		files = files[1:]
+	}
+	return nil
+}
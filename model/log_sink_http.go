@@ -0,0 +1,164 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+const (
+	httpLogSinkFormatNDJSON        = "ndjson"
+	httpLogSinkFormatElasticsearch = "elasticsearch"
+	httpLogSinkFormatLoki          = "loki"
+)
+
+// httpLogSink 批量推送到 HTTP 接口，支持 Elasticsearch _bulk、Loki push 与通用 NDJSON
+type httpLogSink struct {
+	url    string
+	format string
+	auth   string
+	index  string
+	client *http.Client
+}
+
+func newHTTPLogSink() (LogSink, error) {
+	sink := &httpLogSink{
+		url:    common.GetEnvOrDefaultString("LOG_SINK_HTTP_URL", ""),
+		format: common.GetEnvOrDefaultString("LOG_SINK_HTTP_FORMAT", httpLogSinkFormatNDJSON),
+		auth:   common.GetEnvOrDefaultString("LOG_SINK_HTTP_AUTH", ""),
+		index:  common.GetEnvOrDefaultString("LOG_SINK_HTTP_INDEX", "new-api-logs"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	if sink.url == "" {
+		return nil, fmt.Errorf("LOG_SINK_HTTP_URL is required")
+	}
+	switch sink.format {
+	case httpLogSinkFormatNDJSON, httpLogSinkFormatElasticsearch, httpLogSinkFormatLoki:
+	default:
+		return nil, fmt.Errorf("unknown LOG_SINK_HTTP_FORMAT: %s", sink.format)
+	}
+	return sink, nil
+}
+
+func (s *httpLogSink) Name() string {
+	return "http"
+}
+
+func (s *httpLogSink) Write(logs []*Log) error {
+	var body []byte
+	var contentType string
+	var err error
+	switch s.format {
+	case httpLogSinkFormatElasticsearch:
+		body, err = s.buildBulkBody(logs)
+		contentType = "application/x-ndjson"
+	case httpLogSinkFormatLoki:
+		body, err = buildLokiBody(logs)
+		contentType = "application/json"
+	default:
+		body, err = buildNDJSONBody(logs)
+		contentType = "application/x-ndjson"
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.auth != "" {
+		req.Header.Set("Authorization", s.auth)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if s.format == httpLogSinkFormatElasticsearch {
+		var bulkResp struct {
+			Errors bool `json:"errors"`
+		}
+		if err = common.Unmarshal(respBody, &bulkResp); err == nil && bulkResp.Errors {
+			return fmt.Errorf("bulk request has failed items")
+		}
+	}
+	return nil
+}
+
+func buildNDJSONBody(logs []*Log) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, log := range logs {
+		data, err := common.Marshal(log)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// buildBulkBody 以日志 Id 作为文档 Id，重试时不会产生重复文档
+func (s *httpLogSink) buildBulkBody(logs []*Log) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, log := range logs {
+		action, err := common.Marshal(map[string]any{
+			"index": map[string]any{"_index": s.index, "_id": strconv.Itoa(log.Id)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		data, err := common.Marshal(log)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// buildLokiBody 按日志类型分流，时间戳使用纳秒
+func buildLokiBody(logs []*Log) ([]byte, error) {
+	type lokiStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := make(map[int]*lokiStream)
+	var order []int
+	for _, log := range logs {
+		data, err := common.Marshal(log)
+		if err != nil {
+			return nil, err
+		}
+		stream, ok := streams[log.Type]
+		if !ok {
+			stream = &lokiStream{
+				Stream: map[string]string{"app": "new-api", "type": strconv.Itoa(log.Type)},
+			}
+			streams[log.Type] = stream
+			order = append(order, log.Type)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(log.CreatedAt*int64(time.Second), 10), string(data)})
+	}
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, logType := range order {
+		payload.Streams = append(payload.Streams, streams[logType])
+	}
+	return common.Marshal(payload)
+}
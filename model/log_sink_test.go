@@ -0,0 +1,220 @@
+package model
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryLogSink 测试用的外部存储，记录每次写入的批次；gate 不为 nil 时 Write 阻塞直到 gate 关闭
+type memoryLogSink struct {
+	mu      sync.Mutex
+	batches [][]*Log
+	gate    chan struct{}
+}
+
+func (s *memoryLogSink) Name() string {
+	return "memory"
+}
+
+func (s *memoryLogSink) Write(logs []*Log) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]*Log(nil), logs...))
+	return nil
+}
+
+// batchSizes 返回各批次的日志数量
+func (s *memoryLogSink) batchSizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizes := make([]int, 0, len(s.batches))
+	for _, batch := range s.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func (s *memoryLogSink) contents() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var contents []string
+	for _, batch := range s.batches {
+		for _, log := range batch {
+			contents = append(contents, log.Content)
+		}
+	}
+	return contents
+}
+
+// startTestLogSinkWorker 启动 worker 并注册为全局外部存储，测试结束时恢复
+func startTestLogSinkWorker(t *testing.T, sink LogSink, queueSize int, batchSize int, flushInterval time.Duration) *logSinkWorker {
+	worker := &logSinkWorker{
+		sink:          sink,
+		queue:         make(chan *Log, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	origWorkers := logSinkWorkers
+	t.Cleanup(func() { logSinkWorkers = origWorkers })
+	logSinkWorkers = []*logSinkWorker{worker}
+	go worker.run()
+	return worker
+}
+
+func TestLogSinkWorker(t *testing.T) {
+	tests := []struct {
+		name          string
+		batchSize     int
+		flushInterval time.Duration
+		logs          int
+		wantBefore    []int // 关闭前已写出的批次
+		wantAfter     []int // 关闭后全部写出的批次
+	}{
+		{name: "full batches flush immediately", batchSize: 2, flushInterval: time.Hour, logs: 5, wantBefore: []int{2, 2}, wantAfter: []int{2, 2, 1}},
+		{name: "partial batch flushes on interval", batchSize: 10, flushInterval: 20 * time.Millisecond, logs: 3, wantBefore: []int{3}, wantAfter: []int{3}},
+		{name: "queued logs drain on shutdown", batchSize: 10, flushInterval: time.Hour, logs: 3, wantAfter: []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memoryLogSink{}
+			worker := startTestLogSinkWorker(t, sink, 100, tt.batchSize, tt.flushInterval)
+			for i := 0; i < tt.logs; i++ {
+				worker.enqueue(&Log{Content: "log"})
+			}
+			if len(tt.wantBefore) > 0 {
+				require.Eventually(t, func() bool {
+					return len(sink.batchSizes()) == len(tt.wantBefore)
+				}, time.Second, 5*time.Millisecond)
+				require.Equal(t, tt.wantBefore, sink.batchSizes())
+			} else {
+				time.Sleep(20 * time.Millisecond)
+				require.Empty(t, sink.batchSizes())
+			}
+
+			CloseLogSinks(time.Second)
+			require.Equal(t, tt.wantAfter, sink.batchSizes())
+		})
+	}
+}
+
+func TestLogSinkWorkerBackPressure(t *testing.T) {
+	sink := &memoryLogSink{gate: make(chan struct{})}
+	worker := startTestLogSinkWorker(t, sink, 1, 1, time.Hour)
+
+	// 第一条被 worker 取出后阻塞在 Write，第二条占满队列，之后的日志直接丢弃
+	worker.enqueue(&Log{Content: "first"})
+	require.Eventually(t, func() bool { return len(worker.queue) == 0 }, time.Second, time.Millisecond)
+	enqueued := make(chan struct{})
+	go func() {
+		worker.enqueue(&Log{Content: "second"})
+		worker.enqueue(&Log{Content: "dropped"})
+		close(enqueued)
+	}()
+	select {
+	case <-enqueued:
+	case <-time.After(time.Second):
+		t.Fatal("enqueue blocked while the sink was busy")
+	}
+
+	close(sink.gate)
+	CloseLogSinks(time.Second)
+	require.Equal(t, []string{"first", "second"}, sink.contents())
+}
+
+func TestCloseLogSinksTimeout(t *testing.T) {
+	sink := &memoryLogSink{gate: make(chan struct{})}
+	t.Cleanup(func() { close(sink.gate) })
+	worker := startTestLogSinkWorker(t, sink, 10, 10, time.Hour)
+	worker.enqueue(&Log{Content: "stuck"})
+
+	start := time.Now()
+	CloseLogSinks(50 * time.Millisecond)
+	require.Less(t, time.Since(start), time.Second)
+	require.Empty(t, sink.batchSizes())
+}
+
+func TestSaveLogWithoutSql(t *testing.T) {
+	sink := &memoryLogSink{}
+	startTestLogSinkWorker(t, sink, 10, 10, time.Hour)
+	origEnabled := logSqlEnabled
+	t.Cleanup(func() { logSqlEnabled = origEnabled })
+	logSqlEnabled = false
+	logIdSequence.Store(100)
+
+	first, second := &Log{Content: "a"}, &Log{Content: "b"}
+	require.NoError(t, saveLog(first))
+	require.NoError(t, saveLog(second))
+	require.Equal(t, 101, first.Id)
+	require.Equal(t, 102, second.Id)
+
+	CloseLogSinks(time.Second)
+	require.Equal(t, []string{"a", "b"}, sink.contents())
+}
+
+// readJSONLLogs 按文件名顺序读取目录下全部日志文件中的日志内容
+func readJSONLLogs(t *testing.T, dir string) (files []string, contents []string) {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	sort.Strings(files)
+	for _, name := range files {
+		file, err := os.Open(filepath.Join(dir, name))
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var log Log
+			require.NoError(t, common.Unmarshal(scanner.Bytes(), &log))
+			contents = append(contents, log.Content)
+		}
+		require.NoError(t, file.Close())
+	}
+	return files, contents
+}
+
+func TestFileLogSink(t *testing.T) {
+	t.Run("writes json lines", func(t *testing.T) {
+		dir := t.TempDir()
+		t.Setenv("LOG_SINK_JSONL_DIR", dir)
+		sink, err := newFileLogSink()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = sink.(*fileLogSink).file.Close() })
+		require.Equal(t, "jsonl", sink.Name())
+
+		require.NoError(t, sink.Write([]*Log{{Id: 1, Content: "a"}, {Id: 2, Content: "b"}}))
+		require.NoError(t, sink.Write([]*Log{{Id: 3, Content: "c"}}))
+		files, contents := readJSONLLogs(t, dir)
+		require.Len(t, files, 1)
+		require.Equal(t, []string{"a", "b", "c"}, contents)
+	})
+
+	t.Run("rotates and keeps the newest files", func(t *testing.T) {
+		dir := t.TempDir()
+		sink := &fileLogSink{dir: dir, maxSize: 1, maxFiles: 2}
+		require.NoError(t, sink.rotate())
+		t.Cleanup(func() { _ = sink.file.Close() })
+		for _, content := range []string{"a", "b", "c", "d"} {
+			// 文件名精确到毫秒，间隔写入保证每次轮转生成新文件
+			time.Sleep(2 * time.Millisecond)
+			require.NoError(t, sink.Write([]*Log{{Content: content}}))
+		}
+		files, contents := readJSONLLogs(t, dir)
+		require.Len(t, files, 2)
+		require.Equal(t, []string{"c", "d"}, contents)
+	})
+}
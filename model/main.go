@@ -202,8 +202,10 @@ func InitDB() (err error) {
 			//_, _ = sqlDB.Exec("ALTER TABLE channels MODIFY model_mapping TEXT;") // TODO: delete this line when most users have upgraded
 		}
 		common.SysLog("database migration started")
-		err = migrateDB()
-		return err
+		if err = migrateDB(); err != nil {
+			return err
+		}
+		return backfillChannelKeyHashes()
 	} else {
 		common.FatalLog(err)
 	}
@@ -268,6 +270,22 @@ func migrateDB() error {
 		&TwoFA{},
 		&TwoFABackupCode{},
 		&Checkin{},
+		&File{},
+		&Batch{},
+		&ResponseCache{},
+		&Organization{},
+		&OrganizationMember{},
+		&Project{},
+		&BudgetUsage{},
+		&TaskCallback{},
+		&Artifact{},
+		&SubscriptionPlan{},
+		&Subscription{},
+		&QuotaLedger{},
+		&Invoice{},
+		&InvoiceSequence{},
+		&PendingInvoice{},
+		&StoredResponse{},
 	)
 	if err != nil {
 		return err
@@ -302,6 +320,22 @@ func migrateDBFast() error {
 		{&TwoFA{}, "TwoFA"},
 		{&TwoFABackupCode{}, "TwoFABackupCode"},
 		{&Checkin{}, "Checkin"},
+		{&File{}, "File"},
+		{&Batch{}, "Batch"},
+		{&ResponseCache{}, "ResponseCache"},
+		{&Organization{}, "Organization"},
+		{&OrganizationMember{}, "OrganizationMember"},
+		{&Project{}, "Project"},
+		{&BudgetUsage{}, "BudgetUsage"},
+		{&TaskCallback{}, "TaskCallback"},
+		{&Artifact{}, "Artifact"},
+		{&SubscriptionPlan{}, "SubscriptionPlan"},
+		{&Subscription{}, "Subscription"},
+		{&QuotaLedger{}, "QuotaLedger"},
+		{&Invoice{}, "Invoice"},
+		{&InvoiceSequence{}, "InvoiceSequence"},
+		{&PendingInvoice{}, "PendingInvoice"},
+		{&StoredResponse{}, "StoredResponse"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -332,7 +366,7 @@ func migrateDBFast() error {
 
 func migrateLOGDB() error {
 	var err error
-	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
+	if err = LOG_DB.AutoMigrate(&Log{}, &AuditLog{}, &CapturedPayload{}); err != nil {
 		return err
 	}
 	return nil
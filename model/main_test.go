@@ -0,0 +1,40 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	// 测试不连接 Redis，异步更新缓存的 goroutine 可能在单个测试恢复全局设置后才执行
+	common.RedisEnabled = false
+	os.Exit(m.Run())
+}
+
+// setupTestDB 使用内存 SQLite 替换全局 DB，测试结束后恢复
+func setupTestDB(t *testing.T, models ...any) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(models...))
+
+	origDB, origLogDB := DB, LOG_DB
+	origSQLite, origRedis := common.UsingSQLite, common.RedisEnabled
+	origGroupCol, origKeyCol := commonGroupCol, commonKeyCol
+	t.Cleanup(func() {
+		DB, LOG_DB = origDB, origLogDB
+		common.UsingSQLite, common.RedisEnabled = origSQLite, origRedis
+		commonGroupCol, commonKeyCol = origGroupCol, origKeyCol
+	})
+	DB, LOG_DB = db, db
+	common.UsingSQLite = true
+	common.RedisEnabled = false
+	commonGroupCol = "`group`"
+	commonKeyCol = "`key`"
+	return db
+}
@@ -1,28 +1,45 @@
 package model
 
+import "github.com/QuantumNous/new-api/types"
+
 type Midjourney struct {
-	Id          int    `json:"id"`
-	Code        int    `json:"code"`
-	UserId      int    `json:"user_id" gorm:"index"`
-	Action      string `json:"action" gorm:"type:varchar(40);index"`
-	MjId        string `json:"mj_id" gorm:"index"`
-	Prompt      string `json:"prompt"`
-	PromptEn    string `json:"prompt_en"`
-	Description string `json:"description"`
-	State       string `json:"state"`
-	SubmitTime  int64  `json:"submit_time" gorm:"index"`
-	StartTime   int64  `json:"start_time" gorm:"index"`
-	FinishTime  int64  `json:"finish_time" gorm:"index"`
-	ImageUrl    string `json:"image_url"`
-	VideoUrl    string `json:"video_url"`
-	VideoUrls   string `json:"video_urls"`
-	Status      string `json:"status" gorm:"type:varchar(20);index"`
-	Progress    string `json:"progress" gorm:"type:varchar(30);index"`
-	FailReason  string `json:"fail_reason"`
-	ChannelId   int    `json:"channel_id"`
-	Quota       int    `json:"quota"`
-	Buttons     string `json:"buttons"`
-	Properties  string `json:"properties"`
+	Id                int    `json:"id"`
+	Code              int    `json:"code"`
+	UserId            int    `json:"user_id" gorm:"index"`
+	ProjectId         int    `json:"project_id" gorm:"default:0"`
+	Action            string `json:"action" gorm:"type:varchar(40);index"`
+	MjId              string `json:"mj_id" gorm:"index"`
+	Prompt            string `json:"prompt"`
+	PromptEn          string `json:"prompt_en"`
+	Description       string `json:"description"`
+	State             string `json:"state"`
+	SubmitTime        int64  `json:"submit_time" gorm:"index"`
+	StartTime         int64  `json:"start_time" gorm:"index"`
+	FinishTime        int64  `json:"finish_time" gorm:"index"`
+	ImageUrl          string `json:"image_url"`
+	VideoUrl          string `json:"video_url"`
+	VideoUrls         string `json:"video_urls"`
+	Status            string `json:"status" gorm:"type:varchar(20);index"`
+	Progress          string `json:"progress" gorm:"type:varchar(30);index"`
+	FailReason        string `json:"fail_reason"`
+	ChannelId         int    `json:"channel_id"`
+	Quota             int    `json:"quota"`
+	SubscriptionQuota int    `json:"-" gorm:"default:0"` // Quota 中从订阅套餐额度扣除的部分，退款时退回套餐
+	Buttons           string `json:"buttons"`
+	Properties        string `json:"properties"`
+	CallbackUrl       string `json:"-" gorm:"type:varchar(512);default:''"` // 任务结束后的回调地址
+	CallbackSource    string `json:"-" gorm:"type:varchar(16);default:''"`
+	CallbackTokenId   int    `json:"-" gorm:"default:0"`
+}
+
+func (midjourney *Midjourney) SetCallbackTarget(target TaskCallbackTarget) {
+	midjourney.CallbackUrl = target.Url
+	midjourney.CallbackSource = target.Source
+	midjourney.CallbackTokenId = target.TokenId
+}
+
+func (midjourney *Midjourney) GetCallbackTarget() TaskCallbackTarget {
+	return TaskCallbackTarget{Url: midjourney.CallbackUrl, Source: midjourney.CallbackSource, TokenId: midjourney.CallbackTokenId}
 }
 
 // TaskQueryParams 用于包含所有搜索条件的结构体，可以根据需求添加更多字段
@@ -157,6 +174,20 @@ func (midjourney *Midjourney) Update() error {
 	return err
 }
 
+// BillingCharge 任务已扣额度的来源拆分
+func (midjourney *Midjourney) BillingCharge() types.BillingCharge {
+	return types.BillingCharge{Subscription: midjourney.SubscriptionQuota, Balance: midjourney.Quota - midjourney.SubscriptionQuota}
+}
+
+// UpdateSubscriptionQuota 任务写入后才完成扣费时，补记从套餐额度扣除的部分
+func (midjourney *Midjourney) UpdateSubscriptionQuota(quota int) error {
+	midjourney.SubscriptionQuota = quota
+	if midjourney.Id == 0 || quota == 0 {
+		return nil
+	}
+	return DB.Model(midjourney).Update("subscription_quota", quota).Error
+}
+
 func MjBulkUpdate(mjIds []string, params map[string]any) error {
 	return DB.Model(&Midjourney{}).
 		Where("mj_id in (?)", mjIds).
@@ -1,5 +1,7 @@
 package model
 
+import "github.com/QuantumNous/new-api/dto"
+
 func GetModelEnableGroups(modelName string) []string {
 	// 确保缓存最新
 	GetPricing()
@@ -29,3 +31,13 @@ func GetModelQuotaTypes(modelName string) []int {
 	}
 	return []int{quota}
 }
+
+// GetModelCapabilities 返回指定模型的能力（来自缓存），未配置时各项均为零值
+func GetModelCapabilities(modelName string) dto.ModelCapabilities {
+	GetPricing()
+
+	modelEnableGroupsLock.RLock()
+	capabilities := modelCapabilitiesMap[modelName]
+	modelEnableGroupsLock.RUnlock()
+	return capabilities
+}
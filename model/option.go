@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -11,6 +12,8 @@ import (
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"gorm.io/gorm"
 )
 
 type Option struct {
@@ -18,6 +21,42 @@ type Option struct {
 	Value string `json:"value"`
 }
 
+// IsSecretOption 判断配置项是否为密钥类配置，密钥类配置加密存储且不返回给前端
+func IsSecretOption(key string) bool {
+	return strings.HasSuffix(key, "Token") ||
+		strings.HasSuffix(key, "Secret") ||
+		strings.HasSuffix(key, "Key") ||
+		strings.HasSuffix(key, "secret") ||
+		strings.HasSuffix(key, "api_key")
+}
+
+// BeforeSave 加密密钥类配置
+func (option *Option) BeforeSave(tx *gorm.DB) error {
+	if !IsSecretOption(option.Key) {
+		return nil
+	}
+	value, err := common.EncryptSecret(option.Value)
+	if err != nil {
+		return err
+	}
+	option.Value = value
+	return nil
+}
+
+func (option *Option) AfterSave(tx *gorm.DB) error {
+	return option.AfterFind(tx)
+}
+
+// AfterFind 解密密钥类配置
+func (option *Option) AfterFind(tx *gorm.DB) error {
+	value, err := common.DecryptSecret(option.Value)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt option %s: %w", option.Key, err)
+	}
+	option.Value = value
+	return nil
+}
+
 func AllOption() ([]*Option, error) {
 	var options []*Option
 	var err error
@@ -121,6 +160,7 @@ func InitOptionMap() {
 	common.OptionMap["ImageRatio"] = ratio_setting.ImageRatio2JSONString()
 	common.OptionMap["AudioRatio"] = ratio_setting.AudioRatio2JSONString()
 	common.OptionMap["AudioCompletionRatio"] = ratio_setting.AudioCompletionRatio2JSONString()
+	common.OptionMap["TieredRatio"] = ratio_setting.TieredRatio2JSONString()
 	common.OptionMap["TopUpLink"] = common.TopUpLink
 	//common.OptionMap["ChatLink"] = common.ChatLink
 	//common.OptionMap["ChatLink2"] = common.ChatLink2
@@ -157,7 +197,10 @@ func InitOptionMap() {
 }
 
 func loadOptionsFromDatabase() {
-	options, _ := AllOption()
+	options, err := AllOption()
+	if err != nil {
+		common.SysError("failed to load options from database: " + err.Error())
+	}
 	for _, option := range options {
 		err := updateOptionMap(option.Key, option.Value)
 		if err != nil {
@@ -431,6 +474,8 @@ func updateOptionMap(key string, value string) (err error) {
 		err = ratio_setting.UpdateAudioRatioByJSONString(value)
 	case "AudioCompletionRatio":
 		err = ratio_setting.UpdateAudioCompletionRatioByJSONString(value)
+	case "TieredRatio":
+		err = ratio_setting.UpdateTieredRatioByJSONString(value)
 	case "TopUpLink":
 		common.TopUpLink = value
 	//case "ChatLink":
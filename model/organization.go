@@ -0,0 +1,446 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+// 组织成员角色
+const (
+	OrgRoleOwner  = "owner"  // 拥有者：管理组织、成员及所有项目
+	OrgRoleAdmin  = "admin"  // 管理员：管理成员和项目
+	OrgRoleMember = "member" // 成员：可在项目中创建和使用令牌
+	OrgRoleViewer = "viewer" // 观察者：仅可查看项目、日志与统计
+)
+
+var orgRoleLevels = map[string]int{
+	OrgRoleViewer: 1,
+	OrgRoleMember: 2,
+	OrgRoleAdmin:  3,
+	OrgRoleOwner:  4,
+}
+
+// 项目额度模式
+const (
+	ProjectQuotaModeOrg     = "org"     // 使用组织共享额度
+	ProjectQuotaModeProject = "project" // 使用项目独立额度，由组织额度划拨
+)
+
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"size:64;index"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+	Quota       int    `json:"quota" gorm:"default:0"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+type OrganizationMember struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"uniqueIndex:idx_org_member,priority:1"`
+	UserId      int    `json:"user_id" gorm:"uniqueIndex:idx_org_member,priority:2;index"`
+	Role        string `json:"role" gorm:"type:varchar(16)"`
+	QuotaLimit  int    `json:"quota_limit" gorm:"default:0"` // 成员在组织内的额度上限，0 表示不限制
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Username    string `json:"username" gorm:"-"`
+}
+
+type Project struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"index"`
+	Name        string `json:"name" gorm:"size:64"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+	QuotaMode   string `json:"quota_mode" gorm:"type:varchar(16);default:'org'"`
+	Quota       int    `json:"quota" gorm:"default:0"` // 仅独立额度模式下使用
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// UserOrganization 用户所在的组织及其角色
+type UserOrganization struct {
+	Organization
+	Role string `json:"role"`
+}
+
+func IsValidOrgRole(role string) bool {
+	_, ok := orgRoleLevels[role]
+	return ok
+}
+
+// OrgRoleAtLeast 判断角色权限是否不低于 required
+func OrgRoleAtLeast(role string, required string) bool {
+	return orgRoleLevels[role] >= orgRoleLevels[required]
+}
+
+func (member *OrganizationMember) HasRole(required string) bool {
+	return OrgRoleAtLeast(member.Role, required)
+}
+
+func (project *Project) UseOrgQuota() bool {
+	return project.QuotaMode != ProjectQuotaModeProject
+}
+
+// CreateOrganization 创建组织，并将创建者设为拥有者
+func CreateOrganization(org *Organization, ownerId int) error {
+	now := common.GetTimestamp()
+	org.CreatedTime = now
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrgId:       org.Id,
+			UserId:      ownerId,
+			Role:        OrgRoleOwner,
+			CreatedTime: now,
+		}).Error
+	})
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	org := Organization{}
+	err := DB.First(&org, "id = ?", id).Error
+	return &org, err
+}
+
+func GetAllOrganizations(startIdx int, num int) (orgs []*Organization, total int64, err error) {
+	err = DB.Model(&Organization{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, total, err
+}
+
+func GetUserOrganizations(userId int) (orgs []*UserOrganization, err error) {
+	err = DB.Table("organizations").
+		Select("organizations.*, organization_members.role").
+		Joins("join organization_members on organization_members.org_id = organizations.id").
+		Where("organization_members.user_id = ?", userId).
+		Order("organizations.id desc").
+		Find(&orgs).Error
+	return orgs, err
+}
+
+func (org *Organization) Update() error {
+	return DB.Model(org).Select("name", "description", "status").Updates(org).Error
+}
+
+// UpdateOrganizationQuota 管理员设置组织剩余额度
+func UpdateOrganizationQuota(id int, quota int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return setQuotaLedgerColumn(tx, QuotaLedgerSubjectOrg, id, 0, quota, QuotaLedgerRef{Type: QuotaLedgerTypeAdmin})
+	})
+}
+
+// DeleteOrganizationById 删除组织及其成员和项目，项目下的令牌将无法再使用
+func DeleteOrganizationById(id int) (err error) {
+	var tokens []*Token
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			deleteTokensCache(tokens)
+		}
+	}()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var projectIds []int
+		if err := tx.Model(&Project{}).Where("org_id = ?", id).Pluck("id", &projectIds).Error; err != nil {
+			return err
+		}
+		var err error
+		if tokens, err = detachProjectTokens(tx, projectIds); err != nil {
+			return err
+		}
+		if err := tx.Where("org_id = ?", id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("org_id = ?", id).Delete(&Project{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Organization{}, "id = ?", id).Error
+	})
+}
+
+// detachProjectTokens 项目被删除时停用其下的令牌并解除关联，避免令牌继续指向不存在的项目
+func detachProjectTokens(tx *gorm.DB, projectIds []int) ([]*Token, error) {
+	if len(projectIds) == 0 {
+		return nil, nil
+	}
+	var tokens []*Token
+	if err := tx.Where("project_id in ?", projectIds).Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	err := tx.Model(&Token{}).Where("project_id in ?", projectIds).Updates(map[string]any{
+		"project_id": 0,
+		"status":     common.TokenStatusDisabled,
+	}).Error
+	return tokens, err
+}
+
+func deleteTokensCache(tokens []*Token) {
+	gopool.Go(func() {
+		for _, token := range tokens {
+			if err := cacheDeleteToken(token.Key); err != nil {
+				common.SysLog("failed to delete token cache: " + err.Error())
+			}
+		}
+	})
+}
+
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	member := OrganizationMember{}
+	err := DB.Where("org_id = ? and user_id = ?", orgId, userId).First(&member).Error
+	return &member, err
+}
+
+func GetOrganizationMembers(orgId int) (members []*OrganizationMember, err error) {
+	err = DB.Where("org_id = ?", orgId).Order("id asc").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		member.Username, _ = GetUsernameById(member.UserId, false)
+	}
+	return members, nil
+}
+
+// GetOrganizationMemberIdsByRole 获取不低于指定角色的成员 ID
+func GetOrganizationMemberIdsByRole(orgId int, required string) (userIds []int, err error) {
+	var members []*OrganizationMember
+	err = DB.Where("org_id = ?", orgId).Find(&members).Error
+	for _, member := range members {
+		if member.HasRole(required) {
+			userIds = append(userIds, member.UserId)
+		}
+	}
+	return userIds, err
+}
+
+func CountOrganizationOwners(orgId int) (count int64, err error) {
+	err = DB.Model(&OrganizationMember{}).Where("org_id = ? and role = ?", orgId, OrgRoleOwner).Count(&count).Error
+	return count, err
+}
+
+func (member *OrganizationMember) Insert() error {
+	member.CreatedTime = common.GetTimestamp()
+	return DB.Create(member).Error
+}
+
+func (member *OrganizationMember) Update() error {
+	return DB.Model(member).Select("role", "quota_limit").Updates(member).Error
+}
+
+func (member *OrganizationMember) Delete() error {
+	return DB.Delete(member).Error
+}
+
+func GetProjectById(id int) (*Project, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	project := Project{}
+	err := DB.First(&project, "id = ?", id).Error
+	return &project, err
+}
+
+func GetOrganizationProjects(orgId int) (projects []*Project, err error) {
+	err = DB.Where("org_id = ?", orgId).Order("id desc").Find(&projects).Error
+	return projects, err
+}
+
+func (project *Project) Insert() error {
+	project.CreatedTime = common.GetTimestamp()
+	return DB.Create(project).Error
+}
+
+func (project *Project) Update() error {
+	return DB.Model(project).Select("name", "description", "quota_mode", "status").Updates(project).Error
+}
+
+func (project *Project) Delete() (err error) {
+	var tokens []*Token
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			deleteTokensCache(tokens)
+		}
+	}()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if tokens, err = detachProjectTokens(tx, []int{project.Id}); err != nil {
+			return err
+		}
+		return tx.Delete(project).Error
+	})
+}
+
+// TransferProjectQuota 在组织额度与项目独立额度之间划拨，quota 为正时从组织划入项目，为负时退回组织
+func TransferProjectQuota(project *Project, quota int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		org := Organization{}
+		if err := tx.First(&org, "id = ?", project.OrgId).Error; err != nil {
+			return err
+		}
+		current := Project{}
+		if err := tx.First(&current, "id = ?", project.Id).Error; err != nil {
+			return err
+		}
+		if quota > 0 && org.Quota < quota {
+			return fmt.Errorf("组织剩余额度不足，剩余额度: %d", org.Quota)
+		}
+		if quota < 0 && current.Quota < -quota {
+			return fmt.Errorf("项目剩余额度不足，剩余额度: %d", current.Quota)
+		}
+		ref := QuotaLedgerRef{Type: QuotaLedgerTypeTransfer, ReferenceId: strconv.Itoa(current.Id)}
+		if err := changeQuotaLedgerColumn(tx, QuotaLedgerSubjectOrg, org.Id, 0, -quota, ref); err != nil {
+			return err
+		}
+		return changeQuotaLedgerColumn(tx, QuotaLedgerSubjectProject, current.Id, 0, quota, ref)
+	})
+}
+
+// GetProjectAccess 校验用户能否通过项目令牌发起请求
+func GetProjectAccess(projectId int, userId int) (*Project, error) {
+	project, err := GetProjectById(projectId)
+	if err != nil {
+		return nil, errors.New("令牌所属项目不存在")
+	}
+	if project.Status != common.OrganizationStatusEnabled {
+		return nil, errors.New("令牌所属项目已被禁用")
+	}
+	org, err := GetOrganizationById(project.OrgId)
+	if err != nil {
+		return nil, errors.New("令牌所属组织不存在")
+	}
+	if org.Status != common.OrganizationStatusEnabled {
+		return nil, errors.New("令牌所属组织已被禁用")
+	}
+	member, err := GetOrganizationMember(project.OrgId, userId)
+	if err != nil || !member.HasRole(OrgRoleMember) {
+		return nil, errors.New("用户不是该组织的成员或无权使用令牌")
+	}
+	return project, nil
+}
+
+// GetProjectRemainQuota 获取成员通过项目可用的剩余额度，取组织或项目余额与成员上限中的较小值
+func GetProjectRemainQuota(projectId int, userId int) (int, error) {
+	project, err := GetProjectById(projectId)
+	if err != nil {
+		return 0, err
+	}
+	remain := project.Quota
+	if project.UseOrgQuota() {
+		org, err := GetOrganizationById(project.OrgId)
+		if err != nil {
+			return 0, err
+		}
+		remain = org.Quota
+	}
+	member, err := GetOrganizationMember(project.OrgId, userId)
+	if err != nil {
+		return 0, err
+	}
+	if member.QuotaLimit > 0 && member.QuotaLimit-member.UsedQuota < remain {
+		remain = member.QuotaLimit - member.UsedQuota
+	}
+	return remain, nil
+}
+
+// changeProjectQuota 扣减（quota 为正）或返还（quota 为负）项目计费来源的额度，并同步组织、项目和成员的已用额度，额度与成员用量写入流水
+func changeProjectQuota(projectId int, userId int, quota int, ref QuotaLedgerRef) error {
+	project, err := GetProjectById(projectId)
+	if err != nil {
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Project{}).Where("id = ?", project.Id).Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Organization{}).Where("id = ?", project.OrgId).Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error; err != nil {
+			return err
+		}
+		if project.UseOrgQuota() {
+			err = changeQuotaLedgerColumn(tx, QuotaLedgerSubjectOrg, project.OrgId, userId, -quota, ref)
+		} else {
+			err = changeQuotaLedgerColumn(tx, QuotaLedgerSubjectProject, project.Id, userId, -quota, ref)
+		}
+		if err != nil {
+			return err
+		}
+		var memberId int
+		if err := tx.Model(&OrganizationMember{}).Where("org_id = ? and user_id = ?", project.OrgId, userId).
+			Select("id").Find(&memberId).Error; err != nil || memberId == 0 {
+			return err
+		}
+		return changeQuotaLedgerColumn(tx, QuotaLedgerSubjectOrgMember, memberId, userId, quota, ref)
+	})
+}
+
+func DecreaseProjectQuota(projectId int, userId int, quota int, ref QuotaLedgerRef) error {
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	return changeProjectQuota(projectId, userId, quota, ref)
+}
+
+func IncreaseProjectQuota(projectId int, userId int, quota int, ref QuotaLedgerRef) error {
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	return changeProjectQuota(projectId, userId, -quota, ref)
+}
+
+// GetBillingQuota 获取计费来源的剩余额度，项目令牌使用组织或项目额度，其余使用用户额度
+func GetBillingQuota(userId int, projectId int) (int, error) {
+	if projectId > 0 {
+		return GetProjectRemainQuota(projectId, userId)
+	}
+	quota, err := GetUserQuota(userId, false)
+	if err != nil {
+		return quota, err
+	}
+	return quota + GetSubscriptionRemainQuota(userId), nil
+}
+
+// DecreaseBillingQuota 个人计费时优先扣除订阅套餐额度，返回套餐额度与余额各自扣除的部分
+func DecreaseBillingQuota(userId int, projectId int, quota int, ref QuotaLedgerRef) (types.BillingCharge, error) {
+	if projectId > 0 {
+		return types.BillingCharge{Balance: quota}, DecreaseProjectQuota(projectId, userId, quota, ref)
+	}
+	charge := types.BillingCharge{Subscription: consumeSubscriptionQuota(userId, quota, ref)}
+	charge.Balance = quota - charge.Subscription
+	if charge.Balance == 0 {
+		return charge, nil
+	}
+	if err := DecreaseUserQuota(userId, charge.Balance, ref); err != nil {
+		charge.Balance = 0
+		return charge, err
+	}
+	return charge, nil
+}
+
+// IncreaseBillingQuota 按扣费来源退还额度，refund 通常由 BillingCharge.Refund 拆分得到
+func IncreaseBillingQuota(userId int, projectId int, refund types.BillingCharge, ref QuotaLedgerRef) error {
+	if projectId > 0 {
+		return IncreaseProjectQuota(projectId, userId, refund.Total(), ref)
+	}
+	balance := refund.Balance + refund.Subscription - refundSubscriptionQuota(userId, refund.Subscription, ref)
+	if balance == 0 {
+		return nil
+	}
+	return IncreaseUserQuota(userId, balance, false, ref)
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupOrganizationTest 创建用户 1（余额 1000）、组织 1（额度 500）及其成员，以及使用组织额度的项目 1 和独立额度的项目 2（额度 200）
+func setupOrganizationTest(t *testing.T, quotaLimit int) *gorm.DB {
+	db := setupTestDB(t, &User{}, &Subscription{}, &SubscriptionPlan{}, &QuotaLedger{}, &Token{},
+		&Organization{}, &OrganizationMember{}, &Project{})
+	require.NoError(t, db.Create(&User{Id: 1, Username: "u1", Quota: 1000}).Error)
+	require.NoError(t, db.Create(&Organization{Id: 1, Name: "org", Quota: 500, Status: common.OrganizationStatusEnabled}).Error)
+	require.NoError(t, db.Create(&OrganizationMember{Id: 1, OrgId: 1, UserId: 1, Role: OrgRoleMember, QuotaLimit: quotaLimit}).Error)
+	require.NoError(t, db.Create(&Project{Id: 1, OrgId: 1, Name: "shared", QuotaMode: ProjectQuotaModeOrg, Status: common.OrganizationStatusEnabled}).Error)
+	require.NoError(t, db.Create(&Project{Id: 2, OrgId: 1, Name: "own", QuotaMode: ProjectQuotaModeProject, Quota: 200, Status: common.OrganizationStatusEnabled}).Error)
+	return db
+}
+
+func TestProjectBillingQuota(t *testing.T) {
+	tests := []struct {
+		name           string
+		projectId      int
+		quotaLimit     int
+		wantRemain     int
+		wantOrgQuota   int
+		wantProjQuota  int
+		wantUserQuota  int
+		wantMemberUsed int
+	}{
+		{name: "personal token uses user quota", projectId: 0, wantRemain: 1000, wantOrgQuota: 500, wantProjQuota: 200, wantUserQuota: 900},
+		{name: "org quota mode", projectId: 1, wantRemain: 500, wantOrgQuota: 400, wantProjQuota: 200, wantUserQuota: 1000, wantMemberUsed: 100},
+		{name: "project quota mode", projectId: 2, wantRemain: 200, wantOrgQuota: 500, wantProjQuota: 100, wantUserQuota: 1000, wantMemberUsed: 100},
+		{name: "member limit caps org quota", projectId: 1, quotaLimit: 300, wantRemain: 300, wantOrgQuota: 400, wantProjQuota: 200, wantUserQuota: 1000, wantMemberUsed: 100},
+		{name: "member limit above project quota", projectId: 2, quotaLimit: 300, wantRemain: 200, wantOrgQuota: 500, wantProjQuota: 100, wantUserQuota: 1000, wantMemberUsed: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupOrganizationTest(t, tt.quotaLimit)
+			invalidateActiveSubscriptionCache(1)
+			t.Cleanup(func() { invalidateActiveSubscriptionCache(1) })
+
+			remain, err := GetBillingQuota(1, tt.projectId)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantRemain, remain)
+
+			ref := QuotaLedgerRef{Type: QuotaLedgerTypeConsume, ReferenceId: "req-1"}
+			charge, err := DecreaseBillingQuota(1, tt.projectId, 100, ref)
+			require.NoError(t, err)
+			require.Equal(t, types.BillingCharge{Balance: 100}, charge)
+
+			var org Organization
+			require.NoError(t, db.First(&org, 1).Error)
+			require.Equal(t, tt.wantOrgQuota, org.Quota)
+			var project Project
+			require.NoError(t, db.First(&project, 2).Error)
+			require.Equal(t, tt.wantProjQuota, project.Quota)
+			var user User
+			require.NoError(t, db.First(&user, 1).Error)
+			require.Equal(t, tt.wantUserQuota, user.Quota)
+			var member OrganizationMember
+			require.NoError(t, db.First(&member, 1).Error)
+			require.Equal(t, tt.wantMemberUsed, member.UsedQuota)
+			if tt.projectId > 0 {
+				require.Equal(t, 100, org.UsedQuota)
+			}
+
+			// 退款回到原计费来源
+			require.NoError(t, IncreaseBillingQuota(1, tt.projectId, charge, QuotaLedgerRef{Type: QuotaLedgerTypeRefund, ReferenceId: "req-1"}))
+			require.NoError(t, db.First(&org, 1).Error)
+			require.Equal(t, 500, org.Quota)
+			require.Zero(t, org.UsedQuota)
+			require.NoError(t, db.First(&project, 2).Error)
+			require.Equal(t, 200, project.Quota)
+			require.NoError(t, db.First(&user, 1).Error)
+			require.Equal(t, 1000, user.Quota)
+			require.NoError(t, db.First(&member, 1).Error)
+			require.Zero(t, member.UsedQuota)
+		})
+	}
+}
+
+func TestDeleteProjectTokens(t *testing.T) {
+	tests := []struct {
+		name          string
+		deleteProject bool
+		wantDetached  []int
+		wantProjects  int64
+		wantMembers   int64
+		wantOrgExists bool
+	}{
+		{name: "delete project", deleteProject: true, wantDetached: []int{1}, wantProjects: 1, wantMembers: 1, wantOrgExists: true},
+		{name: "delete organization", wantDetached: []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupOrganizationTest(t, 0)
+			require.NoError(t, db.Create(&Token{Id: 1, UserId: 1, Key: "k1", Name: "shared", ProjectId: 1, Status: common.TokenStatusEnabled}).Error)
+			require.NoError(t, db.Create(&Token{Id: 2, UserId: 1, Key: "k2", Name: "own", ProjectId: 2, Status: common.TokenStatusEnabled}).Error)
+			require.NoError(t, db.Create(&Token{Id: 3, UserId: 1, Key: "k3", Name: "personal", Status: common.TokenStatusEnabled}).Error)
+
+			if tt.deleteProject {
+				project, err := GetProjectById(1)
+				require.NoError(t, err)
+				require.NoError(t, project.Delete())
+			} else {
+				require.NoError(t, DeleteOrganizationById(1))
+			}
+
+			var tokens []Token
+			require.NoError(t, db.Order("id").Find(&tokens).Error)
+			require.Len(t, tokens, 3)
+			for _, token := range tokens {
+				if slices.Contains(tt.wantDetached, token.Id) {
+					require.Zero(t, token.ProjectId, "token %d", token.Id)
+					require.Equal(t, common.TokenStatusDisabled, token.Status, "token %d", token.Id)
+				} else {
+					require.Equal(t, common.TokenStatusEnabled, token.Status, "token %d", token.Id)
+				}
+			}
+			require.Zero(t, tokens[2].ProjectId)
+
+			var projects, members, orgs int64
+			require.NoError(t, db.Model(&Project{}).Count(&projects).Error)
+			require.NoError(t, db.Model(&OrganizationMember{}).Count(&members).Error)
+			require.NoError(t, db.Model(&Organization{}).Count(&orgs).Error)
+			require.Equal(t, tt.wantProjects, projects)
+			require.Equal(t, tt.wantMembers, members)
+			require.Equal(t, tt.wantOrgExists, orgs == 1)
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
 )
@@ -25,8 +26,11 @@ type Pricing struct {
 	ModelPrice             float64                 `json:"model_price"`
 	OwnerBy                string                  `json:"owner_by"`
 	CompletionRatio        float64                 `json:"completion_ratio"`
+	PriceTiers             []types.PriceTier       `json:"price_tiers,omitempty"`
 	EnableGroup            []string                `json:"enable_groups"`
 	SupportedEndpointTypes []constant.EndpointType `json:"supported_endpoint_types"`
+
+	dto.ModelCapabilities
 }
 
 type PricingVendor struct {
@@ -43,9 +47,10 @@ var (
 	lastGetPricingTime   time.Time
 	updatePricingLock    sync.Mutex
 
-	// 缓存映射：模型名 -> 启用分组 / 计费类型
+	// 缓存映射：模型名 -> 启用分组 / 计费类型 / 能力
 	modelEnableGroups     = make(map[string][]string)
 	modelQuotaTypeMap     = make(map[string]int)
+	modelCapabilitiesMap  = make(map[string]dto.ModelCapabilities)
 	modelEnableGroupsLock = sync.RWMutex{}
 )
 
@@ -262,6 +267,7 @@ func updatePricing() {
 		}
 	}
 
+	tieredRatio := ratio_setting.GetTieredRatioCopy()
 	pricingMap = make([]Pricing, 0)
 	for model, groups := range modelGroupsMap {
 		pricing := Pricing{
@@ -280,6 +286,7 @@ func updatePricing() {
 			pricing.Icon = meta.Icon
 			pricing.Tags = meta.Tags
 			pricing.VendorID = meta.VendorID
+			pricing.ModelCapabilities = meta.ModelCapabilities
 		}
 		modelPrice, findPrice := ratio_setting.GetModelPrice(model, false)
 		if findPrice {
@@ -289,6 +296,7 @@ func updatePricing() {
 			modelRatio, _, _ := ratio_setting.GetModelRatio(model)
 			pricing.ModelRatio = modelRatio
 			pricing.CompletionRatio = ratio_setting.GetCompletionRatio(model)
+			pricing.PriceTiers = tieredRatio[model]
 			pricing.QuotaType = 0
 		}
 		pricingMap = append(pricingMap, pricing)
@@ -298,9 +306,11 @@ func updatePricing() {
 	modelEnableGroupsLock.Lock()
 	modelEnableGroups = make(map[string][]string)
 	modelQuotaTypeMap = make(map[string]int)
+	modelCapabilitiesMap = make(map[string]dto.ModelCapabilities)
 	for _, p := range pricingMap {
 		modelEnableGroups[p.ModelName] = p.EnableGroup
 		modelQuotaTypeMap[p.ModelName] = p.QuotaType
+		modelCapabilitiesMap[p.ModelName] = p.ModelCapabilities
 	}
 	modelEnableGroupsLock.Unlock()
 
@@ -0,0 +1,235 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 流水对象，BalanceAfter 为对象被追踪字段变更后的值
+const (
+	QuotaLedgerSubjectUser         = "user"         // 用户余额 quota
+	QuotaLedgerSubjectToken        = "token"        // 令牌剩余额度 remain_quota
+	QuotaLedgerSubjectOrg          = "organization" // 组织剩余额度 quota
+	QuotaLedgerSubjectProject      = "project"      // 项目独立额度 quota
+	QuotaLedgerSubjectOrgMember    = "org_member"   // 成员在组织内的已用额度 used_quota
+	QuotaLedgerSubjectSubscription = "subscription" // 订阅本周期已用的套餐额度 used_quota
+)
+
+const (
+	QuotaLedgerTypeConsume     = "consume"      // 请求或任务扣费
+	QuotaLedgerTypeRefund      = "refund"       // 预扣费返还、任务失败退款
+	QuotaLedgerTypeTopup       = "topup"        // 在线充值
+	QuotaLedgerTypeTopupRefund = "topup_refund" // 充值退款扣回额度
+	QuotaLedgerTypeRedeem      = "redeem"       // 兑换码
+	QuotaLedgerTypeAffTransfer = "aff_transfer" // 邀请额度划转
+	QuotaLedgerTypeCheckin     = "checkin"      // 签到奖励
+	QuotaLedgerTypeInvite      = "invite"       // 注册邀请奖励
+	QuotaLedgerTypeAdmin       = "admin"        // 管理员或用户手动调整
+	QuotaLedgerTypeBatch       = "batch"        // 批量更新模式下合并写入的增减
+	QuotaLedgerTypeTransfer    = "transfer"     // 组织与项目之间划拨额度
+	QuotaLedgerTypeNewPeriod   = "new_period"   // 订阅进入新周期重置已用额度
+)
+
+// quotaLedgerColumns 流水对象对应的表与被追踪字段，用户与令牌的消费扣减另见 changeUserQuota、changeTokenQuota
+var quotaLedgerColumns = map[string]struct {
+	model  func() interface{}
+	column string
+}{
+	QuotaLedgerSubjectOrg:          {func() interface{} { return &Organization{} }, "quota"},
+	QuotaLedgerSubjectProject:      {func() interface{} { return &Project{} }, "quota"},
+	QuotaLedgerSubjectOrgMember:    {func() interface{} { return &OrganizationMember{} }, "used_quota"},
+	QuotaLedgerSubjectSubscription: {func() interface{} { return &Subscription{} }, "used_quota"},
+	QuotaLedgerSubjectToken:        {func() interface{} { return &Token{} }, "remain_quota"},
+}
+
+// QuotaLedger 额度流水，只追加不修改，每次余额变动与流水在同一事务内写入
+type QuotaLedger struct {
+	Id            int    `json:"id"`
+	Subject       string `json:"subject" gorm:"type:varchar(16);index:idx_quota_ledger_subject,priority:1"`
+	SubjectId     int    `json:"subject_id" gorm:"index:idx_quota_ledger_subject,priority:2"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	EntryType     string `json:"entry_type" gorm:"type:varchar(32);index"`
+	ReferenceId   string `json:"reference_id" gorm:"type:varchar(191);index"`
+	Delta         int    `json:"delta"`
+	BalanceBefore int    `json:"balance_before"`
+	BalanceAfter  int    `json:"balance_after"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// QuotaLedgerRef 额度变动的来源，ReferenceId 为请求 ID、订单号、任务 ID 等
+type QuotaLedgerRef struct {
+	Type        string
+	ReferenceId string
+}
+
+type QuotaLedgerFilter struct {
+	Subject     string
+	SubjectId   int
+	UserId      int
+	EntryType   string
+	ReferenceId string
+	StartTime   int64
+	EndTime     int64
+}
+
+func recordQuotaLedger(tx *gorm.DB, subject string, subjectId int, userId int, delta int, after int, ref QuotaLedgerRef) error {
+	return tx.Create(&QuotaLedger{
+		Subject:       subject,
+		SubjectId:     subjectId,
+		UserId:        userId,
+		EntryType:     ref.Type,
+		ReferenceId:   ref.ReferenceId,
+		Delta:         delta,
+		BalanceBefore: after - delta,
+		BalanceAfter:  after,
+		CreatedAt:     common.GetTimestamp(),
+	}).Error
+}
+
+// changeUserQuota 在事务内增减用户余额并写入流水。先更新再读取，更新语句持有行锁，读到的即为本次变更后的余额
+func changeUserQuota(tx *gorm.DB, userId int, delta int, ref QuotaLedgerRef) error {
+	if delta == 0 {
+		return nil
+	}
+	if err := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", delta)).Error; err != nil {
+		return err
+	}
+	var after int
+	if err := tx.Model(&User{}).Where("id = ?", userId).Select("quota").Find(&after).Error; err != nil {
+		return err
+	}
+	return recordQuotaLedger(tx, QuotaLedgerSubjectUser, userId, userId, delta, after, ref)
+}
+
+// changeTokenQuota 在事务内增减令牌剩余额度并写入流水，已用额度反向变动
+func changeTokenQuota(tx *gorm.DB, tokenId int, delta int, ref QuotaLedgerRef) error {
+	if delta == 0 {
+		return nil
+	}
+	err := tx.Model(&Token{}).Where("id = ?", tokenId).Updates(
+		map[string]interface{}{
+			"remain_quota":  gorm.Expr("remain_quota + ?", delta),
+			"used_quota":    gorm.Expr("used_quota - ?", delta),
+			"accessed_time": common.GetTimestamp(),
+		},
+	).Error
+	if err != nil {
+		return err
+	}
+	var token Token
+	if err = tx.Select("id", "user_id", "remain_quota").Where("id = ?", tokenId).Find(&token).Error; err != nil {
+		return err
+	}
+	return recordQuotaLedger(tx, QuotaLedgerSubjectToken, tokenId, token.UserId, delta, token.RemainQuota, ref)
+}
+
+func getQuotaLedgerColumnValue(tx *gorm.DB, subject string, subjectId int) (int, error) {
+	target, ok := quotaLedgerColumns[subject]
+	if !ok {
+		return 0, fmt.Errorf("unknown quota ledger subject: %s", subject)
+	}
+	var value int
+	err := tx.Model(target.model()).Where("id = ?", subjectId).Select(target.column).Find(&value).Error
+	return value, err
+}
+
+// changeQuotaLedgerColumn 在事务内增减组织、项目、成员、订阅或令牌的被追踪字段并写入流水
+func changeQuotaLedgerColumn(tx *gorm.DB, subject string, subjectId int, userId int, delta int, ref QuotaLedgerRef) error {
+	if delta == 0 {
+		return nil
+	}
+	target, ok := quotaLedgerColumns[subject]
+	if !ok {
+		return fmt.Errorf("unknown quota ledger subject: %s", subject)
+	}
+	if err := tx.Model(target.model()).Where("id = ?", subjectId).
+		Update(target.column, gorm.Expr(target.column+" + ?", delta)).Error; err != nil {
+		return err
+	}
+	after, err := getQuotaLedgerColumnValue(tx, subject, subjectId)
+	if err != nil {
+		return err
+	}
+	return recordQuotaLedger(tx, subject, subjectId, userId, delta, after, ref)
+}
+
+// setQuotaLedgerColumn 在事务内把被追踪字段设为指定值，按差值写入流水
+func setQuotaLedgerColumn(tx *gorm.DB, subject string, subjectId int, userId int, value int, ref QuotaLedgerRef) error {
+	before, err := getQuotaLedgerColumnValue(tx, subject, subjectId)
+	if err != nil {
+		return err
+	}
+	if before == value {
+		return nil
+	}
+	target := quotaLedgerColumns[subject]
+	if err := tx.Model(target.model()).Where("id = ?", subjectId).Update(target.column, value).Error; err != nil {
+		return err
+	}
+	return recordQuotaLedger(tx, subject, subjectId, userId, value-before, value, ref)
+}
+
+// GetQuotaLedgerColumnValue 读取组织、项目、成员、订阅或令牌当前的被追踪字段，用于对账
+func GetQuotaLedgerColumnValue(subject string, subjectId int) (int, error) {
+	return getQuotaLedgerColumnValue(DB, subject, subjectId)
+}
+
+func GetQuotaLedgers(filter QuotaLedgerFilter, startIdx int, num int) (ledgers []*QuotaLedger, total int64, err error) {
+	tx := DB.Model(&QuotaLedger{})
+	if filter.Subject != "" {
+		tx = tx.Where("subject = ?", filter.Subject)
+	}
+	if filter.SubjectId != 0 {
+		tx = tx.Where("subject_id = ?", filter.SubjectId)
+	}
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.EntryType != "" {
+		tx = tx.Where("entry_type = ?", filter.EntryType)
+	}
+	if filter.ReferenceId != "" {
+		tx = tx.Where("reference_id = ?", filter.ReferenceId)
+	}
+	if filter.StartTime != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTime)
+	}
+	if filter.EndTime != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTime)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&ledgers).Error
+	return ledgers, total, err
+}
+
+// GetQuotaLedgerSubjectsSince 获取指定时间之后有流水的用户或令牌
+func GetQuotaLedgerSubjectsSince(subject string, since int64) (ids []int, err error) {
+	err = DB.Model(&QuotaLedger{}).Where("subject = ? AND created_at >= ?", subject, since).
+		Distinct("subject_id").Pluck("subject_id", &ids).Error
+	return ids, err
+}
+
+// GetLatestQuotaLedger 获取用户或令牌的最后一条流水
+func GetLatestQuotaLedger(subject string, subjectId int) (*QuotaLedger, error) {
+	ledger := &QuotaLedger{}
+	err := DB.Where("subject = ? AND subject_id = ?", subject, subjectId).Order("id desc").First(ledger).Error
+	return ledger, err
+}
+
+// GetTokenQuotaFromDB 直接从数据库读取令牌额度，不回写缓存，供对账使用
+func GetTokenQuotaFromDB(id int) (*Token, error) {
+	token := &Token{}
+	err := DB.Select("id", "user_id", "key", "remain_quota").Where("id = ?", id).First(token).Error
+	return token, err
+}
+
+// GetUserQuotaFromDB 直接从数据库读取用户余额，不回写缓存，供对账使用
+func GetUserQuotaFromDB(id int) (quota int, err error) {
+	err = DB.Model(&User{}).Where("id = ?", id).Select("quota").Find(&quota).Error
+	return quota, err
+}
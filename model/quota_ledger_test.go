@@ -0,0 +1,286 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupQuotaLedgerTest(t *testing.T) *gorm.DB {
+	db := setupTestDB(t, &User{}, &Token{}, &Organization{}, &Project{}, &OrganizationMember{}, &Subscription{}, &QuotaLedger{})
+	require.NoError(t, db.Create(&User{Id: 1, Username: "u1", Quota: 100}).Error)
+	require.NoError(t, db.Create(&Token{Id: 1, UserId: 1, Key: "k1", RemainQuota: 50, UsedQuota: 10}).Error)
+	require.NoError(t, db.Create(&Organization{Id: 1, Quota: 1000}).Error)
+	require.NoError(t, db.Create(&Project{Id: 1, OrgId: 1, QuotaMode: ProjectQuotaModeOrg}).Error)
+	require.NoError(t, db.Create(&Project{Id: 2, OrgId: 1, QuotaMode: ProjectQuotaModeProject, Quota: 200}).Error)
+	require.NoError(t, db.Create(&OrganizationMember{Id: 1, OrgId: 1, UserId: 1, Role: OrgRoleMember}).Error)
+	require.NoError(t, db.Create(&Subscription{Id: 1, UserId: 1, Quota: 500, UsedQuota: 120}).Error)
+	return db
+}
+
+func latestLedgers(t *testing.T, db *gorm.DB, subject string, subjectId int) []QuotaLedger {
+	var ledgers []QuotaLedger
+	require.NoError(t, db.Where("subject = ? AND subject_id = ?", subject, subjectId).Order("id").Find(&ledgers).Error)
+	return ledgers
+}
+
+func TestChangeQuotaLedgerColumn(t *testing.T) {
+	tests := []struct {
+		name      string
+		subject   string
+		subjectId int
+		delta     int
+		want      int
+		wantErr   bool
+	}{
+		{name: "organization", subject: QuotaLedgerSubjectOrg, subjectId: 1, delta: -300, want: 700},
+		{name: "project", subject: QuotaLedgerSubjectProject, subjectId: 2, delta: 50, want: 250},
+		{name: "org member", subject: QuotaLedgerSubjectOrgMember, subjectId: 1, delta: 30, want: 30},
+		{name: "subscription", subject: QuotaLedgerSubjectSubscription, subjectId: 1, delta: 80, want: 200},
+		{name: "unknown subject", subject: "wallet", subjectId: 1, delta: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupQuotaLedgerTest(t)
+			ref := QuotaLedgerRef{Type: QuotaLedgerTypeConsume, ReferenceId: "req-1"}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return changeQuotaLedgerColumn(tx, tt.subject, tt.subjectId, 1, tt.delta, ref)
+			})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			value, err := GetQuotaLedgerColumnValue(tt.subject, tt.subjectId)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, value)
+
+			ledgers := latestLedgers(t, db, tt.subject, tt.subjectId)
+			require.Len(t, ledgers, 1)
+			require.Equal(t, tt.delta, ledgers[0].Delta)
+			require.Equal(t, tt.want-tt.delta, ledgers[0].BalanceBefore)
+			require.Equal(t, tt.want, ledgers[0].BalanceAfter)
+			require.Equal(t, 1, ledgers[0].UserId)
+			require.Equal(t, QuotaLedgerTypeConsume, ledgers[0].EntryType)
+			require.Equal(t, "req-1", ledgers[0].ReferenceId)
+		})
+	}
+}
+
+func TestSetQuotaLedgerColumn(t *testing.T) {
+	tests := []struct {
+		name       string
+		subject    string
+		value      int
+		wantDelta  int
+		wantLedger bool
+	}{
+		{name: "reset subscription usage", subject: QuotaLedgerSubjectSubscription, value: 0, wantDelta: -120, wantLedger: true},
+		{name: "set organization quota", subject: QuotaLedgerSubjectOrg, value: 1500, wantDelta: 500, wantLedger: true},
+		{name: "unchanged value writes nothing", subject: QuotaLedgerSubjectOrg, value: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupQuotaLedgerTest(t)
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return setQuotaLedgerColumn(tx, tt.subject, 1, 1, tt.value, QuotaLedgerRef{Type: QuotaLedgerTypeAdmin})
+			})
+			require.NoError(t, err)
+			value, err := GetQuotaLedgerColumnValue(tt.subject, 1)
+			require.NoError(t, err)
+			require.Equal(t, tt.value, value)
+
+			ledgers := latestLedgers(t, db, tt.subject, 1)
+			if !tt.wantLedger {
+				require.Empty(t, ledgers)
+				return
+			}
+			require.Len(t, ledgers, 1)
+			require.Equal(t, tt.wantDelta, ledgers[0].Delta)
+			require.Equal(t, tt.value, ledgers[0].BalanceAfter)
+		})
+	}
+}
+
+func TestUserAndTokenQuotaLedger(t *testing.T) {
+	tests := []struct {
+		name      string
+		subject   string
+		change    func(tx *gorm.DB, delta int, ref QuotaLedgerRef) error
+		delta     int
+		wantAfter int
+	}{
+		{
+			name:    "user consume",
+			subject: QuotaLedgerSubjectUser,
+			change: func(tx *gorm.DB, delta int, ref QuotaLedgerRef) error {
+				return changeUserQuota(tx, 1, delta, ref)
+			},
+			delta:     -40,
+			wantAfter: 60,
+		},
+		{
+			name:    "token refund",
+			subject: QuotaLedgerSubjectToken,
+			change: func(tx *gorm.DB, delta int, ref QuotaLedgerRef) error {
+				return changeTokenQuota(tx, 1, delta, ref)
+			},
+			delta:     5,
+			wantAfter: 55,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupQuotaLedgerTest(t)
+			ref := QuotaLedgerRef{Type: QuotaLedgerTypeConsume}
+			require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+				if err := tt.change(tx, 0, ref); err != nil {
+					return err
+				}
+				return tt.change(tx, tt.delta, ref)
+			}))
+			ledgers := latestLedgers(t, db, tt.subject, 1)
+			require.Len(t, ledgers, 1, "zero delta must not write a ledger entry")
+			require.Equal(t, tt.wantAfter, ledgers[0].BalanceAfter)
+			require.Equal(t, tt.wantAfter-tt.delta, ledgers[0].BalanceBefore)
+		})
+	}
+}
+
+func TestTokenEditQuotaDelta(t *testing.T) {
+	loaded := 50
+	tests := []struct {
+		name       string
+		original   *int
+		submitted  int
+		wantRemain int
+		wantDelta  int
+	}{
+		{name: "delta from loaded value keeps consumption", original: &loaded, submitted: 80, wantRemain: 70, wantDelta: 30},
+		{name: "unchanged quota keeps consumption", original: &loaded, submitted: 50, wantRemain: 40},
+		{name: "without loaded value sets quota", submitted: 80, wantRemain: 80, wantDelta: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupQuotaLedgerTest(t)
+			token, err := GetTokenById(1)
+			require.NoError(t, err)
+			// 打开编辑后产生一次消费
+			require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+				return changeTokenQuota(tx, 1, -10, QuotaLedgerRef{Type: QuotaLedgerTypeConsume})
+			}))
+
+			token.Name = "renamed"
+			token.RemainQuota = tt.submitted
+			require.NoError(t, token.Edit(tt.original))
+			require.Equal(t, tt.wantRemain, token.RemainQuota)
+
+			var saved Token
+			require.NoError(t, db.First(&saved, 1).Error)
+			require.Equal(t, "renamed", saved.Name)
+			require.Equal(t, tt.wantRemain, saved.RemainQuota)
+			require.Equal(t, 20, saved.UsedQuota, "admin adjustments must not change used quota")
+
+			ledgers := latestLedgers(t, db, QuotaLedgerSubjectToken, 1)
+			if tt.wantDelta == 0 {
+				require.Len(t, ledgers, 1)
+				return
+			}
+			require.Len(t, ledgers, 2)
+			require.Equal(t, QuotaLedgerTypeAdmin, ledgers[1].EntryType)
+			require.Equal(t, tt.wantDelta, ledgers[1].Delta)
+			require.Equal(t, tt.wantRemain, ledgers[1].BalanceAfter)
+		})
+	}
+
+	t.Run("update does not write remain quota", func(t *testing.T) {
+		db := setupQuotaLedgerTest(t)
+		token, err := GetTokenById(1)
+		require.NoError(t, err)
+		require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+			return changeTokenQuota(tx, 1, -10, QuotaLedgerRef{Type: QuotaLedgerTypeConsume})
+		}))
+		token.Status = 2
+		require.NoError(t, token.Update())
+
+		var saved Token
+		require.NoError(t, db.First(&saved, 1).Error)
+		require.Equal(t, 2, saved.Status)
+		require.Equal(t, 40, saved.RemainQuota)
+		require.Len(t, latestLedgers(t, db, QuotaLedgerSubjectToken, 1), 1)
+	})
+}
+
+func TestTransferProjectQuota(t *testing.T) {
+	tests := []struct {
+		name        string
+		quota       int
+		wantErr     bool
+		wantOrg     int
+		wantProject int
+	}{
+		{name: "org to project", quota: 300, wantOrg: 700, wantProject: 500},
+		{name: "project back to org", quota: -200, wantOrg: 1200, wantProject: 0},
+		{name: "org balance insufficient", quota: 1001, wantErr: true, wantOrg: 1000, wantProject: 200},
+		{name: "project balance insufficient", quota: -201, wantErr: true, wantOrg: 1000, wantProject: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupQuotaLedgerTest(t)
+			err := TransferProjectQuota(&Project{Id: 2, OrgId: 1}, tt.quota)
+			require.Equal(t, tt.wantErr, err != nil, "err: %v", err)
+
+			org, err := GetQuotaLedgerColumnValue(QuotaLedgerSubjectOrg, 1)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOrg, org)
+			project, err := GetQuotaLedgerColumnValue(QuotaLedgerSubjectProject, 2)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantProject, project)
+
+			orgLedgers := latestLedgers(t, db, QuotaLedgerSubjectOrg, 1)
+			projectLedgers := latestLedgers(t, db, QuotaLedgerSubjectProject, 2)
+			if tt.wantErr {
+				require.Empty(t, orgLedgers)
+				require.Empty(t, projectLedgers)
+				return
+			}
+			require.Len(t, orgLedgers, 1)
+			require.Len(t, projectLedgers, 1)
+			require.Equal(t, -tt.quota, orgLedgers[0].Delta)
+			require.Equal(t, tt.quota, projectLedgers[0].Delta)
+			require.Equal(t, QuotaLedgerTypeTransfer, orgLedgers[0].EntryType)
+		})
+	}
+}
+
+func TestChangeProjectQuotaLedger(t *testing.T) {
+	tests := []struct {
+		name          string
+		projectId     int
+		quota         int
+		wantSubject   string
+		wantSubjectId int
+		wantBalance   int
+	}{
+		{name: "org quota project", projectId: 1, quota: 40, wantSubject: QuotaLedgerSubjectOrg, wantSubjectId: 1, wantBalance: 960},
+		{name: "own quota project", projectId: 2, quota: 40, wantSubject: QuotaLedgerSubjectProject, wantSubjectId: 2, wantBalance: 160},
+		{name: "refund to own quota project", projectId: 2, quota: -40, wantSubject: QuotaLedgerSubjectProject, wantSubjectId: 2, wantBalance: 240},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupQuotaLedgerTest(t)
+			require.NoError(t, changeProjectQuota(tt.projectId, 1, tt.quota, QuotaLedgerRef{Type: QuotaLedgerTypeConsume}))
+
+			ledgers := latestLedgers(t, db, tt.wantSubject, tt.wantSubjectId)
+			require.Len(t, ledgers, 1)
+			require.Equal(t, -tt.quota, ledgers[0].Delta)
+			require.Equal(t, tt.wantBalance, ledgers[0].BalanceAfter)
+
+			memberLedgers := latestLedgers(t, db, QuotaLedgerSubjectOrgMember, 1)
+			require.Len(t, memberLedgers, 1)
+			require.Equal(t, tt.quota, memberLedgers[0].Delta)
+			require.Equal(t, tt.quota, memberLedgers[0].BalanceAfter)
+		})
+	}
+}
@@ -137,7 +137,7 @@ func Redeem(key string, userId int) (quota int, err error) {
 		if redemption.ExpiredTime != 0 && redemption.ExpiredTime < common.GetTimestamp() {
 			return errors.New("该兑换码已过期")
 		}
-		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+		err = changeUserQuota(tx, userId, redemption.Quota, QuotaLedgerRef{Type: QuotaLedgerTypeRedeem, ReferenceId: strconv.Itoa(redemption.Id)})
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm/clause"
+)
+
+// ResponseCache 数据库存储的响应缓存，未启用 Redis 时使用
+type ResponseCache struct {
+	Id        int    `json:"id"`
+	CacheKey  string `json:"cache_key" gorm:"type:varchar(64);uniqueIndex"`
+	Scope     string `json:"scope" gorm:"type:varchar(64);index"` // 语义匹配的作用域，相同作用域内的请求参数一致
+	Data      string `json:"data" gorm:"type:text"`               // 序列化后的缓存条目
+	Semantic  bool   `json:"semantic"`                            // 是否带有向量，可参与语义匹配
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func GetResponseCache(cacheKey string) (*ResponseCache, error) {
+	var cache ResponseCache
+	err := DB.Where("cache_key = ? AND expires_at > ?", cacheKey, common.GetTimestamp()).First(&cache).Error
+	if err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
+
+// SaveResponseCache 写入缓存，相同 key 时覆盖
+func SaveResponseCache(cache *ResponseCache) error {
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cache_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scope", "data", "semantic", "created_at", "expires_at"}),
+	}).Create(cache).Error
+}
+
+// GetSemanticResponseCaches 获取作用域内最近的可语义匹配的缓存
+func GetSemanticResponseCaches(scope string, limit int) (caches []*ResponseCache, err error) {
+	err = DB.Where("scope = ? AND semantic = ? AND expires_at > ?", scope, true, common.GetTimestamp()).
+		Order("created_at desc").Limit(limit).Find(&caches).Error
+	return caches, err
+}
+
+func DeleteExpiredResponseCaches(now int64) (int64, error) {
+	result := DB.Where("expires_at <= ?", now).Delete(&ResponseCache{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,190 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+const secretMigrationBatchSize = 200
+
+// MigrateSecrets 加密数据库中仍为明文的密钥，并将旧主密钥加密的数据用当前主密钥重新封装
+// 轮换主密钥时：将新密钥设置为 SECRET_ENCRYPTION_KEY，旧密钥加入 SECRET_ENCRYPTION_OLD_KEYS 后执行
+func MigrateSecrets() error {
+	if !common.SecretEncryptionEnabled() {
+		return errors.New("SECRET_ENCRYPTION_KEY is not configured")
+	}
+	migrations := []struct {
+		name string
+		fn   func() (int, error)
+	}{
+		{"channels", migrateChannelSecrets},
+		{"options", migrateOptionSecrets},
+		{"users", migrateUserSecrets},
+		{"tasks", migrateTaskSecrets},
+	}
+	for _, migration := range migrations {
+		count, err := migration.fn()
+		if err != nil {
+			return fmt.Errorf("failed to migrate secrets of %s: %w", migration.name, err)
+		}
+		common.SysLog(fmt.Sprintf("migrated %d secrets in %s", count, migration.name))
+	}
+	return nil
+}
+
+// 以下直接读写原始列，绕过模型上的加解密钩子
+func migrateChannelSecrets() (int, error) {
+	type channelRow struct {
+		Id  int
+		Key string
+	}
+	count := 0
+	var rows []channelRow
+	result := DB.Table("channels").Select("id", "key").FindInBatches(&rows, secretMigrationBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			if !common.SecretNeedsMigration(row.Key) {
+				continue
+			}
+			key, err := common.RewrapSecret(row.Key)
+			if err != nil {
+				return fmt.Errorf("channel #%d: %w", row.Id, err)
+			}
+			if err := DB.Table("channels").Where("id = ?", row.Id).UpdateColumn("key", key).Error; err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, result.Error
+}
+
+// backfillChannelKeyHashes 为旧渠道补齐密钥摘要，使按密钥搜索在加密存储下可用
+func backfillChannelKeyHashes() error {
+	type channelRow struct {
+		Id  int
+		Key string
+	}
+	var rows []channelRow
+	return DB.Table("channels").Select("id", "key").Where("key_hash IS NULL OR key_hash = ''").FindInBatches(&rows, secretMigrationBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			key, err := common.DecryptSecret(row.Key)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to backfill key hash of channel #%d: %s", row.Id, err.Error()))
+				continue
+			}
+			if key == "" {
+				continue
+			}
+			if err := DB.Table("channels").Where("id = ?", row.Id).UpdateColumn("key_hash", common.SecretLookupHash(key)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func migrateOptionSecrets() (int, error) {
+	type optionRow struct {
+		Key   string
+		Value string
+	}
+	var options []optionRow
+	if err := DB.Table("options").Find(&options).Error; err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, option := range options {
+		if !IsSecretOption(option.Key) || !common.SecretNeedsMigration(option.Value) {
+			continue
+		}
+		value, err := common.RewrapSecret(option.Value)
+		if err != nil {
+			return count, fmt.Errorf("option %s: %w", option.Key, err)
+		}
+		if err := DB.Table("options").Where(commonKeyCol+" = ?", option.Key).UpdateColumn("value", value).Error; err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func migrateUserSecrets() (int, error) {
+	type userRow struct {
+		Id      int
+		Setting string
+	}
+	count := 0
+	var rows []userRow
+	result := DB.Table("users").Select("id", "setting").Where("setting <> ''").FindInBatches(&rows, secretMigrationBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			setting := map[string]interface{}{}
+			if err := common.Unmarshal([]byte(row.Setting), &setting); err != nil {
+				continue
+			}
+			changed := false
+			for _, field := range []string{"webhook_secret", "task_callback_secret"} {
+				secret, _ := setting[field].(string)
+				if !common.SecretNeedsMigration(secret) {
+					continue
+				}
+				encrypted, err := common.RewrapSecret(secret)
+				if err != nil {
+					return fmt.Errorf("user #%d: %w", row.Id, err)
+				}
+				setting[field] = encrypted
+				changed = true
+			}
+			if !changed {
+				continue
+			}
+			settingBytes, err := common.Marshal(setting)
+			if err != nil {
+				return err
+			}
+			if err := DB.Table("users").Where("id = ?", row.Id).UpdateColumn("setting", string(settingBytes)).Error; err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, result.Error
+}
+
+func migrateTaskSecrets() (int, error) {
+	type taskRow struct {
+		Id          int64
+		PrivateData string
+	}
+	count := 0
+	var rows []taskRow
+	result := DB.Table("tasks").Select("id", "private_data").Where("private_data IS NOT NULL").FindInBatches(&rows, secretMigrationBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			var privateData TaskPrivateData
+			if err := common.Unmarshal([]byte(row.PrivateData), &privateData); err != nil {
+				continue
+			}
+			if !common.SecretNeedsMigration(privateData.Key) {
+				continue
+			}
+			key, err := common.RewrapSecret(privateData.Key)
+			if err != nil {
+				return fmt.Errorf("task #%d: %w", row.Id, err)
+			}
+			// TaskPrivateData.Value 不会重复加密已加密的值
+			privateData.Key = key
+			if err := DB.Table("tasks").Where("id = ?", row.Id).UpdateColumn("private_data", privateData).Error; err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, result.Error
+}
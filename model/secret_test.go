@@ -0,0 +1,126 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/stretchr/testify/require"
+)
+
+func enableSecretEncryption(t *testing.T, key string) {
+	t.Setenv("SECRET_ENCRYPTION_KEY", key)
+	t.Setenv("SECRET_ENCRYPTION_KEY_FILE", "")
+	t.Setenv("SECRET_ENCRYPTION_OLD_KEYS", "")
+	require.NoError(t, common.InitSecretEncryption())
+	t.Cleanup(func() {
+		t.Setenv("SECRET_ENCRYPTION_KEY", "")
+		_ = common.InitSecretEncryption()
+	})
+}
+
+func rawChannelColumns(t *testing.T, id int) (key string, keyHash string) {
+	row := struct {
+		Key     string
+		KeyHash string
+	}{}
+	require.NoError(t, DB.Table("channels").Select("key", "key_hash").Where("id = ?", id).Scan(&row).Error)
+	return row.Key, row.KeyHash
+}
+
+func TestChannelKeyEncryptionAndSearch(t *testing.T) {
+	tests := []struct {
+		name          string
+		encryptionKey string
+		key           string
+		search        string
+		wantFound     bool
+	}{
+		{name: "plaintext storage", key: "sk-plain", search: "sk-plain", wantFound: true},
+		{name: "encrypted storage", encryptionKey: "master", key: "sk-secret", search: "sk-secret", wantFound: true},
+		{name: "partial key does not match hash", encryptionKey: "master", key: "sk-secret", search: "sk-sec", wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t, &Channel{})
+			if tt.encryptionKey != "" {
+				enableSecretEncryption(t, tt.encryptionKey)
+			}
+			channel := &Channel{Name: "test", Key: tt.key, Models: "gpt-4o"}
+			require.NoError(t, DB.Create(channel).Error)
+			require.Equal(t, tt.key, channel.Key, "caller keeps the plaintext after save")
+
+			rawKey, keyHash := rawChannelColumns(t, channel.Id)
+			require.Equal(t, common.SecretLookupHash(tt.key), keyHash)
+			require.Equal(t, tt.encryptionKey != "", common.IsEncryptedSecret(rawKey))
+
+			loaded, err := GetChannelById(channel.Id, true)
+			require.NoError(t, err)
+			require.Equal(t, tt.key, loaded.Key)
+
+			found, err := SearchChannels(tt.search, "", "", false)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantFound, len(found) == 1)
+		})
+	}
+}
+
+func TestChannelKeyHashOnMapUpdate(t *testing.T) {
+	setupTestDB(t, &Channel{})
+	enableSecretEncryption(t, "master")
+	channel := &Channel{Name: "test", Key: "sk-old"}
+	require.NoError(t, DB.Create(channel).Error)
+
+	require.NoError(t, DB.Model(&Channel{}).Where("id = ?", channel.Id).Updates(map[string]interface{}{"key": "sk-new"}).Error)
+	rawKey, keyHash := rawChannelColumns(t, channel.Id)
+	require.True(t, common.IsEncryptedSecret(rawKey))
+	require.Equal(t, common.SecretLookupHash("sk-new"), keyHash)
+}
+
+func TestBackfillChannelKeyHashes(t *testing.T) {
+	setupTestDB(t, &Channel{})
+	enableSecretEncryption(t, "master")
+	encrypted, err := common.EncryptSecret("sk-encrypted")
+	require.NoError(t, err)
+
+	rows := []struct {
+		id      int
+		key     string
+		keyHash any
+		want    string
+	}{
+		{id: 1, key: "sk-plain", keyHash: nil, want: common.SecretLookupHash("sk-plain")},
+		{id: 2, key: encrypted, keyHash: "", want: common.SecretLookupHash("sk-encrypted")},
+		{id: 3, key: "sk-kept", keyHash: "existing", want: "existing"},
+		{id: 4, key: "enc:v1:unknown:AAAA:AAAA", keyHash: "", want: ""},
+	}
+	// 绕过模型钩子，模拟旧版本写入的数据
+	for _, row := range rows {
+		require.NoError(t, DB.Exec("INSERT INTO channels (id, name, `key`, key_hash) VALUES (?, ?, ?, ?)", row.id, "legacy", row.key, row.keyHash).Error)
+	}
+	require.NoError(t, backfillChannelKeyHashes())
+	for _, row := range rows {
+		_, keyHash := rawChannelColumns(t, row.id)
+		require.Equal(t, row.want, keyHash, "channel #%d", row.id)
+	}
+}
+
+func TestMigrateChannelSecrets(t *testing.T) {
+	setupTestDB(t, &Channel{})
+	require.NoError(t, DB.Exec("INSERT INTO channels (id, name, `key`) VALUES (1, 'legacy', 'sk-legacy')").Error)
+	enableSecretEncryption(t, "master")
+
+	count, err := migrateChannelSecrets()
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	rawKey, _ := rawChannelColumns(t, 1)
+	require.True(t, common.IsEncryptedSecret(rawKey))
+
+	loaded, err := GetChannelById(1, true)
+	require.NoError(t, err)
+	require.Equal(t, "sk-legacy", loaded.Key)
+
+	count, err = migrateChannelSecrets()
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
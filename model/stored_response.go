@@ -0,0 +1,35 @@
+package model
+
+// StoredResponse 网关保存的 /v1/responses 响应，用于经 chat/completions 转发的渠道支持 previous_response_id
+type StoredResponse struct {
+	Id        string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Model     string `json:"model" gorm:"default:''"`
+	Messages  string `json:"messages" gorm:"type:json"` // 截至本次响应的完整对话（chat 消息格式，不含 instructions），长对话会超过 MySQL text 的 64KB 上限
+	Response  string `json:"response" gorm:"type:json"` // 返回给客户端的响应
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+	ExpiresAt int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func (response *StoredResponse) Insert() error {
+	return DB.Create(response).Error
+}
+
+func GetStoredResponse(id string, userId int, now int64) (*StoredResponse, error) {
+	var response StoredResponse
+	err := DB.Where("id = ? AND user_id = ? AND expires_at > ?", id, userId, now).First(&response).Error
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func DeleteStoredResponse(id string, userId int) (int64, error) {
+	result := DB.Where("id = ? AND user_id = ?", id, userId).Delete(&StoredResponse{})
+	return result.RowsAffected, result.Error
+}
+
+func DeleteExpiredStoredResponses(now int64) (int64, error) {
+	result := DB.Where("expires_at <= ?", now).Delete(&StoredResponse{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,484 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionStatusPending = "pending" // 已发起 Stripe 支付，等待确认
+	SubscriptionStatusActive  = "active"
+	SubscriptionStatusExpired = "expired"
+)
+
+// SubscriptionPlan 订阅套餐，每个计费周期发放 Quota 额度，优先于余额扣除
+type SubscriptionPlan struct {
+	Id            int     `json:"id"`
+	Name          string  `json:"name" gorm:"type:varchar(64)"`
+	Description   string  `json:"description" gorm:"type:text"`
+	Price         float64 `json:"price"` // 展示价格，实际扣款以 Stripe 价格为准
+	Currency      string  `json:"currency" gorm:"type:varchar(8)"`
+	StripePriceId string  `json:"stripe_price_id" gorm:"type:varchar(128)"` // Stripe 周期性价格 ID，为空时只能由管理员发放
+	Quota         int     `json:"quota"`                                    // 每个计费周期包含的额度
+	UpgradeGroup  string  `json:"upgrade_group" gorm:"type:varchar(64)"`    // 订阅期间用户所在分组，为空时不修改
+	OverageRatio  float64 `json:"overage_ratio" gorm:"default:1"`           // 套餐额度用完后叠加到分组倍率上的系数
+	Enabled       bool    `json:"enabled"`
+	CreatedAt     int64   `json:"created_at" gorm:"bigint"`
+	UpdatedAt     int64   `json:"updated_at" gorm:"bigint"`
+}
+
+// Subscription 用户订阅，Quota、UpgradeGroup、OverageRatio 为生效时的套餐快照，调整套餐不影响当前周期
+type Subscription struct {
+	Id                   int     `json:"id"`
+	UserId               int     `json:"user_id" gorm:"index"`
+	PlanId               int     `json:"plan_id" gorm:"index"`
+	Status               string  `json:"status" gorm:"type:varchar(16);index"`
+	Reference            string  `json:"-" gorm:"type:varchar(64);index"`
+	StripeSubscriptionId string  `json:"stripe_subscription_id" gorm:"type:varchar(128);index"`
+	Quota                int     `json:"quota"`
+	UsedQuota            int     `json:"used_quota" gorm:"default:0"`
+	UpgradeGroup         string  `json:"upgrade_group" gorm:"type:varchar(64)"`
+	PreviousGroup        string  `json:"previous_group" gorm:"type:varchar(64)"`
+	OverageRatio         float64 `json:"overage_ratio" gorm:"default:1"`
+	CurrentPeriodStart   int64   `json:"current_period_start" gorm:"bigint"`
+	CurrentPeriodEnd     int64   `json:"current_period_end" gorm:"bigint;index"`
+	ExpiresAt            int64   `json:"expires_at" gorm:"bigint"` // 管理员发放的订阅到期时间，Stripe 订阅为 0
+	CancelAtPeriodEnd    bool    `json:"cancel_at_period_end"`
+	CreatedAt            int64   `json:"created_at" gorm:"bigint"`
+	UpdatedAt            int64   `json:"updated_at" gorm:"bigint"`
+}
+
+// 生效订阅的进程内缓存，扣费路径每次请求会读取多次；其他节点的变更最多延迟 activeSubscriptionCacheSeconds 秒，
+// 扣减套餐额度使用条件更新，缓存过期不会导致超扣
+const activeSubscriptionCacheSeconds = 10
+
+type activeSubscriptionEntry struct {
+	sub      *Subscription // nil 表示没有生效订阅
+	expireAt int64
+}
+
+var activeSubscriptionCache sync.Map // userId -> activeSubscriptionEntry
+
+type SubscriptionFilter struct {
+	UserId int
+	PlanId int
+	Status string
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	now := common.GetTimestamp()
+	plan.CreatedAt = now
+	plan.UpdatedAt = now
+	return DB.Create(plan).Error
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	plan.UpdatedAt = common.GetTimestamp()
+	return DB.Save(plan).Error
+}
+
+func DeleteSubscriptionPlanById(id int) error {
+	return DB.Delete(&SubscriptionPlan{}, id).Error
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	plan := &SubscriptionPlan{}
+	err := DB.First(plan, "id = ?", id).Error
+	return plan, err
+}
+
+func GetSubscriptionPlans(enabledOnly bool) (plans []*SubscriptionPlan, err error) {
+	tx := DB.Model(&SubscriptionPlan{})
+	if enabledOnly {
+		tx = tx.Where("enabled = ?", true)
+	}
+	err = tx.Order("id").Find(&plans).Error
+	return plans, err
+}
+
+func (sub *Subscription) Insert() error {
+	now := common.GetTimestamp()
+	sub.CreatedAt = now
+	sub.UpdatedAt = now
+	return DB.Create(sub).Error
+}
+
+// Update 保存订阅，已用额度只通过流水相关函数变更，不随整行保存
+func (sub *Subscription) Update() error {
+	sub.UpdatedAt = common.GetTimestamp()
+	defer invalidateActiveSubscriptionCache(sub.UserId)
+	return DB.Omit("used_quota").Save(sub).Error
+}
+
+func (sub *Subscription) RemainQuota() int {
+	if sub.UsedQuota >= sub.Quota {
+		return 0
+	}
+	return sub.Quota - sub.UsedQuota
+}
+
+func GetSubscriptionById(id int) (*Subscription, error) {
+	sub := &Subscription{}
+	err := DB.First(sub, "id = ?", id).Error
+	return sub, err
+}
+
+func GetSubscriptionByReference(reference string) (*Subscription, error) {
+	sub := &Subscription{}
+	err := DB.Where("reference = ?", reference).First(sub).Error
+	return sub, err
+}
+
+func GetSubscriptionByStripeId(stripeSubscriptionId string) (*Subscription, error) {
+	sub := &Subscription{}
+	err := DB.Where("stripe_subscription_id = ?", stripeSubscriptionId).First(sub).Error
+	return sub, err
+}
+
+// GetActiveSubscription 获取用户当前生效的订阅，没有时返回 nil；扣费路径每次调用，使用 Find 避免记录不存在日志
+func GetActiveSubscription(userId int) (*Subscription, error) {
+	var subs []*Subscription
+	err := DB.Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).Order("id desc").Limit(1).Find(&subs).Error
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return subs[0], nil
+}
+
+func GetSubscriptions(filter SubscriptionFilter, startIdx int, num int) (subs []*Subscription, total int64, err error) {
+	tx := DB.Model(&Subscription{})
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.PlanId != 0 {
+		tx = tx.Where("plan_id = ?", filter.PlanId)
+	}
+	if filter.Status != "" {
+		tx = tx.Where("status = ?", filter.Status)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&subs).Error
+	return subs, total, err
+}
+
+// GetDueSubscriptions 获取当前周期已结束的生效订阅
+func GetDueSubscriptions(now int64, limit int) (subs []*Subscription, err error) {
+	err = DB.Where("status = ? AND current_period_end <= ?", SubscriptionStatusActive, now).Limit(limit).Find(&subs).Error
+	return subs, err
+}
+
+// getCachedActiveSubscription 扣费路径读取生效订阅，返回缓存快照的副本
+func getCachedActiveSubscription(userId int) *Subscription {
+	now := common.GetTimestamp()
+	if value, ok := activeSubscriptionCache.Load(userId); ok {
+		entry := value.(activeSubscriptionEntry)
+		if entry.expireAt > now {
+			if entry.sub == nil {
+				return nil
+			}
+			sub := *entry.sub
+			return &sub
+		}
+	}
+	sub, err := GetActiveSubscription(userId)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to get active subscription of user %d: %s", userId, err.Error()))
+		return nil
+	}
+	activeSubscriptionCache.Store(userId, activeSubscriptionEntry{sub: sub, expireAt: now + activeSubscriptionCacheSeconds})
+	if sub == nil {
+		return nil
+	}
+	copied := *sub
+	return &copied
+}
+
+// updateCachedSubscriptionUsedQuota 本节点扣减或退还套餐额度后同步缓存中的已用额度
+func updateCachedSubscriptionUsedQuota(userId int, subId int, delta int) {
+	value, ok := activeSubscriptionCache.Load(userId)
+	if !ok {
+		return
+	}
+	entry := value.(activeSubscriptionEntry)
+	if entry.sub == nil || entry.sub.Id != subId {
+		return
+	}
+	sub := *entry.sub
+	sub.UsedQuota += delta
+	activeSubscriptionCache.Store(userId, activeSubscriptionEntry{sub: &sub, expireAt: entry.expireAt})
+}
+
+func invalidateActiveSubscriptionCache(userId int) {
+	activeSubscriptionCache.Delete(userId)
+}
+
+// GetSubscriptionRemainQuota 获取用户当前周期剩余的套餐额度
+func GetSubscriptionRemainQuota(userId int) int {
+	sub := getCachedActiveSubscription(userId)
+	if sub == nil {
+		return 0
+	}
+	return sub.RemainQuota()
+}
+
+// GetSubscriptionOverageRatio 套餐额度用完后的倍率系数，未订阅或仍有套餐额度时为 1
+func GetSubscriptionOverageRatio(userId int) float64 {
+	sub := getCachedActiveSubscription(userId)
+	if sub == nil || sub.RemainQuota() > 0 || sub.OverageRatio <= 0 {
+		return 1
+	}
+	return sub.OverageRatio
+}
+
+// consumeSubscriptionQuota 优先扣除套餐额度，返回从套餐额度扣除的部分
+func consumeSubscriptionQuota(userId int, quota int, ref QuotaLedgerRef) int {
+	sub := getCachedActiveSubscription(userId)
+	if sub == nil {
+		return 0
+	}
+	take := min(quota, sub.RemainQuota())
+	if take <= 0 {
+		return 0
+	}
+	// 并发扣除或缓存过期导致套餐额度不足时，本次全部从余额扣除
+	if !changeSubscriptionUsedQuota(sub, take, ref) {
+		return 0
+	}
+	return take
+}
+
+// refundSubscriptionQuota 补回本周期已用的套餐额度，返回实际补回的部分；期间已进入新周期时补回不足的部分由调用方退回余额
+func refundSubscriptionQuota(userId int, quota int, ref QuotaLedgerRef) int {
+	if quota <= 0 {
+		return 0
+	}
+	sub := getCachedActiveSubscription(userId)
+	if sub == nil {
+		return 0
+	}
+	take := min(quota, sub.UsedQuota)
+	if take <= 0 {
+		return 0
+	}
+	if !changeSubscriptionUsedQuota(sub, -take, ref) {
+		return 0
+	}
+	return take
+}
+
+var errSubscriptionQuotaConflict = errors.New("subscription quota changed concurrently")
+
+// changeSubscriptionUsedQuota 条件更新已用额度并写入流水，已用额度超出套餐额度或小于 0 时不更新并返回 false
+func changeSubscriptionUsedQuota(sub *Subscription, delta int, ref QuotaLedgerRef) bool {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&Subscription{}).Where("id = ?", sub.Id)
+		if delta > 0 {
+			query = query.Where("used_quota + ? <= quota", delta)
+		} else {
+			query = query.Where("used_quota >= ?", -delta)
+		}
+		result := query.Update("used_quota", gorm.Expr("used_quota + ?", delta))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errSubscriptionQuotaConflict
+		}
+		after, err := getQuotaLedgerColumnValue(tx, QuotaLedgerSubjectSubscription, sub.Id)
+		if err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, QuotaLedgerSubjectSubscription, sub.Id, sub.UserId, delta, after, ref)
+	})
+	if err != nil {
+		if !errors.Is(err, errSubscriptionQuotaConflict) {
+			common.SysLog(fmt.Sprintf("failed to change subscription #%d used quota: %s", sub.Id, err.Error()))
+		}
+		invalidateActiveSubscriptionCache(sub.UserId)
+		return false
+	}
+	updateCachedSubscriptionUsedQuota(sub.UserId, sub.Id, delta)
+	return true
+}
+
+func setUserGroup(tx *gorm.DB, userId int, group string) error {
+	if err := tx.Model(&User{}).Where("id = ?", userId).Update("group", group).Error; err != nil {
+		return err
+	}
+	gopool.Go(func() {
+		if err := updateUserGroupCache(userId, group); err != nil {
+			common.SysLog("failed to update user group cache: " + err.Error())
+		}
+	})
+	return nil
+}
+
+// SubscriptionPayment 订阅周期的实际付款，金额与币种取自 Stripe 的支付事件
+type SubscriptionPayment struct {
+	Amount   float64
+	Currency string
+}
+
+// resetSubscriptionUsedQuota 进入新周期时清零已用额度并写入流水
+func resetSubscriptionUsedQuota(tx *gorm.DB, sub *Subscription) error {
+	ref := QuotaLedgerRef{Type: QuotaLedgerTypeNewPeriod, ReferenceId: SubscriptionInvoiceReference(sub)}
+	return setQuotaLedgerColumn(tx, QuotaLedgerSubjectSubscription, sub.Id, sub.UserId, 0, ref)
+}
+
+// ActivateSubscription 使订阅生效并发放首个周期额度，用户已有的生效订阅会被替换，原分组沿用最早的记录；payment 为空时不开具发票
+func ActivateSubscription(sub *Subscription, plan *SubscriptionPlan, periodStart int64, periodEnd int64, payment *SubscriptionPayment) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Select("id", "group").Where("id = ?", sub.UserId).First(&user).Error; err != nil {
+			return err
+		}
+		previousGroup := user.Group
+		var actives []*Subscription
+		if err := tx.Where("user_id = ? AND status = ? AND id <> ?", sub.UserId, SubscriptionStatusActive, sub.Id).Find(&actives).Error; err != nil {
+			return err
+		}
+		for _, active := range actives {
+			if active.UpgradeGroup != "" && active.UpgradeGroup == user.Group {
+				previousGroup = active.PreviousGroup
+			}
+			active.Status = SubscriptionStatusExpired
+			active.UpdatedAt = common.GetTimestamp()
+			if err := tx.Omit("used_quota").Save(active).Error; err != nil {
+				return err
+			}
+		}
+
+		sub.PlanId = plan.Id
+		sub.Status = SubscriptionStatusActive
+		sub.Quota = plan.Quota
+		sub.UsedQuota = 0
+		sub.UpgradeGroup = plan.UpgradeGroup
+		sub.OverageRatio = plan.OverageRatio
+		sub.PreviousGroup = previousGroup
+		sub.CurrentPeriodStart = periodStart
+		sub.CurrentPeriodEnd = periodEnd
+		sub.UpdatedAt = common.GetTimestamp()
+		if sub.CreatedAt == 0 {
+			sub.CreatedAt = sub.UpdatedAt
+		}
+		if err := tx.Omit("used_quota").Save(sub).Error; err != nil {
+			return err
+		}
+		if err := resetSubscriptionUsedQuota(tx, sub); err != nil {
+			return err
+		}
+		if err := enqueueSubscriptionInvoice(tx, sub, plan.Name, payment); err != nil {
+			return err
+		}
+		if plan.UpgradeGroup != "" && plan.UpgradeGroup != user.Group {
+			return setUserGroup(tx, sub.UserId, plan.UpgradeGroup)
+		}
+		return nil
+	})
+	invalidateActiveSubscriptionCache(sub.UserId)
+	if err != nil {
+		return err
+	}
+	issuePendingInvoiceAfterCommit(InvoiceSourceSubscription, SubscriptionInvoiceReference(sub))
+	RecordLog(sub.UserId, LogTypeTopup, fmt.Sprintf("订阅套餐 %s 已生效，本周期额度 %s", plan.Name, logger.FormatQuota(plan.Quota)))
+	return nil
+}
+
+// ErrSubscriptionPeriodNotAdvanced 订阅已处于该周期或更晚的周期，重复的续费通知不再重置额度
+var ErrSubscriptionPeriodNotAdvanced = errors.New("subscription period not advanced")
+
+// RenewSubscriptionPeriod 进入新的计费周期，重置已用额度并按当前套餐重新发放；payment 为空时不开具发票
+// 只在 periodStart 晚于数据库中的当前周期时生效，重复或并发到达的续费通知返回 ErrSubscriptionPeriodNotAdvanced
+func RenewSubscriptionPeriod(sub *Subscription, periodStart int64, periodEnd int64, payment *SubscriptionPayment) error {
+	// 套餐已删除时沿用原额度
+	planName := fmt.Sprintf("#%d", sub.PlanId)
+	quota := sub.Quota
+	plan, err := GetSubscriptionPlanById(sub.PlanId)
+	if err == nil {
+		quota = plan.Quota
+		planName = plan.Name
+	}
+	updatedAt := common.GetTimestamp()
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Subscription{}).
+			Where("id = ? AND current_period_start < ?", sub.Id, periodStart).
+			Updates(map[string]interface{}{
+				"quota":                quota,
+				"current_period_start": periodStart,
+				"current_period_end":   periodEnd,
+				"updated_at":           updatedAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSubscriptionPeriodNotAdvanced
+		}
+		sub.Quota = quota
+		sub.UsedQuota = 0
+		sub.CurrentPeriodStart = periodStart
+		sub.CurrentPeriodEnd = periodEnd
+		sub.UpdatedAt = updatedAt
+		if err := resetSubscriptionUsedQuota(tx, sub); err != nil {
+			return err
+		}
+		return enqueueSubscriptionInvoice(tx, sub, planName, payment)
+	})
+	invalidateActiveSubscriptionCache(sub.UserId)
+	if err != nil {
+		return err
+	}
+	issuePendingInvoiceAfterCommit(InvoiceSourceSubscription, SubscriptionInvoiceReference(sub))
+	RecordLog(sub.UserId, LogTypeTopup, fmt.Sprintf("订阅套餐进入新周期，发放额度 %s", logger.FormatQuota(quota)))
+	return nil
+}
+
+// ExpireSubscription 订阅失效，用户仍处于套餐分组时恢复原分组
+func ExpireSubscription(sub *Subscription) error {
+	if sub.Status == SubscriptionStatusExpired {
+		return nil
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		sub.Status = SubscriptionStatusExpired
+		sub.UpdatedAt = common.GetTimestamp()
+		if err := tx.Omit("used_quota").Save(sub).Error; err != nil {
+			return err
+		}
+		if sub.UpgradeGroup == "" || sub.PreviousGroup == "" {
+			return nil
+		}
+		var user User
+		if err := tx.Select("id", "group").Where("id = ?", sub.UserId).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if user.Group != sub.UpgradeGroup {
+			return nil
+		}
+		return setUserGroup(tx, sub.UserId, sub.PreviousGroup)
+	})
+	invalidateActiveSubscriptionCache(sub.UserId)
+	if err != nil {
+		return err
+	}
+	RecordLog(sub.UserId, LogTypeSystem, "订阅套餐已失效")
+	return nil
+}
+
+func UpdateUserStripeCustomer(userId int, customerId string) error {
+	if customerId == "" {
+		return nil
+	}
+	return DB.Model(&User{}).Where("id = ?", userId).Update("stripe_customer", customerId).Error
+}
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupSubscriptionTest 创建用户 1（余额 1000）与生效订阅 1（套餐额度 100，当前周期从 100 开始）
+func setupSubscriptionTest(t *testing.T) *gorm.DB {
+	db := setupTestDB(t, &User{}, &Subscription{}, &SubscriptionPlan{}, &QuotaLedger{}, &Log{},
+		&Invoice{}, &InvoiceSequence{}, &PendingInvoice{})
+	current := operation_setting.GetInvoiceSetting()
+	original := *current
+	t.Cleanup(func() {
+		*current = original
+		invalidateActiveSubscriptionCache(1)
+	})
+	*current = operation_setting.InvoiceSetting{Enabled: true, NumberPrefix: "INV-", Currency: "USD"}
+	invalidateActiveSubscriptionCache(1)
+
+	require.NoError(t, db.Create(&User{Id: 1, Username: "u1", Quota: 1000}).Error)
+	require.NoError(t, db.Create(&SubscriptionPlan{Id: 1, Name: "pro", Quota: 100, Enabled: true}).Error)
+	require.NoError(t, db.Create(&Subscription{
+		Id:                 1,
+		UserId:             1,
+		PlanId:             1,
+		Status:             SubscriptionStatusActive,
+		Quota:              100,
+		CurrentPeriodStart: 100,
+		CurrentPeriodEnd:   200,
+	}).Error)
+	return db
+}
+
+func getSubscriptionUsedQuota(t *testing.T, db *gorm.DB) int {
+	var sub Subscription
+	require.NoError(t, db.First(&sub, 1).Error)
+	return sub.UsedQuota
+}
+
+func TestChangeSubscriptionUsedQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		delta    int
+		wantOk   bool
+		wantUsed int
+	}{
+		{name: "consume up to the quota", delta: 10, wantOk: true, wantUsed: 100},
+		{name: "consume beyond the quota", delta: 11, wantUsed: 90},
+		{name: "refund used quota", delta: -90, wantOk: true, wantUsed: 0},
+		{name: "refund more than used", delta: -91, wantUsed: 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupSubscriptionTest(t)
+			require.NoError(t, db.Model(&Subscription{}).Where("id = ?", 1).Update("used_quota", 90).Error)
+			sub, err := GetSubscriptionById(1)
+			require.NoError(t, err)
+
+			ok := changeSubscriptionUsedQuota(sub, tt.delta, QuotaLedgerRef{Type: QuotaLedgerTypeConsume})
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.wantUsed, getSubscriptionUsedQuota(t, db))
+			var ledgers int64
+			require.NoError(t, db.Model(&QuotaLedger{}).Where("subject = ?", QuotaLedgerSubjectSubscription).Count(&ledgers).Error)
+			require.Equal(t, tt.wantOk, ledgers == 1)
+		})
+	}
+}
+
+func TestBillingQuotaRefundToChargedSource(t *testing.T) {
+	tests := []struct {
+		name           string
+		charge         int
+		refund         int
+		renewBefore    bool
+		wantCharge     types.BillingCharge
+		wantUserQuota  int
+		wantUsedQuota  int
+		wantChargeLeft types.BillingCharge
+	}{
+		{
+			name:           "refund balance part first",
+			charge:         150,
+			refund:         30,
+			wantCharge:     types.BillingCharge{Subscription: 100, Balance: 50},
+			wantUserQuota:  980,
+			wantUsedQuota:  100,
+			wantChargeLeft: types.BillingCharge{Subscription: 100, Balance: 20},
+		},
+		{
+			name:           "refund spills into subscription quota",
+			charge:         150,
+			refund:         120,
+			wantCharge:     types.BillingCharge{Subscription: 100, Balance: 50},
+			wantUserQuota:  1000,
+			wantUsedQuota:  30,
+			wantChargeLeft: types.BillingCharge{Subscription: 30},
+		},
+		{
+			name:           "subscription only",
+			charge:         60,
+			refund:         60,
+			wantCharge:     types.BillingCharge{Subscription: 60},
+			wantUserQuota:  1000,
+			wantUsedQuota:  0,
+			wantChargeLeft: types.BillingCharge{},
+		},
+		{
+			// 结算前进入新周期，已用额度已清零，套餐部分退回余额
+			name:           "new period refunds to balance",
+			charge:         60,
+			refund:         60,
+			renewBefore:    true,
+			wantCharge:     types.BillingCharge{Subscription: 60},
+			wantUserQuota:  1060,
+			wantUsedQuota:  0,
+			wantChargeLeft: types.BillingCharge{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupSubscriptionTest(t)
+			ref := QuotaLedgerRef{Type: QuotaLedgerTypeConsume, ReferenceId: "req-1"}
+
+			charge, err := DecreaseBillingQuota(1, 0, tt.charge, ref)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCharge, charge)
+			if tt.renewBefore {
+				sub, err := GetSubscriptionById(1)
+				require.NoError(t, err)
+				require.NoError(t, RenewSubscriptionPeriod(sub, 200, 300, nil))
+			}
+
+			refund := charge.Refund(tt.refund)
+			require.NoError(t, IncreaseBillingQuota(1, 0, refund, QuotaLedgerRef{Type: QuotaLedgerTypeRefund, ReferenceId: "req-1"}))
+			charge.Sub(refund)
+			require.Equal(t, tt.wantChargeLeft, charge)
+
+			quota, err := GetUserQuota(1, true)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantUserQuota, quota)
+			require.Equal(t, tt.wantUsedQuota, getSubscriptionUsedQuota(t, db))
+		})
+	}
+}
+
+func TestRenewSubscriptionPeriodIdempotent(t *testing.T) {
+	db := setupSubscriptionTest(t)
+	payment := &SubscriptionPayment{Amount: 20, Currency: "USD"}
+	sub, err := GetSubscriptionById(1)
+	require.NoError(t, err)
+	require.True(t, changeSubscriptionUsedQuota(sub, 30, QuotaLedgerRef{Type: QuotaLedgerTypeConsume}))
+
+	require.NoError(t, RenewSubscriptionPeriod(sub, 200, 300, payment))
+	require.Equal(t, 0, getSubscriptionUsedQuota(t, db))
+	require.True(t, changeSubscriptionUsedQuota(sub, 10, QuotaLedgerRef{Type: QuotaLedgerTypeConsume}))
+
+	// 同一周期的通知重复到达（每次都重新读取订阅），以及更早周期的通知乱序到达
+	for _, periodStart := range []int64{200, 200, 150} {
+		stale, err := GetSubscriptionById(1)
+		require.NoError(t, err)
+		stale.CurrentPeriodStart = 100
+		require.ErrorIs(t, RenewSubscriptionPeriod(stale, periodStart, periodStart+100, payment), ErrSubscriptionPeriodNotAdvanced)
+	}
+
+	saved, err := GetSubscriptionById(1)
+	require.NoError(t, err)
+	require.Equal(t, 10, saved.UsedQuota)
+	require.EqualValues(t, 200, saved.CurrentPeriodStart)
+	require.EqualValues(t, 300, saved.CurrentPeriodEnd)
+	var invoices int64
+	require.NoError(t, db.Model(&Invoice{}).Where("source_type = ?", InvoiceSourceSubscription).Count(&invoices).Error)
+	require.EqualValues(t, 1, invoices)
+	var resets int64
+	require.NoError(t, db.Model(&QuotaLedger{}).Where("entry_type = ?", QuotaLedgerTypeNewPeriod).Count(&resets).Error)
+	require.EqualValues(t, 1, resets)
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	commonRelay "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
 )
 
 type TaskStatus string
@@ -40,23 +42,25 @@ const (
 )
 
 type Task struct {
-	ID         int64                 `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
-	CreatedAt  int64                 `json:"created_at" gorm:"index"`
-	UpdatedAt  int64                 `json:"updated_at"`
-	TaskID     string                `json:"task_id" gorm:"type:varchar(191);index"` // 第三方id，不一定有/ song id\ Task id
-	Platform   constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
-	UserId     int                   `json:"user_id" gorm:"index"`
-	Group      string                `json:"group" gorm:"type:varchar(50)"` // 修正计费用
-	ChannelId  int                   `json:"channel_id" gorm:"index"`
-	Quota      int                   `json:"quota"`
-	Action     string                `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
-	Status     TaskStatus            `json:"status" gorm:"type:varchar(20);index"` // 任务状态
-	FailReason string                `json:"fail_reason"`
-	SubmitTime int64                 `json:"submit_time" gorm:"index"`
-	StartTime  int64                 `json:"start_time" gorm:"index"`
-	FinishTime int64                 `json:"finish_time" gorm:"index"`
-	Progress   string                `json:"progress" gorm:"type:varchar(20);index"`
-	Properties Properties            `json:"properties" gorm:"type:json"`
+	ID                int64                 `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
+	CreatedAt         int64                 `json:"created_at" gorm:"index"`
+	UpdatedAt         int64                 `json:"updated_at"`
+	TaskID            string                `json:"task_id" gorm:"type:varchar(191);index"` // 第三方id，不一定有/ song id\ Task id
+	Platform          constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
+	UserId            int                   `json:"user_id" gorm:"index"`
+	ProjectId         int                   `json:"project_id" gorm:"default:0"`   // 项目令牌发起的任务，退款时返还到组织或项目额度
+	Group             string                `json:"group" gorm:"type:varchar(50)"` // 修正计费用
+	ChannelId         int                   `json:"channel_id" gorm:"index"`
+	Quota             int                   `json:"quota"`
+	SubscriptionQuota int                   `json:"-" gorm:"default:0"`                   // Quota 中从订阅套餐额度扣除的部分，退款时退回套餐
+	Action            string                `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
+	Status            TaskStatus            `json:"status" gorm:"type:varchar(20);index"` // 任务状态
+	FailReason        string                `json:"fail_reason"`
+	SubmitTime        int64                 `json:"submit_time" gorm:"index"`
+	StartTime         int64                 `json:"start_time" gorm:"index"`
+	FinishTime        int64                 `json:"finish_time" gorm:"index"`
+	Progress          string                `json:"progress" gorm:"type:varchar(20);index"`
+	Properties        Properties            `json:"properties" gorm:"type:json"`
 	// 禁止返回给用户，内部可能包含key等隐私信息
 	PrivateData TaskPrivateData `json:"-" gorm:"column:private_data;type:json"`
 	Data        json.RawMessage `json:"data" gorm:"type:json"`
@@ -72,6 +76,25 @@ func (t *Task) GetData(v any) error {
 	return err
 }
 
+// BillingCharge 任务已扣额度的来源拆分
+func (t *Task) BillingCharge() types.BillingCharge {
+	return types.BillingCharge{Subscription: t.SubscriptionQuota, Balance: t.Quota - t.SubscriptionQuota}
+}
+
+// UpdateSubscriptionQuota 任务写入后才完成扣费时，补记从套餐额度扣除的部分
+func (t *Task) UpdateSubscriptionQuota(quota int) error {
+	t.SubscriptionQuota = quota
+	if t.ID == 0 || quota == 0 {
+		return nil
+	}
+	return DB.Model(t).Update("subscription_quota", quota).Error
+}
+
+// IsFinished 任务是否已进入成功或失败终态
+func (t *Task) IsFinished() bool {
+	return t.Status == TaskStatusSuccess || t.Status == TaskStatusFailure
+}
+
 type Properties struct {
 	Input             string `json:"input"`
 	UpstreamModelName string `json:"upstream_model_name,omitempty"`
@@ -95,7 +118,20 @@ func (m Properties) Value() (driver.Value, error) {
 }
 
 type TaskPrivateData struct {
-	Key string `json:"key,omitempty"`
+	Key             string `json:"key,omitempty"`
+	CallbackUrl     string `json:"callback_url,omitempty"` // 任务结束后的回调地址
+	CallbackSource  string `json:"callback_source,omitempty"`
+	CallbackTokenId int    `json:"callback_token_id,omitempty"`
+}
+
+func (p *TaskPrivateData) SetCallbackTarget(target TaskCallbackTarget) {
+	p.CallbackUrl = target.Url
+	p.CallbackSource = target.Source
+	p.CallbackTokenId = target.TokenId
+}
+
+func (p *TaskPrivateData) GetCallbackTarget() TaskCallbackTarget {
+	return TaskCallbackTarget{Url: p.CallbackUrl, Source: p.CallbackSource, TokenId: p.CallbackTokenId}
 }
 
 func (p *TaskPrivateData) Scan(val interface{}) error {
@@ -103,13 +139,26 @@ func (p *TaskPrivateData) Scan(val interface{}) error {
 	if len(bytesValue) == 0 {
 		return nil
 	}
-	return json.Unmarshal(bytesValue, p)
+	if err := json.Unmarshal(bytesValue, p); err != nil {
+		return err
+	}
+	key, err := common.DecryptSecret(p.Key)
+	if err != nil {
+		return err
+	}
+	p.Key = key
+	return nil
 }
 
 func (p TaskPrivateData) Value() (driver.Value, error) {
 	if (p == TaskPrivateData{}) {
 		return nil, nil
 	}
+	key, err := common.EncryptSecret(p.Key)
+	if err != nil {
+		return nil, err
+	}
+	p.Key = key
 	return json.Marshal(p)
 }
 
@@ -143,6 +192,7 @@ func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.RelayInfo)
 
 	t := &Task{
 		UserId:      relayInfo.UserId,
+		ProjectId:   relayInfo.ProjectId,
 		Group:       relayInfo.UsingGroup,
 		SubmitTime:  time.Now().Unix(),
 		Status:      TaskStatusNotStart,
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+const (
+	TaskCallbackTypeTask       = "task"
+	TaskCallbackTypeMidjourney = "midjourney"
+)
+
+// 回调地址的来源，决定签名使用的密钥
+const (
+	TaskCallbackSourceRequest = "request" // 提交请求中指定，使用令牌的回调密钥，令牌未配置时使用用户的回调密钥
+	TaskCallbackSourceToken   = "token"   // 令牌配置，只使用令牌的回调密钥
+	TaskCallbackSourceUser    = "user"    // 用户设置，使用用户的回调密钥
+)
+
+// TaskCallbackTarget 任务提交时确定的回调地址及其签名密钥的归属
+type TaskCallbackTarget struct {
+	Url     string
+	Source  string
+	TokenId int
+}
+
+const (
+	TaskCallbackStatusPending = "pending"
+	TaskCallbackStatusSuccess = "success"
+	TaskCallbackStatusFailed  = "failed"
+)
+
+// TaskCallback 异步任务完成回调的投递记录，Payload 为任务结束时的快照
+type TaskCallback struct {
+	Id             int    `json:"id"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	TaskType       string `json:"task_type" gorm:"type:varchar(20)"`
+	TaskId         string `json:"task_id" gorm:"type:varchar(191);index"`
+	Url            string `json:"url" gorm:"type:varchar(512)"`
+	Source         string `json:"source" gorm:"type:varchar(16);default:''"` // 回调地址来源，为空时按用户设置处理
+	TokenId        int    `json:"token_id" gorm:"default:0"`
+	Payload        string `json:"payload" gorm:"type:text"`
+	Status         string `json:"status" gorm:"type:varchar(20);index"`
+	Attempts       int    `json:"attempts" gorm:"default:0"`
+	LastStatusCode int    `json:"last_status_code" gorm:"default:0"`
+	LastError      string `json:"last_error" gorm:"type:text"`
+	NextAttemptAt  int64  `json:"next_attempt_at" gorm:"bigint;index"`
+	CreatedAt      int64  `json:"created_at" gorm:"bigint;index"`
+	UpdatedAt      int64  `json:"updated_at" gorm:"bigint"`
+}
+
+type TaskCallbackFilter struct {
+	UserId   int
+	TaskId   string
+	TaskType string
+	Status   string
+}
+
+func (callback *TaskCallback) Insert() error {
+	return DB.Create(callback).Error
+}
+
+func (callback *TaskCallback) Update() error {
+	return DB.Save(callback).Error
+}
+
+func GetTaskCallbackById(id int) (*TaskCallback, error) {
+	callback := &TaskCallback{}
+	err := DB.First(callback, "id = ?", id).Error
+	return callback, err
+}
+
+func GetTaskCallbacks(filter TaskCallbackFilter, startIdx int, num int) (callbacks []*TaskCallback, total int64, err error) {
+	tx := DB.Model(&TaskCallback{})
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.TaskId != "" {
+		tx = tx.Where("task_id = ?", filter.TaskId)
+	}
+	if filter.TaskType != "" {
+		tx = tx.Where("task_type = ?", filter.TaskType)
+	}
+	if filter.Status != "" {
+		tx = tx.Where("status = ?", filter.Status)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&callbacks).Error
+	return callbacks, total, err
+}
+
+// GetDueTaskCallbacks 获取已到重试时间的待投递记录
+func GetDueTaskCallbacks(now int64, limit int) (callbacks []*TaskCallback, err error) {
+	err = DB.Where("status = ? AND next_attempt_at <= ?", TaskCallbackStatusPending, now).
+		Order("next_attempt_at asc").Limit(limit).Find(&callbacks).Error
+	return callbacks, err
+}
+
+// ResetTaskCallback 重新投递，清空已尝试次数
+func ResetTaskCallback(id int, now int64) error {
+	result := DB.Model(&TaskCallback{}).Where("id = ?", id).Updates(map[string]any{
+		"status":          TaskCallbackStatusPending,
+		"attempts":        0,
+		"next_attempt_at": now,
+		"updated_at":      now,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func DeleteOldTaskCallbacks(targetTimestamp int64) (int64, error) {
+	result := DB.Where("created_at < ? AND status <> ?", targetTimestamp, TaskCallbackStatusPending).Delete(&TaskCallback{})
+	return result.RowsAffected, result.Error
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
 )
@@ -26,10 +27,49 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
-	CrossGroupRetry    bool           `json:"cross_group_retry"` // 跨分组重试，仅auto分组有效
+	CrossGroupRetry    bool           `json:"cross_group_retry"`                                  // 跨分组重试，仅auto分组有效
+	RpmLimit           int            `json:"rpm_limit" gorm:"default:0"`                         // 每分钟请求数限制，0 表示不限制
+	TpmLimit           int            `json:"tpm_limit" gorm:"default:0"`                         // 每分钟 token 数限制，0 表示不限制
+	ConcurrencyLimit   int            `json:"concurrency_limit" gorm:"default:0"`                 // 并发请求数限制，0 表示不限制
+	ResponseCache      bool           `json:"response_cache"`                                     // 启用响应缓存，分组未启用时也生效
+	ProjectId          int            `json:"project_id" gorm:"default:0;index"`                  // 所属项目，非 0 时使用组织或项目额度计费
+	BudgetPeriod       string         `json:"budget_period" gorm:"type:varchar(16);default:''"`   // 预算周期 daily/weekly/monthly，为空表示不限制
+	BudgetQuota        int            `json:"budget_quota" gorm:"default:0"`                      // 每个周期的额度上限
+	BudgetMonthlyCap   int            `json:"budget_monthly_cap" gorm:"default:0"`                // 每月额度硬上限，0 表示不限制
+	BudgetTimezone     string         `json:"budget_timezone" gorm:"type:varchar(64);default:''"` // 周期重置时区，为空时使用全局配置
+	CallbackUrl        string         `json:"callback_url" gorm:"type:varchar(512);default:''"`   // 异步任务完成回调地址
+	CallbackSecret     string         `json:"-" gorm:"type:varchar(255);default:''"`              // 回调签名密钥，加密存储
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
+func (token *Token) GetBudget() operation_setting.Budget {
+	return operation_setting.Budget{
+		Period:     token.BudgetPeriod,
+		Quota:      token.BudgetQuota,
+		MonthlyCap: token.BudgetMonthlyCap,
+		Timezone:   token.BudgetTimezone,
+	}
+}
+
+// GetCallbackSecret 解密令牌的回调签名密钥
+func (token *Token) GetCallbackSecret() string {
+	secret, err := common.DecryptSecret(token.CallbackSecret)
+	if err != nil {
+		common.SysLog("failed to decrypt token callback secret: " + err.Error())
+		return ""
+	}
+	return secret
+}
+
+func (token *Token) SetCallbackSecret(secret string) error {
+	encrypted, err := common.EncryptSecret(secret)
+	if err != nil {
+		return err
+	}
+	token.CallbackSecret = encrypted
+	return nil
+}
+
 func (token *Token) Clean() {
 	token.Key = ""
 }
@@ -178,6 +218,7 @@ func (token *Token) Insert() error {
 }
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
+// 剩余额度不在此更新，需要调整额度时使用 Edit
 func (token *Token) Update() (err error) {
 	defer func() {
 		if shouldUpdateRedis(true, err) {
@@ -189,11 +230,50 @@ func (token *Token) Update() (err error) {
 			})
 		}
 	}()
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+	return token.updateFields(DB)
+}
+
+// Edit 编辑令牌；originalRemainQuota 为打开编辑时看到的剩余额度，传入时按输入值与它的差值调整额度，
+// 编辑期间产生的消费不会被覆盖；未传入时直接把剩余额度设为输入值
+func (token *Token) Edit(originalRemainQuota *int) (err error) {
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			gopool.Go(func() {
+				err := cacheSetToken(*token)
+				if err != nil {
+					common.SysLog("failed to update token cache: " + err.Error())
+				}
+			})
+		}
+	}()
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		before, err := getQuotaLedgerColumnValue(tx, QuotaLedgerSubjectToken, token.Id)
+		if err != nil {
+			return err
+		}
+		delta := token.RemainQuota - before
+		if originalRemainQuota != nil {
+			delta = token.RemainQuota - *originalRemainQuota
+		}
+		if err := token.updateFields(tx); err != nil {
+			return err
+		}
+		if err := changeQuotaLedgerColumn(tx, QuotaLedgerSubjectToken, token.Id, token.UserId, delta, QuotaLedgerRef{Type: QuotaLedgerTypeAdmin}); err != nil {
+			return err
+		}
+		token.RemainQuota, err = getQuotaLedgerColumnValue(tx, QuotaLedgerSubjectToken, token.Id)
+		return err
+	})
 	return err
 }
 
+func (token *Token) updateFields(tx *gorm.DB) error {
+	return tx.Model(token).Select("name", "status", "expired_time", "unlimited_quota",
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry", "rpm_limit", "tpm_limit",
+		"concurrency_limit", "response_cache", "project_id", "budget_period", "budget_quota", "budget_monthly_cap",
+		"budget_timezone", "callback_url", "callback_secret").Updates(token).Error
+}
+
 func (token *Token) SelectUpdate() (err error) {
 	defer func() {
 		if shouldUpdateRedis(true, err) {
@@ -267,7 +347,7 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, key string, quota int) (err error) {
+func IncreaseTokenQuota(id int, key string, quota int, ref QuotaLedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -283,21 +363,16 @@ func IncreaseTokenQuota(id int, key string, quota int) (err error) {
 		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
 		return nil
 	}
-	return increaseTokenQuota(id, quota)
+	return increaseTokenQuota(id, quota, ref)
 }
 
-func increaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
-			"used_quota":    gorm.Expr("used_quota - ?", quota),
-			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+func increaseTokenQuota(id int, quota int, ref QuotaLedgerRef) (err error) {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return changeTokenQuota(tx, id, quota, ref)
+	})
 }
 
-func DecreaseTokenQuota(id int, key string, quota int) (err error) {
+func DecreaseTokenQuota(id int, key string, quota int, ref QuotaLedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -313,18 +388,11 @@ func DecreaseTokenQuota(id int, key string, quota int) (err error) {
 		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
 		return nil
 	}
-	return decreaseTokenQuota(id, quota)
+	return decreaseTokenQuota(id, quota, ref)
 }
 
-func decreaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
-			"used_quota":    gorm.Expr("used_quota + ?", quota),
-			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+func decreaseTokenQuota(id int, quota int, ref QuotaLedgerRef) (err error) {
+	return increaseTokenQuota(id, -quota, ref)
 }
 
 // CountUserTokens returns total number of tokens for the given user, used for pagination
@@ -63,3 +63,17 @@ func cacheGetTokenByKey(key string) (*Token, error) {
 	token.Key = key
 	return &token, nil
 }
+
+// GetCachedTokenRemainQuota 读取缓存中的令牌剩余额度，用于与数据库对账
+func GetCachedTokenRemainQuota(key string) (int, error) {
+	token, err := cacheGetTokenByKey(key)
+	if err != nil {
+		return 0, err
+	}
+	return token.RemainQuota, nil
+}
+
+// RefreshTokenQuotaCache 以数据库中的剩余额度覆盖缓存
+func RefreshTokenQuotaCache(key string, remainQuota int) error {
+	return cacheSetTokenField(key, constant.TokenFiledRemainQuota, fmt.Sprintf("%d", remainQuota))
+}
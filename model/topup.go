@@ -18,6 +18,7 @@ type TopUp struct {
 	Money         float64 `json:"money"`
 	TradeNo       string  `json:"trade_no" gorm:"unique;type:varchar(255);index"`
 	PaymentMethod string  `json:"payment_method" gorm:"type:varchar(50)"`
+	Currency      string  `json:"currency" gorm:"type:varchar(8)"` // 支付币种，易支付为 CNY，Stripe 以回调为准
 	CreateTime    int64   `json:"create_time"`
 	CompleteTime  int64   `json:"complete_time"`
 	Status        string  `json:"status"`
@@ -55,7 +56,7 @@ func GetTopUpByTradeNo(tradeNo string) *TopUp {
 	return topUp
 }
 
-func Recharge(referenceId string, customerId string) (err error) {
+func Recharge(referenceId string, customerId string, currency string) (err error) {
 	if referenceId == "" {
 		return errors.New("未提供支付单号")
 	}
@@ -80,23 +81,31 @@ func Recharge(referenceId string, customerId string) (err error) {
 
 		topUp.CompleteTime = common.GetTimestamp()
 		topUp.Status = common.TopUpStatusSuccess
+		if currency != "" {
+			topUp.Currency = currency
+		}
 		err = tx.Save(topUp).Error
 		if err != nil {
 			return err
 		}
 
 		quota = topUp.Money * common.QuotaPerUnit
-		err = tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(map[string]interface{}{"stripe_customer": customerId, "quota": gorm.Expr("quota + ?", quota)}).Error
+		err = tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("stripe_customer", customerId).Error
+		if err != nil {
+			return err
+		}
+		err = changeUserQuota(tx, topUp.UserId, int(quota), QuotaLedgerRef{Type: QuotaLedgerTypeTopup, ReferenceId: topUp.TradeNo})
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return enqueueTopUpInvoice(tx, topUp, int(quota))
 	})
 
 	if err != nil {
 		return errors.New("充值失败，" + err.Error())
 	}
+	issuePendingInvoiceAfterCommit(InvoiceSourceTopUp, topUp.TradeNo)
 
 	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%d", logger.FormatQuota(int(quota)), topUp.Amount))
 
@@ -288,7 +297,10 @@ func ManualCompleteTopUp(tradeNo string) error {
 		}
 
 		// 增加用户额度（立即写库，保持一致性）
-		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", quotaToAdd)).Error; err != nil {
+		if err := changeUserQuota(tx, topUp.UserId, quotaToAdd, QuotaLedgerRef{Type: QuotaLedgerTypeTopup, ReferenceId: topUp.TradeNo}); err != nil {
+			return err
+		}
+		if err := enqueueTopUpInvoice(tx, topUp, quotaToAdd); err != nil {
 			return err
 		}
 
@@ -300,6 +312,7 @@ func ManualCompleteTopUp(tradeNo string) error {
 	if err != nil {
 		return err
 	}
+	issuePendingInvoiceAfterCommit(InvoiceSourceTopUp, tradeNo)
 
 	// 事务外记录日志，避免阻塞
 	RecordLog(userId, LogTypeTopup, fmt.Sprintf("管理员补单成功，充值金额: %v，支付金额：%f", logger.FormatQuota(quotaToAdd), payMoney))
@@ -338,10 +351,17 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 		// Creem 直接使用 Amount 作为充值额度（整数）
 		quota = topUp.Amount
 
-		// 构建更新字段，优先使用邮箱，如果邮箱为空则使用用户名
-		updateFields := map[string]interface{}{
-			"quota": gorm.Expr("quota + ?", quota),
+		err = changeUserQuota(tx, topUp.UserId, int(quota), QuotaLedgerRef{Type: QuotaLedgerTypeTopup, ReferenceId: topUp.TradeNo})
+		if err != nil {
+			return err
 		}
+		err = enqueueTopUpInvoice(tx, topUp, int(quota))
+		if err != nil {
+			return err
+		}
+
+		// 构建更新字段，优先使用邮箱，如果邮箱为空则使用用户名
+		updateFields := map[string]interface{}{}
 
 		// 如果有客户邮箱，尝试更新用户邮箱（仅当用户邮箱为空时）
 		if customerEmail != "" {
@@ -358,17 +378,16 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 			}
 		}
 
-		err = tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(updateFields).Error
-		if err != nil {
-			return err
+		if len(updateFields) == 0 {
+			return nil
 		}
-
-		return nil
+		return tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(updateFields).Error
 	})
 
 	if err != nil {
 		return errors.New("充值失败，" + err.Error())
 	}
+	issuePendingInvoiceAfterCommit(InvoiceSourceTopUp, topUp.TradeNo)
 
 	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用Creem充值成功，充值额度: %v，支付金额：%.2f", quota, topUp.Money))
 
@@ -10,6 +10,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
@@ -47,21 +48,44 @@ type User struct {
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
 	Remark           string         `json:"remark,omitempty" gorm:"type:varchar(255)" validate:"max=255"`
 	StripeCustomer   string         `json:"stripe_customer" gorm:"type:varchar(64);column:stripe_customer;index"`
+	RpmLimit         int            `json:"rpm_limit" gorm:"type:int;default:0"`                // 每分钟请求数限制，0 表示使用分组或默认限制
+	TpmLimit         int            `json:"tpm_limit" gorm:"type:int;default:0"`                // 每分钟 token 数限制，0 表示使用分组或默认限制
+	ConcurrencyLimit int            `json:"concurrency_limit" gorm:"type:int;default:0"`        // 并发请求数限制，0 表示使用分组或默认限制
+	BudgetPeriod     string         `json:"budget_period" gorm:"type:varchar(16);default:''"`   // 预算周期 daily/weekly/monthly，为空表示不限制
+	BudgetQuota      int            `json:"budget_quota" gorm:"type:int;default:0"`             // 每个周期的额度上限
+	BudgetMonthlyCap int            `json:"budget_monthly_cap" gorm:"type:int;default:0"`       // 每月额度硬上限，0 表示不限制
+	BudgetTimezone   string         `json:"budget_timezone" gorm:"type:varchar(64);default:''"` // 周期重置时区，为空时使用全局配置
 }
 
 func (user *User) ToBaseUser() *UserBase {
 	cache := &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
+		Id:               user.Id,
+		Group:            user.Group,
+		Quota:            user.Quota,
+		Status:           user.Status,
+		Username:         user.Username,
+		Setting:          user.Setting,
+		Email:            user.Email,
+		RpmLimit:         user.RpmLimit,
+		TpmLimit:         user.TpmLimit,
+		ConcurrencyLimit: user.ConcurrencyLimit,
+		BudgetPeriod:     user.BudgetPeriod,
+		BudgetQuota:      user.BudgetQuota,
+		BudgetMonthlyCap: user.BudgetMonthlyCap,
+		BudgetTimezone:   user.BudgetTimezone,
 	}
 	return cache
 }
 
+func (user *User) GetBudget() operation_setting.Budget {
+	return operation_setting.Budget{
+		Period:     user.BudgetPeriod,
+		Quota:      user.BudgetQuota,
+		MonthlyCap: user.BudgetMonthlyCap,
+		Timezone:   user.BudgetTimezone,
+	}
+}
+
 func (user *User) GetAccessToken() string {
 	if user.AccessToken == nil {
 		return ""
@@ -81,10 +105,39 @@ func (user *User) GetSetting() dto.UserSetting {
 			common.SysLog("failed to unmarshal setting: " + err.Error())
 		}
 	}
+	decryptUserSetting(&setting)
 	return setting
 }
 
+// decryptUserSetting 解密用户设置中加密存储的 webhook 与任务回调密钥
+func decryptUserSetting(setting *dto.UserSetting) {
+	secret, err := common.DecryptSecret(setting.WebhookSecret)
+	if err != nil {
+		common.SysLog("failed to decrypt webhook secret: " + err.Error())
+		secret = ""
+	}
+	setting.WebhookSecret = secret
+	callbackSecret, err := common.DecryptSecret(setting.TaskCallbackSecret)
+	if err != nil {
+		common.SysLog("failed to decrypt task callback secret: " + err.Error())
+		callbackSecret = ""
+	}
+	setting.TaskCallbackSecret = callbackSecret
+}
+
 func (user *User) SetSetting(setting dto.UserSetting) {
+	secret, err := common.EncryptSecret(setting.WebhookSecret)
+	if err != nil {
+		common.SysLog("failed to encrypt webhook secret: " + err.Error())
+		return
+	}
+	setting.WebhookSecret = secret
+	callbackSecret, err := common.EncryptSecret(setting.TaskCallbackSecret)
+	if err != nil {
+		common.SysLog("failed to encrypt task callback secret: " + err.Error())
+		return
+	}
+	setting.TaskCallbackSecret = callbackSecret
 	settingBytes, err := json.Marshal(setting)
 	if err != nil {
 		common.SysLog("failed to marshal setting: " + err.Error())
@@ -325,15 +378,13 @@ func HardDeleteUserById(id int) error {
 	return err
 }
 
+// inviteUser 只累加邀请相关字段，避免整行保存覆盖并发写入的额度
 func inviteUser(inviterId int) (err error) {
-	user, err := GetUserById(inviterId, true)
-	if err != nil {
-		return err
-	}
-	user.AffCount++
-	user.AffQuota += common.QuotaForInviter
-	user.AffHistoryQuota += common.QuotaForInviter
-	return DB.Save(user).Error
+	return DB.Model(&User{}).Where("id = ?", inviterId).Updates(map[string]interface{}{
+		"aff_count":   gorm.Expr("aff_count + ?", 1),
+		"aff_quota":   gorm.Expr("aff_quota + ?", common.QuotaForInviter),
+		"aff_history": gorm.Expr("aff_history + ?", common.QuotaForInviter),
+	}).Error
 }
 
 func (user *User) TransferAffQuotaToQuota(quota int) error {
@@ -360,14 +411,17 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 		return errors.New("邀请额度不足！")
 	}
 
-	// 更新用户额度
+	// 更新用户额度，余额变动单独写入流水
 	user.AffQuota -= quota
-	user.Quota += quota
 
 	// 保存用户状态
-	if err := tx.Save(user).Error; err != nil {
+	if err := tx.Omit("quota").Save(user).Error; err != nil {
+		return err
+	}
+	if err := changeUserQuota(tx, user.Id, quota, QuotaLedgerRef{Type: QuotaLedgerTypeAffTransfer}); err != nil {
 		return err
 	}
+	user.Quota += quota
 
 	// 提交事务
 	return tx.Commit().Error
@@ -417,7 +471,7 @@ func (user *User) Insert(inviterId int) error {
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true)
+			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true, QuotaLedgerRef{Type: QuotaLedgerTypeInvite, ReferenceId: strconv.Itoa(inviterId)})
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", logger.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
@@ -439,7 +493,8 @@ func (user *User) Update(updatePassword bool) error {
 	}
 	newUser := *user
 	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(newUser).Error; err != nil {
+	// 额度与用量只通过流水相关函数变更，这里读到的值可能已过期
+	if err = DB.Model(user).Omit("quota", "used_quota", "request_count").Updates(newUser).Error; err != nil {
 		return err
 	}
 
@@ -447,7 +502,9 @@ func (user *User) Update(updatePassword bool) error {
 	return updateUserCache(*user)
 }
 
-func (user *User) Edit(updatePassword bool) error {
+// Edit 管理员编辑用户；originalQuota 为管理员打开编辑时看到的额度，传入时按输入值与它的差值调整额度，
+// 编辑期间产生的消费不会被覆盖；未传入时直接把额度设为输入值
+func (user *User) Edit(updatePassword bool, originalQuota *int) error {
 	var err error
 	if updatePassword {
 		user.Password, err = common.Password2Hash(user.Password)
@@ -458,18 +515,41 @@ func (user *User) Edit(updatePassword bool) error {
 
 	newUser := *user
 	updates := map[string]interface{}{
-		"username":     newUser.Username,
-		"display_name": newUser.DisplayName,
-		"group":        newUser.Group,
-		"quota":        newUser.Quota,
-		"remark":       newUser.Remark,
+		"username":           newUser.Username,
+		"display_name":       newUser.DisplayName,
+		"group":              newUser.Group,
+		"remark":             newUser.Remark,
+		"rpm_limit":          newUser.RpmLimit,
+		"tpm_limit":          newUser.TpmLimit,
+		"concurrency_limit":  newUser.ConcurrencyLimit,
+		"budget_period":      newUser.BudgetPeriod,
+		"budget_quota":       newUser.BudgetQuota,
+		"budget_monthly_cap": newUser.BudgetMonthlyCap,
+		"budget_timezone":    newUser.BudgetTimezone,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
 	}
 
-	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(updates).Error; err != nil {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, user.Id).Error; err != nil {
+			return err
+		}
+		// 额度按差值调整并记录流水
+		delta := newUser.Quota - user.Quota
+		if originalQuota != nil {
+			delta = newUser.Quota - *originalQuota
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := changeUserQuota(tx, user.Id, delta, QuotaLedgerRef{Type: QuotaLedgerTypeAdmin}); err != nil {
+			return err
+		}
+		user.Quota += delta
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
@@ -763,7 +843,7 @@ func GetUserSetting(id int, fromDB bool) (settingMap dto.UserSetting, err error)
 	return userBase.GetSetting(), nil
 }
 
-func IncreaseUserQuota(id int, quota int, db bool) (err error) {
+func IncreaseUserQuota(id int, quota int, db bool, ref QuotaLedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -777,18 +857,16 @@ func IncreaseUserQuota(id int, quota int, db bool) (err error) {
 		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	return increaseUserQuota(id, quota, ref)
 }
 
-func increaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
-	if err != nil {
-		return err
-	}
-	return err
+func increaseUserQuota(id int, quota int, ref QuotaLedgerRef) (err error) {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return changeUserQuota(tx, id, quota, ref)
+	})
 }
 
-func DecreaseUserQuota(id int, quota int) (err error) {
+func DecreaseUserQuota(id int, quota int, ref QuotaLedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -802,25 +880,21 @@ func DecreaseUserQuota(id int, quota int) (err error) {
 		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
 		return nil
 	}
-	return decreaseUserQuota(id, quota)
+	return decreaseUserQuota(id, quota, ref)
 }
 
-func decreaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
-	if err != nil {
-		return err
-	}
-	return err
+func decreaseUserQuota(id int, quota int, ref QuotaLedgerRef) (err error) {
+	return increaseUserQuota(id, -quota, ref)
 }
 
-func DeltaUpdateUserQuota(id int, delta int) (err error) {
+func DeltaUpdateUserQuota(id int, delta int, ref QuotaLedgerRef) (err error) {
 	if delta == 0 {
 		return nil
 	}
 	if delta > 0 {
-		return IncreaseUserQuota(id, delta, false)
+		return IncreaseUserQuota(id, delta, false, ref)
 	} else {
-		return DecreaseUserQuota(id, -delta)
+		return DecreaseUserQuota(id, -delta, ref)
 	}
 }
 
@@ -879,6 +953,14 @@ func updateUserRequestCount(id int, count int) {
 	}
 }
 
+func GetUserIdByUsername(username string) (id int, err error) {
+	err = DB.Model(&User{}).Where("username = ?", username).Select("id").Find(&id).Error
+	if err == nil && id == 0 {
+		err = errors.New("用户不存在")
+	}
+	return id, err
+}
+
 // GetUsernameById gets username from Redis first, falls back to DB if needed
 func GetUsernameById(id int, fromDB bool) (username string, err error) {
 	defer func() {
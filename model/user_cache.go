@@ -7,6 +7,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
 
@@ -15,13 +16,20 @@ import (
 
 // UserBase struct remains the same as it represents the cached data structure
 type UserBase struct {
-	Id       int    `json:"id"`
-	Group    string `json:"group"`
-	Email    string `json:"email"`
-	Quota    int    `json:"quota"`
-	Status   int    `json:"status"`
-	Username string `json:"username"`
-	Setting  string `json:"setting"`
+	Id               int    `json:"id"`
+	Group            string `json:"group"`
+	Email            string `json:"email"`
+	Quota            int    `json:"quota"`
+	Status           int    `json:"status"`
+	Username         string `json:"username"`
+	Setting          string `json:"setting"`
+	RpmLimit         int    `json:"rpm_limit"`
+	TpmLimit         int    `json:"tpm_limit"`
+	ConcurrencyLimit int    `json:"concurrency_limit"`
+	BudgetPeriod     string `json:"budget_period"`
+	BudgetQuota      int    `json:"budget_quota"`
+	BudgetMonthlyCap int    `json:"budget_monthly_cap"`
+	BudgetTimezone   string `json:"budget_timezone"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -31,6 +39,17 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	common.SetContextKey(c, constant.ContextKeyUserEmail, user.Email)
 	common.SetContextKey(c, constant.ContextKeyUserName, user.Username)
 	common.SetContextKey(c, constant.ContextKeyUserSetting, user.GetSetting())
+	common.SetContextKey(c, constant.ContextKeyUserUsageLimit, operation_setting.UsageLimit{
+		RPM:         user.RpmLimit,
+		TPM:         user.TpmLimit,
+		Concurrency: user.ConcurrencyLimit,
+	})
+	common.SetContextKey(c, constant.ContextKeyUserBudget, operation_setting.Budget{
+		Period:     user.BudgetPeriod,
+		Quota:      user.BudgetQuota,
+		MonthlyCap: user.BudgetMonthlyCap,
+		Timezone:   user.BudgetTimezone,
+	})
 }
 
 func (user *UserBase) GetSetting() dto.UserSetting {
@@ -41,6 +60,7 @@ func (user *UserBase) GetSetting() dto.UserSetting {
 			common.SysLog("failed to unmarshal setting: " + err.Error())
 		}
 	}
+	decryptUserSetting(&setting)
 	return setting
 }
 
@@ -99,15 +119,7 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 	}
 
 	// Create cache object from user data
-	userCache = &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
-	}
+	userCache = user.ToBaseUser()
 
 	return userCache, nil
 }
@@ -217,3 +229,17 @@ func updateUserSettingCache(userId int, setting string) error {
 	}
 	return common.RedisHSetField(getUserCacheKey(userId), "Setting", setting)
 }
+
+// GetCachedUserQuota 读取缓存中的用户额度，用于与数据库对账
+func GetCachedUserQuota(userId int) (int, error) {
+	cache, err := cacheGetUserBase(userId)
+	if err != nil {
+		return 0, err
+	}
+	return cache.Quota, nil
+}
+
+// RefreshUserQuotaCache 以数据库中的余额覆盖缓存
+func RefreshUserQuotaCache(userId int, quota int) error {
+	return updateUserQuotaCache(userId, quota)
+}
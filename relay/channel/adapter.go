@@ -52,6 +52,11 @@ type TaskAdaptor interface {
 	ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, error)
 }
 
+// ResponsesAPISupporter 原生实现 /v1/responses 的适配器实现此接口，未实现的适配器经 chat/completions 转发
+type ResponsesAPISupporter interface {
+	SupportsResponsesAPI() bool
+}
+
 type OpenAIVideoConverter interface {
 	ConvertToOpenAIVideo(originTask *model.Task) ([]byte, error)
 }
@@ -1,16 +1,19 @@
 package channel
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
 	"sync"
 	"time"
 
 	common2 "github.com/QuantumNous/new-api/common"
+	constant2 "github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/constant"
@@ -22,6 +25,7 @@ import (
 	"github.com/bytedance/gopkg/util/gopool"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func SetupApiRequestHeader(info *common.RelayInfo, c *gin.Context, req *http.Header) {
@@ -58,6 +62,19 @@ func processHeaderOverride(info *common.RelayInfo) (map[string]string, error) {
 	return headerOverride, nil
 }
 
+// capturePayloadRequest 开启内容采集时保存发往上游的请求体
+func capturePayloadRequest(c *gin.Context, requestBody io.Reader) (io.Reader, error) {
+	if requestBody == nil || !common2.GetContextKeyBool(c, constant2.ContextKeyPayloadCapture) {
+		return requestBody, nil
+	}
+	body, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("read request body failed: %w", err)
+	}
+	common2.SetContextKey(c, constant2.ContextKeyPayloadUpstreamRequest, body)
+	return bytes.NewReader(body), nil
+}
+
 func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
 	fullRequestURL, err := a.GetRequestURL(info)
 	if err != nil {
@@ -66,6 +83,10 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if common2.DebugEnabled {
 		println("fullRequestURL:", fullRequestURL)
 	}
+	requestBody, err = capturePayloadRequest(c, requestBody)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("new request failed: %w", err)
@@ -284,14 +305,30 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	ctx, span := common2.StartSpan(c, "relay.upstream",
+		attribute.String("http.request.method", req.Method),
+		attribute.String("server.address", req.URL.Host),
+	)
+	defer span.End()
+	if info.ChannelSetting.PropagateTraceContext {
+		common2.InjectTraceContext(ctx, req.Header)
+	}
+	requestStart := time.Now()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			span.SetAttributes(attribute.Int64("upstream.ttfb_ms", time.Since(requestStart).Milliseconds()))
+		},
+	}))
 	resp, err := client.Do(req)
 	if err != nil {
+		common2.SetSpanError(span, err)
 		logger.LogError(c, "do request failed: "+err.Error())
 		return nil, types.NewError(err, types.ErrorCodeDoRequestFailed, types.ErrOptionWithHideErrMsg("upstream error: do request failed"))
 	}
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
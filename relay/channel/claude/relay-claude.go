@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/relay/channel/openrouter"
@@ -702,6 +703,89 @@ func HandleStreamFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, clau
 	}
 }
 
+// filterClaudeStreamData 对文本增量做 PII 还原与护栏检查，返回需要依次处理的分片；拦截时直接结束当前消息
+func filterClaudeStreamData(c *gin.Context, info *relaycommon.RelayInfo, filter *service.StreamTextFilter, claudeInfo *ClaudeResponseInfo, data string) []string {
+	if filter == nil {
+		return []string{data}
+	}
+	var claudeResponse dto.ClaudeResponse
+	if err := common.UnmarshalJsonStr(data, &claudeResponse); err != nil {
+		return []string{data}
+	}
+	switch {
+	case claudeResponse.Type == "content_block_delta" && claudeResponse.Delta != nil && claudeResponse.Delta.Text != nil:
+		text := *claudeResponse.Delta.Text
+		checked, blocked := filter.Process(text)
+		if blocked {
+			sendClaudeGuardrailStop(c, info, claudeInfo, claudeResponse.GetIndex())
+			return nil
+		}
+		if checked == text {
+			return []string{data}
+		}
+		claudeResponse.Delta.SetText(checked)
+	case claudeResponse.Type == "content_block_stop":
+		rest, blocked := filter.Flush()
+		if blocked {
+			sendClaudeGuardrailStop(c, info, claudeInfo, claudeResponse.GetIndex())
+			return nil
+		}
+		if rest == "" {
+			return []string{data}
+		}
+		// 补发暂缓的文本
+		restDelta := dto.ClaudeResponse{
+			Type:  "content_block_delta",
+			Delta: &dto.ClaudeMediaMessage{Type: "text_delta", Text: &rest},
+		}
+		restDelta.SetIndex(claudeResponse.GetIndex())
+		restData, err := common.Marshal(restDelta)
+		if err != nil {
+			return []string{data}
+		}
+		return []string{string(restData), data}
+	case claudeResponse.Completion != "":
+		checked, blocked := filter.Process(claudeResponse.Completion)
+		if blocked {
+			sendClaudeGuardrailStop(c, info, claudeInfo, claudeResponse.GetIndex())
+			return nil
+		}
+		if checked == claudeResponse.Completion {
+			return []string{data}
+		}
+		claudeResponse.Completion = checked
+	default:
+		return []string{filter.RestoreRaw(data)}
+	}
+	jsonData, err := common.Marshal(claudeResponse)
+	if err != nil {
+		return []string{data}
+	}
+	return []string{string(jsonData)}
+}
+
+// sendClaudeGuardrailStop 以 refusal / content_filter 结束流
+func sendClaudeGuardrailStop(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, index int) {
+	switch info.RelayFormat {
+	case types.RelayFormatClaude:
+		stopReason := "refusal"
+		blockStop := dto.ClaudeResponse{Type: "content_block_stop"}
+		blockStop.SetIndex(index)
+		_ = helper.ClaudeData(c, blockStop)
+		_ = helper.ClaudeData(c, dto.ClaudeResponse{
+			Type:  "message_delta",
+			Delta: &dto.ClaudeMediaMessage{StopReason: &stopReason},
+			Usage: &dto.ClaudeUsage{OutputTokens: service.CountTextToken(claudeInfo.ResponseText.String(), info.UpstreamModelName)},
+		})
+		_ = helper.ClaudeData(c, dto.ClaudeResponse{Type: "message_stop"})
+	case types.RelayFormatOpenAI:
+		response := helper.GenerateStopResponse(claudeInfo.ResponseId, claudeInfo.Created, claudeInfo.Model, constant.FinishReasonContentFilter)
+		if err := helper.ObjectData(c, response); err != nil {
+			logger.LogError(c, "send_stream_response_failed: "+err.Error())
+		}
+	}
+}
+
 func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, requestMode int) (*dto.Usage, *types.NewAPIError) {
 	claudeInfo := &ClaudeResponseInfo{
 		ResponseId:   helper.GetResponseID(c),
@@ -711,12 +795,15 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 		Usage:        &dto.Usage{},
 	}
 	var err *types.NewAPIError
+	filter := service.NewStreamTextFilter(c, info)
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
-		err = HandleStreamResponseData(c, info, claudeInfo, data, requestMode)
-		if err != nil {
-			return false
+		for _, item := range filterClaudeStreamData(c, info, filter, claudeInfo, data) {
+			err = HandleStreamResponseData(c, info, claudeInfo, item, requestMode)
+			if err != nil {
+				return false
+			}
 		}
-		return true
+		return !filter.Blocked()
 	})
 	if err != nil {
 		return nil, err
@@ -735,6 +822,11 @@ func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 	if claudeError := claudeResponse.GetClaudeError(); claudeError != nil && claudeError.Type != "" {
 		return types.WithClaudeError(*claudeError, http.StatusInternalServerError)
 	}
+	if claudeResponse.StopReason != "" {
+		if newAPIError := service.CheckVirtualModelFinishReason(c, claudeResponse.StopReason); newAPIError != nil {
+			return newAPIError
+		}
+	}
 	if requestMode == RequestModeCompletion {
 		claudeInfo.Usage = service.ResponseText2Usage(c, claudeResponse.Completion, info.UpstreamModelName, info.GetEstimatePromptTokens())
 	} else {
@@ -763,6 +855,10 @@ func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		c.Set("claude_web_search_requests", claudeResponse.Usage.ServerToolUse.WebSearchRequests)
 	}
 
+	responseData, newAPIError := service.CheckResponseGuardrail(c, info, responseData)
+	if newAPIError != nil {
+		return newAPIError
+	}
 	service.IOCopyBytesGracefully(c, httpResp, responseData)
 	return nil
 }
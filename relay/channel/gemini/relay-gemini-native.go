@@ -52,6 +52,10 @@ func GeminiTextGenerationHandler(c *gin.Context, info *relaycommon.RelayInfo, re
 		}
 	}
 
+	responseBody, newAPIError := service.CheckResponseGuardrail(c, info, responseBody)
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
 	service.IOCopyBytesGracefully(c, resp, responseBody)
 
 	return &usage, nil
@@ -1071,10 +1071,71 @@ func handleFinalStream(c *gin.Context, info *relaycommon.RelayInfo, resp *dto.Ch
 	return nil
 }
 
+// filterGeminiStreamData 对文本分片做 PII 还原与护栏检查，拦截时清空内容并以 SAFETY 结束
+func filterGeminiStreamData(filter *service.StreamTextFilter, geminiResponse *dto.GeminiChatResponse, data string) string {
+	if filter == nil {
+		return data
+	}
+	changed := false
+	blocked := false
+	for i := range geminiResponse.Candidates {
+		candidate := &geminiResponse.Candidates[i]
+		lastText := -1
+		for j := range candidate.Content.Parts {
+			part := &candidate.Content.Parts[j]
+			if part.Text == "" || part.Thought {
+				continue
+			}
+			lastText = j
+			var checked string
+			checked, blocked = filter.Process(part.Text)
+			if blocked {
+				break
+			}
+			if checked != part.Text {
+				part.Text = checked
+				changed = true
+			}
+		}
+		if !blocked && candidate.FinishReason != nil {
+			var rest string
+			rest, blocked = filter.Flush()
+			if rest != "" {
+				if lastText >= 0 {
+					candidate.Content.Parts[lastText].Text += rest
+				} else {
+					candidate.Content.Parts = append(candidate.Content.Parts, dto.GeminiPart{Text: rest})
+				}
+				changed = true
+			}
+		}
+		if blocked {
+			break
+		}
+	}
+	if blocked {
+		finishReason := "SAFETY"
+		for k := range geminiResponse.Candidates {
+			geminiResponse.Candidates[k].Content.Parts = nil
+			geminiResponse.Candidates[k].FinishReason = &finishReason
+		}
+		changed = true
+	}
+	if !changed {
+		return data
+	}
+	jsonData, err := common.Marshal(geminiResponse)
+	if err != nil {
+		return data
+	}
+	return string(jsonData)
+}
+
 func geminiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response, callback func(data string, geminiResponse *dto.GeminiChatResponse) bool) (*dto.Usage, *types.NewAPIError) {
 	var usage = &dto.Usage{}
 	var imageCount int
 	responseText := strings.Builder{}
+	filter := service.NewStreamTextFilter(c, info)
 
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		var geminiResponse dto.GeminiChatResponse
@@ -1083,6 +1144,7 @@ func geminiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 			logger.LogError(c, "error unmarshalling stream response: "+err.Error())
 			return false
 		}
+		data = filterGeminiStreamData(filter, &geminiResponse, data)
 
 		// 统计图片数量
 		for _, candidate := range geminiResponse.Candidates {
@@ -1111,7 +1173,8 @@ func geminiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 			}
 		}
 
-		return callback(data, &geminiResponse)
+		// 命中护栏拦截时，以 SAFETY 结束分片收尾
+		return callback(data, &geminiResponse) && !filter.Blocked()
 	})
 
 	if imageCount != 0 {
@@ -1227,6 +1290,9 @@ func GeminiChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 	}
 	fullTextResponse := responseGeminiChat2OpenAI(c, &geminiResponse)
 	fullTextResponse.Model = info.UpstreamModelName
+	if newAPIError := service.CheckVirtualModelChatResponse(c, fullTextResponse); newAPIError != nil {
+		return nil, newAPIError
+	}
 	usage := dto.Usage{
 		PromptTokens:     geminiResponse.UsageMetadata.PromptTokenCount,
 		CompletionTokens: geminiResponse.UsageMetadata.CandidatesTokenCount,
@@ -1263,6 +1329,10 @@ func GeminiChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 		break
 	}
 
+	responseBody, newAPIError := service.CheckResponseGuardrail(c, info, responseBody)
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
 	service.IOCopyBytesGracefully(c, resp, responseBody)
 
 	return &usage, nil
@@ -557,6 +557,10 @@ func detectImageMimeType(filename string) string {
 	}
 }
 
+func (a *Adaptor) SupportsResponsesAPI() bool {
+	return true
+}
+
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
 	//  转换模型推理力度后缀
 	effort, originModel := parseReasoningEffortFromModelSuffix(request.Model)
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
@@ -56,6 +57,10 @@ func OaiResponsesToChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 		return nil, types.NewOpenAIError(err, types.ErrorCodeJsonMarshalFailed, http.StatusInternalServerError)
 	}
 
+	chatBody, newAPIError := service.CheckResponseGuardrail(c, info, chatBody)
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
 	service.IOCopyBytesGracefully(c, resp, chatBody)
 	return usage, nil
 }
@@ -99,6 +104,46 @@ func OaiResponsesToChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo
 		return true
 	}
 
+	filter := service.NewStreamTextFilter(c, info)
+	// sendGuardrailStop 命中护栏拦截时以 content_filter 结束输出
+	sendGuardrailStop := func() bool {
+		if !sendStartIfNeeded() {
+			return false
+		}
+		stop := helper.GenerateStopResponse(responseId, createAt, model, constant.FinishReasonContentFilter)
+		if err := helper.ObjectData(c, stop); err != nil {
+			streamErr = types.NewOpenAIError(err, types.ErrorCodeBadResponse, http.StatusInternalServerError)
+			return false
+		}
+		sentStop = true
+		return false
+	}
+
+	sendTextDelta := func(delta string) bool {
+		if delta == "" {
+			return true
+		}
+		chunk := &dto.ChatCompletionsStreamResponse{
+			Id:      responseId,
+			Object:  "chat.completion.chunk",
+			Created: createAt,
+			Model:   model,
+			Choices: []dto.ChatCompletionsStreamResponseChoice{
+				{
+					Index: 0,
+					Delta: dto.ChatCompletionsStreamResponseChoiceDelta{
+						Content: &delta,
+					},
+				},
+			},
+		}
+		if err := helper.ObjectData(c, chunk); err != nil {
+			streamErr = types.NewOpenAIError(err, types.ErrorCodeBadResponse, http.StatusInternalServerError)
+			return false
+		}
+		return true
+	}
+
 	sendToolCallDelta := func(callID string, name string, argsDelta string) bool {
 		if callID == "" {
 			return true
@@ -196,27 +241,25 @@ func OaiResponsesToChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo
 			if streamResp.Delta != "" {
 				outputText.WriteString(streamResp.Delta)
 				usageText.WriteString(streamResp.Delta)
-				delta := streamResp.Delta
-				chunk := &dto.ChatCompletionsStreamResponse{
-					Id:      responseId,
-					Object:  "chat.completion.chunk",
-					Created: createAt,
-					Model:   model,
-					Choices: []dto.ChatCompletionsStreamResponseChoice{
-						{
-							Index: 0,
-							Delta: dto.ChatCompletionsStreamResponseChoiceDelta{
-								Content: &delta,
-							},
-						},
-					},
+				delta, blocked := filter.Process(streamResp.Delta)
+				if blocked {
+					return sendGuardrailStop()
 				}
-				if err := helper.ObjectData(c, chunk); err != nil {
-					streamErr = types.NewOpenAIError(err, types.ErrorCodeBadResponse, http.StatusInternalServerError)
+				if !sendTextDelta(delta) {
 					return false
 				}
 			}
 
+		case "response.output_text.done":
+			// 补发暂缓的文本，并等待护栏审核完成
+			rest, blocked := filter.Flush()
+			if blocked {
+				return sendGuardrailStop()
+			}
+			if !sendTextDelta(rest) {
+				return false
+			}
+
 		case "response.output_item.added", "response.output_item.done":
 			if streamResp.Item == nil {
 				break
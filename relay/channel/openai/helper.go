@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
@@ -259,3 +260,118 @@ func sendResponsesStreamData(c *gin.Context, streamResponse dto.ResponsesStreamR
 	}
 	helper.ResponseChunkData(c, streamResponse, data)
 }
+
+// sendFilteredResponsesStreamData 还原 Responses 流中的 PII 占位符并做护栏检查后发送，文本增量中被拆开的占位符在 done 事件前补发；
+// 命中拦截规则时不发送当前事件并返回 false
+func sendFilteredResponsesStreamData(c *gin.Context, filter *service.StreamTextFilter, streamResponse dto.ResponsesStreamResponse, data string) bool {
+	if filter == nil {
+		sendResponsesStreamData(c, streamResponse, data)
+		return true
+	}
+	switch streamResponse.Type {
+	case "response.output_text.delta":
+		checked, blocked := filter.Process(streamResponse.Delta)
+		if blocked {
+			return false
+		}
+		if checked != streamResponse.Delta {
+			data = rewriteResponsesStreamEvent(data, func(event map[string]any) {
+				event["delta"] = checked
+			})
+		}
+	case "response.output_text.done":
+		rest, blocked := filter.Flush()
+		if blocked {
+			return false
+		}
+		if rest != "" {
+			restResponse := dto.ResponsesStreamResponse{Type: "response.output_text.delta"}
+			restData := rewriteResponsesStreamEvent(data, func(event map[string]any) {
+				delete(event, "text")
+				event["type"] = restResponse.Type
+				event["delta"] = rest
+			})
+			sendResponsesStreamData(c, restResponse, restData)
+		}
+		data = filter.RestoreRaw(data)
+	default:
+		data = filter.RestoreRaw(data)
+	}
+	sendResponsesStreamData(c, streamResponse, data)
+	return true
+}
+
+// sendResponsesGuardrailStop 命中护栏拦截时以 content_filter 原因结束 Responses 流
+func sendResponsesGuardrailStop(c *gin.Context, response *dto.OpenAIResponsesResponse) {
+	incomplete := dto.OpenAIResponsesResponse{Object: "response"}
+	if response != nil {
+		incomplete = *response
+	}
+	incomplete.Status = "incomplete"
+	incomplete.IncompleteDetails = &dto.IncompleteDetails{Reason: constant.FinishReasonContentFilter}
+	stop := dto.ResponsesStreamResponse{Type: "response.incomplete", Response: &incomplete}
+	data, err := common.Marshal(stop)
+	if err != nil {
+		common.SysLog("error marshalling responses stream stop: " + err.Error())
+		return
+	}
+	sendResponsesStreamData(c, stop, string(data))
+}
+
+func rewriteResponsesStreamEvent(data string, rewrite func(event map[string]any)) string {
+	var event map[string]any
+	if err := common.UnmarshalJsonStr(data, &event); err != nil {
+		return data
+	}
+	rewrite(event)
+	jsonData, err := common.Marshal(event)
+	if err != nil {
+		return data
+	}
+	return string(jsonData)
+}
+
+// filterStreamData 对流式分片做 PII 还原与护栏检查，拦截时改写为 content_filter 结束分片
+func filterStreamData(filter *service.StreamTextFilter, data string) string {
+	if filter == nil {
+		return data
+	}
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+		return data
+	}
+	changed := false
+	for i := range streamResponse.Choices {
+		choice := &streamResponse.Choices[i]
+		content := choice.Delta.GetContentString()
+		if content == "" && choice.FinishReason == nil {
+			continue
+		}
+		checked, blocked := filter.Process(content)
+		if !blocked && choice.FinishReason != nil {
+			var rest string
+			rest, blocked = filter.Flush()
+			checked += rest
+		}
+		if blocked {
+			for j := range streamResponse.Choices {
+				streamResponse.Choices[j].Delta = dto.ChatCompletionsStreamResponseChoiceDelta{}
+				streamResponse.Choices[j].FinishReason = &constant.FinishReasonContentFilter
+			}
+			changed = true
+			break
+		}
+		if checked != content {
+			choice.Delta.SetContentString(checked)
+			changed = true
+		}
+	}
+	if !changed {
+		return data
+	}
+	jsonData, err := common.Marshal(streamResponse)
+	if err != nil {
+		return data
+	}
+	return string(jsonData)
+}
@@ -125,6 +125,7 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 
 	// 检查是否为音频模型
 	isAudioModel := strings.Contains(strings.ToLower(model), "audio")
+	filter := service.NewStreamTextFilter(c, info)
 
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		if lastStreamData != "" {
@@ -139,10 +140,12 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 				secondLastStreamData = lastStreamData
 			}
 
+			data = filterStreamData(filter, data)
 			lastStreamData = data
 			streamItems = append(streamItems, data)
 		}
-		return true
+		// 命中护栏拦截时，改写后的结束分片作为最后一条数据发送
+		return !filter.Blocked()
 	})
 
 	// 对音频模型，从倒数第二个stream data中提取usage信息
@@ -228,6 +231,9 @@ func OpenaiHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Respo
 	if oaiError := simpleResponse.GetOpenAIError(); oaiError != nil && oaiError.Type != "" {
 		return nil, types.WithOpenAIError(*oaiError, resp.StatusCode)
 	}
+	if newAPIError := service.CheckVirtualModelChatResponse(c, &simpleResponse); newAPIError != nil {
+		return nil, newAPIError
+	}
 
 	forceFormat := false
 	if info.ChannelSetting.ForceFormat {
@@ -288,6 +294,10 @@ func OpenaiHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Respo
 		responseBody = geminiRespStr
 	}
 
+	responseBody, newAPIError := service.CheckResponseGuardrail(c, info, responseBody)
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
 	service.IOCopyBytesGracefully(c, resp, responseBody)
 
 	return &simpleResponse.Usage, nil
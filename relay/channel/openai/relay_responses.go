@@ -41,6 +41,10 @@ func OaiResponsesHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 	}
 
 	// 写入新的 response body
+	responseBody, newAPIError := service.CheckResponseGuardrail(c, info, responseBody)
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
 	service.IOCopyBytesGracefully(c, resp, responseBody)
 
 	// compute usage
@@ -78,14 +82,21 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 
 	var usage = &dto.Usage{}
 	var responseTextBuilder strings.Builder
+	var createdResponse *dto.OpenAIResponsesResponse
+	filter := service.NewStreamTextFilter(c, info)
 
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 
 		// 检查当前数据是否包含 completed 状态和 usage 信息
 		var streamResponse dto.ResponsesStreamResponse
 		if err := common.UnmarshalJsonStr(data, &streamResponse); err == nil {
-			sendResponsesStreamData(c, streamResponse, data)
+			if !sendFilteredResponsesStreamData(c, filter, streamResponse, data) {
+				sendResponsesGuardrailStop(c, createdResponse)
+				return false
+			}
 			switch streamResponse.Type {
+			case "response.created":
+				createdResponse = streamResponse.Response
 			case "response.completed":
 				if streamResponse.Response != nil {
 					if streamResponse.Response.Usage != nil {
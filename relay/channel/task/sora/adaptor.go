@@ -18,6 +18,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 // ============================
@@ -108,6 +110,12 @@ func (a *TaskAdaptor) BuildRequestBody(c *gin.Context, info *relaycommon.RelayIn
 	if err != nil {
 		return nil, errors.Wrap(err, "get_request_body_failed")
 	}
+	// callback_url 由本系统处理，不透传给上游
+	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") && gjson.GetBytes(cachedBody, "callback_url").Exists() {
+		if stripped, err := sjson.DeleteBytes(cachedBody, "callback_url"); err == nil {
+			cachedBody = stripped
+		}
+	}
 	return bytes.NewReader(cachedBody), nil
 }
 
@@ -0,0 +1,83 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// switchToChatCompletions 临时将 info 切换为 chat/completions 请求，渠道按 OpenAI 格式转换请求与输出响应，
+// 返回的函数用于还原
+func switchToChatCompletions(info *relaycommon.RelayInfo) func() {
+	savedRelayMode := info.RelayMode
+	savedRequestURLPath := info.RequestURLPath
+	savedRelayFormat := info.RelayFormat
+
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RequestURLPath = "/v1/chat/completions"
+	info.RelayFormat = types.RelayFormatOpenAI
+
+	return func() {
+		info.RelayMode = savedRelayMode
+		info.RequestURLPath = savedRequestURLPath
+		info.RelayFormat = savedRelayFormat
+	}
+}
+
+// doChatCompletionsRequest 以 chat/completions 格式转换并发送请求，非 200 响应转换为错误返回
+func doChatCompletionsRequest(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, chatReq *dto.GeneralOpenAIRequest) (*http.Response, *types.NewAPIError) {
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(c, info, chatReq)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeJsonMarshalFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	jsonData, err = relaycommon.RemoveDisabledFields(jsonData, info.ChannelOtherSettings)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	if len(info.ParamOverride) > 0 {
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride, relaycommon.BuildParamOverrideContext(info))
+		if err != nil {
+			return nil, types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+		}
+	}
+
+	logger.LogDebug(c, fmt.Sprintf("chat completions intermediate request body: %s", string(jsonData)))
+
+	// 按渠道设置在转发前脱敏 PII
+	requestBody := service.RedactRequestPII(c, info, bytes.NewBuffer(jsonData))
+
+	resp, err := adaptor.DoRequest(c, info, requestBody)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	if resp == nil {
+		return nil, types.NewOpenAIError(nil, types.ErrorCodeBadResponse, http.StatusInternalServerError)
+	}
+
+	httpResp := resp.(*http.Response)
+	info.IsStream = info.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
+	if httpResp.StatusCode != http.StatusOK {
+		newApiErr := service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+		service.ResetStatusCode(newApiErr, c.GetString("status_code_mapping"))
+		return nil, newApiErr
+	}
+	return httpResp, nil
+}
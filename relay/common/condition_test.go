@@ -0,0 +1,66 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchConditions(t *testing.T) {
+	body := []byte(`{"model":"gpt-4o","stream":true,"prompt_tokens":99999,"messages":[{"role":"user"}]}`)
+	conditionContext := map[string]interface{}{
+		"prompt_tokens": 120,
+		"headers":       map[string]interface{}{"x-team": "search"},
+	}
+	cond := func(path, mode string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{"path": path, "mode": mode, "value": value}
+	}
+
+	tests := []struct {
+		name       string
+		conditions []interface{}
+		logic      string
+		context    map[string]interface{}
+		want       bool
+	}{
+		{name: "no conditions", want: true},
+		{name: "body path", conditions: []interface{}{cond("model", "prefix", "gpt-4")}, want: true},
+		{name: "ctx path", conditions: []interface{}{cond("ctx.prompt_tokens", "lt", 1000)}, context: conditionContext, want: true},
+		{name: "nested ctx path", conditions: []interface{}{cond("ctx.headers.x-team", "full", "search")}, context: conditionContext, want: true},
+		{name: "body cannot shadow ctx", conditions: []interface{}{cond("ctx.prompt_tokens", "gt", 1000)}, context: conditionContext},
+		{name: "body path ignores ctx", conditions: []interface{}{cond("prompt_tokens", "lt", 1000)}, context: conditionContext},
+		{name: "body path missing from body", conditions: []interface{}{cond("headers.x-team", "full", "search")}, context: conditionContext},
+		{name: "ctx path without context", conditions: []interface{}{cond("ctx.prompt_tokens", "lt", 1000)}},
+		{
+			name:       "ctx missing key passes",
+			conditions: []interface{}{map[string]interface{}{"path": "ctx.has_image", "mode": "full", "value": true, "pass_missing_key": true}},
+			context:    conditionContext,
+			want:       true,
+		},
+		{
+			name:       "ctx invert",
+			conditions: []interface{}{map[string]interface{}{"path": "ctx.prompt_tokens", "mode": "gt", "value": 1000, "invert": true}},
+			context:    conditionContext,
+			want:       true,
+		},
+		{
+			name:       "and requires all",
+			conditions: []interface{}{cond("stream", "full", true), cond("ctx.prompt_tokens", "gt", 1000)},
+			logic:      "AND",
+			context:    conditionContext,
+		},
+		{
+			name:       "or default",
+			conditions: []interface{}{cond("stream", "full", false), cond("ctx.prompt_tokens", "lt", 1000)},
+			context:    conditionContext,
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MatchConditions(body, tt.conditions, tt.logic, tt.context)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -88,29 +88,7 @@ func tryParseOperations(paramOverride map[string]interface{}) ([]ParamOperation,
 
 					// 解析条件
 					if conditions, exists := opMap["conditions"]; exists {
-						if condSlice, ok := conditions.([]interface{}); ok {
-							for _, cond := range condSlice {
-								if condMap, ok := cond.(map[string]interface{}); ok {
-									condition := ConditionOperation{}
-									if path, ok := condMap["path"].(string); ok {
-										condition.Path = path
-									}
-									if mode, ok := condMap["mode"].(string); ok {
-										condition.Mode = mode
-									}
-									if value, ok := condMap["value"]; ok {
-										condition.Value = value
-									}
-									if invert, ok := condMap["invert"].(bool); ok {
-										condition.Invert = invert
-									}
-									if passMissingKey, ok := condMap["pass_missing_key"].(bool); ok {
-										condition.PassMissingKey = passMissingKey
-									}
-									operation.Conditions = append(operation.Conditions, condition)
-								}
-							}
-						}
+						operation.Conditions = parseConditions(conditions)
 					}
 
 					operations = append(operations, operation)
@@ -125,6 +103,67 @@ func tryParseOperations(paramOverride map[string]interface{}) ([]ParamOperation,
 	return nil, false
 }
 
+func parseConditions(conditions interface{}) []ConditionOperation {
+	var result []ConditionOperation
+	if condSlice, ok := conditions.([]interface{}); ok {
+		for _, cond := range condSlice {
+			if condMap, ok := cond.(map[string]interface{}); ok {
+				condition := ConditionOperation{}
+				if path, ok := condMap["path"].(string); ok {
+					condition.Path = path
+				}
+				if mode, ok := condMap["mode"].(string); ok {
+					condition.Mode = mode
+				}
+				if value, ok := condMap["value"]; ok {
+					condition.Value = value
+				}
+				if invert, ok := condMap["invert"].(bool); ok {
+					condition.Invert = invert
+				}
+				if passMissingKey, ok := condMap["pass_missing_key"].(bool); ok {
+					condition.PassMissingKey = passMissingKey
+				}
+				result = append(result, condition)
+			}
+		}
+	}
+	return result
+}
+
+// ConditionContextPrefix 路由条件中请求特征路径的前缀，如 ctx.prompt_tokens，避免请求体中的同名字段伪造特征
+const ConditionContextPrefix = "ctx."
+
+// MatchConditions 使用参数覆盖的条件语法判断请求是否满足条件，ctx. 前缀的路径只在 conditionContext 中查找，其余路径只在请求体中查找
+func MatchConditions(jsonData []byte, conditions []interface{}, logic string, conditionContext map[string]interface{}) (bool, error) {
+	contextJSON := "{}"
+	if len(conditionContext) > 0 {
+		ctxBytes, err := common.Marshal(conditionContext)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal condition context: %v", err)
+		}
+		contextJSON = string(ctxBytes)
+	}
+	parsed := parseConditions(conditions)
+	if len(parsed) == 0 {
+		return true, nil
+	}
+	results := make([]bool, len(parsed))
+	for i, condition := range parsed {
+		source := string(jsonData)
+		if path, ok := strings.CutPrefix(condition.Path, ConditionContextPrefix); ok {
+			source = contextJSON
+			condition.Path = path
+		}
+		result, err := checkSingleCondition(source, "", condition)
+		if err != nil {
+			return false, err
+		}
+		results[i] = result
+	}
+	return combineConditionResults(results, logic), nil
+}
+
 func checkConditions(jsonStr, contextJSON string, conditions []ConditionOperation, logic string) (bool, error) {
 	if len(conditions) == 0 {
 		return true, nil // 没有条件，直接通过
@@ -137,21 +176,24 @@ func checkConditions(jsonStr, contextJSON string, conditions []ConditionOperatio
 		}
 		results[i] = result
 	}
+	return combineConditionResults(results, logic), nil
+}
 
+func combineConditionResults(results []bool, logic string) bool {
 	if strings.ToUpper(logic) == "AND" {
 		for _, result := range results {
 			if !result {
-				return false, nil
+				return false
 			}
 		}
-		return true, nil
+		return true
 	} else {
 		for _, result := range results {
 			if result {
-				return true, nil
+				return true
 			}
 		}
-		return false, nil
+		return false
 	}
 }
 
@@ -601,3 +643,48 @@ func BuildParamOverrideContext(info *RelayInfo) map[string]interface{} {
 	ctx["is_channel_test"] = info.IsChannelTest
 	return ctx
 }
+
+// mergeParamOverride 将 extra 叠加在 base 之后：同为旧格式时按键覆盖，
+// 否则统一转为操作格式并按 base、extra 的顺序拼接
+func mergeParamOverride(base map[string]interface{}, extra map[string]interface{}) map[string]interface{} {
+	if len(extra) == 0 {
+		return base
+	}
+	if len(base) == 0 {
+		return extra
+	}
+	baseOps, baseIsOps := base["operations"].([]interface{})
+	extraOps, extraIsOps := extra["operations"].([]interface{})
+	if !baseIsOps && !extraIsOps {
+		merged := make(map[string]interface{}, len(base)+len(extra))
+		for k, v := range base {
+			merged[k] = v
+		}
+		for k, v := range extra {
+			merged[k] = v
+		}
+		return merged
+	}
+	if !baseIsOps {
+		baseOps = legacyOverrideToOperations(base)
+	}
+	if !extraIsOps {
+		extraOps = legacyOverrideToOperations(extra)
+	}
+	operations := make([]interface{}, 0, len(baseOps)+len(extraOps))
+	operations = append(operations, baseOps...)
+	operations = append(operations, extraOps...)
+	return map[string]interface{}{"operations": operations}
+}
+
+func legacyOverrideToOperations(paramOverride map[string]interface{}) []interface{} {
+	operations := make([]interface{}, 0, len(paramOverride))
+	for key, value := range paramOverride {
+		operations = append(operations, map[string]interface{}{
+			"path":  key,
+			"mode":  "set",
+			"value": value,
+		})
+	}
+	return operations
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/QuantumNous/new-api/dto"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -39,6 +40,11 @@ type ClaudeConvertInfo struct {
 	Done             bool
 }
 
+// GeminiConvertInfo 流式转换为 Gemini 格式时累积的工具调用，结束时整体输出
+type GeminiConvertInfo struct {
+	ToolCalls []*dto.ToolCallResponse
+}
+
 type RerankerInfo struct {
 	Documents       []any
 	ReturnDocuments bool
@@ -84,9 +90,14 @@ type RelayInfo struct {
 	TokenKey          string
 	TokenGroup        string
 	UserId            int
+	RequestId         string // 请求 ID，作为额度流水的关联单号
 	UsingGroup        string // 使用的分组，当auto跨分组重试时，会变动
 	UserGroup         string // 用户所在分组
 	TokenUnlimited    bool
+	ProjectId         int // 令牌所属项目，非 0 时使用组织或项目额度计费
+	OrgId             int // 令牌所属组织
+	TokenBudget       operation_setting.Budget
+	UserBudget        operation_setting.Budget
 	StartTime         time.Time
 	FirstResponseTime time.Time
 	isFirstResponse   bool
@@ -113,9 +124,11 @@ type RelayInfo struct {
 	UserQuota              int
 	RelayFormat            types.RelayFormat
 	SendResponseCount      int
-	FinalPreConsumedQuota  int  // 最终预消耗的配额
-	IsClaudeBetaQuery      bool // /v1/messages?beta=true
-	IsChannelTest          bool // channel test request
+	FinalPreConsumedQuota  int                 // 最终预消耗的配额
+	BillingCharge          types.BillingCharge // 本次请求已扣额度的来源拆分，退款时按来源退回
+	IsClaudeBetaQuery      bool                // /v1/messages?beta=true
+	IsChannelTest          bool                // channel test request
+	ResponseCacheHit       bool                // 命中响应缓存，未请求上游
 
 	PriceData types.PriceData
 
@@ -124,6 +137,7 @@ type RelayInfo struct {
 	ThinkingContentInfo
 	TokenCountMeta
 	*ClaudeConvertInfo
+	*GeminiConvertInfo
 	*RerankerInfo
 	*ResponsesUsageInfo
 	*ChannelMeta
@@ -133,6 +147,8 @@ type RelayInfo struct {
 func (info *RelayInfo) InitChannelMeta(c *gin.Context) {
 	channelType := common.GetContextKeyInt(c, constant.ContextKeyChannelType)
 	paramOverride := common.GetContextKeyStringMap(c, constant.ContextKeyChannelParamOverride)
+	// 虚拟模型当前步骤的参数覆盖叠加在渠道覆盖之后
+	paramOverride = mergeParamOverride(paramOverride, common.GetContextKeyStringMap(c, constant.ContextKeyVirtualModelParamOverride))
 	headerOverride := common.GetContextKeyStringMap(c, constant.ContextKeyChannelHeaderOverride)
 	apiType, _ := common.ChannelType2APIType(channelType)
 	channelMeta := &ChannelMeta{
@@ -355,6 +371,7 @@ func GenRelayInfoGemini(c *gin.Context, request dto.Request) *RelayInfo {
 	info := genBaseRelayInfo(c, request)
 	info.RelayFormat = types.RelayFormatGemini
 	info.ShouldIncludeUsage = false
+	info.GeminiConvertInfo = &GeminiConvertInfo{}
 
 	return info
 }
@@ -400,6 +417,7 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		Request: request,
 
 		UserId:     common.GetContextKeyInt(c, constant.ContextKeyUserId),
+		RequestId:  c.GetString(common.RequestIdKey),
 		UsingGroup: common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
 		UserGroup:  common.GetContextKeyString(c, constant.ContextKeyUserGroup),
 		UserQuota:  common.GetContextKeyInt(c, constant.ContextKeyUserQuota),
@@ -411,6 +429,8 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		TokenKey:       common.GetContextKeyString(c, constant.ContextKeyTokenKey),
 		TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
 		TokenGroup:     tokenGroup,
+		ProjectId:      common.GetContextKeyInt(c, constant.ContextKeyTokenProjectId),
+		OrgId:          common.GetContextKeyInt(c, constant.ContextKeyTokenOrgId),
 
 		isFirstResponse: true,
 		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
@@ -443,6 +463,8 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 	if ok {
 		info.UserSetting = userSetting
 	}
+	info.TokenBudget, _ = common.GetContextKeyType[operation_setting.Budget](c, constant.ContextKeyTokenBudget)
+	info.UserBudget, _ = common.GetContextKeyType[operation_setting.Budget](c, constant.ContextKeyUserBudget)
 
 	return info
 }
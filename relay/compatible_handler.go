@@ -180,6 +180,9 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		requestBody = bytes.NewBuffer(jsonData)
 	}
 
+	// 按渠道设置在转发前脱敏 PII
+	requestBody = service.RedactRequestPII(c, info, requestBody)
+
 	var httpResp *http.Response
 	resp, err := adaptor.DoRequest(c, info, requestBody)
 	if err != nil {
@@ -228,6 +231,11 @@ func shouldChatCompletionsViaResponses(info *relaycommon.RelayInfo) bool {
 }
 
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent ...string) {
+	_, span := common.StartSpan(ctx, "relay.post_consume")
+	defer span.End()
+	if usage != nil {
+		common.SetContextKey(ctx, constant.ContextKeyRelayUsage, usage)
+	}
 	if usage == nil {
 		usage = &dto.Usage{
 			PromptTokens:     relayInfo.GetEstimatePromptTokens(),
@@ -236,6 +244,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		}
 		extraContent = append(extraContent, "上游无计费信息")
 	}
+	service.RecordUsageTokens(ctx, usage.PromptTokens+usage.CompletionTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	cacheTokens := usage.PromptTokensDetails.CachedTokens
@@ -247,6 +256,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 	modelName := relayInfo.OriginModelName
 
 	tokenName := ctx.GetString("token_name")
+	ratio_setting.ApplyPriceTier(&relayInfo.PriceData, modelName, promptTokens)
 	completionRatio := relayInfo.PriceData.CompletionRatio
 	cacheRatio := relayInfo.PriceData.CacheRatio
 	imageRatio := relayInfo.PriceData.ImageRatio
@@ -402,6 +412,11 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 			extraContent = append(extraContent, fmt.Sprintf("其他倍率 %s: %f", key, otherRatio))
 		}
 	}
+	if relayInfo.ResponseCacheHit {
+		hitRatio := operation_setting.GetResponseCacheSetting().HitQuotaRatio
+		quotaCalculateDecimal = quotaCalculateDecimal.Mul(decimal.NewFromFloat(hitRatio))
+		extraContent = append(extraContent, fmt.Sprintf("响应缓存命中倍率: %f", hitRatio))
+	}
 
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
 	totalTokens := promptTokens + completionTokens
@@ -417,11 +432,15 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		logger.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, relayInfo.FinalPreConsumedQuota))
 	} else {
-		if !ratio.IsZero() && quota == 0 {
+		// 响应缓存命中倍率为 0 时不计费
+		if !ratio.IsZero() && quota == 0 && !relayInfo.ResponseCacheHit {
 			quota = 1
 		}
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		// 响应缓存命中时没有请求任何渠道
+		if !relayInfo.ResponseCacheHit {
+			model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		}
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -136,8 +136,18 @@ func GeminiHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		}
 	}
 
+	passThrough := model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled
+	if !passThrough && shouldGeminiViaChatCompletions(info) {
+		usage, newAPIError := geminiViaChatCompletions(c, info, adaptor, request)
+		if newAPIError != nil {
+			return newAPIError
+		}
+		postConsumeQuota(c, info, usage)
+		return nil
+	}
+
 	var requestBody io.Reader
-	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+	if passThrough {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
@@ -167,6 +177,9 @@ func GeminiHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		requestBody = bytes.NewReader(jsonData)
 	}
 
+	// 按渠道设置在转发前脱敏 PII
+	requestBody = service.RedactRequestPII(c, info, requestBody)
+
 	resp, err := adaptor.DoRequest(c, info, requestBody)
 	if err != nil {
 		logger.LogError(c, "Do gemini request failed: "+err.Error())
@@ -295,3 +308,32 @@ func GeminiEmbeddingHandler(c *gin.Context, info *relaycommon.RelayInfo) (newAPI
 	postConsumeQuota(c, info, usage.(*dto.Usage))
 	return nil
 }
+
+// GeminiCountTokensHelper 本地估算 countTokens，不请求上游也不计费。
+// 文本按模型分词器计算，每个媒体文件按 Gemini 单张图片的 258 tokens 估算
+func GeminiCountTokensHelper(c *gin.Context, request dto.Request) *types.NewAPIError {
+	geminiReq, ok := request.(*dto.GeminiChatRequest)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.GeminiChatRequest, got %T", request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	meta := geminiReq.GetTokenCountMeta()
+	texts := []string{meta.CombineText}
+	if geminiReq.SystemInstructions != nil {
+		for _, part := range geminiReq.SystemInstructions.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+	}
+	if len(geminiReq.Tools) > 0 {
+		texts = append(texts, string(geminiReq.Tools))
+	}
+
+	modelName := common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
+	totalTokens := service.CountTextToken(strings.Join(texts, "\n"), modelName)
+	totalTokens += len(meta.Files) * 258
+
+	c.JSON(http.StatusOK, dto.GeminiCountTokensResponse{TotalTokens: totalTokens})
+	return nil
+}
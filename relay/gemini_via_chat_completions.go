@@ -0,0 +1,52 @@
+package relay
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shouldGeminiViaChatCompletions 适配器未实现 Gemini 原生接口时经 chat/completions 转发
+func shouldGeminiViaChatCompletions(info *relaycommon.RelayInfo) bool {
+	switch info.ApiType {
+	case constant.APITypeGemini, constant.APITypeVertexAi, constant.APITypeOpenAI, constant.APITypeCodex:
+		return false
+	}
+	return true
+}
+
+func geminiViaChatCompletions(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.GeminiChatRequest) (*dto.Usage, *types.NewAPIError) {
+	chatReq, err := service.GeminiToOpenAIRequest(request, info)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if info.IsStream && info.SupportStreamOptions {
+		chatReq.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+
+	// 渠道按 chat/completions 请求转换并输出 OpenAI 格式，再由 GeminiViaChatWriter 翻译
+	defer switchToChatCompletions(info)()
+
+	httpResp, newApiErr := doChatCompletionsRequest(c, info, adaptor, chatReq)
+	if newApiErr != nil {
+		return nil, newApiErr
+	}
+
+	writer := service.NewGeminiViaChatWriter(c, info, info.IsStream)
+	usageAny, newApiErr := adaptor.DoResponse(c, httpResp, info)
+	if newApiErr != nil {
+		writer.Finish(nil, true)
+		service.ResetStatusCode(newApiErr, c.GetString("status_code_mapping"))
+		return nil, newApiErr
+	}
+	usage, _ := usageAny.(*dto.Usage)
+	writer.Finish(usage, false)
+	return usage, nil
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
@@ -42,6 +44,13 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 		groupRatioInfo.GroupRatio = ratio_setting.GetGroupRatio(relayInfo.UsingGroup)
 	}
 
+	// 订阅套餐额度用完后按套餐的超额系数计费
+	if relayInfo.ProjectId == 0 {
+		if overageRatio := model.GetSubscriptionOverageRatio(relayInfo.UserId); overageRatio != 1 {
+			groupRatioInfo.GroupRatio *= overageRatio
+		}
+	}
+
 	return groupRatioInfo
 }
 
@@ -61,6 +70,7 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 	var audioRatio float64
 	var audioCompletionRatio float64
 	var freeModel bool
+	var priceTier *types.PriceTier
 	if !usePrice {
 		preConsumedTokens := common.Max(promptTokens, common.PreConsumedQuota)
 		if meta.MaxTokens != 0 {
@@ -87,6 +97,11 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		imageRatio, _ = ratio_setting.GetImageRatio(info.OriginModelName)
 		audioRatio = ratio_setting.GetAudioRatio(info.OriginModelName)
 		audioCompletionRatio = ratio_setting.GetAudioCompletionRatio(info.OriginModelName)
+		// 按预估的提示词长度选择分级倍率，结算时按实际用量重新选择
+		if tier, ok := ratio_setting.GetPriceTier(info.OriginModelName, promptTokens); ok {
+			modelRatio, completionRatio, cacheRatio = tier.Apply(modelRatio, completionRatio, cacheRatio)
+			priceTier = &tier
+		}
 		ratio := modelRatio * groupRatioInfo.GroupRatio
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	} else {
@@ -130,6 +145,16 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		CacheCreation5mRatio: cacheCreationRatio5m,
 		CacheCreation1hRatio: cacheCreationRatio1h,
 		QuotaToPreConsume:    preConsumedQuota,
+		Tier:                 priceTier,
+	}
+
+	// 批处理请求按折扣倍率计费
+	if common.GetContextKeyString(c, constant.ContextKeyBatchId) != "" {
+		batchRatio := operation_setting.GetBatchDiscountRatio()
+		if batchRatio != 1 {
+			priceData.AddOtherRatio("batch", batchRatio)
+			priceData.QuotaToPreConsume = int(float64(priceData.QuotaToPreConsume) * batchRatio)
+		}
 	}
 
 	if common.DebugEnabled {
@@ -0,0 +1,75 @@
+package helper
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupPriceTestDB 使用内存 SQLite 替换 model 的全局 DB，计费时查询订阅套餐
+func setupPriceTestDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.Subscription{}))
+	origDB, origRedis := model.DB, common.RedisEnabled
+	t.Cleanup(func() {
+		model.DB, common.RedisEnabled = origDB, origRedis
+	})
+	model.DB = db
+	common.RedisEnabled = false
+}
+
+func TestModelPriceHelperBatchDiscount(t *testing.T) {
+	setupPriceTestDB(t)
+	setting := operation_setting.GetBatchSetting()
+	origRatio := setting.DiscountRatio
+	t.Cleanup(func() { setting.DiscountRatio = origRatio })
+
+	tests := []struct {
+		name          string
+		batchId       string
+		discountRatio float64
+		wantRatio     float64
+	}{
+		{name: "not a batch request", discountRatio: 0.5, wantRatio: 1},
+		{name: "batch discount", batchId: "batch_1", discountRatio: 0.5, wantRatio: 0.5},
+		{name: "no discount", batchId: "batch_1", discountRatio: 1, wantRatio: 1},
+		{name: "invalid ratio is ignored", batchId: "batch_1", discountRatio: 0, wantRatio: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setting.DiscountRatio = tt.discountRatio
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if tt.batchId != "" {
+				common.SetContextKey(c, constant.ContextKeyBatchId, tt.batchId)
+			}
+			info := &relaycommon.RelayInfo{
+				OriginModelName: "batch-discount-test-model",
+				UserId:          1,
+				UsingGroup:      "default",
+			}
+			info.UserSetting.AcceptUnsetRatioModel = true
+
+			priceData, err := ModelPriceHelper(c, info, 1000, &types.TokenCountMeta{})
+			require.NoError(t, err)
+			base := int(float64(max(1000, common.PreConsumedQuota)) * priceData.ModelRatio * priceData.GroupRatioInfo.GroupRatio)
+			require.Equal(t, int(float64(base)*tt.wantRatio), priceData.QuotaToPreConsume)
+			if tt.wantRatio == 1 {
+				require.NotContains(t, priceData.OtherRatios, "batch")
+			} else {
+				require.Equal(t, tt.wantRatio, priceData.OtherRatios["batch"])
+			}
+		})
+	}
+}
@@ -27,6 +27,8 @@ func GetAndValidateRequest(c *gin.Context, format types.RelayFormat) (request dt
 			request, err = GetAndValidateGeminiEmbeddingRequest(c)
 		} else if strings.Contains(c.Request.URL.Path, ":batchEmbedContents") {
 			request, err = GetAndValidateGeminiBatchEmbeddingRequest(c)
+		} else if strings.Contains(c.Request.URL.Path, ":countTokens") {
+			request, err = GetAndValidateGeminiCountTokensRequest(c)
 		} else {
 			request, err = GetAndValidateGeminiRequest(c)
 		}
@@ -308,6 +310,23 @@ func GetAndValidateGeminiRequest(c *gin.Context) (*dto.GeminiChatRequest, error)
 	return request, nil
 }
 
+// GetAndValidateGeminiCountTokensRequest 解析 countTokens 请求，body 可以是 contents 或完整的 generateContentRequest
+func GetAndValidateGeminiCountTokensRequest(c *gin.Context) (*dto.GeminiChatRequest, error) {
+	request := &dto.GeminiCountTokensRequest{}
+	err := common.UnmarshalBodyReusable(c, request)
+	if err != nil {
+		return nil, err
+	}
+	chatRequest := request.GenerateContentRequest
+	if chatRequest == nil {
+		chatRequest = &dto.GeminiChatRequest{Contents: request.Contents}
+	}
+	if len(chatRequest.Contents) == 0 {
+		return nil, errors.New("contents is required")
+	}
+	return chatRequest, nil
+}
+
 func GetAndValidateGeminiEmbeddingRequest(c *gin.Context) (*dto.GeminiEmbeddingRequest, error) {
 	request := &dto.GeminiEmbeddingRequest{}
 	err := common.UnmarshalBodyReusable(c, request)
@@ -34,6 +34,10 @@ func RelayMidjourneyImage(c *gin.Context) {
 		})
 		return
 	}
+	if artifact, err := model.GetArtifactByTask(model.ArtifactTaskTypeMidjourney, midjourneyTask.MjId); err == nil && !artifact.IsExpired() {
+		c.Redirect(http.StatusFound, service.SignArtifactUrl(artifact))
+		return
+	}
 	var httpClient *http.Client
 	if channel, err := model.CacheGetChannel(midjourneyTask.ChannelId); err == nil {
 		proxy := channel.GetSetting().Proxy
@@ -123,6 +127,11 @@ func RelayMidjourneyNotify(c *gin.Context) *dto.MidjourneyResponse {
 	return nil
 }
 
+// MidjourneyModel2Dto 转换为查询接口返回的任务信息
+func MidjourneyModel2Dto(task *model.Midjourney) dto.MidjourneyDto {
+	return coverMidjourneyTaskDto(nil, task)
+}
+
 func coverMidjourneyTaskDto(c *gin.Context, originTask *model.Midjourney) (midjourneyTask dto.MidjourneyDto) {
 	midjourneyTask.MjId = originTask.MjId
 	midjourneyTask.Progress = originTask.Progress
@@ -184,11 +193,15 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 	if swapFaceRequest.SourceBase64 == "" || swapFaceRequest.TargetBase64 == "" {
 		return service.MidjourneyErrorWrapper(constant.MjRequestError, "sour_base64_and_target_base64_is_required")
 	}
+	callbackTarget, err := service.GetTaskCallbackTarget(c, info.UserId)
+	if err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, "invalid_callback_url")
+	}
 	modelName := service.CoverActionToModelName(constant.MjActionSwapFace)
 
 	priceData := helper.ModelPriceHelperPerCall(c, info)
 
-	userQuota, err := model.GetUserQuota(info.UserId, false)
+	userQuota, err := model.GetBillingQuota(info.UserId, info.ProjectId)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -202,6 +215,12 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 			Description: "quota_not_enough",
 		}
 	}
+	if err := service.CheckBudget(info, priceData.Quota); err != nil {
+		return &dto.MidjourneyResponse{
+			Code:        4,
+			Description: err.Error(),
+		}
+	}
 	requestURL := getMjRequestPath(c.Request.URL.String())
 	baseURL := c.GetString("base_url")
 	fullRequestURL := fmt.Sprintf("%s%s", baseURL, requestURL)
@@ -209,12 +228,18 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 	if err != nil {
 		return &mjResp.Response
 	}
+	var midjourneyTask *model.Midjourney
 	defer func() {
 		if mjResp.StatusCode == 200 && mjResp.Response.Code == 1 {
 			err := service.PostConsumeQuota(info, priceData.Quota, 0, true)
 			if err != nil {
 				common.SysLog("error consuming token remain quota: " + err.Error())
 			}
+			if midjourneyTask != nil {
+				if err := midjourneyTask.UpdateSubscriptionQuota(info.BillingCharge.Subscription); err != nil {
+					common.SysLog("error updating midjourney task subscription quota: " + err.Error())
+				}
+			}
 
 			tokenName := c.GetString("token_name")
 			logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", priceData.ModelPrice, priceData.GroupRatioInfo.GroupRatio, constant.MjActionSwapFace)
@@ -234,8 +259,9 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 		}
 	}()
 	midjResponse := &mjResp.Response
-	midjourneyTask := &model.Midjourney{
+	midjourneyTask = &model.Midjourney{
 		UserId:      info.UserId,
+		ProjectId:   info.ProjectId,
 		Code:        midjResponse.Code,
 		Action:      constant.MjActionSwapFace,
 		MjId:        midjResponse.Result,
@@ -253,6 +279,7 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 		ChannelId:   c.GetInt("channel_id"),
 		Quota:       priceData.Quota,
 	}
+	midjourneyTask.SetCallbackTarget(callbackTarget)
 	err = midjourneyTask.Insert()
 	if err != nil {
 		return service.MidjourneyErrorWrapper(constant.MjRequestError, "insert_midjourney_task_failed")
@@ -377,6 +404,11 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 
 	relayInfo.InitChannelMeta(c)
 
+	callbackTarget, err := service.GetTaskCallbackTarget(c, relayInfo.UserId)
+	if err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, "invalid_callback_url")
+	}
+
 	if relayInfo.RelayMode == relayconstant.RelayModeMidjourneyAction { // midjourney plus，需要从customId中获取任务信息
 		mjErr := service.CoverPlusActionToNormalAction(&midjRequest)
 		if mjErr != nil {
@@ -489,7 +521,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 
 	priceData := helper.ModelPriceHelperPerCall(c, relayInfo)
 
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetBillingQuota(relayInfo.UserId, relayInfo.ProjectId)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -503,6 +535,14 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 			Description: "quota_not_enough",
 		}
 	}
+	if consumeQuota {
+		if err := service.CheckBudget(relayInfo, priceData.Quota); err != nil {
+			return &dto.MidjourneyResponse{
+				Code:        4,
+				Description: err.Error(),
+			}
+		}
+	}
 
 	midjResponseWithStatus, responseBody, err := service.DoMidjourneyHttpRequest(c, time.Second*60, fullRequestURL)
 	if err != nil {
@@ -510,12 +550,18 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 	}
 	midjResponse := &midjResponseWithStatus.Response
 
+	var midjourneyTask *model.Midjourney
 	defer func() {
 		if consumeQuota && midjResponseWithStatus.StatusCode == 200 {
 			err := service.PostConsumeQuota(relayInfo, priceData.Quota, 0, true)
 			if err != nil {
 				common.SysLog("error consuming token remain quota: " + err.Error())
 			}
+			if midjourneyTask != nil {
+				if err := midjourneyTask.UpdateSubscriptionQuota(relayInfo.BillingCharge.Subscription); err != nil {
+					common.SysLog("error updating midjourney task subscription quota: " + err.Error())
+				}
+			}
 			tokenName := c.GetString("token_name")
 			logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s，ID %s", priceData.ModelPrice, priceData.GroupRatioInfo.GroupRatio, midjRequest.Action, midjResponse.Result)
 			other := service.GenerateMjOtherInfo(relayInfo, priceData)
@@ -541,8 +587,9 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 	// 23-队列已满，请稍后再试 {"code":23,"description":"队列已满，请稍后尝试","result":"14001929738841620","properties":{"discordInstanceId":"1118138338562560102"}}
 	// 24-prompt包含敏感词 {"code":24,"description":"可能包含敏感词","properties":{"promptEn":"nude body","bannedWord":"nude"}}
 	// other: 提交错误，description为错误描述
-	midjourneyTask := &model.Midjourney{
+	midjourneyTask = &model.Midjourney{
 		UserId:      relayInfo.UserId,
+		ProjectId:   relayInfo.ProjectId,
 		Code:        midjResponse.Code,
 		Action:      midjRequest.Action,
 		MjId:        midjResponse.Result,
@@ -560,6 +607,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 		ChannelId:   c.GetInt("channel_id"),
 		Quota:       priceData.Quota,
 	}
+	midjourneyTask.SetCallbackTarget(callbackTarget)
 	if midjResponse.Code == 3 {
 		//无实例账号自动禁用渠道（No available account instance）
 		channel, err := model.GetChannelById(midjourneyTask.ChannelId, true)
@@ -48,6 +48,11 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 
 	platform := constant.TaskPlatform(c.GetString("platform"))
 
+	callbackTarget, err := service.GetTaskCallbackTarget(c, info.UserId)
+	if err != nil {
+		return service.TaskErrorWrapperLocal(err, "invalid_callback_url", http.StatusBadRequest)
+	}
+
 	// 获取原始任务信息
 	if info.OriginTaskID != "" {
 		originTask, exist, err := model.GetByTaskId(info.UserId, info.OriginTaskID)
@@ -178,7 +183,7 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 		}
 	}
 	println(fmt.Sprintf("model: %s, model_price: %.4f, group: %s, group_ratio: %.4f, final_ratio: %.4f", modelName, modelPrice, info.UsingGroup, groupRatio, ratio))
-	userQuota, err := model.GetUserQuota(info.UserId, false)
+	userQuota, err := model.GetBillingQuota(info.UserId, info.ProjectId)
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 		return
@@ -188,6 +193,10 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 		taskErr = service.TaskErrorWrapperLocal(errors.New("user quota is not enough"), "quota_not_enough", http.StatusForbidden)
 		return
 	}
+	if err := service.CheckBudget(info, quota); err != nil {
+		taskErr = service.TaskErrorWrapperLocal(err, "budget_not_enough", http.StatusForbidden)
+		return
+	}
 
 	// build body
 	requestBody, err := adaptor.BuildRequestBody(c, info)
@@ -208,6 +217,7 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 		return
 	}
 
+	var task *model.Task
 	defer func() {
 		// release quota
 		if info.ConsumeQuota && taskErr == nil {
@@ -216,6 +226,11 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 			if err != nil {
 				common.SysLog("error consuming token remain quota: " + err.Error())
 			}
+			if task != nil {
+				if err := task.UpdateSubscriptionQuota(info.BillingCharge.Subscription); err != nil {
+					common.SysLog("error updating task subscription quota: " + err.Error())
+				}
+			}
 			if quota != 0 {
 				tokenName := c.GetString("token_name")
 				//gRatio := groupRatio
@@ -270,11 +285,12 @@ func RelayTaskSubmit(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.
 	}
 	info.ConsumeQuota = true
 	// insert task
-	task := model.InitTask(platform, info)
+	task = model.InitTask(platform, info)
 	task.TaskID = taskID
 	task.Quota = quota
 	task.Data = taskData
 	task.Action = info.Action
+	task.PrivateData.SetCallbackTarget(callbackTarget)
 	err = task.Insert()
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "insert_task_failed", http.StatusInternalServerError)
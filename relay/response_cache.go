@@ -0,0 +1,87 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseCacheHelper 命中响应缓存时直接返回缓存内容，按缓存的用量和命中倍率计费
+func ResponseCacheHelper(c *gin.Context, info *relaycommon.RelayInfo, entry *service.ResponseCacheEntry) *types.NewAPIError {
+	info.ChannelMeta = &relaycommon.ChannelMeta{UpstreamModelName: info.OriginModelName}
+	info.ResponseCacheHit = true
+
+	if info.IsStream && !entry.Stream {
+		var response dto.OpenAITextResponse
+		if err := common.UnmarshalJsonStr(entry.Body, &response); err != nil {
+			return types.NewError(fmt.Errorf("failed to parse cached response: %w", err), types.ErrorCodeBadResponseBody, types.ErrOptionWithSkipRetry())
+		}
+		info.SetFirstResponseTime()
+		replayChatCompletionAsStream(c, info, &response)
+	} else if info.IsStream {
+		helper.SetEventStreamHeaders(c)
+		info.SetFirstResponseTime()
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.WriteString(entry.Body)
+		_ = helper.FlushWriter(c)
+	} else {
+		info.SetFirstResponseTime()
+		c.Data(http.StatusOK, "application/json", []byte(entry.Body))
+	}
+
+	usage := entry.Usage
+	postConsumeQuota(c, info, &usage)
+	return nil
+}
+
+// replayChatCompletionAsStream 将缓存的非流式 chat completions 响应按 SSE 返回
+func replayChatCompletionAsStream(c *gin.Context, info *relaycommon.RelayInfo, response *dto.OpenAITextResponse) {
+	helper.SetEventStreamHeaders(c)
+	createdAt := common.GetTimestamp()
+	for _, choice := range response.Choices {
+		delta := dto.ChatCompletionsStreamResponseChoiceDelta{Role: "assistant"}
+		if content := choice.Message.StringContent(); content != "" {
+			delta.SetContentString(content)
+		}
+		if choice.Message.ReasoningContent != "" {
+			reasoning := choice.Message.ReasoningContent
+			delta.ReasoningContent = &reasoning
+		}
+		if len(choice.Message.ToolCalls) > 0 {
+			var toolCalls []dto.ToolCallResponse
+			if err := common.Unmarshal(choice.Message.ToolCalls, &toolCalls); err == nil {
+				for i := range toolCalls {
+					toolCalls[i].SetIndex(i)
+				}
+				delta.ToolCalls = toolCalls
+			}
+		}
+		_ = helper.ObjectData(c, &dto.ChatCompletionsStreamResponse{
+			Id:      response.Id,
+			Object:  "chat.completion.chunk",
+			Created: createdAt,
+			Model:   response.Model,
+			Choices: []dto.ChatCompletionsStreamResponseChoice{{Delta: delta, Index: choice.Index}},
+		})
+		finishReason := choice.FinishReason
+		_ = helper.ObjectData(c, &dto.ChatCompletionsStreamResponse{
+			Id:      response.Id,
+			Object:  "chat.completion.chunk",
+			Created: createdAt,
+			Model:   response.Model,
+			Choices: []dto.ChatCompletionsStreamResponseChoice{{FinishReason: &finishReason, Index: choice.Index}},
+		})
+	}
+	if request, ok := info.Request.(*dto.GeneralOpenAIRequest); ok && request.StreamOptions != nil && request.StreamOptions.IncludeUsage {
+		_ = helper.ObjectData(c, helper.GenerateFinalUsageResponse(response.Id, createdAt, response.Model, response.Usage))
+	}
+	helper.Done(c)
+}
@@ -0,0 +1,132 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupRelayTestDB 使用内存 SQLite 替换 model 的全局 DB 并关闭 Redis，测试结束后恢复
+func setupRelayTestDB(t *testing.T, models ...any) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(models...))
+	origDB, origLogDB := model.DB, model.LOG_DB
+	origSQLite, origRedis := common.UsingSQLite, common.RedisEnabled
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB = origDB, origLogDB
+		common.UsingSQLite, common.RedisEnabled = origSQLite, origRedis
+	})
+	model.DB, model.LOG_DB = db, db
+	common.UsingSQLite = true
+	common.RedisEnabled = false
+	return db
+}
+
+func TestResponseCacheHelper(t *testing.T) {
+	const cachedBody = `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":100,"total_tokens":200}}`
+	usage := dto.Usage{PromptTokens: 100, CompletionTokens: 100, TotalTokens: 200}
+	tests := []struct {
+		name         string
+		hitRatio     float64
+		stream       bool
+		includeUsage bool
+		wantQuota    int
+		wantBody     []string
+		notWantBody  []string
+	}{
+		{name: "free hit", hitRatio: 0, wantQuota: 0, wantBody: []string{cachedBody}},
+		{name: "discounted hit", hitRatio: 0.5, wantQuota: 100, wantBody: []string{cachedBody}},
+		{
+			name:        "json replayed as stream",
+			hitRatio:    0.5,
+			stream:      true,
+			wantQuota:   100,
+			wantBody:    []string{`"content":"hello"`, `"finish_reason":"stop"`, "data: [DONE]"},
+			notWantBody: []string{`"total_tokens":200`},
+		},
+		{
+			name:         "stream replay includes usage when requested",
+			hitRatio:     0.5,
+			stream:       true,
+			includeUsage: true,
+			wantQuota:    100,
+			wantBody:     []string{`"content":"hello"`, `"total_tokens":200`, "data: [DONE]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupRelayTestDB(t, &model.User{}, &model.Subscription{}, &model.QuotaLedger{}, &model.Log{})
+			require.NoError(t, db.Create(&model.User{Id: 1, Username: "u1", Quota: 1000}).Error)
+			setting := operation_setting.GetResponseCacheSetting()
+			origRatio := setting.HitQuotaRatio
+			t.Cleanup(func() { setting.HitQuotaRatio = origRatio })
+			setting.HitQuotaRatio = tt.hitRatio
+
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			request := &dto.GeneralOpenAIRequest{Model: "gpt-4o", Stream: tt.stream}
+			if tt.includeUsage {
+				request.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+			}
+			info := &relaycommon.RelayInfo{
+				UserId:          1,
+				IsPlayground:    true,
+				IsStream:        tt.stream,
+				OriginModelName: "gpt-4o",
+				UsingGroup:      "default",
+				RelayFormat:     types.RelayFormatOpenAI,
+				Request:         request,
+				StartTime:       time.Now(),
+				PriceData: types.PriceData{
+					ModelRatio:      1,
+					CompletionRatio: 1,
+					GroupRatioInfo:  types.GroupRatioInfo{GroupRatio: 1},
+				},
+			}
+			entry := &service.ResponseCacheEntry{Body: cachedBody, Usage: usage}
+
+			require.Nil(t, ResponseCacheHelper(c, info, entry))
+			require.True(t, info.ResponseCacheHit)
+			require.Equal(t, http.StatusOK, recorder.Code)
+			for _, want := range tt.wantBody {
+				require.Contains(t, recorder.Body.String(), want)
+			}
+			for _, notWant := range tt.notWantBody {
+				require.NotContains(t, recorder.Body.String(), notWant)
+			}
+
+			var user model.User
+			require.NoError(t, db.First(&user, 1).Error)
+			require.Equal(t, 1000-tt.wantQuota, user.Quota)
+			require.Equal(t, tt.wantQuota, user.UsedQuota)
+			var log model.Log
+			require.NoError(t, db.Where("type = ?", model.LogTypeConsume).First(&log).Error)
+			require.Equal(t, tt.wantQuota, log.Quota)
+			require.Zero(t, log.ChannelId, "a cache hit does not use any channel")
+		})
+	}
+}
+
+func TestResponseCacheHelperInvalidEntry(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	info := &relaycommon.RelayInfo{IsStream: true, OriginModelName: "gpt-4o"}
+	apiErr := ResponseCacheHelper(c, info, &service.ResponseCacheEntry{Body: "not json"})
+	require.NotNil(t, apiErr)
+	require.Contains(t, apiErr.Error(), "failed to parse cached response")
+}
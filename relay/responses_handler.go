@@ -41,8 +41,29 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		return types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
 	}
 	adaptor.Init(info)
+
+	passThrough := model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled
+	if !passThrough {
+		// previous_response_id 指向网关保存的响应时，原生渠道也需经 chat/completions 拼接历史
+		history, found, err := service.GetStoredResponseHistory(info.UserId, request.PreviousResponseID)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+		}
+		if found || shouldResponsesViaChatCompletions(info, adaptor) {
+			if request.PreviousResponseID != "" && !found {
+				return types.NewErrorWithStatusCode(fmt.Errorf("previous response with id '%s' not found", request.PreviousResponseID), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+			}
+			usage, newAPIError := responsesViaChatCompletions(c, info, adaptor, request, history)
+			if newAPIError != nil {
+				return newAPIError
+			}
+			postConsumeQuota(c, info, usage)
+			return nil
+		}
+	}
+
 	var requestBody io.Reader
-	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+	if passThrough {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
@@ -78,6 +99,9 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		requestBody = bytes.NewBuffer(jsonData)
 	}
 
+	// 按渠道设置在转发前脱敏 PII
+	requestBody = service.RedactRequestPII(c, info, requestBody)
+
 	var httpResp *http.Response
 	resp, err := adaptor.DoRequest(c, info, requestBody)
 	if err != nil {
@@ -0,0 +1,65 @@
+package relay
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shouldResponsesViaChatCompletions 适配器未实现 Responses 接口时经 chat/completions 转发，
+// 原生支持的渠道可按策略指定（Codex 只支持 Responses 接口，始终原生转发）
+func shouldResponsesViaChatCompletions(info *relaycommon.RelayInfo, adaptor channel.Adaptor) bool {
+	if info.ApiType == constant.APITypeCodex {
+		return false
+	}
+	if supporter, ok := adaptor.(channel.ResponsesAPISupporter); ok && supporter.SupportsResponsesAPI() {
+		return service.ShouldResponsesUseChatCompletionsGlobal(info.ChannelId, info.OriginModelName)
+	}
+	return true
+}
+
+func responsesViaChatCompletions(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.OpenAIResponsesRequest, history []dto.Message) (*dto.Usage, *types.NewAPIError) {
+	chatReq, err := service.ResponsesRequestToChatCompletionsRequest(request, history)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	applySystemPromptIfNeeded(c, info, chatReq)
+	if !info.SupportStreamOptions {
+		chatReq.StreamOptions = nil
+	}
+
+	// 渠道按 chat/completions 请求转换并输出 OpenAI 格式，再由 ResponsesViaChatWriter 翻译
+	defer switchToChatCompletions(info)()
+
+	httpResp, newApiErr := doChatCompletionsRequest(c, info, adaptor, chatReq)
+	if newApiErr != nil {
+		return nil, newApiErr
+	}
+
+	responsesResp := service.NewResponsesResponse(request, "resp_"+common.GetUUID(), info.OriginModelName, info.StartTime.Unix())
+	writer := service.NewResponsesViaChatWriter(c, responsesResp, info.IsStream)
+	usageAny, newApiErr := adaptor.DoResponse(c, httpResp, info)
+	if newApiErr != nil {
+		writer.Finish(nil, true)
+		service.ResetStatusCode(newApiErr, c.GetString("status_code_mapping"))
+		return nil, newApiErr
+	}
+	usage, _ := usageAny.(*dto.Usage)
+	if output := writer.Finish(usage, false); output != nil {
+		inputMessages, _ := service.ResponsesInputToChatMessages(request.Input)
+		messages := make([]dto.Message, 0, len(history)+len(inputMessages)+1)
+		messages = append(messages, history...)
+		messages = append(messages, inputMessages...)
+		messages = append(messages, service.ResponsesOutputToChatMessages(output.Output)...)
+		service.SaveStoredResponse(c, info.UserId, messages, output)
+	}
+	return usage, nil
+}
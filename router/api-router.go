@@ -126,6 +126,12 @@ func SetApiRouter(router *gin.Engine) {
 			optionRoute.POST("/rest_model_ratio", controller.ResetModelRatio)
 			optionRoute.POST("/migrate_console_setting", controller.MigrateConsoleSetting) // 用于迁移检测的旧键，下个版本会删除
 		}
+		auditRoute := apiRouter.Group("/audit")
+		auditRoute.Use(middleware.RootAuth())
+		{
+			auditRoute.GET("/", controller.GetAuditLogs)
+			auditRoute.GET("/export", controller.ExportAuditLogs)
+		}
 		ratioSyncRoute := apiRouter.Group("/ratio_sync")
 		ratioSyncRoute.Use(middleware.RootAuth())
 		{
@@ -139,6 +145,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/health", controller.GetChannelHealth)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.POST("/:id/key", middleware.RootAuth(), middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
@@ -183,6 +190,25 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.POST("/batch", controller.DeleteTokenBatch)
 		}
 
+		organizationRoute := apiRouter.Group("/organization")
+		organizationRoute.GET("/", middleware.UserAuth(), controller.GetSelfOrganizations)
+		organizationRoute.POST("/", middleware.UserAuth(), controller.CreateOrganization)
+		organizationRoute.GET("/all", middleware.AdminAuth(), controller.GetAllOrganizations)
+		organizationRoute.PUT("/:id/quota", middleware.AdminAuth(), controller.UpdateOrganizationQuota)
+		organizationRoute.GET("/:id", middleware.UserAuth(), controller.GetOrganization)
+		organizationRoute.PUT("/:id", middleware.UserAuth(), controller.UpdateOrganization)
+		organizationRoute.DELETE("/:id", middleware.UserAuth(), controller.DeleteOrganization)
+		organizationRoute.GET("/:id/member", middleware.UserAuth(), controller.GetOrganizationMembers)
+		organizationRoute.POST("/:id/member", middleware.UserAuth(), controller.AddOrganizationMember)
+		organizationRoute.PUT("/:id/member", middleware.UserAuth(), controller.UpdateOrganizationMember)
+		organizationRoute.DELETE("/:id/member/:user_id", middleware.UserAuth(), controller.DeleteOrganizationMember)
+		organizationRoute.GET("/:id/project", middleware.UserAuth(), controller.GetOrganizationProjects)
+		organizationRoute.POST("/:id/project", middleware.UserAuth(), controller.CreateProject)
+		organizationRoute.PUT("/:id/project", middleware.UserAuth(), controller.UpdateProject)
+		organizationRoute.DELETE("/:id/project/:project_id", middleware.UserAuth(), controller.DeleteProject)
+		organizationRoute.POST("/:id/project/:project_id/quota", middleware.UserAuth(), controller.TransferProjectQuota)
+		organizationRoute.GET("/:id/log", middleware.UserAuth(), controller.GetOrganizationLogs)
+
 		usageRoute := apiRouter.Group("/usage")
 		usageRoute.Use(middleware.CriticalRateLimit())
 		{
@@ -210,12 +236,14 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/payload/:request_id", middleware.AdminAuth(), controller.GetCapturedPayloads)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
 
 		dataRoute := apiRouter.Group("/data")
 		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
+		dataRoute.GET("/organization/:id", middleware.UserAuth(), controller.GetOrganizationQuotaDates)
 
 		logRoute.Use(middleware.CORS())
 		{
@@ -246,6 +274,56 @@ func SetApiRouter(router *gin.Engine) {
 			taskRoute.GET("/", middleware.AdminAuth(), controller.GetAllTask)
 		}
 
+		artifactRoute := apiRouter.Group("/artifact")
+		{
+			artifactRoute.GET("/self", middleware.UserAuth(), controller.GetUserArtifacts)
+			artifactRoute.GET("/:id/content", controller.GetArtifactContent)
+		}
+
+		taskCallbackRoute := apiRouter.Group("/task_callback")
+		taskCallbackRoute.Use(middleware.AdminAuth())
+		{
+			taskCallbackRoute.GET("/", controller.GetTaskCallbacks)
+			taskCallbackRoute.POST("/:id/redeliver", controller.RedeliverTaskCallback)
+		}
+
+		quotaLedgerRoute := apiRouter.Group("/quota_ledger")
+		{
+			quotaLedgerRoute.GET("/self", middleware.UserAuth(), controller.GetSelfQuotaLedgers)
+			quotaLedgerRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaLedgers)
+			quotaLedgerRoute.GET("/reconcile", middleware.AdminAuth(), controller.GetQuotaReconcileReport)
+			quotaLedgerRoute.POST("/reconcile", middleware.AdminAuth(), controller.RunQuotaReconcile)
+		}
+
+		invoiceRoute := apiRouter.Group("/invoice")
+		{
+			invoiceRoute.GET("/self", middleware.UserAuth(), controller.GetSelfInvoices)
+			invoiceRoute.GET("/self/:id/download", middleware.UserAuth(), controller.DownloadSelfInvoice)
+			invoiceRoute.GET("/", middleware.AdminAuth(), controller.GetAllInvoices)
+			invoiceRoute.GET("/:id/download", middleware.AdminAuth(), controller.DownloadInvoice)
+			invoiceRoute.POST("/:id/send", middleware.AdminAuth(), controller.SendInvoice)
+			invoiceRoute.POST("/:id/credit_note", middleware.AdminAuth(), controller.CreateCreditNote)
+		}
+
+		subscriptionRoute := apiRouter.Group("/subscription")
+		{
+			subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetSubscriptionPlans)
+			subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscription)
+			subscriptionRoute.POST("/self/subscribe", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.SubscribePlan)
+			subscriptionRoute.POST("/self/cancel", middleware.UserAuth(), controller.CancelSelfSubscription)
+			subscriptionAdminRoute := subscriptionRoute.Group("/")
+			subscriptionAdminRoute.Use(middleware.AdminAuth())
+			{
+				subscriptionAdminRoute.GET("/", controller.GetAllSubscriptions)
+				subscriptionAdminRoute.POST("/grant", controller.GrantSubscription)
+				subscriptionAdminRoute.POST("/:id/cancel", controller.AdminCancelSubscription)
+				subscriptionAdminRoute.GET("/plan", controller.GetAllSubscriptionPlans)
+				subscriptionAdminRoute.POST("/plan", controller.CreateSubscriptionPlan)
+				subscriptionAdminRoute.PUT("/plan", controller.UpdateSubscriptionPlan)
+				subscriptionAdminRoute.DELETE("/plan/:id", controller.DeleteSubscriptionPlan)
+			}
+		}
+
 		vendorRoute := apiRouter.Group("/vendors")
 		vendorRoute.Use(middleware.AdminAuth())
 		{
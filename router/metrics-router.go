@@ -0,0 +1,14 @@
+package router
+
+import (
+	"github.com/QuantumNous/new-api/common/metrics"
+	"github.com/QuantumNous/new-api/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func SetMetricsRouter(router *gin.Engine) {
+	handler := promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+	router.GET("/metrics", middleware.MetricsAuth(), gin.WrapH(handler))
+}
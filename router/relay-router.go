@@ -63,6 +63,7 @@ func SetRelayRouter(router *gin.Engine) {
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.TokenAuth())
 	relayV1Router.Use(middleware.ModelRequestRateLimit())
+	relayV1Router.Use(middleware.UsageRateLimit())
 	{
 		// WebSocket 路由（统一到 Relay）
 		wsRouter := relayV1Router.Group("")
@@ -71,6 +72,12 @@ func SetRelayRouter(router *gin.Engine) {
 			controller.Relay(c, types.RelayFormatOpenAIRealtime)
 		})
 	}
+	{
+		// 网关保存的 responses，无需选择渠道
+		responseStoreRouter := relayV1Router.Group("")
+		responseStoreRouter.GET("/responses/:id", controller.GetStoredResponse)
+		responseStoreRouter.DELETE("/responses/:id", controller.DeleteStoredResponse)
+	}
 	{
 		//http router
 		httpRouter := relayV1Router.Group("")
@@ -141,17 +148,24 @@ func SetRelayRouter(router *gin.Engine) {
 
 		// not implemented
 		httpRouter.POST("/images/variations", controller.RelayNotImplemented)
-		httpRouter.GET("/files", controller.RelayNotImplemented)
-		httpRouter.POST("/files", controller.RelayNotImplemented)
-		httpRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id/content", controller.RelayNotImplemented)
 		httpRouter.POST("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes/:id", controller.RelayNotImplemented)
 		httpRouter.POST("/fine-tunes/:id/cancel", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes/:id/events", controller.RelayNotImplemented)
 		httpRouter.DELETE("/models/:model", controller.RelayNotImplemented)
+
+		// Files & Batch API，不经过 Distribute
+		batchRouter := relayV1Router.Group("")
+		batchRouter.POST("/files", controller.UploadFile)
+		batchRouter.GET("/files", controller.ListFiles)
+		batchRouter.GET("/files/:id", controller.RetrieveFile)
+		batchRouter.DELETE("/files/:id", controller.DeleteFile)
+		batchRouter.GET("/files/:id/content", controller.GetFileContent)
+		batchRouter.POST("/batches", controller.CreateBatch)
+		batchRouter.GET("/batches", controller.ListBatches)
+		batchRouter.GET("/batches/:batch_id", controller.RetrieveBatch)
+		batchRouter.POST("/batches/:batch_id/cancel", controller.CancelBatch)
 	}
 
 	relayMjRouter := router.Group("/mj")
@@ -172,6 +186,7 @@ func SetRelayRouter(router *gin.Engine) {
 	relayGeminiRouter := router.Group("/v1beta")
 	relayGeminiRouter.Use(middleware.TokenAuth())
 	relayGeminiRouter.Use(middleware.ModelRequestRateLimit())
+	relayGeminiRouter.Use(middleware.UsageRateLimit())
 	relayGeminiRouter.Use(middleware.Distribute())
 	{
 		// Gemini API 路径格式: /v1beta/models/{model_name}:{action}
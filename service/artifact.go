@@ -0,0 +1,197 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+)
+
+var (
+	artifactStore     FileStore
+	artifactStoreLock sync.RWMutex
+)
+
+// InitArtifactStore 配置了 ARTIFACT_S3_BUCKET 时使用 S3 兼容存储，否则使用本地磁盘
+func InitArtifactStore() error {
+	bucket := common.GetEnvOrDefaultString("ARTIFACT_S3_BUCKET", "")
+	if bucket == "" {
+		return nil
+	}
+	store, err := NewS3FileStore(S3FileStoreConfig{
+		Endpoint:  common.GetEnvOrDefaultString("ARTIFACT_S3_ENDPOINT", ""),
+		Region:    common.GetEnvOrDefaultString("ARTIFACT_S3_REGION", "us-east-1"),
+		Bucket:    bucket,
+		Prefix:    common.GetEnvOrDefaultString("ARTIFACT_S3_PREFIX", "artifacts"),
+		AccessKey: common.GetEnvOrDefaultString("ARTIFACT_S3_ACCESS_KEY", ""),
+		SecretKey: common.GetEnvOrDefaultString("ARTIFACT_S3_SECRET_KEY", ""),
+		PathStyle: common.GetEnvOrDefaultBool("ARTIFACT_S3_PATH_STYLE", false),
+	})
+	if err != nil {
+		return err
+	}
+	artifactStoreLock.Lock()
+	artifactStore = store
+	artifactStoreLock.Unlock()
+	common.SysLog("artifact storage: s3 bucket " + bucket)
+	return nil
+}
+
+// GetArtifactStore 获取生成结果的存储后端，未配置 S3 时使用本地磁盘
+func GetArtifactStore() FileStore {
+	artifactStoreLock.RLock()
+	store := artifactStore
+	artifactStoreLock.RUnlock()
+	if store != nil {
+		return store
+	}
+	return &localFileStore{dir: operation_setting.GetArtifactSetting().StorageDir}
+}
+
+// SaveArtifact 将上游返回的图片或视频转存，受单文件大小与用户存储配额限制，同一任务只转存一次
+func SaveArtifact(userId int, taskType string, taskId string, resp *http.Response) (*model.Artifact, error) {
+	setting := operation_setting.GetArtifactSetting()
+	if existing, err := model.GetArtifactByTask(taskType, taskId); err == nil && !existing.IsExpired() {
+		return existing, nil
+	}
+	maxBytes := int64(setting.MaxFileSizeMB) << 20
+	if setting.UserQuotaMB > 0 {
+		used, err := model.GetUserArtifactSize(userId)
+		if err != nil {
+			return nil, err
+		}
+		remaining := int64(setting.UserQuotaMB)<<20 - used
+		if remaining <= 0 {
+			return nil, fmt.Errorf("user %d artifact storage quota exceeded", userId)
+		}
+		if maxBytes <= 0 || remaining < maxBytes {
+			maxBytes = remaining
+		}
+	}
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("artifact size %d exceeds limit %d", resp.ContentLength, maxBytes)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	storageKey := fmt.Sprintf("%s-%d-%s%s", taskType, userId, common.GetUUID(), artifactExtension(contentType))
+	var reader io.Reader = resp.Body
+	if maxBytes > 0 {
+		reader = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	store := GetArtifactStore()
+	size, err := store.Put(storageKey, reader)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes > 0 && size > maxBytes {
+		_ = store.Delete(storageKey)
+		return nil, fmt.Errorf("artifact size exceeds limit %d", maxBytes)
+	}
+
+	now := common.GetTimestamp()
+	artifact := &model.Artifact{
+		UserId:      userId,
+		TaskType:    taskType,
+		TaskId:      taskId,
+		StorageKey:  storageKey,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   now,
+	}
+	if setting.RetentionDays > 0 {
+		artifact.ExpiresAt = now + int64(setting.RetentionDays)*86400
+	}
+	if err = artifact.Insert(); err != nil {
+		_ = store.Delete(storageKey)
+		return nil, err
+	}
+	return artifact, nil
+}
+
+func artifactExtension(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "video/mp4":
+		return ".mp4"
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+func artifactSignature(id int, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(common.CryptoSecret))
+	mac.Write([]byte(fmt.Sprintf("artifact:%d:%d", id, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignArtifactUrl 生成带过期时间的下载地址，有效期不超过文件本身的保留期限
+func SignArtifactUrl(artifact *model.Artifact) string {
+	expires := time.Now().Unix() + int64(operation_setting.GetArtifactSetting().SignedUrlTTLSeconds)
+	if artifact.ExpiresAt > 0 && artifact.ExpiresAt < expires {
+		expires = artifact.ExpiresAt
+	}
+	return fmt.Sprintf("%s/api/artifact/%d/content?expires=%d&signature=%s",
+		strings.TrimRight(system_setting.ServerAddress, "/"), artifact.Id, expires, artifactSignature(artifact.Id, expires))
+}
+
+// VerifyArtifactSignature 校验下载地址的签名与有效期
+func VerifyArtifactSignature(id int, expiresStr string, signature string) error {
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return errors.New("invalid expires")
+	}
+	if expires < time.Now().Unix() {
+		return errors.New("url expired")
+	}
+	if !hmac.Equal([]byte(signature), []byte(artifactSignature(id, expires))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// CleanExpiredArtifacts 定期删除过期的转存结果
+func CleanExpiredArtifacts() {
+	for {
+		time.Sleep(time.Hour)
+		artifacts, err := model.GetExpiredArtifacts(common.GetTimestamp(), 1000)
+		if err != nil {
+			common.SysError("failed to query expired artifacts: " + err.Error())
+			continue
+		}
+		store := GetArtifactStore()
+		for _, artifact := range artifacts {
+			if err := store.Delete(artifact.StorageKey); err != nil {
+				common.SysError(fmt.Sprintf("failed to delete expired artifact %s: %s", artifact.StorageKey, err.Error()))
+				continue
+			}
+			_ = model.DeleteArtifactById(artifact.Id)
+		}
+		if len(artifacts) > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired artifacts", len(artifacts)))
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupArtifactTest 使用临时目录作为本地存储，返回存储目录
+func setupArtifactTest(t *testing.T, setting operation_setting.ArtifactSetting) (*gorm.DB, string) {
+	db := setupTestDB(t, &model.Artifact{})
+	current := operation_setting.GetArtifactSetting()
+	original := *current
+	t.Cleanup(func() { *current = original })
+	setting.StorageDir = t.TempDir()
+	*current = setting
+	return db, setting.StorageDir
+}
+
+func newArtifactResponse(body string, contentLength int64) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"image/png"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: contentLength,
+	}
+}
+
+func countArtifactFiles(t *testing.T, dir string) int {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func TestSaveArtifactQuota(t *testing.T) {
+	const mb = int64(1) << 20
+	tests := []struct {
+		name          string
+		maxFileSizeMB int
+		userQuotaMB   int
+		usedByUser    int64
+		usedByOthers  int64
+		body          string
+		contentLength int64
+		wantErr       string
+	}{
+		{name: "within quota", userQuotaMB: 1, usedByUser: mb - 20, body: "0123456789", contentLength: 10},
+		{name: "other users do not count", userQuotaMB: 1, usedByOthers: mb, body: "0123456789", contentLength: 10},
+		{name: "quota exhausted", userQuotaMB: 1, usedByUser: mb, body: "0123456789", contentLength: 10, wantErr: "storage quota exceeded"},
+		{name: "declared size over remaining quota", userQuotaMB: 1, usedByUser: mb - 5, body: "0123456789", contentLength: 10, wantErr: "exceeds limit 5"},
+		{name: "unknown size over remaining quota", userQuotaMB: 1, usedByUser: mb - 5, body: "0123456789", contentLength: -1, wantErr: "exceeds limit 5"},
+		{name: "declared size over file limit", maxFileSizeMB: 1, body: "0123456789", contentLength: 2 * mb, wantErr: "exceeds limit"},
+		{name: "no limits", body: "0123456789", contentLength: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, dir := setupArtifactTest(t, operation_setting.ArtifactSetting{
+				MaxFileSizeMB: tt.maxFileSizeMB,
+				UserQuotaMB:   tt.userQuotaMB,
+				RetentionDays: 1,
+			})
+			if tt.usedByUser > 0 {
+				require.NoError(t, db.Create(&model.Artifact{UserId: 1, TaskType: model.ArtifactTaskTypeTask, TaskId: "old", Size: tt.usedByUser}).Error)
+			}
+			if tt.usedByOthers > 0 {
+				require.NoError(t, db.Create(&model.Artifact{UserId: 2, TaskType: model.ArtifactTaskTypeTask, TaskId: "other", Size: tt.usedByOthers}).Error)
+			}
+
+			artifact, err := SaveArtifact(1, model.ArtifactTaskTypeTask, "task-1", newArtifactResponse(tt.body, tt.contentLength))
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				require.Zero(t, countArtifactFiles(t, dir), "rejected artifacts leave no file behind")
+				_, err = model.GetArtifactByTask(model.ArtifactTaskTypeTask, "task-1")
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.EqualValues(t, len(tt.body), artifact.Size)
+			require.Equal(t, "image/png", artifact.ContentType)
+			require.True(t, strings.HasSuffix(artifact.StorageKey, ".png"))
+			require.Equal(t, artifact.CreatedAt+86400, artifact.ExpiresAt)
+			data, err := os.ReadFile(filepath.Join(dir, artifact.StorageKey))
+			require.NoError(t, err)
+			require.Equal(t, tt.body, string(data))
+		})
+	}
+}
+
+func TestSaveArtifactReuse(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresAt int64
+		wantReuse bool
+	}{
+		{name: "live artifact is reused", expiresAt: common.GetTimestamp() + 3600, wantReuse: true},
+		{name: "never expiring artifact is reused", expiresAt: 0, wantReuse: true},
+		{name: "expired artifact is archived again", expiresAt: common.GetTimestamp() - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, dir := setupArtifactTest(t, operation_setting.ArtifactSetting{RetentionDays: 1})
+			existing := &model.Artifact{UserId: 1, TaskType: model.ArtifactTaskTypeTask, TaskId: "task-1", StorageKey: "old.png", ExpiresAt: tt.expiresAt}
+			require.NoError(t, existing.Insert())
+
+			artifact, err := SaveArtifact(1, model.ArtifactTaskTypeTask, "task-1", newArtifactResponse("new", 3))
+			require.NoError(t, err)
+			var count int64
+			require.NoError(t, db.Model(&model.Artifact{}).Count(&count).Error)
+			if tt.wantReuse {
+				require.Equal(t, existing.Id, artifact.Id)
+				require.EqualValues(t, 1, count)
+				require.Zero(t, countArtifactFiles(t, dir))
+				return
+			}
+			require.NotEqual(t, existing.Id, artifact.Id)
+			require.EqualValues(t, 2, count)
+			require.False(t, artifact.IsExpired())
+			latest, err := model.GetArtifactByTask(model.ArtifactTaskTypeTask, "task-1")
+			require.NoError(t, err)
+			require.Equal(t, artifact.Id, latest.Id)
+		})
+	}
+}
+
+func TestArtifactSignature(t *testing.T) {
+	origAddress, origSecret := system_setting.ServerAddress, common.CryptoSecret
+	t.Cleanup(func() { system_setting.ServerAddress, common.CryptoSecret = origAddress, origSecret })
+	system_setting.ServerAddress = "https://api.example.com/"
+	common.CryptoSecret = "test-secret"
+	setting := operation_setting.GetArtifactSetting()
+	origTTL := setting.SignedUrlTTLSeconds
+	t.Cleanup(func() { setting.SignedUrlTTLSeconds = origTTL })
+	setting.SignedUrlTTLSeconds = 600
+
+	now := time.Now().Unix()
+	tests := []struct {
+		name        string
+		expiresAt   int64
+		wantExpires int64 // 相对当前时间
+		tamper      func(id int, expires string, signature string) (int, string, string)
+		wantErr     string
+	}{
+		{name: "valid url", wantExpires: 600},
+		{name: "capped by artifact retention", expiresAt: now + 60, wantExpires: 60},
+		{name: "expired artifact", expiresAt: now - 1, wantExpires: -1, wantErr: "url expired"},
+		{
+			name: "extended expiry", wantExpires: 600, wantErr: "invalid signature",
+			tamper: func(id int, expires string, signature string) (int, string, string) {
+				value, _ := strconv.ParseInt(expires, 10, 64)
+				return id, strconv.FormatInt(value+3600, 10), signature
+			},
+		},
+		{
+			name: "other artifact", wantExpires: 600, wantErr: "invalid signature",
+			tamper: func(id int, expires string, signature string) (int, string, string) {
+				return id + 1, expires, signature
+			},
+		},
+		{
+			name: "invalid expires", wantExpires: 600, wantErr: "invalid expires",
+			tamper: func(id int, expires string, signature string) (int, string, string) {
+				return id, "soon", signature
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			artifact := &model.Artifact{Id: 7, ExpiresAt: tt.expiresAt}
+			signed, err := url.Parse(SignArtifactUrl(artifact))
+			require.NoError(t, err)
+			require.Equal(t, "https://api.example.com/api/artifact/7/content", signed.Scheme+"://"+signed.Host+signed.Path)
+			expires := signed.Query().Get("expires")
+			signature := signed.Query().Get("signature")
+			value, err := strconv.ParseInt(expires, 10, 64)
+			require.NoError(t, err)
+			require.InDelta(t, now+tt.wantExpires, value, 1)
+
+			id := artifact.Id
+			if tt.tamper != nil {
+				id, expires, signature = tt.tamper(id, expires, signature)
+			}
+			err = VerifyArtifactSignature(id, expires, signature)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const auditRedacted = "******"
+
+// AuditChange 单个字段的变更，新建时只有 after，删除时只有 before
+type AuditChange struct {
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+func isAuditSecretField(name string) bool {
+	lower := strings.ToLower(name)
+	switch lower {
+	case "key", "password", "secret", "token", "access_token", "api_key":
+		return true
+	}
+	return strings.HasSuffix(lower, "_key") ||
+		strings.HasSuffix(lower, "_secret") ||
+		strings.HasSuffix(lower, "_token") ||
+		strings.HasSuffix(lower, "password") ||
+		model.IsSecretOption(name)
+}
+
+// normalizeAuditValue 把结构体转为通用 JSON 值，JSON 字符串展开以便逐项比较
+func normalizeAuditValue(v any) any {
+	if v == nil {
+		return nil
+	}
+	if s, ok := v.(string); ok {
+		trimmed := strings.TrimSpace(s)
+		if strings.HasPrefix(trimmed, "{") {
+			var parsed map[string]any
+			if err := common.Unmarshal([]byte(trimmed), &parsed); err == nil {
+				return parsed
+			}
+		}
+		return s
+	}
+	data, err := common.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	var out any
+	if err := common.Unmarshal(data, &out); err != nil {
+		return string(data)
+	}
+	return out
+}
+
+type auditField struct {
+	value  any
+	secret bool
+}
+
+func flattenAuditValue(prefix string, v any, secret bool, out map[string]auditField) {
+	if m, ok := v.(map[string]any); ok && len(m) > 0 {
+		for k, child := range m {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			flattenAuditValue(path, normalizeAuditValue(child), secret || isAuditSecretField(k), out)
+		}
+		return
+	}
+	out[prefix] = auditField{value: v, secret: secret}
+}
+
+func redactAuditValue(field auditField, exists bool) any {
+	if !exists || field.value == nil {
+		return nil
+	}
+	if field.secret && field.value != "" {
+		return auditRedacted
+	}
+	return field.value
+}
+
+// addAuditChange 新建或删除时的空值字段不记录
+func addAuditChange(diff map[string]AuditChange, path string, before any, after any) {
+	if before == nil && after == nil {
+		return
+	}
+	diff[path] = AuditChange{Before: before, After: after}
+}
+
+// BuildAuditDiff 计算 before 与 after 的字段级差异，敏感字段只记录是否变化
+func BuildAuditDiff(before any, after any) map[string]AuditChange {
+	beforeFields := make(map[string]auditField)
+	afterFields := make(map[string]auditField)
+	flattenAuditValue("", normalizeAuditValue(before), false, beforeFields)
+	flattenAuditValue("", normalizeAuditValue(after), false, afterFields)
+
+	diff := make(map[string]AuditChange)
+	for path, b := range beforeFields {
+		a, ok := afterFields[path]
+		if ok && reflect.DeepEqual(a.value, b.value) {
+			continue
+		}
+		addAuditChange(diff, path, redactAuditValue(b, true), redactAuditValue(a, ok))
+	}
+	for path, a := range afterFields {
+		if _, ok := beforeFields[path]; ok {
+			continue
+		}
+		addAuditChange(diff, path, nil, redactAuditValue(a, true))
+	}
+	return diff
+}
+
+// RecordAudit 记录一次管理操作，更新但无实际变化时不记录
+func RecordAudit(c *gin.Context, action string, targetType string, targetId any, before any, after any) {
+	diff := BuildAuditDiff(before, after)
+	if action == model.AuditActionUpdate && len(diff) == 0 {
+		return
+	}
+	diffJson, err := common.Marshal(diff)
+	if err != nil {
+		common.SysError("failed to marshal audit diff: " + err.Error())
+		return
+	}
+	auditLog := &model.AuditLog{
+		CreatedAt:  common.GetTimestamp(),
+		ActorId:    c.GetInt("id"),
+		ActorName:  c.GetString("username"),
+		ActorRole:  c.GetInt("role"),
+		Ip:         c.ClientIP(),
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   fmt.Sprintf("%v", targetId),
+		Diff:       string(diffJson),
+	}
+	if err := auditLog.Insert(); err != nil {
+		common.SysError(fmt.Sprintf("failed to record audit log %s %s %s: %s", action, targetType, auditLog.TargetId, err.Error()))
+	}
+}
+
+// CleanExpiredAuditLogs 按保留天数定期清理审计日志
+func CleanExpiredAuditLogs() {
+	for {
+		time.Sleep(time.Hour)
+		retentionDays := operation_setting.GetAuditSetting().RetentionDays
+		if retentionDays <= 0 {
+			continue
+		}
+		cutoff := time.Now().AddDate(0, 0, -retentionDays).Unix()
+		count, err := model.DeleteOldAuditLogs(context.Background(), cutoff, 1000)
+		if err != nil {
+			common.SysError("failed to clean expired audit logs: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired audit logs", count))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type auditTestChannel struct {
+	Name     string            `json:"name"`
+	Key      string            `json:"key"`
+	Priority int64             `json:"priority"`
+	Setting  string            `json:"setting"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+func TestBuildAuditDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before any
+		after  any
+		want   map[string]AuditChange
+	}{
+		{
+			name:   "no change",
+			before: auditTestChannel{Name: "a", Key: "sk-1", Priority: 1},
+			after:  auditTestChannel{Name: "a", Key: "sk-1", Priority: 1},
+			want:   map[string]AuditChange{},
+		},
+		{
+			name:   "plain field change",
+			before: auditTestChannel{Name: "a", Priority: 1},
+			after:  auditTestChannel{Name: "b", Priority: 1},
+			want:   map[string]AuditChange{"name": {Before: "a", After: "b"}},
+		},
+		{
+			name:   "secret field is redacted",
+			before: auditTestChannel{Name: "a", Key: "sk-old"},
+			after:  auditTestChannel{Name: "a", Key: "sk-new"},
+			want:   map[string]AuditChange{"key": {Before: auditRedacted, After: auditRedacted}},
+		},
+		{
+			name:   "secret field set from empty",
+			before: auditTestChannel{Name: "a"},
+			after:  auditTestChannel{Name: "a", Key: "sk-new"},
+			want:   map[string]AuditChange{"key": {Before: "", After: auditRedacted}},
+		},
+		{
+			name:   "create records non-empty fields",
+			before: nil,
+			after:  map[string]any{"name": "a", "api_key": "sk-1"},
+			want: map[string]AuditChange{
+				"name":    {After: "a"},
+				"api_key": {After: auditRedacted},
+			},
+		},
+		{
+			name:   "delete records previous values",
+			before: map[string]any{"name": "a", "password": "p"},
+			after:  nil,
+			want: map[string]AuditChange{
+				"name":     {Before: "a"},
+				"password": {Before: auditRedacted},
+			},
+		},
+		{
+			name:   "json string is expanded and nested secrets redacted",
+			before: auditTestChannel{Setting: `{"proxy":"http://a","aws_secret":"x"}`},
+			after:  auditTestChannel{Setting: `{"proxy":"http://b","aws_secret":"y"}`},
+			want: map[string]AuditChange{
+				"setting.proxy":      {Before: "http://a", After: "http://b"},
+				"setting.aws_secret": {Before: auditRedacted, After: auditRedacted},
+			},
+		},
+		{
+			name:   "children of a secret object are redacted",
+			before: map[string]any{"access_token": map[string]any{"value": "t1"}},
+			after:  map[string]any{"access_token": map[string]any{"value": "t2"}},
+			want:   map[string]AuditChange{"access_token.value": {Before: auditRedacted, After: auditRedacted}},
+		},
+		{
+			name:   "option style secret key",
+			before: map[string]any{"GitHubClientSecret": "a", "SystemName": "x"},
+			after:  map[string]any{"GitHubClientSecret": "b", "SystemName": "y"},
+			want: map[string]AuditChange{
+				"GitHubClientSecret": {Before: auditRedacted, After: auditRedacted},
+				"SystemName":         {Before: "x", After: "y"},
+			},
+		},
+		{
+			name:   "removed nested field",
+			before: auditTestChannel{Headers: map[string]string{"X-A": "1", "X-B": "2"}},
+			after:  auditTestChannel{Headers: map[string]string{"X-A": "1"}},
+			want:   map[string]AuditChange{"headers.X-B": {Before: "2"}},
+		},
+		{
+			name:   "numbers compare after normalization",
+			before: auditTestChannel{Priority: 1},
+			after:  map[string]any{"name": "", "key": "", "priority": 2, "setting": ""},
+			want:   map[string]AuditChange{"priority": {Before: float64(1), After: float64(2)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, BuildAuditDiff(tt.before, tt.after))
+		})
+	}
+}
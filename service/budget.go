@@ -0,0 +1,238 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const budgetKindMonthlyCap = "monthly_cap"
+
+var budgetKindNames = map[string]string{
+	operation_setting.BudgetPeriodDaily:   "每日",
+	operation_setting.BudgetPeriodWeekly:  "每周",
+	operation_setting.BudgetPeriodMonthly: "每月",
+	budgetKindMonthlyCap:                  "每月上限",
+}
+
+// budgetWindow 一个生效的预算窗口（令牌或用户的周期预算、月度上限）
+type budgetWindow struct {
+	Scope   string // token / user
+	ScopeId int
+	Kind    string
+	Limit   int
+	Key     string
+	ResetAt time.Time
+}
+
+var budgetLocations sync.Map
+
+func loadBudgetLocation(timezone string) *time.Location {
+	if timezone == "" {
+		timezone = operation_setting.GetBudgetSetting().Timezone
+	}
+	if timezone == "" || timezone == "Local" {
+		return time.Local
+	}
+	if loc, ok := budgetLocations.Load(timezone); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		common.SysError(fmt.Sprintf("invalid budget timezone %s: %s", timezone, err.Error()))
+		return time.Local
+	}
+	budgetLocations.Store(timezone, loc)
+	return loc
+}
+
+// ValidateBudget 校验令牌或用户的预算配置
+func ValidateBudget(budget operation_setting.Budget) error {
+	if !operation_setting.IsValidBudgetPeriod(budget.Period) {
+		return fmt.Errorf("无效的预算周期: %s", budget.Period)
+	}
+	if budget.Quota < 0 || budget.MonthlyCap < 0 {
+		return fmt.Errorf("预算额度不能为负数")
+	}
+	if budget.Timezone != "" && budget.Timezone != "Local" {
+		if _, err := time.LoadLocation(budget.Timezone); err != nil {
+			return fmt.Errorf("无效的时区: %s", budget.Timezone)
+		}
+	}
+	return nil
+}
+
+// budgetPeriodRange 计算 now 所在周期的起止时间，周预算从周一开始
+func budgetPeriodRange(period string, now time.Time) (time.Time, time.Time) {
+	year, month, day := now.Date()
+	switch period {
+	case operation_setting.BudgetPeriodDaily:
+		start := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 0, 1)
+	case operation_setting.BudgetPeriodWeekly:
+		start := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	default:
+		start := time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+func budgetWindows(scope string, scopeId int, budget operation_setting.Budget, now time.Time) []budgetWindow {
+	if budget.IsZero() {
+		return nil
+	}
+	now = now.In(loadBudgetLocation(budget.Timezone))
+	windows := make([]budgetWindow, 0, 2)
+	add := func(kind string, period string, limit int) {
+		start, end := budgetPeriodRange(period, now)
+		windows = append(windows, budgetWindow{
+			Scope:   scope,
+			ScopeId: scopeId,
+			Kind:    kind,
+			Limit:   limit,
+			Key:     fmt.Sprintf("budget:%s:%d:%s:%s", scope, scopeId, kind, start.Format("20060102")),
+			ResetAt: end,
+		})
+	}
+	if budget.Period != "" && budget.Quota > 0 {
+		add(budget.Period, budget.Period, budget.Quota)
+	}
+	if budget.MonthlyCap > 0 {
+		add(budgetKindMonthlyCap, operation_setting.BudgetPeriodMonthly, budget.MonthlyCap)
+	}
+	return windows
+}
+
+// relayBudgetWindows 当前请求生效的预算窗口，playground 请求不使用令牌预算
+// 窗口按请求开始时间计算，预扣费与结算落在同一周期，跨周期边界的请求不会把结算记到下一周期
+func relayBudgetWindows(relayInfo *relaycommon.RelayInfo) []budgetWindow {
+	now := relayInfo.StartTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var windows []budgetWindow
+	if !relayInfo.IsPlayground {
+		windows = append(windows, budgetWindows("token", relayInfo.TokenId, relayInfo.TokenBudget, now)...)
+	}
+	return append(windows, budgetWindows("user", relayInfo.UserId, relayInfo.UserBudget, now)...)
+}
+
+func HasBudget(relayInfo *relaycommon.RelayInfo) bool {
+	return (!relayInfo.IsPlayground && !relayInfo.TokenBudget.IsZero()) || !relayInfo.UserBudget.IsZero()
+}
+
+func budgetExceededError(window budgetWindow, used int, quota int) error {
+	return fmt.Errorf("%s %s budget is not enough, used: %s, budget: %s, need quota: %s, resets at %s",
+		window.Scope, window.Kind, logger.FormatQuota(used), logger.FormatQuota(window.Limit),
+		logger.FormatQuota(quota), window.ResetAt.Format(time.RFC3339))
+}
+
+// CheckBudget 检查本次消耗是否会超出令牌或用户的周期预算，只读不计入，用于上游成功后才扣费的请求提前拒绝
+func CheckBudget(relayInfo *relaycommon.RelayInfo, quota int) error {
+	for _, window := range relayBudgetWindows(relayInfo) {
+		used, err := model.GetBudgetUsage(window.Key)
+		if err != nil {
+			return err
+		}
+		if used >= window.Limit || used+quota > window.Limit {
+			return budgetExceededError(window, used, quota)
+		}
+	}
+	return nil
+}
+
+// reserveBudget 检查并计入预扣费用量，每个窗口的检查与累加是原子的，任一窗口不足时回滚已计入的窗口
+func reserveBudget(relayInfo *relaycommon.RelayInfo, quota int) error {
+	windows := relayBudgetWindows(relayInfo)
+	for i, window := range windows {
+		ok, used, err := model.ReserveBudgetUsage(window.Key, quota, window.Limit, window.ResetAt.Add(24*time.Hour))
+		if err == nil && !ok {
+			err = budgetExceededError(window, used, quota)
+		}
+		if err != nil {
+			for _, reserved := range windows[:i] {
+				if _, rollbackErr := model.IncreaseBudgetUsage(reserved.Key, -quota, reserved.ResetAt.Add(24*time.Hour)); rollbackErr != nil {
+					common.SysError(fmt.Sprintf("failed to roll back budget usage %s: %s", reserved.Key, rollbackErr.Error()))
+				}
+			}
+			return err
+		}
+		if quota > 0 {
+			checkAndSendBudgetAlert(relayInfo, window, used-quota, used)
+		}
+	}
+	return nil
+}
+
+// recordBudgetUsage 累加周期用量（quota 为负时返还），用量跨过提醒阈值时发送通知
+func recordBudgetUsage(relayInfo *relaycommon.RelayInfo, quota int) {
+	if quota == 0 {
+		return
+	}
+	for _, window := range relayBudgetWindows(relayInfo) {
+		// 多保留一天，避免周期切换时结算的请求找不到记录
+		used, err := model.IncreaseBudgetUsage(window.Key, quota, window.ResetAt.Add(24*time.Hour))
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to record budget usage %s: %s", window.Key, err.Error()))
+			continue
+		}
+		if quota > 0 {
+			checkAndSendBudgetAlert(relayInfo, window, used-quota, used)
+		}
+	}
+}
+
+func checkAndSendBudgetAlert(relayInfo *relaycommon.RelayInfo, window budgetWindow, before int, after int) {
+	percent := 0
+	for _, p := range operation_setting.GetBudgetSetting().AlertPercents {
+		threshold := int64(window.Limit) * int64(p) / 100
+		if p > percent && int64(before) < threshold && int64(after) >= threshold {
+			percent = p
+		}
+	}
+	if percent == 0 {
+		return
+	}
+	gopool.Go(func() {
+		target := "您的账户"
+		if window.Scope == "token" {
+			target = fmt.Sprintf("令牌 #%d", window.ScopeId)
+		}
+		prompt := fmt.Sprintf("%s的%s预算已使用 %d%%", target, budgetKindNames[window.Kind], percent)
+		content := "{{value}}，预算 {{value}}，已使用 {{value}}，将于 {{value}} 重置。"
+		values := []interface{}{prompt, logger.FormatQuota(window.Limit), logger.FormatQuota(after), window.ResetAt.Format("2006-01-02 15:04 MST")}
+		err := NotifyUser(relayInfo.UserId, relayInfo.UserEmail, relayInfo.UserSetting, dto.NewNotify(dto.NotifyTypeBudgetAlert, prompt, content, values))
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to send budget alert to user %d: %s", relayInfo.UserId, err.Error()))
+		}
+	})
+}
+
+// CleanExpiredBudgetUsages 定期清理数据库中已过期的周期用量，Redis 存储依赖过期时间自动清理
+func CleanExpiredBudgetUsages() {
+	for {
+		time.Sleep(time.Hour)
+		if common.RedisEnabled {
+			continue
+		}
+		count, err := model.DeleteExpiredBudgetUsages(common.GetTimestamp())
+		if err != nil {
+			common.SysError("failed to clean expired budget usages: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired budget usages", count))
+		}
+	}
+}
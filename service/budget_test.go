@@ -0,0 +1,283 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/stretchr/testify/require"
+)
+
+// disableBudgetAlertsForTest 关闭预算提醒，避免测试中异步发送通知
+func disableBudgetAlertsForTest(t *testing.T) {
+	setting := operation_setting.GetBudgetSetting()
+	orig := setting.AlertPercents
+	t.Cleanup(func() { setting.AlertPercents = orig })
+	setting.AlertPercents = nil
+}
+
+func TestBudgetPeriodRange(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	require.NoError(t, err)
+	tests := []struct {
+		name      string
+		period    string
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "daily",
+			period:    operation_setting.BudgetPeriodDaily,
+			now:       time.Date(2026, 3, 15, 13, 30, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "weekly starts on monday",
+			period:    operation_setting.BudgetPeriodWeekly,
+			now:       time.Date(2026, 3, 15, 13, 30, 0, 0, time.UTC), // 周日
+			wantStart: time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "weekly on monday",
+			period:    operation_setting.BudgetPeriodWeekly,
+			now:       time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 3, 23, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monthly across year end",
+			period:    operation_setting.BudgetPeriodMonthly,
+			now:       time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "daily in another timezone",
+			period:    operation_setting.BudgetPeriodDaily,
+			now:       time.Date(2026, 3, 15, 20, 0, 0, 0, time.UTC).In(shanghai),
+			wantStart: time.Date(2026, 3, 16, 0, 0, 0, 0, shanghai),
+			wantEnd:   time.Date(2026, 3, 17, 0, 0, 0, 0, shanghai),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := budgetPeriodRange(tt.period, tt.now)
+			require.True(t, tt.wantStart.Equal(start), "start %s", start)
+			require.True(t, tt.wantEnd.Equal(end), "end %s", end)
+		})
+	}
+}
+
+func TestBudgetWindows(t *testing.T) {
+	now := time.Date(2026, 3, 15, 13, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		budget   operation_setting.Budget
+		wantKeys []string
+	}{
+		{name: "no budget", budget: operation_setting.Budget{}},
+		{name: "period without quota", budget: operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily}},
+		{
+			name:     "daily budget",
+			budget:   operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: 100, Timezone: "UTC"},
+			wantKeys: []string{"budget:token:1:daily:20260315"},
+		},
+		{
+			name:     "weekly budget with monthly cap",
+			budget:   operation_setting.Budget{Period: operation_setting.BudgetPeriodWeekly, Quota: 100, MonthlyCap: 300, Timezone: "UTC"},
+			wantKeys: []string{"budget:token:1:weekly:20260309", "budget:token:1:monthly_cap:20260301"},
+		},
+		{
+			name:     "monthly cap only",
+			budget:   operation_setting.Budget{MonthlyCap: 300, Timezone: "UTC"},
+			wantKeys: []string{"budget:token:1:monthly_cap:20260301"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys []string
+			for _, window := range budgetWindows("token", 1, tt.budget, now) {
+				keys = append(keys, window.Key)
+			}
+			require.Equal(t, tt.wantKeys, keys)
+		})
+	}
+}
+
+func TestValidateBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		budget  operation_setting.Budget
+		wantErr bool
+	}{
+		{name: "empty", budget: operation_setting.Budget{}},
+		{name: "valid", budget: operation_setting.Budget{Period: operation_setting.BudgetPeriodMonthly, Quota: 10, Timezone: "Asia/Shanghai"}},
+		{name: "invalid period", budget: operation_setting.Budget{Period: "yearly", Quota: 10}, wantErr: true},
+		{name: "negative quota", budget: operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: -1}, wantErr: true},
+		{name: "negative monthly cap", budget: operation_setting.Budget{MonthlyCap: -1}, wantErr: true},
+		{name: "invalid timezone", budget: operation_setting.Budget{MonthlyCap: 1, Timezone: "Mars/Base"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBudget(tt.budget)
+			require.Equal(t, tt.wantErr, err != nil, "err: %v", err)
+		})
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	disableBudgetAlertsForTest(t)
+	tests := []struct {
+		name         string
+		playground   bool
+		tokenBudget  operation_setting.Budget
+		userBudget   operation_setting.Budget
+		recorded     []int
+		quota        int
+		wantExceeded bool
+	}{
+		{
+			name:        "within token budget",
+			tokenBudget: operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: 100},
+			recorded:    []int{40},
+			quota:       60,
+		},
+		{
+			name:         "token budget exceeded",
+			tokenBudget:  operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: 100},
+			recorded:     []int{40},
+			quota:        61,
+			wantExceeded: true,
+		},
+		{
+			name:         "refund frees budget",
+			tokenBudget:  operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: 100},
+			recorded:     []int{100, -30},
+			quota:        30,
+			wantExceeded: false,
+		},
+		{
+			name:         "user monthly cap exceeded",
+			userBudget:   operation_setting.Budget{MonthlyCap: 50},
+			recorded:     []int{50},
+			quota:        1,
+			wantExceeded: true,
+		},
+		{
+			name:        "playground ignores token budget",
+			playground:  true,
+			tokenBudget: operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: 10},
+			recorded:    []int{10},
+			quota:       5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t, &model.BudgetUsage{})
+			relayInfo := &relaycommon.RelayInfo{
+				UserId:       1,
+				TokenId:      2,
+				IsPlayground: tt.playground,
+				TokenBudget:  tt.tokenBudget,
+				UserBudget:   tt.userBudget,
+			}
+			for _, quota := range tt.recorded {
+				recordBudgetUsage(relayInfo, quota)
+			}
+			err := CheckBudget(relayInfo, tt.quota)
+			require.Equal(t, tt.wantExceeded, err != nil, "err: %v", err)
+		})
+	}
+}
+
+func TestRelayBudgetWindowsUseStartTime(t *testing.T) {
+	relayInfo := &relaycommon.RelayInfo{
+		TokenId:     2,
+		TokenBudget: operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: 100, Timezone: "UTC"},
+		StartTime:   time.Date(2026, 3, 15, 23, 59, 59, 0, time.UTC),
+	}
+	windows := relayBudgetWindows(relayInfo)
+	require.Len(t, windows, 1)
+	// 请求跨过零点结算时仍记入开始时所在的周期
+	require.Equal(t, "budget:token:2:daily:20260315", windows[0].Key)
+}
+
+func TestReserveBudget(t *testing.T) {
+	disableBudgetAlertsForTest(t)
+	tests := []struct {
+		name        string
+		recorded    int
+		quota       int
+		wantErr     bool
+		wantToken   int
+		wantUserCap int
+	}{
+		{name: "reserved in every window", recorded: 40, quota: 10, wantToken: 50, wantUserCap: 50},
+		{name: "token budget exceeded", recorded: 95, quota: 10, wantErr: true, wantToken: 95, wantUserCap: 95},
+		// 令牌窗口已计入，用户窗口不足时回滚令牌窗口
+		{name: "later window rolls back earlier ones", recorded: 55, quota: 10, wantErr: true, wantToken: 55, wantUserCap: 55},
+		{name: "exhausted budget rejects zero quota", recorded: 60, quota: 0, wantErr: true, wantToken: 60, wantUserCap: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t, &model.BudgetUsage{})
+			relayInfo := &relaycommon.RelayInfo{
+				UserId:      1,
+				TokenId:     2,
+				TokenBudget: operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: 100},
+				UserBudget:  operation_setting.Budget{MonthlyCap: 60},
+				StartTime:   time.Now(),
+			}
+			recordBudgetUsage(relayInfo, tt.recorded)
+
+			err := reserveBudget(relayInfo, tt.quota)
+			require.Equal(t, tt.wantErr, err != nil, "err: %v", err)
+			windows := relayBudgetWindows(relayInfo)
+			require.Len(t, windows, 2)
+			used, err := model.GetBudgetUsage(windows[0].Key)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantToken, used)
+			used, err = model.GetBudgetUsage(windows[1].Key)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantUserCap, used)
+		})
+	}
+}
+
+func TestReserveBudgetConcurrent(t *testing.T) {
+	disableBudgetAlertsForTest(t)
+	db := setupTestDB(t, &model.BudgetUsage{})
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	relayInfo := &relaycommon.RelayInfo{
+		UserId:     1,
+		UserBudget: operation_setting.Budget{Period: operation_setting.BudgetPeriodDaily, Quota: 100},
+		StartTime:  time.Now(),
+	}
+
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reserveBudget(relayInfo, 10) == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 10, reserved.Load())
+	used, err := model.GetBudgetUsage(relayBudgetWindows(relayInfo)[0].Key)
+	require.NoError(t, err)
+	require.Equal(t, 100, used)
+}
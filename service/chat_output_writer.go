@@ -0,0 +1,160 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chatOutputTranslator 将 chat/completions 格式的输出翻译为客户端请求的格式
+type chatOutputTranslator interface {
+	// Chunk 翻译一个流式分片，返回需要写出的 SSE 帧
+	Chunk(chunk *dto.ChatCompletionsStreamResponse) []string
+	// Finish 返回流结束时需要补发的 SSE 帧
+	Finish(usage *dto.Usage) []string
+	// Convert 翻译非流式响应，返回响应体
+	Convert(resp *dto.OpenAITextResponse, usage *dto.Usage) ([]byte, error)
+}
+
+// chatOutputWriter 替换 c.Writer，将渠道按 chat/completions 格式写出的响应交给 translator 翻译。
+// 流式按行翻译并立即转发，非流式缓冲完整响应体后在 finish 中转换
+type chatOutputWriter struct {
+	gin.ResponseWriter
+	c          *gin.Context
+	stream     bool
+	translator chatOutputTranslator
+	buffer     bytes.Buffer
+	status     int
+}
+
+func newChatOutputWriter(c *gin.Context, translator chatOutputTranslator, stream bool) *chatOutputWriter {
+	w := &chatOutputWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		stream:         stream,
+		translator:     translator,
+		status:         http.StatusOK,
+	}
+	c.Writer = w
+	return w
+}
+
+func (w *chatOutputWriter) WriteHeader(code int) {
+	if w.stream {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *chatOutputWriter) WriteHeaderNow() {
+	if w.stream {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *chatOutputWriter) Status() int {
+	if w.stream {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *chatOutputWriter) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *chatOutputWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.stream {
+		w.translateLines()
+	}
+	return len(data), nil
+}
+
+func (w *chatOutputWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// translateLines 处理缓冲区中的完整行，不完整的行留待下次写入
+func (w *chatOutputWriter) translateLines() {
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// 没有换行符，放回缓冲区
+			w.buffer.WriteString(line)
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, ":"):
+			// 保活注释原样转发
+			_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "" || data == "[DONE]" {
+				continue
+			}
+			var chunk dto.ChatCompletionsStreamResponse
+			if err := common.UnmarshalJsonStr(data, &chunk); err != nil {
+				logger.LogError(w.c, "failed to unmarshal chat stream chunk: "+err.Error())
+				continue
+			}
+			w.writeFrames(w.translator.Chunk(&chunk))
+		}
+	}
+}
+
+func (w *chatOutputWriter) writeFrames(frames []string) {
+	for _, frame := range frames {
+		_, _ = w.ResponseWriter.WriteString(frame)
+	}
+	if len(frames) > 0 {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// finish 还原 c.Writer 并写出剩余内容，返回是否成功写出翻译后的响应。
+// failed 为 true 时丢弃非流式的缓冲内容，由调用方按正常流程返回错误
+func (w *chatOutputWriter) finish(usage *dto.Usage, failed bool) bool {
+	w.c.Writer = w.ResponseWriter
+	if failed {
+		return false
+	}
+
+	if w.stream {
+		w.buffer.WriteString("\n")
+		w.translateLines()
+		w.writeFrames(w.translator.Finish(usage))
+		return true
+	}
+
+	var chatResp dto.OpenAITextResponse
+	if err := common.Unmarshal(w.buffer.Bytes(), &chatResp); err != nil {
+		logger.LogError(w.c, "failed to unmarshal chat response for conversion: "+err.Error())
+		w.ResponseWriter.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+		return false
+	}
+	data, err := w.translator.Convert(&chatResp, usage)
+	if err != nil {
+		logger.LogError(w.c, "failed to convert chat response: "+err.Error())
+		return false
+	}
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(data)
+	return true
+}
@@ -551,6 +551,8 @@ func stopReasonOpenAI2Claude(reason string) string {
 		return "max_tokens"
 	case "tool_calls":
 		return "tool_use"
+	case "content_filter":
+		return "refusal"
 	default:
 		return reason
 	}
@@ -570,6 +572,10 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 		Stream: info.IsStream,
 	}
 
+	// gemini 的 functionCall 没有 id，按出现顺序生成，functionResponse 按函数名依次对应
+	callCount := 0
+	pendingCallIds := make(map[string][]string)
+
 	// 转换 messages
 	var messages []dto.Message
 	for _, content := range geminiRequest.Contents {
@@ -581,6 +587,10 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 		var mediaContents []dto.MediaContent
 		var toolCalls []dto.ToolCallRequest
 		for _, part := range content.Parts {
+			if part.Thought {
+				// 思考内容不回传
+				continue
+			}
 			if part.Text != "" {
 				mediaContent := dto.MediaContent{
 					Type: "text",
@@ -588,15 +598,7 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 				}
 				mediaContents = append(mediaContents, mediaContent)
 			} else if part.InlineData != nil {
-				mediaContent := dto.MediaContent{
-					Type: "image_url",
-					ImageUrl: &dto.MessageImageUrl{
-						Url:      fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data),
-						Detail:   "auto",
-						MimeType: part.InlineData.MimeType,
-					},
-				}
-				mediaContents = append(mediaContents, mediaContent)
+				mediaContents = append(mediaContents, geminiInlineDataToMediaContent(part.InlineData))
 			} else if part.FileData != nil {
 				mediaContent := dto.MediaContent{
 					Type: "image_url",
@@ -609,8 +611,11 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 				mediaContents = append(mediaContents, mediaContent)
 			} else if part.FunctionCall != nil {
 				// 处理 Gemini 的工具调用
+				callCount++
+				callId := fmt.Sprintf("call_%d", callCount)
+				pendingCallIds[part.FunctionCall.FunctionName] = append(pendingCallIds[part.FunctionCall.FunctionName], callId)
 				toolCall := dto.ToolCallRequest{
-					ID:   fmt.Sprintf("call_%d", len(toolCalls)+1), // 生成唯一ID
+					ID:   callId,
 					Type: "function",
 					Function: dto.FunctionRequest{
 						Name:      part.FunctionCall.FunctionName,
@@ -620,26 +625,35 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 				toolCalls = append(toolCalls, toolCall)
 			} else if part.FunctionResponse != nil {
 				// 处理 Gemini 的工具响应，创建单独的 tool 消息
+				name := part.FunctionResponse.Name
+				var callId string
+				if ids := pendingCallIds[name]; len(ids) > 0 {
+					callId = ids[0]
+					pendingCallIds[name] = ids[1:]
+				} else {
+					callCount++
+					callId = fmt.Sprintf("call_%d", callCount)
+				}
 				toolMessage := dto.Message{
 					Role:       "tool",
-					ToolCallId: fmt.Sprintf("call_%d", len(toolCalls)), // 使用对应的调用ID
+					ToolCallId: callId,
 				}
 				toolMessage.SetStringContent(toJSONString(part.FunctionResponse.Response))
 				messages = append(messages, toolMessage)
 			}
 		}
 
-		// 设置消息内容
-		if len(toolCalls) > 0 {
-			// 如果有工具调用，设置工具调用
-			message.SetToolCalls(toolCalls)
-		} else if len(mediaContents) == 1 && mediaContents[0].Type == "text" {
+		// 设置消息内容，文本与工具调用可同时存在
+		if len(mediaContents) == 1 && mediaContents[0].Type == "text" {
 			// 如果只有一个文本内容，直接设置字符串
 			message.Content = mediaContents[0].Text
 		} else if len(mediaContents) > 0 {
 			// 如果有多个内容或包含媒体，设置为数组
 			message.SetMediaContent(mediaContents)
 		}
+		if len(toolCalls) > 0 {
+			message.SetToolCalls(toolCalls)
+		}
 
 		// 只有当消息有内容或工具调用时才添加
 		if len(message.ParseContent()) > 0 || len(message.ToolCalls) > 0 {
@@ -662,12 +676,16 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 		openaiRequest.MaxTokens = geminiRequest.GenerationConfig.MaxOutputTokens
 	}
 	// gemini stop sequences 最多 5 个，openai stop 最多 4 个
-	if len(geminiRequest.GenerationConfig.StopSequences) > 0 {
-		openaiRequest.Stop = geminiRequest.GenerationConfig.StopSequences[:4]
+	if stops := geminiRequest.GenerationConfig.StopSequences; len(stops) > 0 {
+		if len(stops) > 4 {
+			stops = stops[:4]
+		}
+		openaiRequest.Stop = stops
 	}
 	if geminiRequest.GenerationConfig.CandidateCount > 0 {
 		openaiRequest.N = geminiRequest.GenerationConfig.CandidateCount
 	}
+	openaiRequest.ResponseFormat = geminiResponseFormatToOpenAI(&geminiRequest.GenerationConfig)
 
 	// 转换工具调用
 	if len(geminiRequest.GetTools()) > 0 {
@@ -685,7 +703,7 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 						Function: dto.FunctionRequest{
 							Name:        function.Name,
 							Description: function.Description,
-							Parameters:  function.Parameters,
+							Parameters:  lowerGeminiSchemaTypes(function.Parameters),
 						},
 					}
 					tools = append(tools, openAITool)
@@ -694,6 +712,7 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 		}
 		if len(tools) > 0 {
 			openaiRequest.Tools = tools
+			openaiRequest.ToolChoice = geminiToolConfigToOpenAI(geminiRequest.ToolConfig)
 		}
 	}
 
@@ -710,6 +729,116 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 	return openaiRequest, nil
 }
 
+// geminiInlineDataToMediaContent 按 mimeType 将内联数据转换为图片、音频或文件
+func geminiInlineDataToMediaContent(data *dto.GeminiInlineData) dto.MediaContent {
+	mimeType := strings.ToLower(data.MimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "audio/"):
+		format := strings.TrimPrefix(mimeType, "audio/")
+		switch format {
+		case "mpeg", "mp3":
+			format = "mp3"
+		case "wave", "x-wav":
+			format = "wav"
+		}
+		return dto.MediaContent{
+			Type: dto.ContentTypeInputAudio,
+			InputAudio: &dto.MessageInputAudio{
+				Data:   data.Data,
+				Format: format,
+			},
+		}
+	case mimeType == "" || strings.HasPrefix(mimeType, "image/"):
+		return dto.MediaContent{
+			Type: dto.ContentTypeImageURL,
+			ImageUrl: &dto.MessageImageUrl{
+				Url:      fmt.Sprintf("data:%s;base64,%s", data.MimeType, data.Data),
+				Detail:   "auto",
+				MimeType: data.MimeType,
+			},
+		}
+	default:
+		return dto.MediaContent{
+			Type: dto.ContentTypeFile,
+			File: &dto.MessageFile{
+				FileData: fmt.Sprintf("data:%s;base64,%s", data.MimeType, data.Data),
+			},
+		}
+	}
+}
+
+// geminiToolConfigToOpenAI 将 functionCallingConfig 转换为 tool_choice
+func geminiToolConfigToOpenAI(toolConfig *dto.ToolConfig) any {
+	if toolConfig == nil || toolConfig.FunctionCallingConfig == nil {
+		return nil
+	}
+	config := toolConfig.FunctionCallingConfig
+	switch strings.ToUpper(string(config.Mode)) {
+	case "AUTO":
+		return "auto"
+	case "NONE":
+		return "none"
+	case "ANY", "VALIDATED":
+		if len(config.AllowedFunctionNames) == 1 {
+			return map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": config.AllowedFunctionNames[0]},
+			}
+		}
+		return "required"
+	}
+	return nil
+}
+
+// geminiResponseFormatToOpenAI 将 responseMimeType 与 schema 转换为 response_format
+func geminiResponseFormatToOpenAI(config *dto.GeminiChatGenerationConfig) *dto.ResponseFormat {
+	if !strings.EqualFold(config.ResponseMimeType, "application/json") {
+		return nil
+	}
+	var schema any
+	if len(config.ResponseJsonSchema) > 0 {
+		schema = config.ResponseJsonSchema
+	} else if config.ResponseSchema != nil {
+		schema = lowerGeminiSchemaTypes(config.ResponseSchema)
+	}
+	if schema == nil {
+		return &dto.ResponseFormat{Type: "json_object"}
+	}
+	jsonSchema, err := common.Marshal(map[string]any{
+		"name":   "response",
+		"schema": schema,
+	})
+	if err != nil {
+		return &dto.ResponseFormat{Type: "json_object"}
+	}
+	return &dto.ResponseFormat{Type: "json_schema", JsonSchema: jsonSchema}
+}
+
+// lowerGeminiSchemaTypes Gemini 的 schema 为 OpenAPI 子集，类型名为大写，转换为 JSON Schema 的小写形式
+func lowerGeminiSchemaTypes(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			if key == "type" {
+				if t, ok := value.(string); ok {
+					out[key] = strings.ToLower(t)
+					continue
+				}
+			}
+			out[key] = lowerGeminiSchemaTypes(value)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, value := range v {
+			out[i] = lowerGeminiSchemaTypes(value)
+		}
+		return out
+	}
+	return schema
+}
+
 func convertGeminiRoleToOpenAI(geminiRole string) string {
 	switch geminiRole {
 	case "user":
@@ -751,19 +880,7 @@ func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse, info *relayco
 		}
 
 		// 设置结束原因
-		var finishReason string
-		switch choice.FinishReason {
-		case "stop":
-			finishReason = "STOP"
-		case "length":
-			finishReason = "MAX_TOKENS"
-		case "content_filter":
-			finishReason = "SAFETY"
-		case "tool_calls":
-			finishReason = "STOP"
-		default:
-			finishReason = "STOP"
-		}
+		finishReason := convertOpenAIFinishReasonToGemini(choice.FinishReason)
 		candidate.FinishReason = &finishReason
 
 		// 转换消息内容
@@ -772,37 +889,20 @@ func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse, info *relayco
 			Parts: make([]dto.GeminiPart, 0),
 		}
 
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			content.Parts = append(content.Parts, dto.GeminiPart{Text: reasoning, Thought: true})
+		}
+		// 处理文本内容
+		if textContent := choice.Message.StringContent(); textContent != "" {
+			content.Parts = append(content.Parts, dto.GeminiPart{Text: textContent})
+		}
 		// 处理工具调用
-		toolCalls := choice.Message.ParseToolCalls()
-		if len(toolCalls) > 0 {
-			for _, toolCall := range toolCalls {
-				// 解析参数
-				var args map[string]interface{}
-				if toolCall.Function.Arguments != "" {
-					if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
-						args = map[string]interface{}{"arguments": toolCall.Function.Arguments}
-					}
-				} else {
-					args = make(map[string]interface{})
-				}
-
-				part := dto.GeminiPart{
-					FunctionCall: &dto.FunctionCall{
-						FunctionName: toolCall.Function.Name,
-						Arguments:    args,
-					},
-				}
-				content.Parts = append(content.Parts, part)
-			}
-		} else {
-			// 处理文本内容
-			textContent := choice.Message.StringContent()
-			if textContent != "" {
-				part := dto.GeminiPart{
-					Text: textContent,
-				}
-				content.Parts = append(content.Parts, part)
-			}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			content.Parts = append(content.Parts, openAIToolCallToGeminiPart(toolCall.Function.Name, toolCall.Function.Arguments))
 		}
 
 		candidate.Content = content
@@ -812,13 +912,64 @@ func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse, info *relayco
 	return geminiResponse
 }
 
-// StreamResponseOpenAI2Gemini 将 OpenAI 流式响应转换为 Gemini 格式
+func convertOpenAIFinishReasonToGemini(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func openAIToolCallToGeminiPart(name string, arguments string) dto.GeminiPart {
+	// 解析参数
+	var args map[string]interface{}
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			args = map[string]interface{}{"arguments": arguments}
+		}
+	} else {
+		args = make(map[string]interface{})
+	}
+	return dto.GeminiPart{
+		FunctionCall: &dto.FunctionCall{
+			FunctionName: name,
+			Arguments:    args,
+		},
+	}
+}
+
+// StreamResponseOpenAI2Gemini 将 OpenAI 流式响应转换为 Gemini 格式。
+// 工具调用参数以分片形式到达，累积到 GeminiConvertInfo 中，在结束时作为完整的 functionCall 输出
 func StreamResponseOpenAI2Gemini(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) *dto.GeminiChatResponse {
+	if info.GeminiConvertInfo == nil {
+		info.GeminiConvertInfo = &relaycommon.GeminiConvertInfo{}
+	}
+	convertInfo := info.GeminiConvertInfo
+
 	// 检查是否有实际内容或结束标志
 	hasContent := false
 	hasFinishReason := false
 	for _, choice := range openAIResponse.Choices {
-		if len(choice.Delta.GetContentString()) > 0 || (choice.Delta.ToolCalls != nil && len(choice.Delta.ToolCalls) > 0) {
+		for _, toolCall := range choice.Delta.ToolCalls {
+			index := len(convertInfo.ToolCalls)
+			if toolCall.Index != nil {
+				index = *toolCall.Index
+			} else if toolCall.ID == "" && index > 0 {
+				index--
+			}
+			for len(convertInfo.ToolCalls) <= index {
+				convertInfo.ToolCalls = append(convertInfo.ToolCalls, &dto.ToolCallResponse{})
+			}
+			accumulated := convertInfo.ToolCalls[index]
+			if toolCall.Function.Name != "" {
+				accumulated.Function.Name = toolCall.Function.Name
+			}
+			accumulated.Function.Arguments += toolCall.Function.Arguments
+		}
+		if len(choice.Delta.GetContentString()) > 0 || len(choice.Delta.GetReasoningContent()) > 0 {
 			hasContent = true
 		}
 		if choice.FinishReason != nil {
@@ -826,7 +977,7 @@ func StreamResponseOpenAI2Gemini(openAIResponse *dto.ChatCompletionsStreamRespon
 		}
 	}
 
-	// 如果没有实际内容且没有结束标志，跳过。主要针对 openai 流响应开头的空数据
+	// 如果没有实际内容且没有结束标志，跳过。主要针对 openai 流响应开头的空数据以及工具调用参数分片
 	if !hasContent && !hasFinishReason {
 		return nil
 	}
@@ -852,60 +1003,31 @@ func StreamResponseOpenAI2Gemini(openAIResponse *dto.ChatCompletionsStreamRespon
 			SafetyRatings: []dto.GeminiChatSafetyRating{},
 		}
 
-		// 设置结束原因
-		if choice.FinishReason != nil {
-			var finishReason string
-			switch *choice.FinishReason {
-			case "stop":
-				finishReason = "STOP"
-			case "length":
-				finishReason = "MAX_TOKENS"
-			case "content_filter":
-				finishReason = "SAFETY"
-			case "tool_calls":
-				finishReason = "STOP"
-			default:
-				finishReason = "STOP"
-			}
-			candidate.FinishReason = &finishReason
-		}
-
 		// 转换消息内容
 		content := dto.GeminiChatContent{
 			Role:  "model",
 			Parts: make([]dto.GeminiPart, 0),
 		}
 
-		// 处理工具调用
-		if choice.Delta.ToolCalls != nil {
-			for _, toolCall := range choice.Delta.ToolCalls {
-				// 解析参数
-				var args map[string]interface{}
-				if toolCall.Function.Arguments != "" {
-					if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
-						args = map[string]interface{}{"arguments": toolCall.Function.Arguments}
-					}
-				} else {
-					args = make(map[string]interface{})
-				}
+		if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+			content.Parts = append(content.Parts, dto.GeminiPart{Text: reasoning, Thought: true})
+		}
+		// 处理文本内容
+		if textContent := choice.Delta.GetContentString(); textContent != "" {
+			content.Parts = append(content.Parts, dto.GeminiPart{Text: textContent})
+		}
 
-				part := dto.GeminiPart{
-					FunctionCall: &dto.FunctionCall{
-						FunctionName: toolCall.Function.Name,
-						Arguments:    args,
-					},
-				}
-				content.Parts = append(content.Parts, part)
-			}
-		} else {
-			// 处理文本内容
-			textContent := choice.Delta.GetContentString()
-			if textContent != "" {
-				part := dto.GeminiPart{
-					Text: textContent,
+		// 设置结束原因，并输出累积的工具调用
+		if choice.FinishReason != nil {
+			finishReason := convertOpenAIFinishReasonToGemini(*choice.FinishReason)
+			candidate.FinishReason = &finishReason
+			for _, toolCall := range convertInfo.ToolCalls {
+				if toolCall.Function.Name == "" {
+					continue
 				}
-				content.Parts = append(content.Parts, part)
+				content.Parts = append(content.Parts, openAIToolCallToGeminiPart(toolCall.Function.Name, toolCall.Function.Arguments))
 			}
+			convertInfo.ToolCalls = nil
 		}
 
 		candidate.Content = content
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// FileStore 文件存储后端，默认使用本地磁盘，可通过 SetFileStore 替换为其他实现
+type FileStore interface {
+	Put(name string, reader io.Reader) (int64, error)
+	Open(name string) (io.ReadCloser, error)
+	Delete(name string) error
+}
+
+type localFileStore struct {
+	dir string
+}
+
+var (
+	fileStore     FileStore
+	fileStoreLock sync.RWMutex
+)
+
+// SetFileStore 替换全局文件存储后端
+func SetFileStore(store FileStore) {
+	fileStoreLock.Lock()
+	defer fileStoreLock.Unlock()
+	fileStore = store
+}
+
+// GetFileStore 获取全局文件存储后端，未设置时使用本地磁盘
+func GetFileStore() FileStore {
+	fileStoreLock.RLock()
+	store := fileStore
+	fileStoreLock.RUnlock()
+	if store != nil {
+		return store
+	}
+	return &localFileStore{dir: operation_setting.GetBatchSetting().StorageDir}
+}
+
+func (s *localFileStore) path(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid file name: %s", name)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+func (s *localFileStore) Put(name string, reader io.Reader) (int64, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return 0, err
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, reader)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, err
+	}
+	return n, os.Rename(tmpPath, path)
+}
+
+func (s *localFileStore) Open(name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *localFileStore) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
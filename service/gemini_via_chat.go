@@ -0,0 +1,93 @@
+package service
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeminiViaChatWriter 将渠道按 chat/completions 格式写出的响应翻译为 Gemini generateContent 格式
+type GeminiViaChatWriter struct {
+	*chatOutputWriter
+}
+
+func NewGeminiViaChatWriter(c *gin.Context, info *relaycommon.RelayInfo, stream bool) *GeminiViaChatWriter {
+	return &GeminiViaChatWriter{
+		chatOutputWriter: newChatOutputWriter(c, &geminiChatTranslator{info: info}, stream),
+	}
+}
+
+// Finish 还原 c.Writer 并写出剩余内容
+func (w *GeminiViaChatWriter) Finish(usage *dto.Usage, failed bool) {
+	w.finish(usage, failed)
+}
+
+type geminiChatTranslator struct {
+	info *relaycommon.RelayInfo
+	// 带 finishReason 的分片暂存到流结束，补上 usageMetadata 后再发送
+	pending *dto.GeminiChatResponse
+}
+
+func (t *geminiChatTranslator) Chunk(chunk *dto.ChatCompletionsStreamResponse) []string {
+	resp := StreamResponseOpenAI2Gemini(chunk, t.info)
+	if resp == nil {
+		return nil
+	}
+	finished := false
+	for _, candidate := range resp.Candidates {
+		if candidate.FinishReason != nil {
+			finished = true
+			break
+		}
+	}
+	if !finished {
+		return geminiDataFrames(resp)
+	}
+	frames := geminiDataFrames(t.pending)
+	t.pending = resp
+	return frames
+}
+
+func (t *geminiChatTranslator) Finish(usage *dto.Usage) []string {
+	resp := t.pending
+	t.pending = nil
+	if resp == nil {
+		resp = &dto.GeminiChatResponse{Candidates: []dto.GeminiChatCandidate{}}
+	}
+	if usage != nil {
+		resp.UsageMetadata = GeminiUsageMetadataFromUsage(usage)
+	}
+	return geminiDataFrames(resp)
+}
+
+func (t *geminiChatTranslator) Convert(chatResp *dto.OpenAITextResponse, usage *dto.Usage) ([]byte, error) {
+	resp := ResponseOpenAI2Gemini(chatResp, t.info)
+	if usage != nil {
+		resp.UsageMetadata = GeminiUsageMetadataFromUsage(usage)
+	}
+	return common.Marshal(resp)
+}
+
+// GeminiUsageMetadataFromUsage 将 OpenAI usage 转换为 Gemini usageMetadata
+func GeminiUsageMetadataFromUsage(usage *dto.Usage) dto.GeminiUsageMetadata {
+	return dto.GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+		ThoughtsTokenCount:   usage.CompletionTokenDetails.ReasoningTokens,
+	}
+}
+
+func geminiDataFrames(resp *dto.GeminiChatResponse) []string {
+	if resp == nil {
+		return nil
+	}
+	data, err := common.Marshal(resp)
+	if err != nil {
+		common.SysError("failed to marshal gemini stream response: " + err.Error())
+		return nil
+	}
+	return []string{"data: " + string(data) + "\n\n"}
+}
@@ -0,0 +1,355 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func marshalForTest(t *testing.T, v any) string {
+	t.Helper()
+	data, err := common.Marshal(v)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func jsonFieldForTest(t *testing.T, data string, key string) any {
+	t.Helper()
+	var m map[string]any
+	require.NoError(t, common.UnmarshalJsonStr(data, &m))
+	return m[key]
+}
+
+func newGeminiTestRelayInfo(stream bool) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		IsStream:    stream,
+		ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gpt-4o"},
+	}
+}
+
+func TestGeminiToOpenAIRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   string
+		check func(t *testing.T, out *dto.GeneralOpenAIRequest)
+	}{
+		{
+			name: "system instruction and roles",
+			req: `{"systemInstruction":{"parts":[{"text":"be brief"},{"text":"be kind"}]},
+				"contents":[{"role":"user","parts":[{"text":"hi"}]},{"role":"model","parts":[{"text":"thinking","thought":true},{"text":"hello"}]}]}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.Equal(t, "gpt-4o", out.Model)
+				require.JSONEq(t, `[
+					{"role":"system","content":"be brief\nbe kind"},
+					{"role":"user","content":"hi"},
+					{"role":"assistant","content":"hello"}
+				]`, marshalForTest(t, out.Messages))
+			},
+		},
+		{
+			name: "function calls paired with responses by name",
+			req: `{"contents":[
+				{"role":"user","parts":[{"text":"weather?"}]},
+				{"role":"model","parts":[{"text":"checking"},{"functionCall":{"name":"weather","args":{"city":"a"}}},{"functionCall":{"name":"time","args":{}}},{"functionCall":{"name":"weather","args":{"city":"b"}}}]},
+				{"role":"user","parts":[{"functionResponse":{"name":"weather","response":{"t":1}}},{"functionResponse":{"name":"time","response":{"h":2}}},{"functionResponse":{"name":"weather","response":{"t":3}}}]}
+			]}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.Len(t, out.Messages, 5)
+				assistant := out.Messages[1]
+				require.Equal(t, "checking", assistant.StringContent())
+				var ids []string
+				for _, call := range assistant.ParseToolCalls() {
+					ids = append(ids, call.ID)
+				}
+				require.Equal(t, []string{"call_1", "call_2", "call_3"}, ids)
+				require.Equal(t, "call_1", out.Messages[2].ToolCallId)
+				require.Equal(t, "call_2", out.Messages[3].ToolCallId)
+				require.Equal(t, "call_3", out.Messages[4].ToolCallId)
+				require.JSONEq(t, `{"t":3}`, out.Messages[4].StringContent())
+			},
+		},
+		{
+			name: "inline data by mime type",
+			req: `{"contents":[{"role":"user","parts":[
+				{"inlineData":{"mimeType":"image/png","data":"aW1n"}},
+				{"inlineData":{"mimeType":"audio/mpeg","data":"YXVk"}},
+				{"inlineData":{"mimeType":"application/pdf","data":"cGRm"}}
+			]}]}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.JSONEq(t, `[{"role":"user","content":[
+					{"type":"image_url","image_url":{"url":"data:image/png;base64,aW1n","detail":"auto","MimeType":"image/png"}},
+					{"type":"input_audio","input_audio":{"data":"YXVk","format":"mp3"}},
+					{"type":"file","file":{"file_data":"data:application/pdf;base64,cGRm"}}
+				]}]`, marshalForTest(t, out.Messages))
+			},
+		},
+		{
+			name: "generation config",
+			req: `{"contents":[{"role":"user","parts":[{"text":"hi"}]}],
+				"generationConfig":{"maxOutputTokens":50,"stopSequences":["a","b","c","d","e"],"responseMimeType":"application/json",
+					"responseSchema":{"type":"OBJECT","properties":{"n":{"type":"INTEGER"}}}}}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.Equal(t, uint(50), out.MaxTokens)
+				require.Equal(t, []string{"a", "b", "c", "d"}, out.Stop)
+				require.Equal(t, "json_schema", out.ResponseFormat.Type)
+				require.JSONEq(t, `{"name":"response","schema":{"type":"object","properties":{"n":{"type":"integer"}}}}`, string(out.ResponseFormat.JsonSchema))
+			},
+		},
+		{
+			name: "json mime type without schema",
+			req:  `{"contents":[{"role":"user","parts":[{"text":"hi"}]}],"generationConfig":{"responseMimeType":"application/json","stopSequences":["x"]}}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.Equal(t, "json_object", out.ResponseFormat.Type)
+				require.Equal(t, []string{"x"}, out.Stop)
+			},
+		},
+		{
+			name: "tools and forced function",
+			req: `{"contents":[{"role":"user","parts":[{"text":"hi"}]}],
+				"tools":[{"functionDeclarations":[{"name":"weather","description":"d","parameters":{"type":"OBJECT","properties":{"city":{"type":"STRING"}}}}]}],
+				"toolConfig":{"functionCallingConfig":{"mode":"ANY","allowedFunctionNames":["weather"]}}}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.JSONEq(t, `[{"type":"function","function":{"name":"weather","description":"d","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}}]`,
+					marshalForTest(t, out.Tools))
+				require.Equal(t, map[string]any{"type": "function", "function": map[string]any{"name": "weather"}}, out.ToolChoice)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req dto.GeminiChatRequest
+			require.NoError(t, common.UnmarshalJsonStr(tt.req, &req))
+			out, err := GeminiToOpenAIRequest(&req, newGeminiTestRelayInfo(false))
+			require.NoError(t, err)
+			tt.check(t, out)
+		})
+	}
+}
+
+func TestGeminiToolConfigToOpenAI(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *dto.ToolConfig
+		want   any
+	}{
+		{name: "nil", want: nil},
+		{name: "auto", config: &dto.ToolConfig{FunctionCallingConfig: &dto.FunctionCallingConfig{Mode: "AUTO"}}, want: "auto"},
+		{name: "none", config: &dto.ToolConfig{FunctionCallingConfig: &dto.FunctionCallingConfig{Mode: "none"}}, want: "none"},
+		{name: "any of several", config: &dto.ToolConfig{FunctionCallingConfig: &dto.FunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{"a", "b"}}}, want: "required"},
+		{name: "unknown mode", config: &dto.ToolConfig{FunctionCallingConfig: &dto.FunctionCallingConfig{Mode: "MODE_UNSPECIFIED"}}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, geminiToolConfigToOpenAI(tt.config))
+		})
+	}
+}
+
+func TestStreamResponseOpenAI2Gemini(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string // 每个分片转换后的 candidates，nil 表示跳过
+	}{
+		{
+			name: "text and finish reason",
+			chunks: []string{
+				`{"choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+				`{"choices":[{"index":0,"delta":{"reasoning_content":"hmm","content":"Hi"}}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`,
+			},
+			want: []string{
+				``,
+				`[{"index":0,"content":{"role":"model","parts":[{"text":"hmm","thought":true},{"text":"Hi"}]},"finishReason":null,"safetyRatings":[]}]`,
+				`[{"index":0,"content":{"role":"model","parts":[]},"finishReason":"MAX_TOKENS","safetyRatings":[]}]`,
+			},
+		},
+		{
+			name: "tool call arguments accumulate until finish",
+			chunks: []string{
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"c1","function":{"name":"weather","arguments":"{\"ci"}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"a\"}"}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"c2","function":{"name":"time","arguments":"bad"}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			},
+			want: []string{
+				``,
+				``,
+				``,
+				`[{"index":0,"content":{"role":"model","parts":[{"functionCall":{"name":"weather","args":{"city":"a"}}},{"functionCall":{"name":"time","args":{"arguments":"bad"}}}]},"finishReason":"STOP","safetyRatings":[]}]`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := newGeminiTestRelayInfo(true)
+			for i, raw := range tt.chunks {
+				var chunk dto.ChatCompletionsStreamResponse
+				require.NoError(t, common.UnmarshalJsonStr(raw, &chunk))
+				resp := StreamResponseOpenAI2Gemini(&chunk, info)
+				if tt.want[i] == "" {
+					require.Nil(t, resp, "chunk %d", i)
+					continue
+				}
+				require.NotNil(t, resp, "chunk %d", i)
+				require.JSONEq(t, tt.want[i], marshalForTest(t, resp.Candidates), "chunk %d", i)
+			}
+		})
+	}
+}
+
+func newChatOutputTestContext() (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	return c, recorder
+}
+
+// sseDataLines 取出 SSE 响应中的 data 行
+func sseDataLines(body string) []string {
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "data: ") {
+			lines = append(lines, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return lines
+}
+
+func TestGeminiViaChatWriterStream(t *testing.T) {
+	c, recorder := newChatOutputTestContext()
+	writer := NewGeminiViaChatWriter(c, newGeminiTestRelayInfo(true), true)
+	require.Same(t, writer.chatOutputWriter, c.Writer)
+
+	// 渠道的写入可能在任意位置断开
+	writes := []string{
+		`: keep-alive` + "\n\n",
+		`data: {"choices":[{"index":0,"delta":{"content":"Hel"}}]}` + "\n\n" + `data: {"choices":[{"index":0,"del`,
+		`ta":{"content":"lo"}}]}` + "\n\n",
+		`data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n",
+		"data: [DONE]\n\n",
+	}
+	for _, data := range writes {
+		_, err := c.Writer.WriteString(data)
+		require.NoError(t, err)
+	}
+	writer.Finish(&dto.Usage{PromptTokens: 3, CompletionTokens: 2}, false)
+	require.NotSame(t, writer.chatOutputWriter, c.Writer)
+
+	body := recorder.Body.String()
+	require.True(t, strings.HasPrefix(body, ": keep-alive\n\n"))
+	lines := sseDataLines(body)
+	require.Len(t, lines, 3)
+	require.JSONEq(t, `[{"index":0,"content":{"role":"model","parts":[{"text":"Hel"}]},"finishReason":null,"safetyRatings":[]}]`,
+		marshalForTest(t, jsonFieldForTest(t, lines[0], "candidates")))
+	// 结束分片暂存到流结束，补上最终用量
+	var last dto.GeminiChatResponse
+	require.NoError(t, common.UnmarshalJsonStr(lines[2], &last))
+	require.Equal(t, "STOP", *last.Candidates[0].FinishReason)
+	require.Equal(t, 5, last.UsageMetadata.TotalTokenCount)
+}
+
+func TestChatOutputWriterNonStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		failed     bool
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "converted response",
+			body:       `{"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1}}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"hi"}]},"finishReason":"STOP","safetyRatings":[]}],"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":2,"totalTokenCount":6,"thoughtsTokenCount":0,"promptTokensDetails":null}}`,
+		},
+		{
+			name:   "failed request writes nothing",
+			body:   `{"error":{"message":"bad"}}`,
+			failed: true,
+		},
+		{
+			name:       "unparsable body is passed through",
+			body:       `not json`,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `not json`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, recorder := newChatOutputTestContext()
+			writer := NewGeminiViaChatWriter(c, newGeminiTestRelayInfo(false), false)
+			status := tt.wantStatus
+			if status == 0 {
+				status = http.StatusOK
+			}
+			c.Writer.WriteHeader(status)
+			_, err := c.Writer.Write([]byte(tt.body))
+			require.NoError(t, err)
+			require.Equal(t, status, c.Writer.Status())
+			require.Zero(t, recorder.Body.Len(), "non-stream output is buffered")
+
+			writer.Finish(&dto.Usage{PromptTokens: 4, CompletionTokens: 2}, tt.failed)
+			if tt.failed {
+				require.Zero(t, recorder.Body.Len())
+				return
+			}
+			require.Equal(t, tt.wantStatus, recorder.Code)
+			if tt.wantBody == "not json" {
+				require.Equal(t, tt.wantBody, recorder.Body.String())
+				return
+			}
+			require.JSONEq(t, tt.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestResponsesViaChatWriter(t *testing.T) {
+	newResponse := func() *dto.OpenAIResponsesResponse {
+		return &dto.OpenAIResponsesResponse{ID: "resp_1", Object: "response", Status: "in_progress", Output: []dto.ResponsesOutput{}}
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		c, recorder := newChatOutputTestContext()
+		writer := NewResponsesViaChatWriter(c, newResponse(), true)
+		_, err := c.Writer.WriteString(`data: {"choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}` + "\n\ndata: [DONE]\n\n")
+		require.NoError(t, err)
+		resp := writer.Finish(&dto.Usage{PromptTokens: 1, CompletionTokens: 1}, false)
+		require.NotNil(t, resp)
+		require.Equal(t, "completed", resp.Status)
+		require.Equal(t, 2, resp.Usage.TotalTokens)
+
+		body := recorder.Body.String()
+		require.Contains(t, body, "event: response.created\n")
+		require.Contains(t, body, "event: response.output_text.delta\n")
+		require.True(t, strings.HasSuffix(body, "\n\n"))
+		lines := sseDataLines(body)
+		require.Equal(t, "response.completed", jsonFieldForTest(t, lines[len(lines)-1], "type"))
+	})
+
+	t.Run("non stream", func(t *testing.T) {
+		c, recorder := newChatOutputTestContext()
+		writer := NewResponsesViaChatWriter(c, newResponse(), false)
+		_, err := c.Writer.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"length"}]}`))
+		require.NoError(t, err)
+		resp := writer.Finish(nil, false)
+		require.NotNil(t, resp)
+		require.Equal(t, "incomplete", resp.Status)
+		require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		require.Equal(t, "incomplete", jsonFieldForTest(t, recorder.Body.String(), "status"))
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		c, _ := newChatOutputTestContext()
+		writer := NewResponsesViaChatWriter(c, newResponse(), false)
+		require.Nil(t, writer.Finish(nil, true))
+	})
+}
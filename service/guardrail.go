@@ -0,0 +1,609 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// 流式检查时保留上一段输出的末尾，用于匹配跨分片的关键词
+const guardrailStreamOverlap = 64
+
+// 请求体中这些字段下的字符串视为提示词，脱敏时只改写这些字段
+var promptTextKeys = map[string]bool{
+	"content":      true,
+	"text":         true,
+	"input":        true,
+	"prompt":       true,
+	"instructions": true,
+	"system":       true,
+}
+
+// 非流式响应体中这些字段下的字符串视为输出文本，覆盖 OpenAI、Responses、Claude 与 Gemini 格式
+var outputTextKeys = map[string]bool{
+	"content": true,
+	"text":    true,
+}
+
+var guardrailPatternCache sync.Map
+
+func getGuardrailPattern(pattern string) *regexp.Regexp {
+	if re, ok := guardrailPatternCache.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		common.SysError(fmt.Sprintf("invalid guardrail pattern %s: %s", pattern, err.Error()))
+		re = nil
+	}
+	guardrailPatternCache.Store(pattern, re)
+	return re
+}
+
+// guardrailRulePatterns 关键词规则转为不区分大小写的正则，与正则规则统一处理
+func guardrailRulePatterns(rule *operation_setting.GuardrailRule) []*regexp.Regexp {
+	var patterns []string
+	switch rule.Type {
+	case operation_setting.GuardrailTypeKeyword:
+		quoted := make([]string, 0, len(rule.Keywords))
+		for _, keyword := range rule.Keywords {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				quoted = append(quoted, regexp.QuoteMeta(keyword))
+			}
+		}
+		if len(quoted) > 0 {
+			patterns = []string{"(?i)(?:" + strings.Join(quoted, "|") + ")"}
+		}
+	case operation_setting.GuardrailTypeRegex:
+		patterns = rule.Patterns
+	}
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if re := getGuardrailPattern(pattern); re != nil {
+			res = append(res, re)
+		}
+	}
+	return res
+}
+
+func guardrailMatch(rule *operation_setting.GuardrailRule, text string) []string {
+	var matches []string
+	for _, re := range guardrailRulePatterns(rule) {
+		matches = append(matches, re.FindAllString(text, 5)...)
+	}
+	return matches
+}
+
+// guardrailMatchFrom 只返回结束位置在 start 之后的匹配，流式检查时跳过已在上一分片末尾计入的匹配
+func guardrailMatchFrom(rule *operation_setting.GuardrailRule, text string, start int) []string {
+	var matches []string
+	for _, re := range guardrailRulePatterns(rule) {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if loc[1] <= start {
+				continue
+			}
+			matches = append(matches, text[loc[0]:loc[1]])
+			if len(matches) >= 5 {
+				return matches
+			}
+		}
+	}
+	return matches
+}
+
+func guardrailRedact(rule *operation_setting.GuardrailRule, text string) string {
+	replacement := operation_setting.GetGuardrailSetting().RedactReplacement
+	for _, re := range guardrailRulePatterns(rule) {
+		text = re.ReplaceAllLiteralString(text, replacement)
+	}
+	return text
+}
+
+func guardrailRuleName(rule *operation_setting.GuardrailRule) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return rule.Type
+}
+
+// getGuardrailRules 返回对当前分组、令牌和阶段生效的规则
+func getGuardrailRules(group string, tokenId int, stage string) []*operation_setting.GuardrailRule {
+	setting := operation_setting.GetGuardrailSetting()
+	if !setting.Enabled {
+		return nil
+	}
+	var rules []*operation_setting.GuardrailRule
+	for i := range setting.Rules {
+		rule := &setting.Rules[i]
+		if rule.AppliesTo(stage) && rule.Matches(group, tokenId) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// recordGuardrailHit 记录命中的规则，写入消费日志
+func recordGuardrailHit(c *gin.Context, stage string, rule *operation_setting.GuardrailRule, detail []string) {
+	hit := fmt.Sprintf("%s:%s:%s", stage, rule.Action, guardrailRuleName(rule))
+	hits := common.GetContextKeyStringSlice(c, constant.ContextKeyGuardrailHits)
+	if slices.Contains(hits, hit) {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyGuardrailHits, append(hits, hit))
+	logger.LogWarn(c, fmt.Sprintf("guardrail %s hit: %s", hit, strings.Join(detail, ", ")))
+}
+
+func guardrailBlockedError(rule *operation_setting.GuardrailRule) *types.NewAPIError {
+	message := rule.Message
+	if message == "" {
+		message = fmt.Sprintf("request blocked by guardrail: %s", guardrailRuleName(rule))
+	}
+	return types.NewErrorWithStatusCode(errors.New(message), types.ErrorCodeGuardrailBlocked, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+type guardrailModerationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// GuardrailModerator 调用 moderations 接口并返回响应体，由 controller 经审核渠道的适配器实现，不向用户计费
+type GuardrailModerator func(c *gin.Context, text string) ([]byte, error)
+
+var (
+	guardrailModerator     GuardrailModerator
+	guardrailModeratorLock sync.RWMutex
+)
+
+// SetGuardrailModerator 设置审核接口的调用方式
+func SetGuardrailModerator(moderator GuardrailModerator) {
+	guardrailModeratorLock.Lock()
+	defer guardrailModeratorLock.Unlock()
+	guardrailModerator = moderator
+}
+
+// guardrailModerate 调用审核接口，返回命中的类别
+func guardrailModerate(c *gin.Context, text string) ([]string, error) {
+	guardrailModeratorLock.RLock()
+	moderator := guardrailModerator
+	guardrailModeratorLock.RUnlock()
+	if moderator == nil {
+		return nil, errors.New("moderator is not configured")
+	}
+	respBody, err := moderator(c, text)
+	if err != nil {
+		return nil, err
+	}
+	var moderationResponse guardrailModerationResponse
+	if err := common.Unmarshal(respBody, &moderationResponse); err != nil {
+		return nil, err
+	}
+	var categories []string
+	for _, result := range moderationResponse.Results {
+		if !result.Flagged {
+			continue
+		}
+		for category, flagged := range result.Categories {
+			if flagged && !slices.Contains(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+		if len(categories) == 0 {
+			categories = append(categories, "flagged")
+		}
+	}
+	return categories, nil
+}
+
+// guardrailModerationHit 判断审核结果是否命中规则关注的类别
+func guardrailModerationHit(rule *operation_setting.GuardrailRule, categories []string) []string {
+	if len(rule.Categories) == 0 {
+		return categories
+	}
+	var hits []string
+	for _, category := range categories {
+		if slices.Contains(rule.Categories, category) {
+			hits = append(hits, category)
+		}
+	}
+	return hits
+}
+
+// guardrailModeration 同一段文本只调用一次审核接口，审核失败时放行
+type guardrailModeration struct {
+	checked    bool
+	categories []string
+}
+
+func (m *guardrailModeration) get(c *gin.Context, text string) []string {
+	if !m.checked {
+		m.checked = true
+		categories, err := guardrailModerate(c, text)
+		if err != nil {
+			logger.LogError(c, "guardrail moderation failed: "+err.Error())
+		}
+		m.categories = categories
+	}
+	return m.categories
+}
+
+// CheckPromptGuardrail 按规则检查提示词，返回拦截错误；命中脱敏规则时改写缓存的请求体并返回 true，调用方需重新解析请求
+func CheckPromptGuardrail(c *gin.Context, request dto.Request) (bool, *types.NewAPIError) {
+	rules := getGuardrailRules(common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+		common.GetContextKeyInt(c, constant.ContextKeyTokenId), operation_setting.GuardrailStagePrompt)
+	if len(rules) == 0 || request == nil {
+		return false, nil
+	}
+	meta := request.GetTokenCountMeta()
+	if meta == nil {
+		return false, nil
+	}
+	text := meta.CombineText
+	moderation := &guardrailModeration{}
+	var redactRules []*operation_setting.GuardrailRule
+	for _, rule := range rules {
+		var detail []string
+		switch rule.Type {
+		case operation_setting.GuardrailTypeMaxPromptSize:
+			if size := utf8.RuneCountInString(text); size > rule.MaxPromptSize {
+				detail = []string{fmt.Sprintf("prompt size %d exceeds %d", size, rule.MaxPromptSize)}
+			}
+		case operation_setting.GuardrailTypeModeration:
+			if text != "" {
+				detail = guardrailModerationHit(rule, moderation.get(c, text))
+			}
+		default:
+			detail = guardrailMatch(rule, text)
+		}
+		if len(detail) == 0 {
+			continue
+		}
+		recordGuardrailHit(c, operation_setting.GuardrailStagePrompt, rule, detail)
+		switch rule.Action {
+		case operation_setting.GuardrailActionBlock:
+			return false, guardrailBlockedError(rule)
+		case operation_setting.GuardrailActionRedact:
+			redactRules = append(redactRules, rule)
+			text = guardrailRedact(rule, text)
+		}
+	}
+	if len(redactRules) == 0 {
+		return false, nil
+	}
+	if err := redactRequestBody(c, redactRules); err != nil {
+		// 无法改写请求体时宁可拦截，也不把未脱敏的内容发给上游
+		logger.LogError(c, "guardrail redact request body failed: "+err.Error())
+		return false, guardrailBlockedError(redactRules[0])
+	}
+	return true, nil
+}
+
+// redactRequestBody 改写缓存的 JSON 请求体中的提示词字段
+func redactRequestBody(c *gin.Context, rules []*operation_setting.GuardrailRule) error {
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return errors.New("only json request body can be redacted")
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	payload, err := decodeJSONPayload(body)
+	if err != nil {
+		return err
+	}
+	payload = walkTextStrings(payload, promptTextKeys, false, func(text string) string {
+		for _, rule := range rules {
+			text = guardrailRedact(rule, text)
+		}
+		return text
+	})
+	redacted, err := common.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	c.Set(common.KeyRequestBody, redacted)
+	c.Request.Body = io.NopCloser(bytes.NewReader(redacted))
+	return nil
+}
+
+// decodeJSONPayload 解析任意 JSON，数字保留原文以免大整数丢失精度
+func decodeJSONPayload(body []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var payload any
+	err := decoder.Decode(&payload)
+	return payload, err
+}
+
+// walkTextStrings 对 JSON 中 keys 字段下的字符串逐个调用 fn 改写
+func walkTextStrings(value any, keys map[string]bool, isText bool, fn func(string) string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = walkTextStrings(item, keys, keys[key], fn)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = walkTextStrings(item, keys, isText, fn)
+		}
+	case string:
+		if isText {
+			return fn(v)
+		}
+	}
+	return value
+}
+
+// outputGuardrailRules 返回对输出生效的规则，长度规则只作用于提示词
+func outputGuardrailRules(info *relaycommon.RelayInfo) []*operation_setting.GuardrailRule {
+	var rules []*operation_setting.GuardrailRule
+	for _, rule := range getGuardrailRules(info.UsingGroup, info.TokenId, operation_setting.GuardrailStageOutput) {
+		if rule.Type != operation_setting.GuardrailTypeMaxPromptSize {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// CheckResponseGuardrail 非流式响应写出前调用，返回还原 PII 占位符并按输出规则脱敏后的响应体。
+// 命中拦截规则时返回 ErrorCodeGuardrailBlocked 错误，回退链中还有下一个模型时据此回退
+func CheckResponseGuardrail(c *gin.Context, info *relaycommon.RelayInfo, data []byte) ([]byte, *types.NewAPIError) {
+	data = RestoreResponsePII(c, data)
+	rules := outputGuardrailRules(info)
+	if len(rules) == 0 {
+		return data, nil
+	}
+	payload, err := decodeJSONPayload(data)
+	if err != nil {
+		return data, nil
+	}
+	var texts []string
+	walkTextStrings(payload, outputTextKeys, false, func(text string) string {
+		texts = append(texts, text)
+		return text
+	})
+	text := strings.Join(texts, "\n")
+	if text == "" {
+		return data, nil
+	}
+	moderation := &guardrailModeration{}
+	var redactRules []*operation_setting.GuardrailRule
+	for _, rule := range rules {
+		var detail []string
+		switch rule.Type {
+		case operation_setting.GuardrailTypeModeration:
+			detail = guardrailModerationHit(rule, moderation.get(c, text))
+		default:
+			detail = guardrailMatch(rule, text)
+		}
+		if len(detail) == 0 {
+			continue
+		}
+		recordGuardrailHit(c, operation_setting.GuardrailStageOutput, rule, detail)
+		switch rule.Action {
+		case operation_setting.GuardrailActionBlock:
+			return nil, guardrailBlockedError(rule)
+		case operation_setting.GuardrailActionRedact:
+			redactRules = append(redactRules, rule)
+		}
+	}
+	if len(redactRules) == 0 {
+		return data, nil
+	}
+	payload = walkTextStrings(payload, outputTextKeys, false, func(text string) string {
+		for _, rule := range redactRules {
+			text = guardrailRedact(rule, text)
+		}
+		return text
+	})
+	redacted, err := common.Marshal(payload)
+	if err != nil {
+		logger.LogError(c, "guardrail redact response body failed: "+err.Error())
+		return nil, guardrailBlockedError(redactRules[0])
+	}
+	return redacted, nil
+}
+
+type guardrailModerationResult struct {
+	categories []string
+	err        error
+}
+
+// StreamGuardrail 流式输出的增量检查，每个分片在发送给用户前调用 Check。
+// 审核接口每新增 ModerationInterval 个字符在后台调用一次，结果在之后的分片或 Finish 时生效，不阻塞输出
+type StreamGuardrail struct {
+	c             *gin.Context
+	rules         []*operation_setting.GuardrailRule
+	moderated     bool // 是否有审核规则
+	tail          string
+	output        strings.Builder
+	outputSize    int
+	moderatedSize int
+	moderation    chan guardrailModerationResult // 进行中的审核，没有时为 nil
+	blocked       bool
+}
+
+// NewStreamGuardrail 没有对输出生效的规则时返回 nil
+func NewStreamGuardrail(c *gin.Context, info *relaycommon.RelayInfo) *StreamGuardrail {
+	rules := outputGuardrailRules(info)
+	if len(rules) == 0 {
+		return nil
+	}
+	g := &StreamGuardrail{c: c, rules: rules}
+	for _, rule := range rules {
+		if rule.Type == operation_setting.GuardrailTypeModeration {
+			g.moderated = true
+		}
+	}
+	return g
+}
+
+// Blocked 是否已因命中拦截规则而中断输出
+func (g *StreamGuardrail) Blocked() bool {
+	return g != nil && g.blocked
+}
+
+// Check 检查新增的输出文本，返回脱敏后的文本以及是否需要中断输出。
+// 关键词和正则会结合上一分片的末尾匹配，只计入结束于新增文本内的匹配，脱敏也只能作用于尚未发送的新增文本
+func (g *StreamGuardrail) Check(delta string) (string, bool) {
+	if g == nil {
+		return delta, false
+	}
+	if g.blocked || g.collectModeration(false) {
+		return "", true
+	}
+	if delta == "" {
+		return delta, false
+	}
+	for _, rule := range g.rules {
+		if rule.Type == operation_setting.GuardrailTypeModeration {
+			continue
+		}
+		detail := guardrailMatchFrom(rule, g.tail+delta, len(g.tail))
+		if len(detail) == 0 {
+			continue
+		}
+		recordGuardrailHit(g.c, operation_setting.GuardrailStageOutput, rule, detail)
+		switch rule.Action {
+		case operation_setting.GuardrailActionBlock:
+			g.blocked = true
+			return "", true
+		case operation_setting.GuardrailActionRedact:
+			delta = guardrailRedact(rule, delta)
+		}
+	}
+	g.output.WriteString(delta)
+	g.outputSize += utf8.RuneCountInString(delta)
+	g.tail = lastRunes(g.tail+delta, guardrailStreamOverlap)
+	g.startModeration(false)
+	return delta, false
+}
+
+// RedactRaw 按脱敏规则改写携带完整文本的事件（如 Responses 的 done 事件），与增量文本的脱敏结果保持一致
+func (g *StreamGuardrail) RedactRaw(data string) string {
+	if g == nil {
+		return data
+	}
+	var redactRules []*operation_setting.GuardrailRule
+	for _, rule := range g.rules {
+		if rule.Action == operation_setting.GuardrailActionRedact {
+			redactRules = append(redactRules, rule)
+		}
+	}
+	if len(redactRules) == 0 {
+		return data
+	}
+	payload, err := decodeJSONPayload([]byte(data))
+	if err != nil {
+		return data
+	}
+	payload = walkTextStrings(payload, outputTextKeys, false, func(text string) string {
+		for _, rule := range redactRules {
+			text = guardrailRedact(rule, text)
+		}
+		return text
+	})
+	redacted, err := common.Marshal(payload)
+	if err != nil {
+		return data
+	}
+	return string(redacted)
+}
+
+// Finish 输出结束时等待进行中的审核，并审核剩余的输出，返回是否需要中断输出
+func (g *StreamGuardrail) Finish() bool {
+	if g == nil || g.blocked {
+		return g.Blocked()
+	}
+	if g.collectModeration(true) {
+		return true
+	}
+	g.startModeration(true)
+	return g.collectModeration(true)
+}
+
+// startModeration 未审核的输出达到间隔（force 时只要有未审核的输出）且没有进行中的审核时，在后台审核已输出的全部文本
+func (g *StreamGuardrail) startModeration(force bool) {
+	if !g.moderated || g.moderation != nil {
+		return
+	}
+	pending := g.outputSize - g.moderatedSize
+	interval := operation_setting.GetGuardrailSetting().ModerationInterval
+	if pending <= 0 || (!force && interval > 0 && pending < interval) {
+		return
+	}
+	g.moderatedSize = g.outputSize
+	text := g.output.String()
+	c := g.c
+	result := make(chan guardrailModerationResult, 1)
+	g.moderation = result
+	gopool.Go(func() {
+		categories, err := guardrailModerate(c, text)
+		result <- guardrailModerationResult{categories: categories, err: err}
+	})
+}
+
+// collectModeration 取回后台审核的结果，wait 为 false 时审核未完成则直接返回；审核失败时放行
+func (g *StreamGuardrail) collectModeration(wait bool) bool {
+	if g.moderation == nil {
+		return false
+	}
+	var result guardrailModerationResult
+	if wait {
+		result = <-g.moderation
+	} else {
+		select {
+		case result = <-g.moderation:
+		default:
+			return false
+		}
+	}
+	g.moderation = nil
+	if result.err != nil {
+		logger.LogError(g.c, "guardrail moderation failed: "+result.err.Error())
+		return false
+	}
+	for _, rule := range g.rules {
+		if rule.Type != operation_setting.GuardrailTypeModeration {
+			continue
+		}
+		detail := guardrailModerationHit(rule, result.categories)
+		if len(detail) == 0 {
+			continue
+		}
+		recordGuardrailHit(g.c, operation_setting.GuardrailStageOutput, rule, detail)
+		if rule.Action == operation_setting.GuardrailActionBlock {
+			g.blocked = true
+			return true
+		}
+	}
+	return false
+}
+
+func lastRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[len(runes)-n:])
+}
@@ -0,0 +1,428 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func setGuardrailRulesForTest(t *testing.T, rules ...operation_setting.GuardrailRule) {
+	t.Helper()
+	setting := operation_setting.GetGuardrailSetting()
+	original := *setting
+	t.Cleanup(func() {
+		*setting = original
+	})
+	setting.Enabled = true
+	setting.Rules = rules
+	setting.RedactReplacement = "***"
+}
+
+func newGuardrailTestContext(body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyTokenId, 1)
+	return c
+}
+
+func newGuardrailTestRelayInfo() *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		UsingGroup:  "default",
+		TokenId:     1,
+		ChannelMeta: &relaycommon.ChannelMeta{},
+	}
+}
+
+var (
+	blockSecretRule = operation_setting.GuardrailRule{
+		Name: "secret", Type: operation_setting.GuardrailTypeKeyword, Action: operation_setting.GuardrailActionBlock,
+		Keywords: []string{"TopSecret"}, Message: "blocked secret",
+	}
+	redactPasswordRule = operation_setting.GuardrailRule{
+		Name: "password", Type: operation_setting.GuardrailTypeRegex, Action: operation_setting.GuardrailActionRedact,
+		Patterns: []string{`pw-\d+`},
+	}
+)
+
+func TestGetGuardrailRules(t *testing.T) {
+	promptOnly := operation_setting.GuardrailRule{Name: "prompt", Stage: operation_setting.GuardrailStagePrompt}
+	outputOnly := operation_setting.GuardrailRule{Name: "output", Stage: operation_setting.GuardrailStageOutput}
+	vipOnly := operation_setting.GuardrailRule{Name: "vip", Groups: []string{"vip"}}
+	tokenOnly := operation_setting.GuardrailRule{Name: "token", TokenIds: []int{7}}
+	setGuardrailRulesForTest(t, promptOnly, outputOnly, vipOnly, tokenOnly)
+
+	tests := []struct {
+		name    string
+		group   string
+		tokenId int
+		stage   string
+		want    []string
+	}{
+		{name: "prompt stage", group: "default", tokenId: 1, stage: operation_setting.GuardrailStagePrompt, want: []string{"prompt"}},
+		{name: "output stage", group: "default", tokenId: 1, stage: operation_setting.GuardrailStageOutput, want: []string{"output"}},
+		{name: "group match", group: "vip", tokenId: 1, stage: operation_setting.GuardrailStageOutput, want: []string{"output", "vip"}},
+		{name: "token match", group: "default", tokenId: 7, stage: operation_setting.GuardrailStagePrompt, want: []string{"prompt", "token"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, rule := range getGuardrailRules(tt.group, tt.tokenId, tt.stage) {
+				names = append(names, rule.Name)
+			}
+			require.Equal(t, tt.want, names)
+		})
+	}
+
+	operation_setting.GetGuardrailSetting().Enabled = false
+	require.Empty(t, getGuardrailRules("vip", 7, operation_setting.GuardrailStagePrompt))
+}
+
+func TestGuardrailMatchAndRedact(t *testing.T) {
+	setGuardrailRulesForTest(t)
+	tests := []struct {
+		name        string
+		rule        operation_setting.GuardrailRule
+		text        string
+		wantMatches []string
+		wantRedact  string
+	}{
+		{
+			name:        "keyword is case insensitive",
+			rule:        operation_setting.GuardrailRule{Type: operation_setting.GuardrailTypeKeyword, Keywords: []string{"foo", " "}},
+			text:        "a FOO b foo",
+			wantMatches: []string{"FOO", "foo"},
+			wantRedact:  "a *** b ***",
+		},
+		{
+			name:        "keyword is quoted",
+			rule:        operation_setting.GuardrailRule{Type: operation_setting.GuardrailTypeKeyword, Keywords: []string{"a.b"}},
+			text:        "axb a.b",
+			wantMatches: []string{"a.b"},
+			wantRedact:  "axb ***",
+		},
+		{
+			name:        "regex",
+			rule:        redactPasswordRule,
+			text:        "use pw-123 and pw-x",
+			wantMatches: []string{"pw-123"},
+			wantRedact:  "use *** and pw-x",
+		},
+		{
+			name:       "invalid regex is ignored",
+			rule:       operation_setting.GuardrailRule{Type: operation_setting.GuardrailTypeRegex, Patterns: []string{"("}},
+			text:       "(",
+			wantRedact: "(",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.wantMatches, guardrailMatch(&tt.rule, tt.text))
+			require.Equal(t, tt.wantRedact, guardrailRedact(&tt.rule, tt.text))
+		})
+	}
+}
+
+func TestGuardrailMatchFrom(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		start int
+		want  []string
+	}{
+		{name: "match in delta", text: "tail pw-1", start: 5, want: []string{"pw-1"}},
+		{name: "match only in tail is skipped", text: "pw-1 next", start: 5},
+		{name: "match across tail and delta", text: "use pw-1", start: 6, want: []string{"pw-1"}},
+		{name: "match growing into delta", text: "pw-12", start: 4, want: []string{"pw-12"}},
+		{name: "tail and delta matches", text: "pw-1 pw-2", start: 5, want: []string{"pw-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, guardrailMatchFrom(&redactPasswordRule, tt.text, tt.start))
+		})
+	}
+}
+
+func TestGuardrailModerationHit(t *testing.T) {
+	tests := []struct {
+		name       string
+		categories []string
+		flagged    []string
+		want       []string
+	}{
+		{name: "any category", flagged: []string{"violence"}, want: []string{"violence"}},
+		{name: "listed category", categories: []string{"hate", "violence"}, flagged: []string{"sexual", "violence"}, want: []string{"violence"}},
+		{name: "unlisted category", categories: []string{"hate"}, flagged: []string{"violence"}},
+		{name: "not flagged", categories: []string{"hate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &operation_setting.GuardrailRule{Type: operation_setting.GuardrailTypeModeration, Categories: tt.categories}
+			require.Equal(t, tt.want, guardrailModerationHit(rule, tt.flagged))
+		})
+	}
+}
+
+func TestCheckPromptGuardrail(t *testing.T) {
+	flagRule := operation_setting.GuardrailRule{
+		Name: "flag", Type: operation_setting.GuardrailTypeKeyword, Action: operation_setting.GuardrailActionFlag, Keywords: []string{"hello"},
+	}
+	sizeRule := operation_setting.GuardrailRule{
+		Name: "size", Type: operation_setting.GuardrailTypeMaxPromptSize, Action: operation_setting.GuardrailActionBlock, MaxPromptSize: 20,
+	}
+	outputRule := blockSecretRule
+	outputRule.Stage = operation_setting.GuardrailStageOutput
+
+	tests := []struct {
+		name        string
+		rules       []operation_setting.GuardrailRule
+		prompt      string
+		wantBlocked bool
+		wantMessage string
+		wantRewrite bool
+		wantBody    string
+		wantHits    []string
+	}{
+		{name: "no rules", prompt: "TopSecret"},
+		{
+			name:        "keyword block",
+			rules:       []operation_setting.GuardrailRule{blockSecretRule},
+			prompt:      "tell me the topsecret",
+			wantBlocked: true,
+			wantMessage: "blocked secret",
+			wantHits:    []string{"prompt:block:secret"},
+		},
+		{
+			name:     "output rule does not check prompt",
+			rules:    []operation_setting.GuardrailRule{outputRule},
+			prompt:   "TopSecret",
+			wantHits: nil,
+		},
+		{
+			name:     "flag only records hit",
+			rules:    []operation_setting.GuardrailRule{flagRule},
+			prompt:   "hello",
+			wantHits: []string{"prompt:flag:flag"},
+		},
+		{
+			name:        "prompt too long",
+			rules:       []operation_setting.GuardrailRule{sizeRule},
+			prompt:      "这是一个超过二十个字符长度限制的提示词内容，应当被拦截",
+			wantBlocked: true,
+			wantHits:    []string{"prompt:block:size"},
+		},
+		{
+			name:        "redact rewrites body",
+			rules:       []operation_setting.GuardrailRule{redactPasswordRule, blockSecretRule},
+			prompt:      "my password is pw-42",
+			wantRewrite: true,
+			wantBody:    "my password is ***",
+			wantHits:    []string{"prompt:redact:password"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGuardrailRulesForTest(t, tt.rules...)
+			body, err := common.Marshal(map[string]any{
+				"model":    "gpt-4o",
+				"messages": []map[string]any{{"role": "user", "content": tt.prompt}},
+			})
+			require.NoError(t, err)
+			c := newGuardrailTestContext(string(body))
+			request := &dto.GeneralOpenAIRequest{
+				Model:    "gpt-4o",
+				Messages: []dto.Message{{Role: "user", Content: tt.prompt}},
+			}
+
+			rewrite, apiErr := CheckPromptGuardrail(c, request)
+			require.Equal(t, tt.wantBlocked, apiErr != nil, "err: %v", apiErr)
+			if apiErr != nil {
+				require.Equal(t, types.ErrorCodeGuardrailBlocked, apiErr.GetErrorCode())
+				if tt.wantMessage != "" {
+					require.Equal(t, tt.wantMessage, apiErr.Error())
+				}
+			}
+			require.Equal(t, tt.wantRewrite, rewrite)
+			if tt.wantRewrite {
+				rewritten, err := common.GetRequestBody(c)
+				require.NoError(t, err)
+				var payload dto.GeneralOpenAIRequest
+				require.NoError(t, common.Unmarshal(rewritten, &payload))
+				require.Equal(t, tt.wantBody, payload.Messages[0].StringContent())
+			}
+			require.Equal(t, tt.wantHits, common.GetContextKeyStringSlice(c, constant.ContextKeyGuardrailHits))
+		})
+	}
+}
+
+func TestCheckResponseGuardrail(t *testing.T) {
+	tests := []struct {
+		name        string
+		rules       []operation_setting.GuardrailRule
+		body        string
+		wantBlocked bool
+		wantBody    string
+	}{
+		{
+			name:     "no output rules",
+			body:     `{"choices":[{"message":{"content":"TopSecret"}}]}`,
+			wantBody: `{"choices":[{"message":{"content":"TopSecret"}}]}`,
+		},
+		{
+			name:        "openai content blocked",
+			rules:       []operation_setting.GuardrailRule{blockSecretRule},
+			body:        `{"choices":[{"message":{"content":"the TopSecret plan"}}]}`,
+			wantBlocked: true,
+		},
+		{
+			name:        "claude text blocked",
+			rules:       []operation_setting.GuardrailRule{blockSecretRule},
+			body:        `{"content":[{"type":"text","text":"topsecret"}]}`,
+			wantBlocked: true,
+		},
+		{
+			name:     "gemini text redacted and numbers preserved",
+			rules:    []operation_setting.GuardrailRule{redactPasswordRule},
+			body:     `{"candidates":[{"content":{"parts":[{"text":"pw-1 ok"}]}}],"usageMetadata":{"totalTokenCount":12345678901234567}}`,
+			wantBody: `{"candidates":[{"content":{"parts":[{"text":"*** ok"}]}}],"usageMetadata":{"totalTokenCount":12345678901234567}}`,
+		},
+		{
+			name:     "non text fields are not checked",
+			rules:    []operation_setting.GuardrailRule{blockSecretRule},
+			body:     `{"id":"TopSecret","choices":[{"message":{"content":"fine"}}]}`,
+			wantBody: `{"id":"TopSecret","choices":[{"message":{"content":"fine"}}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGuardrailRulesForTest(t, tt.rules...)
+			c := newGuardrailTestContext("")
+			data, apiErr := CheckResponseGuardrail(c, newGuardrailTestRelayInfo(), []byte(tt.body))
+			if tt.wantBlocked {
+				require.NotNil(t, apiErr)
+				require.Equal(t, types.ErrorCodeGuardrailBlocked, apiErr.GetErrorCode())
+				require.True(t, types.IsSkipRetryError(apiErr))
+				return
+			}
+			require.Nil(t, apiErr)
+			require.JSONEq(t, tt.wantBody, string(data))
+		})
+	}
+}
+
+func TestStreamGuardrail(t *testing.T) {
+	tests := []struct {
+		name        string
+		rules       []operation_setting.GuardrailRule
+		deltas      []string
+		wantOutput  []string
+		wantBlocked bool
+	}{
+		{
+			name:       "clean output passes",
+			rules:      []operation_setting.GuardrailRule{blockSecretRule},
+			deltas:     []string{"hello ", "world"},
+			wantOutput: []string{"hello ", "world"},
+		},
+		{
+			name:        "keyword split across chunks",
+			rules:       []operation_setting.GuardrailRule{blockSecretRule},
+			deltas:      []string{"the Top", "Secret", " plan"},
+			wantOutput:  []string{"the Top"},
+			wantBlocked: true,
+		},
+		{
+			name:       "redact within a chunk",
+			rules:      []operation_setting.GuardrailRule{redactPasswordRule},
+			deltas:     []string{"use pw-1", "23 now"},
+			wantOutput: []string{"use ***", "23 now"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGuardrailRulesForTest(t, tt.rules...)
+			g := NewStreamGuardrail(newGuardrailTestContext(""), newGuardrailTestRelayInfo())
+			require.NotNil(t, g)
+			var output []string
+			for _, delta := range tt.deltas {
+				text, blocked := g.Check(delta)
+				if blocked {
+					break
+				}
+				output = append(output, text)
+			}
+			require.Equal(t, tt.wantOutput, output)
+			require.Equal(t, tt.wantBlocked, g.Blocked())
+			require.Equal(t, tt.wantBlocked, g.Finish())
+		})
+	}
+
+	t.Run("no output rules", func(t *testing.T) {
+		setGuardrailRulesForTest(t)
+		var g *StreamGuardrail = NewStreamGuardrail(newGuardrailTestContext(""), newGuardrailTestRelayInfo())
+		require.Nil(t, g)
+		text, blocked := g.Check("anything")
+		require.Equal(t, "anything", text)
+		require.False(t, blocked)
+		require.False(t, g.Finish())
+	})
+
+	t.Run("redact raw done event", func(t *testing.T) {
+		setGuardrailRulesForTest(t, redactPasswordRule)
+		g := NewStreamGuardrail(newGuardrailTestContext(""), newGuardrailTestRelayInfo())
+		raw := `{"type":"response.output_text.done","text":"use pw-123","sequence_number":5}`
+		require.JSONEq(t, `{"type":"response.output_text.done","text":"use ***","sequence_number":5}`, g.RedactRaw(raw))
+	})
+
+	t.Run("moderation through the moderator", func(t *testing.T) {
+		moderationRule := operation_setting.GuardrailRule{
+			Name: "moderation", Type: operation_setting.GuardrailTypeModeration, Action: operation_setting.GuardrailActionBlock,
+		}
+		tests := []struct {
+			name        string
+			response    string
+			err         error
+			wantBlocked bool
+		}{
+			{name: "flagged output is blocked", response: `{"results":[{"flagged":true,"categories":{"violence":true}}]}`, wantBlocked: true},
+			{name: "clean output passes", response: `{"results":[{"flagged":false,"categories":{"violence":false}}]}`},
+			{name: "moderation failure passes", err: errors.New("upstream error")},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				setGuardrailRulesForTest(t, moderationRule)
+				var moderated []string
+				SetGuardrailModerator(func(c *gin.Context, text string) ([]byte, error) {
+					moderated = append(moderated, text)
+					return []byte(tt.response), tt.err
+				})
+				t.Cleanup(func() { SetGuardrailModerator(nil) })
+
+				c := newGuardrailTestContext("")
+				g := NewStreamGuardrail(c, newGuardrailTestRelayInfo())
+				for _, delta := range []string{"hello ", "world"} {
+					_, blocked := g.Check(delta)
+					require.False(t, blocked)
+				}
+				require.Equal(t, tt.wantBlocked, g.Finish())
+				require.Equal(t, []string{"hello world"}, moderated)
+				if tt.wantBlocked {
+					require.Equal(t, []string{"output:block:moderation"}, common.GetContextKeyStringSlice(c, constant.ContextKeyGuardrailHits))
+				}
+			})
+		}
+	})
+}
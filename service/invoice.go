@@ -0,0 +1,217 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+var invoiceHTMLTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"money": func(currency string, amount float64) string {
+		return fmt.Sprintf("%s %.2f", currency, amount)
+	},
+	"date": func(ts int64) string {
+		return time.Unix(ts, 0).Format("2006-01-02")
+	},
+	"lines": func(s string) []string {
+		return strings.Split(s, "\n")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Invoice.Number}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; max-width: 760px; margin: 32px auto; padding: 0 16px; }
+h1 { font-size: 24px; margin-bottom: 4px; }
+table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+th, td { text-align: left; padding: 8px; border-bottom: 1px solid #ddd; }
+td.num, th.num { text-align: right; }
+.parties { display: flex; justify-content: space-between; margin-top: 24px; }
+.muted { color: #666; font-size: 13px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="muted">No. {{.Invoice.Number}} &middot; {{date .Invoice.IssuedAt}}</div>
+{{if .Related}}<div class="muted">Refund of {{.Related}}</div>{{end}}
+<div class="parties">
+<div>
+<strong>{{.Invoice.SellerName}}</strong><br>
+{{range lines .Invoice.SellerAddress}}{{.}}<br>{{end}}
+{{if .Invoice.SellerTaxId}}Tax ID: {{.Invoice.SellerTaxId}}<br>{{end}}
+{{if .Invoice.SellerEmail}}{{.Invoice.SellerEmail}}{{end}}
+</div>
+<div>
+<strong>Bill to</strong><br>
+{{.Invoice.BuyerName}}<br>
+{{.Invoice.BuyerEmail}}
+</div>
+</div>
+<table>
+<tr><th>Description</th><th class="num">Amount</th></tr>
+<tr><td>{{.Invoice.Description}}</td><td class="num">{{money .Invoice.Currency .Invoice.Subtotal}}</td></tr>
+<tr><td>Tax ({{.Invoice.TaxRate}}%)</td><td class="num">{{money .Invoice.Currency .Invoice.TaxAmount}}</td></tr>
+<tr><th>Total</th><th class="num">{{money .Invoice.Currency .Invoice.Total}}</th></tr>
+</table>
+{{if .Invoice.PaymentMethod}}<p class="muted">Payment method: {{.Invoice.PaymentMethod}}</p>{{end}}
+{{if .Invoice.Note}}<p>{{.Invoice.Note}}</p>{{end}}
+{{if .Invoice.Footer}}<p class="muted">{{.Invoice.Footer}}</p>{{end}}
+</body>
+</html>
+`))
+
+func invoiceTitle(invoice *model.Invoice) string {
+	if invoice.Type == model.InvoiceTypeCreditNote {
+		return "Credit Note"
+	}
+	return "Invoice"
+}
+
+func invoiceRelatedNumber(invoice *model.Invoice) string {
+	if invoice.Type == model.InvoiceTypeCreditNote {
+		return invoice.SourceReference
+	}
+	return ""
+}
+
+// RenderInvoiceHTML 渲染发票网页，也用作邮件正文
+func RenderInvoiceHTML(invoice *model.Invoice) (string, error) {
+	var buf bytes.Buffer
+	err := invoiceHTMLTemplate.Execute(&buf, map[string]any{
+		"Title":   invoiceTitle(invoice),
+		"Invoice": invoice,
+		"Related": invoiceRelatedNumber(invoice),
+	})
+	return buf.String(), err
+}
+
+// RenderInvoicePDF 渲染 PDF 发票，使用 PDF 内置字体，包含无法显示的字符时返回 ErrInvoicePDFUnsupportedText
+func RenderInvoicePDF(invoice *model.Invoice) ([]byte, error) {
+	pdf := newInvoicePDF()
+	pdf.text(50, 790, 20, invoiceTitle(invoice))
+	pdf.text(50, 768, 10, fmt.Sprintf("No. %s    Date: %s", invoice.Number, time.Unix(invoice.IssuedAt, 0).Format("2006-01-02")))
+	if related := invoiceRelatedNumber(invoice); related != "" {
+		pdf.text(50, 754, 10, "Refund of "+related)
+	}
+
+	y := 720.0
+	pdf.text(50, y, 11, invoice.SellerName)
+	pdf.text(330, y, 11, "Bill to")
+	sellerLines := strings.Split(invoice.SellerAddress, "\n")
+	if invoice.SellerTaxId != "" {
+		sellerLines = append(sellerLines, "Tax ID: "+invoice.SellerTaxId)
+	}
+	if invoice.SellerEmail != "" {
+		sellerLines = append(sellerLines, invoice.SellerEmail)
+	}
+	buyerLines := []string{invoice.BuyerName, invoice.BuyerEmail}
+	for i := 0; i < len(sellerLines) || i < len(buyerLines); i++ {
+		y -= 14
+		if i < len(sellerLines) {
+			pdf.text(50, y, 10, sellerLines[i])
+		}
+		if i < len(buyerLines) {
+			pdf.text(330, y, 10, buyerLines[i])
+		}
+	}
+
+	y -= 40
+	pdf.text(50, y, 11, "Description")
+	pdf.text(430, y, 11, "Amount")
+	pdf.line(50, y-6, 545, y-6)
+	rows := [][2]string{
+		{invoice.Description, fmt.Sprintf("%s %.2f", invoice.Currency, invoice.Subtotal)},
+		{fmt.Sprintf("Tax (%g%%)", invoice.TaxRate), fmt.Sprintf("%s %.2f", invoice.Currency, invoice.TaxAmount)},
+	}
+	for _, row := range rows {
+		y -= 22
+		pdf.text(50, y, 10, row[0])
+		pdf.text(430, y, 10, row[1])
+	}
+	pdf.line(50, y-8, 545, y-8)
+	y -= 24
+	pdf.text(50, y, 11, "Total")
+	pdf.text(430, y, 11, fmt.Sprintf("%s %.2f", invoice.Currency, invoice.Total))
+
+	y -= 40
+	if invoice.PaymentMethod != "" {
+		pdf.text(50, y, 9, "Payment method: "+invoice.PaymentMethod)
+		y -= 14
+	}
+	for _, text := range []string{invoice.Note, invoice.Footer} {
+		for _, line := range strings.Split(text, "\n") {
+			if line == "" {
+				continue
+			}
+			pdf.text(50, y, 9, line)
+			y -= 14
+		}
+	}
+	return pdf.bytes()
+}
+
+// DeliverInvoice 按来源查找发票，启用邮件发送时异步发送给用户
+func DeliverInvoice(sourceType string, reference string) {
+	if !operation_setting.GetInvoiceSetting().EmailEnabled {
+		return
+	}
+	invoice, err := model.GetInvoiceBySource(sourceType, reference)
+	if err != nil || invoice == nil {
+		return
+	}
+	SendInvoiceEmail(invoice)
+}
+
+// DeliverCreditNote 启用邮件发送时异步发送红字发票
+func DeliverCreditNote(creditNote *model.Invoice) {
+	if !operation_setting.GetInvoiceSetting().EmailEnabled {
+		return
+	}
+	SendInvoiceEmail(creditNote)
+}
+
+// SendInvoiceEmail 异步发送发票邮件，用户未绑定邮箱时跳过
+func SendInvoiceEmail(invoice *model.Invoice) {
+	if invoice.BuyerEmail == "" {
+		return
+	}
+	gopool.Go(func() {
+		content, err := RenderInvoiceHTML(invoice)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to render invoice %s: %s", invoice.Number, err.Error()))
+			return
+		}
+		subject := fmt.Sprintf("%s %s %s", common.SystemName, invoiceTitle(invoice), invoice.Number)
+		if err = common.SendEmail(subject, invoice.BuyerEmail, content); err != nil {
+			common.SysError(fmt.Sprintf("failed to send invoice %s: %s", invoice.Number, err.Error()))
+		}
+	})
+}
+
+// RunPendingInvoiceRetry 定期重试付款后开具失败的发票，开具成功后按配置发送邮件
+func RunPendingInvoiceRetry() {
+	for {
+		time.Sleep(5 * time.Minute)
+		pendings, err := model.GetRetryablePendingInvoices(100)
+		if err != nil {
+			common.SysError("failed to query pending invoices: " + err.Error())
+			continue
+		}
+		for _, pending := range pendings {
+			if err := model.IssuePendingInvoice(pending.SourceType, pending.SourceReference); err != nil {
+				common.SysError(fmt.Sprintf("failed to issue invoice for %s %s: %s", pending.SourceType, pending.SourceReference, err.Error()))
+				continue
+			}
+			DeliverInvoice(pending.SourceType, pending.SourceReference)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvoicePDFUnsupportedText 发票包含内置字体无法显示的字符（如中日韩文字），此时应改用 HTML 发票
+var ErrInvoicePDFUnsupportedText = errors.New("发票包含 PDF 内置字体无法显示的字符，请使用 format=html 查看网页版发票")
+
+// invoicePDF 生成单页 A4 PDF，仅支持内置 Helvetica 字体的文字与直线，足够排版发票
+type invoicePDF struct {
+	content     bytes.Buffer
+	unsupported bool // 出现 WinAnsi 无法编码的字符
+}
+
+func newInvoicePDF() *invoicePDF {
+	return &invoicePDF{}
+}
+
+// pdfString 转为 WinAnsi 编码的 PDF 字符串，遇到 Latin-1 以外的字符时返回 false
+func pdfString(s string) (string, bool) {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			// 忽略换行等控制字符
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+func (p *invoicePDF) text(x, y, size float64, s string) {
+	if s == "" {
+		return
+	}
+	encoded, ok := pdfString(s)
+	if !ok {
+		p.unsupported = true
+		return
+	}
+	fmt.Fprintf(&p.content, "BT /F1 %g Tf %g %g Td (%s) Tj ET\n", size, x, y, encoded)
+}
+
+func (p *invoicePDF) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %g %g m %g %g l S\n", x1, y1, x2, y2)
+}
+
+func (p *invoicePDF) bytes() ([]byte, error) {
+	if p.unsupported {
+		return nil, ErrInvoicePDFUnsupportedText
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()),
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes(), nil
+}
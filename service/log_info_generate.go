@@ -7,6 +7,7 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -55,6 +56,36 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_system_prompt_overwritten"] = true
 	}
 
+	if batchId := common.GetContextKeyString(ctx, constant.ContextKeyBatchId); batchId != "" {
+		other["batch_id"] = batchId
+	}
+
+	if tier := relayInfo.PriceData.Tier; tier != nil {
+		other["price_tier"] = tier.MinPromptTokens
+	}
+
+	if hits := common.GetContextKeyStringSlice(ctx, constant.ContextKeyGuardrailHits); len(hits) > 0 {
+		other["guardrail"] = hits
+	}
+
+	if counts := getPIIRedactor(ctx).Counts(); len(counts) > 0 {
+		other["pii_redactions"] = counts
+	}
+
+	if rule := GetRoutingRule(ctx); rule != nil {
+		other["routing_rule"] = rule.Name
+	}
+
+	if state := GetVirtualModelState(ctx); state != nil {
+		other["virtual_model"] = state.Name
+		other["fallback_path"] = state.Path
+	}
+
+	if relayInfo.ResponseCacheHit {
+		other["cache_hit"] = true
+		other["cache_hit_ratio"] = operation_setting.GetResponseCacheSetting().HitQuotaRatio
+	}
+
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	isMultiKey := common.GetContextKeyBool(ctx, constant.ContextKeyChannelIsMultiKey)
@@ -63,6 +94,10 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		adminInfo["multi_key_index"] = common.GetContextKeyInt(ctx, constant.ContextKeyChannelMultiKeyIndex)
 	}
 
+	if common.GetContextKeyBool(ctx, constant.ContextKeyPayloadCapture) {
+		adminInfo["payload_request_id"] = ctx.GetString(common.RequestIdKey)
+	}
+
 	isLocalCountTokens := common.GetContextKeyBool(ctx, constant.ContextKeyLocalCountTokens)
 	if isLocalCountTokens {
 		adminInfo["local_count_tokens"] = isLocalCountTokens
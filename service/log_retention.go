@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+// CleanOldSqlLogs 配合外部日志存储使用，SQL 日志表只保留最近 LOG_SQL_RETENTION_DAYS 天
+func CleanOldSqlLogs() {
+	if common.LogSqlRetentionDays <= 0 || !model.IsSqlLogEnabled() {
+		return
+	}
+	for {
+		time.Sleep(time.Hour)
+		cutoff := time.Now().AddDate(0, 0, -common.LogSqlRetentionDays).Unix()
+		count, err := model.DeleteOldLog(context.Background(), cutoff, 1000)
+		if err != nil {
+			common.SysError("failed to clean old sql logs: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d sql logs older than %d days", count, common.LogSqlRetentionDays))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// 测试不连接 Redis，异步通知或缓存更新可能在单个测试恢复全局设置后才执行
+	common.RedisEnabled = false
+	os.Exit(m.Run())
+}
+
+// setupTestDB 使用内存 SQLite 替换 model 的全局 DB 并关闭 Redis，测试结束后恢复
+func setupTestDB(t *testing.T, models ...any) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(models...))
+
+	origDB, origLogDB := model.DB, model.LOG_DB
+	origSQLite, origRedis := common.UsingSQLite, common.RedisEnabled
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB = origDB, origLogDB
+		common.UsingSQLite, common.RedisEnabled = origSQLite, origRedis
+	})
+	model.DB, model.LOG_DB = db, db
+	common.UsingSQLite = true
+	common.RedisEnabled = false
+	return db
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ApplyModelCapabilities 按模型能力检查并调整请求，需在预扣费之前调用：
+// 提示词超出上下文窗口直接拒绝，max_tokens 按最大输出与剩余上下文截断，
+// 向不支持图片或音频输入的模型发送媒体时返回明确的错误，其余不支持的参数从请求中移除
+func ApplyModelCapabilities(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request, meta *types.TokenCountMeta) *types.NewAPIError {
+	if !operation_setting.GetModelCapabilitySetting().Enabled {
+		return nil
+	}
+	capabilities := model.GetModelCapabilities(info.OriginModelName)
+	if capabilities == (dto.ModelCapabilities{}) {
+		return nil
+	}
+
+	promptTokens := info.GetEstimatePromptTokens()
+	// 提示词占满上下文窗口时已没有输出空间，同样拒绝
+	if capabilities.ContextWindow > 0 && promptTokens >= capabilities.ContextWindow {
+		return types.NewErrorWithStatusCode(
+			fmt.Errorf("prompt is too long for model %s: %d tokens leaves no room for output in the context window of %d tokens", info.OriginModelName, promptTokens, capabilities.ContextWindow),
+			types.ErrorCodeContextLengthExceeded, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	if unsupported(capabilities.SupportsVision) || unsupported(capabilities.SupportsAudio) {
+		fullMeta := meta
+		if fullMeta == nil || (fullMeta.CombineText == "" && len(fullMeta.Files) == 0) {
+			fullMeta = request.GetTokenCountMeta()
+		}
+		if fullMeta != nil {
+			for _, file := range fullMeta.Files {
+				if file.FileType == types.FileTypeImage && unsupported(capabilities.SupportsVision) {
+					return types.NewErrorWithStatusCode(fmt.Errorf("model %s does not support image input", info.OriginModelName),
+						types.ErrorCodeUnsupportedCapability, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+				}
+				if file.FileType == types.FileTypeAudio && unsupported(capabilities.SupportsAudio) {
+					return types.NewErrorWithStatusCode(fmt.Errorf("model %s does not support audio input", info.OriginModelName),
+						types.ErrorCodeUnsupportedCapability, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+				}
+			}
+		}
+	}
+
+	limit := maxTokensLimit(capabilities, promptTokens)
+	var stripped []string
+	var maxTokens uint
+	switch r := request.(type) {
+	case *dto.GeneralOpenAIRequest:
+		if r.MaxCompletionTokens != 0 {
+			r.MaxCompletionTokens = clampMaxTokens(r.MaxCompletionTokens, limit)
+		} else {
+			r.MaxTokens = clampMaxTokens(r.MaxTokens, limit)
+		}
+		maxTokens = r.GetMaxTokens()
+		if unsupported(capabilities.SupportsTools) && (len(r.Tools) > 0 || r.ToolChoice != nil) {
+			r.Tools, r.ToolChoice, r.ParallelTooCalls = nil, nil, nil
+			stripped = append(stripped, "tools")
+		}
+		if unsupported(capabilities.SupportsJsonMode) && r.ResponseFormat != nil && r.ResponseFormat.Type != "text" {
+			r.ResponseFormat = nil
+			stripped = append(stripped, "response_format")
+		}
+		if unsupported(capabilities.SupportsReasoning) && (r.ReasoningEffort != "" || len(r.Reasoning) > 0 || len(r.EnableThinking) > 0) {
+			r.ReasoningEffort, r.Reasoning, r.EnableThinking = "", nil, nil
+			stripped = append(stripped, "reasoning")
+		}
+		if unsupported(capabilities.SupportsAudio) && (len(r.Audio) > 0 || len(r.Modalities) > 0) {
+			r.Audio, r.Modalities = nil, nil
+			stripped = append(stripped, "audio")
+		}
+	case *dto.ClaudeRequest:
+		r.MaxTokens = clampMaxTokens(r.MaxTokens, limit)
+		maxTokens = r.MaxTokens
+		if unsupported(capabilities.SupportsTools) && (r.Tools != nil || r.ToolChoice != nil) {
+			r.Tools, r.ToolChoice = nil, nil
+			stripped = append(stripped, "tools")
+		}
+		if unsupported(capabilities.SupportsJsonMode) && len(r.OutputFormat) > 0 {
+			r.OutputFormat = nil
+			stripped = append(stripped, "output_format")
+		}
+		if unsupported(capabilities.SupportsReasoning) && r.Thinking != nil {
+			r.Thinking = nil
+			stripped = append(stripped, "thinking")
+		}
+		if newAPIError := clampClaudeThinkingBudget(info.OriginModelName, r); newAPIError != nil {
+			return newAPIError
+		}
+	case *dto.GeminiChatRequest:
+		config := &r.GenerationConfig
+		config.MaxOutputTokens = clampMaxTokens(config.MaxOutputTokens, limit)
+		maxTokens = config.MaxOutputTokens
+		if unsupported(capabilities.SupportsTools) && (len(r.Tools) > 0 || r.ToolConfig != nil) {
+			r.Tools, r.ToolConfig = nil, nil
+			stripped = append(stripped, "tools")
+		}
+		if unsupported(capabilities.SupportsJsonMode) && strings.EqualFold(config.ResponseMimeType, "application/json") {
+			config.ResponseMimeType, config.ResponseSchema, config.ResponseJsonSchema = "", nil, nil
+			stripped = append(stripped, "responseMimeType")
+		}
+		if unsupported(capabilities.SupportsReasoning) && config.ThinkingConfig != nil {
+			config.ThinkingConfig = nil
+			stripped = append(stripped, "thinkingConfig")
+		}
+	case *dto.OpenAIResponsesRequest:
+		r.MaxOutputTokens = clampMaxTokens(r.MaxOutputTokens, limit)
+		maxTokens = r.MaxOutputTokens
+		if unsupported(capabilities.SupportsTools) && (len(r.Tools) > 0 || len(r.ToolChoice) > 0) {
+			r.Tools, r.ToolChoice, r.ParallelToolCalls = nil, nil, nil
+			stripped = append(stripped, "tools")
+		}
+		if unsupported(capabilities.SupportsJsonMode) && len(r.Text) > 0 {
+			if text, ok := removeResponsesTextFormat(r.Text); ok {
+				r.Text = text
+				stripped = append(stripped, "text.format")
+			}
+		}
+		if unsupported(capabilities.SupportsReasoning) && r.Reasoning != nil {
+			r.Reasoning = nil
+			stripped = append(stripped, "reasoning")
+		}
+	default:
+		return nil
+	}
+
+	if meta != nil {
+		meta.MaxTokens = int(maxTokens)
+	}
+	if len(stripped) > 0 {
+		logger.LogDebug(c, fmt.Sprintf("model %s capabilities: removed unsupported parameters %s", info.OriginModelName, strings.Join(stripped, ", ")))
+	}
+	return nil
+}
+
+func unsupported(capability *bool) bool {
+	return capability != nil && !*capability
+}
+
+// maxTokensLimit 返回允许的最大输出，取模型最大输出与剩余上下文中较小者，0 表示不限制；
+// 调用方需先拒绝提示词占满上下文窗口的请求，否则剩余上下文为 0 会被当作不限制
+func maxTokensLimit(capabilities dto.ModelCapabilities, promptTokens int) int {
+	limit := capabilities.MaxOutputTokens
+	if capabilities.ContextWindow > 0 && promptTokens > 0 {
+		remaining := capabilities.ContextWindow - promptTokens
+		if limit == 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+func clampMaxTokens(maxTokens uint, limit int) uint {
+	if limit <= 0 {
+		return maxTokens
+	}
+	if maxTokens == 0 {
+		if operation_setting.GetModelCapabilitySetting().FillDefaultMaxTokens {
+			return uint(limit)
+		}
+		return 0
+	}
+	if maxTokens > uint(limit) {
+		return uint(limit)
+	}
+	return maxTokens
+}
+
+// Claude 要求 thinking.budget_tokens 不小于 1024 且小于 max_tokens
+const claudeMinThinkingBudget = 1024
+
+// clampClaudeThinkingBudget max_tokens 被截断后同步截断思考预算，剩余输出空间不足以开启思考时拒绝
+func clampClaudeThinkingBudget(modelName string, r *dto.ClaudeRequest) *types.NewAPIError {
+	if r.Thinking == nil || r.Thinking.BudgetTokens == nil || r.MaxTokens == 0 {
+		return nil
+	}
+	maxTokens := int(r.MaxTokens)
+	if *r.Thinking.BudgetTokens < maxTokens {
+		return nil
+	}
+	if maxTokens <= claudeMinThinkingBudget {
+		return types.NewErrorWithStatusCode(
+			fmt.Errorf("max_tokens of %d for model %s leaves no room for a thinking budget of at least %d tokens", maxTokens, modelName, claudeMinThinkingBudget),
+			types.ErrorCodeContextLengthExceeded, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	r.Thinking.BudgetTokens = common.GetPointer(maxTokens - 1)
+	return nil
+}
+
+// removeResponsesTextFormat 移除 text.format 中的 JSON 输出格式，保留 verbosity 等其他字段
+func removeResponsesTextFormat(raw []byte) ([]byte, bool) {
+	var text map[string]any
+	if err := common.Unmarshal(raw, &text); err != nil {
+		return raw, false
+	}
+	format, ok := text["format"].(map[string]any)
+	if !ok || common.Interface2String(format["type"]) == "text" {
+		return raw, false
+	}
+	delete(text, "format")
+	if len(text) == 0 {
+		return nil, true
+	}
+	out, err := common.Marshal(text)
+	if err != nil {
+		return raw, false
+	}
+	return out, true
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxTokensLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		contextWindow int
+		maxOutput     int
+		promptTokens  int
+		want          int
+	}{
+		{name: "no limits", want: 0},
+		{name: "max output only", maxOutput: 4096, promptTokens: 100, want: 4096},
+		{name: "remaining context below max output", contextWindow: 8000, maxOutput: 4096, promptTokens: 6000, want: 2000},
+		{name: "max output below remaining context", contextWindow: 128000, maxOutput: 4096, promptTokens: 1000, want: 4096},
+		{name: "context window only", contextWindow: 8000, promptTokens: 7999, want: 1},
+		{name: "unknown prompt size", contextWindow: 8000, maxOutput: 4096, want: 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capabilities := dto.ModelCapabilities{ContextWindow: tt.contextWindow, MaxOutputTokens: tt.maxOutput}
+			require.Equal(t, tt.want, maxTokensLimit(capabilities, tt.promptTokens))
+		})
+	}
+}
+
+func TestClampMaxTokens(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxTokens   uint
+		limit       int
+		fillDefault bool
+		want        uint
+	}{
+		{name: "no limit", maxTokens: 100000, limit: 0, want: 100000},
+		{name: "within limit", maxTokens: 100, limit: 4096, want: 100},
+		{name: "clamped", maxTokens: 10000, limit: 4096, want: 4096},
+		{name: "unset stays unset", maxTokens: 0, limit: 4096, want: 0},
+		{name: "unset filled with limit", maxTokens: 0, limit: 4096, fillDefault: true, want: 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setting := operation_setting.GetModelCapabilitySetting()
+			original := setting.FillDefaultMaxTokens
+			t.Cleanup(func() {
+				setting.FillDefaultMaxTokens = original
+			})
+			setting.FillDefaultMaxTokens = tt.fillDefault
+			require.Equal(t, tt.want, clampMaxTokens(tt.maxTokens, tt.limit))
+		})
+	}
+}
+
+func TestClampClaudeThinkingBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxTokens  uint
+		budget     *int
+		wantBudget *int
+		wantErr    bool
+	}{
+		{name: "no thinking budget", maxTokens: 2000},
+		{name: "budget below max tokens", maxTokens: 8000, budget: common.GetPointer(4000), wantBudget: common.GetPointer(4000)},
+		{name: "budget clamped below max tokens", maxTokens: 4096, budget: common.GetPointer(8000), wantBudget: common.GetPointer(4095)},
+		{name: "budget equal to max tokens", maxTokens: 2048, budget: common.GetPointer(2048), wantBudget: common.GetPointer(2047)},
+		{name: "no room for minimum budget", maxTokens: 1024, budget: common.GetPointer(2048), wantErr: true},
+		{name: "unset max tokens", maxTokens: 0, budget: common.GetPointer(2048), wantBudget: common.GetPointer(2048)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &dto.ClaudeRequest{MaxTokens: tt.maxTokens}
+			if tt.budget != nil {
+				r.Thinking = &dto.Thinking{Type: "enabled", BudgetTokens: tt.budget}
+			}
+			apiErr := clampClaudeThinkingBudget("claude-test", r)
+			if tt.wantErr {
+				require.NotNil(t, apiErr)
+				require.Equal(t, types.ErrorCodeContextLengthExceeded, apiErr.GetErrorCode())
+				require.True(t, types.IsSkipRetryError(apiErr))
+				return
+			}
+			require.Nil(t, apiErr)
+			if tt.wantBudget == nil {
+				require.Nil(t, r.Thinking)
+				return
+			}
+			require.Equal(t, *tt.wantBudget, *r.Thinking.BudgetTokens)
+		})
+	}
+}
+
+func TestRemoveResponsesTextFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		want        string
+		wantRemoved bool
+	}{
+		{name: "json schema removed", text: `{"format":{"type":"json_schema","schema":{}}}`, want: ``, wantRemoved: true},
+		{name: "verbosity kept", text: `{"format":{"type":"json_object"},"verbosity":"low"}`, want: `{"verbosity":"low"}`, wantRemoved: true},
+		{name: "text format kept", text: `{"format":{"type":"text"}}`, want: `{"format":{"type":"text"}}`},
+		{name: "no format", text: `{"verbosity":"low"}`, want: `{"verbosity":"low"}`},
+		{name: "invalid json", text: `{`, want: `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, removed := removeResponsesTextFormat([]byte(tt.text))
+			require.Equal(t, tt.wantRemoved, removed)
+			require.Equal(t, tt.want, string(out))
+		})
+	}
+}
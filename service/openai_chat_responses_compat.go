@@ -1,6 +1,8 @@
 package service
 
 import (
+	"encoding/json"
+
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/service/openaicompat"
 )
@@ -16,3 +18,19 @@ func ResponsesResponseToChatCompletionsResponse(resp *dto.OpenAIResponsesRespons
 func ExtractOutputTextFromResponses(resp *dto.OpenAIResponsesResponse) string {
 	return openaicompat.ExtractOutputTextFromResponses(resp)
 }
+
+func ResponsesRequestToChatCompletionsRequest(req *dto.OpenAIResponsesRequest, history []dto.Message) (*dto.GeneralOpenAIRequest, error) {
+	return openaicompat.ResponsesRequestToChatCompletionsRequest(req, history)
+}
+
+func ResponsesInputToChatMessages(input json.RawMessage) ([]dto.Message, error) {
+	return openaicompat.ResponsesInputToChatMessages(input)
+}
+
+func NewResponsesResponse(req *dto.OpenAIResponsesRequest, id string, model string, createdAt int64) *dto.OpenAIResponsesResponse {
+	return openaicompat.NewResponsesResponse(req, id, model, createdAt)
+}
+
+func ResponsesOutputToChatMessages(outputs []dto.ResponsesOutput) []dto.Message {
+	return openaicompat.ResponsesOutputToChatMessages(outputs)
+}
@@ -12,3 +12,7 @@ func ShouldChatCompletionsUseResponsesPolicy(policy model_setting.ChatCompletion
 func ShouldChatCompletionsUseResponsesGlobal(channelID int, model string) bool {
 	return openaicompat.ShouldChatCompletionsUseResponsesGlobal(channelID, model)
 }
+
+func ShouldResponsesUseChatCompletionsGlobal(channelID int, model string) bool {
+	return openaicompat.ShouldResponsesUseChatCompletionsGlobal(channelID, model)
+}
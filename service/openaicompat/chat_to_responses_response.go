@@ -0,0 +1,393 @@
+package openaicompat
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// ResponsesStoreEnabled store 未显式设置为 false 时保存响应，与 OpenAI 默认行为一致
+func ResponsesStoreEnabled(req *dto.OpenAIResponsesRequest) bool {
+	if req == nil || len(req.Store) == 0 {
+		return true
+	}
+	var store bool
+	if err := common.Unmarshal(req.Store, &store); err != nil {
+		return true
+	}
+	return store
+}
+
+// NewResponsesResponse 根据请求生成 /v1/responses 响应骨架，输出与用量在完成后填充
+func NewResponsesResponse(req *dto.OpenAIResponsesRequest, id string, model string, createdAt int64) *dto.OpenAIResponsesResponse {
+	resp := &dto.OpenAIResponsesResponse{
+		ID:                 id,
+		Object:             "response",
+		CreatedAt:          int(createdAt),
+		Status:             "in_progress",
+		Instructions:       ResponsesInstructions(req),
+		MaxOutputTokens:    int(req.MaxOutputTokens),
+		Model:              model,
+		Output:             []dto.ResponsesOutput{},
+		ParallelToolCalls:  true,
+		PreviousResponseID: req.PreviousResponseID,
+		Reasoning:          req.Reasoning,
+		Store:              ResponsesStoreEnabled(req),
+		Temperature:        1,
+		ToolChoice:         "auto",
+		Tools:              req.GetToolsMap(),
+		TopP:               1,
+		Truncation:         "disabled",
+		Metadata:           req.Metadata,
+	}
+	if resp.Tools == nil {
+		resp.Tools = []map[string]any{}
+	}
+	if len(resp.Metadata) == 0 {
+		resp.Metadata = []byte("{}")
+	}
+	if req.Temperature != nil {
+		resp.Temperature = *req.Temperature
+	}
+	if req.TopP != nil {
+		resp.TopP = *req.TopP
+	}
+	if len(req.ParallelToolCalls) > 0 {
+		_ = common.Unmarshal(req.ParallelToolCalls, &resp.ParallelToolCalls)
+	}
+	if common.GetJsonType(req.ToolChoice) == "string" {
+		_ = common.Unmarshal(req.ToolChoice, &resp.ToolChoice)
+	}
+	if req.User != "" {
+		resp.User, _ = common.Marshal(req.User)
+	}
+	return resp
+}
+
+// ChatUsageToResponsesUsage 补充 Responses 格式的 input_tokens / output_tokens
+func ChatUsageToResponsesUsage(usage *dto.Usage) *dto.Usage {
+	if usage == nil {
+		return nil
+	}
+	out := *usage
+	out.InputTokens = usage.PromptTokens
+	out.OutputTokens = usage.CompletionTokens
+	if out.TotalTokens == 0 {
+		out.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	out.InputTokensDetails = &dto.InputTokenDetails{
+		CachedTokens: usage.PromptTokensDetails.CachedTokens,
+	}
+	return &out
+}
+
+// applyResponsesFinishReason 根据 chat 的 finish_reason 设置响应状态
+func applyResponsesFinishReason(resp *dto.OpenAIResponsesResponse, finishReason string) {
+	switch finishReason {
+	case "length":
+		resp.Status = "incomplete"
+		resp.IncompleteDetails = &dto.IncompleteDetails{Reason: "max_output_tokens"}
+	case "content_filter":
+		resp.Status = "incomplete"
+		resp.IncompleteDetails = &dto.IncompleteDetails{Reason: "content_filter"}
+	default:
+		resp.Status = "completed"
+	}
+}
+
+func newResponsesMessageOutput(text string) dto.ResponsesOutput {
+	return dto.ResponsesOutput{
+		Type:   "message",
+		ID:     "msg_" + common.GetUUID(),
+		Status: "completed",
+		Role:   "assistant",
+		Content: []dto.ResponsesOutputContent{{
+			Type:        "output_text",
+			Text:        text,
+			Annotations: []interface{}{},
+		}},
+	}
+}
+
+// ChatCompletionsResponseToResponsesResponse 将非流式 chat 响应填充到 Responses 响应骨架中
+func ChatCompletionsResponseToResponsesResponse(chatResp *dto.OpenAITextResponse, resp *dto.OpenAIResponsesResponse) *dto.OpenAIResponsesResponse {
+	resp.Output = []dto.ResponsesOutput{}
+	finishReason := ""
+	if len(chatResp.Choices) > 0 {
+		choice := chatResp.Choices[0]
+		finishReason = choice.FinishReason
+		if text := choice.Message.StringContent(); text != "" {
+			resp.Output = append(resp.Output, newResponsesMessageOutput(text))
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			callId := toolCall.ID
+			if callId == "" {
+				callId = "call_" + common.GetUUID()
+			}
+			resp.Output = append(resp.Output, dto.ResponsesOutput{
+				Type:      "function_call",
+				ID:        "fc_" + common.GetUUID(),
+				Status:    "completed",
+				CallId:    callId,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+	}
+	applyResponsesFinishReason(resp, finishReason)
+	resp.Usage = ChatUsageToResponsesUsage(&chatResp.Usage)
+	return resp
+}
+
+// ResponsesOutputToChatMessages 将输出转换为 assistant 消息，用于保存对话历史
+func ResponsesOutputToChatMessages(outputs []dto.ResponsesOutput) []dto.Message {
+	var text strings.Builder
+	var toolCalls []dto.ToolCallRequest
+	for _, output := range outputs {
+		switch output.Type {
+		case "message":
+			for _, content := range output.Content {
+				text.WriteString(content.Text)
+			}
+		case "function_call":
+			toolCalls = append(toolCalls, dto.ToolCallRequest{
+				ID:   output.CallId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      output.Name,
+					Arguments: output.Arguments,
+				},
+			})
+		}
+	}
+	if text.Len() == 0 && len(toolCalls) == 0 {
+		return nil
+	}
+	msg := dto.Message{Role: "assistant", Content: text.String()}
+	if len(toolCalls) > 0 {
+		msg.SetToolCalls(toolCalls)
+	}
+	return []dto.Message{msg}
+}
+
+type responsesStreamItem struct {
+	outputIndex int
+	output      dto.ResponsesOutput
+	text        strings.Builder
+	arguments   strings.Builder
+	done        bool
+}
+
+// ChatToResponsesStreamConverter 将 chat/completions 流式块翻译为 /v1/responses 流式事件
+type ChatToResponsesStreamConverter struct {
+	response     *dto.OpenAIResponsesResponse
+	sequence     int
+	started      bool
+	items        []*responsesStreamItem
+	message      *responsesStreamItem         // 当前正在输出的文本项
+	toolCalls    map[int]*responsesStreamItem // chat tool_calls 的 index -> 输出项
+	finishReason string
+	usage        *dto.Usage
+}
+
+func NewChatToResponsesStreamConverter(resp *dto.OpenAIResponsesResponse) *ChatToResponsesStreamConverter {
+	return &ChatToResponsesStreamConverter{
+		response:  resp,
+		toolCalls: map[int]*responsesStreamItem{},
+	}
+}
+
+// Started 是否已输出 response.created 事件
+func (s *ChatToResponsesStreamConverter) Started() bool {
+	return s.started
+}
+
+// Response 返回当前的响应对象，Finish 之后包含完整输出与用量
+func (s *ChatToResponsesStreamConverter) Response() *dto.OpenAIResponsesResponse {
+	return s.response
+}
+
+func (s *ChatToResponsesStreamConverter) event(eventType string, fields map[string]any) map[string]any {
+	fields["type"] = eventType
+	fields["sequence_number"] = s.sequence
+	s.sequence++
+	return fields
+}
+
+func (s *ChatToResponsesStreamConverter) start() []map[string]any {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return []map[string]any{
+		s.event("response.created", map[string]any{"response": s.response}),
+		s.event("response.in_progress", map[string]any{"response": s.response}),
+	}
+}
+
+func (s *ChatToResponsesStreamConverter) addItem(output dto.ResponsesOutput) (*responsesStreamItem, map[string]any) {
+	item := &responsesStreamItem{outputIndex: len(s.items), output: output}
+	s.items = append(s.items, item)
+	return item, s.event("response.output_item.added", map[string]any{
+		"output_index": item.outputIndex,
+		"item":         item.output,
+	})
+}
+
+func (s *ChatToResponsesStreamConverter) closeMessage() []map[string]any {
+	item := s.message
+	if item == nil {
+		return nil
+	}
+	s.message = nil
+	item.done = true
+	text := item.text.String()
+	part := dto.ResponsesOutputContent{Type: "output_text", Text: text, Annotations: []interface{}{}}
+	item.output.Status = "completed"
+	item.output.Content = []dto.ResponsesOutputContent{part}
+	return []map[string]any{
+		s.event("response.output_text.done", map[string]any{
+			"item_id":       item.output.ID,
+			"output_index":  item.outputIndex,
+			"content_index": 0,
+			"text":          text,
+		}),
+		s.event("response.content_part.done", map[string]any{
+			"item_id":       item.output.ID,
+			"output_index":  item.outputIndex,
+			"content_index": 0,
+			"part":          part,
+		}),
+		s.event(dto.ResponsesOutputTypeItemDone, map[string]any{
+			"output_index": item.outputIndex,
+			"item":         item.output,
+		}),
+	}
+}
+
+func (s *ChatToResponsesStreamConverter) closeToolCall(item *responsesStreamItem) []map[string]any {
+	item.done = true
+	item.output.Status = "completed"
+	item.output.Arguments = item.arguments.String()
+	return []map[string]any{
+		s.event("response.function_call_arguments.done", map[string]any{
+			"item_id":      item.output.ID,
+			"output_index": item.outputIndex,
+			"arguments":    item.output.Arguments,
+		}),
+		s.event(dto.ResponsesOutputTypeItemDone, map[string]any{
+			"output_index": item.outputIndex,
+			"item":         item.output,
+		}),
+	}
+}
+
+// Chunk 处理一个 chat 流式块，返回需要发送的事件
+func (s *ChatToResponsesStreamConverter) Chunk(chunk *dto.ChatCompletionsStreamResponse) []map[string]any {
+	events := s.start()
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if delta := choice.Delta.GetContentString(); delta != "" {
+			if s.message == nil {
+				item, added := s.addItem(dto.ResponsesOutput{
+					Type:    "message",
+					ID:      "msg_" + common.GetUUID(),
+					Status:  "in_progress",
+					Role:    "assistant",
+					Content: []dto.ResponsesOutputContent{},
+				})
+				s.message = item
+				events = append(events, added, s.event("response.content_part.added", map[string]any{
+					"item_id":       item.output.ID,
+					"output_index":  item.outputIndex,
+					"content_index": 0,
+					"part":          dto.ResponsesOutputContent{Type: "output_text", Text: "", Annotations: []interface{}{}},
+				}))
+			}
+			s.message.text.WriteString(delta)
+			events = append(events, s.event("response.output_text.delta", map[string]any{
+				"item_id":       s.message.output.ID,
+				"output_index":  s.message.outputIndex,
+				"content_index": 0,
+				"delta":         delta,
+			}))
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			index := 0
+			if toolCall.Index != nil {
+				index = *toolCall.Index
+			}
+			item, ok := s.toolCalls[index]
+			if !ok {
+				// 工具调用开始后文本项不再追加
+				events = append(events, s.closeMessage()...)
+				callId := toolCall.ID
+				if callId == "" {
+					callId = "call_" + common.GetUUID()
+				}
+				var added map[string]any
+				item, added = s.addItem(dto.ResponsesOutput{
+					Type:   "function_call",
+					ID:     "fc_" + common.GetUUID(),
+					Status: "in_progress",
+					CallId: callId,
+					Name:   toolCall.Function.Name,
+				})
+				s.toolCalls[index] = item
+				events = append(events, added)
+			} else if item.output.Name == "" {
+				item.output.Name = toolCall.Function.Name
+			}
+			if toolCall.Function.Arguments != "" {
+				item.arguments.WriteString(toolCall.Function.Arguments)
+				events = append(events, s.event("response.function_call_arguments.delta", map[string]any{
+					"item_id":      item.output.ID,
+					"output_index": item.outputIndex,
+					"delta":        toolCall.Function.Arguments,
+				}))
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.finishReason = *choice.FinishReason
+		}
+	}
+	return events
+}
+
+// Finish 关闭所有输出项并生成 response.completed 事件，usage 为空时使用流中携带的用量
+func (s *ChatToResponsesStreamConverter) Finish(usage *dto.Usage) []map[string]any {
+	events := s.start()
+	events = append(events, s.closeMessage()...)
+	indexes := make([]int, 0, len(s.toolCalls))
+	for index := range s.toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		if item := s.toolCalls[index]; !item.done {
+			events = append(events, s.closeToolCall(item)...)
+		}
+	}
+
+	s.response.Output = make([]dto.ResponsesOutput, 0, len(s.items))
+	for _, item := range s.items {
+		s.response.Output = append(s.response.Output, item.output)
+	}
+	if usage == nil {
+		usage = s.usage
+	}
+	s.response.Usage = ChatUsageToResponsesUsage(usage)
+	applyResponsesFinishReason(s.response, s.finishReason)
+	eventType := "response.completed"
+	if s.response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	return append(events, s.event(eventType, map[string]any{"response": s.response}))
+}
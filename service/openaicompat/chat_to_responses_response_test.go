@@ -0,0 +1,241 @@
+package openaicompat
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newResponsesForTest(t *testing.T, request string) *dto.OpenAIResponsesResponse {
+	t.Helper()
+	var req dto.OpenAIResponsesRequest
+	require.NoError(t, common.UnmarshalJsonStr(request, &req))
+	return NewResponsesResponse(&req, "resp_1", "m", 100)
+}
+
+func TestResponsesStoreEnabled(t *testing.T) {
+	tests := []struct {
+		request string
+		want    bool
+	}{
+		{request: `{}`, want: true},
+		{request: `{"store":true}`, want: true},
+		{request: `{"store":false}`, want: false},
+		{request: `{"store":"no"}`, want: true},
+	}
+	for _, tt := range tests {
+		var req dto.OpenAIResponsesRequest
+		require.NoError(t, common.UnmarshalJsonStr(tt.request, &req))
+		require.Equal(t, tt.want, ResponsesStoreEnabled(&req), tt.request)
+	}
+}
+
+func TestChatCompletionsResponseToResponsesResponse(t *testing.T) {
+	tests := []struct {
+		name           string
+		chat           string
+		wantStatus     string
+		wantIncomplete string
+		wantTypes      []string
+		check          func(t *testing.T, resp *dto.OpenAIResponsesResponse)
+	}{
+		{
+			name:       "text answer",
+			chat:       `{"choices":[{"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"prompt_tokens_details":{"cached_tokens":1}}}`,
+			wantStatus: "completed",
+			wantTypes:  []string{"message"},
+			check: func(t *testing.T, resp *dto.OpenAIResponsesResponse) {
+				require.Equal(t, "hello", resp.Output[0].Content[0].Text)
+				require.Equal(t, 3, resp.Usage.InputTokens)
+				require.Equal(t, 2, resp.Usage.OutputTokens)
+				require.Equal(t, 5, resp.Usage.TotalTokens)
+				require.Equal(t, 1, resp.Usage.InputTokensDetails.CachedTokens)
+			},
+		},
+		{
+			name:       "text and tool calls",
+			chat:       `{"choices":[{"message":{"role":"assistant","content":"checking","tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`,
+			wantStatus: "completed",
+			wantTypes:  []string{"message", "function_call"},
+			check: func(t *testing.T, resp *dto.OpenAIResponsesResponse) {
+				require.Equal(t, "call_1", resp.Output[1].CallId)
+				require.Equal(t, "f", resp.Output[1].Name)
+			},
+		},
+		{
+			name:           "length limit",
+			chat:           `{"choices":[{"message":{"role":"assistant","content":"cut"},"finish_reason":"length"}]}`,
+			wantStatus:     "incomplete",
+			wantIncomplete: "max_output_tokens",
+			wantTypes:      []string{"message"},
+		},
+		{
+			name:           "content filter",
+			chat:           `{"choices":[{"message":{"role":"assistant","content":""},"finish_reason":"content_filter"}]}`,
+			wantStatus:     "incomplete",
+			wantIncomplete: "content_filter",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chatResp dto.OpenAITextResponse
+			require.NoError(t, common.UnmarshalJsonStr(tt.chat, &chatResp))
+			resp := ChatCompletionsResponseToResponsesResponse(&chatResp, newResponsesForTest(t, `{"model":"m"}`))
+			require.Equal(t, tt.wantStatus, resp.Status)
+			if tt.wantIncomplete == "" {
+				require.Nil(t, resp.IncompleteDetails)
+			} else {
+				require.Equal(t, tt.wantIncomplete, resp.IncompleteDetails.Reason)
+			}
+			var types []string
+			for _, output := range resp.Output {
+				types = append(types, output.Type)
+			}
+			require.Equal(t, tt.wantTypes, types)
+			if tt.check != nil {
+				tt.check(t, resp)
+			}
+		})
+	}
+}
+
+func TestResponsesOutputToChatMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		outputs []dto.ResponsesOutput
+		want    string
+	}{
+		{name: "empty", want: `null`},
+		{
+			name: "text and tool call",
+			outputs: []dto.ResponsesOutput{
+				{Type: "reasoning"},
+				{Type: "message", Content: []dto.ResponsesOutputContent{{Text: "a"}, {Text: "b"}}},
+				{Type: "function_call", CallId: "c1", Name: "f", Arguments: "{}"},
+			},
+			want: `[{"role":"assistant","content":"ab","tool_calls":[{"id":"c1","type":"function","function":{"name":"f","arguments":"{}"}}]}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.JSONEq(t, tt.want, marshalForTest(t, ResponsesOutputToChatMessages(tt.outputs)))
+		})
+	}
+}
+
+func TestChatToResponsesStreamConverter(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []string
+		usage      *dto.Usage
+		wantEvents []string
+		wantStatus string
+		check      func(t *testing.T, resp *dto.OpenAIResponsesResponse, events []map[string]any)
+	}{
+		{
+			name:       "no chunks",
+			wantEvents: []string{"response.created", "response.in_progress", "response.completed"},
+			wantStatus: "completed",
+		},
+		{
+			name: "text deltas",
+			chunks: []string{
+				`{"choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`,
+				`{"choices":[{"index":0,"delta":{"content":"Hel"}}]}`,
+				`{"choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":4,"completion_tokens":2,"total_tokens":6}}`,
+			},
+			wantEvents: []string{
+				"response.created", "response.in_progress",
+				"response.output_item.added", "response.content_part.added", "response.output_text.delta",
+				"response.output_text.delta",
+				"response.output_text.done", "response.content_part.done", "response.output_item.done",
+				"response.completed",
+			},
+			wantStatus: "completed",
+			check: func(t *testing.T, resp *dto.OpenAIResponsesResponse, events []map[string]any) {
+				require.Len(t, resp.Output, 1)
+				require.Equal(t, "Hello", resp.Output[0].Content[0].Text)
+				require.Equal(t, "completed", resp.Output[0].Status)
+				require.Equal(t, 6, resp.Usage.TotalTokens)
+				for i, event := range events {
+					require.Equal(t, i, event["sequence_number"])
+				}
+			},
+		},
+		{
+			name: "text then parallel tool calls",
+			chunks: []string{
+				`{"choices":[{"index":0,"delta":{"content":"Let me check"}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"a","arguments":""}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"b","arguments":"{}"}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"x\":"}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"1}"}}]},"finish_reason":"tool_calls"}]}`,
+			},
+			usage: &dto.Usage{PromptTokens: 1, CompletionTokens: 1},
+			wantEvents: []string{
+				"response.created", "response.in_progress",
+				"response.output_item.added", "response.content_part.added", "response.output_text.delta",
+				"response.output_text.done", "response.content_part.done", "response.output_item.done",
+				"response.output_item.added",
+				"response.output_item.added", "response.function_call_arguments.delta",
+				"response.function_call_arguments.delta",
+				"response.function_call_arguments.delta",
+				"response.function_call_arguments.done", "response.output_item.done",
+				"response.function_call_arguments.done", "response.output_item.done",
+				"response.completed",
+			},
+			wantStatus: "completed",
+			check: func(t *testing.T, resp *dto.OpenAIResponsesResponse, events []map[string]any) {
+				require.Len(t, resp.Output, 3)
+				require.Equal(t, "call_a", resp.Output[1].CallId)
+				require.Equal(t, `{"x":1}`, resp.Output[1].Arguments)
+				require.Equal(t, "call_b", resp.Output[2].CallId)
+				require.Equal(t, `{}`, resp.Output[2].Arguments)
+				require.Equal(t, 2, resp.Usage.TotalTokens)
+			},
+		},
+		{
+			name: "length limit",
+			chunks: []string{
+				`{"choices":[{"index":0,"delta":{"content":"cut"},"finish_reason":"length"}]}`,
+				`{"choices":[{"index":1,"delta":{"content":"other choice"}}]}`,
+			},
+			wantEvents: []string{
+				"response.created", "response.in_progress",
+				"response.output_item.added", "response.content_part.added", "response.output_text.delta",
+				"response.output_text.done", "response.content_part.done", "response.output_item.done",
+				"response.incomplete",
+			},
+			wantStatus: "incomplete",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter := NewChatToResponsesStreamConverter(newResponsesForTest(t, `{"model":"m","stream":true}`))
+			require.False(t, converter.Started())
+			var events []map[string]any
+			for _, raw := range tt.chunks {
+				var chunk dto.ChatCompletionsStreamResponse
+				require.NoError(t, common.UnmarshalJsonStr(raw, &chunk))
+				events = append(events, converter.Chunk(&chunk)...)
+				require.True(t, converter.Started())
+			}
+			events = append(events, converter.Finish(tt.usage)...)
+
+			var types []string
+			for _, event := range events {
+				types = append(types, event["type"].(string))
+			}
+			require.Equal(t, tt.wantEvents, types)
+			resp := converter.Response()
+			require.Equal(t, tt.wantStatus, resp.Status)
+			if tt.check != nil {
+				tt.check(t, resp, events)
+			}
+		})
+	}
+}
@@ -16,3 +16,11 @@ func ShouldChatCompletionsUseResponsesGlobal(channelID int, model string) bool {
 		model,
 	)
 }
+
+func ShouldResponsesUseChatCompletionsGlobal(channelID int, model string) bool {
+	return ShouldChatCompletionsUseResponsesPolicy(
+		model_setting.GetGlobalSettings().ResponsesToChatCompletionsPolicy,
+		channelID,
+		model,
+	)
+}
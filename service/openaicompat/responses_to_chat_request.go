@@ -0,0 +1,338 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// ResponsesRequestToChatCompletionsRequest 将 /v1/responses 请求转换为 chat/completions 请求，
+// history 为 previous_response_id 对应的历史对话，插入在 instructions 与本次输入之间
+func ResponsesRequestToChatCompletionsRequest(req *dto.OpenAIResponsesRequest, history []dto.Message) (*dto.GeneralOpenAIRequest, error) {
+	if req == nil {
+		return nil, errors.New("request is nil")
+	}
+	if req.Model == "" {
+		return nil, errors.New("model is required")
+	}
+
+	inputMessages, err := ResponsesInputToChatMessages(req.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]dto.Message, 0, len(history)+len(inputMessages)+1)
+	if instructions := ResponsesInstructions(req); instructions != "" {
+		messages = append(messages, dto.Message{
+			Role:    "system",
+			Content: instructions,
+		})
+	}
+	messages = append(messages, history...)
+	messages = append(messages, inputMessages...)
+	if len(messages) == 0 {
+		return nil, errors.New("input is required")
+	}
+
+	out := &dto.GeneralOpenAIRequest{
+		Model:       req.Model,
+		Messages:    messages,
+		Stream:      req.Stream,
+		MaxTokens:   req.MaxOutputTokens,
+		Temperature: req.Temperature,
+		User:        req.User,
+	}
+	if req.Stream {
+		out.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+	if req.TopP != nil {
+		out.TopP = *req.TopP
+	}
+	if req.Reasoning != nil && req.Reasoning.Effort != "" {
+		out.ReasoningEffort = req.Reasoning.Effort
+	}
+	if len(req.ParallelToolCalls) > 0 {
+		var parallel bool
+		if err := common.Unmarshal(req.ParallelToolCalls, &parallel); err == nil {
+			out.ParallelTooCalls = &parallel
+		}
+	}
+
+	for _, tool := range req.GetToolsMap() {
+		// 内置工具（web_search、file_search 等）无法在 chat 接口中使用，直接忽略
+		if common.Interface2String(tool["type"]) != "function" {
+			continue
+		}
+		name := strings.TrimSpace(common.Interface2String(tool["name"]))
+		if name == "" {
+			continue
+		}
+		out.Tools = append(out.Tools, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        name,
+				Description: common.Interface2String(tool["description"]),
+				Parameters:  tool["parameters"],
+			},
+		})
+	}
+
+	if len(req.ToolChoice) > 0 && len(out.Tools) > 0 {
+		out.ToolChoice = responsesToolChoiceToChat(req.ToolChoice)
+	}
+
+	if len(req.Text) > 0 {
+		out.ResponseFormat = responsesTextFormatToChat(req.Text)
+	}
+
+	return out, nil
+}
+
+// ResponsesInstructions 读取字符串形式的 instructions
+func ResponsesInstructions(req *dto.OpenAIResponsesRequest) string {
+	if req == nil || len(req.Instructions) == 0 || common.GetJsonType(req.Instructions) != "string" {
+		return ""
+	}
+	var instructions string
+	_ = common.Unmarshal(req.Instructions, &instructions)
+	return strings.TrimSpace(instructions)
+}
+
+// ResponsesInputToChatMessages 将 input 转换为 chat 消息，不包含 instructions
+func ResponsesInputToChatMessages(input json.RawMessage) ([]dto.Message, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	switch common.GetJsonType(input) {
+	case "string":
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, err
+		}
+		return []dto.Message{{Role: "user", Content: text}}, nil
+	case "array":
+	default:
+		return nil, fmt.Errorf("unsupported input type: %s", common.GetJsonType(input))
+	}
+
+	var items []map[string]any
+	if err := common.Unmarshal(input, &items); err != nil {
+		return nil, err
+	}
+
+	messages := make([]dto.Message, 0, len(items))
+	// 连续的 function_call 合并为同一条 assistant 消息的 tool_calls
+	var pendingToolCalls []dto.ToolCallRequest
+	flushToolCalls := func() {
+		if len(pendingToolCalls) == 0 {
+			return
+		}
+		last := len(messages) - 1
+		if last >= 0 && messages[last].Role == "assistant" && messages[last].ToolCalls == nil {
+			messages[last].SetToolCalls(pendingToolCalls)
+		} else {
+			msg := dto.Message{Role: "assistant", Content: ""}
+			msg.SetToolCalls(pendingToolCalls)
+			messages = append(messages, msg)
+		}
+		pendingToolCalls = nil
+	}
+
+	for _, item := range items {
+		itemType := common.Interface2String(item["type"])
+		switch itemType {
+		case "function_call":
+			callId := common.Interface2String(item["call_id"])
+			if callId == "" {
+				callId = common.Interface2String(item["id"])
+			}
+			pendingToolCalls = append(pendingToolCalls, dto.ToolCallRequest{
+				ID:   callId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      common.Interface2String(item["name"]),
+					Arguments: common.Interface2String(item["arguments"]),
+				},
+			})
+		case "function_call_output":
+			flushToolCalls()
+			messages = append(messages, dto.Message{
+				Role:       "tool",
+				Content:    responsesToolOutputToString(item["output"]),
+				ToolCallId: common.Interface2String(item["call_id"]),
+			})
+		case "", "message":
+			flushToolCalls()
+			role := strings.TrimSpace(common.Interface2String(item["role"]))
+			if role == "" {
+				role = "user"
+			}
+			if role == "developer" {
+				role = "system"
+			}
+			messages = append(messages, dto.Message{
+				Role:    role,
+				Content: responsesContentToChat(role, item["content"]),
+			})
+		default:
+			// reasoning、item_reference 及内置工具调用记录在 chat 接口中没有对应结构
+			continue
+		}
+	}
+	flushToolCalls()
+	return messages, nil
+}
+
+func responsesToolOutputToString(output any) string {
+	switch v := output.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []any:
+		// 仅包含文本时拼接为字符串
+		var sb strings.Builder
+		for _, part := range v {
+			m, ok := part.(map[string]any)
+			if !ok || common.Interface2String(m["type"]) != "input_text" {
+				sb.Reset()
+				break
+			}
+			sb.WriteString(common.Interface2String(m["text"]))
+		}
+		if sb.Len() > 0 {
+			return sb.String()
+		}
+	}
+	b, err := common.Marshal(output)
+	if err != nil {
+		return fmt.Sprintf("%v", output)
+	}
+	return string(b)
+}
+
+func responsesContentToChat(role string, content any) any {
+	parts, ok := content.([]any)
+	if !ok {
+		if content == nil {
+			return ""
+		}
+		return content
+	}
+
+	mediaContents := make([]dto.MediaContent, 0, len(parts))
+	allText := true
+	var sb strings.Builder
+	for _, part := range parts {
+		m, ok := part.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch common.Interface2String(m["type"]) {
+		case "input_text", "output_text", "text":
+			text := common.Interface2String(m["text"])
+			sb.WriteString(text)
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: text,
+			})
+		case "refusal":
+			text := common.Interface2String(m["refusal"])
+			sb.WriteString(text)
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: text,
+			})
+		case "input_image":
+			allText = false
+			imageUrl := dto.MessageImageUrl{
+				Url:    common.Interface2String(m["image_url"]),
+				Detail: common.Interface2String(m["detail"]),
+			}
+			if imageUrl.Url == "" {
+				imageUrl.Url = common.Interface2String(m["file_id"])
+			}
+			if imageUrl.Detail == "" {
+				imageUrl.Detail = "auto"
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type:     dto.ContentTypeImageURL,
+				ImageUrl: imageUrl,
+			})
+		case "input_file":
+			allText = false
+			file := map[string]any{}
+			for _, key := range []string{"file_id", "file_data", "filename"} {
+				if v := common.Interface2String(m[key]); v != "" {
+					file[key] = v
+				}
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeFile,
+				File: file,
+			})
+		case "input_audio":
+			allText = false
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type:       dto.ContentTypeInputAudio,
+				InputAudio: m["input_audio"],
+			})
+		}
+	}
+	// 纯文本（尤其是 assistant 历史消息）使用字符串，兼容性更好
+	if allText && (role != "user" || len(mediaContents) <= 1) {
+		return sb.String()
+	}
+	return mediaContents
+}
+
+func responsesToolChoiceToChat(raw json.RawMessage) any {
+	if common.GetJsonType(raw) == "string" {
+		var choice string
+		_ = common.Unmarshal(raw, &choice)
+		return choice
+	}
+	var m map[string]any
+	if err := common.Unmarshal(raw, &m); err != nil {
+		return nil
+	}
+	if common.Interface2String(m["type"]) == "function" {
+		if name := common.Interface2String(m["name"]); name != "" {
+			return map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": name},
+			}
+		}
+	}
+	return nil
+}
+
+func responsesTextFormatToChat(raw json.RawMessage) *dto.ResponseFormat {
+	var text struct {
+		Format map[string]any `json:"format"`
+	}
+	if err := common.Unmarshal(raw, &text); err != nil || text.Format == nil {
+		return nil
+	}
+	switch common.Interface2String(text.Format["type"]) {
+	case "json_object":
+		return &dto.ResponseFormat{Type: "json_object"}
+	case "json_schema":
+		schema := map[string]any{}
+		for _, key := range []string{"name", "description", "schema", "strict"} {
+			if v, ok := text.Format[key]; ok {
+				schema[key] = v
+			}
+		}
+		schemaRaw, err := common.Marshal(schema)
+		if err != nil {
+			return nil
+		}
+		return &dto.ResponseFormat{Type: "json_schema", JsonSchema: schemaRaw}
+	}
+	return nil
+}
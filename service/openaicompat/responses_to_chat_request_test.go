@@ -0,0 +1,183 @@
+package openaicompat
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+
+	"github.com/stretchr/testify/require"
+)
+
+func marshalForTest(t *testing.T, v any) string {
+	t.Helper()
+	data, err := common.Marshal(v)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestResponsesInputToChatMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty", input: ``, want: `null`},
+		{name: "string input", input: `"hi"`, want: `[{"role":"user","content":"hi"}]`},
+		{name: "object input", input: `{"role":"user"}`, wantErr: true},
+		{
+			name:  "developer role and text parts",
+			input: `[{"role":"developer","content":"be brief"},{"type":"message","role":"user","content":[{"type":"input_text","text":"hello"}]}]`,
+			want:  `[{"role":"system","content":"be brief"},{"role":"user","content":"hello"}]`,
+		},
+		{
+			name:  "multiple user text parts stay as parts",
+			input: `[{"role":"user","content":[{"type":"input_text","text":"a"},{"type":"input_text","text":"b"}]}]`,
+			want:  `[{"role":"user","content":[{"type":"text","text":"a"},{"type":"text","text":"b"}]}]`,
+		},
+		{
+			name:  "assistant output text joined",
+			input: `[{"role":"assistant","content":[{"type":"output_text","text":"a"},{"type":"refusal","refusal":"b"}]}]`,
+			want:  `[{"role":"assistant","content":"ab"}]`,
+		},
+		{
+			name:  "image part",
+			input: `[{"role":"user","content":[{"type":"input_text","text":"what"},{"type":"input_image","image_url":"https://x/y.png"}]}]`,
+			want:  `[{"role":"user","content":[{"type":"text","text":"what"},{"type":"image_url","image_url":{"url":"https://x/y.png","detail":"auto","MimeType":""}}]}]`,
+		},
+		{
+			name: "function calls merged into assistant message",
+			input: `[
+				{"role":"assistant","content":"checking"},
+				{"type":"function_call","call_id":"call_1","name":"a","arguments":"{}"},
+				{"type":"function_call","id":"fc_2","name":"b","arguments":"{\"x\":1}"},
+				{"type":"function_call_output","call_id":"call_1","output":"ok"},
+				{"type":"function_call_output","call_id":"fc_2","output":[{"type":"input_text","text":"o"},{"type":"input_text","text":"k"}]}
+			]`,
+			want: `[
+				{"role":"assistant","content":"checking","tool_calls":[
+					{"id":"call_1","type":"function","function":{"name":"a","arguments":"{}"}},
+					{"id":"fc_2","type":"function","function":{"name":"b","arguments":"{\"x\":1}"}}
+				]},
+				{"role":"tool","content":"ok","tool_call_id":"call_1"},
+				{"role":"tool","content":"ok","tool_call_id":"fc_2"}
+			]`,
+		},
+		{
+			name:  "function call without preceding assistant message",
+			input: `[{"role":"user","content":"hi"},{"type":"function_call","call_id":"c","name":"a","arguments":"{}"}]`,
+			want:  `[{"role":"user","content":"hi"},{"role":"assistant","content":"","tool_calls":[{"id":"c","type":"function","function":{"name":"a","arguments":"{}"}}]}]`,
+		},
+		{
+			name:  "non text tool output is json encoded",
+			input: `[{"type":"function_call_output","call_id":"c","output":{"temp":20}}]`,
+			want:  `[{"role":"tool","content":"{\"temp\":20}","tool_call_id":"c"}]`,
+		},
+		{
+			name:  "reasoning items are skipped",
+			input: `[{"type":"reasoning","summary":[]},{"role":"user","content":"hi"}]`,
+			want:  `[{"role":"user","content":"hi"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := ResponsesInputToChatMessages([]byte(tt.input))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, marshalForTest(t, messages))
+		})
+	}
+}
+
+func TestResponsesRequestToChatCompletionsRequest(t *testing.T) {
+	history := []dto.Message{{Role: "user", Content: "earlier"}, {Role: "assistant", Content: "reply"}}
+	tests := []struct {
+		name    string
+		request string
+		history []dto.Message
+		check   func(t *testing.T, out *dto.GeneralOpenAIRequest)
+		wantErr bool
+	}{
+		{name: "model required", request: `{"input":"hi"}`, wantErr: true},
+		{name: "input required", request: `{"model":"m"}`, wantErr: true},
+		{
+			name:    "instructions then history then input",
+			request: `{"model":"m","instructions":"sys","input":"now","max_output_tokens":100,"stream":true}`,
+			history: history,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.JSONEq(t, `[
+					{"role":"system","content":"sys"},
+					{"role":"user","content":"earlier"},
+					{"role":"assistant","content":"reply"},
+					{"role":"user","content":"now"}
+				]`, marshalForTest(t, out.Messages))
+				require.Equal(t, uint(100), out.MaxTokens)
+				require.True(t, out.Stream)
+				require.NotNil(t, out.StreamOptions)
+				require.True(t, out.StreamOptions.IncludeUsage)
+			},
+		},
+		{
+			name:    "history alone is enough",
+			request: `{"model":"m"}`,
+			history: history,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.Len(t, out.Messages, 2)
+				require.Nil(t, out.StreamOptions)
+			},
+		},
+		{
+			name: "function tools kept and built-in tools dropped",
+			request: `{"model":"m","input":"hi","tool_choice":{"type":"function","name":"get_weather"},"parallel_tool_calls":false,
+				"tools":[{"type":"web_search"},{"type":"function","name":"get_weather","description":"d","parameters":{"type":"object"}},{"type":"function","name":" "}]}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.JSONEq(t, `[{"type":"function","function":{"name":"get_weather","description":"d","parameters":{"type":"object"}}}]`, marshalForTest(t, out.Tools))
+				require.Equal(t, map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}}, out.ToolChoice)
+				require.NotNil(t, out.ParallelTooCalls)
+				require.False(t, *out.ParallelTooCalls)
+			},
+		},
+		{
+			name:    "tool choice without tools is dropped",
+			request: `{"model":"m","input":"hi","tool_choice":"required","tools":[{"type":"web_search"}]}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.Empty(t, out.Tools)
+				require.Nil(t, out.ToolChoice)
+			},
+		},
+		{
+			name:    "json schema text format",
+			request: `{"model":"m","input":"hi","reasoning":{"effort":"low"},"text":{"format":{"type":"json_schema","name":"r","schema":{"type":"object"},"strict":true}}}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.Equal(t, "low", out.ReasoningEffort)
+				require.NotNil(t, out.ResponseFormat)
+				require.Equal(t, "json_schema", out.ResponseFormat.Type)
+				require.JSONEq(t, `{"name":"r","schema":{"type":"object"},"strict":true}`, string(out.ResponseFormat.JsonSchema))
+			},
+		},
+		{
+			name:    "plain text format",
+			request: `{"model":"m","input":"hi","text":{"format":{"type":"text"}}}`,
+			check: func(t *testing.T, out *dto.GeneralOpenAIRequest) {
+				require.Nil(t, out.ResponseFormat)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req dto.OpenAIResponsesRequest
+			require.NoError(t, common.UnmarshalJsonStr(tt.request, &req))
+			out, err := ResponsesRequestToChatCompletionsRequest(&req, tt.history)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, out)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const payloadRedacted = "[REDACTED]"
+
+// 内置的脱敏规则，在 PII 实体之外还包括各类密钥
+var payloadPIIPatterns = func() []*regexp.Regexp {
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]{16,}`),
+		regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_-]{16,}`),
+	}
+	for _, p := range piiEntityPatterns {
+		patterns = append(patterns, p.pattern)
+	}
+	return patterns
+}()
+
+var payloadPatternCache sync.Map
+
+func getPayloadPattern(pattern string) *regexp.Regexp {
+	if re, ok := payloadPatternCache.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		common.SysError(fmt.Sprintf("invalid payload redact pattern %s: %s", pattern, err.Error()))
+		re = nil
+	}
+	payloadPatternCache.Store(pattern, re)
+	return re
+}
+
+// RedactPayload 按内置 PII 规则与自定义正则脱敏
+func RedactPayload(content string) string {
+	if content == "" {
+		return content
+	}
+	setting := operation_setting.GetPayloadCaptureSetting()
+	if setting.RedactPII {
+		for _, re := range payloadPIIPatterns {
+			content = re.ReplaceAllString(content, payloadRedacted)
+		}
+	}
+	for _, pattern := range setting.RedactPatterns {
+		if re := getPayloadPattern(pattern); re != nil {
+			content = re.ReplaceAllString(content, payloadRedacted)
+		}
+	}
+	return content
+}
+
+func truncatePayload(data []byte, limit int) (string, bool) {
+	if limit <= 0 || len(data) <= limit {
+		return string(data), false
+	}
+	return strings.ToValidUTF8(string(data[:limit]), ""), true
+}
+
+// PayloadCapture 采集单次请求的客户端请求体、上游请求体和返回给客户端的响应
+type PayloadCapture struct {
+	writer *payloadCaptureWriter
+}
+
+// NewPayloadCapture 未开启采集时返回 nil
+func NewPayloadCapture() *PayloadCapture {
+	if !operation_setting.GetPayloadCaptureSetting().Enabled {
+		return nil
+	}
+	return &PayloadCapture{}
+}
+
+// Begin 每次尝试前调用，按当前渠道判断是否采集，重试时清空已采集的内容
+func (pc *PayloadCapture) Begin(c *gin.Context, info *relaycommon.RelayInfo) {
+	channelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId)
+	enabled := operation_setting.ShouldCapturePayload(info.UserId, info.TokenId, channelId)
+	common.SetContextKey(c, constant.ContextKeyPayloadCapture, enabled)
+	common.SetContextKey(c, constant.ContextKeyPayloadUpstreamRequest, []byte(nil))
+	if !enabled {
+		return
+	}
+	if pc.writer == nil {
+		pc.writer = &payloadCaptureWriter{ResponseWriter: c.Writer, limit: operation_setting.GetPayloadCaptureSetting().MaxBytes}
+		c.Writer = pc.writer
+	}
+	pc.writer.body.Reset()
+	pc.writer.overflow = false
+}
+
+// Save 请求结束后异步保存采集的内容
+func (pc *PayloadCapture) Save(c *gin.Context, info *relaycommon.RelayInfo, apiErr *types.NewAPIError) {
+	if pc == nil || pc.writer == nil || !common.GetContextKeyBool(c, constant.ContextKeyPayloadCapture) {
+		return
+	}
+	setting := operation_setting.GetPayloadCaptureSetting()
+	requestBody, _ := common.GetRequestBody(c)
+	upstreamBody, _ := common.GetContextKeyType[[]byte](c, constant.ContextKeyPayloadUpstreamRequest)
+	request, requestTruncated := truncatePayload(requestBody, setting.MaxBytes)
+	upstreamRequest, upstreamTruncated := truncatePayload(upstreamBody, setting.MaxBytes)
+	now := time.Now()
+	payload := &model.CapturedPayload{
+		RequestId:       c.GetString(common.RequestIdKey),
+		UserId:          info.UserId,
+		TokenId:         info.TokenId,
+		ChannelId:       common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+		ModelName:       info.OriginModelName,
+		StatusCode:      pc.writer.Status(),
+		Request:         request,
+		UpstreamRequest: upstreamRequest,
+		Response:        strings.ToValidUTF8(pc.writer.body.String(), ""),
+		Truncated:       requestTruncated || upstreamTruncated || pc.writer.overflow,
+		CreatedAt:       now.Unix(),
+		ExpiresAt:       now.Add(time.Duration(setting.TTLHours) * time.Hour).Unix(),
+	}
+	if apiErr != nil {
+		payload.StatusCode = apiErr.StatusCode
+		payload.ErrorMessage = apiErr.Error()
+	}
+	gopool.Go(func() {
+		payload.Request = RedactPayload(payload.Request)
+		payload.UpstreamRequest = RedactPayload(payload.UpstreamRequest)
+		payload.Response = RedactPayload(payload.Response)
+		payload.ErrorMessage = RedactPayload(payload.ErrorMessage)
+		if err := payload.Insert(); err != nil {
+			common.SysError(fmt.Sprintf("failed to save captured payload %s: %s", payload.RequestId, err.Error()))
+		}
+	})
+}
+
+type payloadCaptureWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// capture 超出上限后保留已采集部分并标记截断
+func (w *payloadCaptureWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.limit > 0 && w.body.Len()+len(data) > w.limit {
+		w.body.Write(data[:w.limit-w.body.Len()])
+		w.overflow = true
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *payloadCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *payloadCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// CleanExpiredCapturedPayloads 定期清理过期的采集内容
+func CleanExpiredCapturedPayloads() {
+	for {
+		time.Sleep(time.Hour)
+		count, err := model.DeleteExpiredCapturedPayloads(common.GetTimestamp())
+		if err != nil {
+			common.SysError("failed to clean expired captured payloads: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired captured payloads", count))
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// piiEntityPatterns 按实体类型划分的 PII 规则，身份证号需在手机号之前匹配
+var piiEntityPatterns = []struct {
+	entity  string
+	pattern *regexp.Regexp
+}{
+	{operation_setting.PIIEntityEmail, regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{operation_setting.PIIEntityNationalId, regexp.MustCompile(`\b\d{17}[\dXx]\b`)},
+	{operation_setting.PIIEntityCard, regexp.MustCompile(`\b(?:\d{4}[ -]?){3}\d{4}\b`)},
+	{operation_setting.PIIEntityPhone, regexp.MustCompile(`\b1[3-9]\d{9}\b`)},
+	{operation_setting.PIIEntityPhone, regexp.MustCompile(`\+\d{1,3}[ -]?\d{6,14}\b`)},
+}
+
+var piiPlaceholderPattern = regexp.MustCompile(`\[(?:EMAIL|PHONE|CARD|NATIONAL_ID)_\d+\]`)
+
+// 流式还原时，末尾可能是被拆开的占位符，暂缓输出
+var piiPartialPlaceholderPattern = regexp.MustCompile(`\[[A-Z_]*\d*$`)
+
+// PIIRedactor 保存单次请求内原文与占位符的对应关系，重试其他渠道时占位符保持不变
+type PIIRedactor struct {
+	mu           sync.Mutex
+	placeholders map[string]string // 原文 -> 占位符
+	originals    map[string]string // 占位符 -> 原文
+	counters     map[string]int
+	counts       map[string]int // 最近一次转发中各类实体的脱敏次数
+	restore      bool
+}
+
+func getPIIRedactor(c *gin.Context) *PIIRedactor {
+	if redactor, ok := common.GetContextKeyType[*PIIRedactor](c, constant.ContextKeyPIIRedactor); ok {
+		return redactor
+	}
+	return nil
+}
+
+func (r *PIIRedactor) placeholder(entity string, original string) string {
+	if ph, ok := r.placeholders[original]; ok {
+		return ph
+	}
+	r.counters[entity]++
+	ph := fmt.Sprintf("[%s_%d]", strings.ToUpper(entity), r.counters[entity])
+	r.placeholders[original] = ph
+	r.originals[ph] = original
+	return ph
+}
+
+func (r *PIIRedactor) redact(text string, entities []string) string {
+	for _, p := range piiEntityPatterns {
+		if !slices.Contains(entities, p.entity) {
+			continue
+		}
+		text = p.pattern.ReplaceAllStringFunc(text, func(original string) string {
+			r.counts[p.entity]++
+			return r.placeholder(p.entity, original)
+		})
+	}
+	return text
+}
+
+// Restore 将文本中的占位符还原为原文
+func (r *PIIRedactor) Restore(text string) string {
+	if r == nil || !r.restore || !strings.Contains(text, "[") {
+		return text
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return piiPlaceholderPattern.ReplaceAllStringFunc(text, func(ph string) string {
+		if original, ok := r.originals[ph]; ok {
+			return original
+		}
+		return ph
+	})
+}
+
+// RestoreJSON 还原 JSON 中的占位符，原文按 JSON 字符串转义
+func (r *PIIRedactor) RestoreJSON(data []byte) []byte {
+	if r == nil || !r.restore || !bytes.Contains(data, []byte("[")) {
+		return data
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return piiPlaceholderPattern.ReplaceAllFunc(data, func(ph []byte) []byte {
+		original, ok := r.originals[string(ph)]
+		if !ok {
+			return ph
+		}
+		escaped, err := common.Marshal(original)
+		if err != nil {
+			return ph
+		}
+		return escaped[1 : len(escaped)-1]
+	})
+}
+
+// Counts 返回最近一次转发中各类实体的脱敏次数
+func (r *PIIRedactor) Counts() map[string]int {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.counts) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(r.counts))
+	for entity, count := range r.counts {
+		counts[entity] = count
+	}
+	return counts
+}
+
+// RedactRequestPII 渠道开启 PII 脱敏时，将上游请求体提示词中的 PII 替换为占位符
+func RedactRequestPII(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) io.Reader {
+	redactor := getPIIRedactor(c)
+	if !info.ChannelSetting.PIIRedaction {
+		if redactor != nil {
+			// 本次重试的渠道未开启脱敏，不还原也不计数
+			redactor.mu.Lock()
+			redactor.restore = false
+			redactor.counts = map[string]int{}
+			redactor.mu.Unlock()
+		}
+		return requestBody
+	}
+	entities := operation_setting.GetPIISetting().Entities
+	if requestBody == nil || len(entities) == 0 {
+		return requestBody
+	}
+	body, err := io.ReadAll(requestBody)
+	if err != nil {
+		logger.LogError(c, "read request body for pii redaction failed: "+err.Error())
+		return bytes.NewReader(body)
+	}
+	payload, err := decodeJSONPayload(body)
+	if err != nil {
+		return bytes.NewReader(body)
+	}
+	if redactor == nil {
+		redactor = &PIIRedactor{
+			placeholders: map[string]string{},
+			originals:    map[string]string{},
+			counters:     map[string]int{},
+		}
+		common.SetContextKey(c, constant.ContextKeyPIIRedactor, redactor)
+	}
+	redactor.mu.Lock()
+	redactor.restore = info.ChannelSetting.PIIRestore
+	redactor.counts = map[string]int{}
+	payload = walkTextStrings(payload, promptTextKeys, false, func(text string) string {
+		return redactor.redact(text, entities)
+	})
+	redactor.mu.Unlock()
+	redacted, err := common.Marshal(payload)
+	if err != nil {
+		logger.LogError(c, "marshal redacted request body failed: "+err.Error())
+		return bytes.NewReader(body)
+	}
+	return bytes.NewReader(redacted)
+}
+
+// RestoreResponsePII 还原非流式响应中的占位符
+func RestoreResponsePII(c *gin.Context, data []byte) []byte {
+	return getPIIRedactor(c).RestoreJSON(data)
+}
+
+// StreamTextFilter 流式输出文本的处理链：先还原 PII 占位符，再做护栏检查
+type StreamTextFilter struct {
+	pii       *PIIRedactor
+	pending   string
+	guardrail *StreamGuardrail
+}
+
+// NewStreamTextFilter 既不需要还原也没有护栏规则时返回 nil
+func NewStreamTextFilter(c *gin.Context, info *relaycommon.RelayInfo) *StreamTextFilter {
+	filter := NewPIIStreamFilter(c)
+	guardrail := NewStreamGuardrail(c, info)
+	if guardrail == nil {
+		return filter
+	}
+	if filter == nil {
+		filter = &StreamTextFilter{}
+	}
+	filter.guardrail = guardrail
+	return filter
+}
+
+// NewPIIStreamFilter 只做占位符还原，不需要还原时返回 nil
+func NewPIIStreamFilter(c *gin.Context) *StreamTextFilter {
+	redactor := getPIIRedactor(c)
+	if redactor == nil || !redactor.restore {
+		return nil
+	}
+	return &StreamTextFilter{pii: redactor}
+}
+
+// Blocked 是否已因命中护栏而中断输出
+func (f *StreamTextFilter) Blocked() bool {
+	return f != nil && f.guardrail.Blocked()
+}
+
+// Process 处理新增的输出文本，返回应发送给用户的文本以及是否需要中断输出
+func (f *StreamTextFilter) Process(delta string) (string, bool) {
+	if f == nil {
+		return delta, false
+	}
+	if f.pii != nil {
+		text := f.pending + delta
+		f.pending = ""
+		if loc := piiPartialPlaceholderPattern.FindStringIndex(text); loc != nil && loc[1]-loc[0] <= 24 {
+			f.pending = text[loc[0]:]
+			text = text[:loc[0]]
+		}
+		delta = f.pii.Restore(text)
+	}
+	if delta == "" {
+		return "", f.Blocked()
+	}
+	return f.guardrail.Check(delta)
+}
+
+// Flush 输出结束时取出暂缓的文本，并等待护栏完成对全部输出的审核
+func (f *StreamTextFilter) Flush() (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	text := f.pending
+	f.pending = ""
+	text, blocked := f.guardrail.Check(f.pii.Restore(text))
+	if blocked || f.guardrail.Finish() {
+		return "", true
+	}
+	return text, false
+}
+
+// RestoreRaw 还原携带完整文本的事件中的占位符，并按护栏规则脱敏
+func (f *StreamTextFilter) RestoreRaw(data string) string {
+	if f == nil {
+		return data
+	}
+	if f.pii != nil {
+		data = string(f.pii.RestoreJSON([]byte(data)))
+	}
+	return f.guardrail.RedactRaw(data)
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func newPIITestRelayInfo(redaction bool, restore bool) *relaycommon.RelayInfo {
+	info := newGuardrailTestRelayInfo()
+	info.ChannelSetting = dto.ChannelSettings{PIIRedaction: redaction, PIIRestore: restore}
+	return info
+}
+
+// redactPIIForTest 按渠道设置脱敏请求体，返回转发给上游的请求体
+func redactPIIForTest(t *testing.T, c *gin.Context, info *relaycommon.RelayInfo, body string) string {
+	t.Helper()
+	reader := RedactRequestPII(c, info, strings.NewReader(body))
+	redacted, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(redacted)
+}
+
+func TestRedactRequestPII(t *testing.T) {
+	tests := []struct {
+		name       string
+		redaction  bool
+		entities   []string
+		body       string
+		wantBody   string
+		wantCounts map[string]int
+	}{
+		{
+			name:      "channel without redaction",
+			redaction: false,
+			entities:  []string{operation_setting.PIIEntityEmail},
+			body:      `{"messages":[{"role":"user","content":"mail a@example.com"}]}`,
+			wantBody:  `{"messages":[{"role":"user","content":"mail a@example.com"}]}`,
+		},
+		{
+			name:       "same value shares a placeholder",
+			redaction:  true,
+			entities:   []string{operation_setting.PIIEntityEmail, operation_setting.PIIEntityPhone},
+			body:       `{"model":"gpt-4o","messages":[{"role":"user","content":"a@example.com, 13800138000, a@example.com, b@example.com"}]}`,
+			wantBody:   `{"model":"gpt-4o","messages":[{"role":"user","content":"[EMAIL_1], [PHONE_1], [EMAIL_1], [EMAIL_2]"}]}`,
+			wantCounts: map[string]int{operation_setting.PIIEntityEmail: 3, operation_setting.PIIEntityPhone: 1},
+		},
+		{
+			name:       "national id is not taken as phone",
+			redaction:  true,
+			entities:   []string{operation_setting.PIIEntityNationalId, operation_setting.PIIEntityPhone},
+			body:       `{"input":"id 11010519491231002X"}`,
+			wantBody:   `{"input":"id [NATIONAL_ID_1]"}`,
+			wantCounts: map[string]int{operation_setting.PIIEntityNationalId: 1},
+		},
+		{
+			name:       "card number and content parts",
+			redaction:  true,
+			entities:   []string{operation_setting.PIIEntityCard},
+			body:       `{"messages":[{"role":"user","content":[{"type":"text","text":"card 4111 1111 1111 1111"}]}]}`,
+			wantBody:   `{"messages":[{"role":"user","content":[{"type":"text","text":"card [CARD_1]"}]}]}`,
+			wantCounts: map[string]int{operation_setting.PIIEntityCard: 1},
+		},
+		{
+			name:      "non prompt fields are kept",
+			redaction: true,
+			entities:  []string{operation_setting.PIIEntityEmail},
+			body:      `{"user":"a@example.com","metadata":{"email":"a@example.com"}}`,
+			wantBody:  `{"user":"a@example.com","metadata":{"email":"a@example.com"}}`,
+		},
+		{
+			name:      "disabled entity is kept",
+			redaction: true,
+			entities:  []string{operation_setting.PIIEntityPhone},
+			body:      `{"prompt":"a@example.com"}`,
+			wantBody:  `{"prompt":"a@example.com"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setting := operation_setting.GetPIISetting()
+			original := setting.Entities
+			t.Cleanup(func() {
+				setting.Entities = original
+			})
+			setting.Entities = tt.entities
+
+			c := newGuardrailTestContext("")
+			body := redactPIIForTest(t, c, newPIITestRelayInfo(tt.redaction, true), tt.body)
+			require.JSONEq(t, tt.wantBody, body)
+			require.Equal(t, tt.wantCounts, getPIIRedactor(c).Counts())
+		})
+	}
+}
+
+func TestRestoreResponsePII(t *testing.T) {
+	tests := []struct {
+		name     string
+		restore  bool
+		retry    bool // 重试到未开启脱敏的渠道
+		response string
+		want     string
+	}{
+		{
+			name:     "restore known placeholders",
+			restore:  true,
+			response: `{"content":"to [EMAIL_1] or [EMAIL_2], not [EMAIL_9]"}`,
+			want:     `{"content":"to a@example.com or c@example.com, not [EMAIL_9]"}`,
+		},
+		{
+			name:     "restore disabled",
+			restore:  false,
+			response: `{"content":"to [EMAIL_1]"}`,
+			want:     `{"content":"to [EMAIL_1]"}`,
+		},
+		{
+			name:     "retry on channel without redaction",
+			restore:  true,
+			retry:    true,
+			response: `{"content":"to [EMAIL_1]"}`,
+			want:     `{"content":"to [EMAIL_1]"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newGuardrailTestContext("")
+			redactPIIForTest(t, c, newPIITestRelayInfo(true, tt.restore), `{"prompt":"a@example.com c@example.com"}`)
+			if tt.retry {
+				redactPIIForTest(t, c, newPIITestRelayInfo(false, false), `{"prompt":"x"}`)
+			}
+			require.Equal(t, tt.want, string(RestoreResponsePII(c, []byte(tt.response))))
+		})
+	}
+
+	t.Run("no redactor", func(t *testing.T) {
+		c := newGuardrailTestContext("")
+		require.Equal(t, `{"content":"[EMAIL_1]"}`, string(RestoreResponsePII(c, []byte(`{"content":"[EMAIL_1]"}`))))
+		require.Nil(t, NewPIIStreamFilter(c))
+	})
+}
+
+func TestPIIStreamFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		deltas    []string
+		want      []string
+		wantFlush string
+	}{
+		{
+			name:   "whole placeholder in one chunk",
+			deltas: []string{"mail [EMAIL_1] now"},
+			want:   []string{"mail a@example.com now"},
+		},
+		{
+			name:   "placeholder split across chunks",
+			deltas: []string{"mail [EMA", "IL_", "1] now"},
+			want:   []string{"mail ", "", "a@example.com now"},
+		},
+		{
+			name:   "placeholder split at the bracket",
+			deltas: []string{"call [", "PHONE_1]"},
+			want:   []string{"call ", "13800138000"},
+		},
+		{
+			name:      "unfinished bracket is flushed at the end",
+			deltas:    []string{"see [NOTE"},
+			want:      []string{"see "},
+			wantFlush: "[NOTE",
+		},
+		{
+			name:   "unknown placeholder is kept",
+			deltas: []string{"[EMAIL_", "7] and [EMAIL_1]"},
+			want:   []string{"", "[EMAIL_7] and a@example.com"},
+		},
+		{
+			name:   "long bracket text is not held back",
+			deltas: []string{"[ABCDEFGHIJKLMNOPQRSTUVWXYZ", "]"},
+			want:   []string{"[ABCDEFGHIJKLMNOPQRSTUVWXYZ", "]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGuardrailRulesForTest(t)
+			c := newGuardrailTestContext("")
+			info := newPIITestRelayInfo(true, true)
+			redactPIIForTest(t, c, info, `{"prompt":"a@example.com 13800138000"}`)
+
+			filter := NewStreamTextFilter(c, info)
+			require.NotNil(t, filter)
+			var got []string
+			for _, delta := range tt.deltas {
+				text, blocked := filter.Process(delta)
+				require.False(t, blocked)
+				got = append(got, text)
+			}
+			require.Equal(t, tt.want, got)
+			flushed, blocked := filter.Flush()
+			require.False(t, blocked)
+			require.Equal(t, tt.wantFlush, flushed)
+		})
+	}
+}
+
+func TestStreamTextFilterWithGuardrail(t *testing.T) {
+	emailRule := operation_setting.GuardrailRule{
+		Name: "email", Type: operation_setting.GuardrailTypeKeyword, Action: operation_setting.GuardrailActionBlock,
+		Keywords: []string{"a@example.com"},
+	}
+	tests := []struct {
+		name        string
+		rules       []operation_setting.GuardrailRule
+		deltas      []string
+		want        []string
+		wantBlocked bool
+	}{
+		{
+			name:        "guardrail sees restored text",
+			rules:       []operation_setting.GuardrailRule{emailRule},
+			deltas:      []string{"mail [EMA", "IL_1] now"},
+			want:        []string{"mail "},
+			wantBlocked: true,
+		},
+		{
+			name:   "redact after restore",
+			rules:  []operation_setting.GuardrailRule{redactPasswordRule},
+			deltas: []string{"[EMAIL_1] pw-1"},
+			want:   []string{"a@example.com ***"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGuardrailRulesForTest(t, tt.rules...)
+			c := newGuardrailTestContext("")
+			info := newPIITestRelayInfo(true, true)
+			redactPIIForTest(t, c, info, `{"prompt":"a@example.com"}`)
+
+			filter := NewStreamTextFilter(c, info)
+			var got []string
+			blocked := false
+			for _, delta := range tt.deltas {
+				var text string
+				text, blocked = filter.Process(delta)
+				if blocked {
+					break
+				}
+				got = append(got, text)
+			}
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantBlocked, filter.Blocked())
+			_, flushBlocked := filter.Flush()
+			require.Equal(t, tt.wantBlocked, flushBlocked)
+		})
+	}
+
+	t.Run("restore raw done event", func(t *testing.T) {
+		setGuardrailRulesForTest(t, redactPasswordRule)
+		c := newGuardrailTestContext("")
+		info := newPIITestRelayInfo(true, true)
+		redactPIIForTest(t, c, info, `{"prompt":"a@example.com"}`)
+		filter := NewStreamTextFilter(c, info)
+		raw := `{"type":"response.output_text.done","text":"[EMAIL_1] pw-9"}`
+		require.JSONEq(t, `{"type":"response.output_text.done","text":"a@example.com ***"}`, filter.RestoreRaw(raw))
+	})
+
+	t.Run("nil filter passes through", func(t *testing.T) {
+		setGuardrailRulesForTest(t)
+		c := newGuardrailTestContext("")
+		filter := NewStreamTextFilter(c, newPIITestRelayInfo(false, false))
+		require.Nil(t, filter)
+		text, blocked := filter.Process("[EMAIL_1]")
+		require.Equal(t, "[EMAIL_1]", text)
+		require.False(t, blocked)
+		require.Equal(t, "raw", filter.RestoreRaw("raw"))
+	})
+}
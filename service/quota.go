@@ -30,13 +30,14 @@ type TokenDetails struct {
 }
 
 type QuotaInfo struct {
-	InputDetails  TokenDetails
-	OutputDetails TokenDetails
-	ModelName     string
-	UsePrice      bool
-	ModelPrice    float64
-	ModelRatio    float64
-	GroupRatio    float64
+	InputDetails    TokenDetails
+	OutputDetails   TokenDetails
+	ModelName       string
+	UsePrice        bool
+	ModelPrice      float64
+	ModelRatio      float64
+	CompletionRatio float64
+	GroupRatio      float64
 }
 
 func hasCustomModelRatio(modelName string, currentRatio float64) bool {
@@ -57,7 +58,7 @@ func calculateAudioQuota(info QuotaInfo) int {
 		return int(quota.IntPart())
 	}
 
-	completionRatio := decimal.NewFromFloat(ratio_setting.GetCompletionRatio(info.ModelName))
+	completionRatio := decimal.NewFromFloat(info.CompletionRatio)
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(info.ModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(info.ModelName))
 
@@ -90,7 +91,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if relayInfo.UsePrice {
 		return nil
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetBillingQuota(relayInfo.UserId, relayInfo.ProjectId)
 	if err != nil {
 		return err
 	}
@@ -130,10 +131,11 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  modelName,
-		UsePrice:   relayInfo.UsePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: actualGroupRatio,
+		ModelName:       modelName,
+		UsePrice:        relayInfo.UsePrice,
+		ModelRatio:      modelRatio,
+		CompletionRatio: ratio_setting.GetCompletionRatio(modelName),
+		GroupRatio:      actualGroupRatio,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -146,6 +148,10 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
 	}
 
+	if err := CheckBudget(relayInfo, quota); err != nil {
+		return err
+	}
+
 	err = PostConsumeQuota(relayInfo, quota, 0, false)
 	if err != nil {
 		return err
@@ -156,7 +162,10 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 
 func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelName string,
 	usage *dto.RealtimeUsage, extraContent string) {
+	_, span := common.StartSpan(ctx, "relay.post_consume")
+	defer span.End()
 
+	RecordUsageTokens(ctx, usage.InputTokens+usage.OutputTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.InputTokenDetails.TextTokens
 	textOutTokens := usage.OutputTokenDetails.TextTokens
@@ -169,6 +178,10 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(relayInfo.OriginModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(modelName))
 
+	ratio_setting.ApplyPriceTier(&relayInfo.PriceData, relayInfo.OriginModelName, usage.InputTokens)
+	if relayInfo.PriceData.Tier != nil {
+		completionRatio = decimal.NewFromFloat(relayInfo.PriceData.CompletionRatio)
+	}
 	modelRatio := relayInfo.PriceData.ModelRatio
 	groupRatio := relayInfo.PriceData.GroupRatioInfo.GroupRatio
 	modelPrice := relayInfo.PriceData.ModelPrice
@@ -183,10 +196,11 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  modelName,
-		UsePrice:   usePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:       modelName,
+		UsePrice:        usePrice,
+		ModelRatio:      modelRatio,
+		CompletionRatio: completionRatio.InexactFloat64(),
+		GroupRatio:      groupRatio,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -236,24 +250,33 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 }
 
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) {
+	_, span := common.StartSpan(ctx, "relay.post_consume")
+	defer span.End()
 
+	RecordUsageTokens(ctx, usage.PromptTokens+usage.CompletionTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
 	modelName := relayInfo.OriginModelName
 
 	tokenName := ctx.GetString("token_name")
+	cacheTokens := usage.PromptTokensDetails.CachedTokens
+	cacheCreationTokens := usage.PromptTokensDetails.CachedCreationTokens
+	// Claude 的 input_tokens 不含缓存部分，分级按完整上下文长度计算
+	contextTokens := promptTokens
+	if relayInfo.ChannelType != constant.ChannelTypeOpenRouter {
+		contextTokens += cacheTokens + cacheCreationTokens
+	}
+	ratio_setting.ApplyPriceTier(&relayInfo.PriceData, modelName, contextTokens)
 	completionRatio := relayInfo.PriceData.CompletionRatio
 	modelRatio := relayInfo.PriceData.ModelRatio
 	groupRatio := relayInfo.PriceData.GroupRatioInfo.GroupRatio
 	modelPrice := relayInfo.PriceData.ModelPrice
 	cacheRatio := relayInfo.PriceData.CacheRatio
-	cacheTokens := usage.PromptTokensDetails.CachedTokens
 
 	cacheCreationRatio := relayInfo.PriceData.CacheCreationRatio
 	cacheCreationRatio5m := relayInfo.PriceData.CacheCreation5mRatio
 	cacheCreationRatio1h := relayInfo.PriceData.CacheCreation1hRatio
-	cacheCreationTokens := usage.PromptTokensDetails.CachedCreationTokens
 	cacheCreationTokens5m := usage.ClaudeCacheCreation5mTokens
 	cacheCreationTokens1h := usage.ClaudeCacheCreation1hTokens
 
@@ -375,7 +398,10 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData types.PriceData)
 }
 
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	_, span := common.StartSpan(ctx, "relay.post_consume")
+	defer span.End()
 
+	RecordUsageTokens(ctx, usage.PromptTokens+usage.CompletionTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
 	textOutTokens := usage.CompletionTokenDetails.TextTokens
@@ -388,6 +414,10 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(relayInfo.OriginModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(relayInfo.OriginModelName))
 
+	ratio_setting.ApplyPriceTier(&relayInfo.PriceData, relayInfo.OriginModelName, usage.PromptTokens)
+	if relayInfo.PriceData.Tier != nil {
+		completionRatio = decimal.NewFromFloat(relayInfo.PriceData.CompletionRatio)
+	}
 	modelRatio := relayInfo.PriceData.ModelRatio
 	groupRatio := relayInfo.PriceData.GroupRatioInfo.GroupRatio
 	modelPrice := relayInfo.PriceData.ModelPrice
@@ -402,10 +432,11 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  relayInfo.OriginModelName,
-		UsePrice:   usePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:       relayInfo.OriginModelName,
+		UsePrice:        usePrice,
+		ModelRatio:      modelRatio,
+		CompletionRatio: completionRatio.InexactFloat64(),
+		GroupRatio:      groupRatio,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -481,32 +512,48 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
-	if relayInfo.IsPlayground {
-		return nil
-	}
-	//if relayInfo.TokenUnlimited {
-	//	return nil
-	//}
-	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
-	if err != nil {
+	// 周期预算同时约束令牌和用户，playground 请求也需要检查用户预算
+	if err := reserveBudget(relayInfo, quota); err != nil {
 		return err
 	}
-	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
-		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
-	}
-	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
-	if err != nil {
-		return err
+	if !relayInfo.IsPlayground && quota > 0 {
+		//if relayInfo.TokenUnlimited {
+		//	return nil
+		//}
+		token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+		if err == nil && !relayInfo.TokenUnlimited && token.RemainQuota < quota {
+			err = fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
+		}
+		if err == nil {
+			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, quotaLedgerRef(relayInfo, quota))
+		}
+		if err != nil {
+			// 令牌扣费失败，退还已计入的预算
+			recordBudgetUsage(relayInfo, -quota)
+			return err
+		}
 	}
 	return nil
 }
 
+// quotaLedgerRef 请求扣费与返还的流水来源，关联请求 ID
+func quotaLedgerRef(relayInfo *relaycommon.RelayInfo, quota int) model.QuotaLedgerRef {
+	if quota < 0 {
+		return model.QuotaLedgerRef{Type: model.QuotaLedgerTypeRefund, ReferenceId: relayInfo.RequestId}
+	}
+	return model.QuotaLedgerRef{Type: model.QuotaLedgerTypeConsume, ReferenceId: relayInfo.RequestId}
+}
+
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
 	if quota > 0 {
-		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
+		var charge types.BillingCharge
+		charge, err = model.DecreaseBillingQuota(relayInfo.UserId, relayInfo.ProjectId, quota, quotaLedgerRef(relayInfo, quota))
+		relayInfo.BillingCharge.Add(charge)
 	} else {
-		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
+		refund := relayInfo.BillingCharge.Refund(-quota)
+		err = model.IncreaseBillingQuota(relayInfo.UserId, relayInfo.ProjectId, refund, quotaLedgerRef(relayInfo, quota))
+		relayInfo.BillingCharge.Sub(refund)
 	}
 	if err != nil {
 		return err
@@ -514,18 +561,23 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 
 	if !relayInfo.IsPlayground {
 		if quota > 0 {
-			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, quotaLedgerRef(relayInfo, quota))
 		} else {
-			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
+			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota, quotaLedgerRef(relayInfo, quota))
 		}
 		if err != nil {
 			return err
 		}
 	}
+	recordBudgetUsage(relayInfo, quota)
 
 	if sendEmail {
 		if (quota + preConsumedQuota) != 0 {
-			checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
+			if relayInfo.OrgId > 0 {
+				checkAndSendOrgQuotaNotify(relayInfo, quota, preConsumedQuota)
+			} else {
+				checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
+			}
 		}
 	}
 
@@ -579,3 +631,34 @@ func checkAndSendQuotaNotify(relayInfo *relaycommon.RelayInfo, quota int, preCon
 		}
 	})
 }
+
+// checkAndSendOrgQuotaNotify 项目令牌的计费额度不足时，通知组织拥有者和管理员
+func checkAndSendOrgQuotaNotify(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int) {
+	gopool.Go(func() {
+		if relayInfo.UserQuota-(quota+preConsumedQuota) >= common.QuotaRemindThreshold {
+			return
+		}
+		org, err := model.GetOrganizationById(relayInfo.OrgId)
+		if err != nil {
+			return
+		}
+		userIds, err := model.GetOrganizationMemberIdsByRole(org.Id, model.OrgRoleAdmin)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to get admins of organization %d: %s", org.Id, err.Error()))
+			return
+		}
+		prompt := fmt.Sprintf("组织 %s 的额度即将用尽", org.Name)
+		content := "{{value}}，项目 #{{value}} 当前剩余额度为 {{value}}，为了不影响组织成员使用，请及时充值或调整额度。"
+		values := []interface{}{prompt, relayInfo.ProjectId, logger.FormatQuota(relayInfo.UserQuota)}
+		for _, userId := range userIds {
+			user, err := model.GetUserCache(userId)
+			if err != nil {
+				continue
+			}
+			err = NotifyUser(user.Id, user.Email, user.GetSetting(), dto.NewNotify(dto.NotifyTypeQuotaExceed, prompt, content, values))
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to send organization quota notify to user %d: %s", user.Id, err.Error()))
+			}
+		}
+	})
+}
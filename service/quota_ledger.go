@@ -0,0 +1,226 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+const (
+	QuotaIssueLedgerMismatch = "ledger_mismatch" // 数据库余额与最后一条流水的变更后余额不一致
+	QuotaIssueCacheDrift     = "cache_drift"     // Redis 缓存额度与数据库不一致
+)
+
+const quotaReconcileInterval = time.Hour
+
+// 组织、项目、成员和订阅的流水只核对数据库，没有 Redis 缓存
+var quotaLedgerColumnSubjects = []string{
+	model.QuotaLedgerSubjectOrg,
+	model.QuotaLedgerSubjectProject,
+	model.QuotaLedgerSubjectOrgMember,
+	model.QuotaLedgerSubjectSubscription,
+}
+
+type QuotaReconcileIssue struct {
+	Kind      string `json:"kind"`
+	Subject   string `json:"subject"`
+	SubjectId int    `json:"subject_id"`
+	Expected  int    `json:"expected"`
+	Actual    int    `json:"actual"`
+	Fixed     bool   `json:"fixed"`
+}
+
+type QuotaReconcileReport struct {
+	Since      int64                 `json:"since"`
+	StartedAt  int64                 `json:"started_at"`
+	FinishedAt int64                 `json:"finished_at"`
+	Checked    int                   `json:"checked"`
+	Issues     []QuotaReconcileIssue `json:"issues"`
+}
+
+var (
+	lastQuotaReconcileReport *QuotaReconcileReport
+	quotaReconcileLock       sync.Mutex
+)
+
+// RunQuotaReconciliation 定期对最近有额度变动的用户、令牌、组织、项目、成员和订阅对账
+func RunQuotaReconciliation() {
+	since := common.GetTimestamp()
+	for {
+		time.Sleep(quotaReconcileInterval)
+		report, err := ReconcileQuota(since)
+		if err != nil {
+			common.SysError("quota reconciliation failed: " + err.Error())
+			continue
+		}
+		// 下一轮从本轮开始时间往前留一分钟，覆盖对账期间写入的流水
+		since = report.StartedAt - 60
+	}
+}
+
+// GetLastQuotaReconcileReport 获取最近一次对账结果，尚未对账时返回 nil
+func GetLastQuotaReconcileReport() *QuotaReconcileReport {
+	quotaReconcileLock.Lock()
+	defer quotaReconcileLock.Unlock()
+	return lastQuotaReconcileReport
+}
+
+// ReconcileQuota 检查 since 之后有流水的对象：数据库中被追踪的字段应等于最后一条流水的变更后余额，用户和令牌的缓存应与数据库一致。
+// 流水不一致说明存在绕过流水的写入，只告警不修正；缓存漂移以数据库为准覆盖缓存
+func ReconcileQuota(since int64) (*QuotaReconcileReport, error) {
+	quotaReconcileLock.Lock()
+	defer quotaReconcileLock.Unlock()
+
+	report := &QuotaReconcileReport{
+		Since:     since,
+		StartedAt: common.GetTimestamp(),
+		Issues:    make([]QuotaReconcileIssue, 0),
+	}
+	userIds, err := model.GetQuotaLedgerSubjectsSince(model.QuotaLedgerSubjectUser, since)
+	if err != nil {
+		return nil, err
+	}
+	for _, userId := range userIds {
+		report.Checked++
+		report.Issues = append(report.Issues, reconcileUserQuota(userId)...)
+	}
+	tokenIds, err := model.GetQuotaLedgerSubjectsSince(model.QuotaLedgerSubjectToken, since)
+	if err != nil {
+		return nil, err
+	}
+	for _, tokenId := range tokenIds {
+		report.Checked++
+		report.Issues = append(report.Issues, reconcileTokenQuota(tokenId)...)
+	}
+	for _, subject := range quotaLedgerColumnSubjects {
+		ids, err := model.GetQuotaLedgerSubjectsSince(subject, since)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			report.Checked++
+			report.Issues = append(report.Issues, reconcileQuotaLedgerColumn(subject, id)...)
+		}
+	}
+	report.FinishedAt = common.GetTimestamp()
+	for _, issue := range report.Issues {
+		common.SysError(fmt.Sprintf("quota reconciliation: %s %s #%d expected %d, actual %d",
+			issue.Kind, issue.Subject, issue.SubjectId, issue.Expected, issue.Actual))
+	}
+	common.SysLog(fmt.Sprintf("quota reconciliation finished: checked %d, issues %d", report.Checked, len(report.Issues)))
+	lastQuotaReconcileReport = report
+	return report, nil
+}
+
+// recheckQuota 余额与流水、缓存分别读取，期间可能有新的扣费写入，不一致时稍后重读一次再判定
+func recheckQuota(check func() (int, int, error)) (expected int, actual int, ok bool) {
+	for i := 0; i < 2; i++ {
+		var err error
+		expected, actual, err = check()
+		if err != nil || expected == actual {
+			return expected, actual, true
+		}
+		if i == 0 {
+			time.Sleep(time.Second)
+		}
+	}
+	return expected, actual, false
+}
+
+func reconcileUserQuota(userId int) (issues []QuotaReconcileIssue) {
+	expected, actual, ok := recheckQuota(func() (int, int, error) {
+		quota, err := model.GetUserQuotaFromDB(userId)
+		if err != nil {
+			return 0, 0, err
+		}
+		ledger, err := model.GetLatestQuotaLedger(model.QuotaLedgerSubjectUser, userId)
+		if err != nil {
+			return 0, 0, err
+		}
+		return ledger.BalanceAfter, quota, nil
+	})
+	if !ok {
+		issues = append(issues, QuotaReconcileIssue{Kind: QuotaIssueLedgerMismatch, Subject: model.QuotaLedgerSubjectUser, SubjectId: userId, Expected: expected, Actual: actual})
+	}
+	// 批量更新模式下缓存先于数据库变动，无法区分漂移与待写入的增减
+	if !common.RedisEnabled || common.BatchUpdateEnabled {
+		return issues
+	}
+	expected, actual, ok = recheckQuota(func() (int, int, error) {
+		quota, err := model.GetUserQuotaFromDB(userId)
+		if err != nil {
+			return 0, 0, err
+		}
+		cached, err := model.GetCachedUserQuota(userId)
+		if err != nil {
+			return 0, 0, err
+		}
+		return quota, cached, nil
+	})
+	if !ok {
+		issue := QuotaReconcileIssue{Kind: QuotaIssueCacheDrift, Subject: model.QuotaLedgerSubjectUser, SubjectId: userId, Expected: expected, Actual: actual}
+		issue.Fixed = model.RefreshUserQuotaCache(userId, expected) == nil
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+func reconcileTokenQuota(tokenId int) (issues []QuotaReconcileIssue) {
+	var key string
+	expected, actual, ok := recheckQuota(func() (int, int, error) {
+		token, err := model.GetTokenQuotaFromDB(tokenId)
+		if err != nil {
+			return 0, 0, err
+		}
+		key = token.Key
+		ledger, err := model.GetLatestQuotaLedger(model.QuotaLedgerSubjectToken, tokenId)
+		if err != nil {
+			return 0, 0, err
+		}
+		return ledger.BalanceAfter, token.RemainQuota, nil
+	})
+	if !ok {
+		issues = append(issues, QuotaReconcileIssue{Kind: QuotaIssueLedgerMismatch, Subject: model.QuotaLedgerSubjectToken, SubjectId: tokenId, Expected: expected, Actual: actual})
+	}
+	if !common.RedisEnabled || common.BatchUpdateEnabled || key == "" {
+		return issues
+	}
+	expected, actual, ok = recheckQuota(func() (int, int, error) {
+		token, err := model.GetTokenQuotaFromDB(tokenId)
+		if err != nil {
+			return 0, 0, err
+		}
+		cached, err := model.GetCachedTokenRemainQuota(key)
+		if err != nil {
+			return 0, 0, err
+		}
+		return token.RemainQuota, cached, nil
+	})
+	if !ok {
+		issue := QuotaReconcileIssue{Kind: QuotaIssueCacheDrift, Subject: model.QuotaLedgerSubjectToken, SubjectId: tokenId, Expected: expected, Actual: actual}
+		issue.Fixed = model.RefreshTokenQuotaCache(key, expected) == nil
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+func reconcileQuotaLedgerColumn(subject string, subjectId int) (issues []QuotaReconcileIssue) {
+	expected, actual, ok := recheckQuota(func() (int, int, error) {
+		value, err := model.GetQuotaLedgerColumnValue(subject, subjectId)
+		if err != nil {
+			return 0, 0, err
+		}
+		ledger, err := model.GetLatestQuotaLedger(subject, subjectId)
+		if err != nil {
+			return 0, 0, err
+		}
+		return ledger.BalanceAfter, value, nil
+	})
+	if !ok {
+		issues = append(issues, QuotaReconcileIssue{Kind: QuotaIssueLedgerMismatch, Subject: subject, SubjectId: subjectId, Expected: expected, Actual: actual})
+	}
+	return issues
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestReconcileQuota(t *testing.T) {
+	tests := []struct {
+		name       string
+		tamper     func(db *gorm.DB) error // 绕过流水直接修改余额
+		wantIssues []QuotaReconcileIssue
+	}{
+		{name: "balances match ledger"},
+		{
+			name: "user balance written without ledger",
+			tamper: func(db *gorm.DB) error {
+				return db.Model(&model.User{}).Where("id = ?", 1).Update("quota", 90).Error
+			},
+			wantIssues: []QuotaReconcileIssue{
+				{Kind: QuotaIssueLedgerMismatch, Subject: model.QuotaLedgerSubjectUser, SubjectId: 1, Expected: 100, Actual: 90},
+			},
+		},
+		{
+			name: "token balance written without ledger",
+			tamper: func(db *gorm.DB) error {
+				return db.Model(&model.Token{}).Where("id = ?", 1).Update("remain_quota", 0).Error
+			},
+			wantIssues: []QuotaReconcileIssue{
+				{Kind: QuotaIssueLedgerMismatch, Subject: model.QuotaLedgerSubjectToken, SubjectId: 1, Expected: 50, Actual: 0},
+			},
+		},
+		{
+			name: "organization and subscription written without ledger",
+			tamper: func(db *gorm.DB) error {
+				if err := db.Model(&model.Organization{}).Where("id = ?", 1).Update("quota", 1).Error; err != nil {
+					return err
+				}
+				return db.Model(&model.Subscription{}).Where("id = ?", 1).Update("used_quota", 7).Error
+			},
+			wantIssues: []QuotaReconcileIssue{
+				{Kind: QuotaIssueLedgerMismatch, Subject: model.QuotaLedgerSubjectOrg, SubjectId: 1, Expected: 1000, Actual: 1},
+				{Kind: QuotaIssueLedgerMismatch, Subject: model.QuotaLedgerSubjectSubscription, SubjectId: 1, Expected: 0, Actual: 7},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, &model.User{}, &model.Token{}, &model.Organization{}, &model.Project{},
+				&model.OrganizationMember{}, &model.Subscription{}, &model.QuotaLedger{})
+			require.NoError(t, db.Create(&model.User{Id: 1, Username: "u1", Quota: 100}).Error)
+			require.NoError(t, db.Create(&model.Token{Id: 1, UserId: 1, Key: "k1", RemainQuota: 50}).Error)
+			require.NoError(t, db.Create(&model.Organization{Id: 1, Quota: 1000}).Error)
+			require.NoError(t, db.Create(&model.Project{Id: 1, OrgId: 1, QuotaMode: model.ProjectQuotaModeProject, Quota: 20}).Error)
+			require.NoError(t, db.Create(&model.OrganizationMember{Id: 1, OrgId: 1, UserId: 1, UsedQuota: 30}).Error)
+			require.NoError(t, db.Create(&model.Subscription{Id: 1, UserId: 1, UsedQuota: 0}).Error)
+			ledgers := []model.QuotaLedger{
+				{Subject: model.QuotaLedgerSubjectUser, SubjectId: 1, BalanceAfter: 100, CreatedAt: 100},
+				{Subject: model.QuotaLedgerSubjectToken, SubjectId: 1, BalanceAfter: 50, CreatedAt: 100},
+				{Subject: model.QuotaLedgerSubjectOrg, SubjectId: 1, BalanceAfter: 1000, CreatedAt: 100},
+				{Subject: model.QuotaLedgerSubjectProject, SubjectId: 1, BalanceAfter: 20, CreatedAt: 100},
+				{Subject: model.QuotaLedgerSubjectOrgMember, SubjectId: 1, BalanceAfter: 30, CreatedAt: 100},
+				{Subject: model.QuotaLedgerSubjectSubscription, SubjectId: 1, BalanceAfter: 0, CreatedAt: 100},
+				// 早于对账起点的流水不参与本轮对账
+				{Subject: model.QuotaLedgerSubjectUser, SubjectId: 2, BalanceAfter: 5, CreatedAt: 1},
+			}
+			require.NoError(t, db.Create(&ledgers).Error)
+			if tt.tamper != nil {
+				require.NoError(t, tt.tamper(db))
+			}
+
+			report, err := ReconcileQuota(50)
+			require.NoError(t, err)
+			require.Equal(t, 6, report.Checked)
+			wantIssues := tt.wantIssues
+			if wantIssues == nil {
+				wantIssues = []QuotaReconcileIssue{}
+			}
+			require.Equal(t, wantIssues, report.Issues)
+			require.Same(t, report, GetLastQuotaReconcileReport())
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// ResponseCacheEntry 缓存的响应，Body 为原样返回给客户端的内容（JSON 或 SSE）
+type ResponseCacheEntry struct {
+	Stream    bool      `json:"stream"`
+	Body      string    `json:"body"`
+	Usage     dto.Usage `json:"usage"`
+	Embedding []float64 `json:"embedding,omitempty"`
+	CreatedAt int64     `json:"created_at"`
+}
+
+// Replayable 判断缓存能否按客户端请求的方式返回，非流式的 chat completions 响应可转换为 SSE 返回
+func (e *ResponseCacheEntry) Replayable(stream bool, relayMode int) bool {
+	if e.Stream == stream {
+		return true
+	}
+	return stream && relayMode == relayconstant.RelayModeChatCompletions
+}
+
+// ResponseCacheEmbedder 计算语义匹配使用的向量，由调用方经渠道适配器请求，不向用户计费
+type ResponseCacheEmbedder func(c *gin.Context, text string) ([]float64, error)
+
+// ResponseCache 单个请求的缓存上下文
+type ResponseCache struct {
+	key       string
+	scope     string
+	text      string
+	embed     ResponseCacheEmbedder
+	embedding []float64
+	writer    *responseCacheWriter
+}
+
+// 以下字段不影响响应内容，计算缓存 key 时忽略；stream 忽略后流式与非流式请求可共用缓存
+// 流式缓存原样回放 SSE，是否包含 usage 块取决于 stream_options，因此流式请求的 key 保留 stream_options
+var responseCacheIgnoredFields = []string{"stream", "stream_options", "user", "metadata", "store"}
+
+// 语义匹配时参与向量计算的字段，其余字段必须完全一致
+var responseCachePromptFields = []string{"messages", "prompt", "input"}
+
+// NewResponseCache 判断请求是否启用响应缓存，启用时返回缓存上下文
+func NewResponseCache(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request, embed ResponseCacheEmbedder) *ResponseCache {
+	setting := operation_setting.GetResponseCacheSetting()
+	if !setting.Enabled || info.IsPlayground {
+		return nil
+	}
+	switch info.RelayMode {
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions, relayconstant.RelayModeResponses:
+	default:
+		return nil
+	}
+	if info.RelayFormat != types.RelayFormatOpenAI && info.RelayFormat != types.RelayFormatOpenAIResponses {
+		return nil
+	}
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenResponseCache) && !operation_setting.IsResponseCacheGroupEnabled(info.UsingGroup) {
+		return nil
+	}
+	if strings.Contains(c.GetHeader("Cache-Control"), "no-store") {
+		return nil
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil
+	}
+	fields := make(map[string]any)
+	if err := common.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+	streamOptions := fields["stream_options"]
+	for _, field := range responseCacheIgnoredFields {
+		delete(fields, field)
+	}
+	if info.IsStream && streamOptions != nil {
+		fields["stream_options"] = streamOptions
+	}
+	fields["model"] = info.OriginModelName
+	if state := GetVirtualModelState(c); state != nil {
+		// 虚拟模型可能由回退链中任一模型响应，按虚拟模型缓存
+		fields["model"] = state.Name
+	}
+	prefix := "shared"
+	if !setting.ShareAcrossUsers {
+		prefix = "user:" + strconv.Itoa(info.UserId)
+	}
+	prefix += "|" + strconv.Itoa(info.RelayMode)
+	normalized, err := common.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	for _, field := range responseCachePromptFields {
+		delete(fields, field)
+	}
+	params, err := common.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	rc := &ResponseCache{
+		key:   responseCacheHash(prefix, normalized),
+		scope: responseCacheHash(prefix, params),
+	}
+	if setting.SemanticEnabled && embed != nil {
+		if meta := request.GetTokenCountMeta(); meta != nil {
+			rc.text = meta.CombineText
+			rc.embed = embed
+		}
+	}
+	return rc
+}
+
+func responseCacheHash(prefix string, data []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prefix))
+	h.Write([]byte{'|'})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Lookup 查找缓存，先精确匹配，未命中且启用语义匹配时按向量相似度查找
+func (rc *ResponseCache) Lookup(c *gin.Context) *ResponseCacheEntry {
+	if strings.Contains(c.GetHeader("Cache-Control"), "no-cache") {
+		return nil
+	}
+	entry, err := getResponseCacheEntry(rc.key)
+	if err != nil {
+		logger.LogError(c, "failed to get response cache: "+err.Error())
+	}
+	if entry != nil || rc.text == "" {
+		return entry
+	}
+	setting := operation_setting.GetResponseCacheSetting()
+	rc.embedding, err = rc.embed(c, rc.text)
+	if err != nil {
+		logger.LogError(c, "failed to get response cache embedding: "+err.Error())
+		return nil
+	}
+	candidates, err := getSemanticResponseCacheEntries(rc.scope, setting.SemanticMaxScan)
+	if err != nil {
+		logger.LogError(c, "failed to get semantic response caches: "+err.Error())
+		return nil
+	}
+	var best *ResponseCacheEntry
+	bestScore := setting.SemanticThreshold
+	for _, candidate := range candidates {
+		if score := cosineSimilarity(rc.embedding, candidate.Embedding); score >= bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	if best != nil {
+		logger.LogInfo(c, fmt.Sprintf("response cache semantic hit, similarity %.4f", bestScore))
+	}
+	return best
+}
+
+// Capture 记录返回给客户端的内容，重试时清空
+func (rc *ResponseCache) Capture(c *gin.Context) {
+	if rc.writer == nil {
+		rc.writer = &responseCacheWriter{ResponseWriter: c.Writer, limit: operation_setting.GetResponseCacheSetting().MaxEntryBytes}
+		c.Writer = rc.writer
+	}
+	rc.writer.body.Reset()
+	rc.writer.overflow = false
+}
+
+// Save 请求成功后写入缓存，未获取到上游用量时不缓存
+func (rc *ResponseCache) Save(c *gin.Context, info *relaycommon.RelayInfo) {
+	if rc.writer == nil || rc.writer.overflow || rc.writer.body.Len() == 0 || rc.writer.Status() != http.StatusOK {
+		return
+	}
+	usage, ok := common.GetContextKeyType[*dto.Usage](c, constant.ContextKeyRelayUsage)
+	if !ok || usage == nil || usage.TotalTokens == 0 {
+		return
+	}
+	entry := &ResponseCacheEntry{
+		Stream:    info.IsStream,
+		Body:      rc.writer.body.String(),
+		Usage:     *usage,
+		Embedding: rc.embedding,
+		CreatedAt: common.GetTimestamp(),
+	}
+	if rc.text != "" && entry.Embedding == nil {
+		// 跳过了查找（Cache-Control: no-cache）或计算向量失败时补充计算
+		embedding, err := rc.embed(c, rc.text)
+		if err != nil {
+			logger.LogError(c, "failed to get response cache embedding: "+err.Error())
+		}
+		entry.Embedding = embedding
+	}
+	if err := setResponseCacheEntry(rc.key, rc.scope, entry); err != nil {
+		logger.LogError(c, "failed to save response cache: "+err.Error())
+	}
+}
+
+type responseCacheWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *responseCacheWriter) capture(n int, write func()) {
+	if w.overflow {
+		return
+	}
+	if w.limit > 0 && w.body.Len()+n > w.limit {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	write()
+}
+
+func (w *responseCacheWriter) Write(data []byte) (int, error) {
+	w.capture(len(data), func() { w.body.Write(data) })
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCacheWriter) WriteString(s string) (int, error) {
+	w.capture(len(s), func() { w.body.WriteString(s) })
+	return w.ResponseWriter.WriteString(s)
+}
+
+// useRedisResponseCache 未启用 Redis 时始终使用数据库存储
+func useRedisResponseCache() bool {
+	return common.RedisEnabled && operation_setting.GetResponseCacheSetting().Storage != operation_setting.ResponseCacheStorageDB
+}
+
+func responseCacheTTL() time.Duration {
+	ttl := operation_setting.GetResponseCacheSetting().TTLSeconds
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+func responseCacheRedisKey(key string) string {
+	return "response_cache:" + key
+}
+
+func responseCacheRedisScopeKey(scope string) string {
+	return "response_cache:semantic:" + scope
+}
+
+func decodeResponseCacheEntry(data string) (*ResponseCacheEntry, error) {
+	var entry ResponseCacheEntry
+	if err := common.UnmarshalJsonStr(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func getResponseCacheEntry(key string) (*ResponseCacheEntry, error) {
+	if useRedisResponseCache() {
+		data, err := common.RedisGet(responseCacheRedisKey(key))
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return decodeResponseCacheEntry(data)
+	}
+	cache, err := model.GetResponseCache(key)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeResponseCacheEntry(cache.Data)
+}
+
+func setResponseCacheEntry(key string, scope string, entry *ResponseCacheEntry) error {
+	data, err := common.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ttl := responseCacheTTL()
+	if useRedisResponseCache() {
+		if err := common.RedisSet(responseCacheRedisKey(key), string(data), ttl); err != nil {
+			return err
+		}
+		if entry.Embedding == nil {
+			return nil
+		}
+		ctx := context.Background()
+		scopeKey := responseCacheRedisScopeKey(scope)
+		pipe := common.RDB.TxPipeline()
+		pipe.LRem(ctx, scopeKey, 0, key)
+		pipe.LPush(ctx, scopeKey, key)
+		pipe.LTrim(ctx, scopeKey, 0, int64(max(operation_setting.GetResponseCacheSetting().SemanticMaxScan, 1)-1))
+		pipe.Expire(ctx, scopeKey, ttl)
+		_, err = pipe.Exec(ctx)
+		return err
+	}
+	// MySQL 的 text 列最大 64KB
+	if common.UsingMySQL && len(data) > 65535 {
+		return nil
+	}
+	now := common.GetTimestamp()
+	return model.SaveResponseCache(&model.ResponseCache{
+		CacheKey:  key,
+		Scope:     scope,
+		Data:      string(data),
+		Semantic:  entry.Embedding != nil,
+		CreatedAt: now,
+		ExpiresAt: now + int64(ttl.Seconds()),
+	})
+}
+
+func getSemanticResponseCacheEntries(scope string, limit int) ([]*ResponseCacheEntry, error) {
+	if limit <= 0 {
+		limit = 200
+	}
+	entries := make([]*ResponseCacheEntry, 0)
+	if useRedisResponseCache() {
+		ctx := context.Background()
+		keys, err := common.RDB.LRange(ctx, responseCacheRedisScopeKey(scope), 0, int64(limit-1)).Result()
+		if err != nil || len(keys) == 0 {
+			return entries, err
+		}
+		for i := range keys {
+			keys[i] = responseCacheRedisKey(keys[i])
+		}
+		values, err := common.RDB.MGet(ctx, keys...).Result()
+		if err != nil {
+			return entries, err
+		}
+		for _, value := range values {
+			data, ok := value.(string)
+			if !ok {
+				continue
+			}
+			if entry, err := decodeResponseCacheEntry(data); err == nil && entry.Embedding != nil {
+				entries = append(entries, entry)
+			}
+		}
+		return entries, nil
+	}
+	caches, err := model.GetSemanticResponseCaches(scope, limit)
+	if err != nil {
+		return entries, err
+	}
+	for _, cache := range caches {
+		if entry, err := decodeResponseCacheEntry(cache.Data); err == nil && entry.Embedding != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// CleanExpiredResponseCaches 定期清理数据库中过期的响应缓存
+func CleanExpiredResponseCaches() {
+	for {
+		time.Sleep(time.Hour)
+		if !operation_setting.GetResponseCacheSetting().Enabled {
+			continue
+		}
+		count, err := model.DeleteExpiredResponseCaches(common.GetTimestamp())
+		if err != nil {
+			common.SysError("failed to clean expired response caches: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired response caches", count))
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// setResponseCacheForTest 启用 default 分组的响应缓存并使用数据库存储，测试结束后恢复
+func setResponseCacheForTest(t *testing.T, modify func(setting *operation_setting.ResponseCacheSetting)) {
+	setting := operation_setting.GetResponseCacheSetting()
+	orig := *setting
+	t.Cleanup(func() { *setting = orig })
+	setting.Enabled = true
+	setting.Groups = []string{"default"}
+	setting.Storage = operation_setting.ResponseCacheStorageDB
+	setting.SemanticEnabled = false
+	if modify != nil {
+		modify(setting)
+	}
+}
+
+func newResponseCacheTestInfo(userId int, stream bool) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		UserId:          userId,
+		IsStream:        stream,
+		RelayMode:       relayconstant.RelayModeChatCompletions,
+		RelayFormat:     types.RelayFormatOpenAI,
+		UsingGroup:      "default",
+		OriginModelName: "gpt-4o",
+	}
+}
+
+func responseCacheKeyForTest(t *testing.T, body string, info *relaycommon.RelayInfo) string {
+	rc := NewResponseCache(newRoutingTestContext(body, nil), info, nil, nil)
+	require.NotNil(t, rc)
+	return rc.key
+}
+
+func TestNewResponseCacheKey(t *testing.T) {
+	const base = `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+	const includeUsage = `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}],"stream":true,"stream_options":{"include_usage":true}}`
+	tests := []struct {
+		name      string
+		share     bool
+		bodyA     string
+		infoA     *relaycommon.RelayInfo
+		bodyB     string
+		infoB     *relaycommon.RelayInfo
+		wantEqual bool
+	}{
+		{
+			name:      "field order and ignored fields",
+			bodyA:     base,
+			infoA:     newResponseCacheTestInfo(1, false),
+			bodyB:     `{"user":"alice","metadata":{"a":"b"},"store":true,"messages":[{"role":"user","content":"hi"}],"temperature":0,"model":"gpt-4o"}`,
+			infoB:     newResponseCacheTestInfo(1, false),
+			wantEqual: true,
+		},
+		{
+			name:      "stream and non-stream share a key",
+			bodyA:     base,
+			infoA:     newResponseCacheTestInfo(1, false),
+			bodyB:     `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}],"stream":true}`,
+			infoB:     newResponseCacheTestInfo(1, true),
+			wantEqual: true,
+		},
+		{
+			name:  "stream options are part of a stream key",
+			bodyA: `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}],"stream":true}`,
+			infoA: newResponseCacheTestInfo(1, true),
+			bodyB: includeUsage,
+			infoB: newResponseCacheTestInfo(1, true),
+		},
+		{
+			name:      "stream options are ignored without stream",
+			bodyA:     base,
+			infoA:     newResponseCacheTestInfo(1, false),
+			bodyB:     `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}],"stream_options":{"include_usage":true}}`,
+			infoB:     newResponseCacheTestInfo(1, false),
+			wantEqual: true,
+		},
+		{
+			name:  "sampling parameters",
+			bodyA: base,
+			infoA: newResponseCacheTestInfo(1, false),
+			bodyB: `{"model":"gpt-4o","temperature":1,"messages":[{"role":"user","content":"hi"}]}`,
+			infoB: newResponseCacheTestInfo(1, false),
+		},
+		{
+			name:  "users are isolated",
+			bodyA: base,
+			infoA: newResponseCacheTestInfo(1, false),
+			bodyB: base,
+			infoB: newResponseCacheTestInfo(2, false),
+		},
+		{
+			name:      "shared across users",
+			share:     true,
+			bodyA:     base,
+			infoA:     newResponseCacheTestInfo(1, false),
+			bodyB:     base,
+			infoB:     newResponseCacheTestInfo(2, false),
+			wantEqual: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setResponseCacheForTest(t, func(setting *operation_setting.ResponseCacheSetting) {
+				setting.ShareAcrossUsers = tt.share
+			})
+			keyA := responseCacheKeyForTest(t, tt.bodyA, tt.infoA)
+			keyB := responseCacheKeyForTest(t, tt.bodyB, tt.infoB)
+			require.Equal(t, tt.wantEqual, keyA == keyB)
+		})
+	}
+}
+
+func TestNewResponseCacheDisabled(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(setting *operation_setting.ResponseCacheSetting)
+		info    func(info *relaycommon.RelayInfo)
+		headers map[string]string
+	}{
+		{name: "disabled", modify: func(setting *operation_setting.ResponseCacheSetting) { setting.Enabled = false }},
+		{name: "group not enabled", info: func(info *relaycommon.RelayInfo) { info.UsingGroup = "vip" }},
+		{name: "playground", info: func(info *relaycommon.RelayInfo) { info.IsPlayground = true }},
+		{name: "embeddings", info: func(info *relaycommon.RelayInfo) { info.RelayMode = relayconstant.RelayModeEmbeddings }},
+		{name: "no-store", headers: map[string]string{"Cache-Control": "no-store"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setResponseCacheForTest(t, tt.modify)
+			info := newResponseCacheTestInfo(1, false)
+			if tt.info != nil {
+				tt.info(info)
+			}
+			c := newRoutingTestContext(`{"model":"gpt-4o","messages":[]}`, tt.headers)
+			require.Nil(t, NewResponseCache(c, info, nil, nil))
+		})
+	}
+}
+
+func TestResponseCacheEntryReplayable(t *testing.T) {
+	tests := []struct {
+		name        string
+		entryStream bool
+		stream      bool
+		relayMode   int
+		want        bool
+	}{
+		{name: "json to json", relayMode: relayconstant.RelayModeChatCompletions, want: true},
+		{name: "sse to sse", entryStream: true, stream: true, relayMode: relayconstant.RelayModeResponses, want: true},
+		{name: "chat json to sse", stream: true, relayMode: relayconstant.RelayModeChatCompletions, want: true},
+		{name: "responses json to sse", stream: true, relayMode: relayconstant.RelayModeResponses},
+		{name: "sse to json", entryStream: true, relayMode: relayconstant.RelayModeChatCompletions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &ResponseCacheEntry{Stream: tt.entryStream}
+			require.Equal(t, tt.want, entry.Replayable(tt.stream, tt.relayMode))
+		})
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []float64
+		b    []float64
+		want float64
+	}{
+		{name: "same direction", a: []float64{1, 2, 3}, b: []float64{2, 4, 6}, want: 1},
+		{name: "orthogonal", a: []float64{1, 0}, b: []float64{0, 1}, want: 0},
+		{name: "opposite", a: []float64{1, 1}, b: []float64{-1, -1}, want: -1},
+		{name: "angle", a: []float64{1, 0}, b: []float64{1, 1}, want: 1 / math.Sqrt2},
+		{name: "length mismatch", a: []float64{1, 0}, b: []float64{1, 0, 0}},
+		{name: "empty", a: nil, b: nil},
+		{name: "zero vector", a: []float64{0, 0}, b: []float64{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.InDelta(t, tt.want, cosineSimilarity(tt.a, tt.b), 1e-9)
+		})
+	}
+}
+
+// saveResponseCacheForTest 模拟一次成功的请求：捕获响应内容、记录用量后写入缓存
+func saveResponseCacheForTest(t *testing.T, rc *ResponseCache, c *gin.Context, info *relaycommon.RelayInfo, body string) {
+	rc.Capture(c)
+	_, err := c.Writer.WriteString(body)
+	require.NoError(t, err)
+	common.SetContextKey(c, constant.ContextKeyRelayUsage, &dto.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15})
+	rc.Save(c, info)
+}
+
+func TestResponseCacheSaveAndLookup(t *testing.T) {
+	setupTestDB(t, &model.ResponseCache{})
+	setResponseCacheForTest(t, nil)
+	const body = `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+	info := newResponseCacheTestInfo(1, false)
+	c := newRoutingTestContext(body, nil)
+	rc := NewResponseCache(c, info, nil, nil)
+	require.Nil(t, rc.Lookup(c))
+	saveResponseCacheForTest(t, rc, c, info, `{"id":"chatcmpl-1"}`)
+
+	c = newRoutingTestContext(body, nil)
+	entry := NewResponseCache(c, info, nil, nil).Lookup(c)
+	require.NotNil(t, entry)
+	require.Equal(t, `{"id":"chatcmpl-1"}`, entry.Body)
+	require.False(t, entry.Stream)
+	require.Equal(t, 15, entry.Usage.TotalTokens)
+
+	c = newRoutingTestContext(body, map[string]string{"Cache-Control": "no-cache"})
+	require.Nil(t, NewResponseCache(c, info, nil, nil).Lookup(c), "no-cache skips the lookup")
+
+	// 超过单条大小限制或未获取到用量时不缓存
+	operation_setting.GetResponseCacheSetting().MaxEntryBytes = 4
+	other := `{"model":"gpt-4o","messages":[{"role":"user","content":"large"}]}`
+	c = newRoutingTestContext(other, nil)
+	rc = NewResponseCache(c, info, nil, nil)
+	saveResponseCacheForTest(t, rc, c, info, `{"id":"chatcmpl-2"}`)
+	c = newRoutingTestContext(other, nil)
+	require.Nil(t, NewResponseCache(c, info, nil, nil).Lookup(c))
+}
+
+func TestResponseCacheSemanticLookup(t *testing.T) {
+	setupTestDB(t, &model.ResponseCache{})
+	setResponseCacheForTest(t, func(setting *operation_setting.ResponseCacheSetting) {
+		setting.SemanticEnabled = true
+		setting.SemanticThreshold = 0.9
+	})
+	embed := func(c *gin.Context, text string) ([]float64, error) {
+		switch {
+		case strings.Contains(text, "weather"):
+			return []float64{1, 0.1}, nil
+		case strings.Contains(text, "forecast"):
+			return []float64{1, 0.2}, nil
+		default:
+			return []float64{0, 1}, nil
+		}
+	}
+	newRequest := func(content string, temperature float64) (string, dto.Request) {
+		request := &dto.GeneralOpenAIRequest{
+			Model:       "gpt-4o",
+			Temperature: &temperature,
+			Messages:    []dto.Message{{Role: "user", Content: content}},
+		}
+		body, err := common.Marshal(request)
+		require.NoError(t, err)
+		return string(body), request
+	}
+	info := newResponseCacheTestInfo(1, false)
+
+	body, request := newRequest("what is the weather today", 0)
+	c := newRoutingTestContext(body, nil)
+	rc := NewResponseCache(c, info, request, embed)
+	require.Nil(t, rc.Lookup(c))
+	saveResponseCacheForTest(t, rc, c, info, `{"id":"weather"}`)
+
+	tests := []struct {
+		name        string
+		content     string
+		temperature float64
+		wantHit     bool
+	}{
+		{name: "similar prompt", content: "today's forecast please", wantHit: true},
+		{name: "unrelated prompt", content: "tell me a joke"},
+		{name: "other parameters must match", content: "today's forecast please", temperature: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, request := newRequest(tt.content, tt.temperature)
+			c := newRoutingTestContext(body, nil)
+			entry := NewResponseCache(c, info, request, embed).Lookup(c)
+			if !tt.wantHit {
+				require.Nil(t, entry)
+				return
+			}
+			require.NotNil(t, entry)
+			require.Equal(t, `{"id":"weather"}`, entry.Body)
+		})
+	}
+}
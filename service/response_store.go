@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetStoredResponseHistory 读取 previous_response_id 对应的对话历史，未保存或已过期时 found 为 false
+func GetStoredResponseHistory(userId int, responseId string) (history []dto.Message, found bool, err error) {
+	if responseId == "" {
+		return nil, false, nil
+	}
+	stored, err := model.GetStoredResponse(responseId, userId, common.GetTimestamp())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if err := common.UnmarshalJsonStr(stored.Messages, &history); err != nil {
+		return nil, false, fmt.Errorf("failed to decode stored response %s: %w", responseId, err)
+	}
+	return history, true, nil
+}
+
+// SaveStoredResponse 保存响应及截至本次响应的完整对话，供后续请求通过 previous_response_id 引用
+func SaveStoredResponse(c *gin.Context, userId int, messages []dto.Message, resp *dto.OpenAIResponsesResponse) {
+	setting := operation_setting.GetResponseStoreSetting()
+	if !setting.Enabled || resp == nil || !resp.Store {
+		return
+	}
+	messagesJson, err := common.Marshal(messages)
+	if err != nil {
+		logger.LogError(c, "failed to marshal stored response messages: "+err.Error())
+		return
+	}
+	respJson, err := common.Marshal(resp)
+	if err != nil {
+		logger.LogError(c, "failed to marshal stored response: "+err.Error())
+		return
+	}
+	now := time.Now()
+	stored := &model.StoredResponse{
+		Id:        resp.ID,
+		UserId:    userId,
+		Model:     resp.Model,
+		Messages:  string(messagesJson),
+		Response:  string(respJson),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(time.Duration(setting.TTLHours) * time.Hour).Unix(),
+	}
+	// 同步写入，客户端收到响应后立即引用 previous_response_id 时也能读到
+	if err := stored.Insert(); err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to save stored response %s: %s", stored.Id, err.Error()))
+	}
+}
+
+// CleanExpiredStoredResponses 定期清理过期的响应
+func CleanExpiredStoredResponses() {
+	for {
+		time.Sleep(time.Hour)
+		count, err := model.DeleteExpiredStoredResponses(common.GetTimestamp())
+		if err != nil {
+			common.SysError("failed to clean expired stored responses: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired stored responses", count))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/service/openaicompat"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponsesViaChatWriter 将渠道按 chat/completions 格式写出的响应翻译为 /v1/responses 格式
+type ResponsesViaChatWriter struct {
+	*chatOutputWriter
+	translator *responsesChatTranslator
+}
+
+func NewResponsesViaChatWriter(c *gin.Context, resp *dto.OpenAIResponsesResponse, stream bool) *ResponsesViaChatWriter {
+	translator := &responsesChatTranslator{
+		converter: openaicompat.NewChatToResponsesStreamConverter(resp),
+	}
+	return &ResponsesViaChatWriter{
+		chatOutputWriter: newChatOutputWriter(c, translator, stream),
+		translator:       translator,
+	}
+}
+
+// Finish 还原 c.Writer 并写出剩余内容，返回最终的响应对象
+func (w *ResponsesViaChatWriter) Finish(usage *dto.Usage, failed bool) *dto.OpenAIResponsesResponse {
+	if !w.finish(usage, failed) {
+		return nil
+	}
+	if w.translator.final != nil {
+		return w.translator.final
+	}
+	return w.translator.converter.Response()
+}
+
+type responsesChatTranslator struct {
+	converter *openaicompat.ChatToResponsesStreamConverter
+	final     *dto.OpenAIResponsesResponse
+}
+
+func (t *responsesChatTranslator) Chunk(chunk *dto.ChatCompletionsStreamResponse) []string {
+	return responsesEventFrames(t.converter.Chunk(chunk))
+}
+
+func (t *responsesChatTranslator) Finish(usage *dto.Usage) []string {
+	return responsesEventFrames(t.converter.Finish(usage))
+}
+
+func (t *responsesChatTranslator) Convert(chatResp *dto.OpenAITextResponse, usage *dto.Usage) ([]byte, error) {
+	resp := openaicompat.ChatCompletionsResponseToResponsesResponse(chatResp, t.converter.Response())
+	if usage != nil {
+		resp.Usage = openaicompat.ChatUsageToResponsesUsage(usage)
+	}
+	data, err := common.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	t.final = resp
+	return data, nil
+}
+
+func responsesEventFrames(events []map[string]any) []string {
+	frames := make([]string, 0, len(events))
+	for _, event := range events {
+		data, err := common.Marshal(event)
+		if err != nil {
+			common.SysError("failed to marshal responses stream event: " + err.Error())
+			continue
+		}
+		frames = append(frames, fmt.Sprintf("event: %s\ndata: %s\n\n", event["type"], data))
+	}
+	return frames
+}
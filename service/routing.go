@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// 不参与路由匹配的敏感请求头
+var routingIgnoredHeaders = []string{"authorization", "cookie", "x-api-key", "x-goog-api-key", "api-key"}
+
+// 计入提示词估算的文本字段
+var routingTextFields = []string{"text", "content", "prompt", "input", "system", "instructions"}
+
+// MatchRoutingRule 按配置顺序返回第一条命中的路由规则，并记录到上下文
+func MatchRoutingRule(c *gin.Context, modelName string, group string) *operation_setting.RoutingRule {
+	setting := operation_setting.GetRoutingSetting()
+	if !setting.Enabled || len(setting.Rules) == 0 {
+		return nil
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil || !gjson.ValidBytes(body) {
+		body = []byte("{}")
+	}
+	conditionContext := buildRoutingContext(c, body, modelName, group)
+	for i := range setting.Rules {
+		rule := setting.Rules[i]
+		matched, err := relaycommon.MatchConditions(body, rule.Conditions, rule.Logic, conditionContext)
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("路由规则 %s 条件判断失败: %s", rule.Name, err.Error()))
+			continue
+		}
+		if matched {
+			logger.LogDebug(c, "routing rule %s matched, group: %s, tag: %s, model: %s", rule.Name, rule.Group, rule.Tag, rule.Model)
+			common.SetContextKey(c, constant.ContextKeyRoutingRule, &rule)
+			return &rule
+		}
+	}
+	return nil
+}
+
+func GetRoutingRule(c *gin.Context) *operation_setting.RoutingRule {
+	if rule, ok := common.GetContextKeyType[*operation_setting.RoutingRule](c, constant.ContextKeyRoutingRule); ok {
+		return rule
+	}
+	return nil
+}
+
+// ApplyRoutingRetryParam 让选择渠道与重试沿用路由规则选定的分组与标签
+func ApplyRoutingRetryParam(c *gin.Context, param *RetryParam) {
+	rule := GetRoutingRule(c)
+	if rule == nil {
+		return
+	}
+	if rule.Group != "" {
+		param.TokenGroup = rule.Group
+	}
+	param.Tag = rule.Tag
+}
+
+// buildRoutingContext 提取可供路由条件匹配的请求特征，条件路径需加 ctx. 前缀引用，如 ctx.prompt_tokens
+func buildRoutingContext(c *gin.Context, body []byte, modelName string, group string) map[string]interface{} {
+	features := &routingFeatures{}
+	var request any
+	if err := common.Unmarshal(body, &request); err == nil {
+		features.walk("", request)
+	}
+
+	stream := gjson.GetBytes(body, "stream").Bool() ||
+		strings.Contains(c.Request.URL.Path, "streamGenerateContent") || c.Query("alt") == "sse"
+	reasoningEffort := gjson.GetBytes(body, "reasoning_effort").String()
+	if reasoningEffort == "" {
+		reasoningEffort = gjson.GetBytes(body, "reasoning.effort").String()
+	}
+	hasTools := len(gjson.GetBytes(body, "tools").Array()) > 0 || len(gjson.GetBytes(body, "functions").Array()) > 0
+
+	headers := make(map[string]interface{}, len(c.Request.Header))
+	for key, values := range c.Request.Header {
+		key = strings.ToLower(key)
+		if len(values) == 0 || common.StringsContains(routingIgnoredHeaders, key) {
+			continue
+		}
+		headers[key] = values[0]
+	}
+
+	now := time.Now()
+	return map[string]interface{}{
+		"model":            modelName,
+		"group":            group,
+		"user_id":          c.GetInt("id"),
+		"token_id":         c.GetInt("token_id"),
+		"token_name":       c.GetString("token_name"),
+		"request_path":     c.Request.URL.Path,
+		"prompt_tokens":    CountTextToken(features.text.String(), modelName),
+		"has_image":        features.hasImage,
+		"has_audio":        features.hasAudio,
+		"has_tools":        hasTools,
+		"stream":           stream,
+		"reasoning_effort": reasoningEffort,
+		"headers":          headers,
+		"hour":             now.Hour(),
+		"weekday":          int(now.Weekday()),
+		"time":             now.Hour()*100 + now.Minute(), // 如 930 表示 09:30
+	}
+}
+
+// routingFeatures 遍历 OpenAI / Claude / Gemini 请求体，收集文本与多模态特征
+type routingFeatures struct {
+	text     strings.Builder
+	hasImage bool
+	hasAudio bool
+}
+
+func (f *routingFeatures) walk(key string, value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		switch v["type"] {
+		case "image_url", "image", "input_image":
+			f.hasImage = true
+		case "input_audio", "audio":
+			f.hasAudio = true
+		}
+		for k, child := range v {
+			switch k {
+			case "image_url":
+				f.hasImage = true
+			case "input_audio":
+				f.hasAudio = true
+			case "inline_data", "inlineData", "file_data", "fileData":
+				f.checkMimeType(child)
+			}
+			f.walk(k, child)
+		}
+	case []any:
+		for _, item := range v {
+			f.walk(key, item)
+		}
+	case string:
+		if common.StringsContains(routingTextFields, key) {
+			f.text.WriteString(v)
+			f.text.WriteString("\n")
+		}
+	}
+}
+
+func (f *routingFeatures) checkMimeType(value any) {
+	data, ok := value.(map[string]any)
+	if !ok {
+		return
+	}
+	mimeType, _ := data["mime_type"].(string)
+	if mimeType == "" {
+		mimeType, _ = data["mimeType"].(string)
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		f.hasImage = true
+	case strings.HasPrefix(mimeType, "audio/"):
+		f.hasAudio = true
+	}
+}
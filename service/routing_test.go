@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// setRoutingRulesForTest 启用路由并替换规则，测试结束后恢复
+func setRoutingRulesForTest(t *testing.T, enabled bool, rules ...operation_setting.RoutingRule) {
+	setting := operation_setting.GetRoutingSetting()
+	orig := *setting
+	t.Cleanup(func() { *setting = orig })
+	setting.Enabled = enabled
+	setting.Rules = rules
+}
+
+func routingCondition(path, mode string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{"path": path, "mode": mode, "value": value}
+}
+
+func newRoutingTestContext(body string, headers map[string]string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		c.Request.Header.Set(key, value)
+	}
+	return c
+}
+
+func TestMatchRoutingRule(t *testing.T) {
+	rules := []operation_setting.RoutingRule{
+		{Name: "long", Conditions: []interface{}{routingCondition("ctx.prompt_tokens", "gt", 50)}, Group: "long-context"},
+		{Name: "vision", Conditions: []interface{}{routingCondition("ctx.has_image", "full", true)}, Tag: "vision"},
+		{Name: "team", Conditions: []interface{}{routingCondition("ctx.headers.x-team", "full", "search")}, Model: "search-model"},
+		{Name: "auth", Conditions: []interface{}{routingCondition("ctx.headers.authorization", "prefix", "Bearer")}, Group: "leak"},
+		{
+			Name:       "cheap stream",
+			Conditions: []interface{}{routingCondition("stream", "full", true), routingCondition("ctx.prompt_tokens", "lt", 10)},
+			Logic:      "AND",
+			Group:      "budget",
+		},
+	}
+	longText := strings.Repeat("hello world ", 100)
+
+	tests := []struct {
+		name     string
+		disabled bool
+		body     string
+		headers  map[string]string
+		want     string
+	}{
+		{name: "no rule matched", body: `{"messages":[{"role":"user","content":"hi there, how are you doing today"}]}`},
+		{name: "long prompt", body: `{"messages":[{"role":"user","content":"` + longText + `"}]}`, want: "long"},
+		{name: "first matching rule wins", body: `{"messages":[{"role":"user","content":[{"type":"text","text":"` + longText + `"},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`, want: "long"},
+		{name: "openai image", body: `{"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`, want: "vision"},
+		{name: "gemini inline image", body: `{"contents":[{"parts":[{"inlineData":{"mimeType":"image/png","data":"AAAA"}}]}]}`, want: "vision"},
+		{name: "header", body: `{"messages":[]}`, headers: map[string]string{"X-Team": "search"}, want: "team"},
+		{name: "sensitive header ignored", body: `{"messages":[]}`, headers: map[string]string{"Authorization": "Bearer sk-test"}},
+		{name: "body and ctx conditions combined", body: `{"stream":true,"messages":[{"role":"user","content":"hi"}]}`, want: "cheap stream"},
+		{
+			name: "body cannot shadow features",
+			body: `{"prompt_tokens":99999,"has_image":true,"headers":{"x-team":"search"},"messages":[{"role":"user","content":"hi there, how are you doing today"}]}`,
+		},
+		{name: "disabled", disabled: true, body: `{"messages":[{"role":"user","content":"` + longText + `"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRoutingRulesForTest(t, !tt.disabled, rules...)
+			c := newRoutingTestContext(tt.body, tt.headers)
+
+			rule := MatchRoutingRule(c, "claude-3-5-sonnet", "default")
+			if tt.want == "" {
+				require.Nil(t, rule)
+				require.Nil(t, GetRoutingRule(c))
+				return
+			}
+			require.NotNil(t, rule)
+			require.Equal(t, tt.want, rule.Name)
+			require.Equal(t, rule, GetRoutingRule(c))
+		})
+	}
+}
+
+func TestApplyRoutingRetryParam(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      operation_setting.RoutingRule
+		wantGroup string
+		wantTag   string
+	}{
+		{name: "group", rule: operation_setting.RoutingRule{Name: "group", Group: "long-context"}, wantGroup: "long-context"},
+		{name: "tag keeps token group", rule: operation_setting.RoutingRule{Name: "tag", Tag: "vision"}, wantGroup: "default", wantTag: "vision"},
+		{name: "model only", rule: operation_setting.RoutingRule{Name: "model", Model: "search-model"}, wantGroup: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRoutingRulesForTest(t, true, tt.rule)
+			c := newRoutingTestContext(`{"messages":[]}`, nil)
+			require.NotNil(t, MatchRoutingRule(c, "claude-3-5-sonnet", "default"))
+
+			param := &RetryParam{Ctx: c, TokenGroup: "default", Tag: "stale"}
+			ApplyRoutingRetryParam(c, param)
+			require.Equal(t, tt.wantGroup, param.TokenGroup)
+			require.Equal(t, tt.wantTag, param.Tag)
+		})
+	}
+
+	t.Run("no rule", func(t *testing.T) {
+		c := newRoutingTestContext(`{"messages":[]}`, nil)
+		param := &RetryParam{Ctx: c, TokenGroup: "default", Tag: "keep"}
+		ApplyRoutingRetryParam(c, param)
+		require.Equal(t, "default", param.TokenGroup)
+		require.Equal(t, "keep", param.Tag)
+	})
+}
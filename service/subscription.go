@@ -0,0 +1,54 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+// Stripe 续费失败时会多次重试扣款，周期结束后保留一段宽限期再判定订阅失效
+const subscriptionStripeGracePeriod = 3 * 24 * time.Hour
+
+// RunSubscriptionMaintenance 定期处理周期已结束的订阅：管理员发放的订阅按月续期或到期失效，Stripe 订阅超过宽限期未续费则失效
+func RunSubscriptionMaintenance() {
+	for {
+		time.Sleep(10 * time.Minute)
+		now := time.Now()
+		subs, err := model.GetDueSubscriptions(now.Unix(), 1000)
+		if err != nil {
+			common.SysError("failed to query due subscriptions: " + err.Error())
+			continue
+		}
+		for _, sub := range subs {
+			if err := advanceSubscription(sub, now); err != nil {
+				common.SysError(fmt.Sprintf("failed to advance subscription #%d: %s", sub.Id, err.Error()))
+			}
+		}
+	}
+}
+
+func advanceSubscription(sub *model.Subscription, now time.Time) error {
+	if sub.StripeSubscriptionId != "" {
+		if now.Unix() < sub.CurrentPeriodEnd+int64(subscriptionStripeGracePeriod/time.Second) {
+			return nil
+		}
+		return model.ExpireSubscription(sub)
+	}
+	if sub.CancelAtPeriodEnd || (sub.ExpiresAt > 0 && now.Unix() >= sub.ExpiresAt) {
+		return model.ExpireSubscription(sub)
+	}
+	start := sub.CurrentPeriodEnd
+	end := time.Unix(start, 0).AddDate(0, 1, 0).Unix()
+	if sub.ExpiresAt > 0 && end > sub.ExpiresAt {
+		end = sub.ExpiresAt
+	}
+	err := model.RenewSubscriptionPeriod(sub, start, end, nil)
+	if errors.Is(err, model.ErrSubscriptionPeriodNotAdvanced) {
+		// 其他节点已经续期
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	taskCallbackEvent       = "task.finished"
+	taskCallbackBatchSize   = 100
+	taskCallbackConcurrency = 8
+)
+
+// TaskCallbackPayload 回调请求体，Task 为任务结束时的信息，与查询接口返回的结构一致
+type TaskCallbackPayload struct {
+	Event     string `json:"event"`
+	TaskType  string `json:"task_type"`
+	TaskId    string `json:"task_id"`
+	Status    string `json:"status"`
+	Task      any    `json:"task"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ValidateTaskCallbackUrl 回调地址只允许 http/https
+func ValidateTaskCallbackUrl(callbackUrl string) error {
+	if callbackUrl == "" {
+		return nil
+	}
+	if len(callbackUrl) > 512 {
+		return fmt.Errorf("callback_url is too long")
+	}
+	u, err := url.ParseRequestURI(callbackUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback_url: %s", callbackUrl)
+	}
+	return nil
+}
+
+// GetTaskCallbackTarget 依次取提交请求中的 callback_url、令牌和用户设置中的回调地址
+func GetTaskCallbackTarget(c *gin.Context, userId int) (model.TaskCallbackTarget, error) {
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	var req struct {
+		CallbackUrl string `json:"callback_url" form:"callback_url"`
+	}
+	if err := common.UnmarshalBodyReusable(c, &req); err == nil && req.CallbackUrl != "" {
+		if err := ValidateTaskCallbackUrl(req.CallbackUrl); err != nil {
+			return model.TaskCallbackTarget{}, err
+		}
+		return model.TaskCallbackTarget{Url: req.CallbackUrl, Source: model.TaskCallbackSourceRequest, TokenId: tokenId}, nil
+	}
+	if callbackUrl := common.GetContextKeyString(c, constant.ContextKeyTokenCallbackUrl); callbackUrl != "" {
+		return model.TaskCallbackTarget{Url: callbackUrl, Source: model.TaskCallbackSourceToken, TokenId: tokenId}, nil
+	}
+	setting, err := model.GetUserSetting(userId, false)
+	if err != nil || setting.TaskCallbackUrl == "" {
+		return model.TaskCallbackTarget{}, nil
+	}
+	return model.TaskCallbackTarget{Url: setting.TaskCallbackUrl, Source: model.TaskCallbackSourceUser}, nil
+}
+
+// NotifyTaskFinished 视频、音乐等异步任务进入终态后登记回调，taskDto 为查询接口返回的任务信息
+func NotifyTaskFinished(task *model.Task, taskDto any) {
+	enqueueTaskCallback(task.UserId, model.TaskCallbackTypeTask, task.TaskID, task.PrivateData.GetCallbackTarget(), string(task.Status), taskDto)
+}
+
+// NotifyMidjourneyFinished Midjourney 任务进入终态后登记回调，taskDto 为查询接口返回的任务信息
+func NotifyMidjourneyFinished(task *model.Midjourney, taskDto any) {
+	enqueueTaskCallback(task.UserId, model.TaskCallbackTypeMidjourney, task.MjId, task.GetCallbackTarget(), task.Status, taskDto)
+}
+
+func enqueueTaskCallback(userId int, taskType string, taskId string, target model.TaskCallbackTarget, status string, task any) {
+	if target.Url == "" {
+		return
+	}
+	if !operation_setting.GetTaskCallbackSetting().Enabled {
+		return
+	}
+	now := common.GetTimestamp()
+	payload, err := common.Marshal(TaskCallbackPayload{
+		Event:     taskCallbackEvent,
+		TaskType:  taskType,
+		TaskId:    taskId,
+		Status:    status,
+		Task:      task,
+		Timestamp: now,
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to marshal task callback payload %s: %s", taskId, err.Error()))
+		return
+	}
+	callback := &model.TaskCallback{
+		UserId:        userId,
+		TaskType:      taskType,
+		TaskId:        taskId,
+		Url:           target.Url,
+		Source:        target.Source,
+		TokenId:       target.TokenId,
+		Payload:       string(payload),
+		Status:        model.TaskCallbackStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := callback.Insert(); err != nil {
+		common.SysError(fmt.Sprintf("failed to save task callback %s: %s", taskId, err.Error()))
+	}
+}
+
+// taskCallbackBackoff 第 n 次失败后的重试间隔，按指数增长并封顶
+func taskCallbackBackoff(attempts int) time.Duration {
+	setting := operation_setting.GetTaskCallbackSetting()
+	backoff := time.Duration(setting.InitialBackoffSeconds) * time.Second
+	maxBackoff := time.Duration(setting.MaxBackoffSeconds) * time.Second
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if maxBackoff > 0 && backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// taskCallbackSecret 按回调地址的来源选择签名密钥：令牌配置的地址只使用令牌密钥，用户设置的地址使用用户密钥
+func taskCallbackSecret(callback *model.TaskCallback) string {
+	if callback.Source == model.TaskCallbackSourceToken || callback.Source == model.TaskCallbackSourceRequest {
+		secret := ""
+		if token, err := model.GetTokenById(callback.TokenId); err == nil {
+			secret = token.GetCallbackSecret()
+		}
+		if secret != "" || callback.Source == model.TaskCallbackSourceToken {
+			return secret
+		}
+	}
+	if userSetting, err := model.GetUserSetting(callback.UserId, false); err == nil {
+		return userSetting.TaskCallbackSecret
+	}
+	return ""
+}
+
+// deliverTaskCallback 投递一次并更新投递记录
+func deliverTaskCallback(callback *model.TaskCallback) {
+	setting := operation_setting.GetTaskCallbackSetting()
+	secret := taskCallbackSecret(callback)
+	timeout := time.Duration(setting.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	statusCode, err := postSignedWebhook(ctx, callback.Url, secret, []byte(callback.Payload), map[string]string{
+		"X-Webhook-Id":    strconv.Itoa(callback.Id),
+		"X-Webhook-Event": taskCallbackEvent,
+	})
+	if err == nil && (statusCode < 200 || statusCode >= 300) {
+		err = fmt.Errorf("callback request failed with status code: %d", statusCode)
+	}
+
+	now := time.Now()
+	callback.Attempts++
+	callback.LastStatusCode = statusCode
+	callback.UpdatedAt = now.Unix()
+	if err == nil {
+		callback.Status = model.TaskCallbackStatusSuccess
+		callback.LastError = ""
+	} else {
+		callback.LastError = err.Error()
+		if callback.Attempts >= setting.MaxAttempts {
+			callback.Status = model.TaskCallbackStatusFailed
+		} else {
+			callback.NextAttemptAt = now.Add(taskCallbackBackoff(callback.Attempts)).Unix()
+		}
+	}
+	if err := callback.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update task callback #%d: %s", callback.Id, err.Error()))
+	}
+}
+
+// RedeliverTaskCallback 管理员手动重新投递
+func RedeliverTaskCallback(id int) error {
+	return model.ResetTaskCallback(id, common.GetTimestamp())
+}
+
+// RunTaskCallbackDeliveries 定期投递到期的回调，并按保留天数清理投递记录
+func RunTaskCallbackDeliveries() {
+	lastCleanup := time.Now()
+	for {
+		time.Sleep(5 * time.Second)
+		callbacks, err := model.GetDueTaskCallbacks(common.GetTimestamp(), taskCallbackBatchSize)
+		if err != nil {
+			common.SysError("failed to get due task callbacks: " + err.Error())
+			continue
+		}
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, taskCallbackConcurrency)
+		for _, callback := range callbacks {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(callback *model.TaskCallback) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				deliverTaskCallback(callback)
+			}(callback)
+		}
+		wg.Wait()
+
+		retentionDays := operation_setting.GetTaskCallbackSetting().RetentionDays
+		if retentionDays > 0 && time.Since(lastCleanup) > time.Hour {
+			lastCleanup = time.Now()
+			count, err := model.DeleteOldTaskCallbacks(time.Now().AddDate(0, 0, -retentionDays).Unix())
+			if err != nil {
+				common.SysError("failed to clean old task callbacks: " + err.Error())
+			} else if count > 0 {
+				common.SysLog(fmt.Sprintf("cleaned %d old task callbacks", count))
+			}
+		}
+	}
+}
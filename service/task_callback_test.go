@@ -0,0 +1,98 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTaskCallbackUrl(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "empty is allowed", url: ""},
+		{name: "https", url: "https://example.com/callback?id=1"},
+		{name: "http with port", url: "http://127.0.0.1:8080/callback"},
+		{name: "unsupported scheme", url: "ftp://example.com/callback", wantErr: true},
+		{name: "missing host", url: "https:///callback", wantErr: true},
+		{name: "relative path", url: "/callback", wantErr: true},
+		{name: "not a url", url: "example.com", wantErr: true},
+		{name: "too long", url: "https://example.com/" + strings.Repeat("a", 512), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTaskCallbackUrl(tt.url)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTaskCallbackBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  int
+		max      int
+		attempts int
+		want     time.Duration
+	}{
+		{name: "first retry", initial: 30, max: 3600, attempts: 1, want: 30 * time.Second},
+		{name: "doubles each attempt", initial: 30, max: 3600, attempts: 3, want: 120 * time.Second},
+		{name: "capped", initial: 30, max: 3600, attempts: 10, want: time.Hour},
+		{name: "many attempts stay capped", initial: 30, max: 3600, attempts: 1000, want: time.Hour},
+		{name: "initial above cap", initial: 600, max: 300, attempts: 1, want: 300 * time.Second},
+		{name: "no cap", initial: 10, max: 0, attempts: 1, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setting := operation_setting.GetTaskCallbackSetting()
+			original := *setting
+			t.Cleanup(func() { *setting = original })
+			setting.InitialBackoffSeconds = tt.initial
+			setting.MaxBackoffSeconds = tt.max
+			require.Equal(t, tt.want, taskCallbackBackoff(tt.attempts))
+		})
+	}
+}
+
+func TestTaskCallbackSecret(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		tokenSecret string
+		tokenId     int
+		want        string
+	}{
+		{name: "token url uses token secret", source: model.TaskCallbackSourceToken, tokenSecret: "token-secret", tokenId: 1, want: "token-secret"},
+		{name: "token url never falls back to user secret", source: model.TaskCallbackSourceToken, tokenId: 1, want: ""},
+		{name: "token url with missing token", source: model.TaskCallbackSourceToken, tokenId: 2, want: ""},
+		{name: "request url prefers token secret", source: model.TaskCallbackSourceRequest, tokenSecret: "token-secret", tokenId: 1, want: "token-secret"},
+		{name: "request url falls back to user secret", source: model.TaskCallbackSourceRequest, tokenId: 1, want: "user-secret"},
+		{name: "user url uses user secret", source: model.TaskCallbackSourceUser, tokenSecret: "token-secret", tokenId: 1, want: "user-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, &model.User{}, &model.Token{})
+			user := &model.User{Id: 1, Username: "u1"}
+			user.SetSetting(dto.UserSetting{TaskCallbackSecret: "user-secret"})
+			require.NoError(t, db.Create(user).Error)
+			token := &model.Token{Id: 1, UserId: 1, Key: "k1", Name: "t1"}
+			require.NoError(t, token.SetCallbackSecret(tt.tokenSecret))
+			require.NoError(t, db.Create(token).Error)
+
+			callback := &model.TaskCallback{UserId: 1, TokenId: tt.tokenId, Source: tt.source}
+			require.Equal(t, tt.want, taskCallbackSecret(callback))
+		})
+	}
+}
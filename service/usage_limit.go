@@ -0,0 +1,252 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const usageLimitWindow = time.Minute
+
+// UsageLimitScope 一个生效的限流维度（令牌、用户或分组）
+type UsageLimitScope struct {
+	Name  string
+	Key   string
+	Limit operation_setting.UsageLimit
+}
+
+// mergeUsageLimit 按字段合并限制，优先使用 primary 中大于 0 的值
+func mergeUsageLimit(primary operation_setting.UsageLimit, fallback operation_setting.UsageLimit) operation_setting.UsageLimit {
+	if primary.RPM <= 0 {
+		primary.RPM = fallback.RPM
+	}
+	if primary.TPM <= 0 {
+		primary.TPM = fallback.TPM
+	}
+	if primary.Concurrency <= 0 {
+		primary.Concurrency = fallback.Concurrency
+	}
+	return primary
+}
+
+// ResolveUsageLimitScopes 计算令牌与用户维度的限流并写入上下文
+// 用户限制优先级：用户单独设置 > 默认限制；分组维度要等 Distribute 确定最终分组后由 ApplyGroupUsageLimit 追加
+func ResolveUsageLimitScopes(c *gin.Context) []UsageLimitScope {
+	scopes := make([]UsageLimitScope, 0, 3)
+	if tokenLimit, ok := common.GetContextKeyType[operation_setting.UsageLimit](c, constant.ContextKeyTokenUsageLimit); ok && !tokenLimit.IsZero() {
+		scopes = append(scopes, UsageLimitScope{
+			Name:  "token",
+			Key:   "token:" + strconv.Itoa(c.GetInt("token_id")),
+			Limit: tokenLimit,
+		})
+	}
+	userLimit, _ := common.GetContextKeyType[operation_setting.UsageLimit](c, constant.ContextKeyUserUsageLimit)
+	userLimit = mergeUsageLimit(userLimit, operation_setting.GetUsageLimitSetting().DefaultUserLimit)
+	if !userLimit.IsZero() {
+		scopes = append(scopes, UsageLimitScope{
+			Name:  "user",
+			Key:   "user:" + strconv.Itoa(c.GetInt("id")),
+			Limit: userLimit,
+		})
+	}
+	common.SetContextKey(c, constant.ContextKeyUsageLimitScopes, scopes)
+	return scopes
+}
+
+// usageLimitGroup 返回实际服务本次请求的分组：auto 分组取选中的分组，路由规则改写后的分组已写入 UsingGroup
+func usageLimitGroup(c *gin.Context) string {
+	if autoGroup := common.GetContextKeyString(c, constant.ContextKeyAutoGroup); autoGroup != "" {
+		return autoGroup
+	}
+	return common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+}
+
+// ApplyGroupUsageLimit 在选出渠道后按最终分组检查并发与 RPM，并把分组维度追加到上下文供 TPM 使用
+// 未经过 UsageRateLimit 的请求不做限制；返回的 release 必须在请求结束后调用
+func ApplyGroupUsageLimit(c *gin.Context) (func(), *types.NewAPIError) {
+	noop := func() {}
+	scopes, ok := common.GetContextKeyType[[]UsageLimitScope](c, constant.ContextKeyUsageLimitScopes)
+	if !ok {
+		return noop, nil
+	}
+	group := usageLimitGroup(c)
+	groupLimit, ok := operation_setting.GetGroupUsageLimit(group)
+	if !ok || groupLimit.IsZero() {
+		return noop, nil
+	}
+	groupScopes := []UsageLimitScope{{
+		Name:  "group " + group,
+		Key:   "group:" + group,
+		Limit: groupLimit,
+	}}
+	release, apiErr := AcquireUsageConcurrency(c, groupScopes)
+	if apiErr != nil {
+		return noop, apiErr
+	}
+	if apiErr = CheckUsageRequestLimit(c, groupScopes); apiErr != nil {
+		release()
+		// 令牌与用户维度已在 UsageRateLimit 中计入，一并退还
+		refundUsageRequests(c, scopes)
+		return noop, apiErr
+	}
+	common.SetContextKey(c, constant.ContextKeyUsageLimitScopes, append(scopes, groupScopes...))
+	return release, nil
+}
+
+func newRateLimitError(message string) *types.NewAPIError {
+	return types.NewErrorWithStatusCode(errors.New(message), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+}
+
+func newRateLimitCheckError(err error) *types.NewAPIError {
+	return types.NewErrorWithStatusCode(fmt.Errorf("rate_limit_check_failed: %w", err), types.ErrorCodeRateLimitExceeded, http.StatusInternalServerError, types.ErrOptionWithSkipRetry())
+}
+
+// setRateLimitHeaders 写入 OpenAI 风格的 x-ratelimit-* 响应头，多个维度时取剩余量最少的一个
+func setRateLimitHeaders(c *gin.Context, kind string, result *limiter.WindowResult) {
+	remainingHeader := "x-ratelimit-remaining-" + kind
+	if current := c.Writer.Header().Get(remainingHeader); current != "" {
+		if remaining, err := strconv.ParseInt(current, 10, 64); err == nil && remaining <= result.Remaining {
+			return
+		}
+	}
+	c.Header("x-ratelimit-limit-"+kind, strconv.FormatInt(result.Limit, 10))
+	c.Header(remainingHeader, strconv.FormatInt(result.Remaining, 10))
+	c.Header("x-ratelimit-reset-"+kind, result.ResetAfter.String())
+}
+
+// CheckUsageRequestLimit 检查每分钟请求数
+func CheckUsageRequestLimit(c *gin.Context, scopes []UsageLimitScope) *types.NewAPIError {
+	for _, scope := range scopes {
+		if scope.Limit.RPM <= 0 {
+			continue
+		}
+		result, err := limiter.ConsumeWindow(c, "usageLimit:rpm:"+scope.Key, int64(scope.Limit.RPM), 1, usageLimitWindow)
+		if err != nil {
+			return newRateLimitCheckError(err)
+		}
+		setRateLimitHeaders(c, "requests", result)
+		if !result.Allowed {
+			// 回滚已经计入的其他维度
+			refundUsageRequests(c, scopes[:indexOfScope(scopes, scope.Key)])
+			return newRateLimitError(fmt.Sprintf("Rate limit reached for %s requests per min (RPM): Limit %d, Used %d. Please try again in %s.",
+				scope.Name, result.Limit, result.Limit-result.Remaining, result.ResetAfter))
+		}
+	}
+	return nil
+}
+
+func refundUsageRequests(c *gin.Context, scopes []UsageLimitScope) {
+	for _, scope := range scopes {
+		if scope.Limit.RPM <= 0 {
+			continue
+		}
+		if err := limiter.AdjustWindow(c, "usageLimit:rpm:"+scope.Key, -1, usageLimitWindow); err != nil {
+			logger.LogError(c, "failed to adjust rpm counter: "+err.Error())
+		}
+	}
+}
+
+// AcquireUsageConcurrency 占用并发名额，返回的 release 必须在请求结束后调用
+func AcquireUsageConcurrency(c *gin.Context, scopes []UsageLimitScope) (func(), *types.NewAPIError) {
+	ttl := time.Duration(operation_setting.GetUsageLimitSetting().ConcurrencyTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	acquired := make([]string, 0, len(scopes))
+	release := func() {
+		for _, key := range acquired {
+			if err := limiter.ReleaseConcurrency(c, key); err != nil {
+				logger.LogError(c, "failed to release concurrency: "+err.Error())
+			}
+		}
+	}
+	for _, scope := range scopes {
+		if scope.Limit.Concurrency <= 0 {
+			continue
+		}
+		key := "usageLimit:concurrency:" + scope.Key
+		ok, current, err := limiter.AcquireConcurrency(c, key, int64(scope.Limit.Concurrency), ttl)
+		if err != nil {
+			release()
+			return nil, newRateLimitCheckError(err)
+		}
+		if !ok {
+			release()
+			return nil, newRateLimitError(fmt.Sprintf("Concurrency limit reached for %s: Limit %d, In flight %d. Please try again later.",
+				scope.Name, scope.Limit.Concurrency, current))
+		}
+		acquired = append(acquired, key)
+	}
+	return release, nil
+}
+
+// CheckUsageTokenLimit 按预估的 token 数检查每分钟 token 数
+func CheckUsageTokenLimit(c *gin.Context, estimatedTokens int) *types.NewAPIError {
+	scopes, ok := common.GetContextKeyType[[]UsageLimitScope](c, constant.ContextKeyUsageLimitScopes)
+	if !ok {
+		return nil
+	}
+	for _, scope := range scopes {
+		if scope.Limit.TPM <= 0 {
+			continue
+		}
+		result, err := limiter.ConsumeWindow(c, "usageLimit:tpm:"+scope.Key, int64(scope.Limit.TPM), int64(estimatedTokens), usageLimitWindow)
+		if err != nil {
+			return newRateLimitCheckError(err)
+		}
+		setRateLimitHeaders(c, "tokens", result)
+		if !result.Allowed {
+			// 回滚已经计入的其他维度
+			settleUsageTokens(c, scopes[:indexOfScope(scopes, scope.Key)], -estimatedTokens)
+			return newRateLimitError(fmt.Sprintf("Rate limit reached for %s tokens per min (TPM): Limit %d, Used %d, Requested %d. Please try again in %s.",
+				scope.Name, result.Limit, result.Limit-result.Remaining, estimatedTokens, result.ResetAfter))
+		}
+	}
+	return nil
+}
+
+func indexOfScope(scopes []UsageLimitScope, key string) int {
+	for i, scope := range scopes {
+		if scope.Key == key {
+			return i
+		}
+	}
+	return len(scopes)
+}
+
+func settleUsageTokens(c *gin.Context, scopes []UsageLimitScope, delta int) {
+	for _, scope := range scopes {
+		if scope.Limit.TPM <= 0 {
+			continue
+		}
+		if err := limiter.AdjustWindow(c, "usageLimit:tpm:"+scope.Key, int64(delta), usageLimitWindow); err != nil {
+			logger.LogError(c, "failed to adjust tpm counter: "+err.Error())
+		}
+	}
+}
+
+// RecordUsageTokens 结算时记录本次请求实际消耗的 token 数，用于修正 TPM 限流的预估值
+func RecordUsageTokens(c *gin.Context, tokens int) {
+	common.SetContextKey(c, constant.ContextKeyConsumedTokens, common.GetContextKeyInt(c, constant.ContextKeyConsumedTokens)+tokens)
+}
+
+// SettleUsageTokenLimit 请求结束后按实际用量修正 TPM 计数，未产生用量时退还预估值
+func SettleUsageTokenLimit(c *gin.Context, estimatedTokens int) {
+	scopes, ok := common.GetContextKeyType[[]UsageLimitScope](c, constant.ContextKeyUsageLimitScopes)
+	if !ok {
+		return
+	}
+	actualTokens := common.GetContextKeyInt(c, constant.ContextKeyConsumedTokens)
+	settleUsageTokens(c, scopes, actualTokens-estimatedTokens)
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveUsageLimitScopes(t *testing.T) {
+	setting := operation_setting.GetUsageLimitSetting()
+	orig := *setting
+	t.Cleanup(func() { *setting = orig })
+	setting.DefaultUserLimit = operation_setting.UsageLimit{RPM: 60}
+	setting.GroupLimits = map[string]operation_setting.UsageLimit{
+		"vip": {TPM: 1000, Concurrency: 5},
+	}
+
+	tests := []struct {
+		name       string
+		tokenLimit *operation_setting.UsageLimit
+		userLimit  operation_setting.UsageLimit
+		group      string
+		want       []UsageLimitScope
+	}{
+		{
+			name:  "default user limit only",
+			group: "default",
+			want: []UsageLimitScope{
+				{Name: "user", Key: "user:7", Limit: operation_setting.UsageLimit{RPM: 60}},
+			},
+		},
+		{
+			name:      "user override takes precedence over default",
+			userLimit: operation_setting.UsageLimit{RPM: 10, TPM: 200},
+			group:     "default",
+			want: []UsageLimitScope{
+				{Name: "user", Key: "user:7", Limit: operation_setting.UsageLimit{RPM: 10, TPM: 200}},
+			},
+		},
+		{
+			name:  "group is left to ApplyGroupUsageLimit",
+			group: "vip",
+			want: []UsageLimitScope{
+				{Name: "user", Key: "user:7", Limit: operation_setting.UsageLimit{RPM: 60}},
+			},
+		},
+		{
+			name:       "token limit comes first",
+			tokenLimit: &operation_setting.UsageLimit{Concurrency: 1},
+			group:      "vip",
+			want: []UsageLimitScope{
+				{Name: "token", Key: "token:3", Limit: operation_setting.UsageLimit{Concurrency: 1}},
+				{Name: "user", Key: "user:7", Limit: operation_setting.UsageLimit{RPM: 60}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Set("id", 7)
+			c.Set("token_id", 3)
+			if tt.tokenLimit != nil {
+				common.SetContextKey(c, constant.ContextKeyTokenUsageLimit, *tt.tokenLimit)
+			}
+			common.SetContextKey(c, constant.ContextKeyUserUsageLimit, tt.userLimit)
+			common.SetContextKey(c, constant.ContextKeyUsingGroup, tt.group)
+			require.Equal(t, tt.want, ResolveUsageLimitScopes(c))
+		})
+	}
+}
+
+func TestApplyGroupUsageLimit(t *testing.T) {
+	setting := operation_setting.GetUsageLimitSetting()
+	orig, origRedis := *setting, common.RedisEnabled
+	t.Cleanup(func() { *setting, common.RedisEnabled = orig, origRedis })
+	common.RedisEnabled = false
+	setting.GroupLimits = map[string]operation_setting.UsageLimit{
+		"vip":          {TPM: 1000, Concurrency: 5},
+		"long-context": {RPM: 10},
+	}
+	userScope := UsageLimitScope{Name: "user", Key: "user:7", Limit: operation_setting.UsageLimit{RPM: 60}}
+
+	tests := []struct {
+		name       string
+		skipScopes bool
+		usingGroup string
+		autoGroup  string
+		want       []UsageLimitScope
+	}{
+		{
+			name:       "token group",
+			usingGroup: "vip",
+			want: []UsageLimitScope{
+				userScope,
+				{Name: "group vip", Key: "group:vip", Limit: operation_setting.UsageLimit{TPM: 1000, Concurrency: 5}},
+			},
+		},
+		{
+			name:       "auto uses the selected group",
+			usingGroup: "auto",
+			autoGroup:  "vip",
+			want: []UsageLimitScope{
+				userScope,
+				{Name: "group vip", Key: "group:vip", Limit: operation_setting.UsageLimit{TPM: 1000, Concurrency: 5}},
+			},
+		},
+		{
+			// 路由规则改写后的分组由 Distribute 写入 UsingGroup
+			name:       "routing rule group",
+			usingGroup: "long-context",
+			want: []UsageLimitScope{
+				userScope,
+				{Name: "group long-context", Key: "group:long-context", Limit: operation_setting.UsageLimit{RPM: 10}},
+			},
+		},
+		{name: "group without limit", usingGroup: "default", want: []UsageLimitScope{userScope}},
+		{name: "usage limit middleware not applied", skipScopes: true, usingGroup: "vip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if !tt.skipScopes {
+				common.SetContextKey(c, constant.ContextKeyUsageLimitScopes, []UsageLimitScope{userScope})
+			}
+			common.SetContextKey(c, constant.ContextKeyUsingGroup, tt.usingGroup)
+			if tt.autoGroup != "" {
+				common.SetContextKey(c, constant.ContextKeyAutoGroup, tt.autoGroup)
+			}
+
+			release, apiErr := ApplyGroupUsageLimit(c)
+			require.Nil(t, apiErr)
+			defer release()
+			scopes, _ := common.GetContextKeyType[[]UsageLimitScope](c, constant.ContextKeyUsageLimitScopes)
+			require.Equal(t, tt.want, scopes)
+		})
+	}
+}
+
+func TestCheckUsageRequestLimitRollback(t *testing.T) {
+	origRedis := common.RedisEnabled
+	t.Cleanup(func() { common.RedisEnabled = origRedis })
+	common.RedisEnabled = false
+
+	scopes := []UsageLimitScope{
+		{Name: "token", Key: "rollback-test:token", Limit: operation_setting.UsageLimit{RPM: 10}},
+		{Name: "user", Key: "rollback-test:user", Limit: operation_setting.UsageLimit{RPM: 10}},
+		{Name: "group", Key: "rollback-test:group", Limit: operation_setting.UsageLimit{RPM: 1}},
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	require.Nil(t, CheckUsageRequestLimit(c, scopes))
+	// 分组维度已满，之后的请求被拒绝，令牌与用户维度不应被计入
+	for i := 0; i < 3; i++ {
+		apiErr := CheckUsageRequestLimit(c, scopes)
+		require.NotNil(t, apiErr)
+		require.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	}
+	for _, scope := range scopes[:2] {
+		result, err := limiter.ConsumeWindow(c, "usageLimit:rpm:"+scope.Key, int64(scope.Limit.RPM), 0, usageLimitWindow)
+		require.NoError(t, err)
+		require.EqualValues(t, 9, result.Remaining, scope.Name)
+	}
+}
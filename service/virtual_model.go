@@ -0,0 +1,189 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VirtualModelState 虚拟模型请求当前所处的回退步骤
+type VirtualModelState struct {
+	operation_setting.VirtualModel
+	Index int
+	Path  []VirtualModelAttempt // 依次尝试过的真实模型
+}
+
+// VirtualModelAttempt 回退链中的一次尝试，Outcome 为空表示由该模型完成请求
+type VirtualModelAttempt struct {
+	Model   string `json:"model"`
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// 回退原因，记录在日志的 fallback_path 中
+const (
+	VirtualModelOutcomeNoChannel     = "no_channel"
+	VirtualModelOutcomeContentFilter = "content_filter"
+	VirtualModelOutcomeGuardrail     = "guardrail"
+	VirtualModelOutcomeError         = "error"
+)
+
+// 上游内容审核拒绝时的特征，命中后回退到下一个模型
+var contentFilterKeywords = []string{
+	"content_filter",
+	"content_policy_violation",
+	"content management policy",
+	"responsibleaipolicyviolation",
+}
+
+func GetVirtualModelState(c *gin.Context) *VirtualModelState {
+	if state, ok := common.GetContextKeyType[*VirtualModelState](c, constant.ContextKeyVirtualModel); ok {
+		return state
+	}
+	return nil
+}
+
+// SelectVirtualModelChannel 按顺序为虚拟模型选择第一个有可用渠道的真实模型，返回渠道、真实模型与分组
+func SelectVirtualModelChannel(c *gin.Context, vm *operation_setting.VirtualModel, retryParam *RetryParam) (*model.Channel, string, string, error) {
+	var (
+		selectGroup string
+		lastErr     error
+		skipped     []VirtualModelAttempt
+	)
+	for i, step := range vm.Steps {
+		retryParam.ModelName = step.Model
+		channel, group, err := CacheGetRandomSatisfiedChannel(retryParam)
+		selectGroup = group
+		if err != nil {
+			lastErr = err
+		}
+		if err != nil || channel == nil {
+			skipped = append(skipped, VirtualModelAttempt{Model: step.Model, Outcome: VirtualModelOutcomeNoChannel})
+			continue
+		}
+		state := &VirtualModelState{VirtualModel: *vm, Path: skipped}
+		state.setStep(c, i)
+		common.SetContextKey(c, constant.ContextKeyVirtualModel, state)
+		return channel, step.Model, selectGroup, nil
+	}
+	return nil, "", selectGroup, lastErr
+}
+
+// NextVirtualModelStep 当前模型失败后切换到回退链中下一个有可用渠道的模型，返回下一个模型名称；
+// 在退还和重新预扣费之前确认渠道可用，没有可用模型时保持当前步骤
+func NextVirtualModelStep(c *gin.Context, err *types.NewAPIError, retryParam *RetryParam) (string, bool) {
+	state := GetVirtualModelState(c)
+	if state == nil || state.Index+1 >= len(state.Steps) || !shouldFallbackVirtualModel(c, err) {
+		return "", false
+	}
+	state.setOutcome(virtualModelOutcome(err))
+	param := &RetryParam{
+		Ctx:        c,
+		TokenGroup: retryParam.TokenGroup,
+		Tag:        retryParam.Tag,
+		Retry:      common.GetPointer(0),
+	}
+	for i := state.Index + 1; i < len(state.Steps); i++ {
+		param.ModelName = state.Steps[i].Model
+		channel, _, selectErr := CacheGetRandomSatisfiedChannel(param)
+		if selectErr == nil && channel != nil {
+			state.setStep(c, i)
+			return state.Steps[i].Model, true
+		}
+		state.Path = append(state.Path, VirtualModelAttempt{Model: state.Steps[i].Model, Outcome: VirtualModelOutcomeNoChannel})
+	}
+	return "", false
+}
+
+func (s *VirtualModelState) setStep(c *gin.Context, index int) {
+	s.Index = index
+	s.Path = append(s.Path, VirtualModelAttempt{Model: s.Steps[index].Model})
+	common.SetContextKey(c, constant.ContextKeyVirtualModelParamOverride, s.Steps[index].ParamOverride)
+}
+
+// setOutcome 记录当前步骤的回退原因，当前步骤总是 Path 的最后一项
+func (s *VirtualModelState) setOutcome(outcome string) {
+	if len(s.Path) > 0 {
+		s.Path[len(s.Path)-1].Outcome = outcome
+	}
+}
+
+func virtualModelOutcome(err *types.NewAPIError) string {
+	switch {
+	case err.GetErrorCode() == types.ErrorCodeGuardrailBlocked:
+		return VirtualModelOutcomeGuardrail
+	case isContentFilterError(err):
+		return VirtualModelOutcomeContentFilter
+	case err.GetErrorCode() == types.ErrorCodeGetChannelFailed:
+		return VirtualModelOutcomeNoChannel
+	}
+	return VirtualModelOutcomeError
+}
+
+// CheckVirtualModelFinishReason 非流式响应写出前调用：上游返回 200 但因内容审核结束，且回退链中还有下一个模型时，
+// 返回错误以触发回退
+func CheckVirtualModelFinishReason(c *gin.Context, finishReasons ...string) *types.NewAPIError {
+	state := GetVirtualModelState(c)
+	if state == nil || state.Index+1 >= len(state.Steps) || c.Writer.Written() {
+		return nil
+	}
+	for _, reason := range finishReasons {
+		if reason == constant.FinishReasonContentFilter || reason == "refusal" {
+			return types.NewErrorWithStatusCode(fmt.Errorf("upstream response stopped by content filter"), types.ErrorCodePromptBlocked, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+	}
+	return nil
+}
+
+// shouldFallbackVirtualModel 渠道耗尽、上游失败、内容审核或输出护栏拒绝时回退，请求本身有误或已开始输出时不回退
+func shouldFallbackVirtualModel(c *gin.Context, err *types.NewAPIError) bool {
+	if err == nil || c.Writer.Written() {
+		return false
+	}
+	if isContentFilterError(err) || err.GetErrorCode() == types.ErrorCodeGuardrailBlocked {
+		return true
+	}
+	switch err.GetErrorCode() {
+	case types.ErrorCodeGetChannelFailed, types.ErrorCodeModelNotFound, types.ErrorCodeChannelNoAvailableKey:
+		return true
+	}
+	if types.IsChannelError(err) {
+		return true
+	}
+	switch err.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return false
+	}
+	return true
+}
+
+func isContentFilterError(err *types.NewAPIError) bool {
+	if err.GetErrorCode() == types.ErrorCodePromptBlocked {
+		return true
+	}
+	openAIError := err.ToOpenAIError()
+	text := strings.ToLower(fmt.Sprintf("%v %s %s", openAIError.Code, openAIError.Type, err.Error()))
+	for _, keyword := range contentFilterKeywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckVirtualModelChatResponse 按 chat completions 响应各选项的 finish_reason 调用 CheckVirtualModelFinishReason
+func CheckVirtualModelChatResponse(c *gin.Context, response *dto.OpenAITextResponse) *types.NewAPIError {
+	finishReasons := make([]string, 0, len(response.Choices))
+	for _, choice := range response.Choices {
+		finishReasons = append(finishReasons, choice.FinishReason)
+	}
+	return CheckVirtualModelFinishReason(c, finishReasons...)
+}
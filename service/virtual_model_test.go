@@ -0,0 +1,207 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// setVirtualModelChannelsForTest 为 default 分组下的模型各创建一个启用的渠道并加载到内存缓存
+func setVirtualModelChannelsForTest(t *testing.T, models ...string) {
+	db := setupTestDB(t, &model.Channel{}, &model.Ability{})
+	for i, name := range models {
+		channel := &model.Channel{Id: i + 1, Name: name, Status: common.ChannelStatusEnabled, Models: name, Group: "default"}
+		require.NoError(t, db.Create(channel).Error)
+		require.NoError(t, db.Create(&model.Ability{Group: "default", Model: name, ChannelId: channel.Id, Enabled: true}).Error)
+	}
+
+	origMemoryCache := common.MemoryCacheEnabled
+	t.Cleanup(func() { common.MemoryCacheEnabled = origMemoryCache })
+	common.MemoryCacheEnabled = true
+	model.InitChannelCache()
+}
+
+// newVirtualModelTestContext 创建处于回退链第一步的请求上下文
+func newVirtualModelTestContext(steps ...string) (*gin.Context, *VirtualModelState) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	vm := operation_setting.VirtualModel{Name: "virtual"}
+	for _, step := range steps {
+		vm.Steps = append(vm.Steps, operation_setting.VirtualModelStep{
+			Model:         step,
+			ParamOverride: map[string]interface{}{"step": step},
+		})
+	}
+	state := &VirtualModelState{VirtualModel: vm}
+	state.setStep(c, 0)
+	common.SetContextKey(c, constant.ContextKeyVirtualModel, state)
+	return c, state
+}
+
+func TestCheckVirtualModelFinishReason(t *testing.T) {
+	tests := []struct {
+		name          string
+		steps         []string
+		noState       bool
+		written       bool
+		finishReasons []string
+		wantErr       bool
+	}{
+		{name: "content filter falls back", steps: []string{"a", "b"}, finishReasons: []string{"stop", "content_filter"}, wantErr: true},
+		{name: "refusal falls back", steps: []string{"a", "b"}, finishReasons: []string{"refusal"}, wantErr: true},
+		{name: "normal stop", steps: []string{"a", "b"}, finishReasons: []string{"stop", "length"}},
+		{name: "last step", steps: []string{"a"}, finishReasons: []string{"content_filter"}},
+		{name: "response already written", steps: []string{"a", "b"}, written: true, finishReasons: []string{"content_filter"}},
+		{name: "not a virtual model", noState: true, finishReasons: []string{"content_filter"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c *gin.Context
+			if tt.noState {
+				c, _ = gin.CreateTestContext(httptest.NewRecorder())
+			} else {
+				c, _ = newVirtualModelTestContext(tt.steps...)
+			}
+			if tt.written {
+				c.Writer.WriteHeaderNow()
+			}
+
+			err := CheckVirtualModelFinishReason(c, tt.finishReasons...)
+			if !tt.wantErr {
+				require.Nil(t, err)
+				return
+			}
+			require.NotNil(t, err)
+			require.Equal(t, types.ErrorCodePromptBlocked, err.GetErrorCode())
+			require.Equal(t, VirtualModelOutcomeContentFilter, virtualModelOutcome(err))
+			require.True(t, shouldFallbackVirtualModel(c, err))
+		})
+	}
+}
+
+func TestNextVirtualModelStep(t *testing.T) {
+	upstreamErr := types.NewOpenAIError(errors.New("upstream failed"), types.ErrorCodeBadResponseStatusCode, http.StatusInternalServerError)
+	contentFilterErr := types.WithOpenAIError(types.OpenAIError{Message: "blocked by upstream", Type: "invalid_request_error", Code: "content_filter"}, http.StatusBadRequest)
+	guardrailErr := types.NewErrorWithStatusCode(errors.New("blocked"), types.ErrorCodeGuardrailBlocked, http.StatusBadRequest)
+	noChannelErr := types.NewError(errors.New("no channel"), types.ErrorCodeGetChannelFailed)
+	badRequestErr := types.NewErrorWithStatusCode(errors.New("bad request"), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+
+	tests := []struct {
+		name      string
+		steps     []string
+		channels  []string
+		err       *types.NewAPIError
+		written   bool
+		wantModel string
+		wantOK    bool
+		wantPath  []VirtualModelAttempt
+	}{
+		{
+			name:      "upstream error",
+			steps:     []string{"a", "b"},
+			channels:  []string{"a", "b"},
+			err:       upstreamErr,
+			wantModel: "b",
+			wantOK:    true,
+			wantPath:  []VirtualModelAttempt{{Model: "a", Outcome: VirtualModelOutcomeError}, {Model: "b"}},
+		},
+		{
+			name:      "content filter",
+			steps:     []string{"a", "b"},
+			channels:  []string{"a", "b"},
+			err:       contentFilterErr,
+			wantModel: "b",
+			wantOK:    true,
+			wantPath:  []VirtualModelAttempt{{Model: "a", Outcome: VirtualModelOutcomeContentFilter}, {Model: "b"}},
+		},
+		{
+			name:      "guardrail",
+			steps:     []string{"a", "b"},
+			channels:  []string{"a", "b"},
+			err:       guardrailErr,
+			wantModel: "b",
+			wantOK:    true,
+			wantPath:  []VirtualModelAttempt{{Model: "a", Outcome: VirtualModelOutcomeGuardrail}, {Model: "b"}},
+		},
+		{
+			name:      "skips step without channel",
+			steps:     []string{"a", "b", "c"},
+			channels:  []string{"a", "c"},
+			err:       noChannelErr,
+			wantModel: "c",
+			wantOK:    true,
+			wantPath: []VirtualModelAttempt{
+				{Model: "a", Outcome: VirtualModelOutcomeNoChannel},
+				{Model: "b", Outcome: VirtualModelOutcomeNoChannel},
+				{Model: "c"},
+			},
+		},
+		{
+			name:     "no remaining channel",
+			steps:    []string{"a", "b"},
+			channels: []string{"a"},
+			err:      upstreamErr,
+			wantPath: []VirtualModelAttempt{{Model: "a", Outcome: VirtualModelOutcomeError}, {Model: "b", Outcome: VirtualModelOutcomeNoChannel}},
+		},
+		{
+			name:     "chain exhausted",
+			steps:    []string{"a"},
+			channels: []string{"a"},
+			err:      upstreamErr,
+			wantPath: []VirtualModelAttempt{{Model: "a"}},
+		},
+		{
+			name:     "bad request does not fall back",
+			steps:    []string{"a", "b"},
+			channels: []string{"a", "b"},
+			err:      badRequestErr,
+			wantPath: []VirtualModelAttempt{{Model: "a"}},
+		},
+		{
+			name:     "response already written",
+			steps:    []string{"a", "b"},
+			channels: []string{"a", "b"},
+			err:      upstreamErr,
+			written:  true,
+			wantPath: []VirtualModelAttempt{{Model: "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVirtualModelChannelsForTest(t, tt.channels...)
+			c, state := newVirtualModelTestContext(tt.steps...)
+			if tt.written {
+				c.Writer.WriteHeaderNow()
+			}
+
+			next, ok := NextVirtualModelStep(c, tt.err, &RetryParam{Ctx: c, TokenGroup: "default"})
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.wantModel, next)
+			require.Equal(t, tt.wantPath, state.Path)
+
+			wantIndex := 0
+			if ok {
+				wantIndex = len(tt.steps) - 1
+			}
+			require.Equal(t, wantIndex, state.Index)
+			override, _ := common.GetContextKey(c, constant.ContextKeyVirtualModelParamOverride)
+			require.Equal(t, map[string]interface{}{"step": tt.steps[wantIndex]}, override)
+		})
+	}
+
+	t.Run("not a virtual model", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		next, ok := NextVirtualModelStep(c, upstreamErr, &RetryParam{Ctx: c, TokenGroup: "default"})
+		require.False(t, ok)
+		require.Empty(t, next)
+	})
+}
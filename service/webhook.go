@@ -2,6 +2,7 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
@@ -54,9 +55,21 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 		return fmt.Errorf("failed to marshal webhook payload: %v", err)
 	}
 
-	// 创建 HTTP 请求
+	statusCode, err := postSignedWebhook(context.Background(), webhookURL, secret, payloadBytes, nil)
+	if err != nil {
+		return err
+	}
+	if statusCode < 200 || statusCode >= 300 {
+		return fmt.Errorf("webhook request failed with status code: %d", statusCode)
+	}
+	return nil
+}
+
+// postSignedWebhook 以 POST 发送 JSON 负载，secret 不为空时附带 HMAC-SHA256 签名，返回对端状态码
+func postSignedWebhook(ctx context.Context, webhookURL string, secret string, payloadBytes []byte, extraHeaders map[string]string) (int, error) {
 	var req *http.Request
 	var resp *http.Response
+	var err error
 
 	if system_setting.EnableWorker() {
 		// 构建worker请求数据
@@ -69,6 +82,9 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 			},
 			Body: payloadBytes,
 		}
+		for key, value := range extraHeaders {
+			workerReq.Headers[key] = value
+		}
 
 		// 如果有secret，添加签名到headers
 		if secret != "" {
@@ -79,48 +95,41 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 
 		resp, err = DoWorkerRequest(workerReq)
 		if err != nil {
-			return fmt.Errorf("failed to send webhook request through worker: %v", err)
+			return 0, fmt.Errorf("failed to send webhook request through worker: %v", err)
 		}
 		defer resp.Body.Close()
+		return resp.StatusCode, nil
+	}
 
-		// 检查响应状态
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
-		}
-	} else {
-		// SSRF防护：验证Webhook URL（非Worker模式）
-		fetchSetting := system_setting.GetFetchSetting()
-		if err := common.ValidateURLWithFetchSetting(webhookURL, fetchSetting.EnableSSRFProtection, fetchSetting.AllowPrivateIp, fetchSetting.DomainFilterMode, fetchSetting.IpFilterMode, fetchSetting.DomainList, fetchSetting.IpList, fetchSetting.AllowedPorts, fetchSetting.ApplyIPFilterForDomain); err != nil {
-			return fmt.Errorf("request reject: %v", err)
-		}
-
-		req, err = http.NewRequest(http.MethodPost, webhookURL, bytes.NewBuffer(payloadBytes))
-		if err != nil {
-			return fmt.Errorf("failed to create webhook request: %v", err)
-		}
-
-		// 设置请求头
-		req.Header.Set("Content-Type", "application/json")
+	// SSRF防护：验证Webhook URL（非Worker模式）
+	fetchSetting := system_setting.GetFetchSetting()
+	if err := common.ValidateURLWithFetchSetting(webhookURL, fetchSetting.EnableSSRFProtection, fetchSetting.AllowPrivateIp, fetchSetting.DomainFilterMode, fetchSetting.IpFilterMode, fetchSetting.DomainList, fetchSetting.IpList, fetchSetting.AllowedPorts, fetchSetting.ApplyIPFilterForDomain); err != nil {
+		return 0, fmt.Errorf("request reject: %v", err)
+	}
 
-		// 如果有 secret，生成签名
-		if secret != "" {
-			signature := generateSignature(secret, payloadBytes)
-			req.Header.Set("X-Webhook-Signature", signature)
-		}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook request: %v", err)
+	}
 
-		// 发送请求
-		client := GetHttpClient()
-		resp, err = client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to send webhook request: %v", err)
-		}
-		defer resp.Body.Close()
+	// 设置请求头
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range extraHeaders {
+		req.Header.Set(key, value)
+	}
 
-		// 检查响应状态
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
-		}
+	// 如果有 secret，生成签名
+	if secret != "" {
+		signature := generateSignature(secret, payloadBytes)
+		req.Header.Set("X-Webhook-Signature", signature)
 	}
 
-	return nil
+	// 发送请求
+	client := GetHttpClient()
+	resp, err = client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send webhook request: %v", err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
 }
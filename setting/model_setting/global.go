@@ -27,10 +27,14 @@ func (p ChatCompletionsToResponsesPolicy) IsChannelEnabled(channelID int) bool {
 	return slices.Contains(p.ChannelIDs, channelID)
 }
 
+// ResponsesToChatCompletionsPolicy 指定原生支持 /v1/responses 的渠道也改为经 chat/completions 转发
+type ResponsesToChatCompletionsPolicy = ChatCompletionsToResponsesPolicy
+
 type GlobalSettings struct {
 	PassThroughRequestEnabled        bool                             `json:"pass_through_request_enabled"`
 	ThinkingModelBlacklist           []string                         `json:"thinking_model_blacklist"`
 	ChatCompletionsToResponsesPolicy ChatCompletionsToResponsesPolicy `json:"chat_completions_to_responses_policy"`
+	ResponsesToChatCompletionsPolicy ResponsesToChatCompletionsPolicy `json:"responses_to_chat_completions_policy"`
 }
 
 // 默认配置
@@ -44,6 +48,10 @@ var defaultOpenaiSettings = GlobalSettings{
 		Enabled:     false,
 		AllChannels: true,
 	},
+	ResponsesToChatCompletionsPolicy: ResponsesToChatCompletionsPolicy{
+		Enabled:     false,
+		AllChannels: true,
+	},
 }
 
 // 全局实例
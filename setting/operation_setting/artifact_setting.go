@@ -0,0 +1,32 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ArtifactSetting 生成结果持久化存储配置，S3 连接信息通过环境变量配置
+type ArtifactSetting struct {
+	Enabled             bool   `json:"enabled"`                // 任务成功后是否将图片、视频转存到本地或 S3
+	StorageDir          string `json:"storage_dir"`            // 本地存储目录，配置了 S3 时不使用
+	MaxFileSizeMB       int    `json:"max_file_size_mb"`       // 单个文件最大大小，超过则不转存
+	UserQuotaMB         int    `json:"user_quota_mb"`          // 每个用户的存储配额，0 表示不限制
+	RetentionDays       int    `json:"retention_days"`         // 转存文件保留天数，0 表示永久保留
+	SignedUrlTTLSeconds int    `json:"signed_url_ttl_seconds"` // 签名下载地址有效期
+}
+
+// 默认配置
+var artifactSetting = ArtifactSetting{
+	Enabled:             false,
+	StorageDir:          "./data/artifacts",
+	MaxFileSizeMB:       512,
+	UserQuotaMB:         2048,
+	RetentionDays:       30,
+	SignedUrlTTLSeconds: 3600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("artifact_setting", &artifactSetting)
+}
+
+func GetArtifactSetting() *ArtifactSetting {
+	return &artifactSetting
+}
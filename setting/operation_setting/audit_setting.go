@@ -0,0 +1,22 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// AuditSetting 审计日志配置
+type AuditSetting struct {
+	RetentionDays int `json:"retention_days"` // 审计日志保留天数，0 表示永久保留
+}
+
+// 默认配置
+var auditSetting = AuditSetting{
+	RetentionDays: 400,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("audit_setting", &auditSetting)
+}
+
+func GetAuditSetting() *AuditSetting {
+	return &auditSetting
+}
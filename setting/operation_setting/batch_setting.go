@@ -0,0 +1,42 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// BatchSetting Files / Batch API 配置
+type BatchSetting struct {
+	Enabled           bool    `json:"enabled"`             // 是否启用 /v1/files 与 /v1/batches
+	DiscountRatio     float64 `json:"discount_ratio"`      // 批处理计费折扣倍率，1 表示不打折
+	StorageDir        string  `json:"storage_dir"`         // 本地文件存储目录
+	MaxFileSizeMB     int     `json:"max_file_size_mb"`    // 单个上传文件最大大小
+	MaxLinesPerBatch  int     `json:"max_lines_per_batch"` // 单个批处理最大请求数
+	Concurrency       int     `json:"concurrency"`         // 单个批处理并发执行的请求数
+	FileRetentionDays int     `json:"file_retention_days"` // 文件保留天数，0 表示永久保留
+}
+
+// 默认配置
+var batchSetting = BatchSetting{
+	Enabled:           false,
+	DiscountRatio:     0.5,
+	StorageDir:        "./data/files",
+	MaxFileSizeMB:     100,
+	MaxLinesPerBatch:  50000,
+	Concurrency:       4,
+	FileRetentionDays: 30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch_setting", &batchSetting)
+}
+
+func GetBatchSetting() *BatchSetting {
+	return &batchSetting
+}
+
+// GetBatchDiscountRatio 返回批处理计费折扣倍率，非法值按 1 处理
+func GetBatchDiscountRatio() float64 {
+	if batchSetting.DiscountRatio <= 0 {
+		return 1
+	}
+	return batchSetting.DiscountRatio
+}
@@ -0,0 +1,51 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// 预算周期
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodWeekly  = "weekly" // 每周一零点重置
+	BudgetPeriodMonthly = "monthly"
+)
+
+// Budget 令牌或用户的周期预算
+type Budget struct {
+	Period     string `json:"period"`      // 预算周期，为空表示不限制
+	Quota      int    `json:"quota"`       // 每个周期的额度上限
+	MonthlyCap int    `json:"monthly_cap"` // 每个自然月的额度硬上限，0 表示不限制
+	Timezone   string `json:"timezone"`    // 周期重置使用的时区，为空时使用全局配置
+}
+
+func (b Budget) IsZero() bool {
+	return (b.Period == "" || b.Quota <= 0) && b.MonthlyCap <= 0
+}
+
+func IsValidBudgetPeriod(period string) bool {
+	switch period {
+	case "", BudgetPeriodDaily, BudgetPeriodWeekly, BudgetPeriodMonthly:
+		return true
+	}
+	return false
+}
+
+// BudgetSetting 周期预算配置
+type BudgetSetting struct {
+	Timezone      string `json:"timezone"`       // 默认时区，如 Asia/Shanghai，Local 表示服务器时区
+	AlertPercents []int  `json:"alert_percents"` // 周期用量达到预算的百分比时发送提醒
+}
+
+// 默认配置
+var budgetSetting = BudgetSetting{
+	Timezone:      "Local",
+	AlertPercents: []int{80, 100},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("budget_setting", &budgetSetting)
+}
+
+func GetBudgetSetting() *BudgetSetting {
+	return &budgetSetting
+}
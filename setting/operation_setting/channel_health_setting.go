@@ -0,0 +1,41 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+const (
+	ChannelSelectModeWeighted      = "weighted"
+	ChannelSelectModeLowestLatency = "lowest_latency"
+)
+
+// ChannelHealthSetting 渠道熔断与健康度选择配置
+type ChannelHealthSetting struct {
+	Enabled            bool    `json:"enabled"`
+	SelectMode         string  `json:"select_mode"`          // weighted: 按健康度加权随机；lowest_latency: 选择延迟最低的渠道
+	WindowSeconds      int     `json:"window_seconds"`       // 错误率统计的滑动窗口
+	MinRequests        int     `json:"min_requests"`         // 窗口内请求数达到该值后才会触发熔断
+	ErrorRateThreshold float64 `json:"error_rate_threshold"` // 错误率达到该值时熔断
+	OpenSeconds        int     `json:"open_seconds"`         // 熔断持续时间，之后进入半开状态
+	HalfOpenRequests   int     `json:"half_open_requests"`   // 半开状态下允许同时进行的探测请求数
+	EWMAAlpha          float64 `json:"ewma_alpha"`           // 延迟 EWMA 平滑系数
+}
+
+// 默认配置
+var channelHealthSetting = ChannelHealthSetting{
+	Enabled:            false,
+	SelectMode:         ChannelSelectModeWeighted,
+	WindowSeconds:      60,
+	MinRequests:        20,
+	ErrorRateThreshold: 0.5,
+	OpenSeconds:        30,
+	HalfOpenRequests:   1,
+	EWMAAlpha:          0.3,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_health_setting", &channelHealthSetting)
+}
+
+func GetChannelHealthSetting() *ChannelHealthSetting {
+	return &channelHealthSetting
+}
@@ -0,0 +1,139 @@
+package operation_setting
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	GuardrailTypeKeyword       = "keyword"
+	GuardrailTypeRegex         = "regex"
+	GuardrailTypeModeration    = "moderation"
+	GuardrailTypeMaxPromptSize = "max_prompt_size"
+)
+
+const (
+	GuardrailActionBlock  = "block"
+	GuardrailActionRedact = "redact"
+	GuardrailActionFlag   = "flag"
+)
+
+const (
+	GuardrailStagePrompt = "prompt"
+	GuardrailStageOutput = "output"
+	GuardrailStageBoth   = "both"
+)
+
+// GuardrailRule 单条护栏规则，按配置顺序依次执行
+type GuardrailRule struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`            // keyword / regex / moderation / max_prompt_size
+	Action        string   `json:"action"`          // block / redact / flag
+	Stage         string   `json:"stage"`           // prompt / output / both，为空时为 both
+	Groups        []string `json:"groups"`          // 生效的分组，与令牌均为空时对所有请求生效
+	TokenIds      []int    `json:"token_ids"`       // 生效的令牌
+	Keywords      []string `json:"keywords"`        // keyword 规则的关键词，不区分大小写
+	Patterns      []string `json:"patterns"`        // regex 规则的正则
+	Categories    []string `json:"categories"`      // moderation 规则只拦截这些类别，为空时任意类别命中即生效
+	MaxPromptSize int      `json:"max_prompt_size"` // max_prompt_size 规则允许的最大字符数，只作用于提示词
+	Message       string   `json:"message"`         // 拦截时返回给用户的提示
+}
+
+// GuardrailSetting 提示词与输出内容护栏配置
+type GuardrailSetting struct {
+	Enabled             bool            `json:"enabled"`
+	Rules               []GuardrailRule `json:"rules"`
+	ModerationChannelId int             `json:"moderation_channel_id"` // 审核使用的渠道，需兼容 OpenAI moderations 接口
+	ModerationModel     string          `json:"moderation_model"`
+	ModerationInterval  int             `json:"moderation_interval"` // 流式输出每新增多少字符在后台调用一次审核
+	RedactReplacement   string          `json:"redact_replacement"`
+}
+
+// 默认配置
+var guardrailSetting = GuardrailSetting{
+	Enabled:            false,
+	Rules:              []GuardrailRule{},
+	ModerationModel:    "omni-moderation-latest",
+	ModerationInterval: 500,
+	RedactReplacement:  "**###**",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("guardrail_setting", &guardrailSetting)
+}
+
+func GetGuardrailSetting() *GuardrailSetting {
+	return &guardrailSetting
+}
+
+// Matches 判断规则是否对当前分组和令牌生效
+func (r *GuardrailRule) Matches(group string, tokenId int) bool {
+	if len(r.Groups) == 0 && len(r.TokenIds) == 0 {
+		return true
+	}
+	return slices.Contains(r.Groups, group) || slices.Contains(r.TokenIds, tokenId)
+}
+
+// AppliesTo 判断规则是否作用于指定阶段
+func (r *GuardrailRule) AppliesTo(stage string) bool {
+	return r.Stage == "" || r.Stage == GuardrailStageBoth || r.Stage == stage
+}
+
+// ValidateGuardrailRules 校验规则 JSON
+func ValidateGuardrailRules(jsonStr string) error {
+	var rules []GuardrailRule
+	if err := common.UnmarshalJsonStr(jsonStr, &rules); err != nil {
+		return fmt.Errorf("护栏规则格式错误: %w", err)
+	}
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		switch rule.Action {
+		case GuardrailActionBlock, GuardrailActionRedact, GuardrailActionFlag:
+		default:
+			return fmt.Errorf("护栏规则 %s 的动作无效: %s", name, rule.Action)
+		}
+		switch rule.Stage {
+		case "", GuardrailStagePrompt, GuardrailStageOutput, GuardrailStageBoth:
+		default:
+			return fmt.Errorf("护栏规则 %s 的阶段无效: %s", name, rule.Stage)
+		}
+		switch rule.Type {
+		case GuardrailTypeKeyword:
+			if len(rule.Keywords) == 0 {
+				return fmt.Errorf("护栏规则 %s 未配置关键词", name)
+			}
+		case GuardrailTypeRegex:
+			if len(rule.Patterns) == 0 {
+				return fmt.Errorf("护栏规则 %s 未配置正则", name)
+			}
+			for _, pattern := range rule.Patterns {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("护栏规则 %s 的正则无效: %w", name, err)
+				}
+			}
+		case GuardrailTypeModeration:
+			if rule.Action == GuardrailActionRedact {
+				return fmt.Errorf("护栏规则 %s: 审核规则不支持脱敏", name)
+			}
+		case GuardrailTypeMaxPromptSize:
+			if rule.MaxPromptSize <= 0 {
+				return fmt.Errorf("护栏规则 %s 的最大长度必须大于 0", name)
+			}
+			if rule.Action == GuardrailActionRedact {
+				return fmt.Errorf("护栏规则 %s: 长度规则不支持脱敏", name)
+			}
+		default:
+			return errors.New("护栏规则类型无效: " + rule.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package operation_setting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateGuardrailRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr bool
+	}{
+		{name: "empty", rules: `[]`},
+		{name: "valid rules", rules: `[
+			{"type":"keyword","action":"block","keywords":["a"]},
+			{"type":"regex","action":"redact","stage":"output","patterns":["\\d+"]},
+			{"type":"moderation","action":"flag","stage":"both"},
+			{"type":"max_prompt_size","action":"block","max_prompt_size":100}
+		]`},
+		{name: "invalid json", rules: `{`, wantErr: true},
+		{name: "unknown action", rules: `[{"type":"keyword","action":"drop","keywords":["a"]}]`, wantErr: true},
+		{name: "unknown stage", rules: `[{"type":"keyword","action":"block","stage":"input","keywords":["a"]}]`, wantErr: true},
+		{name: "unknown type", rules: `[{"type":"llm","action":"block"}]`, wantErr: true},
+		{name: "keyword without keywords", rules: `[{"type":"keyword","action":"block"}]`, wantErr: true},
+		{name: "invalid regex", rules: `[{"type":"regex","action":"block","patterns":["("]}]`, wantErr: true},
+		{name: "moderation cannot redact", rules: `[{"type":"moderation","action":"redact"}]`, wantErr: true},
+		{name: "size must be positive", rules: `[{"type":"max_prompt_size","action":"block"}]`, wantErr: true},
+		{name: "size cannot redact", rules: `[{"type":"max_prompt_size","action":"redact","max_prompt_size":1}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateGuardrailRules(tt.rules)
+			require.Equal(t, tt.wantErr, err != nil, "err: %v", err)
+		})
+	}
+}
+
+func TestGuardrailRuleScope(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        GuardrailRule
+		group       string
+		tokenId     int
+		stage       string
+		wantMatch   bool
+		wantApplies bool
+	}{
+		{name: "global rule", rule: GuardrailRule{}, group: "default", tokenId: 1, stage: GuardrailStagePrompt, wantMatch: true, wantApplies: true},
+		{name: "group rule", rule: GuardrailRule{Groups: []string{"vip"}}, group: "default", tokenId: 1, stage: GuardrailStagePrompt, wantApplies: true},
+		{name: "token rule", rule: GuardrailRule{Groups: []string{"vip"}, TokenIds: []int{1}}, group: "default", tokenId: 1, stage: GuardrailStagePrompt, wantMatch: true, wantApplies: true},
+		{name: "output rule on prompt", rule: GuardrailRule{Stage: GuardrailStageOutput}, group: "default", stage: GuardrailStagePrompt, wantMatch: true},
+		{name: "both stages", rule: GuardrailRule{Stage: GuardrailStageBoth}, group: "default", stage: GuardrailStageOutput, wantMatch: true, wantApplies: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.wantMatch, tt.rule.Matches(tt.group, tt.tokenId))
+			require.Equal(t, tt.wantApplies, tt.rule.AppliesTo(tt.stage))
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// InvoiceSetting 发票配置，开票时会将公司信息与税率快照到发票中，修改配置不影响已开具的发票
+type InvoiceSetting struct {
+	Enabled          bool    `json:"enabled"`            // 充值和订阅付款成功后是否自动开具发票
+	EmailEnabled     bool    `json:"email_enabled"`      // 开具后是否通过邮件发送给用户
+	NumberPrefix     string  `json:"number_prefix"`      // 发票编号前缀
+	CreditNotePrefix string  `json:"credit_note_prefix"` // 红字发票（退款）编号前缀
+	CompanyName      string  `json:"company_name"`
+	CompanyAddress   string  `json:"company_address"`
+	CompanyTaxId     string  `json:"company_tax_id"`
+	CompanyEmail     string  `json:"company_email"`
+	Currency         string  `json:"currency"` // 付款未记录币种时（如历史订单）使用的发票币种，其余按各笔付款的实际币种开具
+	TaxRate          float64 `json:"tax_rate"` // 税率百分比，支付金额视为含税价
+	Footer           string  `json:"footer"`   // 发票底部备注
+}
+
+// 默认配置
+var invoiceSetting = InvoiceSetting{
+	Enabled:          false,
+	EmailEnabled:     false,
+	NumberPrefix:     "INV-",
+	CreditNotePrefix: "CN-",
+	Currency:         "USD",
+	TaxRate:          0,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("invoice_setting", &invoiceSetting)
+}
+
+func GetInvoiceSetting() *InvoiceSetting {
+	return &invoiceSetting
+}
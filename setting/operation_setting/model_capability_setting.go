@@ -0,0 +1,25 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ModelCapabilitySetting 按模型元数据中的能力信息限制请求
+type ModelCapabilitySetting struct {
+	Enabled bool `json:"enabled"`
+	// 请求未指定 max_tokens 时按模型最大输出补全，会提高预扣额度
+	FillDefaultMaxTokens bool `json:"fill_default_max_tokens"`
+}
+
+// 默认配置
+var modelCapabilitySetting = ModelCapabilitySetting{
+	Enabled:              true,
+	FillDefaultMaxTokens: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_capability_setting", &modelCapabilitySetting)
+}
+
+func GetModelCapabilitySetting() *ModelCapabilitySetting {
+	return &modelCapabilitySetting
+}
@@ -0,0 +1,50 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// PayloadCaptureSetting 请求/响应内容采集配置，仅对列出的用户、令牌或渠道生效
+type PayloadCaptureSetting struct {
+	Enabled        bool     `json:"enabled"`
+	UserIds        []int    `json:"user_ids"`
+	TokenIds       []int    `json:"token_ids"`
+	ChannelIds     []int    `json:"channel_ids"`
+	MaxBytes       int      `json:"max_bytes"`       // 每段内容最多保存的字节数，超出部分截断
+	TTLHours       int      `json:"ttl_hours"`       // 保存时长，过期后自动清理
+	RedactPII      bool     `json:"redact_pii"`      // 脱敏邮箱、手机号、银行卡号、API Key 等
+	RedactPatterns []string `json:"redact_patterns"` // 额外的脱敏正则
+}
+
+// 默认配置
+var payloadCaptureSetting = PayloadCaptureSetting{
+	Enabled:        false,
+	UserIds:        []int{},
+	TokenIds:       []int{},
+	ChannelIds:     []int{},
+	MaxBytes:       32768,
+	TTLHours:       72,
+	RedactPII:      true,
+	RedactPatterns: []string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("payload_capture_setting", &payloadCaptureSetting)
+}
+
+func GetPayloadCaptureSetting() *PayloadCaptureSetting {
+	return &payloadCaptureSetting
+}
+
+// ShouldCapturePayload 用户、令牌或渠道任一开启采集即采集
+func ShouldCapturePayload(userId int, tokenId int, channelId int) bool {
+	if !payloadCaptureSetting.Enabled {
+		return false
+	}
+	return slices.Contains(payloadCaptureSetting.UserIds, userId) ||
+		slices.Contains(payloadCaptureSetting.TokenIds, tokenId) ||
+		(channelId != 0 && slices.Contains(payloadCaptureSetting.ChannelIds, channelId))
+}
@@ -0,0 +1,31 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	PIIEntityEmail      = "email"
+	PIIEntityPhone      = "phone"
+	PIIEntityCard       = "card"
+	PIIEntityNationalId = "national_id"
+)
+
+// PIISetting 转发上游前的 PII 脱敏配置，是否启用由渠道设置决定
+type PIISetting struct {
+	Entities []string `json:"entities"` // 需要脱敏的实体类型
+}
+
+// 默认配置
+var piiSetting = PIISetting{
+	Entities: []string{PIIEntityEmail, PIIEntityPhone, PIIEntityCard, PIIEntityNationalId},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("pii_setting", &piiSetting)
+}
+
+func GetPIISetting() *PIISetting {
+	return &piiSetting
+}
@@ -0,0 +1,56 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	ResponseCacheStorageAuto  = "auto"
+	ResponseCacheStorageRedis = "redis"
+	ResponseCacheStorageDB    = "db"
+)
+
+// ResponseCacheSetting chat/completions、responses 响应缓存配置
+type ResponseCacheSetting struct {
+	Enabled           bool     `json:"enabled"`
+	Groups            []string `json:"groups"`              // 启用缓存的分组，令牌也可单独启用
+	Storage           string   `json:"storage"`             // auto / redis / db，auto 时启用 Redis 则使用 Redis
+	TTLSeconds        int      `json:"ttl_seconds"`         // 缓存有效期
+	MaxEntryBytes     int      `json:"max_entry_bytes"`     // 单条响应最大缓存字节数，超过则不缓存
+	HitQuotaRatio     float64  `json:"hit_quota_ratio"`     // 命中时按正常费用的倍率计费，0 表示免费
+	ShareAcrossUsers  bool     `json:"share_across_users"`  // 不同用户之间共享缓存
+	SemanticEnabled   bool     `json:"semantic_enabled"`    // 启用语义匹配
+	SemanticThreshold float64  `json:"semantic_threshold"`  // 余弦相似度阈值
+	SemanticChannelId int      `json:"semantic_channel_id"` // 计算向量使用的渠道，需支持 embeddings 接口
+	SemanticModel     string   `json:"semantic_model"`      // 计算向量使用的模型
+	SemanticMaxScan   int      `json:"semantic_max_scan"`   // 语义匹配时最多比较的缓存条数
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:           false,
+	Groups:            []string{},
+	Storage:           ResponseCacheStorageAuto,
+	TTLSeconds:        3600,
+	MaxEntryBytes:     65536,
+	HitQuotaRatio:     0,
+	SemanticThreshold: 0.95,
+	SemanticModel:     "text-embedding-3-small",
+	SemanticMaxScan:   200,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache_setting", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
+
+// IsResponseCacheGroupEnabled 判断分组是否启用响应缓存
+func IsResponseCacheGroupEnabled(group string) bool {
+	return slices.Contains(responseCacheSetting.Groups, group)
+}
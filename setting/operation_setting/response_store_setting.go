@@ -0,0 +1,24 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ResponseStoreSetting 网关侧 /v1/responses 响应保存配置，仅用于经 chat/completions 转发的渠道
+type ResponseStoreSetting struct {
+	Enabled  bool `json:"enabled"`
+	TTLHours int  `json:"ttl_hours"` // 保存时长，过期后 previous_response_id 不再可用
+}
+
+// 默认配置
+var responseStoreSetting = ResponseStoreSetting{
+	Enabled:  true,
+	TTLHours: 720,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_store_setting", &responseStoreSetting)
+}
+
+func GetResponseStoreSetting() *ResponseStoreSetting {
+	return &responseStoreSetting
+}
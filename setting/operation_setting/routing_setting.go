@@ -0,0 +1,78 @@
+package operation_setting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// RoutingRule 按请求特征选择渠道的路由规则，按配置顺序匹配，命中第一条即停止
+type RoutingRule struct {
+	Name       string        `json:"name"`
+	Conditions []interface{} `json:"conditions"` // 与参数覆盖的 conditions 格式相同，请求特征以 ctx. 前缀引用，为空时匹配所有请求
+	Logic      string        `json:"logic"`      // AND / OR，默认 OR
+	Group      string        `json:"group"`      // 命中后改用的分组
+	Tag        string        `json:"tag"`        // 命中后只选择该标签的渠道
+	Model      string        `json:"model"`      // 命中后改用的模型，可以是虚拟模型
+}
+
+// RoutingSetting 基于请求内容的路由配置
+type RoutingSetting struct {
+	Enabled bool          `json:"enabled"`
+	Rules   []RoutingRule `json:"rules"`
+}
+
+// 默认配置
+var routingSetting = RoutingSetting{
+	Enabled: false,
+	Rules:   []RoutingRule{},
+}
+
+var routingConditionModes = []string{"full", "prefix", "suffix", "contains", "gt", "gte", "lt", "lte"}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("routing_setting", &routingSetting)
+}
+
+func GetRoutingSetting() *RoutingSetting {
+	return &routingSetting
+}
+
+// ValidateRoutingRules 校验路由规则 JSON
+func ValidateRoutingRules(jsonStr string) error {
+	var rules []RoutingRule
+	if err := common.UnmarshalJsonStr(jsonStr, &rules); err != nil {
+		return fmt.Errorf("路由规则格式错误: %w", err)
+	}
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		switch strings.ToUpper(rule.Logic) {
+		case "", "AND", "OR":
+		default:
+			return fmt.Errorf("路由规则 %s 的逻辑无效: %s", name, rule.Logic)
+		}
+		if rule.Group == "" && rule.Tag == "" && rule.Model == "" {
+			return fmt.Errorf("路由规则 %s 未配置分组、标签或模型", name)
+		}
+		for j, cond := range rule.Conditions {
+			condMap, ok := cond.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("路由规则 %s 的第 %d 个条件格式错误", name, j+1)
+			}
+			if path, _ := condMap["path"].(string); path == "" {
+				return fmt.Errorf("路由规则 %s 的第 %d 个条件未配置路径", name, j+1)
+			}
+			mode, _ := condMap["mode"].(string)
+			if !common.StringsContains(routingConditionModes, strings.ToLower(mode)) {
+				return fmt.Errorf("路由规则 %s 的第 %d 个条件模式无效: %s", name, j+1, mode)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// TaskCallbackSetting 异步任务完成回调配置
+type TaskCallbackSetting struct {
+	Enabled               bool `json:"enabled"`
+	MaxAttempts           int  `json:"max_attempts"`            // 最大投递次数，超过后标记为失败
+	InitialBackoffSeconds int  `json:"initial_backoff_seconds"` // 首次重试间隔，之后每次翻倍
+	MaxBackoffSeconds     int  `json:"max_backoff_seconds"`     // 重试间隔上限
+	TimeoutSeconds        int  `json:"timeout_seconds"`         // 单次投递超时
+	RetentionDays         int  `json:"retention_days"`          // 投递记录保留天数，0 表示永久保留
+}
+
+// 默认配置
+var taskCallbackSetting = TaskCallbackSetting{
+	Enabled:               true,
+	MaxAttempts:           8,
+	InitialBackoffSeconds: 30,
+	MaxBackoffSeconds:     3600,
+	TimeoutSeconds:        10,
+	RetentionDays:         30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("task_callback_setting", &taskCallbackSetting)
+}
+
+func GetTaskCallbackSetting() *TaskCallbackSetting {
+	return &taskCallbackSetting
+}
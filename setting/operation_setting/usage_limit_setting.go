@@ -0,0 +1,44 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// UsageLimit RPM / TPM / 并发限制，0 表示不限制
+type UsageLimit struct {
+	RPM         int `json:"rpm"`
+	TPM         int `json:"tpm"`
+	Concurrency int `json:"concurrency"`
+}
+
+func (l UsageLimit) IsZero() bool {
+	return l.RPM <= 0 && l.TPM <= 0 && l.Concurrency <= 0
+}
+
+// UsageLimitSetting 按令牌、用户、分组的用量限制配置
+type UsageLimitSetting struct {
+	Enabled               bool                  `json:"enabled"`
+	DefaultUserLimit      UsageLimit            `json:"default_user_limit"`      // 用户未单独设置时的默认限制
+	GroupLimits           map[string]UsageLimit `json:"group_limits"`            // 分组整体的限制，分组内所有用户共享计数
+	ConcurrencyTTLSeconds int                   `json:"concurrency_ttl_seconds"` // 并发计数最长保留时间，防止进程异常退出后无法释放
+}
+
+// 默认配置
+var usageLimitSetting = UsageLimitSetting{
+	Enabled:               false,
+	GroupLimits:           map[string]UsageLimit{},
+	ConcurrencyTTLSeconds: 600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("usage_limit_setting", &usageLimitSetting)
+}
+
+func GetUsageLimitSetting() *UsageLimitSetting {
+	return &usageLimitSetting
+}
+
+// GetGroupUsageLimit 获取分组的用量限制
+func GetGroupUsageLimit(group string) (UsageLimit, bool) {
+	limit, ok := usageLimitSetting.GroupLimits[group]
+	return limit, ok
+}
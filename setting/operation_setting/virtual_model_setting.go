@@ -0,0 +1,86 @@
+package operation_setting
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// VirtualModelStep 虚拟模型回退链中的一步
+type VirtualModelStep struct {
+	Model         string                 `json:"model"`
+	ParamOverride map[string]interface{} `json:"param_override,omitempty"` // 与渠道参数覆盖格式相同，叠加在渠道覆盖之后
+}
+
+// VirtualModel 管理员定义的虚拟模型，按顺序尝试各真实模型
+type VirtualModel struct {
+	Name  string             `json:"name"`
+	Steps []VirtualModelStep `json:"steps"`
+}
+
+// VirtualModelSetting 虚拟模型配置
+type VirtualModelSetting struct {
+	Enabled bool           `json:"enabled"`
+	Models  []VirtualModel `json:"models"`
+}
+
+// 默认配置
+var virtualModelSetting = VirtualModelSetting{
+	Enabled: false,
+	Models:  []VirtualModel{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("virtual_model_setting", &virtualModelSetting)
+}
+
+func GetVirtualModelSetting() *VirtualModelSetting {
+	return &virtualModelSetting
+}
+
+// GetVirtualModel 按名称查找虚拟模型，未启用或不存在时返回 nil
+func GetVirtualModel(name string) *VirtualModel {
+	if !virtualModelSetting.Enabled || name == "" {
+		return nil
+	}
+	for i := range virtualModelSetting.Models {
+		if virtualModelSetting.Models[i].Name == name {
+			return &virtualModelSetting.Models[i]
+		}
+	}
+	return nil
+}
+
+// ValidateVirtualModels 校验虚拟模型 JSON
+func ValidateVirtualModels(jsonStr string) error {
+	var models []VirtualModel
+	if err := common.UnmarshalJsonStr(jsonStr, &models); err != nil {
+		return fmt.Errorf("虚拟模型格式错误: %w", err)
+	}
+	names := make(map[string]bool, len(models))
+	for i, vm := range models {
+		if vm.Name == "" {
+			return fmt.Errorf("虚拟模型 #%d 未配置名称", i+1)
+		}
+		if names[vm.Name] {
+			return fmt.Errorf("虚拟模型 %s 重复", vm.Name)
+		}
+		names[vm.Name] = true
+	}
+	for _, vm := range models {
+		if len(vm.Steps) == 0 {
+			return fmt.Errorf("虚拟模型 %s 未配置回退模型", vm.Name)
+		}
+		for j, step := range vm.Steps {
+			if step.Model == "" {
+				return fmt.Errorf("虚拟模型 %s 的第 %d 步未配置模型", vm.Name, j+1)
+			}
+			if names[step.Model] {
+				return fmt.Errorf("虚拟模型 %s 不能引用虚拟模型 %s", vm.Name, step.Model)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package ratio_setting
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// 按上下文长度分级的倍率，模型名 -> 分级列表（按 MinPromptTokens 升序）
+var tieredRatioMap = map[string][]types.PriceTier{}
+var tieredRatioMapMutex sync.RWMutex
+
+func TieredRatio2JSONString() string {
+	tieredRatioMapMutex.RLock()
+	defer tieredRatioMapMutex.RUnlock()
+	jsonBytes, err := common.Marshal(tieredRatioMap)
+	if err != nil {
+		common.SysError("error marshalling tiered ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateTieredRatioByJSONString(jsonStr string) error {
+	newMap := make(map[string][]types.PriceTier)
+	if err := common.Unmarshal([]byte(jsonStr), &newMap); err != nil {
+		return err
+	}
+	for name, tiers := range newMap {
+		seen := make(map[int]bool, len(tiers))
+		for _, tier := range tiers {
+			if tier.MinPromptTokens < 0 || tier.ModelRatio < 0 || tier.CompletionRatio < 0 || tier.CacheRatio < 0 {
+				return fmt.Errorf("模型 %s 的分级倍率不能为负数", name)
+			}
+			if seen[tier.MinPromptTokens] {
+				return fmt.Errorf("模型 %s 存在重复的分级起点 %d", name, tier.MinPromptTokens)
+			}
+			seen[tier.MinPromptTokens] = true
+		}
+		sort.Slice(tiers, func(i, j int) bool {
+			return tiers[i].MinPromptTokens < tiers[j].MinPromptTokens
+		})
+	}
+	tieredRatioMapMutex.Lock()
+	tieredRatioMap = newMap
+	tieredRatioMapMutex.Unlock()
+	InvalidateExposedDataCache()
+	return nil
+}
+
+func getPriceTiers(name string) []types.PriceTier {
+	tieredRatioMapMutex.RLock()
+	defer tieredRatioMapMutex.RUnlock()
+	if tiers, ok := tieredRatioMap[name]; ok {
+		return tiers
+	}
+	return tieredRatioMap[FormatMatchingModelName(name)]
+}
+
+// GetPriceTier 返回提示词 token 数命中的最高分级
+func GetPriceTier(name string, promptTokens int) (types.PriceTier, bool) {
+	tiers := getPriceTiers(name)
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if promptTokens >= tiers[i].MinPromptTokens {
+			return tiers[i], true
+		}
+	}
+	return types.PriceTier{}, false
+}
+
+// ApplyPriceTier 按实际提示词 token 数重新选择分级并更新倍率
+func ApplyPriceTier(priceData *types.PriceData, name string, promptTokens int) {
+	if priceData.UsePrice || len(getPriceTiers(name)) == 0 {
+		return
+	}
+	modelRatio, _, _ := GetModelRatio(name)
+	completionRatio := GetCompletionRatio(name)
+	cacheRatio, _ := GetCacheRatio(name)
+	priceData.Tier = nil
+	if tier, ok := GetPriceTier(name, promptTokens); ok {
+		modelRatio, completionRatio, cacheRatio = tier.Apply(modelRatio, completionRatio, cacheRatio)
+		priceData.Tier = &tier
+	}
+	priceData.ModelRatio = modelRatio
+	priceData.CompletionRatio = completionRatio
+	priceData.CacheRatio = cacheRatio
+}
+
+func GetTieredRatioCopy() map[string][]types.PriceTier {
+	tieredRatioMapMutex.RLock()
+	defer tieredRatioMapMutex.RUnlock()
+	copyMap := make(map[string][]types.PriceTier, len(tieredRatioMap))
+	for k, v := range tieredRatioMap {
+		copyMap[k] = append([]types.PriceTier(nil), v...)
+	}
+	return copyMap
+}
@@ -0,0 +1,146 @@
+package ratio_setting
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setTieredRatioForTest(t *testing.T, jsonStr string) {
+	t.Helper()
+	original := TieredRatio2JSONString()
+	t.Cleanup(func() {
+		require.NoError(t, UpdateTieredRatioByJSONString(original))
+	})
+	require.NoError(t, UpdateTieredRatioByJSONString(jsonStr))
+}
+
+func TestUpdateTieredRatioByJSONString(t *testing.T) {
+	tests := []struct {
+		name    string
+		jsonStr string
+		wantErr bool
+	}{
+		{name: "empty", jsonStr: `{}`},
+		{name: "valid", jsonStr: `{"m":[{"min_prompt_tokens":0},{"min_prompt_tokens":200000,"model_ratio":2}]}`},
+		{name: "invalid json", jsonStr: `{"m":`, wantErr: true},
+		{name: "negative start", jsonStr: `{"m":[{"min_prompt_tokens":-1}]}`, wantErr: true},
+		{name: "negative ratio", jsonStr: `{"m":[{"min_prompt_tokens":0,"cache_ratio":-1}]}`, wantErr: true},
+		{name: "duplicate start", jsonStr: `{"m":[{"min_prompt_tokens":10},{"min_prompt_tokens":10}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTieredRatioForTest(t, `{}`)
+			err := UpdateTieredRatioByJSONString(tt.jsonStr)
+			require.Equal(t, tt.wantErr, err != nil, "err: %v", err)
+		})
+	}
+}
+
+func TestGetPriceTier(t *testing.T) {
+	// 故意乱序，加载时应按起点排序
+	setTieredRatioForTest(t, `{"tier-model":[
+		{"min_prompt_tokens":200000,"model_ratio":3},
+		{"min_prompt_tokens":32000,"model_ratio":2},
+		{"min_prompt_tokens":1000,"model_ratio":1.5}
+	]}`)
+	tests := []struct {
+		name         string
+		model        string
+		promptTokens int
+		wantOk       bool
+		wantMin      int
+	}{
+		{name: "below first tier", model: "tier-model", promptTokens: 999},
+		{name: "first tier boundary", model: "tier-model", promptTokens: 1000, wantOk: true, wantMin: 1000},
+		{name: "middle tier", model: "tier-model", promptTokens: 100000, wantOk: true, wantMin: 32000},
+		{name: "highest tier boundary", model: "tier-model", promptTokens: 200000, wantOk: true, wantMin: 200000},
+		{name: "above highest tier", model: "tier-model", promptTokens: 1000000, wantOk: true, wantMin: 200000},
+		{name: "unknown model", model: "other-model", promptTokens: 100000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier, ok := GetPriceTier(tt.model, tt.promptTokens)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.wantMin, tier.MinPromptTokens)
+		})
+	}
+}
+
+func TestApplyPriceTier(t *testing.T) {
+	setTieredRatioForTest(t, `{"tier-model":[
+		{"min_prompt_tokens":1000,"model_ratio":2},
+		{"min_prompt_tokens":5000,"completion_ratio":8,"cache_ratio":0.5}
+	]}`)
+	baseModelRatio, _, _ := GetModelRatio("tier-model")
+	baseCompletionRatio := GetCompletionRatio("tier-model")
+	baseCacheRatio, _ := GetCacheRatio("tier-model")
+
+	tests := []struct {
+		name                string
+		usePrice            bool
+		promptTokens        int
+		wantTier            int // 0 表示未命中
+		wantModelRatio      float64
+		wantCompletionRatio float64
+		wantCacheRatio      float64
+	}{
+		{
+			name:                "no tier hit falls back to defaults",
+			promptTokens:        10,
+			wantModelRatio:      baseModelRatio,
+			wantCompletionRatio: baseCompletionRatio,
+			wantCacheRatio:      baseCacheRatio,
+		},
+		{
+			name:                "tier overrides model ratio only",
+			promptTokens:        1000,
+			wantTier:            1000,
+			wantModelRatio:      2,
+			wantCompletionRatio: baseCompletionRatio,
+			wantCacheRatio:      baseCacheRatio,
+		},
+		{
+			name:                "zero ratios keep defaults",
+			promptTokens:        6000,
+			wantTier:            5000,
+			wantModelRatio:      baseModelRatio,
+			wantCompletionRatio: 8,
+			wantCacheRatio:      0.5,
+		},
+		{
+			name:                "fixed price is untouched",
+			usePrice:            true,
+			promptTokens:        6000,
+			wantModelRatio:      7,
+			wantCompletionRatio: 7,
+			wantCacheRatio:      7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priceData := &types.PriceData{
+				UsePrice:        tt.usePrice,
+				ModelRatio:      7,
+				CompletionRatio: 7,
+				CacheRatio:      7,
+				Tier:            &types.PriceTier{MinPromptTokens: -1},
+			}
+			ApplyPriceTier(priceData, "tier-model", tt.promptTokens)
+			require.Equal(t, tt.wantModelRatio, priceData.ModelRatio)
+			require.Equal(t, tt.wantCompletionRatio, priceData.CompletionRatio)
+			require.Equal(t, tt.wantCacheRatio, priceData.CacheRatio)
+			switch {
+			case tt.usePrice:
+				require.Equal(t, -1, priceData.Tier.MinPromptTokens)
+			case tt.wantTier == 0:
+				require.Nil(t, priceData.Tier)
+			default:
+				require.NotNil(t, priceData.Tier)
+				require.Equal(t, tt.wantTier, priceData.Tier.MinPromptTokens)
+			}
+		})
+	}
+}
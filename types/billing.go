@@ -0,0 +1,31 @@
+package types
+
+// BillingCharge 已扣额度在订阅套餐额度与余额之间的拆分，退款时按来源退回
+type BillingCharge struct {
+	Subscription int // 从套餐额度扣除的部分
+	Balance      int // 从余额（或组织、项目额度）扣除的部分
+}
+
+func (c BillingCharge) Total() int {
+	return c.Subscription + c.Balance
+}
+
+func (c *BillingCharge) Add(other BillingCharge) {
+	c.Subscription += other.Subscription
+	c.Balance += other.Balance
+}
+
+func (c *BillingCharge) Sub(other BillingCharge) {
+	c.Subscription -= other.Subscription
+	c.Balance -= other.Balance
+}
+
+// Refund 拆分退还的额度：余额在套餐额度之后扣除，因此先退余额部分，再退套餐部分，超出已扣部分的退回余额
+func (c BillingCharge) Refund(quota int) BillingCharge {
+	balance := min(quota, max(c.Balance, 0))
+	subscription := min(quota-balance, max(c.Subscription, 0))
+	return BillingCharge{
+		Subscription: subscription,
+		Balance:      quota - subscription,
+	}
+}
@@ -40,6 +40,9 @@ type ErrorCode string
 const (
 	ErrorCodeInvalidRequest         ErrorCode = "invalid_request"
 	ErrorCodeSensitiveWordsDetected ErrorCode = "sensitive_words_detected"
+	ErrorCodeGuardrailBlocked       ErrorCode = "guardrail_blocked"
+	ErrorCodeContextLengthExceeded  ErrorCode = "context_length_exceeded"
+	ErrorCodeUnsupportedCapability  ErrorCode = "unsupported_capability"
 
 	// Knight Omega error
 	ErrorCodeCountTokenFailed   ErrorCode = "count_token_failed"
@@ -84,6 +87,9 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+
+	// rate limit error
+	ErrorCodeRateLimitExceeded ErrorCode = "rate_limit_exceeded"
 )
 
 type NewAPIError struct {
@@ -24,6 +24,29 @@ type PriceData struct {
 	UsePrice             bool
 	QuotaToPreConsume    int // 预消耗额度
 	GroupRatioInfo       GroupRatioInfo
+	Tier                 *PriceTier // 命中的上下文长度分级，nil 表示未分级
+}
+
+// PriceTier 提示词 token 数达到 MinPromptTokens 时生效的倍率，倍率为 0 表示沿用模型默认倍率
+type PriceTier struct {
+	MinPromptTokens int     `json:"min_prompt_tokens"`
+	ModelRatio      float64 `json:"model_ratio,omitempty"`
+	CompletionRatio float64 `json:"completion_ratio,omitempty"`
+	CacheRatio      float64 `json:"cache_ratio,omitempty"`
+}
+
+// Apply 用分级倍率覆盖默认的输入、输出、缓存倍率
+func (t PriceTier) Apply(modelRatio, completionRatio, cacheRatio float64) (float64, float64, float64) {
+	if t.ModelRatio > 0 {
+		modelRatio = t.ModelRatio
+	}
+	if t.CompletionRatio > 0 {
+		completionRatio = t.CompletionRatio
+	}
+	if t.CacheRatio > 0 {
+		cacheRatio = t.CacheRatio
+	}
+	return modelRatio, completionRatio, cacheRatio
 }
 
 func (p *PriceData) AddOtherRatio(key string, ratio float64) {
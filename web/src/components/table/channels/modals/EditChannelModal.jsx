@@ -137,6 +137,7 @@ const EditChannelModal = (props) => {
     thinking_to_content: false,
     proxy: '',
     pass_through_body_enabled: false,
+    propagate_trace_context: false,
     system_prompt: '',
     system_prompt_override: false,
     settings: '',
@@ -356,6 +357,7 @@ const EditChannelModal = (props) => {
     thinking_to_content: false,
     proxy: '',
     pass_through_body_enabled: false,
+    propagate_trace_context: false,
     system_prompt: '',
   });
   const showApiConfigCard = true; // 控制是否显示 API 配置卡片
@@ -564,6 +566,8 @@ const EditChannelModal = (props) => {
           data.proxy = parsedSettings.proxy || '';
           data.pass_through_body_enabled =
             parsedSettings.pass_through_body_enabled || false;
+          data.propagate_trace_context =
+            parsedSettings.propagate_trace_context || false;
           data.system_prompt = parsedSettings.system_prompt || '';
           data.system_prompt_override =
             parsedSettings.system_prompt_override || false;
@@ -573,6 +577,7 @@ const EditChannelModal = (props) => {
           data.thinking_to_content = false;
           data.proxy = '';
           data.pass_through_body_enabled = false;
+          data.propagate_trace_context = false;
           data.system_prompt = '';
           data.system_prompt_override = false;
         }
@@ -581,6 +586,7 @@ const EditChannelModal = (props) => {
         data.thinking_to_content = false;
         data.proxy = '';
         data.pass_through_body_enabled = false;
+        data.propagate_trace_context = false;
         data.system_prompt = '';
         data.system_prompt_override = false;
       }
@@ -649,6 +655,7 @@ const EditChannelModal = (props) => {
         thinking_to_content: data.thinking_to_content,
         proxy: data.proxy,
         pass_through_body_enabled: data.pass_through_body_enabled,
+        propagate_trace_context: data.propagate_trace_context || false,
         system_prompt: data.system_prompt,
         system_prompt_override: data.system_prompt_override || false,
       });
@@ -955,6 +962,7 @@ const EditChannelModal = (props) => {
       thinking_to_content: false,
       proxy: '',
       pass_through_body_enabled: false,
+      propagate_trace_context: false,
       system_prompt: '',
       system_prompt_override: false,
     });
@@ -1282,6 +1290,7 @@ const EditChannelModal = (props) => {
       thinking_to_content: localInputs.thinking_to_content || false,
       proxy: localInputs.proxy || '',
       pass_through_body_enabled: localInputs.pass_through_body_enabled || false,
+      propagate_trace_context: localInputs.propagate_trace_context || false,
       system_prompt: localInputs.system_prompt || '',
       system_prompt_override: localInputs.system_prompt_override || false,
     };
@@ -1333,6 +1342,7 @@ const EditChannelModal = (props) => {
     delete localInputs.thinking_to_content;
     delete localInputs.proxy;
     delete localInputs.pass_through_body_enabled;
+    delete localInputs.propagate_trace_context;
     delete localInputs.system_prompt;
     delete localInputs.system_prompt_override;
     delete localInputs.is_enterprise_account;
@@ -3215,6 +3225,22 @@ const EditChannelModal = (props) => {
                       extraText={t('启用请求体透传功能')}
                     />
 
+                    <Form.Switch
+                      field='propagate_trace_context'
+                      label={t('透传链路追踪')}
+                      checkedText={t('开')}
+                      uncheckedText={t('关')}
+                      onChange={(value) =>
+                        handleChannelSettingsChange(
+                          'propagate_trace_context',
+                          value,
+                        )
+                      }
+                      extraText={t(
+                        '启用 OpenTelemetry 后，向上游请求添加 traceparent 请求头',
+                      )}
+                    />
+
                     <Form.Input
                       field='proxy'
                       label={t('代理地址')}
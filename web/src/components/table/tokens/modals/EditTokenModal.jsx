@@ -43,6 +43,7 @@ const EditTokenModal = (props) => {
   const [loading, setLoading] = useState(false);
   const isMobile = useIsMobile();
   const formApiRef = useRef(null);
+  const originalRemainQuotaRef = useRef(0);
   const [models, setModels] = useState([]);
   const [groups, setGroups] = useState([]);
   const isEdit = props.editingToken.id !== undefined;
@@ -145,6 +146,7 @@ const EditTokenModal = (props) => {
       } else {
         data.model_limits = [];
       }
+      originalRemainQuotaRef.current = data.remain_quota;
       if (formApiRef.current) {
         formApiRef.current.setValues({ ...getInitValues(), ...data });
       }
@@ -207,6 +209,8 @@ const EditTokenModal = (props) => {
       let res = await API.put(`/api/token/`, {
         ...localInputs,
         id: parseInt(props.editingToken.id),
+        // 后端按与打开时剩余额度的差值调整，避免覆盖编辑期间的消费
+        original_remain_quota: originalRemainQuotaRef.current,
       });
       const { success, message } = res.data;
       if (success) {
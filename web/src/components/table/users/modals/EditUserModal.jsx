@@ -46,6 +46,7 @@ const EditUserModal = (props) => {
   const isMobile = useIsMobile();
   const [groupOptions, setGroupOptions] = useState([]);
   const formApiRef = useRef(null);
+  const originalQuotaRef = useRef(0);
 
   const isEdit = Boolean(userId);
 
@@ -82,6 +83,7 @@ const EditUserModal = (props) => {
     const { success, message, data } = res.data;
     if (success) {
       data.password = '';
+      originalQuotaRef.current = data.quota;
       formApiRef.current?.setValues({ ...getInitValues(), ...data });
     } else {
       showError(message);
@@ -102,6 +104,8 @@ const EditUserModal = (props) => {
       payload.quota = parseInt(payload.quota) || 0;
     if (userId) {
       payload.id = parseInt(userId);
+      // 后端按与打开时额度的差值调整，避免覆盖编辑期间的消费
+      payload.original_quota = originalQuotaRef.current;
     }
     const url = userId ? `/api/user/` : `/api/user/self`;
     const res = await API.put(url, payload);